@@ -0,0 +1,398 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+
+	"web-chatbot-backend/internal/bookmarks"
+	"web-chatbot-backend/internal/changelog"
+	"web-chatbot-backend/internal/delivery"
+	"web-chatbot-backend/internal/page"
+	"web-chatbot-backend/internal/rules"
+	"web-chatbot-backend/internal/session"
+	"web-chatbot-backend/internal/visitor"
+)
+
+// requireAdmin checks the bearer token on admin requests: the shared admin
+// token, or an operator's own token, which signs the request in as them.
+// The admin API is disabled entirely when no admin token is configured.
+func requireAdmin(c *fiber.Ctx) error {
+	if serverConfig.AdminToken == "" {
+		return c.Status(403).JSON(fiber.Map{"error": "Admin API is disabled"})
+	}
+	token := strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
+	if token == "" && (websocket.IsWebSocketUpgrade(c) || c.Get("Accept") == "text/event-stream") {
+		// Browsers cannot set headers on WebSocket or EventSource requests
+		token = c.Query("access_token")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(serverConfig.AdminToken)) == 1 {
+		return c.Next()
+	}
+	op, err := adminOperators.Authenticate(token)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	c.Locals("operator", op.Name)
+	return c.Next()
+}
+
+func registerAdminRoutes(app *fiber.App) {
+	admin := app.Group("/admin/v1", requireAdmin)
+
+	admin.Get("/visitors", func(c *fiber.Ctx) error {
+		return paginate(c, "visitors", visitors.List())
+	})
+
+	admin.Get("/visitors/:id", conditional, func(c *fiber.Ctx) error {
+		profile, err := visitors.Get(c.Params("id"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(profile)
+	})
+
+	// Edit operator notes and ban status
+	admin.Patch("/visitors/:id", func(c *fiber.Ctx) error {
+		var update visitor.Update
+		if err := c.BodyParser(&update); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		profile, err := visitors.Update(c.Params("id"), update)
+		if errors.Is(err, visitor.ErrNotFound) {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(profile)
+	})
+
+	admin.Get("/health", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"ready": upstreams.Healthy(defaultTenant), "upstreams": upstreams.Statuses()})
+	})
+
+	// Follow-up requests collected in degraded mode
+	admin.Get("/followups", func(c *fiber.Ctx) error {
+		return paginate(c, "followups", degradedMode.FollowUps())
+	})
+
+	admin.Get("/actions", conditional, func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"actions": actionRegistry.Names()})
+	})
+
+	admin.Get("/hooks", conditional, func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"hooks": pipelineHooks.Registered(), "wasm_modules": wasmHost.Modules()})
+	})
+
+	// Auto-responder rules
+	admin.Get("/rules", conditional, func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"rules": autoResponder.List()})
+	})
+
+	admin.Post("/rules", func(c *fiber.Ctx) error {
+		var rule rules.Rule
+		if err := c.BodyParser(&rule); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		rule.ID = ""
+		saved, err := autoResponder.Put(rule)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		recordChange(c, "rule", changelog.ActionCreate, saved.ID, nil, saved)
+		return c.Status(201).JSON(saved)
+	})
+
+	admin.Put("/rules/:id", func(c *fiber.Ctx) error {
+		var rule rules.Rule
+		if err := c.BodyParser(&rule); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		rule.ID = c.Params("id")
+		before, _ := autoResponder.Get(rule.ID)
+		saved, err := autoResponder.Put(rule)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		action := changelog.ActionUpdate
+		if before == nil {
+			action = changelog.ActionCreate
+		}
+		recordChange(c, "rule", action, saved.ID, before, saved)
+		return c.JSON(saved)
+	})
+
+	admin.Delete("/rules/:id", func(c *fiber.Ctx) error {
+		before, _ := autoResponder.Get(c.Params("id"))
+		if err := autoResponder.Delete(c.Params("id")); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		recordChange(c, "rule", changelog.ActionDelete, c.Params("id"), before, nil)
+		return c.SendStatus(204)
+	})
+
+	admin.Get("/analytics/rules", func(c *fiber.Ctx) error {
+		return paginate(c, "rules", autoResponder.Stats())
+	})
+
+	// Where visitors connect from, by country and city
+	admin.Get("/analytics/geography", func(c *fiber.Ctx) error {
+		list := sessions.List(func(s *session.Session) bool { return s.Location != nil && !s.Test })
+		return c.JSON(fiber.Map{
+			"countries": breakdown(list, func(s *session.Session) string { return s.Location.Country }),
+			"cities": breakdown(list, func(s *session.Session) string {
+				if s.Location.City == "" {
+					return ""
+				}
+				return s.Location.City + ", " + s.Location.CountryCode
+			}),
+		})
+	})
+
+	// Which devices and browsers visitors use
+	admin.Get("/analytics/devices", func(c *fiber.Ctx) error {
+		list := sessions.List(func(s *session.Session) bool { return s.Device != nil && !s.Test })
+		return c.JSON(fiber.Map{
+			"types":    breakdown(list, func(s *session.Session) string { return s.Device.Type }),
+			"os":       breakdown(list, func(s *session.Session) string { return s.Device.OS }),
+			"browsers": breakdown(list, func(s *session.Session) string { return s.Device.Browser }),
+		})
+	})
+
+	registerExportRoutes(admin)
+	registerAnnotationRoutes(admin)
+	registerKnowledgeRoutes(admin)
+	registerLatencyRoutes(admin)
+	registerTopQuestionRoutes(admin)
+	registerDeadLetterRoutes(admin)
+	registerTranscriptWebhookRoutes(admin)
+
+	if agentPush != nil {
+		registerAgentDeviceRoutes(admin)
+	}
+	registerCallRoutes(admin)
+	registerAgentRoutes(admin)
+	registerSLARoutes(admin)
+	registerStreamRoutes(admin)
+	registerPresenceRoutes(admin)
+	registerGreetingRoutes(admin)
+	registerTestChatRoutes(admin)
+	registerReminderAdminRoutes(admin)
+	registerJobQueueRoutes(admin)
+	registerBackupRoutes(admin)
+	registerAPIKeyRoutes(admin)
+	registerSettingsRoutes(admin)
+	registerTwoFactorRoutes(admin)
+	registerOperatorRoutes(admin)
+	registerMergeAdminRoutes(admin)
+	registerShareAdminRoutes(admin)
+	registerPreviewAdminRoutes(admin)
+	registerTenantRoutes(admin)
+	registerDomainRoutes(admin)
+	if translator != nil {
+		registerTranslationRoutes(admin)
+	}
+	registerDraftRoutes(admin)
+	registerRoutingRoutes(admin)
+	registerCacheRoutes(admin)
+	registerLoggingRoutes(admin)
+	registerPreflightRoutes(admin)
+
+	// Agents claim escalated conversations from the queue
+	admin.Post("/sessions/:id/claim", func(c *fiber.Ctx) error {
+		var body struct {
+			Agent string `json:"agent"`
+		}
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&body); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+			}
+		}
+		if err := sessions.Claim(c.Params("id"), body.Agent); err != nil {
+			if errors.Is(err, session.ErrNotFound) {
+				return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"status": session.StatusWithAgent})
+	})
+
+	// Agent replies reach the visitor live, or by push if they have left
+	admin.Post("/sessions/:id/messages", func(c *fiber.Ctx) error {
+		var body struct {
+			Agent string `json:"agent"`
+			Text  string `json:"text"`
+		}
+		if err := c.BodyParser(&body); err != nil || body.Text == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "text is required"})
+		}
+		sess, err := sessions.Get(c.Params("id"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := deliverAgentMessage(sess, body.Agent, body.Text); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.SendStatus(204)
+	})
+
+	// Pinned conversations and bookmarked messages for QA review
+	admin.Get("/pins", func(c *fiber.Ctx) error {
+		return paginate(c, "pins", reviewMarks.Pins())
+	})
+
+	admin.Put("/sessions/:id/pin", func(c *fiber.Ctx) error {
+		var body struct {
+			By   string `json:"by"`
+			Note string `json:"note"`
+		}
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&body); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+			}
+		}
+		if _, err := sessions.Get(c.Params("id")); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		pin, err := reviewMarks.Pin(c.Params("id"), body.By, body.Note)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(pin)
+	})
+
+	admin.Delete("/sessions/:id/pin", func(c *fiber.Ctx) error {
+		if err := reviewMarks.Unpin(c.Params("id")); err != nil {
+			if errors.Is(err, bookmarks.ErrNotFound) {
+				return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.SendStatus(204)
+	})
+
+	admin.Get("/bookmarks", func(c *fiber.Ctx) error {
+		return paginate(c, "bookmarks", reviewMarks.Bookmarks(c.Query("session_id")))
+	})
+
+	// Bookmark a message by its position in the transcript
+	admin.Post("/sessions/:id/bookmarks", func(c *fiber.Ctx) error {
+		var body struct {
+			Index *int   `json:"index"`
+			By    string `json:"by"`
+			Note  string `json:"note"`
+		}
+		if err := c.BodyParser(&body); err != nil || body.Index == nil {
+			return c.Status(400).JSON(fiber.Map{"error": "index is required"})
+		}
+		history, err := sessions.History(c.Params("id"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		if *body.Index < 0 || *body.Index >= len(history) {
+			return c.Status(400).JSON(fiber.Map{"error": "index is outside the transcript"})
+		}
+		b, err := reviewMarks.Add(c.Params("id"), history[*body.Index], body.By, body.Note)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(201).JSON(b)
+	})
+
+	admin.Delete("/bookmarks/:id", func(c *fiber.Ctx) error {
+		if err := reviewMarks.Remove(c.Params("id")); err != nil {
+			if errors.Is(err, bookmarks.ErrNotFound) {
+				return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.SendStatus(204)
+	})
+
+	// Sessions, newest first, optionally filtered by status, visitor or tag
+	admin.Get("/sessions", func(c *fiber.Ctx) error {
+		filter := sessionFilter{
+			Status:    session.Status(c.Query("status")),
+			VisitorID: c.Query("visitor_id"),
+			Tag:       c.Query("tag"),
+		}
+		match, err := filter.match()
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		list := sessions.List(match)
+		sort.Slice(list, func(i, j int) bool {
+			if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+				return list[i].CreatedAt.After(list[j].CreatedAt)
+			}
+			return list[i].ID < list[j].ID
+		})
+		return paginate(c, "sessions", list)
+	})
+
+	admin.Get("/sessions/:id/transcript", conditional, func(c *fiber.Ctx) error {
+		history, err := sessions.History(c.Params("id"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return paginate(c, "messages", history)
+	})
+
+	// Outbound event webhook receipts
+	// Bulk operations run as background jobs
+	admin.Post("/jobs", startBulkJob)
+
+	admin.Get("/jobs", func(c *fiber.Ctx) error {
+		return paginate(c, "jobs", bulkJobs.List())
+	})
+
+	admin.Get("/jobs/:id", func(c *fiber.Ctx) error {
+		job, err := bulkJobs.Get(c.Params("id"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(job)
+	})
+
+	admin.Get("/deliveries", func(c *fiber.Ctx) error {
+		list := deliveries.List(delivery.Status(c.Query("status")), c.Query("event_id"))
+		return paginate(c, "deliveries", list)
+	})
+
+	admin.Get("/deliveries/:id", func(c *fiber.Ctx) error {
+		del, err := deliveries.Get(c.Params("id"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(del)
+	})
+
+	admin.Post("/deliveries/:id/redeliver", func(c *fiber.Ctx) error {
+		del, err := deliveries.Redeliver(c.Params("id"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(202).JSON(del)
+	})
+}
+
+// paginate responds with one page of items under key, plus the
+// next_cursor, has_more and total hints. Clients pass ?cursor= and
+// ?limit= to page through.
+func paginate[T any](c *fiber.Ctx, key string, items []T) error {
+	req, err := page.Parse(c.Query("cursor"), c.QueryInt("limit"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	items, info := page.Apply(items, req)
+	resp := fiber.Map{key: items, "has_more": info.HasMore, "total": info.Total}
+	if info.NextCursor != "" {
+		resp["next_cursor"] = info.NextCursor
+	}
+	return c.JSON(resp)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/agentpush"
+	"web-chatbot-backend/internal/events"
+	"web-chatbot-backend/internal/session"
+	"web-chatbot-backend/internal/visitor"
+	"web-chatbot-backend/internal/webpush"
+)
+
+// deliverAgentMessage records an agent's reply and sends it to the
+// visitor. If they have left the page, they get a push notification
+// instead so they know to come back.
+func deliverAgentMessage(sess *session.Session, agent, text string) error {
+	msg, err := sessions.AppendMessage(sess.ID, session.RoleAgent, text)
+	if err != nil {
+		return err
+	}
+	bus.Publish(events.Event{Type: "agent_message", SessionID: sess.ID, Data: map[string]any{"agent": agent}})
+	err = visitorHub.SendTo(sess.ID, fiber.Map{"type": "agent", "agent": agent, "message": text, "message_id": msg.ID})
+	if err == nil {
+		sendUnread(sess.ID)
+		return nil
+	}
+	if err != errNotConnected {
+		log.Warn().Err(err).Msg("write error")
+	}
+	title := "New reply"
+	if agent != "" {
+		title = "New reply from " + agent
+	}
+	pushToVisitor(sess.VisitorID, webpush.Notification{Title: title, Body: text, URL: serverConfig.WebPush.ClickURL, Tag: sess.ID})
+	return nil
+}
+
+// relayToAgent records a visitor message in a conversation an agent is
+// handling and passes it to the agent console, with suggested replies if
+// agent assist is on.
+func relayToAgent(ctx context.Context, sess *session.Session, profile *visitor.Profile, text string) {
+	recordVisitorMessage(ctx, sess.ID, text)
+	err := agentHub.SendTo(sess.ID, fiber.Map{"type": "visitor", "message": text})
+	if err == errNotConnected {
+		return
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("write error")
+	}
+	if serverConfig.AgentAssist {
+		go suggestReply(sess, profile, text)
+	}
+}
+
+// suggestReply asks the bot how it would answer and shows the answer to
+// the agent only. Actions the bot asks for are not run, since the agent
+// has not approved anything yet.
+func suggestReply(sess *session.Session, profile *visitor.Profile, text string) {
+	payload := webhookPayload(text, profile, sess)
+	payload["mode"] = "agent_assist"
+	payload["agent"] = sess.Agent
+	reply, err := askBot(context.Background(), payload)
+	if err != nil {
+		log.Error().Str("session_id", sess.ID).Err(err).Msg("Agent assist for session failed")
+		return
+	}
+	if reply.Text == "" {
+		return
+	}
+	frame := fiber.Map{"type": "suggestion", "message": reply.Text}
+	if len(reply.QuickReplies) > 0 {
+		frame["quick_replies"] = reply.QuickReplies
+	}
+	if err := agentHub.Post(sess.ID, frame); err != nil {
+		log.Warn().Err(err).Msg("write error")
+	}
+}
+
+// notifyAgentsOfQueue pushes newly queued conversations to every agent's
+// mobile app.
+func notifyAgentsOfQueue(e events.Event) {
+	if e.Type != "session_"+string(session.StatusWaitingAgent) {
+		return
+	}
+	msg := agentpush.Message{
+		Kind:  agentpush.KindQueued,
+		Title: "New conversation waiting",
+		Body:  lastVisitorMessage(e.SessionID),
+		Data:  map[string]string{"session_id": e.SessionID},
+	}
+	for _, agent := range agentPush.Agents() {
+		go agentPush.Notify(context.Background(), agent, msg)
+	}
+}
+
+// notifyAgentOfReply pushes a visitor message to the agent handling the
+// conversation.
+func notifyAgentOfReply(sessionID, text string) {
+	if agentPush == nil {
+		return
+	}
+	sess, err := sessions.Get(sessionID)
+	if err != nil || sess.Status != session.StatusWithAgent || sess.Agent == "" {
+		return
+	}
+	go agentPush.Notify(context.Background(), sess.Agent, agentpush.Message{
+		Kind:  agentpush.KindVisitorReply,
+		Title: "Visitor replied",
+		Body:  text,
+		Data:  map[string]string{"session_id": sessionID},
+	})
+}
+
+// lastVisitorMessage returns the latest visitor message of a session.
+func lastVisitorMessage(id string) string {
+	history, _ := sessions.History(id)
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Role == session.RoleVisitor {
+			return history[i].Text
+		}
+	}
+	return ""
+}
+
+// registerAgentDeviceRoutes lets the agent app register for pushes.
+func registerAgentDeviceRoutes(admin fiber.Router) {
+	admin.Get("/agents/:agent/devices", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"devices": agentPush.Devices(c.Params("agent"))})
+	})
+
+	admin.Post("/agents/:agent/devices", func(c *fiber.Ctx) error {
+		var d agentpush.Device
+		if err := c.BodyParser(&d); err != nil || d.Token == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "platform and token are required"})
+		}
+		if err := agentPush.Register(c.Params("agent"), d); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.SendStatus(201)
+	})
+
+	admin.Delete("/agents/:agent/devices/:token", func(c *fiber.Ctx) error {
+		if err := agentPush.Unregister(c.Params("agent"), c.Params("token")); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.SendStatus(204)
+	})
+
+	admin.Get("/agents/:agent/notifications", func(c *fiber.Ctx) error {
+		return c.JSON(agentPush.Preferences(c.Params("agent")))
+	})
+
+	admin.Put("/agents/:agent/notifications", func(c *fiber.Ctx) error {
+		p := agentPush.Preferences(c.Params("agent"))
+		if err := c.BodyParser(&p); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		if err := agentPush.SetPreferences(c.Params("agent"), p); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(p)
+	})
+}
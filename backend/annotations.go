@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/annotations"
+)
+
+// Labels reviewers put on messages, for training and evaluating the
+// workflow
+var messageAnnotations *annotations.Store
+
+// annotationFilter reads the filters of the annotation list and export.
+func annotationFilter(c *fiber.Ctx) (annotations.Filter, error) {
+	f := annotations.Filter{
+		SessionID: c.Query("session_id"),
+		Reviewer:  c.Query("reviewer"),
+		Intent:    c.Query("intent"),
+		Tag:       c.Query("tag"),
+	}
+	for name, dst := range map[string]*int{"min_quality": &f.MinQuality, "max_quality": &f.MaxQuality} {
+		v := c.Query(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < annotations.MinQuality || n > annotations.MaxQuality {
+			return f, errors.New(name + " must be between 1 and 5")
+		}
+		*dst = n
+	}
+	return f, nil
+}
+
+// labelRecord is one line of the label export: the message, what it
+// answered, and the labels.
+type labelRecord struct {
+	ID         string    `json:"id"`
+	SessionID  string    `json:"session_id"`
+	Index      int       `json:"index"`
+	Role       string    `json:"role"`
+	Text       string    `json:"text"`
+	Prompt     string    `json:"prompt,omitempty"`
+	Intent     string    `json:"intent,omitempty"`
+	Quality    *int      `json:"quality,omitempty"`
+	Correction string    `json:"correction,omitempty"`
+	Tags       []string  `json:"tags,omitempty"`
+	Note       string    `json:"note,omitempty"`
+	Reviewer   string    `json:"reviewer,omitempty"`
+	LabeledAt  time.Time `json:"labeled_at"`
+}
+
+// registerAnnotationRoutes lets reviewers label messages with the
+// visitor's intent, a quality score and the answer the bot should have
+// given, and exports the labels.
+func registerAnnotationRoutes(admin fiber.Router) {
+	// Labels a message by its position in the transcript. Each reviewer has
+	// one annotation per message, which a second PUT replaces.
+	admin.Put("/sessions/:id/messages/:index/annotation", func(c *fiber.Ctx) error {
+		var body struct {
+			annotations.Labels
+			By string `json:"by"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		index, err := c.ParamsInt("index")
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "index must be a number"})
+		}
+		history, err := sessions.History(c.Params("id"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		if index < 0 || index >= len(history) {
+			return c.Status(400).JSON(fiber.Map{"error": "index is outside the transcript"})
+		}
+		reviewer := changedBy(c)
+		if reviewer == "" {
+			reviewer = body.By
+		}
+		a, created, err := messageAnnotations.Put(c.Params("id"), index, history, reviewer, body.Labels)
+		switch {
+		case errors.Is(err, annotations.ErrEmpty), errors.Is(err, annotations.ErrQuality), errors.Is(err, annotations.ErrCorrectionRole):
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		case err != nil:
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		suggestKnowledge(a)
+		if created {
+			return c.Status(201).JSON(a)
+		}
+		return c.JSON(a)
+	})
+
+	admin.Get("/sessions/:id/annotations", func(c *fiber.Ctx) error {
+		return paginate(c, "annotations", messageAnnotations.List(annotations.Filter{SessionID: c.Params("id")}))
+	})
+
+	admin.Get("/annotations", func(c *fiber.Ctx) error {
+		f, err := annotationFilter(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return paginate(c, "annotations", messageAnnotations.List(f))
+	})
+
+	// The labels as JSON lines, one labeled message per line, with the same
+	// filters as the list
+	admin.Get("/annotations/export", func(c *fiber.Ctx) error {
+		f, err := annotationFilter(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		list := messageAnnotations.List(f)
+
+		log.Info().Int("annotations", len(list)).Str("by", changedBy(c)).Msg("Exporting labels")
+		c.Set("Content-Type", "application/x-ndjson")
+		c.Set("Content-Disposition", `attachment; filename="annotations.jsonl"`)
+		streamBody(c, func(w *bufio.Writer) {
+			enc := json.NewEncoder(w)
+			for _, a := range list {
+				record := labelRecord{
+					ID:         a.ID,
+					SessionID:  a.SessionID,
+					Index:      a.Index,
+					Role:       a.Message.Role,
+					Text:       a.Message.Text,
+					Prompt:     a.Prompt,
+					Intent:     a.Intent,
+					Quality:    a.Quality,
+					Correction: a.Correction,
+					Tags:       a.Tags,
+					Note:       a.Note,
+					Reviewer:   a.Reviewer,
+					LabeledAt:  a.UpdatedAt,
+				}
+				if err := enc.Encode(record); err != nil {
+					return
+				}
+			}
+		})
+		return nil
+	})
+
+	admin.Delete("/annotations/:id", func(c *fiber.Ctx) error {
+		if err := messageAnnotations.Remove(c.Params("id")); err != nil {
+			if errors.Is(err, annotations.ErrNotFound) {
+				return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.SendStatus(204)
+	})
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/apikeys"
+)
+
+var apiKeys *apikeys.Store
+
+// openAPIKeys loads the API keys from the message store, moving those of
+// api_keys.json there the first time, or from the file without a store.
+// Every instance reloads them with the tenants, every
+// CHATBOT_TENANT_RELOAD_INTERVAL.
+func openAPIKeys(ctx context.Context) error {
+	path := filepath.Join(serverConfig.DataDir, "api_keys.json")
+	if messageStore == nil {
+		var err error
+		apiKeys, err = apikeys.NewStore(path)
+		return err
+	}
+	keys, err := apikeys.OpenDB(ctx, messageStore, path)
+	if err != nil {
+		return err
+	}
+	apiKeys = keys
+	go keys.Run(ctx, serverConfig.TenantReloadInterval)
+	return nil
+}
+
+// requireCaller lets other backends call a route with an API key that has
+// scope in the X-API-Key header. Callers without one go through
+// requireVisitorToken like any visitor.
+func requireCaller(scope string) fiber.Handler {
+	withKey := requireAPIKey(scope)
+	return func(c *fiber.Ctx) error {
+		if c.Get("X-API-Key") == "" {
+			return requireVisitorToken(c)
+		}
+		return withKey(c)
+	}
+}
+
+// requireAPIKey lets a route be called only by other backends, with an
+// API key that has scope.
+func requireAPIKey(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		secret := c.Get("X-API-Key")
+		if secret == "" {
+			return c.Status(401).JSON(fiber.Map{"error": "X-API-Key is required"})
+		}
+		key, err := apiKeys.Authenticate(secret)
+		if err != nil {
+			log.Warn().Err(err).Msg("Rejected API key")
+			return c.Status(401).JSON(fiber.Map{"error": "Invalid API key"})
+		}
+		if !key.Allows(scope) {
+			return c.Status(403).JSON(fiber.Map{"error": "API key lacks the " + scope + " scope"})
+		}
+		c.Locals("api_key", key)
+		if key.Tenant != "" {
+			useTenant(c, key.Tenant)
+		}
+		return c.Next()
+	}
+}
+
+// registerAPIKeyRoutes lets operators issue, change, rotate and revoke
+// API keys.
+func registerAPIKeyRoutes(admin fiber.Router) {
+	admin.Get("/api-keys", func(c *fiber.Ctx) error {
+		return paginate(c, "api_keys", apiKeys.List())
+	})
+
+	admin.Get("/api-keys/:id", func(c *fiber.Ctx) error {
+		key, err := apiKeys.Get(c.Params("id"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(key)
+	})
+
+	// The key itself is only ever returned here
+	admin.Post("/api-keys", func(c *fiber.Ctx) error {
+		var body struct {
+			Name   string   `json:"name"`
+			Scopes []string `json:"scopes"`
+			Tenant string   `json:"tenant"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		if body.Tenant == defaultTenant {
+			body.Tenant = ""
+		}
+		if body.Tenant != "" && !knownTenant(body.Tenant) {
+			return c.Status(400).JSON(fiber.Map{"error": "Unknown tenant"})
+		}
+		key, secret, err := apiKeys.Create(body.Name, body.Scopes, body.Tenant, changedBy(c))
+		if errors.Is(err, apikeys.ErrNoName) || errors.Is(err, apikeys.ErrNoScopes) || errors.Is(err, apikeys.ErrUnknownScope) {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error(), "scopes": apikeys.Scopes})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(201).JSON(fiber.Map{"key": key, "secret": secret})
+	})
+
+	// Renames a key or changes its scopes; the secret stays the same
+	admin.Patch("/api-keys/:id", func(c *fiber.Ctx) error {
+		var body struct {
+			Name   string   `json:"name"`
+			Scopes []string `json:"scopes"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		key, err := apiKeys.Update(c.Params("id"), body.Name, body.Scopes)
+		switch {
+		case errors.Is(err, apikeys.ErrNotFound):
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		case errors.Is(err, apikeys.ErrRevoked):
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		case errors.Is(err, apikeys.ErrNoScopes) || errors.Is(err, apikeys.ErrUnknownScope):
+			return c.Status(400).JSON(fiber.Map{"error": err.Error(), "scopes": apikeys.Scopes})
+		case err != nil:
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		log.Info().Str("api_key", key.ID).Str("by", changedBy(c)).Msg("Updated API key")
+		return c.JSON(key)
+	})
+
+	// Issues a new secret for the key. With a grace period, e.g.
+	// {"grace": "24h"}, the old one keeps working until it ends.
+	admin.Post("/api-keys/:id/rotate", requireSecondFactor, func(c *fiber.Ctx) error {
+		var body struct {
+			Grace string `json:"grace"`
+		}
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&body); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+			}
+		}
+		var grace time.Duration
+		if body.Grace != "" {
+			d, err := time.ParseDuration(body.Grace)
+			if err != nil || d < 0 {
+				return c.Status(400).JSON(fiber.Map{"error": "grace must be a duration such as 24h"})
+			}
+			grace = d
+		}
+		key, secret, err := apiKeys.Rotate(c.Params("id"), grace)
+		switch {
+		case errors.Is(err, apikeys.ErrNotFound):
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		case errors.Is(err, apikeys.ErrRevoked):
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		case err != nil:
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		log.Info().Str("api_key", key.ID).Str("by", changedBy(c)).Dur("grace", grace).Msg("Rotated API key")
+		return c.JSON(fiber.Map{"key": key, "secret": secret})
+	})
+
+	admin.Delete("/api-keys/:id", requireSecondFactor, func(c *fiber.Ctx) error {
+		key, err := apiKeys.Revoke(c.Params("id"))
+		switch {
+		case errors.Is(err, apikeys.ErrNotFound):
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		case err != nil:
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(key)
+	})
+}
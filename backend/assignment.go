@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/agentpush"
+	"web-chatbot-backend/internal/agents"
+	"web-chatbot-backend/internal/assign"
+	"web-chatbot-backend/internal/events"
+	"web-chatbot-backend/internal/session"
+)
+
+// Automatic assignment of queued conversations, see
+// config.Assignment.
+var (
+	agentRoster *agents.Store
+	assigner    *assign.Assigner
+)
+
+// assignMu serialises assignment runs so two runs never hand the same
+// agent more than their limit.
+var assignMu sync.Mutex
+
+// assignQueued routes waiting conversations, longest waiting first, to
+// available agents with spare capacity.
+func assignQueued() {
+	assignMu.Lock()
+	defer assignMu.Unlock()
+
+	waiting := sessions.List(func(s *session.Session) bool { return s.Status == session.StatusWaitingAgent })
+	if len(waiting) == 0 {
+		return
+	}
+	sort.Slice(waiting, func(i, j int) bool { return waiting[i].StatusChangedAt.Before(waiting[j].StatusChangedAt) })
+
+	candidates := assignmentCandidates()
+	for _, sess := range waiting {
+		pool := candidates
+		if len(sess.Skills) > 0 && (serverConfig.Assignment.SkillRoutingTimeout == 0 || time.Since(sess.StatusChangedAt) < serverConfig.Assignment.SkillRoutingTimeout) {
+			pool = assign.Qualified(candidates, sess.Skills)
+		}
+		// Skilled agents may be free even when the first in line waits
+		name, ok := assigner.Pick(pool, append(sess.Tags, sess.Skills...))
+		if !ok {
+			continue
+		}
+		if err := sessions.Claim(sess.ID, name); err != nil {
+			// Claimed by hand in the meantime
+			continue
+		}
+		for i := range candidates {
+			if candidates[i].Name == name {
+				candidates[i].Active++
+			}
+		}
+		log.Info().Str("session_id", sess.ID).Str("agent", name).Str("strategy", assigner.Strategy()).Msg("Assigned session")
+		if agentPush != nil {
+			go agentPush.Notify(context.Background(), name, agentpush.Message{
+				Kind:  agentpush.KindQueued,
+				Title: "Conversation assigned to you",
+				Body:  lastVisitorMessage(sess.ID),
+				Data:  map[string]string{"session_id": sess.ID},
+			})
+		}
+	}
+}
+
+// assignmentCandidates returns the online agents with how many
+// conversations each is handling.
+func assignmentCandidates() []assign.Candidate {
+	active := make(map[string]int)
+	for _, s := range sessions.List(func(s *session.Session) bool { return s.Status == session.StatusWithAgent }) {
+		active[s.Agent]++
+	}
+	var out []assign.Candidate
+	for _, a := range agentRoster.Online() {
+		limit := a.MaxChats
+		if limit == 0 {
+			limit = serverConfig.Assignment.MaxChats
+		}
+		out = append(out, assign.Candidate{Name: a.Name, Active: active[a.Name], Max: limit, Skills: a.Skills})
+	}
+	return out
+}
+
+// runAssignment assigns queued conversations whenever one is queued or an
+// agent frees up, and every interval in case an agent became available,
+// until ctx is cancelled.
+func runAssignment(ctx context.Context, interval time.Duration) {
+	changed := make(chan struct{}, 1)
+	bus.Subscribe(func(e events.Event) {
+		if strings.HasPrefix(e.Type, "session_") {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	})
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-changed:
+		}
+		assignQueued()
+	}
+}
+
+// publishPresence announces an agent's status change. Agents coming
+// online may pick up queued conversations right away.
+func publishPresence(a agents.Agent, from agents.Status) {
+	if a.Status == from {
+		return
+	}
+	bus.Publish(events.Event{
+		Type: "agent_presence_changed",
+		Data: map[string]any{"agent": a.Name, "from": from, "to": a.Status},
+	})
+	if assigner != nil && a.Available() {
+		go assignQueued()
+	}
+}
+
+// agentsOnline reports whether anyone is there to take an escalation.
+// Deployments without an agent roster are assumed to be staffed.
+func agentsOnline() bool {
+	return len(agentRoster.List()) == 0 || len(agentRoster.Online()) > 0
+}
+
+// registerAgentRoutes manages the agent roster.
+func registerAgentRoutes(admin fiber.Router) {
+	admin.Get("/agents", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"agents": agentRoster.List()})
+	})
+
+	admin.Get("/agents/:agent", func(c *fiber.Ctx) error {
+		a, err := agentRoster.Get(c.Params("agent"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(a)
+	})
+
+	admin.Put("/agents/:agent", func(c *fiber.Ctx) error {
+		var a agents.Agent
+		if err := c.BodyParser(&a); err != nil || a.MaxChats < 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		a.Name = c.Params("agent")
+		from := agents.Offline
+		if old, err := agentRoster.Get(a.Name); err == nil {
+			from = old.Status
+		}
+		if err := agentRoster.Put(a); err != nil {
+			if errors.Is(err, agents.ErrInvalidStatus) {
+				return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		a, _ = agentRoster.Get(a.Name)
+		publishPresence(a, from)
+		return c.JSON(a)
+	})
+
+	// The agent console reports presence on login, logout and status
+	// changes; max_chats is optional
+	admin.Put("/agents/:agent/presence", func(c *fiber.Ctx) error {
+		body := struct {
+			Status   agents.Status `json:"status"`
+			MaxChats *int          `json:"max_chats"`
+		}{}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		maxChats := -1
+		if body.MaxChats != nil {
+			if *body.MaxChats < 0 {
+				return c.Status(400).JSON(fiber.Map{"error": "max_chats must not be negative"})
+			}
+			maxChats = *body.MaxChats
+		}
+		a, from, err := agentRoster.SetPresence(c.Params("agent"), body.Status, maxChats)
+		if err != nil {
+			if errors.Is(err, agents.ErrInvalidStatus) {
+				return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		publishPresence(a, from)
+		return c.JSON(a)
+	})
+
+	admin.Delete("/agents/:agent", requireSecondFactor, func(c *fiber.Ctx) error {
+		if err := agentRoster.Delete(c.Params("agent")); err != nil {
+			if errors.Is(err, agents.ErrNotFound) {
+				return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.SendStatus(204)
+	})
+}
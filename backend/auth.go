@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/jwtauth"
+	"web-chatbot-backend/internal/session"
+)
+
+// visitorTokens checks visitor tokens, or is nil when visitors need not
+// sign in; see newVisitorTokens.
+var visitorTokens *jwtauth.Verifier
+
+// newVisitorTokens returns the verifier for the configured key, or nil if
+// there is none.
+func newVisitorTokens() (*jwtauth.Verifier, error) {
+	opts := jwtauth.Options{Issuer: serverConfig.JWT.Issuer, Audience: serverConfig.JWT.Audience, Leeway: serverConfig.JWT.Leeway}
+	switch {
+	case serverConfig.JWT.Secret != "":
+		opts.Secret = []byte(serverConfig.JWT.Secret)
+	case serverConfig.JWT.PublicKeyFile != "":
+		data, err := os.ReadFile(serverConfig.JWT.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Key, err = jwtauth.ParseRSAPublicKey(data); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, nil
+	}
+	return jwtauth.New(opts)
+}
+
+// requireVisitorToken rejects chat requests without a valid token when
+// visitors must sign in, and keeps the token's claims in the "user" local
+// for the handlers.
+func requireVisitorToken(c *fiber.Ctx) error {
+	if visitorTokens == nil {
+		return c.Next()
+	}
+	token := strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
+	if token == "" && (websocket.IsWebSocketUpgrade(c) || c.Get("Accept") == "text/event-stream") {
+		// Browsers cannot set headers on WebSocket or EventSource requests
+		token = c.Query("token")
+	}
+	if token == "" {
+		return c.Status(401).JSON(fiber.Map{"error": "Sign-in required"})
+	}
+	claims, err := visitorTokens.Verify(token, time.Now())
+	if err != nil {
+		log.Warn().Err(err).Msg("Rejected visitor token")
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid token"})
+	}
+	c.Locals("user", claims)
+	return c.Next()
+}
+
+// signIn records who signed in to a session, so their claims go to the
+// webhook with every message. user is the "user" local of the request or
+// WebSocket connection.
+func signIn(sessionID string, user interface{}) {
+	claims, _ := user.(jwtauth.Claims)
+	if claims == nil {
+		return
+	}
+	if err := sessions.SetUser(sessionID, claims); err != nil {
+		log.Error().Str("session_id", sessionID).Err(err).Msg("Error recording user")
+	}
+}
+
+// ownsSession reports whether a visitor posting over HTTP may continue a
+// session: it must have been started by the same visitorID, and if
+// someone signed in to it, by a request signed in as them (user is the
+// request's "user" local). Sessions started without a visitor ID cannot
+// be continued this way, since nobody can show they started them.
+func ownsSession(s *session.Session, visitorID string, user interface{}) bool {
+	if s.VisitorID == "" || s.VisitorID != visitorID {
+		return false
+	}
+	if sub := jwtauth.Claims(s.User).Subject(); sub != "" {
+		claims, _ := user.(jwtauth.Claims)
+		return claims.Subject() == sub
+	}
+	return true
+}
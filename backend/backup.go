@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/store"
+)
+
+// runStoreCheckpoints checkpoints the message store every interval until
+// ctx is cancelled.
+func runStoreCheckpoints(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkpointMessageStore(ctx)
+		}
+	}
+}
+
+// checkpointMessageStore empties the write-ahead log of the SQLite message
+// store into the database file.
+func checkpointMessageStore(ctx context.Context) {
+	cctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	pages, err := messageStore.Checkpoint(cctx)
+	switch {
+	case errors.Is(err, store.ErrCheckpointBusy):
+		log.Debug().Int("pages", pages).Msg("Message store checkpoint incomplete, readers still open")
+	case err != nil:
+		log.Error().Err(err).Msg("Error checkpointing message store")
+	default:
+		log.Debug().Int("pages", pages).Msg("Message store checkpointed")
+	}
+}
+
+// runBackupCommand handles `chatbot-server backup <file>`: it writes a
+// snapshot of the SQLite message store to file, also while the server is
+// running on it.
+func runBackupCommand(args []string) {
+	if len(args) != 1 {
+		log.Fatal().Msg("Usage: chatbot-server backup <file>")
+	}
+	if serverConfig.Store.Driver != store.SQLite {
+		log.Fatal().Msg("Backups need CHATBOT_STORE_DRIVER=sqlite")
+	}
+	if serverConfig.Store.DSN == "" {
+		if _, err := os.Stat(filepath.Join(serverConfig.DataDir, "messages.db")); err != nil {
+			log.Fatal().Err(err).Msg("No message store to back up")
+		}
+	}
+	ctx := context.Background()
+	st, err := dialMessageStore(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error opening message store")
+	}
+	defer st.Close()
+	started := time.Now()
+	if err := st.Backup(ctx, args[0]); err != nil {
+		log.Fatal().Err(err).Msg("Error backing up message store")
+	}
+	log.Info().Str("path", args[0]).Dur("took", time.Since(started)).Msg("Message store backed up")
+}
+
+// registerBackupRoutes lets operators download a consistent snapshot of
+// the SQLite message store without stopping the server.
+func registerBackupRoutes(admin fiber.Router) {
+	admin.Get("/store/backup", func(c *fiber.Ctx) error {
+		if messageStore == nil || serverConfig.Store.Driver != store.SQLite {
+			return c.Status(404).JSON(fiber.Map{"error": "Backups need the SQLite message store, see CHATBOT_STORE_DRIVER"})
+		}
+		if err := os.MkdirAll(serverConfig.DataDir, 0o755); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		// A name of our own in the data directory, which the snapshot is
+		// then written to
+		f, err := os.CreateTemp(serverConfig.DataDir, "backup-*.db")
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		path := f.Name()
+		f.Close()
+		os.Remove(path)
+
+		started := time.Now()
+		if err := messageStore.Backup(c.Context(), path); err != nil {
+			log.Error().Err(err).Msg("Error backing up message store")
+			return c.Status(500).JSON(fiber.Map{"error": "Could not back up the message store"})
+		}
+		snapshot, err := os.Open(path)
+		// The open file stays readable until it is sent
+		os.Remove(path)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		info, err := snapshot.Stat()
+		if err != nil {
+			snapshot.Close()
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		log.Info().Int64("bytes", info.Size()).Dur("took", time.Since(started)).Str("by", changedBy(c)).Msg("Message store backed up")
+		c.Set("Content-Type", "application/vnd.sqlite3")
+		c.Set("Content-Disposition", `attachment; filename="messages-`+started.UTC().Format("20060102-150405")+`.db"`)
+		return c.SendStream(snapshot, int(info.Size()))
+	})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/apikeys"
+	"web-chatbot-backend/internal/pagecontext"
+	"web-chatbot-backend/internal/session"
+	"web-chatbot-backend/internal/store"
+	"web-chatbot-backend/internal/visitor"
+)
+
+// batchMessage is one message of a batch. ClientID is chosen by the
+// widget, e.g. the ID it gave the message while offline, and keys the
+// message's reply in the response.
+type batchMessage struct {
+	ClientID     string `json:"client_id"`
+	Message      string `json:"message"`
+	QuickReplyID string `json:"quick_reply_id"`
+}
+
+type batchRequest struct {
+	SessionID string         `json:"session_id"`
+	VisitorID string         `json:"visitor_id"`
+	Messages  []batchMessage `json:"messages"`
+	// Context describes the page the messages were sent from
+	Context *pagecontext.Context `json:"context"`
+}
+
+// registerBatchRoutes serves POST /chat/batch, which sends several
+// messages to a session at once, e.g. those the visitor wrote while
+// offline. They are answered one after the other, in order, as if each had
+// been posted to /chat.
+func registerBatchRoutes(app *fiber.App) {
+	app.Post("/chat/batch", limitBody(serverConfig.Server.ChatBodyLimit), requireCaller(apikeys.ScopeChat), func(c *fiber.Ctx) error {
+		received := time.Now()
+		var req batchRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		if len(req.Messages) == 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "messages is required"})
+		}
+		if len(req.Messages) > serverConfig.BatchMaxMessages {
+			return c.Status(400).JSON(fiber.Map{"error": "Too many messages", "max_messages": serverConfig.BatchMaxMessages})
+		}
+		seen := make(map[string]bool, len(req.Messages))
+		for _, m := range req.Messages {
+			if m.ClientID == "" {
+				return c.Status(400).JSON(fiber.Map{"error": "Every message needs a client_id"})
+			}
+			if seen[m.ClientID] {
+				return c.Status(400).JSON(fiber.Map{"error": "Duplicate client_id " + m.ClientID})
+			}
+			seen[m.ClientID] = true
+		}
+		ctx, err := acceptPageContext(c.UserContext(), req.Context, c.Get("User-Agent"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid context: " + err.Error()})
+		}
+		c.SetUserContext(ctx)
+
+		var profile *visitor.Profile
+		if req.VisitorID != "" {
+			var err error
+			profile, err = visitors.Touch(req.VisitorID)
+			if err != nil {
+				log.Ctx(c.UserContext()).Error().Str("visitor_id", req.VisitorID).Err(err).Msg("Error updating visitor")
+			} else if profile.Banned {
+				return c.Status(403).JSON(fiber.Map{"error": bannedMessage})
+			}
+		}
+
+		if req.SessionID != "" {
+			if existing, err := sessions.Get(req.SessionID); err == nil && !ownsSession(existing, req.VisitorID, c.Locals("user")) {
+				return c.Status(403).JSON(fiber.Map{"error": "Session belongs to another visitor"})
+			}
+		}
+		sess := resumeOrCreateSession(req.SessionID, req.VisitorID, tenantFrom(c.UserContext()))
+		signIn(sess.ID, c.Locals("user"))
+		keepPageContext(c.UserContext(), sess.ID)
+		if sess.ID != req.SessionID && profile != nil {
+			if _, err := visitors.RecordSession(profile.ID, sess.ID); err != nil {
+				log.Ctx(c.UserContext()).Error().Str("visitor_id", profile.ID).Err(err).Msg("Error recording session")
+			}
+		}
+		if streamClient(sess.ID) == nil {
+			sessions.SetChannel(sess.ID, store.ChannelHTTP)
+		}
+
+		log.Ctx(c.UserContext()).Info().Int("messages", len(req.Messages)).Str("session_id", sess.ID).Msg("Received batch")
+
+		replies := make(map[string]fiber.Map, len(req.Messages))
+		key := rateLimitKey(req.VisitorID, c.IP())
+		limiter := limiterFor(tenantFrom(c.UserContext()))
+		for i, m := range req.Messages {
+			state, ok := limiter.Allow(key)
+			setRateLimitHeaders(c, state)
+			if !ok {
+				// The rest are left for the widget to send again later
+				retryAfter := retryAfterSeconds(state)
+				for _, rest := range req.Messages[i:] {
+					replies[rest.ClientID] = fiber.Map{"status": "rate_limited", "error": rateLimitedMessage, "retry_after": retryAfter}
+				}
+				break
+			}
+			// Later messages wait for the earlier ones to be answered
+			ctx := withTurnClock(c.UserContext(), received)
+			replies[m.ClientID] = answerBatchMessage(ctx, sess.ID, profile, m)
+		}
+
+		return c.JSON(fiber.Map{"session_id": sess.ID, "replies": replies})
+	})
+}
+
+// answerBatchMessage handles one message of a batch and returns its entry
+// in the response: the bot's reply, or just the session status while an
+// agent has the conversation.
+func answerBatchMessage(ctx context.Context, id string, profile *visitor.Profile, m batchMessage) fiber.Map {
+	if err := sessions.Touch(id); err != nil {
+		log.Ctx(ctx).Error().Str("session_id", id).Err(err).Msg("Error touching session")
+	}
+	if err := sessions.Activate(id); err != nil {
+		log.Ctx(ctx).Error().Str("session_id", id).Err(err).Msg("Error activating session")
+	}
+	notifyAgentOfReply(id, m.Message)
+
+	// An earlier message of the batch may have handed the conversation over
+	if current, err := sessions.Get(id); err == nil && current.Status == session.StatusWithAgent {
+		relayToAgent(ctx, current, profile, m.Message)
+		countMessage(ctx, nil)
+		return fiber.Map{"status": current.Status}
+	}
+
+	var out botReply
+	var err error
+	if m.QuickReplyID != "" {
+		out, err = respondQuickReply(ctx, id, profile, m.Message, m.QuickReplyID)
+	} else {
+		out, err = respond(ctx, id, profile, m.Message)
+	}
+	countMessage(ctx, err)
+	if err != nil {
+		return fiber.Map{"status": "failed", "reply": apology(err)}
+	}
+
+	resp := out.frame()
+	resp["status"] = "answered"
+	if out.System != "" {
+		resp["system"] = out.System
+	}
+	if len(out.Actions) > 0 {
+		resp["actions"] = out.Actions
+	}
+	recordTiming(ctx, id)
+	return resp
+}
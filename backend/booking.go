@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/actions"
+	"web-chatbot-backend/internal/booking"
+	"web-chatbot-backend/internal/events"
+	"web-chatbot-backend/internal/session"
+)
+
+// registerBookingActions adds the list_slots and book_slot actions.
+//
+// Workflows call {"action": "list_slots", "params": {"days": 7, "limit": 5}}
+// to offer the next free slots as quick replies. Picking one runs book_slot,
+// which books it for the visitor using the "name", "email" and "timezone"
+// session variables, posts the confirmation and emails it to the visitor.
+func registerBookingActions(registry *actions.Registry, scheduler booking.Scheduler) {
+	registry.Register("list_slots", actions.HandlerFunc(func(ctx context.Context, call actions.Call) (map[string]interface{}, error) {
+		days := intParam(call.Params, "days", 7)
+		limit := intParam(call.Params, "limit", 5)
+		loc := sessionLocation(call.SessionID)
+
+		from := time.Now()
+		slots, err := scheduler.Slots(ctx, from, from.AddDate(0, 0, days))
+		if err != nil {
+			return nil, err
+		}
+		if len(slots) > limit {
+			slots = slots[:limit]
+		}
+		if len(slots) == 0 {
+			return map[string]interface{}{"slots": []string{}, "message": "Sorry, there are no free slots at the moment."}, nil
+		}
+
+		starts := make([]string, 0, len(slots))
+		offers := make([]session.QuickReply, 0, len(slots))
+		for _, slot := range slots {
+			start := slot.Start.Format(time.RFC3339)
+			starts = append(starts, start)
+			offers = append(offers, session.QuickReply{
+				Label:  slot.Start.In(loc).Format("Mon 2 Jan 15:04 MST"),
+				Action: "book_slot",
+				Params: map[string]interface{}{"start": start},
+			})
+		}
+		return map[string]interface{}{
+			"slots":         starts,
+			"message":       "Here are the next available times:",
+			"quick_replies": offers,
+		}, nil
+	}))
+
+	registry.Register("book_slot", actions.HandlerFunc(func(ctx context.Context, call actions.Call) (map[string]interface{}, error) {
+		startParam, _ := call.Params["start"].(string)
+		start, err := time.Parse(time.RFC3339, startParam)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start %q", startParam)
+		}
+
+		memory, _ := sessions.Memory(call.SessionID)
+		invitee := booking.Invitee{
+			Name:     stringParam(call.Params, memory, "name"),
+			Email:    stringParam(call.Params, memory, "email"),
+			TimeZone: stringParam(call.Params, memory, "timezone"),
+		}
+		if invitee.Email == "" {
+			return map[string]interface{}{"message": "Please tell me your email address first so I can book this for you."},
+				fmt.Errorf("no email address for the booking")
+		}
+		if invitee.Name == "" {
+			invitee.Name = invitee.Email
+		}
+
+		b, err := scheduler.Book(ctx, start, invitee)
+		if err != nil {
+			return map[string]interface{}{"message": "Sorry, I couldn't book that slot. It may have just been taken."}, err
+		}
+
+		when := start.In(sessionLocation(call.SessionID)).Format("Monday 2 January 15:04 MST")
+		confirmation := fmt.Sprintf("You're booked for %s.", when)
+		if b.URL != "" {
+			confirmation += " Manage your booking: " + b.URL
+		}
+
+		bus.Publish(events.Event{
+			Type:      "booking_created",
+			SessionID: call.SessionID,
+			Data:      map[string]any{"provider": scheduler.Name(), "booking_id": b.ID, "start": start, "email": invitee.Email},
+		})
+
+		if smtpConfig().Addr != "" {
+			go func() {
+				if err := actions.SendMail(smtpConfig(), []string{invitee.Email}, "Your booking is confirmed", confirmation); err != nil {
+					log.Error().Str("session_id", call.SessionID).Err(err).Msg("Error emailing booking confirmation")
+				}
+			}()
+			confirmation += " A confirmation has been sent to " + invitee.Email + "."
+		}
+
+		return map[string]interface{}{
+			"booking_id": b.ID,
+			"start":      start.Format(time.RFC3339),
+			"url":        b.URL,
+			"message":    confirmation,
+		}, nil
+	}))
+}
+
+// sessionLocation is the visitor's time zone from the "timezone" session
+// variable, or UTC.
+func sessionLocation(sessionID string) *time.Location {
+	memory, _ := sessions.Memory(sessionID)
+	if tz, ok := memory["timezone"].(string); ok {
+		if loc, err := time.LoadLocation(tz); err == nil {
+			return loc
+		}
+	}
+	return time.UTC
+}
+
+func intParam(params map[string]interface{}, key string, def int) int {
+	if v, ok := params[key].(float64); ok && v > 0 {
+		return int(v)
+	}
+	return def
+}
+
+// stringParam reads key from the action params, falling back to the
+// session variables.
+func stringParam(params, memory map[string]interface{}, key string) string {
+	if v, ok := params[key].(string); ok && v != "" {
+		return v
+	}
+	if v, ok := memory[key].(string); ok {
+		return v
+	}
+	return ""
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"web-chatbot-backend/internal/delivery"
+	"web-chatbot-backend/internal/session"
+)
+
+// Bulk job types
+const (
+	jobCloseIdle        = "close_idle_sessions"
+	jobTagSessions      = "tag_sessions"
+	jobPurgeSessions    = "purge_sessions"
+	jobRedeliverWebhook = "redeliver_failed_webhooks"
+)
+
+// sessionFilter selects the sessions a bulk job applies to. Durations use
+// Go syntax, e.g. "30m" or "720h".
+type sessionFilter struct {
+	Status    session.Status `json:"status"`
+	VisitorID string         `json:"visitor_id"`
+	Tag       string         `json:"tag"`
+	IdleFor   string         `json:"idle_for"`
+	OlderThan string         `json:"older_than"`
+}
+
+func (f sessionFilter) empty() bool {
+	return f == sessionFilter{}
+}
+
+// match returns a predicate for sessions.List.
+func (f sessionFilter) match() (func(*session.Session) bool, error) {
+	var idleFor, olderThan time.Duration
+	var err error
+	if f.IdleFor != "" {
+		if idleFor, err = time.ParseDuration(f.IdleFor); err != nil {
+			return nil, fmt.Errorf("invalid idle_for: %w", err)
+		}
+	}
+	if f.OlderThan != "" {
+		if olderThan, err = time.ParseDuration(f.OlderThan); err != nil {
+			return nil, fmt.Errorf("invalid older_than: %w", err)
+		}
+	}
+	if f.Status != "" && !f.Status.Valid() {
+		return nil, fmt.Errorf("invalid status %q", f.Status)
+	}
+	now := time.Now()
+	return func(s *session.Session) bool {
+		return (f.Status == "" || s.Status == f.Status) &&
+			(f.VisitorID == "" || s.VisitorID == f.VisitorID) &&
+			(f.Tag == "" || s.HasTag(f.Tag)) &&
+			(idleFor == 0 || now.Sub(s.LastActivityAt) >= idleFor) &&
+			(olderThan == 0 || now.Sub(s.CreatedAt) >= olderThan)
+	}, nil
+}
+
+func sessionIDs(list []*session.Session) []string {
+	ids := make([]string, len(list))
+	for i, s := range list {
+		ids[i] = s.ID
+	}
+	return ids
+}
+
+// startBulkJob validates a bulk request and starts its job.
+func startBulkJob(c *fiber.Ctx) error {
+	var req struct {
+		Type   string        `json:"type"`
+		Filter sessionFilter `json:"filter"`
+		// Tags to add and remove for tag_sessions
+		Add    []string `json:"add"`
+		Remove []string `json:"remove"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	if req.Type == jobRedeliverWebhook {
+		var ids []string
+		for _, d := range deliveries.List(delivery.StatusFailed, "") {
+			ids = append(ids, d.ID)
+		}
+		job := bulkJobs.Start(req.Type, ids, func(id string) error {
+			_, err := deliveries.Redeliver(id)
+			return err
+		})
+		return c.Status(202).JSON(job)
+	}
+
+	match, err := req.Filter.match()
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	var fn func(id string) error
+	switch req.Type {
+	case jobCloseIdle:
+		if req.Filter.IdleFor == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "filter.idle_for is required"})
+		}
+		if req.Filter.Status == "" {
+			// Only conversations still in progress can go idle
+			inner := match
+			match = func(s *session.Session) bool {
+				return (s.Status == session.StatusNew || s.Status == session.StatusActive) && inner(s)
+			}
+		}
+		fn = func(id string) error { return sessions.Close(id, "admin") }
+	case jobTagSessions:
+		if len(req.Add) == 0 && len(req.Remove) == 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "add or remove is required"})
+		}
+		fn = func(id string) error { return sessions.Tag(id, req.Add, req.Remove) }
+	case jobPurgeSessions:
+		// Refuse to purge everything by accident
+		if req.Filter.empty() {
+			return c.Status(400).JSON(fiber.Map{"error": "a filter is required"})
+		}
+		if status, msg := secondFactorProblem(c); status != 0 {
+			return c.Status(status).JSON(fiber.Map{"error": msg})
+		}
+		fn = sessions.Delete
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("unknown job type %q", req.Type)})
+	}
+
+	job := bulkJobs.Start(req.Type, sessionIDs(sessions.List(match)), fn)
+	return c.Status(202).JSON(job)
+}
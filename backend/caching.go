@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/cache"
+	"web-chatbot-backend/internal/geoip"
+)
+
+// Parts of the cache, see openCache
+var (
+	geoipCache       *cache.Namespace
+	responseCache    *cache.Namespace
+	translationCache *cache.Namespace
+)
+
+// openCache sets up the configured cache backend.
+func openCache(ctx context.Context) error {
+	var c cache.Cache
+	switch serverConfig.Cache.Backend {
+	case "memory":
+		c = cache.NewLRU(serverConfig.Cache.Size)
+	case "redis":
+		r, err := cache.NewRedis(ctx, serverConfig.Cache.RedisURL)
+		if err != nil {
+			return err
+		}
+		c = r
+	default:
+		return fmt.Errorf("unknown cache %q", serverConfig.Cache.Backend)
+	}
+	geoipCache = cache.NewNamespace(c, "geoip")
+	responseCache = cache.NewNamespace(c, "reply")
+	translationCache = cache.NewNamespace(c, "translation")
+	return nil
+}
+
+// lookupLocation resolves ip, remembering the answer for
+// CHATBOT_GEOIP_CACHE_TTL so returning visitors cost no lookup. With
+// countryOnly the region and city are dropped before anything is cached.
+func lookupLocation(ctx context.Context, ip string, countryOnly bool) (geoip.Location, error) {
+	key := ip
+	if countryOnly {
+		key = "country:" + ip
+	}
+	var loc geoip.Location
+	if geoipCache.GetJSON(ctx, key, &loc) {
+		return loc, nil
+	}
+	loc, err := locator.Lookup(ctx, ip)
+	if err != nil {
+		return loc, err
+	}
+	if countryOnly {
+		loc = geoip.CountryOnly(loc)
+	}
+	if err := geoipCache.SetJSON(ctx, key, loc, serverConfig.Cache.GeoIPTTL); err != nil {
+		log.Error().Str("ip", ip).Err(err).Msg("Error caching location")
+	}
+	return loc, nil
+}
+
+// askBotCached is askBot for payloads whose reply may be reused
+// for CHATBOT_RESPONSE_CACHE_TTL. Only identical payloads share a reply,
+// so in practice these are one-off messages without a session. Replies
+// that run actions or set memory are never cached.
+func askBotCached(ctx context.Context, payload map[string]interface{}) (upstreamReply, error) {
+	if serverConfig.Cache.ResponseTTL <= 0 || !featureEnabled(featureResponseCache) {
+		return askBot(ctx, payload)
+	}
+	// The request ID differs every time, so it is left out of the key
+	keyed := maps.Clone(payload)
+	delete(keyed, "request_id")
+	raw, err := json.Marshal(keyed)
+	if err != nil {
+		return askBot(ctx, payload)
+	}
+	sum := sha256.Sum256(raw)
+	key := hex.EncodeToString(sum[:])
+
+	var reply upstreamReply
+	if responseCache.GetJSON(ctx, key, &reply) {
+		return reply, nil
+	}
+	reply, err = askBot(ctx, payload)
+	if err == nil && len(reply.Actions) == 0 && len(reply.Memory) == 0 {
+		if err := responseCache.SetJSON(ctx, key, reply, serverConfig.Cache.ResponseTTL); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("Error caching reply")
+		}
+	}
+	return reply, err
+}
+
+// registerCacheRoutes reports how well each part of the cache is doing.
+func registerCacheRoutes(admin fiber.Router) {
+	admin.Get("/cache", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{
+			"backend": serverConfig.Cache.Backend,
+			"caches": fiber.Map{
+				"geoip":        geoipCache.Stats(),
+				"responses":    responseCache.Stats(),
+				"translations": translationCache.Stats(),
+			},
+		})
+	})
+}
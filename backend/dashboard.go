@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"web-chatbot-backend/internal/events"
+	"web-chatbot-backend/internal/realtime"
+	"web-chatbot-backend/internal/session"
+)
+
+// Live stats for dashboards, sent on the admin stream every
+// CHATBOT_STREAM_INTERVAL.
+var (
+	dashboardFeed = realtime.NewFeed()
+	messageRate   = realtime.NewRate(time.Minute)
+	errorRate     = realtime.NewRate(time.Minute)
+)
+
+// dashboardStats is a snapshot of what the system is doing right now.
+type dashboardStats struct {
+	Time              time.Time `json:"time"`
+	ActiveSessions    int       `json:"active_sessions"`
+	QueueDepth        int       `json:"queue_depth"`
+	WithAgent         int       `json:"with_agent"`
+	AgentsOnline      int       `json:"agents_online"`
+	MessagesPerMinute int       `json:"messages_per_minute"`
+	ErrorsPerMinute   int       `json:"errors_per_minute"`
+	// ErrorRate is the share of last minute's messages that failed.
+	ErrorRate       float64 `json:"error_rate"`
+	UpstreamHealthy bool    `json:"upstream_healthy"`
+}
+
+// countMessage records one visitor message for the stats and metrics, and
+// whether answering it failed.
+func countMessage(ctx context.Context, err error) {
+	messageRate.Add(1)
+	if err != nil {
+		errorRate.Add(1)
+	}
+	chatMetrics.CountMessage(tenantFrom(ctx), botProviderName, err != nil)
+}
+
+func currentStats() dashboardStats {
+	stats := dashboardStats{
+		Time:              time.Now(),
+		AgentsOnline:      len(agentRoster.Online()),
+		MessagesPerMinute: messageRate.Count(),
+		ErrorsPerMinute:   errorRate.Count(),
+		UpstreamHealthy:   upstreams.Ready(),
+	}
+	for _, s := range sessions.List(func(s *session.Session) bool { return !s.Test }) {
+		switch s.Status {
+		case session.StatusWaitingAgent:
+			stats.QueueDepth++
+		case session.StatusWithAgent:
+			stats.WithAgent++
+		case session.StatusClosed, session.StatusArchived:
+			continue
+		}
+		stats.ActiveSessions++
+	}
+	if stats.MessagesPerMinute > 0 {
+		stats.ErrorRate = float64(stats.ErrorsPerMinute) / float64(stats.MessagesPerMinute)
+	}
+	return stats
+}
+
+// runDashboardStream publishes stats every interval while anyone is
+// watching, and every event as it happens, until ctx is cancelled.
+func runDashboardStream(ctx context.Context, interval time.Duration) {
+	bus.Subscribe(func(e events.Event) {
+		dashboardFeed.Publish(realtime.Update{Event: "event", Data: e})
+	})
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if dashboardFeed.Subscribers() > 0 {
+			dashboardFeed.Publish(realtime.Update{Event: "stats", Data: currentStats()})
+		}
+	}
+}
+
+// writeEvent writes one server-sent event and flushes it to the client.
+func writeEvent(w *bufio.Writer, event string, data any) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, body); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// registerStreamRoutes serves the dashboard stream as server-sent events:
+// "stats" snapshots and an "event" for everything published on the bus.
+func registerStreamRoutes(admin fiber.Router) {
+	admin.Get("/stream", func(c *fiber.Ctx) error {
+		c.Set("Content-Type", "text/event-stream")
+		c.Set("Cache-Control", "no-cache")
+		c.Set("Connection", "keep-alive")
+		c.Set("X-Accel-Buffering", "no")
+
+		updates, unsubscribe := dashboardFeed.Subscribe(64)
+		first := currentStats()
+		streamBody(c, func(w *bufio.Writer) {
+			defer unsubscribe()
+			if err := writeEvent(w, "stats", first); err != nil {
+				return
+			}
+			for u := range updates {
+				if err := writeEvent(w, u.Event, u.Data); err != nil {
+					return
+				}
+			}
+		})
+		return nil
+	})
+
+	admin.Get("/stats", func(c *fiber.Ctx) error {
+		return c.JSON(currentStats())
+	})
+}
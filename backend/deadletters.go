@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/deadletter"
+	"web-chatbot-backend/internal/provider"
+	"web-chatbot-backend/internal/requestid"
+	"web-chatbot-backend/internal/visitor"
+)
+
+var deadLetters *deadletter.Queue
+
+// recordFailure notes on the visitor's message that the bot could not
+// answer it, and why, and keeps a copy in the dead-letter queue.
+func recordFailure(ctx context.Context, conversation string, profile *visitor.Profile, message string, err error) {
+	var rerr *relayError
+	if !errors.As(err, &rerr) || rerr.Class == "" {
+		return
+	}
+	if conversation != "" {
+		if err := sessions.SetFailure(conversation, string(rerr.Class)); err != nil {
+			log.Ctx(ctx).Error().Str("session_id", conversation).Err(err).Msg("Error recording failure")
+		}
+	}
+	letter := deadletter.Letter{
+		SessionID: conversation,
+		VisitorID: profileID(profile),
+		RequestID: requestid.From(ctx),
+		Message:   message,
+		Class:     string(rerr.Class),
+		Error:     rerr.Err.Error(),
+	}
+	var status *provider.StatusError
+	if errors.As(err, &status) {
+		letter.Status = status.Status
+	}
+	deadLetters.Add(letter)
+}
+
+// registerDeadLetterRoutes lets operators look through the messages the
+// bot failed to answer, and dismiss them once dealt with.
+func registerDeadLetterRoutes(admin fiber.Router) {
+	admin.Get("/dead-letters", func(c *fiber.Ctx) error {
+		class := c.Query("class")
+		if class != "" && !slices.Contains(provider.Classes, provider.Class(class)) {
+			return c.Status(400).JSON(fiber.Map{"error": "Unknown class", "classes": provider.Classes})
+		}
+		return paginate(c, "dead_letters", deadLetters.List(class, c.Query("session_id")))
+	})
+
+	admin.Get("/dead-letters/counts", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"counts": deadLetters.Counts()})
+	})
+
+	admin.Get("/dead-letters/:id", func(c *fiber.Ctx) error {
+		letter, err := deadLetters.Get(c.Params("id"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(letter)
+	})
+
+	admin.Delete("/dead-letters/:id", requireSecondFactor, func(c *fiber.Ctx) error {
+		if err := deadLetters.Delete(c.Params("id")); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.SendStatus(204)
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/acme/autocert"
+
+	"web-chatbot-backend/internal/domains"
+	"web-chatbot-backend/internal/handoff"
+)
+
+// defaultTenant names the tenant of requests for no other, served with
+// the server's own settings.
+const defaultTenant = "default"
+
+var customDomains *domains.Store
+
+var errUnknownDomain = errors.New("not a custom domain")
+
+// knownTenant reports whether this deployment serves tenant.
+func knownTenant(tenant string) bool {
+	_, ok := registeredTenant(tenant)
+	return tenant == defaultTenant || ok
+}
+
+type tenantKey struct{}
+
+// withTenant returns ctx carrying the tenant a chat turn is for.
+func withTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// tenantFrom returns the tenant in ctx, or the default tenant.
+func tenantFrom(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok {
+		return tenant
+	}
+	return defaultTenant
+}
+
+// resolveTenant keeps the tenant a request is for in the "tenant" local
+// and the request's user context: the one named by the bot_id query
+// parameter, the one its Host is mapped to, or the default tenant. API
+// keys of a tenant override it, see requireAPIKey.
+func resolveTenant(c *fiber.Ctx) error {
+	tenant, ok := customDomains.Lookup(c.Hostname())
+	if !ok {
+		tenant = defaultTenant
+	}
+	if id := c.Query("bot_id"); id != "" {
+		if !knownTenant(id) {
+			return c.Status(404).JSON(fiber.Map{"error": "Unknown bot"})
+		}
+		tenant = id
+	}
+	useTenant(c, tenant)
+	return c.Next()
+}
+
+// serveTLS serves app over HTTPS with certificates obtained on demand
+// through the ACME TLS-ALPN challenge, so the port must be reachable from
+// the internet. Certificates are only requested for custom domains.
+func serveTLS(app *fiber.App) {
+	m := &autocert.Manager{
+		Prompt: autocert.AcceptTOS,
+		Cache:  autocert.DirCache(filepath.Join(serverConfig.DataDir, "certs")),
+		Email:  serverConfig.Autocert.Email,
+		HostPolicy: func(_ context.Context, host string) error {
+			if _, ok := customDomains.Lookup(host); !ok {
+				return fmt.Errorf("%w: %s", errUnknownDomain, host)
+			}
+			return nil
+		},
+	}
+	ln, err := handoff.Listen(fmt.Sprintf(":%d", serverConfig.Autocert.TLSPort), serverConfig.Handoff.ReusePort)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error listening for HTTPS")
+	}
+	if err := app.Listener(tls.NewListener(ln, m.TLSConfig())); err != nil && !draining.Load() {
+		log.Fatal().Err(err).Msg("Server stopped")
+	}
+}
+
+// registerDomainRoutes lets operators map custom domains to tenants.
+func registerDomainRoutes(admin fiber.Router) {
+	admin.Get("/domains", func(c *fiber.Ctx) error {
+		return paginate(c, "domains", customDomains.List())
+	})
+
+	admin.Post("/domains", func(c *fiber.Ctx) error {
+		var body struct {
+			Host   string `json:"host"`
+			Tenant string `json:"tenant"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		if body.Tenant == "" {
+			body.Tenant = defaultTenant
+		}
+		if !knownTenant(body.Tenant) {
+			return c.Status(400).JSON(fiber.Map{"error": "Unknown tenant"})
+		}
+		d, err := customDomains.Add(body.Host, body.Tenant, changedBy(c))
+		switch {
+		case errors.Is(err, domains.ErrInvalidHost):
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		case errors.Is(err, domains.ErrExists):
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		case err != nil:
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(201).JSON(d)
+	})
+
+	admin.Delete("/domains/:host", requireSecondFactor, func(c *fiber.Ctx) error {
+		err := customDomains.Remove(c.Params("host"))
+		if errors.Is(err, domains.ErrNotFound) {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.SendStatus(204)
+	})
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/botconfig"
+	"web-chatbot-backend/internal/changelog"
+	"web-chatbot-backend/internal/events"
+	"web-chatbot-backend/internal/greetings"
+	"web-chatbot-backend/internal/rules"
+	"web-chatbot-backend/internal/visitor"
+)
+
+// Draft and published bot configuration
+var botConfig *botconfig.Store
+
+// Who changed the configuration, when and how
+var configChanges *changelog.Log
+
+// changedBy names the operator making an admin request for the records:
+// the one whose token made it or, with the shared admin token, whoever
+// the X-Admin-User header says. The header is not checked, so nothing is
+// decided by it; see signedInOperator.
+func changedBy(c *fiber.Ctx) string {
+	if name := signedInOperator(c); name != "" {
+		return name
+	}
+	return c.Get("X-Admin-User")
+}
+
+// recordChange adds a configuration change to the history. A failure to
+// record it is logged; the change itself has already been made.
+func recordChange(c *fiber.Ctx, kind, action, target string, before, after any) {
+	entry := changelog.Entry{Kind: kind, Action: action, Target: target, By: changedBy(c)}
+	if _, err := configChanges.Record(entry, before, after); err != nil {
+		log.Error().Str("kind", kind).Err(err).Msg("Error recording change")
+	}
+}
+
+// announcePublish tells event webhook subscribers, the dashboard and, if
+// configured, a Slack channel that a new release went live.
+func announcePublish(release botconfig.Release, change changelog.Entry) {
+	bus.Publish(events.Event{
+		Type: "config_published",
+		Data: map[string]any{"version": release.Version, "by": release.PublishedBy, "changes": len(change.Diff)},
+	})
+	if serverConfig.ConfigSlackWebhookURL == "" {
+		return
+	}
+	by := release.PublishedBy
+	if by == "" {
+		by = "someone"
+	}
+	text := fmt.Sprintf("Bot configuration version %d was published by %s with %d changes.", release.Version, by, len(change.Diff))
+	go func() {
+		body, _ := json.Marshal(map[string]string{"text": text})
+		client := &http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Post(serverConfig.ConfigSlackWebhookURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Error().Int("version", release.Version).Err(err).Msg("Error notifying Slack of release")
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Error().Int("version", release.Version).Int("status", resp.StatusCode).Msg("Slack rejected the release notice")
+		}
+	}()
+}
+
+// publishedConfig is the configuration visitors get right now.
+func publishedConfig() botconfig.Config {
+	return botconfig.Config{
+		Prompt:    botConfig.Release().Prompt,
+		Greetings: greetingRules.List(),
+		Rules:     autoResponder.List(),
+	}
+}
+
+// currentDraft is the draft, or the published configuration to start one
+// from.
+func currentDraft() botconfig.Config {
+	if draft := botConfig.Draft(); draft != nil {
+		return *draft
+	}
+	return publishedConfig()
+}
+
+// applyConfig swaps in the greetings and rules of c. The greetings are put
+// back if the rules cannot be saved, so visitors never see half of a
+// release.
+func applyConfig(c botconfig.Config) error {
+	previous := greetingRules.List()
+	if err := greetingRules.Replace(c.Greetings); err != nil {
+		return err
+	}
+	if err := autoResponder.Replace(c.Rules); err != nil {
+		greetingRules.Replace(previous)
+		return err
+	}
+	return nil
+}
+
+// draftReply answers message the way the draft would, without touching
+// any session, stats or hooks.
+func draftReply(ctx context.Context, draft botconfig.Config, profile *visitor.Profile, message string) (fiber.Map, error) {
+	engine, _ := rules.NewEngine("")
+	if err := engine.Replace(draft.Rules); err != nil {
+		return nil, err
+	}
+	resp := fiber.Map{}
+	rule := engine.Find(message)
+	if rule != nil {
+		resp["rule"] = rule
+		if rule.Reply != "" {
+			resp["source"] = "rule"
+			resp["reply"] = rule.Reply
+			return resp, nil
+		}
+	}
+
+	payload := webhookPayload(message, profile, nil)
+	delete(payload, "prompt")
+	if draft.Prompt != "" {
+		payload["prompt"] = draft.Prompt
+	}
+	payload["test"] = true
+	payload["draft"] = true
+	reply, err := askBot(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+	resp["source"] = "bot"
+	resp["reply"] = reply.Text
+	if len(reply.QuickReplies) > 0 {
+		resp["quick_replies"] = reply.QuickReplies
+	}
+	return resp, nil
+}
+
+// draftGreeting is the greeting the draft would show v.
+func draftGreeting(draft botconfig.Config, v greetings.Visitor) (string, error) {
+	set, _ := greetings.NewSet("")
+	if err := set.Replace(draft.Greetings); err != nil {
+		return "", err
+	}
+	if rule := set.Pick(v); rule != nil {
+		return rule.Text, nil
+	}
+	return defaultGreeting(v.Tenant), nil
+}
+
+// registerDraftRoutes lets operators edit a draft of the prompt, greetings
+// and auto-responder rules, try it out and then publish it in one go. The
+// greeting and rule routes keep editing the published configuration
+// directly.
+func registerDraftRoutes(admin fiber.Router) {
+	admin.Get("/config", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"release": botConfig.Release(), "config": publishedConfig()})
+	})
+
+	admin.Get("/config/draft", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"exists": botConfig.Draft() != nil, "config": currentDraft()})
+	})
+
+	admin.Put("/config/draft", func(c *fiber.Ctx) error {
+		var draft botconfig.Config
+		if err := c.BodyParser(&draft); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		before := currentDraft()
+		if err := botConfig.SetDraft(draft); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		recordChange(c, "draft", changelog.ActionUpdate, "", before, currentDraft())
+		return c.JSON(fiber.Map{"exists": true, "config": currentDraft()})
+	})
+
+	admin.Delete("/config/draft", func(c *fiber.Ctx) error {
+		before := botConfig.Draft()
+		if err := botConfig.Discard(); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if before != nil {
+			recordChange(c, "draft", changelog.ActionDiscard, "", before, publishedConfig())
+		}
+		return c.SendStatus(204)
+	})
+
+	admin.Post("/config/draft/test", func(c *fiber.Ctx) error {
+		var body struct {
+			Message string `json:"message"`
+			// Tenant, VisitorID, Referrer and TZ simulate the visitor, as
+			// the widget passes them on bootstrap
+			Tenant    string `json:"tenant"`
+			VisitorID string `json:"visitor_id"`
+			Referrer  string `json:"referrer"`
+			TZ        string `json:"tz"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		draft := currentDraft()
+		var profile *visitor.Profile
+		if body.VisitorID != "" {
+			var err error
+			if profile, err = visitors.Get(body.VisitorID); err != nil {
+				return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+
+		greeting, err := draftGreeting(draft, greetingVisitor(cmp.Or(body.Tenant, defaultTenant), body.VisitorID, body.Referrer, body.TZ))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		resp := fiber.Map{"greeting": greeting}
+		if body.Message == "" {
+			return c.JSON(resp)
+		}
+		out, err := draftReply(c.UserContext(), draft, profile, body.Message)
+		if err != nil {
+			return c.Status(502).JSON(fiber.Map{"error": err.Error()})
+		}
+		for k, v := range out {
+			resp[k] = v
+		}
+		return c.JSON(resp)
+	})
+
+	admin.Post("/config/publish", func(c *fiber.Ctx) error {
+		var body struct {
+			By string `json:"by"`
+		}
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&body); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+			}
+		}
+		if body.By == "" {
+			body.By = changedBy(c)
+		}
+		before := publishedConfig()
+		release, err := botConfig.Publish(body.By, applyConfig)
+		if errors.Is(err, botconfig.ErrNoDraft) {
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		after := publishedConfig()
+		change, err := configChanges.Record(changelog.Entry{
+			Kind: "config", Action: changelog.ActionPublish, By: release.PublishedBy, Version: release.Version,
+		}, before, after)
+		if err != nil {
+			log.Error().Int("version", release.Version).Err(err).Msg("Error recording release")
+		}
+		announcePublish(release, change)
+		return c.JSON(fiber.Map{"release": release, "config": after})
+	})
+
+	// Newest first; ?kind= narrows it to config, draft, greeting or rule
+	admin.Get("/config/history", func(c *fiber.Ctx) error {
+		return paginate(c, "changes", configChanges.List(c.Query("kind")))
+	})
+}
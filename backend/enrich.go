@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/geoip"
+	"web-chatbot-backend/internal/session"
+	"web-chatbot-backend/internal/useragent"
+)
+
+// enrichSession records what we can learn about a new session's visitor
+// from the connection. The geo-IP lookup runs in the background so it
+// never delays the conversation; the first message may be forwarded
+// before it finishes.
+func enrichSession(sess *session.Session, ip, userAgent string) {
+	if sess.Device == nil && userAgent != "" {
+		if err := sessions.SetDevice(sess.ID, useragent.Parse(userAgent)); err != nil {
+			log.Error().Str("session_id", sess.ID).Err(err).Msg("Error storing device")
+		}
+	}
+	go locateSession(sess, ip)
+}
+
+func locateSession(sess *session.Session, ip string) {
+	if locator == nil || sess.Location != nil || !geoip.Public(ip) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	loc, err := lookupLocation(ctx, ip, countryOnly(sess.Tenant))
+	if err != nil {
+		log.Error().Str("session_id", sess.ID).Err(err).Msg("Error looking up location")
+		return
+	}
+	if err := sessions.SetLocation(sess.ID, loc); err != nil {
+		log.Error().Str("session_id", sess.ID).Err(err).Msg("Error storing location")
+	}
+}
+
+// countryOnly reports whether only the country of tenant's visitors is
+// kept: the tenant's own choice if it made one, else
+// CHATBOT_GEOIP_COUNTRY_ONLY.
+func countryOnly(tenant string) bool {
+	if t, ok := registeredTenant(tenant); ok && t.GeoIPCountryOnly != nil {
+		return *t.GeoIPCountryOnly
+	}
+	return serverConfig.GeoIP.CountryOnly
+}
+
+// countEntry is one row of an analytics breakdown.
+type countEntry struct {
+	Name     string `json:"name"`
+	Sessions int    `json:"sessions"`
+}
+
+// breakdown counts sessions by the key returned for each, skipping
+// sessions with an empty key, most common first.
+func breakdown(list []*session.Session, key func(*session.Session) string) []countEntry {
+	counts := make(map[string]int)
+	for _, s := range list {
+		if k := key(s); k != "" {
+			counts[k]++
+		}
+	}
+	out := make([]countEntry, 0, len(counts))
+	for name, n := range counts {
+		out = append(out, countEntry{Name: name, Sessions: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Sessions != out[j].Sessions {
+			return out[i].Sessions > out[j].Sessions
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
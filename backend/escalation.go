@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/actions"
+	"web-chatbot-backend/internal/alerts"
+	"web-chatbot-backend/internal/events"
+	"web-chatbot-backend/internal/session"
+	"web-chatbot-backend/internal/ticketing"
+)
+
+// Sessions already exported to the helpdesk
+var (
+	exportedTickets   = make(map[string]ticketing.Created)
+	exportedTicketsMu sync.Mutex
+)
+
+// escalateAction moves the conversation into the agent queue. Workflows
+// trigger it with {"action": "escalate"}, optionally with the skills the
+// agent needs as detected from the visitor's intent, e.g.
+// {"skills": ["billing"]}. The result tells the workflow whether any agent
+// is online, so it can offer an alternative out of hours.
+func escalateAction(ctx context.Context, call actions.Call) (map[string]interface{}, error) {
+	if call.SessionID == "" {
+		return nil, fmt.Errorf("escalation needs a session")
+	}
+	if list, ok := call.Params["skills"].([]interface{}); ok {
+		var skills []string
+		for _, v := range list {
+			if skill, ok := v.(string); ok {
+				skills = append(skills, skill)
+			}
+		}
+		if err := sessions.RequireSkills(call.SessionID, skills); err != nil {
+			return nil, err
+		}
+	}
+	if err := sessions.Transition(call.SessionID, session.StatusWaitingAgent); err != nil {
+		return nil, err
+	}
+	online := agentsOnline()
+	out := map[string]interface{}{"status": session.StatusWaitingAgent, "agents_online": online}
+	if !online {
+		out["message"] = serverConfig.Queue.NoAgentsMessage
+	}
+	return out, nil
+}
+
+// ticketingConfig sets up the helpdesk escalations are exported to.
+func ticketingConfig() ticketing.Config {
+	t := serverConfig.Ticketing
+	return ticketing.Config{
+		Provider:             t.Provider,
+		ZendeskSubdomain:     t.ZendeskSubdomain,
+		ZendeskEmail:         t.ZendeskEmail,
+		ZendeskAPIToken:      t.ZendeskAPIToken,
+		IntercomToken:        t.IntercomToken,
+		IntercomTicketTypeID: t.IntercomTicketTypeID,
+		URLTemplate:          t.URLTemplate,
+	}
+}
+
+// runEscalationExporter periodically hands unclaimed escalations to the
+// helpdesk until ctx is cancelled.
+func runEscalationExporter(ctx context.Context, connector ticketing.Connector, timeout, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			exportUnclaimedEscalations(ctx, connector, timeout)
+		}
+	}
+}
+
+// exportUnclaimedEscalations creates a ticket for every session that has
+// waited for an agent longer than timeout, and posts the ticket link into
+// the conversation.
+func exportUnclaimedEscalations(ctx context.Context, connector ticketing.Connector, timeout time.Duration) {
+	waiting := sessions.List(func(s *session.Session) bool {
+		return s.Status == session.StatusWaitingAgent && time.Since(s.StatusChangedAt) >= timeout
+	})
+	for _, sess := range waiting {
+		exportedTicketsMu.Lock()
+		_, done := exportedTickets[sess.ID]
+		exportedTicketsMu.Unlock()
+		if done {
+			continue
+		}
+
+		created, err := connector.CreateTicket(ctx, buildTicket(sess))
+		if err != nil {
+			log.Error().Str("connector", connector.Name()).Str("session_id", sess.ID).Err(err).Msg("Error creating ticket")
+			continue
+		}
+		exportedTicketsMu.Lock()
+		exportedTickets[sess.ID] = created
+		exportedTicketsMu.Unlock()
+
+		log.Info().Str("connector", connector.Name()).Str("ticket_id", created.ID).Str("session_id", sess.ID).Msg("Created ticket")
+		bus.Publish(events.Event{
+			Type:      "ticket_created",
+			SessionID: sess.ID,
+			Data:      map[string]any{"provider": connector.Name(), "ticket_id": created.ID, "url": created.URL},
+		})
+
+		text := fmt.Sprintf("All our agents are busy, so we've opened ticket #%s and will get back to you.", created.ID)
+		if created.URL != "" {
+			text += " " + created.URL
+		}
+		notifySession(sess.ID, text)
+	}
+}
+
+// buildTicket collects the transcript and contact details of a session.
+// Contact details come from the "name" and "email" session variables.
+func buildTicket(sess *session.Session) ticketing.Ticket {
+	t := ticketing.Ticket{
+		SessionID: sess.ID,
+		Subject:   "Chat escalation " + sess.ID,
+	}
+	if v, ok := sess.Memory["name"].(string); ok {
+		t.Name = v
+	}
+	if v, ok := sess.Memory["email"].(string); ok {
+		t.Email = v
+	}
+
+	history, _ := sessions.History(sess.ID)
+	var b strings.Builder
+	fmt.Fprintf(&b, "Session: %s\n", sess.ID)
+	if sess.VisitorID != "" {
+		fmt.Fprintf(&b, "Visitor: %s\n", sess.VisitorID)
+	}
+	b.WriteString("\n")
+	for _, m := range history {
+		fmt.Fprintf(&b, "[%s] %s: %s\n", m.Time.Format(time.RFC3339), m.Role, m.Text)
+	}
+	t.Transcript = b.String()
+	return t
+}
+
+// Whether the queue-length alert has fired; it re-arms once the queue
+// drops below the threshold again.
+var (
+	queueAlerted   bool
+	queueAlertedMu sync.Mutex
+)
+
+// alertOperators emails the operators when a conversation enters the
+// agent queue and when the queue grows past the alert threshold.
+func alertOperators(e events.Event) {
+	if !strings.HasPrefix(e.Type, "session_") {
+		return
+	}
+	if e.Type == "session_"+string(session.StatusWaitingAgent) && serverConfig.Alerts.OnEscalation {
+		operatorAlerts.Notify(alerts.Alert{
+			Subject: "Conversation waiting for an agent",
+			Body:    escalationSummary(e.SessionID),
+		})
+	}
+
+	if serverConfig.Alerts.QueueThreshold <= 0 {
+		return
+	}
+	waiting := len(sessions.List(func(s *session.Session) bool { return s.Status == session.StatusWaitingAgent }))
+	queueAlertedMu.Lock()
+	fire := waiting >= serverConfig.Alerts.QueueThreshold && !queueAlerted
+	queueAlerted = waiting >= serverConfig.Alerts.QueueThreshold
+	queueAlertedMu.Unlock()
+	if fire {
+		operatorAlerts.Notify(alerts.Alert{
+			Subject: fmt.Sprintf("%d conversations waiting for an agent", waiting),
+			Body:    fmt.Sprintf("The agent queue has reached %d conversations (alert threshold %d).", waiting, serverConfig.Alerts.QueueThreshold),
+		})
+	}
+}
+
+// escalationSummary describes an escalated session for an alert email.
+func escalationSummary(id string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Session: %s\n", id)
+	if sess, err := sessions.Get(id); err == nil && sess.VisitorID != "" {
+		fmt.Fprintf(&b, "Visitor: %s\n", sess.VisitorID)
+	}
+	if text := lastVisitorMessage(id); text != "" {
+		fmt.Fprintf(&b, "Last visitor message: %s\n", text)
+	}
+	return b.String()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/anonymize"
+	"web-chatbot-backend/internal/archive"
+	"web-chatbot-backend/internal/session"
+)
+
+var exportAnonymizer *anonymize.Anonymizer
+
+// newExportAnonymizer returns the anonymizer for exports. Without
+// CHATBOT_EXPORT_HASH_KEY a random key is used, so the same visitor gets
+// a different hash after the server restarts.
+func newExportAnonymizer() (*anonymize.Anonymizer, error) {
+	if serverConfig.Export.HashKey != "" {
+		return anonymize.New([]byte(serverConfig.Export.HashKey)), nil
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	log.Warn().Msg("CHATBOT_EXPORT_HASH_KEY is not set; anonymized visitor IDs will change on restart")
+	return anonymize.New(key), nil
+}
+
+// parseExportRange reads the optional from and to query parameters.
+func parseExportRange(c *fiber.Ctx) (from, to time.Time, err error) {
+	if v := c.Query("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			return
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		to, err = time.Parse(time.RFC3339, v)
+	}
+	return
+}
+
+// registerExportRoutes serves transcripts for analysis as JSON lines, one
+// session and its messages per line, like the retention archive. Test
+// chats are left out.
+func registerExportRoutes(admin fiber.Router) {
+	admin.Get("/analytics/export", func(c *fiber.Ctx) error {
+		from, to, err := parseExportRange(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "from and to must be RFC 3339 times"})
+		}
+		anonymized := serverConfig.Export.Anonymize || c.QueryBool("anonymize")
+		list := sessions.List(func(s *session.Session) bool {
+			return !s.Test && !s.CreatedAt.Before(from) && (to.IsZero() || s.CreatedAt.Before(to))
+		})
+		sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+
+		log.Info().Int("sessions", len(list)).Bool("anonymized", anonymized).Str("by", changedBy(c)).Msg("Exporting transcripts")
+		c.Set("Content-Type", "application/x-ndjson")
+		c.Set("Content-Disposition", `attachment; filename="transcripts.jsonl"`)
+		streamBody(c, func(w *bufio.Writer) {
+			enc := json.NewEncoder(w)
+			for _, s := range list {
+				history, err := sessions.History(s.ID)
+				if err != nil {
+					// Deleted since the export started
+					continue
+				}
+				record := archive.Record{Session: s, Messages: history}
+				if anonymized {
+					record = exportAnonymizer.Record(record)
+				}
+				if err := enc.Encode(record); err != nil {
+					return
+				}
+			}
+		})
+		return nil
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/config"
+	"web-chatbot-backend/internal/handoff"
+	"web-chatbot-backend/internal/session"
+)
+
+// supervisor is the link to the supervisor that started this process, nil
+// when it was started on its own.
+var supervisor *handoff.Worker
+
+// runSupervisor supervises workers instead of serving: the process holds
+// the listening sockets and runs the server as a worker on them, see
+// handoff.Supervisor.
+func runSupervisor(cfg config.Config) {
+	addrs := []string{fmt.Sprintf(":%d", cfg.Port)}
+	if cfg.Autocert.Enabled {
+		addrs = append(addrs, fmt.Sprintf(":%d", cfg.Autocert.TLSPort))
+	}
+	s := &handoff.Supervisor{Addrs: addrs, ReusePort: cfg.Handoff.ReusePort, ReadyTimeout: cfg.Handoff.ReadyTimeout}
+	if err := s.Run(); err != nil {
+		log.Fatal().Err(err).Msg("Supervisor stopped")
+	}
+	log.Info().Msg("Supervisor stopped")
+}
+
+// handOver passes the sessions to the worker replacing this one and
+// returns the resume tokens of connected visitors by session, or nil if
+// that failed.
+func handOver() map[string]string {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	state, tokens, err := exportHandoff()
+	if err == nil {
+		err = supervisor.HandOver(ctx, state)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error handing over sessions")
+		return nil
+	}
+	return tokens
+}
+
+// handoffState is what a worker hands over to the one replacing it.
+// ResumeTokens map the tokens given to connected visitors to their
+// sessions.
+type handoffState struct {
+	Sessions     []session.Handover `json:"sessions"`
+	ResumeTokens map[string]string  `json:"resume_tokens"`
+}
+
+// exportHandoff gives every connected visitor a resume token and returns
+// the state to hand over, along with the tokens by session.
+func exportHandoff() ([]byte, map[string]string, error) {
+	state := handoffState{Sessions: sessions.Export(), ResumeTokens: make(map[string]string)}
+	bySession := make(map[string]string)
+	for _, id := range visitorHub.SessionIDs() {
+		token := uuid.NewString()
+		state.ResumeTokens[token] = id
+		bySession[id] = token
+	}
+	body, err := json.Marshal(state)
+	return body, bySession, err
+}
+
+// importHandoff takes over the state of the worker this one replaces.
+func importHandoff(body []byte) error {
+	var state handoffState
+	if err := json.Unmarshal(body, &state); err != nil {
+		return err
+	}
+	n := sessions.Import(state.Sessions)
+	resumeTokens.add(state.ResumeTokens, serverConfig.Idle.Grace)
+	log.Info().Int("sessions", n).Int("resume_tokens", len(state.ResumeTokens)).Msg("Took over from the previous worker")
+	return nil
+}
+
+// resumeTokens are the tokens handed to visitors by the worker this one
+// replaced.
+var resumeTokens = &tokenSet{tokens: make(map[string]resumeToken)}
+
+type resumeToken struct {
+	sessionID string
+	expires   time.Time
+}
+
+type tokenSet struct {
+	mu     sync.Mutex
+	tokens map[string]resumeToken
+}
+
+func (t *tokenSet) add(tokens map[string]string, ttl time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	expires := time.Now().Add(ttl)
+	for token, id := range tokens {
+		t.tokens[token] = resumeToken{sessionID: id, expires: expires}
+	}
+}
+
+// take returns the session of a token and forgets the token; each can be
+// used once.
+func (t *tokenSet) take(token string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for k, v := range t.tokens {
+		if now.After(v.expires) {
+			delete(t.tokens, k)
+		}
+	}
+	rt, ok := t.tokens[token]
+	delete(t.tokens, token)
+	return rt.sessionID, ok
+}
+
+// requestedSession returns the session a connecting visitor asks to
+// resume: the one of their resume token, or the session_id they name.
+func requestedSession(sessionID, resumeToken string) string {
+	if resumeToken != "" {
+		if id, ok := resumeTokens.take(resumeToken); ok {
+			return id
+		}
+		log.Warn().Msg("Unknown or expired resume token")
+	}
+	return sessionID
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/backplane"
+	"web-chatbot-backend/internal/store"
+)
+
+// errNotConnected is returned when sending to a session nobody is
+// connected to.
+var errNotConnected = errors.New("not connected")
+
+// Errors writing to server-sent event streams
+var (
+	errStreamClosed = errors.New("stream closed")
+	errStreamFull   = errors.New("stream is not keeping up")
+)
+
+// Client is one connection, bound to a session: a WebSocket, or a
+// server-sent event stream when Conn is nil.
+type Client struct {
+	Conn      *websocket.Conn
+	SessionID string
+
+	// writeMu serialises writes; the idle reaper and the read loop may both
+	// write to the same connection.
+	writeMu sync.Mutex
+
+	// frames queues encoded frames for an event stream until done is
+	// closed, see newStreamClient.
+	frames    chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// ctx is cancelled when the client is closed, see bind.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// newClient creates the client for a WebSocket.
+func newClient(conn *websocket.Conn, sessionID string) *Client {
+	cl := &Client{Conn: conn, SessionID: sessionID}
+	cl.ctx, cl.cancel = context.WithCancel(context.Background())
+	return cl
+}
+
+// newStreamClient creates the client for a server-sent event stream; the
+// handler serving the stream writes out its frames.
+func newStreamClient(sessionID string) *Client {
+	cl := &Client{SessionID: sessionID, frames: make(chan []byte, 64), done: make(chan struct{})}
+	cl.ctx, cl.cancel = context.WithCancel(context.Background())
+	return cl
+}
+
+// bind returns a copy of ctx that is also cancelled when the client is
+// closed, so work done for the connection, such as a call to the bot,
+// stops once nobody is left to answer.
+func (cl *Client) bind(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	if cl.ctx == nil {
+		return ctx, cancel
+	}
+	stop := context.AfterFunc(cl.ctx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// WriteJSON sends v to the client as a JSON frame.
+func (cl *Client) WriteJSON(v interface{}) error {
+	if cl.Conn == nil {
+		body, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-cl.done:
+			return errStreamClosed
+		default:
+		}
+		select {
+		case cl.frames <- body:
+			return nil
+		default:
+			return errStreamFull
+		}
+	}
+	cl.writeMu.Lock()
+	defer cl.writeMu.Unlock()
+	return cl.Conn.WriteJSON(v)
+}
+
+// Close disconnects the client.
+func (cl *Client) Close() {
+	if cl.cancel != nil {
+		cl.cancel()
+	}
+	if cl.Conn != nil {
+		cl.Conn.Close()
+		return
+	}
+	cl.closeOnce.Do(func() { close(cl.done) })
+}
+
+// Disconnect sends frame, then closes the connection; WebSockets with a
+// close frame carrying code and reason.
+func (cl *Client) Disconnect(frame interface{}, code int, reason string) {
+	cl.WriteJSON(frame)
+	if cl.Conn != nil {
+		cl.writeMu.Lock()
+		cl.Conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+		cl.writeMu.Unlock()
+	}
+	cl.Close()
+}
+
+// Replace closes old, a connection another one took over the session
+// from, with a "replaced" frame so its page stops reconnecting by itself.
+func (cl *Client) Replace() {
+	cl.Disconnect(map[string]string{"type": "replaced"}, websocket.CloseNormalClosure, "replaced by a newer connection")
+}
+
+// Hub owns one kind of WebSocket connection, at most one per session. It
+// is safe for concurrent use; writes happen outside its lock so a slow
+// connection never holds up the others.
+type Hub struct {
+	name    string
+	mu      sync.RWMutex
+	clients map[string]*Client
+}
+
+// NewHub creates a hub; name tells its frames apart on the backplane.
+func NewHub(name string) *Hub {
+	return &Hub{name: name, clients: make(map[string]*Client)}
+}
+
+// Connected visitors and agent consoles, keyed by session ID
+var (
+	visitorHub = NewHub("visitor")
+	agentHub   = NewHub("agent")
+)
+
+// frameBackplane passes frames for connections this instance does not hold
+// to the other instances, see CHATBOT_BACKPLANE_URL. It is nil when running
+// a single instance.
+var frameBackplane backplane.Backplane
+
+// connectBackplane joins the configured backplane. It returns nil without
+// one.
+func connectBackplane(ctx context.Context) (backplane.Backplane, error) {
+	switch serverConfig.Backplane.Kind {
+	case "redis":
+		if serverConfig.Backplane.URL == "" {
+			return nil, nil
+		}
+		return backplane.NewRedis(ctx, serverConfig.Backplane.URL, serverConfig.Backplane.Channel)
+	case "postgres":
+		url := serverConfig.Backplane.URL
+		if url == "" && serverConfig.Store.Driver == store.Postgres {
+			url = serverConfig.Store.DSN
+		}
+		if url == "" {
+			return nil, errors.New("CHATBOT_BACKPLANE=postgres needs CHATBOT_BACKPLANE_URL or the Postgres message store")
+		}
+		return backplane.NewPostgres(ctx, url, serverConfig.Backplane.Channel)
+	}
+	return nil, fmt.Errorf("unknown backplane %q", serverConfig.Backplane.Kind)
+}
+
+// Register makes client the connection for its session and returns the
+// connection it replaced, if any. The other instances learn that this one
+// holds it, so they wait for it to confirm their frames, see SendTo.
+func (h *Hub) Register(client *Client) *Client {
+	h.mu.Lock()
+	old := h.clients[client.SessionID]
+	h.clients[client.SessionID] = client
+	h.mu.Unlock()
+	if frameBackplane != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), backplane.AckTimeout)
+		defer cancel()
+		if err := frameBackplane.Hold(ctx, h.name, client.SessionID); err != nil {
+			log.Warn().Str("session_id", client.SessionID).Err(err).Msg("Error announcing connection on the backplane")
+		}
+	}
+	return old
+}
+
+// Unregister removes client, unless another connection has replaced it
+// since.
+func (h *Hub) Unregister(client *Client) {
+	h.mu.Lock()
+	removed := h.clients[client.SessionID] == client
+	if removed {
+		delete(h.clients, client.SessionID)
+	}
+	h.mu.Unlock()
+	if removed && frameBackplane != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), backplane.AckTimeout)
+		defer cancel()
+		if err := frameBackplane.Release(ctx, h.name, client.SessionID); err != nil {
+			log.Warn().Str("session_id", client.SessionID).Err(err).Msg("Error releasing connection on the backplane")
+		}
+	}
+}
+
+// Get returns the connection for a session, or nil.
+func (h *Hub) Get(sessionID string) *Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.clients[sessionID]
+}
+
+// SendTo sends v to the connection for a session. If it is not connected
+// here, v is handed to the backplane for whichever instance holds it, and
+// errNotConnected is returned unless that instance confirms delivering it,
+// see backplane.AckTimeout. Sessions no instance holds get
+// errNotConnected without waiting. Use Post where the caller does not act on
+// whether v arrived.
+func (h *Hub) SendTo(sessionID string, v interface{}) error {
+	if client := h.Get(sessionID); client != nil {
+		return client.WriteJSON(v)
+	}
+	if frameBackplane == nil {
+		return errNotConnected
+	}
+	delivered, err := frameBackplane.Send(context.Background(), h.name, sessionID, v)
+	if err != nil {
+		return err
+	}
+	if !delivered {
+		return errNotConnected
+	}
+	return nil
+}
+
+// Post sends v to the connection for a session like SendTo, but without
+// waiting to hear whether another instance delivered it; errNotConnected
+// only means no instance could have.
+func (h *Hub) Post(sessionID string, v interface{}) error {
+	if client := h.Get(sessionID); client != nil {
+		return client.WriteJSON(v)
+	}
+	if frameBackplane == nil {
+		return errNotConnected
+	}
+	return frameBackplane.Publish(context.Background(), h.name, sessionID, v)
+}
+
+// Broadcast sends v to every connection, here and on the other instances,
+// and returns how many of the local ones it reached.
+func (h *Hub) Broadcast(v interface{}) int {
+	if frameBackplane != nil {
+		if err := frameBackplane.Publish(context.Background(), h.name, "", v); err != nil {
+			log.Error().Str("hub", h.name).Err(err).Msg("Error publishing broadcast")
+		}
+	}
+	return h.broadcastLocal(v)
+}
+
+func (h *Hub) broadcastLocal(v interface{}) int {
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.clients))
+	for _, client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mu.RUnlock()
+
+	sent := 0
+	for _, client := range clients {
+		if err := client.WriteJSON(v); err != nil {
+			log.Warn().Str("session_id", client.SessionID).Err(err).Msg("write error")
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+// deliver writes a frame from another instance to the local connection it
+// is meant for, if there is one, and reports whether it did.
+func (h *Hub) deliver(m backplane.Message) bool {
+	if m.SessionID == "" {
+		return h.broadcastLocal(m.Frame) > 0
+	}
+	client := h.Get(m.SessionID)
+	if client == nil {
+		return false
+	}
+	if err := client.WriteJSON(m.Frame); err != nil {
+		log.Warn().Err(err).Msg("write error")
+		return false
+	}
+	return true
+}
+
+// deliverFromBackplane routes frames from other instances to the hub they
+// belong to.
+func deliverFromBackplane(m backplane.Message) bool {
+	switch m.Hub {
+	case visitorHub.name:
+		return visitorHub.deliver(m)
+	case agentHub.name:
+		return agentHub.deliver(m)
+	}
+	return false
+}
+
+// CloseAll disconnects every local connection, see Client.Disconnect, and
+// returns how many there were.
+func (h *Hub) CloseAll(frame interface{}, code int, reason string) int {
+	return h.CloseEach(func(*Client) interface{} { return frame }, code, reason)
+}
+
+// CloseEach is CloseAll with a last frame made for each connection.
+func (h *Hub) CloseEach(frame func(*Client) interface{}, code int, reason string) int {
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.clients))
+	for _, client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mu.RUnlock()
+
+	for _, client := range clients {
+		client.Disconnect(frame(client), code, reason)
+	}
+	return len(clients)
+}
+
+// SessionIDs returns the sessions with a local connection.
+func (h *Hub) SessionIDs() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	ids := make([]string, 0, len(h.clients))
+	for id := range h.clients {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Len returns how many local connections there are.
+func (h *Hub) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/apikeys"
+	"web-chatbot-backend/internal/events"
+	"web-chatbot-backend/internal/session"
+	"web-chatbot-backend/internal/webpush"
+)
+
+// Longest message other systems may inject, in bytes
+const maxInjectedLength = 4000
+
+// injectMessage records a message from another system in a session as a
+// bot message and shows it to the visitor, live or by push if they have
+// left. It reports how the visitor was reached: "chat", "push" or "" if
+// the message only waits in the transcript.
+func injectMessage(sess *session.Session, source, from, text string) (string, error) {
+	msg, err := sessions.AppendMessage(sess.ID, session.RoleBot, text)
+	if err != nil {
+		return "", err
+	}
+	channel := ""
+	err = visitorHub.SendTo(sess.ID, fiber.Map{"type": "notification", "from": from, "message": text, "message_id": msg.ID})
+	switch {
+	case err == nil:
+		channel = "chat"
+		sendUnread(sess.ID)
+	case err != errNotConnected:
+		log.Warn().Err(err).Msg("write error")
+	}
+	if channel == "" {
+		title := "New message"
+		if from != "" {
+			title = "New message from " + from
+		}
+		if pushToVisitor(sess.VisitorID, webpush.Notification{Title: title, Body: text, URL: serverConfig.WebPush.ClickURL, Tag: sess.ID}) {
+			channel = "push"
+		}
+	}
+	bus.Publish(events.Event{
+		Type:      "message_injected",
+		SessionID: sess.ID,
+		Data:      map[string]any{"source": source, "from": from, "channel": channel},
+	})
+	return channel, nil
+}
+
+// registerInjectRoutes lets other systems, such as an order system or n8n
+// itself, post a message into a session, e.g. "your order has shipped".
+func registerInjectRoutes(app *fiber.App) {
+	app.Post("/sessions/:id/inject", requireAPIKey(apikeys.ScopeInject), func(c *fiber.Ctx) error {
+		var body struct {
+			Text string `json:"text"`
+			From string `json:"from"`
+		}
+		if !featureEnabled(featureInject) {
+			return c.Status(403).JSON(fiber.Map{"error": "Injecting messages is switched off"})
+		}
+		if err := c.BodyParser(&body); err != nil || body.Text == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "text is required"})
+		}
+		if len(body.Text) > maxInjectedLength {
+			return c.Status(413).JSON(fiber.Map{"error": "text is too long"})
+		}
+		sess, err := sessions.Get(c.Params("id"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		if sess.Status == session.StatusArchived {
+			return c.Status(409).JSON(fiber.Map{"error": "Session is archived"})
+		}
+		key, _ := c.Locals("api_key").(*apikeys.Key)
+		channel, err := injectMessage(sess, key.Name, body.From, body.Text)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(201).JSON(fiber.Map{"session_id": sess.ID, "delivered": channel})
+	})
+}
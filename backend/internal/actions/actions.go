@@ -0,0 +1,123 @@
+// Package actions executes action directives returned by the bot, such as
+// {"action":"create_ticket","params":{...}}, through registered handlers.
+package actions
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Directive asks the backend to run a named action.
+type Directive struct {
+	Action string                 `json:"action"`
+	Params map[string]interface{} `json:"params,omitempty"`
+}
+
+// Call is a directive together with the conversation it came from.
+type Call struct {
+	Directive
+	SessionID string
+	VisitorID string
+}
+
+// Result reports the outcome of an action back into the conversation.
+type Result struct {
+	Action string                 `json:"action"`
+	OK     bool                   `json:"ok"`
+	Output map[string]interface{} `json:"output,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+// Handler executes one kind of action.
+type Handler interface {
+	Execute(ctx context.Context, call Call) (map[string]interface{}, error)
+}
+
+// HandlerFunc adapts a function to a Handler.
+type HandlerFunc func(ctx context.Context, call Call) (map[string]interface{}, error)
+
+func (f HandlerFunc) Execute(ctx context.Context, call Call) (map[string]interface{}, error) {
+	return f(ctx, call)
+}
+
+// Checker is implemented by handlers that can check their configuration
+// without running, see Registry.Check.
+type Checker interface {
+	Check() error
+}
+
+// Registry maps action names to handlers.
+type Registry struct {
+	Timeout time.Duration
+	// Client, if set, makes the calls of the HTTP and lookup handlers
+	// loaded from a config file.
+	Client *http.Client
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+func NewRegistry(timeout time.Duration) *Registry {
+	return &Registry{Timeout: timeout, handlers: make(map[string]Handler)}
+}
+
+// Register adds or replaces the handler for an action name.
+func (r *Registry) Register(name string, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[name] = h
+}
+
+// Names lists the registered actions.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.handlers))
+	for name := range r.handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check checks every handler that is a Checker and returns the problems
+// found, by action name.
+func (r *Registry) Check() map[string]error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	problems := make(map[string]error)
+	for name, h := range r.handlers {
+		if c, ok := h.(Checker); ok {
+			if err := c.Check(); err != nil {
+				problems[name] = err
+			}
+		}
+	}
+	return problems
+}
+
+// Execute runs a call and always returns a result; failures are reported
+// in the result rather than as an error so they can be shown to the bot.
+func (r *Registry) Execute(ctx context.Context, call Call) Result {
+	r.mu.RLock()
+	h, ok := r.handlers[call.Action]
+	r.mu.RUnlock()
+	if !ok {
+		return Result{Action: call.Action, Error: fmt.Sprintf("unknown action %q", call.Action)}
+	}
+
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+	out, err := h.Execute(ctx, call)
+	if err != nil {
+		return Result{Action: call.Action, Output: out, Error: err.Error()}
+	}
+	return Result{Action: call.Action, OK: true, Output: out}
+}
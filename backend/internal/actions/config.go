@@ -0,0 +1,68 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+
+	"web-chatbot-backend/internal/filestore"
+)
+
+// Config describes one configured action handler.
+type Config struct {
+	Name string `json:"name"`
+	// Type is "http", "lookup" or "email".
+	Type string `json:"type"`
+
+	// HTTP actions (webhooks, CRM and ticketing APIs) and lookups
+	URL     string            `json:"url,omitempty"`
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Lookups: URL, headers and body are templates, see LookupHandler
+	Body          string      `json:"body,omitempty"`
+	Auth          *AuthConfig `json:"auth,omitempty"`
+	Reply         string      `json:"reply,omitempty"`
+	NotFoundReply string      `json:"not_found_reply,omitempty"`
+
+	// Email actions
+	SMTP *SMTPConfig `json:"smtp,omitempty"`
+}
+
+// LoadFile registers the handlers described in a JSON config file. A
+// missing file registers nothing.
+func (r *Registry) LoadFile(path string) error {
+	var configs []Config
+	if err := filestore.Load(path, &configs); err != nil {
+		return err
+	}
+	for _, cfg := range configs {
+		h, err := newHandler(cfg, r.Client)
+		if err != nil {
+			return fmt.Errorf("action %q: %w", cfg.Name, err)
+		}
+		r.Register(cfg.Name, h)
+	}
+	return nil
+}
+
+func newHandler(cfg Config, client *http.Client) (Handler, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("missing name")
+	}
+	switch cfg.Type {
+	case "http":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("missing url")
+		}
+		return &HTTPHandler{URL: cfg.URL, Method: cfg.Method, Headers: cfg.Headers, Client: client}, nil
+	case "lookup":
+		return newLookupHandler(cfg, client)
+	case "email":
+		if cfg.SMTP == nil || cfg.SMTP.Addr == "" || cfg.SMTP.From == "" {
+			return nil, fmt.Errorf("smtp addr and from are required")
+		}
+		return &EmailHandler{SMTP: *cfg.SMTP}, nil
+	default:
+		return nil, fmt.Errorf("unknown type %q", cfg.Type)
+	}
+}
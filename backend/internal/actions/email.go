@@ -0,0 +1,59 @@
+package actions
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// SMTPConfig is the mail server used by email actions.
+type SMTPConfig struct {
+	Addr     string `json:"addr"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	From     string `json:"from"`
+}
+
+// EmailHandler sends an email built from the params "to", "subject" and
+// "body".
+type EmailHandler struct {
+	SMTP SMTPConfig
+}
+
+func (h *EmailHandler) Execute(ctx context.Context, call Call) (map[string]interface{}, error) {
+	to, _ := call.Params["to"].(string)
+	subject, _ := call.Params["subject"].(string)
+	body, _ := call.Params["body"].(string)
+	if to == "" {
+		return nil, fmt.Errorf("missing param \"to\"")
+	}
+	if err := SendMail(h.SMTP, []string{to}, subject, body); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"to": to}, nil
+}
+
+// SendMail sends a plain-text email through the configured SMTP server.
+func SendMail(cfg SMTPConfig, to []string, subject, body string) error {
+	for _, addr := range to {
+		if strings.ContainsAny(addr, "\r\n") {
+			return fmt.Errorf("invalid recipient %q", addr)
+		}
+	}
+	subject = strings.NewReplacer("\r", " ", "\n", " ").Replace(subject)
+
+	msg := "From: " + cfg.From + "\r\n" +
+		"To: " + strings.Join(to, ", ") + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + body
+
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		host, _, _ := net.SplitHostPort(cfg.Addr)
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+	}
+	return smtp.SendMail(cfg.Addr, auth, cfg.From, to, []byte(msg))
+}
@@ -0,0 +1,78 @@
+package actions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// HTTPHandler sends the action params as a JSON body to an HTTP endpoint
+// and returns the decoded JSON response as output.
+type HTTPHandler struct {
+	URL     string
+	Method  string
+	Headers map[string]string
+	Client  *http.Client
+}
+
+// Check checks the endpoint is an http(s) URL.
+func (h *HTTPHandler) Check() error {
+	if u, err := url.Parse(h.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url %q is not an http(s) URL", h.URL)
+	}
+	return nil
+}
+
+func (h *HTTPHandler) Execute(ctx context.Context, call Call) (map[string]interface{}, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"action":     call.Action,
+		"params":     call.Params,
+		"session_id": call.SessionID,
+		"visitor_id": call.VisitorID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	method := h.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequestWithContext(ctx, method, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range h.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	out := map[string]interface{}{"status": resp.StatusCode}
+	var decoded map[string]interface{}
+	if json.Unmarshal(respBody, &decoded) == nil {
+		out["body"] = decoded
+	} else if len(respBody) > 0 {
+		out["body"] = string(respBody)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return out, fmt.Errorf("action endpoint responded with status %d", resp.StatusCode)
+	}
+	return out, nil
+}
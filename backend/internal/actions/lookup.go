@@ -0,0 +1,282 @@
+package actions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// AuthConfig adds credentials to lookup requests. Values may use
+// {{env "NAME"}} to read secrets from the environment, see EnvPrefix.
+type AuthConfig struct {
+	// Type is "bearer", "basic" or "header".
+	Type     string `json:"type"`
+	Token    string `json:"token,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Header is the header name for "header" auth, e.g. X-API-Key.
+	Header string `json:"header,omitempty"`
+}
+
+// LookupHandler calls an HTTP API built from templates and renders the
+// response into a reply. Templates use Go text/template syntax and see the
+// action params as fields, e.g. "https://shop.example.com/orders/{{.order_number}}".
+// Params are path-escaped in the URL template. The reply template sees
+// .params, .status and .body (the decoded JSON response).
+type LookupHandler struct {
+	Method        string
+	URL           *template.Template
+	Headers       map[string]*template.Template
+	Body          *template.Template
+	Auth          *AuthConfig
+	Reply         *template.Template
+	NotFoundReply string
+	Client        *http.Client
+}
+
+// EnvPrefix starts the names of the environment variables templates may
+// read, so an actions file cannot send the server's own secrets, such as
+// CHATBOT_ADMIN_TOKEN, to the APIs it calls.
+const EnvPrefix = "CHATBOT_ACTION_"
+
+// env is the template function reading secrets from the environment.
+func env(name string) (string, error) {
+	if !strings.HasPrefix(name, EnvPrefix) {
+		return "", fmt.Errorf("env %q: only variables starting with %s can be read", name, EnvPrefix)
+	}
+	return os.Getenv(name), nil
+}
+
+var templateFuncs = template.FuncMap{
+	"env":      env,
+	"urlquery": url.QueryEscape,
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+func render(t *template.Template, data interface{}) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func newLookupHandler(cfg Config, client *http.Client) (*LookupHandler, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("missing url")
+	}
+	h := &LookupHandler{
+		Method:        cfg.Method,
+		Headers:       make(map[string]*template.Template),
+		Auth:          cfg.Auth,
+		NotFoundReply: cfg.NotFoundReply,
+		Client:        client,
+	}
+	var err error
+	if h.URL, err = parseTemplate("url", cfg.URL); err != nil {
+		return nil, fmt.Errorf("url template: %w", err)
+	}
+	for k, v := range cfg.Headers {
+		if h.Headers[k], err = parseTemplate(k, v); err != nil {
+			return nil, fmt.Errorf("header %s template: %w", k, err)
+		}
+	}
+	if cfg.Body != "" {
+		if h.Body, err = parseTemplate("body", cfg.Body); err != nil {
+			return nil, fmt.Errorf("body template: %w", err)
+		}
+	}
+	if cfg.Reply != "" {
+		if h.Reply, err = parseTemplate("reply", cfg.Reply); err != nil {
+			return nil, fmt.Errorf("reply template: %w", err)
+		}
+	}
+	return h, nil
+}
+
+func (h *LookupHandler) Execute(ctx context.Context, call Call) (map[string]interface{}, error) {
+	params := map[string]interface{}{"session_id": call.SessionID, "visitor_id": call.VisitorID}
+	escaped := map[string]interface{}{"session_id": url.PathEscape(call.SessionID), "visitor_id": url.PathEscape(call.VisitorID)}
+	for k, v := range call.Params {
+		params[k] = v
+		escaped[k] = url.PathEscape(fmt.Sprint(v))
+	}
+
+	target, err := render(h.URL, escaped)
+	if err != nil {
+		return nil, err
+	}
+	var body io.Reader
+	if h.Body != nil {
+		rendered, err := render(h.Body, params)
+		if err != nil {
+			return nil, err
+		}
+		body = strings.NewReader(rendered)
+	}
+	method := h.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if h.Body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	for k, t := range h.Headers {
+		v, err := render(t, params)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(k, v)
+	}
+	if err := h.authorize(req); err != nil {
+		return nil, err
+	}
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	out := map[string]interface{}{"status": resp.StatusCode}
+	var decoded interface{}
+	if json.Unmarshal(respBody, &decoded) == nil {
+		out["body"] = decoded
+	} else {
+		out["body"] = string(bytes.TrimSpace(respBody))
+	}
+
+	if resp.StatusCode == http.StatusNotFound && h.NotFoundReply != "" {
+		out["message"] = h.NotFoundReply
+		return out, fmt.Errorf("lookup found nothing")
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return out, fmt.Errorf("lookup responded with status %d", resp.StatusCode)
+	}
+	if h.Reply != nil {
+		msg, err := render(h.Reply, map[string]interface{}{"params": params, "status": resp.StatusCode, "body": decoded})
+		if err != nil {
+			return out, fmt.Errorf("reply template: %w", err)
+		}
+		out["message"] = msg
+	}
+	return out, nil
+}
+
+// expandAuth renders one credential of an AuthConfig.
+func expandAuth(s string) (string, error) {
+	t, err := parseTemplate("auth", s)
+	if err != nil {
+		return "", err
+	}
+	return render(t, nil)
+}
+
+// Check renders the templates with placeholder params and checks they
+// make a request that could be sent: to an http(s) URL, with credentials
+// if the lookup has any.
+func (h *LookupHandler) Check() error {
+	sample := map[string]interface{}{"session_id": "session", "visitor_id": "visitor"}
+	target, err := render(h.URL, sample)
+	if err != nil {
+		return fmt.Errorf("url template: %w", err)
+	}
+	if u, err := url.Parse(target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url template gives %q, not an http(s) URL", target)
+	}
+	for k, t := range h.Headers {
+		if _, err := render(t, sample); err != nil {
+			return fmt.Errorf("header %s template: %w", k, err)
+		}
+	}
+	if h.Body != nil {
+		if _, err := render(h.Body, sample); err != nil {
+			return fmt.Errorf("body template: %w", err)
+		}
+	}
+	if h.Reply != nil {
+		if _, err := render(h.Reply, map[string]interface{}{"params": sample, "status": http.StatusOK, "body": map[string]interface{}{}}); err != nil {
+			return fmt.Errorf("reply template: %w", err)
+		}
+	}
+	if h.Auth == nil {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	if err := h.authorize(req); err != nil {
+		return fmt.Errorf("auth: %w", err)
+	}
+	credentials := []string{h.Auth.Token}
+	if h.Auth.Type == "basic" {
+		credentials = []string{h.Auth.Username, h.Auth.Password}
+	}
+	for _, c := range credentials {
+		if v, _ := expandAuth(c); v == "" {
+			return fmt.Errorf("auth: %s credentials are empty; is the environment variable set?", h.Auth.Type)
+		}
+	}
+	return nil
+}
+
+func (h *LookupHandler) authorize(req *http.Request) error {
+	if h.Auth == nil {
+		return nil
+	}
+	switch h.Auth.Type {
+	case "bearer":
+		token, err := expandAuth(h.Auth.Token)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case "basic":
+		user, err := expandAuth(h.Auth.Username)
+		if err != nil {
+			return err
+		}
+		pass, err := expandAuth(h.Auth.Password)
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(user, pass)
+	case "header":
+		token, err := expandAuth(h.Auth.Token)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(h.Auth.Header, token)
+	default:
+		return fmt.Errorf("unknown auth type %q", h.Auth.Type)
+	}
+	return nil
+}
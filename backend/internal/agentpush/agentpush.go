@@ -0,0 +1,206 @@
+// Package agentpush sends mobile push notifications to the agent app
+// through Firebase Cloud Messaging and the Apple Push Notification service.
+package agentpush
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/filestore"
+)
+
+// Platforms
+const (
+	FCM  = "fcm"
+	APNs = "apns"
+)
+
+// Kinds of notification, which agents can turn off individually.
+const (
+	KindQueued       = "queued"
+	KindVisitorReply = "visitor_reply"
+)
+
+// ErrInvalidToken is returned by senders when the device token is no
+// longer valid and should be removed.
+var ErrInvalidToken = errors.New("device token is no longer valid")
+
+// Device is a registered agent app installation.
+type Device struct {
+	Platform     string    `json:"platform"`
+	Token        string    `json:"token"`
+	RegisteredAt time.Time `json:"registered_at"`
+}
+
+// Preferences control which notifications an agent receives.
+type Preferences struct {
+	Queued       bool `json:"queued"`
+	VisitorReply bool `json:"visitor_replies"`
+}
+
+// DefaultPreferences apply to agents who have not set any.
+var DefaultPreferences = Preferences{Queued: true, VisitorReply: true}
+
+func (p Preferences) wants(kind string) bool {
+	switch kind {
+	case KindQueued:
+		return p.Queued
+	case KindVisitorReply:
+		return p.VisitorReply
+	}
+	return true
+}
+
+// Message is a notification to show on the device.
+type Message struct {
+	Kind  string
+	Title string
+	Body  string
+	// Data is passed to the app, e.g. the session ID to open.
+	Data map[string]string
+}
+
+// Sender delivers messages to one platform.
+type Sender interface {
+	Send(ctx context.Context, token string, msg Message) error
+}
+
+type agent struct {
+	Devices     []Device     `json:"devices"`
+	Preferences *Preferences `json:"preferences,omitempty"`
+}
+
+// Notifier keeps the agents' devices and preferences, saved to a JSON file
+// after every change, and fans messages out to their devices.
+type Notifier struct {
+	Senders map[string]Sender
+
+	mu     sync.Mutex
+	path   string
+	agents map[string]*agent
+}
+
+// NewNotifier loads devices from path.
+func NewNotifier(path string, senders map[string]Sender) (*Notifier, error) {
+	n := &Notifier{Senders: senders, path: path, agents: make(map[string]*agent)}
+	if err := filestore.Load(path, &n.agents); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Register adds a device for the agent. Registering a known token again
+// just refreshes it.
+func (n *Notifier) Register(agentID string, d Device) error {
+	if _, ok := n.Senders[d.Platform]; !ok {
+		return fmt.Errorf("push platform %q is not configured", d.Platform)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	a := n.agent(agentID)
+	d.RegisteredAt = time.Now()
+	for i := range a.Devices {
+		if a.Devices[i].Token == d.Token {
+			a.Devices[i] = d
+			return filestore.Save(n.path, n.agents)
+		}
+	}
+	a.Devices = append(a.Devices, d)
+	return filestore.Save(n.path, n.agents)
+}
+
+// Unregister removes a device token from the agent.
+func (n *Notifier) Unregister(agentID, token string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	a, ok := n.agents[agentID]
+	if !ok {
+		return nil
+	}
+	for i := range a.Devices {
+		if a.Devices[i].Token == token {
+			a.Devices = append(a.Devices[:i], a.Devices[i+1:]...)
+			return filestore.Save(n.path, n.agents)
+		}
+	}
+	return nil
+}
+
+// Devices returns the agent's registered devices.
+func (n *Notifier) Devices(agentID string) []Device {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if a, ok := n.agents[agentID]; ok {
+		return append([]Device(nil), a.Devices...)
+	}
+	return nil
+}
+
+// Preferences returns the agent's notification preferences.
+func (n *Notifier) Preferences(agentID string) Preferences {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if a, ok := n.agents[agentID]; ok && a.Preferences != nil {
+		return *a.Preferences
+	}
+	return DefaultPreferences
+}
+
+// SetPreferences replaces the agent's notification preferences.
+func (n *Notifier) SetPreferences(agentID string, p Preferences) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.agent(agentID).Preferences = &p
+	return filestore.Save(n.path, n.agents)
+}
+
+// Agents returns the IDs of all agents with registered devices.
+func (n *Notifier) Agents() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var ids []string
+	for id, a := range n.agents {
+		if len(a.Devices) > 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Notify sends msg to every device of the agent, unless they turned this
+// kind of notification off. Invalid tokens are removed.
+func (n *Notifier) Notify(ctx context.Context, agentID string, msg Message) {
+	if !n.Preferences(agentID).wants(msg.Kind) {
+		return
+	}
+	for _, d := range n.Devices(agentID) {
+		sender, ok := n.Senders[d.Platform]
+		if !ok {
+			continue
+		}
+		err := sender.Send(ctx, d.Token, msg)
+		if errors.Is(err, ErrInvalidToken) {
+			log.Warn().Str("platform", d.Platform).Str("agent", agentID).Msg("Removing invalid token")
+			if err := n.Unregister(agentID, d.Token); err != nil {
+				log.Error().Str("agent", agentID).Err(err).Msg("Error removing device")
+			}
+		} else if err != nil {
+			log.Error().Str("platform", d.Platform).Str("agent", agentID).Err(err).Msg("Error sending push")
+		}
+	}
+}
+
+// agent returns the record for id, creating it. The caller holds n.mu.
+func (n *Notifier) agent(id string) *agent {
+	a, ok := n.agents[id]
+	if !ok {
+		a = &agent{}
+		n.agents[id] = a
+	}
+	return a
+}
@@ -0,0 +1,142 @@
+package agentpush
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// APNsSender uses token-based authentication with a .p8 signing key.
+type APNsSender struct {
+	BaseURL string
+	KeyID   string
+	TeamID  string
+	// Topic is the agent app's bundle ID.
+	Topic  string
+	key    *ecdsa.PrivateKey
+	Client *http.Client
+
+	mu       sync.Mutex
+	jwt      string
+	issuedAt time.Time
+}
+
+// NewAPNsSender reads the .p8 key file. Set sandbox for development
+// builds of the app.
+func NewAPNsSender(keyFile, keyID, teamID, topic string, sandbox bool) (*APNsSender, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("apns key: no PEM block")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("apns key: %w", err)
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("apns key is not an EC key")
+	}
+	if keyID == "" || teamID == "" || topic == "" {
+		return nil, fmt.Errorf("apns needs a key ID, team ID and topic")
+	}
+	baseURL := "https://api.push.apple.com"
+	if sandbox {
+		baseURL = "https://api.sandbox.push.apple.com"
+	}
+	return &APNsSender{
+		BaseURL: baseURL,
+		KeyID:   keyID,
+		TeamID:  teamID,
+		Topic:   topic,
+		key:     key,
+		// APNs requires HTTP/2, which net/http negotiates over TLS
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (a *APNsSender) Send(ctx context.Context, token string, msg Message) error {
+	payload := map[string]interface{}{
+		"aps": map[string]interface{}{
+			"alert": map[string]string{"title": msg.Title, "body": msg.Body},
+			"sound": "default",
+		},
+	}
+	for k, v := range msg.Data {
+		payload[k] = v
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	auth, err := a.token()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.BaseURL+"/3/device/"+token, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("authorization", "bearer "+auth)
+	req.Header.Set("apns-topic", a.Topic)
+	req.Header.Set("apns-push-type", "alert")
+
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	var reason struct {
+		Reason string `json:"reason"`
+	}
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	json.Unmarshal(detail, &reason)
+	if resp.StatusCode == http.StatusGone || reason.Reason == "BadDeviceToken" || reason.Reason == "Unregistered" {
+		return ErrInvalidToken
+	}
+	return fmt.Errorf("apns responded with status %d: %s", resp.StatusCode, reason.Reason)
+}
+
+// token returns the provider JWT, reissued every 30 minutes since APNs
+// rejects tokens older than an hour.
+func (a *APNsSender) token() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.jwt != "" && time.Since(a.issuedAt) < 30*time.Minute {
+		return a.jwt, nil
+	}
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": a.KeyID})
+	claims, _ := json.Marshal(map[string]interface{}{"iss": a.TeamID, "iat": now.Unix()})
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, a.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	a.jwt = unsigned + "." + enc.EncodeToString(sig)
+	a.issuedAt = now
+	return a.jwt, nil
+}
@@ -0,0 +1,169 @@
+package agentpush
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FCMSender uses the FCM HTTP v1 API with a service account.
+type FCMSender struct {
+	ProjectID   string
+	ClientEmail string
+	TokenURI    string
+	key         *rsa.PrivateKey
+	Client      *http.Client
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+// NewFCMSender reads a Firebase service account JSON key file.
+func NewFCMSender(credentialsFile string) (*FCMSender, error) {
+	data, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	var creds struct {
+		ProjectID   string `json:"project_id"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("fcm credentials: %w", err)
+	}
+	block, _ := pem.Decode([]byte(creds.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("fcm credentials: no private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("fcm credentials: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("fcm credentials: private key is not RSA")
+	}
+	if creds.TokenURI == "" {
+		creds.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	return &FCMSender{
+		ProjectID:   creds.ProjectID,
+		ClientEmail: creds.ClientEmail,
+		TokenURI:    creds.TokenURI,
+		key:         key,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (f *FCMSender) Send(ctx context.Context, token string, msg Message) error {
+	access, err := f.token(ctx)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"message": map[string]interface{}{
+			"token":        token,
+			"notification": map[string]string{"title": msg.Title, "body": msg.Body},
+			"data":         msg.Data,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("https://fcm.googleapis.com/v1/projects/%s/messages:send", url.PathEscape(f.ProjectID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+access)
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode == http.StatusNotFound || strings.Contains(string(detail), "UNREGISTERED") {
+		return ErrInvalidToken
+	}
+	return fmt.Errorf("fcm responded with status %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+}
+
+// token returns an OAuth2 access token, exchanging a signed service
+// account assertion for a new one when the cached token is about to
+// expire.
+func (f *FCMSender) token(ctx context.Context) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.accessToken != "" && time.Until(f.expiresAt) > time.Minute {
+		return f.accessToken, nil
+	}
+
+	now := time.Now()
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   f.ClientEmail,
+		"scope": "https://www.googleapis.com/auth/firebase.messaging",
+		"aud":   f.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(nil, f.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + enc.EncodeToString(sig)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("fcm token exchange responded with status %d", resp.StatusCode)
+	}
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	f.accessToken = result.AccessToken
+	f.expiresAt = now.Add(time.Duration(result.ExpiresIn) * time.Second)
+	return f.accessToken, nil
+}
@@ -0,0 +1,171 @@
+// Package agents keeps the roster of human agents: how many conversations
+// each can handle at once, their skills and their presence.
+package agents
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"web-chatbot-backend/internal/filestore"
+)
+
+var (
+	ErrNotFound      = errors.New("agent not found")
+	ErrInvalidStatus = errors.New("status must be online, away, busy or offline")
+)
+
+// Status is an agent's presence.
+type Status string
+
+const (
+	// Online agents take new conversations.
+	Online Status = "online"
+	// Away and busy agents are logged in but take no new conversations.
+	Away Status = "away"
+	Busy Status = "busy"
+	// Offline agents are logged out.
+	Offline Status = "offline"
+)
+
+// Valid reports whether s is a known status.
+func (s Status) Valid() bool {
+	switch s {
+	case Online, Away, Busy, Offline:
+		return true
+	}
+	return false
+}
+
+// Agent is one member of the support team.
+type Agent struct {
+	Name string `json:"name"`
+	// MaxChats is how many conversations the agent handles at once; zero
+	// means the deployment default.
+	MaxChats int      `json:"max_chats,omitempty"`
+	Skills   []string `json:"skills,omitempty"`
+	Status   Status   `json:"status"`
+	// StatusChangedAt is when the agent last changed their presence.
+	StatusChangedAt time.Time `json:"status_changed_at,omitempty"`
+}
+
+// Available reports whether the agent takes new conversations.
+func (a Agent) Available() bool {
+	return a.Status == Online
+}
+
+// HasSkill reports whether the agent has the given skill.
+func (a Agent) HasSkill(skill string) bool {
+	for _, s := range a.Skills {
+		if s == skill {
+			return true
+		}
+	}
+	return false
+}
+
+// Store holds the roster, saved to a JSON file after every change.
+type Store struct {
+	mu     sync.Mutex
+	path   string
+	agents map[string]Agent
+}
+
+// NewStore loads the roster from path.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path, agents: make(map[string]Agent)}
+	if err := filestore.Load(path, &s.agents); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Put adds or replaces an agent. New agents start offline.
+func (s *Store) Put(a Agent) error {
+	if a.Status == "" {
+		a.Status = Offline
+	}
+	if !a.Status.Valid() {
+		return ErrInvalidStatus
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.agents[a.Name]; !ok || old.Status != a.Status {
+		a.StatusChangedAt = time.Now()
+	} else {
+		a.StatusChangedAt = old.StatusChangedAt
+	}
+	s.agents[a.Name] = a
+	return filestore.Save(s.path, s.agents)
+}
+
+// SetPresence changes an agent's status, and their concurrent chat limit
+// if maxChats is not negative, adding them to the roster on first login.
+// It returns the agent and their previous status.
+func (s *Store) SetPresence(name string, status Status, maxChats int) (Agent, Status, error) {
+	if !status.Valid() {
+		return Agent{}, "", ErrInvalidStatus
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.agents[name]
+	if !ok {
+		a = Agent{Name: name, Status: Offline}
+	}
+	from := a.Status
+	if from != status {
+		a.Status = status
+		a.StatusChangedAt = time.Now()
+	}
+	if maxChats >= 0 {
+		a.MaxChats = maxChats
+	}
+	s.agents[name] = a
+	return a, from, filestore.Save(s.path, s.agents)
+}
+
+// Online returns the agents taking new conversations.
+func (s *Store) Online() []Agent {
+	var out []Agent
+	for _, a := range s.List() {
+		if a.Available() {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// Get returns one agent.
+func (s *Store) Get(name string) (Agent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.agents[name]
+	if !ok {
+		return Agent{}, ErrNotFound
+	}
+	return a, nil
+}
+
+// Delete removes an agent from the roster.
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.agents[name]; !ok {
+		return ErrNotFound
+	}
+	delete(s.agents, name)
+	return filestore.Save(s.path, s.agents)
+}
+
+// List returns all agents sorted by name.
+func (s *Store) List() []Agent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Agent, 0, len(s.agents))
+	for _, a := range s.agents {
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
@@ -0,0 +1,99 @@
+// Package alerts emails operators about things that need a human, either
+// one message per alert or batched into periodic digests.
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Alert is one notification for operators.
+type Alert struct {
+	Subject string
+	Body    string
+	Time    time.Time
+}
+
+// SendFunc delivers one email to the operators.
+type SendFunc func(subject, body string) error
+
+// Mailer sends alerts through Send. With a zero Digest interval every
+// alert is sent right away; otherwise alerts are collected and sent as one
+// digest per interval by Run.
+type Mailer struct {
+	Send   SendFunc
+	Digest time.Duration
+
+	mu      sync.Mutex
+	pending []Alert
+}
+
+// Notify sends or queues an alert. It never blocks on the mail server.
+func (m *Mailer) Notify(a Alert) {
+	if a.Time.IsZero() {
+		a.Time = time.Now()
+	}
+	if m.Digest <= 0 {
+		go func() {
+			if err := m.Send(a.Subject, a.Body); err != nil {
+				log.Error().Str("subject", a.Subject).Err(err).Msg("Error sending operator alert")
+			}
+		}()
+		return
+	}
+	m.mu.Lock()
+	m.pending = append(m.pending, a)
+	m.mu.Unlock()
+}
+
+// Run sends a digest of the queued alerts every Digest interval until ctx
+// is cancelled.
+func (m *Mailer) Run(ctx context.Context) {
+	if m.Digest <= 0 {
+		return
+	}
+	ticker := time.NewTicker(m.Digest)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.flush()
+		}
+	}
+}
+
+func (m *Mailer) flush() {
+	m.mu.Lock()
+	batch := m.pending
+	m.pending = nil
+	m.mu.Unlock()
+
+	switch len(batch) {
+	case 0:
+		return
+	case 1:
+		if err := m.Send(batch[0].Subject, batch[0].Body); err != nil {
+			log.Error().Str("subject", batch[0].Subject).Err(err).Msg("Error sending operator alert")
+		}
+		return
+	}
+
+	var b strings.Builder
+	for i, a := range batch {
+		if i > 0 {
+			b.WriteString("\n----\n\n")
+		}
+		fmt.Fprintf(&b, "[%s] %s\n\n%s\n", a.Time.Format("15:04:05"), a.Subject, a.Body)
+	}
+	subject := fmt.Sprintf("%d chatbot alerts", len(batch))
+	if err := m.Send(subject, b.String()); err != nil {
+		log.Error().Err(err).Msg("Error sending operator alert digest")
+	}
+}
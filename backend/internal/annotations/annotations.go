@@ -0,0 +1,213 @@
+// Package annotations keeps the labels reviewers put on messages: the
+// visitor's intent, how good the bot's answer was and what it should have
+// said. Exported, they are training and evaluation data for the workflow.
+package annotations
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"web-chatbot-backend/internal/filestore"
+	"web-chatbot-backend/internal/session"
+)
+
+var (
+	ErrNotFound       = errors.New("annotation not found")
+	ErrEmpty          = errors.New("an annotation needs an intent, quality, correction or tags")
+	ErrQuality        = errors.New("quality must be between 1 and 5")
+	ErrCorrectionRole = errors.New("only bot messages can have a correction")
+)
+
+// Quality scores run from MinQuality, unusable, to MaxQuality, perfect.
+const (
+	MinQuality = 1
+	MaxQuality = 5
+)
+
+// Labels are what a reviewer says about a message.
+type Labels struct {
+	Intent  string `json:"intent,omitempty"`
+	Quality *int   `json:"quality,omitempty"`
+	// Correction is the answer the bot should have given.
+	Correction string   `json:"correction,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	Note       string   `json:"note,omitempty"`
+}
+
+// Annotation is one reviewer's labels on one message. The message is
+// copied, so the annotation outlives the transcript.
+type Annotation struct {
+	ID        string          `json:"id"`
+	SessionID string          `json:"session_id"`
+	Index     int             `json:"index"`
+	Message   session.Message `json:"message"`
+	// Prompt is the visitor message a bot or agent message answered.
+	Prompt    string    `json:"prompt,omitempty"`
+	Reviewer  string    `json:"reviewer,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Labels
+}
+
+// Filter picks annotations; empty fields match any.
+type Filter struct {
+	SessionID  string
+	Reviewer   string
+	Intent     string
+	Tag        string
+	MinQuality int
+	MaxQuality int
+}
+
+func (f Filter) match(a Annotation) bool {
+	if f.SessionID != "" && a.SessionID != f.SessionID {
+		return false
+	}
+	if f.Reviewer != "" && a.Reviewer != f.Reviewer {
+		return false
+	}
+	if f.Intent != "" && a.Intent != f.Intent {
+		return false
+	}
+	if f.Tag != "" && !contains(a.Tags, f.Tag) {
+		return false
+	}
+	if f.MinQuality > 0 && (a.Quality == nil || *a.Quality < f.MinQuality) {
+		return false
+	}
+	if f.MaxQuality > 0 && (a.Quality == nil || *a.Quality > f.MaxQuality) {
+		return false
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Store holds annotations, saved to a JSON file after every change.
+type Store struct {
+	mu          sync.Mutex
+	path        string
+	annotations map[string]Annotation
+}
+
+// NewStore loads annotations from path.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path, annotations: make(map[string]Annotation)}
+	if err := filestore.Load(path, &s.annotations); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Validate checks labels for a message with role.
+func (l *Labels) Validate(role string) error {
+	l.Intent = strings.TrimSpace(l.Intent)
+	l.Correction = strings.TrimSpace(l.Correction)
+	if l.Intent == "" && l.Quality == nil && l.Correction == "" && len(l.Tags) == 0 {
+		return ErrEmpty
+	}
+	if l.Quality != nil && (*l.Quality < MinQuality || *l.Quality > MaxQuality) {
+		return ErrQuality
+	}
+	if l.Correction != "" && role != session.RoleBot {
+		return ErrCorrectionRole
+	}
+	return nil
+}
+
+// Put labels the message at index in a session's history for reviewer,
+// replacing the labels they gave it before. It reports whether the
+// annotation is new.
+func (s *Store) Put(sessionID string, index int, history []session.Message, reviewer string, labels Labels) (Annotation, bool, error) {
+	msg := history[index]
+	if err := labels.Validate(msg.Role); err != nil {
+		return Annotation{}, false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	a, exists := s.find(sessionID, index, reviewer)
+	if !exists {
+		a = Annotation{
+			ID:        uuid.NewString(),
+			SessionID: sessionID,
+			Index:     index,
+			Reviewer:  reviewer,
+			CreatedAt: now,
+		}
+	}
+	a.Message = msg
+	a.Prompt = prompt(history, index)
+	a.Labels = labels
+	a.UpdatedAt = now
+	s.annotations[a.ID] = a
+	return a, !exists, filestore.Save(s.path, s.annotations)
+}
+
+// find returns the annotation reviewer made on a message. Callers hold
+// s.mu.
+func (s *Store) find(sessionID string, index int, reviewer string) (Annotation, bool) {
+	for _, a := range s.annotations {
+		if a.SessionID == sessionID && a.Index == index && a.Reviewer == reviewer {
+			return a, true
+		}
+	}
+	return Annotation{}, false
+}
+
+// prompt returns the visitor message the bot or agent message at index
+// answered.
+func prompt(history []session.Message, index int) string {
+	if role := history[index].Role; role != session.RoleBot && role != session.RoleAgent {
+		return ""
+	}
+	for i := index - 1; i >= 0; i-- {
+		if history[i].Role == session.RoleVisitor {
+			return history[i].Text
+		}
+	}
+	return ""
+}
+
+// Remove deletes an annotation.
+func (s *Store) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.annotations[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.annotations, id)
+	return filestore.Save(s.path, s.annotations)
+}
+
+// List returns the annotations f matches, oldest message first.
+func (s *Store) List(f Filter) []Annotation {
+	s.mu.Lock()
+	out := make([]Annotation, 0)
+	for _, a := range s.annotations {
+		if f.match(a) {
+			out = append(out, a)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].Message.Time.Equal(out[j].Message.Time) {
+			return out[i].Message.Time.Before(out[j].Message.Time)
+		}
+		return out[i].CreatedAt.Before(out[j].CreatedAt)
+	})
+	return out
+}
@@ -0,0 +1,65 @@
+// Package anonymize prepares transcripts for sharing outside the company:
+// visitor identifiers are replaced by keyed hashes and personal data is
+// masked in message text.
+package anonymize
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"web-chatbot-backend/internal/archive"
+	"web-chatbot-backend/internal/redact"
+	"web-chatbot-backend/internal/session"
+)
+
+// Anonymizer hashes identifiers with a secret key, so the same visitor
+// gets the same hash in every export made with the key, but the hash
+// cannot be traced back to the visitor without it.
+type Anonymizer struct {
+	key []byte
+}
+
+func New(key []byte) *Anonymizer {
+	return &Anonymizer{key: key}
+}
+
+// ID returns the hash standing in for id. Empty IDs stay empty.
+func (a *Anonymizer) ID(id string) string {
+	if id == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(id))
+	return "anon_" + hex.EncodeToString(mac.Sum(nil))[:24]
+}
+
+// Record returns an anonymized copy of r. The visitor ID is hashed;
+// what the visitor signed in with, what the bot remembered and where
+// they shared their position are left out; the city they connected from
+// is dropped, keeping the country and region; and personal data is
+// masked in the messages and summary.
+func (a *Anonymizer) Record(r archive.Record) archive.Record {
+	s := *r.Session
+	s.VisitorID = a.ID(s.VisitorID)
+	s.User = nil
+	s.Memory = nil
+	s.SharedLocation = nil
+	if s.Location != nil {
+		loc := *s.Location
+		loc.City = ""
+		s.Location = &loc
+	}
+	if s.Summary != nil {
+		summary := *s.Summary
+		summary.Text = redact.Text(summary.Text)
+		s.Summary = &summary
+	}
+
+	messages := make([]session.Message, len(r.Messages))
+	for i, m := range r.Messages {
+		m.Text = redact.Text(m.Text)
+		messages[i] = m
+	}
+	return archive.Record{Session: &s, Messages: messages}
+}
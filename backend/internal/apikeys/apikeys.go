@@ -0,0 +1,444 @@
+// Package apikeys issues and checks the API keys other backends use to
+// call the chat API. Only a SHA-256 hash of each key is stored; the key
+// itself is shown once, when it is created. Keys are kept in the message
+// store database when there is one, so every instance sees them, and in a
+// JSON file otherwise.
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/filestore"
+	"web-chatbot-backend/internal/store"
+)
+
+var (
+	ErrNotFound     = errors.New("API key not found")
+	ErrInvalid      = errors.New("invalid API key")
+	ErrRevoked      = errors.New("API key has been revoked")
+	ErrNoName       = errors.New("API key name is required")
+	ErrNoScopes     = errors.New("API key needs at least one scope")
+	ErrUnknownScope = errors.New("unknown scope")
+)
+
+// Scopes a key can be given.
+const (
+	// ScopeChat allows sending messages, POST /chat and /chat/batch.
+	ScopeChat = "chat"
+	// ScopeMessages allows reading session history.
+	ScopeMessages = "messages"
+	// ScopeReminders allows scheduling and cancelling reminders.
+	ScopeReminders = "reminders"
+	// ScopeVisitors allows merging visitor profiles.
+	ScopeVisitors = "visitors"
+	// ScopeInject allows posting messages into sessions, e.g. order
+	// updates.
+	ScopeInject = "inject"
+)
+
+// Scopes lists every scope.
+var Scopes = []string{ScopeChat, ScopeMessages, ScopeReminders, ScopeVisitors, ScopeInject}
+
+// Prefix starts every key, so leaked keys are easy to search for.
+const Prefix = "cbk_"
+
+// How often the last use of a key is saved
+const usageSaveInterval = time.Minute
+
+// Key describes an API key. It has the fields of store.APIKey, so one
+// converts to the other.
+type Key struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// Tenant, if set, is the tenant every request with the key is for.
+	Tenant string `json:"tenant,omitempty"`
+	// Hint is the start of the key, to tell keys apart.
+	Hint       string     `json:"hint"`
+	Hash       string     `json:"hash"`
+	CreatedAt  time.Time  `json:"created_at"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	RotatedAt  *time.Time `json:"rotated_at,omitempty"`
+	// PreviousHash is the key before the last rotation, which keeps
+	// working until PreviousExpiresAt.
+	PreviousHash      string     `json:"previous_hash,omitempty"`
+	PreviousExpiresAt *time.Time `json:"previous_expires_at,omitempty"`
+}
+
+// Allows reports whether the key has scope.
+func (k *Key) Allows(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+func (k *Key) clone() *Key {
+	c := *k
+	c.Scopes = append([]string(nil), k.Scopes...)
+	return &c
+}
+
+// Store holds API keys in memory and saves each change to the database,
+// or, without one, to a JSON file when a path is set.
+type Store struct {
+	mu   sync.Mutex
+	path string
+	db   *store.Store
+	keys map[string]*Key
+	// byHash has each key under its hash and any previous one
+	byHash map[string]*Key
+	// usageSavedAt is when the last use of each key was last saved
+	usageSavedAt map[string]time.Time
+}
+
+// NewStore loads keys from path. An empty path keeps them in memory only.
+func NewStore(path string) (*Store, error) {
+	s := newStore()
+	s.path = path
+	keys := make(map[string]*Key)
+	if path != "" {
+		if err := filestore.Load(path, &keys); err != nil {
+			return nil, err
+		}
+	}
+	s.set(keys)
+	return s, nil
+}
+
+// OpenDB loads keys from the api_keys table of db. Keys in the JSON file
+// at path, where they were kept before, are copied into the table the
+// first time, and the file renamed to path + ".migrated".
+func OpenDB(ctx context.Context, db *store.Store, path string) (*Store, error) {
+	s := newStore()
+	s.db = db
+	if err := s.migrate(ctx, path); err != nil {
+		return nil, fmt.Errorf("moving %s into the database: %w", path, err)
+	}
+	return s, s.Reload(ctx)
+}
+
+func newStore() *Store {
+	return &Store{keys: make(map[string]*Key), byHash: make(map[string]*Key), usageSavedAt: make(map[string]time.Time)}
+}
+
+// migrate copies the keys of the JSON file at path into the database,
+// keeping any with the same ID there already.
+func (s *Store) migrate(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	var keys map[string]*Key
+	if err := filestore.Load(path, &keys); err != nil {
+		return err
+	}
+	existing, err := s.db.APIKeys(ctx)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(existing))
+	for _, k := range existing {
+		known[k.ID] = true
+	}
+	moved := 0
+	for _, k := range keys {
+		if known[k.ID] {
+			continue
+		}
+		if err := s.db.PutAPIKey(ctx, store.APIKey(*k)); err != nil {
+			return err
+		}
+		moved++
+	}
+	if err := os.Rename(path, path+".migrated"); err != nil {
+		return err
+	}
+	log.Info().Int("api_keys", moved).Str("file", path).Msg("Moved API keys into the database")
+	return nil
+}
+
+// Reload reads every key from the database again, keeping any later use
+// of a key seen here but not saved yet.
+func (s *Store) Reload(ctx context.Context) error {
+	list, err := s.db.APIKeys(ctx)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]*Key, len(list))
+	for _, sk := range list {
+		k := Key(sk)
+		keys[k.ID] = &k
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, k := range keys {
+		if old, ok := s.keys[id]; ok && old.LastUsedAt != nil && (k.LastUsedAt == nil || old.LastUsedAt.After(*k.LastUsedAt)) {
+			k.LastUsedAt = old.LastUsedAt
+		}
+	}
+	s.set(keys)
+	return nil
+}
+
+// Run reloads the keys every interval until ctx is cancelled, picking up
+// keys issued, rotated or revoked through other instances.
+func (s *Store) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Reload(ctx); err != nil {
+				log.Error().Err(err).Msg("Error reloading API keys")
+			}
+		}
+	}
+}
+
+// set replaces the keys. Callers hold s.mu, or own s.
+func (s *Store) set(keys map[string]*Key) {
+	s.keys = keys
+	s.byHash = make(map[string]*Key, len(keys))
+	for _, k := range keys {
+		s.byHash[k.Hash] = k
+		if k.PreviousHash != "" {
+			s.byHash[k.PreviousHash] = k
+		}
+	}
+}
+
+// Create issues a key and returns it along with the secret to hand to the
+// caller, which cannot be recovered later.
+func (s *Store) Create(name string, scopes []string, tenant, by string) (*Key, string, error) {
+	if name == "" {
+		return nil, "", ErrNoName
+	}
+	if err := checkScopes(scopes); err != nil {
+		return nil, "", err
+	}
+	secret, err := newSecret()
+	if err != nil {
+		return nil, "", err
+	}
+	k := &Key{
+		ID:        uuid.NewString(),
+		Name:      name,
+		Scopes:    append([]string(nil), scopes...),
+		Tenant:    tenant,
+		Hint:      secret[:len(Prefix)+6],
+		Hash:      hash(secret),
+		CreatedAt: time.Now(),
+		CreatedBy: by,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[k.ID] = k
+	s.byHash[k.Hash] = k
+	if err := s.save(k); err != nil {
+		delete(s.keys, k.ID)
+		delete(s.byHash, k.Hash)
+		return nil, "", err
+	}
+	return k.clone(), secret, nil
+}
+
+// List returns every key, newest first.
+func (s *Store) List() []*Key {
+	s.mu.Lock()
+	out := make([]*Key, 0, len(s.keys))
+	for _, k := range s.keys {
+		out = append(out, k.clone())
+	}
+	s.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out
+}
+
+// Get returns a copy of one key.
+func (s *Store) Get(id string) (*Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return k.clone(), nil
+}
+
+// Revoke stops a key from working. Revoking it again has no effect.
+func (s *Store) Revoke(id string) (*Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if k.RevokedAt == nil {
+		now := time.Now()
+		k.RevokedAt = &now
+		if err := s.save(k); err != nil {
+			return nil, err
+		}
+	}
+	return k.clone(), nil
+}
+
+// Update renames a key or changes its scopes. An empty name or nil scopes
+// are left as they are.
+func (s *Store) Update(id, name string, scopes []string) (*Key, error) {
+	if scopes != nil {
+		if err := checkScopes(scopes); err != nil {
+			return nil, err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if k.RevokedAt != nil {
+		return nil, ErrRevoked
+	}
+	if name != "" {
+		k.Name = name
+	}
+	if scopes != nil {
+		k.Scopes = append([]string(nil), scopes...)
+	}
+	if err := s.save(k); err != nil {
+		return nil, err
+	}
+	return k.clone(), nil
+}
+
+// Rotate replaces a key's secret and returns the new one. The old secret
+// keeps working for grace, so callers can switch over without downtime;
+// with no grace it stops at once. A key rotated again during its grace
+// period loses the older secret straight away.
+func (s *Store) Rotate(id string, grace time.Duration) (*Key, string, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return nil, "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return nil, "", ErrNotFound
+	}
+	if k.RevokedAt != nil {
+		return nil, "", ErrRevoked
+	}
+	now := time.Now()
+	if k.PreviousHash != "" {
+		delete(s.byHash, k.PreviousHash)
+	}
+	k.PreviousHash, k.PreviousExpiresAt = "", nil
+	if grace > 0 {
+		expires := now.Add(grace)
+		k.PreviousHash, k.PreviousExpiresAt = k.Hash, &expires
+	} else {
+		delete(s.byHash, k.Hash)
+	}
+	k.Hint = secret[:len(Prefix)+6]
+	k.Hash = hash(secret)
+	k.RotatedAt = &now
+	s.byHash[k.Hash] = k
+	if err := s.save(k); err != nil {
+		return nil, "", err
+	}
+	return k.clone(), secret, nil
+}
+
+// Authenticate returns the key secret belongs to and notes that it was
+// used.
+func (s *Store) Authenticate(secret string) (*Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := hash(secret)
+	k, ok := s.byHash[h]
+	if !ok {
+		return nil, ErrInvalid
+	}
+	if k.RevokedAt != nil {
+		return nil, ErrRevoked
+	}
+	now := time.Now()
+	if h == k.PreviousHash && !now.Before(*k.PreviousExpiresAt) {
+		delete(s.byHash, h)
+		k.PreviousHash, k.PreviousExpiresAt = "", nil
+		s.saveLogged(k)
+		return nil, ErrInvalid
+	}
+	k.LastUsedAt = &now
+	// Busy keys would otherwise be saved on every request
+	if now.Sub(s.usageSavedAt[k.ID]) >= usageSaveInterval {
+		s.saveLogged(k)
+	}
+	return k.clone(), nil
+}
+
+// checkScopes reports an error unless scopes names at least one scope,
+// all of them known.
+func checkScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return ErrNoScopes
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return fmt.Errorf("%w %q", ErrUnknownScope, scope)
+		}
+	}
+	return nil
+}
+
+// newSecret returns a new random key.
+func newSecret() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return Prefix + hex.EncodeToString(raw), nil
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// save writes k to the database, or every key to the file. Callers hold
+// s.mu.
+func (s *Store) save(k *Key) error {
+	s.usageSavedAt[k.ID] = time.Now()
+	switch {
+	case s.db != nil:
+		return s.db.PutAPIKey(context.Background(), store.APIKey(*k))
+	case s.path != "":
+		return filestore.Save(s.path, s.keys)
+	}
+	return nil
+}
+
+// saveLogged saves k for Authenticate, whose callers only care about the
+// key.
+func (s *Store) saveLogged(k *Key) {
+	if err := s.save(k); err != nil {
+		log.Error().Str("api_key", k.ID).Err(err).Msg("Error saving API key")
+	}
+}
@@ -0,0 +1,44 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"web-chatbot-backend/internal/session"
+)
+
+// Record is one line of an export: a session and its transcript.
+type Record struct {
+	Session  *session.Session  `json:"session"`
+	Messages []session.Message `json:"messages"`
+}
+
+// Store is where exports are written.
+type Store interface {
+	Put(ctx context.Context, key string, body []byte, contentType string) error
+}
+
+// Export writes records as one gzip-compressed JSONL object under prefix,
+// named by date, and returns its key.
+func Export(ctx context.Context, store Store, prefix string, records []Record, now time.Time) (string, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return "", err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	key := fmt.Sprintf("%s%s/transcripts-%s.jsonl.gz", prefix, now.UTC().Format("2006/01/02"), now.UTC().Format("150405.000000000"))
+	if err := store.Put(ctx, key, buf.Bytes(), "application/gzip"); err != nil {
+		return "", err
+	}
+	return key, nil
+}
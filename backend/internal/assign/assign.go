@@ -0,0 +1,123 @@
+// Package assign picks the agent a queued conversation is routed to.
+package assign
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Strategies
+const (
+	RoundRobin  = "round_robin"
+	LeastActive = "least_active"
+	// Skills prefers the agents whose skills match most of the
+	// conversation's tags, then the least busy of them.
+	Skills = "skills"
+)
+
+// Candidate is an agent that could take a conversation.
+type Candidate struct {
+	Name string
+	// Active is how many conversations the agent is handling and Max how
+	// many they can handle at once.
+	Active int
+	Max    int
+	Skills []string
+}
+
+func (c Candidate) free() bool {
+	return c.Active < c.Max
+}
+
+// Qualified returns the candidates having every one of skills.
+func Qualified(candidates []Candidate, skills []string) []Candidate {
+	var out []Candidate
+	for _, c := range candidates {
+		if overlap(c.Skills, skills) == len(skills) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Assigner applies one strategy. It is safe for concurrent use.
+type Assigner struct {
+	strategy string
+
+	mu   sync.Mutex
+	last string // round-robin cursor
+}
+
+// New returns an assigner for the named strategy.
+func New(strategy string) (*Assigner, error) {
+	switch strategy {
+	case RoundRobin, LeastActive, Skills:
+		return &Assigner{strategy: strategy}, nil
+	}
+	return nil, fmt.Errorf("unknown assignment strategy %q", strategy)
+}
+
+// Strategy returns the name of the strategy.
+func (a *Assigner) Strategy() string {
+	return a.strategy
+}
+
+// Pick returns the agent to route a conversation with the given tags to,
+// or false if every candidate is at capacity. Candidates must be sorted
+// by name.
+func (a *Assigner) Pick(candidates []Candidate, tags []string) (string, bool) {
+	var free []Candidate
+	for _, c := range candidates {
+		if c.free() {
+			free = append(free, c)
+		}
+	}
+	if len(free) == 0 {
+		return "", false
+	}
+
+	switch a.strategy {
+	case RoundRobin:
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		// The next agent by name after the previous pick, wrapping around
+		pick := free[0]
+		for _, c := range free {
+			if c.Name > a.last {
+				pick = c
+				break
+			}
+		}
+		a.last = pick.Name
+		return pick.Name, true
+	case Skills:
+		best, bestScore := free[0], -1
+		for _, c := range free {
+			score := overlap(c.Skills, tags)
+			if score > bestScore || score == bestScore && c.Active < best.Active {
+				best, bestScore = c, score
+			}
+		}
+		return best.Name, true
+	default:
+		best := free[0]
+		for _, c := range free[1:] {
+			if c.Active < best.Active {
+				best = c
+			}
+		}
+		return best.Name, true
+	}
+}
+
+func overlap(skills, tags []string) int {
+	n := 0
+	for _, s := range skills {
+		for _, t := range tags {
+			if s == t {
+				n++
+			}
+		}
+	}
+	return n
+}
@@ -0,0 +1,395 @@
+// Package backplane carries WebSocket frames between instances of the
+// server, so a frame for a connection held by another instance still
+// reaches it. Frames go over Redis pub/sub or Postgres LISTEN/NOTIFY.
+package backplane
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+// AckTimeout is how long Send waits for the instance holding a connection
+// to confirm it delivered the frame.
+const AckTimeout = time.Second
+
+// PresenceTTL is how long a connection counts as held by an instance that
+// stopped renewing it, e.g. because it crashed. Instances renew theirs
+// every third of it.
+const PresenceTTL = 30 * time.Second
+
+// Frames waiting for a connection that is slow to take them, after which
+// more are dropped
+const laneBuffer = 64
+
+// How long a connection's lane waits for more frames before it stops
+const laneIdle = time.Minute
+
+// Message is one frame on its way to a connection on some instance.
+type Message struct {
+	// Origin is the instance that published the message; it ignores its
+	// own messages.
+	Origin string `json:"origin"`
+	// ID is set on frames whose delivery the origin waits for, see Send.
+	ID string `json:"id,omitempty"`
+	// AckFor is set on the acknowledgement of the message with that ID,
+	// which only the instance To reads.
+	AckFor string `json:"ack_for,omitempty"`
+	To     string `json:"to,omitempty"`
+	// Hub names the kind of connection, e.g. "visitor" or "agent".
+	Hub string `json:"hub,omitempty"`
+	// SessionID is the connection to send Frame to; empty sends it to
+	// every connection of the hub.
+	SessionID string          `json:"session_id,omitempty"`
+	Frame     json.RawMessage `json:"frame,omitempty"`
+}
+
+// Backplane passes frames to the other instances.
+type Backplane interface {
+	// ID identifies this instance on the backplane.
+	ID() string
+	// Publish sends frame to the connection of a session on every other
+	// instance, or to all connections of hub if sessionID is empty,
+	// without waiting to hear whether any instance holds it.
+	Publish(ctx context.Context, hub, sessionID string, frame any) error
+	// Send sends frame to the connection of a session on whichever
+	// instance holds it, and reports whether one confirmed delivering it
+	// within AckTimeout. It returns false at once if no instance holds
+	// the connection.
+	Send(ctx context.Context, hub, sessionID string, frame any) (bool, error)
+	// Hold tells the other instances this one holds the connection of a
+	// session, until Release or PresenceTTL after it stops running.
+	Hold(ctx context.Context, hub, sessionID string) error
+	// Release says this instance no longer holds the connection.
+	Release(ctx context.Context, hub, sessionID string) error
+	// Run calls deliver with every message other instances publish until
+	// ctx is cancelled, and keeps the connections held here known.
+	// deliver reports whether it wrote the frame to a connection of this
+	// instance. Frames for different connections are delivered
+	// concurrently, those for one connection in order.
+	Run(ctx context.Context, deliver func(Message) bool)
+	Close() error
+}
+
+// presence is where a transport keeps which instance holds each
+// connection, under keys that expire unless renewed.
+type presence interface {
+	// hold marks key as held by instance for ttl.
+	hold(ctx context.Context, key, instance string, ttl time.Duration) error
+	// release drops key if instance still holds it.
+	release(ctx context.Context, key, instance string) error
+	// held reports whether any instance holds key.
+	held(ctx context.Context, key string) (bool, error)
+}
+
+// node is what every backplane does on top of the transport it publishes
+// with: it tells instances apart, keeps the connections it holds known,
+// matches acknowledgements to the frames waiting for them and delivers
+// frames off the loop reading the transport.
+type node struct {
+	id       string
+	publish  func(ctx context.Context, body []byte) error
+	presence presence
+
+	mu      sync.Mutex
+	waiting map[string]chan struct{}
+	holding map[string]bool
+	// lanes queue the frames of each connection, see dispatch
+	lanes map[string]chan Message
+}
+
+func newNode(publish func(ctx context.Context, body []byte) error, p presence) *node {
+	return &node{
+		id:       uuid.NewString(),
+		publish:  publish,
+		presence: p,
+		waiting:  make(map[string]chan struct{}),
+		holding:  make(map[string]bool),
+		lanes:    make(map[string]chan Message),
+	}
+}
+
+func presenceKey(hub, sessionID string) string {
+	return hub + ":" + sessionID
+}
+
+// ID identifies this instance on the backplane.
+func (n *node) ID() string {
+	return n.id
+}
+
+// Publish sends frame to the connection of a session on every other
+// instance, or to all connections of hub if sessionID is empty.
+func (n *node) Publish(ctx context.Context, hub, sessionID string, frame any) error {
+	return n.send(ctx, Message{Hub: hub, SessionID: sessionID}, frame)
+}
+
+// Send sends frame to the connection of a session and waits for the
+// instance holding it to acknowledge it. It reports false if none did
+// within AckTimeout, or at once if no instance holds it.
+func (n *node) Send(ctx context.Context, hub, sessionID string, frame any) (bool, error) {
+	live, err := n.presence.held(ctx, presenceKey(hub, sessionID))
+	if err != nil {
+		// Without knowing, wait for an answer as if it were held
+		log.Warn().Err(err).Msg("Error looking up backplane presence")
+	} else if !live {
+		return false, nil
+	}
+
+	id := uuid.NewString()
+	acked := make(chan struct{}, 1)
+	n.mu.Lock()
+	n.waiting[id] = acked
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		delete(n.waiting, id)
+		n.mu.Unlock()
+	}()
+
+	if err := n.send(ctx, Message{ID: id, Hub: hub, SessionID: sessionID}, frame); err != nil {
+		return false, err
+	}
+	timer := time.NewTimer(AckTimeout)
+	defer timer.Stop()
+	select {
+	case <-acked:
+		return true, nil
+	case <-timer.C:
+		return false, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// Hold marks the connection of a session as held here, and keeps it so
+// while Run runs.
+func (n *node) Hold(ctx context.Context, hub, sessionID string) error {
+	key := presenceKey(hub, sessionID)
+	n.mu.Lock()
+	n.holding[key] = true
+	n.mu.Unlock()
+	return n.presence.hold(ctx, key, n.id, PresenceTTL)
+}
+
+// Release stops holding the connection of a session.
+func (n *node) Release(ctx context.Context, hub, sessionID string) error {
+	key := presenceKey(hub, sessionID)
+	n.mu.Lock()
+	delete(n.holding, key)
+	n.mu.Unlock()
+	return n.presence.release(ctx, key, n.id)
+}
+
+// keepPresence renews the connections held here until ctx is cancelled.
+func (n *node) keepPresence(ctx context.Context) {
+	ticker := time.NewTicker(PresenceTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n.mu.Lock()
+			keys := make([]string, 0, len(n.holding))
+			for key := range n.holding {
+				keys = append(keys, key)
+			}
+			n.mu.Unlock()
+			for _, key := range keys {
+				if err := n.presence.hold(ctx, key, n.id, PresenceTTL); err != nil {
+					log.Warn().Err(err).Msg("Error renewing backplane presence")
+					break
+				}
+			}
+		}
+	}
+}
+
+func (n *node) send(ctx context.Context, m Message, frame any) error {
+	raw, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	m.Origin, m.Frame = n.id, raw
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return n.publish(ctx, body)
+}
+
+// receive handles a published message: acknowledgements for this
+// instance wake up Send, and frames from other instances are queued for
+// their connection, see dispatch.
+func (n *node) receive(ctx context.Context, payload []byte, deliver func(Message) bool) {
+	var m Message
+	if err := json.Unmarshal(payload, &m); err != nil {
+		log.Warn().Err(err).Msg("Ignoring malformed backplane message")
+		return
+	}
+	if m.Origin == n.id {
+		return
+	}
+	if m.AckFor != "" {
+		if m.To != n.id {
+			return
+		}
+		n.mu.Lock()
+		acked, ok := n.waiting[m.AckFor]
+		n.mu.Unlock()
+		if ok {
+			select {
+			case acked <- struct{}{}:
+			default:
+			}
+		}
+		return
+	}
+	n.dispatch(ctx, m, deliver)
+}
+
+// dispatch queues m on the lane of its connection, which delivers it in
+// order after the frames before it, so a connection that is slow to
+// write holds up neither the others nor the acknowledgements. A lane
+// stops after laneIdle without frames.
+func (n *node) dispatch(ctx context.Context, m Message, deliver func(Message) bool) {
+	key := presenceKey(m.Hub, m.SessionID)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	lane, ok := n.lanes[key]
+	if !ok {
+		lane = make(chan Message, laneBuffer)
+		n.lanes[key] = lane
+		go n.drain(ctx, key, lane, deliver)
+	}
+	select {
+	case lane <- m:
+	default:
+		log.Warn().Str("hub", m.Hub).Str("session_id", m.SessionID).Msg("Dropping backplane frame for a connection that is not keeping up")
+	}
+}
+
+// drain delivers the frames of one lane until it has been idle for
+// laneIdle or ctx is cancelled.
+func (n *node) drain(ctx context.Context, key string, lane chan Message, deliver func(Message) bool) {
+	idle := time.NewTimer(laneIdle)
+	defer idle.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			n.mu.Lock()
+			delete(n.lanes, key)
+			n.mu.Unlock()
+			return
+		case m := <-lane:
+			n.deliver(ctx, m, deliver)
+			idle.Reset(laneIdle)
+		case <-idle.C:
+			n.mu.Lock()
+			if len(lane) == 0 {
+				delete(n.lanes, key)
+				n.mu.Unlock()
+				return
+			}
+			n.mu.Unlock()
+			idle.Reset(laneIdle)
+		}
+	}
+}
+
+// deliver passes m to deliver and acknowledges it if it was delivered and
+// its origin waits to hear.
+func (n *node) deliver(ctx context.Context, m Message, deliver func(Message) bool) {
+	if !deliver(m) || m.ID == "" {
+		return
+	}
+	body, err := json.Marshal(Message{Origin: n.id, AckFor: m.ID, To: m.Origin})
+	if err != nil {
+		return
+	}
+	actx, cancel := context.WithTimeout(ctx, AckTimeout)
+	defer cancel()
+	if err := n.publish(actx, body); err != nil {
+		log.Warn().Err(err).Msg("Error acknowledging backplane message")
+	}
+}
+
+// Redis is a backplane on Redis pub/sub.
+type Redis struct {
+	*node
+	client  *redis.Client
+	channel string
+}
+
+// NewRedis connects to the Redis at url, e.g. redis://localhost:6379/0,
+// and publishes on channel.
+func NewRedis(ctx context.Context, url, channel string) (*Redis, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	r := &Redis{client: client, channel: channel}
+	r.node = newNode(func(ctx context.Context, body []byte) error {
+		return client.Publish(ctx, channel, body).Err()
+	}, r)
+	return r, nil
+}
+
+// Connections are held under keys next to the channel, whose value is the
+// instance holding them.
+func (r *Redis) presenceKey(key string) string {
+	return r.channel + ":presence:" + key
+}
+
+func (r *Redis) hold(ctx context.Context, key, instance string, ttl time.Duration) error {
+	return r.client.Set(ctx, r.presenceKey(key), instance, ttl).Err()
+}
+
+// releaseScript deletes a key only if it still has the value given, so an
+// instance never drops a connection another one has taken over since.
+var releaseScript = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`)
+
+func (r *Redis) release(ctx context.Context, key, instance string) error {
+	return releaseScript.Run(ctx, r.client, []string{r.presenceKey(key)}, instance).Err()
+}
+
+func (r *Redis) held(ctx context.Context, key string) (bool, error) {
+	n, err := r.client.Exists(ctx, r.presenceKey(key)).Result()
+	return n > 0, err
+}
+
+// Run calls deliver with every message other instances publish until ctx
+// is cancelled. The subscription reconnects by itself if Redis goes away.
+func (r *Redis) Run(ctx context.Context, deliver func(Message) bool) {
+	go r.keepPresence(ctx)
+	sub := r.client.Subscribe(ctx, r.channel)
+	defer sub.Close()
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			r.receive(ctx, []byte(msg.Payload), deliver)
+		}
+	}
+}
+
+// Close disconnects from Redis.
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
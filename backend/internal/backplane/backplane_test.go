@@ -0,0 +1,230 @@
+package backplane
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fanout stands in for Redis or Postgres: every payload published by any
+// node reaches every node, its own included.
+type fanout struct {
+	mu    sync.Mutex
+	nodes []*fanoutNode
+	held  memPresence
+}
+
+// memPresence keeps presence in memory, without expiry.
+type memPresence struct {
+	mu   sync.Mutex
+	keys map[string]string
+}
+
+func (p *memPresence) hold(_ context.Context, key, instance string, _ time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.keys == nil {
+		p.keys = make(map[string]string)
+	}
+	p.keys[key] = instance
+	return nil
+}
+
+func (p *memPresence) release(_ context.Context, key, instance string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.keys[key] == instance {
+		delete(p.keys, key)
+	}
+	return nil
+}
+
+func (p *memPresence) held(_ context.Context, key string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.keys[key]
+	return ok, nil
+}
+
+type fanoutNode struct {
+	*node
+	inbox chan []byte
+}
+
+func (f *fanout) join(deliver func(Message) bool) *fanoutNode {
+	n := &fanoutNode{inbox: make(chan []byte, 16)}
+	n.node = newNode(func(_ context.Context, body []byte) error {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		for _, other := range f.nodes {
+			other.inbox <- body
+		}
+		return nil
+	}, &f.held)
+	f.mu.Lock()
+	f.nodes = append(f.nodes, n)
+	f.mu.Unlock()
+	go func() {
+		for body := range n.inbox {
+			n.receive(context.Background(), body, deliver)
+		}
+	}()
+	return n
+}
+
+func TestSendIsAcknowledgedByTheHolder(t *testing.T) {
+	var f fanout
+	var got []Message
+	var mu sync.Mutex
+	sender := f.join(func(Message) bool { t.Error("sender received its own frame"); return false })
+	f.join(func(m Message) bool { return false })
+	holder := f.join(func(m Message) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, m)
+		return m.SessionID == "s1"
+	})
+	holder.Hold(context.Background(), "visitor", "s1")
+
+	delivered, err := sender.Send(context.Background(), "visitor", "s1", map[string]string{"type": "agent"})
+	if err != nil || !delivered {
+		t.Fatalf("Send = %v, %v; want delivered", delivered, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 1 || got[0].Hub != "visitor" {
+		t.Fatalf("holder got %+v", got)
+	}
+	var frame map[string]string
+	if err := json.Unmarshal(got[0].Frame, &frame); err != nil || frame["type"] != "agent" {
+		t.Errorf("frame = %s", got[0].Frame)
+	}
+}
+
+func TestSendWithoutHolder(t *testing.T) {
+	var f fanout
+	sender := f.join(func(Message) bool { return false })
+	other := f.join(func(Message) bool { return false })
+
+	// Nobody holds the connection: no need to wait for an answer
+	started := time.Now()
+	delivered, err := sender.Send(context.Background(), "visitor", "s1", "hi")
+	if err != nil || delivered {
+		t.Fatalf("Send = %v, %v; want not delivered", delivered, err)
+	}
+	if waited := time.Since(started); waited >= AckTimeout {
+		t.Errorf("waited %v for a connection nobody holds", waited)
+	}
+
+	// Once released, it is not waited for either
+	other.Hold(context.Background(), "visitor", "s1")
+	other.Release(context.Background(), "visitor", "s1")
+	if delivered, err := sender.Send(context.Background(), "visitor", "s1", "hi"); err != nil || delivered {
+		t.Fatalf("Send after release = %v, %v; want not delivered", delivered, err)
+	}
+}
+
+func TestSendWaitsForAHeldConnection(t *testing.T) {
+	var f fanout
+	sender := f.join(func(Message) bool { return false })
+	// Held, but the connection is gone before the frame arrives
+	holder := f.join(func(Message) bool { return false })
+	holder.Hold(context.Background(), "visitor", "s1")
+
+	started := time.Now()
+	delivered, err := sender.Send(context.Background(), "visitor", "s1", "hi")
+	if err != nil || delivered {
+		t.Fatalf("Send = %v, %v; want not delivered", delivered, err)
+	}
+	if waited := time.Since(started); waited < AckTimeout {
+		t.Errorf("gave up after %v, before AckTimeout", waited)
+	}
+}
+
+func TestSlowConnectionHoldsUpNoOther(t *testing.T) {
+	var f fanout
+	release := make(chan struct{})
+	defer close(release)
+	sender := f.join(func(Message) bool { return false })
+	holder := f.join(func(m Message) bool {
+		if m.SessionID == "slow" {
+			<-release
+		}
+		return true
+	})
+	holder.Hold(context.Background(), "visitor", "slow")
+	holder.Hold(context.Background(), "visitor", "fast")
+
+	if err := sender.Publish(context.Background(), "visitor", "slow", "hi"); err != nil {
+		t.Fatal(err)
+	}
+	delivered, err := sender.Send(context.Background(), "visitor", "fast", "hi")
+	if err != nil || !delivered {
+		t.Fatalf("Send behind a slow connection = %v, %v; want delivered", delivered, err)
+	}
+}
+
+func TestFramesOfAConnectionStayInOrder(t *testing.T) {
+	var f fanout
+	got := make(chan string, 10)
+	sender := f.join(func(Message) bool { return false })
+	f.join(func(m Message) bool {
+		var frame string
+		json.Unmarshal(m.Frame, &frame)
+		got <- frame
+		return true
+	})
+
+	want := []string{"a", "b", "c", "d", "e"}
+	for _, frame := range want {
+		if err := sender.Publish(context.Background(), "visitor", "s1", frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, w := range want {
+		select {
+		case frame := <-got:
+			if frame != w {
+				t.Fatalf("got %q, want %q", frame, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%q never arrived", w)
+		}
+	}
+}
+
+func TestSendStopsWithContext(t *testing.T) {
+	var f fanout
+	sender := f.join(func(Message) bool { return false })
+	sender.Hold(context.Background(), "visitor", "s1")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := sender.Send(ctx, "visitor", "s1", "hi"); err == nil {
+		t.Fatal("Send returned no error after the context ended")
+	}
+}
+
+func TestPublishIsNotAcknowledged(t *testing.T) {
+	var f fanout
+	acks := make(chan Message, 4)
+	sender := f.join(func(Message) bool { return false })
+	holder := f.join(func(Message) bool { return true })
+	// Catch whatever the holder would answer with
+	holder.node.publish = func(_ context.Context, body []byte) error {
+		var m Message
+		json.Unmarshal(body, &m)
+		acks <- m
+		return nil
+	}
+
+	if err := sender.Publish(context.Background(), "visitor", "s1", "hi"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-acks:
+		t.Fatalf("broadcast frame was acknowledged: %+v", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,206 @@
+package backplane
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+// Postgres limits, in bytes
+const (
+	maxChannelLength = 63
+	// MaxPostgresPayload is the largest message NOTIFY carries; larger
+	// frames are stored in the backplane_frames table, and only their ID
+	// is sent, see storedPrefix.
+	MaxPostgresPayload = 7999
+)
+
+// storedPrefix starts the notification for a frame kept in the
+// backplane_frames table, followed by its ID. Messages themselves are
+// JSON objects, so the two cannot be confused.
+const storedPrefix = "frame:"
+
+// How long stored frames are kept for the instances to read them
+const storedFrameTTL = time.Minute
+
+var postgresSchema = []string{
+	`CREATE TABLE IF NOT EXISTS backplane_presence (
+		channel TEXT NOT NULL,
+		key TEXT NOT NULL,
+		instance TEXT NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (channel, key)
+	)`,
+	`CREATE TABLE IF NOT EXISTS backplane_frames (
+		id BIGSERIAL PRIMARY KEY,
+		body TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+}
+
+// Postgres is a backplane on Postgres LISTEN/NOTIFY, for deployments that
+// have Postgres but no Redis.
+type Postgres struct {
+	*node
+	pool    *pgxpool.Pool
+	url     string
+	channel string
+}
+
+// NewPostgres connects to the Postgres at url, e.g.
+// postgres://chatbot:secret@db/chatbot, and notifies on channel.
+func NewPostgres(ctx context.Context, url, channel string) (*Postgres, error) {
+	if channel == "" || len(channel) > maxChannelLength {
+		return nil, fmt.Errorf("channel must be 1 to %d bytes long", maxChannelLength)
+	}
+	pool, err := pgxpool.New(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	for _, stmt := range postgresSchema {
+		if _, err := pool.Exec(ctx, stmt); err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("creating tables: %w", err)
+		}
+	}
+	p := &Postgres{pool: pool, url: url, channel: channel}
+	p.node = newNode(p.notify, p)
+	return p, nil
+}
+
+// notify publishes body, through the backplane_frames table if it is too
+// large for NOTIFY.
+func (p *Postgres) notify(ctx context.Context, body []byte) error {
+	payload := string(body)
+	if len(body) > MaxPostgresPayload {
+		var id int64
+		if err := p.pool.QueryRow(ctx, `INSERT INTO backplane_frames (body) VALUES ($1) RETURNING id`, payload).Scan(&id); err != nil {
+			return fmt.Errorf("storing a frame of %d bytes: %w", len(body), err)
+		}
+		payload = storedPrefix + strconv.FormatInt(id, 10)
+	}
+	_, err := p.pool.Exec(ctx, `SELECT pg_notify($1, $2)`, p.channel, payload)
+	return err
+}
+
+// load returns the message a notification carries, reading it from the
+// backplane_frames table if it was stored there.
+func (p *Postgres) load(ctx context.Context, payload string) ([]byte, error) {
+	ref, stored := strings.CutPrefix(payload, storedPrefix)
+	if !stored {
+		return []byte(payload), nil
+	}
+	id, err := strconv.ParseInt(ref, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad stored frame ID %q", ref)
+	}
+	var body string
+	if err := p.pool.QueryRow(ctx, `SELECT body FROM backplane_frames WHERE id = $1`, id).Scan(&body); err != nil {
+		return nil, fmt.Errorf("reading stored frame %d: %w", id, err)
+	}
+	return []byte(body), nil
+}
+
+// Connections are held in the backplane_presence table, by channel so
+// backplanes sharing a database stay apart. Rows of instances that went
+// away are cleared by clearExpired.
+func (p *Postgres) hold(ctx context.Context, key, instance string, ttl time.Duration) error {
+	_, err := p.pool.Exec(ctx, `INSERT INTO backplane_presence (channel, key, instance, expires_at)
+		VALUES ($1, $2, $3, now() + make_interval(secs => $4))
+		ON CONFLICT (channel, key) DO UPDATE SET instance = excluded.instance, expires_at = excluded.expires_at`,
+		p.channel, key, instance, ttl.Seconds())
+	return err
+}
+
+func (p *Postgres) release(ctx context.Context, key, instance string) error {
+	_, err := p.pool.Exec(ctx, `DELETE FROM backplane_presence WHERE channel = $1 AND key = $2 AND instance = $3`, p.channel, key, instance)
+	return err
+}
+
+func (p *Postgres) held(ctx context.Context, key string) (bool, error) {
+	var ok bool
+	err := p.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM backplane_presence WHERE channel = $1 AND key = $2 AND expires_at > now())`,
+		p.channel, key).Scan(&ok)
+	return ok, err
+}
+
+// clearExpired drops the presence of instances that stopped renewing it,
+// and stored frames every instance has had time to read, every
+// PresenceTTL until ctx is cancelled.
+func (p *Postgres) clearExpired(ctx context.Context) {
+	ticker := time.NewTicker(PresenceTTL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := p.pool.Exec(ctx, `DELETE FROM backplane_presence WHERE channel = $1 AND expires_at <= now()`, p.channel); err != nil {
+				log.Warn().Err(err).Msg("Error clearing expired backplane presence")
+			}
+			if _, err := p.pool.Exec(ctx, `DELETE FROM backplane_frames WHERE created_at < now() - make_interval(secs => $1)`,
+				storedFrameTTL.Seconds()); err != nil {
+				log.Warn().Err(err).Msg("Error clearing stored backplane frames")
+			}
+		}
+	}
+}
+
+// Run calls deliver with every message other instances publish until ctx
+// is cancelled. It listens on a connection of its own, and opens a new one
+// if that is lost; messages published in between are missed.
+func (p *Postgres) Run(ctx context.Context, deliver func(Message) bool) {
+	go p.keepPresence(ctx)
+	go p.clearExpired(ctx)
+	for {
+		err := p.listen(ctx, deliver)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Warn().Err(err).Msg("Lost backplane connection, reconnecting")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (p *Postgres) listen(ctx context.Context, deliver func(Message) bool) error {
+	conn, err := pgx.Connect(ctx, p.url)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{p.channel}.Sanitize()); err != nil {
+		return err
+	}
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		body, err := p.load(ctx, n.Payload)
+		if err != nil {
+			log.Warn().Err(err).Msg("Dropping backplane message")
+			continue
+		}
+		p.receive(ctx, body, deliver)
+	}
+}
+
+// Close disconnects from Postgres.
+func (p *Postgres) Close() error {
+	p.pool.Close()
+	return nil
+}
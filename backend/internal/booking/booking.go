@@ -0,0 +1,90 @@
+// Package booking talks to scheduling services so the bot can offer and
+// book appointment slots.
+package booking
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Slot is an available start time.
+type Slot struct {
+	Start time.Time `json:"start"`
+}
+
+// Booking is a confirmed appointment.
+type Booking struct {
+	ID    string    `json:"id"`
+	Start time.Time `json:"start"`
+	URL   string    `json:"url,omitempty"`
+}
+
+// Invitee is the visitor booking the slot.
+type Invitee struct {
+	Name     string
+	Email    string
+	TimeZone string
+}
+
+// Scheduler lists and books slots in one scheduling service.
+type Scheduler interface {
+	Name() string
+	Slots(ctx context.Context, from, to time.Time) ([]Slot, error)
+	Book(ctx context.Context, start time.Time, invitee Invitee) (Booking, error)
+}
+
+// Config selects and configures a scheduler.
+type Config struct {
+	// Provider is "calcom" or "calendly". Empty disables booking.
+	Provider string
+
+	CalcomAPIKey      string
+	CalcomEventTypeID string
+
+	CalendlyToken     string
+	CalendlyEventType string
+}
+
+// New returns the scheduler for cfg.Provider, or nil if none is set.
+func New(cfg Config) (Scheduler, error) {
+	client := &http.Client{Timeout: 15 * time.Second}
+	switch cfg.Provider {
+	case "":
+		return nil, nil
+	case "calcom":
+		if cfg.CalcomAPIKey == "" || cfg.CalcomEventTypeID == "" {
+			return nil, fmt.Errorf("cal.com needs an API key and event type ID")
+		}
+		return &Calcom{BaseURL: "https://api.cal.com/v1", APIKey: cfg.CalcomAPIKey, EventTypeID: cfg.CalcomEventTypeID, Client: client}, nil
+	case "calendly":
+		if cfg.CalendlyToken == "" || cfg.CalendlyEventType == "" {
+			return nil, fmt.Errorf("calendly needs a token and event type URI")
+		}
+		return &Calendly{BaseURL: "https://api.calendly.com", Token: cfg.CalendlyToken, EventType: cfg.CalendlyEventType, Client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown booking provider %q", cfg.Provider)
+	}
+}
+
+// doJSON performs a request and decodes a 2xx JSON response into out.
+func doJSON(client *http.Client, req *http.Request, out interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("scheduler responded with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, out)
+}
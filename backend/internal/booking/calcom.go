@@ -0,0 +1,89 @@
+package booking
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// Calcom uses the Cal.com v1 API.
+type Calcom struct {
+	BaseURL     string
+	APIKey      string
+	EventTypeID string
+	Client      *http.Client
+}
+
+func (c *Calcom) Name() string { return "calcom" }
+
+func (c *Calcom) Slots(ctx context.Context, from, to time.Time) ([]Slot, error) {
+	q := url.Values{}
+	q.Set("apiKey", c.APIKey)
+	q.Set("eventTypeId", c.EventTypeID)
+	q.Set("startTime", from.UTC().Format(time.RFC3339))
+	q.Set("endTime", to.UTC().Format(time.RFC3339))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/slots?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Slots map[string][]struct {
+			Time time.Time `json:"time"`
+		} `json:"slots"`
+	}
+	if err := doJSON(c.Client, req, &resp); err != nil {
+		return nil, err
+	}
+	var slots []Slot
+	for _, day := range resp.Slots {
+		for _, s := range day {
+			slots = append(slots, Slot{Start: s.Time})
+		}
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i].Start.Before(slots[j].Start) })
+	return slots, nil
+}
+
+func (c *Calcom) Book(ctx context.Context, start time.Time, invitee Invitee) (Booking, error) {
+	var eventTypeID int
+	if _, err := fmt.Sscan(c.EventTypeID, &eventTypeID); err != nil {
+		return Booking{}, fmt.Errorf("invalid event type ID %q", c.EventTypeID)
+	}
+	tz := invitee.TimeZone
+	if tz == "" {
+		tz = "UTC"
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"eventTypeId": eventTypeID,
+		"start":       start.UTC().Format(time.RFC3339),
+		"timeZone":    tz,
+		"language":    "en",
+		"metadata":    map[string]string{},
+		"responses":   map[string]string{"name": invitee.Name, "email": invitee.Email},
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/bookings?apiKey="+url.QueryEscape(c.APIKey), bytes.NewReader(body))
+	if err != nil {
+		return Booking{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var resp struct {
+		ID        int64     `json:"id"`
+		UID       string    `json:"uid"`
+		StartTime time.Time `json:"startTime"`
+	}
+	if err := doJSON(c.Client, req, &resp); err != nil {
+		return Booking{}, err
+	}
+	b := Booking{ID: fmt.Sprint(resp.ID), Start: resp.StartTime}
+	if resp.UID != "" {
+		b.URL = "https://cal.com/booking/" + resp.UID
+	}
+	return b, nil
+}
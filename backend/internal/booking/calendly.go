@@ -0,0 +1,89 @@
+package booking
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+)
+
+// Calendly uses the Calendly v2 API. The available-times endpoint allows
+// at most a seven day window.
+type Calendly struct {
+	BaseURL string
+	Token   string
+	// EventType is the event type URI,
+	// e.g. https://api.calendly.com/event_types/XXXX.
+	EventType string
+	Client    *http.Client
+}
+
+func (c *Calendly) Name() string { return "calendly" }
+
+func (c *Calendly) Slots(ctx context.Context, from, to time.Time) ([]Slot, error) {
+	if to.Sub(from) > 7*24*time.Hour {
+		to = from.Add(7 * 24 * time.Hour)
+	}
+	q := url.Values{}
+	q.Set("event_type", c.EventType)
+	q.Set("start_time", from.UTC().Format(time.RFC3339))
+	q.Set("end_time", to.UTC().Format(time.RFC3339))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/event_type_available_times?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+
+	var resp struct {
+		Collection []struct {
+			Status    string    `json:"status"`
+			StartTime time.Time `json:"start_time"`
+		} `json:"collection"`
+	}
+	if err := doJSON(c.Client, req, &resp); err != nil {
+		return nil, err
+	}
+	var slots []Slot
+	for _, t := range resp.Collection {
+		if t.Status == "available" {
+			slots = append(slots, Slot{Start: t.StartTime})
+		}
+	}
+	return slots, nil
+}
+
+func (c *Calendly) Book(ctx context.Context, start time.Time, invitee Invitee) (Booking, error) {
+	tz := invitee.TimeZone
+	if tz == "" {
+		tz = "UTC"
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"event_type": c.EventType,
+		"start_time": start.UTC().Format(time.RFC3339),
+		"invitee": map[string]string{
+			"name":     invitee.Name,
+			"email":    invitee.Email,
+			"timezone": tz,
+		},
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/invitees", bytes.NewReader(body))
+	if err != nil {
+		return Booking{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	var resp struct {
+		Resource struct {
+			URI           string `json:"uri"`
+			RescheduleURL string `json:"reschedule_url"`
+		} `json:"resource"`
+	}
+	if err := doJSON(c.Client, req, &resp); err != nil {
+		return Booking{}, err
+	}
+	return Booking{ID: path.Base(resp.Resource.URI), Start: start, URL: resp.Resource.RescheduleURL}, nil
+}
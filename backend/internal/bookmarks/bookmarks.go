@@ -0,0 +1,137 @@
+// Package bookmarks keeps the conversations admins pinned and the
+// messages they bookmarked, e.g. for QA review or training material.
+package bookmarks
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"web-chatbot-backend/internal/filestore"
+	"web-chatbot-backend/internal/session"
+)
+
+var ErrNotFound = errors.New("bookmark not found")
+
+// Pin marks a whole conversation as important.
+type Pin struct {
+	SessionID string    `json:"session_id"`
+	PinnedBy  string    `json:"pinned_by,omitempty"`
+	Note      string    `json:"note,omitempty"`
+	PinnedAt  time.Time `json:"pinned_at"`
+}
+
+// Bookmark is a copy of one message, kept even after the transcript
+// drops it.
+type Bookmark struct {
+	ID        string          `json:"id"`
+	SessionID string          `json:"session_id"`
+	Message   session.Message `json:"message"`
+	Note      string          `json:"note,omitempty"`
+	CreatedBy string          `json:"created_by,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+type data struct {
+	Pins      map[string]Pin      `json:"pins"`
+	Bookmarks map[string]Bookmark `json:"bookmarks"`
+}
+
+// Store holds pins and bookmarks, saved to a JSON file after every change.
+type Store struct {
+	mu   sync.Mutex
+	path string
+	data data
+}
+
+// NewStore loads pins and bookmarks from path.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path}
+	if err := filestore.Load(path, &s.data); err != nil {
+		return nil, err
+	}
+	if s.data.Pins == nil {
+		s.data.Pins = make(map[string]Pin)
+	}
+	if s.data.Bookmarks == nil {
+		s.data.Bookmarks = make(map[string]Bookmark)
+	}
+	return s, nil
+}
+
+// Pin pins a conversation, replacing any earlier pin and note.
+func (s *Store) Pin(sessionID, by, note string) (Pin, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := Pin{SessionID: sessionID, PinnedBy: by, Note: note, PinnedAt: time.Now()}
+	s.data.Pins[sessionID] = p
+	return p, filestore.Save(s.path, s.data)
+}
+
+// Unpin removes a conversation's pin.
+func (s *Store) Unpin(sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Pins[sessionID]; !ok {
+		return ErrNotFound
+	}
+	delete(s.data.Pins, sessionID)
+	return filestore.Save(s.path, s.data)
+}
+
+// Pins returns all pins, newest first.
+func (s *Store) Pins() []Pin {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Pin, 0, len(s.data.Pins))
+	for _, p := range s.data.Pins {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].PinnedAt.After(out[j].PinnedAt) })
+	return out
+}
+
+// Add bookmarks a message.
+func (s *Store) Add(sessionID string, msg session.Message, by, note string) (Bookmark, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := Bookmark{
+		ID:        uuid.NewString(),
+		SessionID: sessionID,
+		Message:   msg,
+		Note:      note,
+		CreatedBy: by,
+		CreatedAt: time.Now(),
+	}
+	s.data.Bookmarks[b.ID] = b
+	return b, filestore.Save(s.path, s.data)
+}
+
+// Remove deletes a bookmark.
+func (s *Store) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Bookmarks[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.data.Bookmarks, id)
+	return filestore.Save(s.path, s.data)
+}
+
+// Bookmarks returns the bookmarks of one session, or of all sessions if
+// sessionID is empty, newest first.
+func (s *Store) Bookmarks(sessionID string) []Bookmark {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Bookmark, 0)
+	for _, b := range s.data.Bookmarks {
+		if sessionID == "" || b.SessionID == sessionID {
+			out = append(out, b)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out
+}
@@ -0,0 +1,158 @@
+// Package botconfig keeps a draft of the bot's behavior (its prompt,
+// greetings and auto-responder rules) next to the published version, so
+// changes can be tried out before they go live.
+package botconfig
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"web-chatbot-backend/internal/filestore"
+	"web-chatbot-backend/internal/greetings"
+	"web-chatbot-backend/internal/rules"
+)
+
+// ErrNoDraft is returned when publishing without a draft.
+var ErrNoDraft = errors.New("no draft to publish")
+
+// Config is one version of the bot configuration.
+type Config struct {
+	// Prompt is sent to the bot with every message, e.g. as the system
+	// prompt of the workflow's model.
+	Prompt    string           `json:"prompt,omitempty"`
+	Greetings []greetings.Rule `json:"greetings"`
+	Rules     []rules.Rule     `json:"rules"`
+}
+
+// Validate checks every greeting and rule.
+func (c *Config) Validate() error {
+	var errs []error
+	seen := make(map[string]bool)
+	for i := range c.Greetings {
+		g := &c.Greetings[i]
+		if err := g.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("greeting %d: %w", i+1, err))
+		}
+		if g.ID != "" && seen["g"+g.ID] {
+			errs = append(errs, fmt.Errorf("greeting %d: duplicate id %s", i+1, g.ID))
+		}
+		seen["g"+g.ID] = true
+	}
+	for i := range c.Rules {
+		r := &c.Rules[i]
+		if err := r.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("rule %d: %w", i+1, err))
+		}
+		if r.ID != "" && seen["r"+r.ID] {
+			errs = append(errs, fmt.Errorf("rule %d: duplicate id %s", i+1, r.ID))
+		}
+		seen["r"+r.ID] = true
+	}
+	return errors.Join(errs...)
+}
+
+// Release describes the published configuration.
+type Release struct {
+	// Version counts publishes, starting at 1.
+	Version     int        `json:"version"`
+	Prompt      string     `json:"prompt,omitempty"`
+	PublishedBy string     `json:"published_by,omitempty"`
+	PublishedAt *time.Time `json:"published_at,omitempty"`
+}
+
+type data struct {
+	Draft   *Config `json:"draft,omitempty"`
+	Release Release `json:"release"`
+}
+
+// Store holds the draft and the current release, saved to a JSON file
+// after every change. The published greetings and rules themselves live in
+// their own stores; see Publish.
+type Store struct {
+	mu   sync.Mutex
+	path string
+	data data
+}
+
+// NewStore loads the draft and release from path.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path}
+	if err := filestore.Load(path, &s.data); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Draft returns a copy of the draft, or nil if there is none.
+func (s *Store) Draft() *Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Draft == nil {
+		return nil
+	}
+	c := copyConfig(*s.data.Draft)
+	return &c
+}
+
+// SetDraft replaces the draft.
+func (s *Store) SetDraft(c Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	c = copyConfig(c)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Draft = &c
+	return filestore.Save(s.path, s.data)
+}
+
+// Discard throws the draft away.
+func (s *Store) Discard() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Draft = nil
+	return filestore.Save(s.path, s.data)
+}
+
+// Release returns the current release.
+func (s *Store) Release() Release {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.Release
+}
+
+// Publish makes the draft live. apply is called with the draft to swap in
+// the greetings and rules; if it fails, the draft is kept and the release
+// stays as it was. Publishes never run concurrently.
+func (s *Store) Publish(by string, apply func(Config) error) (Release, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Draft == nil {
+		return Release{}, ErrNoDraft
+	}
+	draft := copyConfig(*s.data.Draft)
+	if err := draft.Validate(); err != nil {
+		return Release{}, err
+	}
+	if err := apply(draft); err != nil {
+		return Release{}, err
+	}
+
+	now := time.Now()
+	s.data.Release = Release{
+		Version:     s.data.Release.Version + 1,
+		Prompt:      draft.Prompt,
+		PublishedBy: by,
+		PublishedAt: &now,
+	}
+	s.data.Draft = nil
+	return s.data.Release, filestore.Save(s.path, s.data)
+}
+
+func copyConfig(c Config) Config {
+	c.Greetings = append([]greetings.Rule{}, c.Greetings...)
+	c.Rules = append([]rules.Rule{}, c.Rules...)
+	return c
+}
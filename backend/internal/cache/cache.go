@@ -0,0 +1,200 @@
+// Package cache is a key-value cache with expiring entries, kept in memory
+// or in Redis, so everything the server caches behaves the same way.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Cache stores values for a while. A ttl of 0 keeps the value until it is
+// evicted.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// LRU is an in-memory cache that evicts the least recently used entry once
+// it holds size entries.
+type LRU struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+type entry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewLRU creates an in-memory cache of at most size entries.
+func NewLRU(size int) *LRU {
+	return &LRU{size: max(size, 1), order: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (l *LRU) Get(_ context.Context, key string) ([]byte, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	el, ok := l.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	e := el.Value.(*entry)
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		l.order.Remove(el)
+		delete(l.items, key)
+		return nil, false, nil
+	}
+	l.order.MoveToFront(el)
+	return e.value, true, nil
+}
+
+func (l *LRU) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	e := &entry{key: key, value: value}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if el, ok := l.items[key]; ok {
+		el.Value = e
+		l.order.MoveToFront(el)
+		return nil
+	}
+	l.items[key] = l.order.PushFront(e)
+	for l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.items, oldest.Value.(*entry).key)
+	}
+	return nil
+}
+
+func (l *LRU) Delete(_ context.Context, key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if el, ok := l.items[key]; ok {
+		l.order.Remove(el)
+		delete(l.items, key)
+	}
+	return nil
+}
+
+// Redis is a cache shared by every instance, on a Redis server.
+type Redis struct {
+	client *redis.Client
+}
+
+// NewRedis connects to the Redis at url, e.g. redis://localhost:6379/1.
+func NewRedis(ctx context.Context, url string) (*Redis, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &Redis{client: client}, nil
+}
+
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := r.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.client.Set(ctx, key, value, ttl).Err()
+}
+
+func (r *Redis) Delete(ctx context.Context, key string) error {
+	return r.client.Del(ctx, key).Err()
+}
+
+// Stats counts how a namespace was used.
+type Stats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	Errors int64 `json:"errors"`
+	// HitRate is the share of lookups that were hits.
+	HitRate float64 `json:"hit_rate"`
+}
+
+// Namespace is one user's part of a shared cache: its keys are prefixed
+// with its name and its hits and misses are counted separately.
+type Namespace struct {
+	cache  Cache
+	prefix string
+
+	hits, misses, errors atomic.Int64
+}
+
+// NewNamespace returns the part of c named name.
+func NewNamespace(c Cache, name string) *Namespace {
+	return &Namespace{cache: c, prefix: name + ":"}
+}
+
+// GetJSON decodes the value of key into v and reports whether there was
+// one. Cache errors count as misses.
+func (n *Namespace) GetJSON(ctx context.Context, key string, v any) bool {
+	raw, ok, err := n.cache.Get(ctx, n.prefix+key)
+	if err == nil && ok {
+		err = json.Unmarshal(raw, v)
+	}
+	switch {
+	case err != nil:
+		n.errors.Add(1)
+		n.misses.Add(1)
+		return false
+	case !ok:
+		n.misses.Add(1)
+		return false
+	}
+	n.hits.Add(1)
+	return true
+}
+
+// SetJSON stores v under key for ttl.
+func (n *Namespace) SetJSON(ctx context.Context, key string, v any, ttl time.Duration) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := n.cache.Set(ctx, n.prefix+key, raw, ttl); err != nil {
+		n.errors.Add(1)
+		return err
+	}
+	return nil
+}
+
+// Delete removes key.
+func (n *Namespace) Delete(ctx context.Context, key string) error {
+	return n.cache.Delete(ctx, n.prefix+key)
+}
+
+// Stats returns the counters so far.
+func (n *Namespace) Stats() Stats {
+	s := Stats{Hits: n.hits.Load(), Misses: n.misses.Load(), Errors: n.errors.Load()}
+	if total := s.Hits + s.Misses; total > 0 {
+		s.HitRate = float64(s.Hits) / float64(total)
+	}
+	return s
+}
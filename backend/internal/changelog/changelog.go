@@ -0,0 +1,212 @@
+// Package changelog records who changed the bot configuration, when and
+// how, as a diff of the before and after versions.
+package changelog
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"web-chatbot-backend/internal/filestore"
+)
+
+// MaxEntries caps the history kept; the oldest entries are dropped first.
+const MaxEntries = 1000
+
+// Actions
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionPublish = "publish"
+	ActionDiscard = "discard"
+)
+
+// Change is one value that differs between two versions. Path names it
+// like greetings[<id>].text; Before is missing for added values and After
+// for removed ones.
+type Change struct {
+	Path   string `json:"path"`
+	Before any    `json:"before,omitempty"`
+	After  any    `json:"after,omitempty"`
+}
+
+// Entry is one recorded change.
+type Entry struct {
+	ID string `json:"id"`
+	// Kind is what was changed, e.g. "rule", "greeting" or "draft".
+	Kind   string `json:"kind"`
+	Action string `json:"action"`
+	// Target is the ID of the changed item, if it has one.
+	Target string    `json:"target,omitempty"`
+	By     string    `json:"by,omitempty"`
+	At     time.Time `json:"at"`
+	// Version is the release a publish produced.
+	Version int      `json:"version,omitempty"`
+	Diff    []Change `json:"diff"`
+}
+
+// Log holds the change history, saved to a JSON file after every change.
+type Log struct {
+	mu      sync.Mutex
+	path    string
+	entries []Entry
+}
+
+// NewLog loads the history from path.
+func NewLog(path string) (*Log, error) {
+	l := &Log{path: path}
+	if err := filestore.Load(path, &l.entries); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Record diffs before and after and adds the change to the history.
+// Either may be nil, for creations and deletions.
+func (l *Log) Record(e Entry, before, after any) (Entry, error) {
+	diff, err := Diff(before, after)
+	if err != nil {
+		return Entry{}, err
+	}
+	e.ID = uuid.NewString()
+	e.At = time.Now()
+	e.Diff = diff
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, e)
+	if len(l.entries) > MaxEntries {
+		l.entries = append([]Entry(nil), l.entries[len(l.entries)-MaxEntries:]...)
+	}
+	return e, filestore.Save(l.path, l.entries)
+}
+
+// List returns the entries of kind, or all entries if kind is empty,
+// newest first.
+func (l *Log) List(kind string) []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]Entry, 0, len(l.entries))
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		if kind == "" || l.entries[i].Kind == kind {
+			out = append(out, l.entries[i])
+		}
+	}
+	return out
+}
+
+// Diff compares the JSON forms of before and after. Lists of objects with
+// an "id" are matched up by ID, other lists by position.
+func Diff(before, after any) ([]Change, error) {
+	b, err := generic(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := generic(after)
+	if err != nil {
+		return nil, err
+	}
+	var out []Change
+	diff("", b, a, &out)
+	if out == nil {
+		out = []Change{}
+	}
+	return out, nil
+}
+
+func generic(v any) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out any
+	return out, json.Unmarshal(raw, &out)
+}
+
+func diff(path string, before, after any, out *[]Change) {
+	switch b := before.(type) {
+	case map[string]any:
+		if a, ok := after.(map[string]any); ok {
+			for _, k := range unionKeys(b, a) {
+				diff(join(path, k), b[k], a[k], out)
+			}
+			return
+		}
+	case []any:
+		if a, ok := after.([]any); ok {
+			diffList(path, b, a, out)
+			return
+		}
+	}
+	if !reflect.DeepEqual(before, after) {
+		*out = append(*out, Change{Path: path, Before: before, After: after})
+	}
+}
+
+func diffList(path string, before, after []any, out *[]Change) {
+	bIDs, bOK := byID(before)
+	aIDs, aOK := byID(after)
+	if !bOK || !aOK {
+		for i := 0; i < max(len(before), len(after)); i++ {
+			var b, a any
+			if i < len(before) {
+				b = before[i]
+			}
+			if i < len(after) {
+				a = after[i]
+			}
+			diff(fmt.Sprintf("%s[%d]", path, i), b, a, out)
+		}
+		return
+	}
+	for _, id := range unionKeys(bIDs, aIDs) {
+		diff(fmt.Sprintf("%s[%s]", path, id), bIDs[id], aIDs[id], out)
+	}
+}
+
+// byID keys a list of objects by their "id", if they all have one.
+func byID(list []any) (map[string]any, bool) {
+	out := make(map[string]any, len(list))
+	for _, v := range list {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		id, ok := obj["id"].(string)
+		if !ok || id == "" {
+			return nil, false
+		}
+		out[id] = obj
+	}
+	return out, true
+}
+
+func unionKeys(a, b map[string]any) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
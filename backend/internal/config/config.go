@@ -0,0 +1,853 @@
+// Package config loads the server settings that differ between
+// deployments from an optional YAML or JSON file and the environment.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the server configuration. The settings up to AllowedOrigins
+// can come from the file; the sections after them only from the
+// environment, each field from the variable named in its env tag.
+type Config struct {
+	// Provider is the kind of bot messages are sent to: "n8n" for the
+	// webhook at WebhookURL, "http" for any other service taking the same
+	// payload there, or "openai" for a chat completions API.
+	Provider string `json:"provider" yaml:"provider"`
+	// WebhookURL is the n8n webhook messages are forwarded to.
+	WebhookURL string `json:"webhook_url" yaml:"webhook_url"`
+	// WebhookSecret signs every webhook call when set, see package
+	// signing.
+	WebhookSecret string `json:"webhook_secret" yaml:"webhook_secret"`
+	// Port is what the HTTP server listens on.
+	Port int `json:"port" yaml:"port"`
+	// AllowedOrigins may call the API from a browser; "*" allows any.
+	AllowedOrigins []string `json:"allowed_origins" yaml:"allowed_origins"`
+
+	OpenAI       OpenAI       `json:"-" yaml:"-"`
+	HTTP         HTTP         `json:"-" yaml:"-"`
+	Upstream     Upstream     `json:"-" yaml:"-"`
+	Reply        Reply        `json:"-" yaml:"-"`
+	Log          Log          `json:"-" yaml:"-"`
+	Store        Store        `json:"-" yaml:"-"`
+	Backplane    Backplane    `json:"-" yaml:"-"`
+	Cache        Cache        `json:"-" yaml:"-"`
+	JWT          JWT          `json:"-" yaml:"-"`
+	TwoFactor    TwoFactor    `json:"-" yaml:"-"`
+	Autocert     Autocert     `json:"-" yaml:"-"`
+	Handoff      Handoff      `json:"-" yaml:"-"`
+	Shutdown     Shutdown     `json:"-" yaml:"-"`
+	JobQueue     JobQueue     `json:"-" yaml:"-"`
+	Reminders    Reminders    `json:"-" yaml:"-"`
+	Verify       Verify       `json:"-" yaml:"-"`
+	Share        Share        `json:"-" yaml:"-"`
+	Export       Export       `json:"-" yaml:"-"`
+	Translation  Translation  `json:"-" yaml:"-"`
+	Telemetry    Telemetry    `json:"-" yaml:"-"`
+	Metrics      Metrics      `json:"-" yaml:"-"`
+	TopQuestions TopQuestions `json:"-" yaml:"-"`
+	PageContext  PageContext  `json:"-" yaml:"-"`
+	Transcripts  Transcripts  `json:"-" yaml:"-"`
+	Server       Server       `json:"-" yaml:"-"`
+	Probe        Probe        `json:"-" yaml:"-"`
+	Idle         Idle         `json:"-" yaml:"-"`
+	RateLimit    RateLimit    `json:"-" yaml:"-"`
+	Widget       Widget       `json:"-" yaml:"-"`
+	Degraded     Degraded     `json:"-" yaml:"-"`
+	Actions      Actions      `json:"-" yaml:"-"`
+	Hooks        Hooks        `json:"-" yaml:"-"`
+	Scripts      Scripts      `json:"-" yaml:"-"`
+	Wasm         Wasm         `json:"-" yaml:"-"`
+	Events       Events       `json:"-" yaml:"-"`
+	Assignment   Assignment   `json:"-" yaml:"-"`
+	Queue        Queue        `json:"-" yaml:"-"`
+	SLA          SLA          `json:"-" yaml:"-"`
+	Ticketing    Ticketing    `json:"-" yaml:"-"`
+	Alerts       Alerts       `json:"-" yaml:"-"`
+	SMTP         SMTP         `json:"-" yaml:"-"`
+	Retention    Retention    `json:"-" yaml:"-"`
+	Booking      Booking      `json:"-" yaml:"-"`
+	Shopify      Shopify      `json:"-" yaml:"-"`
+	Stripe       Stripe       `json:"-" yaml:"-"`
+	GeoIP        GeoIP        `json:"-" yaml:"-"`
+	WebPush      WebPush      `json:"-" yaml:"-"`
+	AgentPush    AgentPush    `json:"-" yaml:"-"`
+
+	// DataDir holds the file-backed stores.
+	DataDir string `json:"-" yaml:"-" env:"CHATBOT_DATA_DIR"`
+	// AdminToken is the shared token of the admin API, which is off
+	// without one.
+	AdminToken string `json:"-" yaml:"-" env:"CHATBOT_ADMIN_TOKEN"`
+	// AgentAssist has the bot suggest replies to agents.
+	AgentAssist bool `json:"-" yaml:"-" env:"CHATBOT_AGENT_ASSIST"`
+
+	// HistoryTurns is how many of the latest turns webhook payloads carry
+	// as history.
+	HistoryTurns int `json:"-" yaml:"-" env:"CHATBOT_HISTORY_TURNS"`
+	// MaxTurns, if set, lets the history grow to that many turns before
+	// the older ones are summarized instead.
+	MaxTurns int `json:"-" yaml:"-" env:"CHATBOT_MAX_TURNS"`
+	// BatchMaxMessages is the most messages one POST /chat/batch may
+	// carry.
+	BatchMaxMessages int `json:"-" yaml:"-" env:"CHATBOT_BATCH_MAX_MESSAGES"`
+	// DeadLetterMax is how many of the messages the bot failed to answer
+	// are kept.
+	DeadLetterMax int `json:"-" yaml:"-" env:"CHATBOT_DEAD_LETTER_MAX"`
+	// KBMinQuality is the review score from which agent answers are
+	// suggested as knowledge base entries; 0 turns suggestions off.
+	KBMinQuality int `json:"-" yaml:"-" env:"CHATBOT_KB_MIN_QUALITY"`
+	// ConfigSlackWebhookURL is a Slack incoming webhook told about every
+	// publish of the bot configuration.
+	ConfigSlackWebhookURL string `json:"-" yaml:"-" env:"CHATBOT_CONFIG_SLACK_WEBHOOK_URL"`
+	// RoutesFile routes visitor messages to separate workflows, see
+	// package routing. Empty means routes.json in the data directory.
+	RoutesFile string `json:"-" yaml:"-" env:"CHATBOT_ROUTES_FILE"`
+	// PreflightStrict stops the server on any preflight problem, not only
+	// those that leave the bot unusable.
+	PreflightStrict bool `json:"-" yaml:"-" env:"CHATBOT_PREFLIGHT_STRICT"`
+	// PreviewTTL is how long preview links last.
+	PreviewTTL time.Duration `json:"-" yaml:"-" env:"CHATBOT_PREVIEW_TTL"`
+	// SSEKeepalive is how often an idle event stream gets a comment, so
+	// proxies keep it open and a closed connection is noticed.
+	SSEKeepalive time.Duration `json:"-" yaml:"-" env:"CHATBOT_SSE_KEEPALIVE"`
+	// TenantReloadInterval is how often every instance reloads the
+	// tenants from the message store.
+	TenantReloadInterval time.Duration `json:"-" yaml:"-" env:"CHATBOT_TENANT_RELOAD_INTERVAL"`
+	// StreamInterval is how often dashboards get live stats.
+	StreamInterval time.Duration `json:"-" yaml:"-" env:"CHATBOT_STREAM_INTERVAL"`
+}
+
+// OpenAI sets up the openai provider.
+type OpenAI struct {
+	URL    string `env:"CHATBOT_OPENAI_URL"`
+	APIKey string `env:"CHATBOT_OPENAI_API_KEY"`
+	Model  string `env:"CHATBOT_OPENAI_MODEL"`
+}
+
+// HTTP sets up the http provider. Headers are "Name: value" pairs sent
+// with every call; ReplyField is where the reply is, if not in "reply".
+type HTTP struct {
+	Headers    []string `env:"CHATBOT_HTTP_HEADERS"`
+	ReplyField string   `env:"CHATBOT_HTTP_REPLY_FIELD"`
+}
+
+// Upstream limits what is exchanged with the bot. Limits are in bytes;
+// replies longer than MaxReplyLength characters are cut short, 0 keeps
+// them whole. Timeout bounds a whole call, streamed replies included; 0
+// leaves only the connect and read timeouts. The files set up mutual
+// TLS and a private CA.
+type Upstream struct {
+	RequestLimit        int           `env:"CHATBOT_UPSTREAM_REQUEST_LIMIT"`
+	ResponseLimit       int           `env:"CHATBOT_UPSTREAM_RESPONSE_LIMIT"`
+	MaxReplyLength      int           `env:"CHATBOT_MAX_REPLY_LENGTH"`
+	ConnectTimeout      time.Duration `env:"CHATBOT_UPSTREAM_CONNECT_TIMEOUT"`
+	ReadTimeout         time.Duration `env:"CHATBOT_UPSTREAM_READ_TIMEOUT"`
+	Timeout             time.Duration `env:"CHATBOT_UPSTREAM_TIMEOUT"`
+	MaxIdleConns        int           `env:"CHATBOT_UPSTREAM_MAX_IDLE_CONNS"`
+	MaxIdleConnsPerHost int           `env:"CHATBOT_UPSTREAM_MAX_IDLE_CONNS_PER_HOST"`
+	IdleConnTimeout     time.Duration `env:"CHATBOT_UPSTREAM_IDLE_CONN_TIMEOUT"`
+	CAFile              string        `env:"CHATBOT_UPSTREAM_CA_FILE"`
+	CertFile            string        `env:"CHATBOT_UPSTREAM_CERT_FILE"`
+	KeyFile             string        `env:"CHATBOT_UPSTREAM_KEY_FILE"`
+	InsecureSkipVerify  bool          `env:"CHATBOT_UPSTREAM_INSECURE_SKIP_VERIFY"`
+}
+
+// Reply says where in a JSON response the reply is looked for, first
+// match wins, and with Fallback "raw" shows the whole body when none is
+// there rather than failing the call.
+type Reply struct {
+	Fields   []string `env:"CHATBOT_REPLY_FIELDS"`
+	Fallback string   `env:"CHATBOT_REPLY_FALLBACK"`
+}
+
+// Log sets what is logged, from Level up, as JSON lines or, with Format
+// "console", as colored text for reading in a terminal.
+type Log struct {
+	Level  string `env:"CHATBOT_LOG_LEVEL"`
+	Format string `env:"CHATBOT_LOG_FORMAT"`
+}
+
+// Store keeps every transcript message in a database when Driver is
+// "sqlite" or "postgres". The DSN of SQLite defaults to messages.db in
+// the data directory, whose connections wait up to BusyTimeout for a lock
+// and whose write-ahead log is copied back into the database every
+// CheckpointInterval (0 leaves it to SQLite).
+type Store struct {
+	Driver             string        `env:"CHATBOT_STORE_DRIVER"`
+	DSN                string        `env:"CHATBOT_STORE_DSN"`
+	BusyTimeout        time.Duration `env:"CHATBOT_STORE_BUSY_TIMEOUT"`
+	CheckpointInterval time.Duration `env:"CHATBOT_STORE_CHECKPOINT_INTERVAL"`
+}
+
+// Backplane passes frames between instances over "redis" pub/sub or
+// "postgres" LISTEN/NOTIFY, once URL is set. Postgres defaults to the
+// database of the message store.
+type Backplane struct {
+	Kind    string `env:"CHATBOT_BACKPLANE"`
+	URL     string `env:"CHATBOT_BACKPLANE_URL"`
+	Channel string `env:"CHATBOT_BACKPLANE_CHANNEL"`
+}
+
+// Cache is "memory" for an LRU of Size entries per instance, or "redis"
+// to share one cache between instances. Lookups are cached for their TTL;
+// bot replies only if ResponseTTL is set.
+type Cache struct {
+	Backend     string        `env:"CHATBOT_CACHE"`
+	Size        int           `env:"CHATBOT_CACHE_SIZE"`
+	RedisURL    string        `env:"CHATBOT_CACHE_REDIS_URL"`
+	GeoIPTTL    time.Duration `env:"CHATBOT_GEOIP_CACHE_TTL"`
+	ResponseTTL time.Duration `env:"CHATBOT_RESPONSE_CACHE_TTL"`
+}
+
+// JWT makes visitors sign in with a token signed with Secret (HS256) or
+// the key in PublicKeyFile (RS256, PEM). Issuer and Audience, if set,
+// must match the token.
+type JWT struct {
+	Secret        string        `env:"CHATBOT_JWT_SECRET"`
+	PublicKeyFile string        `env:"CHATBOT_JWT_PUBLIC_KEY_FILE"`
+	Issuer        string        `env:"CHATBOT_JWT_ISSUER"`
+	Audience      string        `env:"CHATBOT_JWT_AUDIENCE"`
+	Leeway        time.Duration `env:"CHATBOT_JWT_LEEWAY"`
+}
+
+// Values of TwoFactor.Admin
+const (
+	TwoFactorOff      = "off"
+	TwoFactorOptional = "optional"
+	TwoFactorRequired = "required"
+)
+
+// TwoFactor sets when destructive admin requests need a one-time code:
+// never, only from operators who set up two-factor authentication, or
+// always. Issuer names the server in authenticator apps.
+type TwoFactor struct {
+	Admin  string `env:"CHATBOT_ADMIN_2FA"`
+	Issuer string `env:"CHATBOT_2FA_ISSUER"`
+}
+
+// Autocert also serves HTTPS on TLSPort, with Let's Encrypt certificates
+// obtained for the custom domains as they are first visited.
+type Autocert struct {
+	Enabled bool   `env:"CHATBOT_AUTOCERT"`
+	Email   string `env:"CHATBOT_AUTOCERT_EMAIL"`
+	TLSPort int    `env:"CHATBOT_TLS_PORT"`
+}
+
+// Handoff runs the server as a worker of a supervisor holding the
+// listening sockets, which gives a new worker ReadyTimeout to start.
+// ReusePort opens the listeners with SO_REUSEPORT, so that another server
+// can be started on the same port.
+type Handoff struct {
+	Supervisor   bool          `env:"CHATBOT_SUPERVISOR"`
+	ReadyTimeout time.Duration `env:"CHATBOT_HANDOFF_READY_TIMEOUT"`
+	ReusePort    bool          `env:"CHATBOT_REUSE_PORT"`
+}
+
+// Shutdown drains the server for up to Timeout before it exits. Visitors
+// are told to reconnect after ReconnectAfter.
+type Shutdown struct {
+	Timeout        time.Duration `env:"CHATBOT_SHUTDOWN_TIMEOUT"`
+	ReconnectAfter time.Duration `env:"CHATBOT_RECONNECT_AFTER"`
+}
+
+// JobQueue delivers reminders through the job queue of the message store,
+// looking for due jobs every Interval. An instance has Lease to finish a
+// job before another takes it over.
+type JobQueue struct {
+	Enabled  bool          `env:"CHATBOT_JOB_QUEUE"`
+	Interval time.Duration `env:"CHATBOT_JOB_INTERVAL"`
+	Lease    time.Duration `env:"CHATBOT_JOB_LEASE"`
+}
+
+// Reminders are checked every Interval. One the visitor cannot be reached
+// for is tried again every Retry until it is Expiry overdue. Reminders
+// can be set at most MaxDelay ahead.
+type Reminders struct {
+	Interval time.Duration `env:"CHATBOT_REMINDER_INTERVAL"`
+	Retry    time.Duration `env:"CHATBOT_REMINDER_RETRY"`
+	Expiry   time.Duration `env:"CHATBOT_REMINDER_EXPIRY"`
+	MaxDelay time.Duration `env:"CHATBOT_REMINDER_MAX_DELAY"`
+}
+
+// Verify sets up the email verification codes, which last CodeTTL and
+// allow MaxAttempts wrong guesses.
+type Verify struct {
+	CodeTTL     time.Duration `env:"CHATBOT_VERIFY_CODE_TTL"`
+	MaxAttempts int           `env:"CHATBOT_VERIFY_MAX_ATTEMPTS"`
+	Subject     string        `env:"CHATBOT_VERIFY_SUBJECT"`
+}
+
+// Share links are signed with Secret. They last TTL unless the request
+// asks for less, or for more up to MaxTTL.
+type Share struct {
+	Secret string        `env:"CHATBOT_SHARE_SECRET"`
+	TTL    time.Duration `env:"CHATBOT_SHARE_TTL"`
+	MaxTTL time.Duration `env:"CHATBOT_SHARE_MAX_TTL"`
+}
+
+// Export hashes visitor IDs in anonymized exports with HashKey. With
+// Anonymize every export is anonymized, whatever the request asks for.
+type Export struct {
+	HashKey   string `env:"CHATBOT_EXPORT_HASH_KEY"`
+	Anonymize bool   `env:"CHATBOT_EXPORT_ANONYMIZE"`
+}
+
+// Translation has messages translated by Provider, into Lang unless the
+// operator asks for another language. Each translation is cached for
+// CacheTTL.
+type Translation struct {
+	Provider string        `env:"CHATBOT_TRANSLATION_PROVIDER"`
+	APIKey   string        `env:"CHATBOT_TRANSLATION_API_KEY"`
+	URL      string        `env:"CHATBOT_TRANSLATION_URL"`
+	Lang     string        `env:"CHATBOT_TRANSLATION_LANG"`
+	CacheTTL time.Duration `env:"CHATBOT_TRANSLATION_CACHE_TTL"`
+}
+
+// Telemetry exports spans to the OTLP/HTTP collector at Endpoint, e.g.
+// http://tempo:4318, recording SampleRatio of new traces.
+type Telemetry struct {
+	Endpoint    string  `env:"CHATBOT_OTLP_ENDPOINT"`
+	ServiceName string  `env:"CHATBOT_SERVICE_NAME"`
+	SampleRatio float64 `env:"CHATBOT_TRACE_SAMPLE_RATIO"`
+}
+
+// Metrics labels Prometheus series by tenant and provider. Only the
+// tenants listed get a label of their own, or without a list the first
+// MaxTenants seen; the rest are counted as "other". The same goes for
+// providers. Token, if set, is required as a bearer token on /metrics.
+type Metrics struct {
+	Tenants      []string `env:"CHATBOT_METRICS_TENANTS"`
+	MaxTenants   int      `env:"CHATBOT_METRICS_MAX_TENANTS"`
+	Providers    []string `env:"CHATBOT_METRICS_PROVIDERS"`
+	MaxProviders int      `env:"CHATBOT_METRICS_MAX_PROVIDERS"`
+	Token        string   `env:"CHATBOT_METRICS_TOKEN"`
+}
+
+// TopQuestions counts the K questions asked most in a sketch of Width by
+// Depth counters (K 0 turns counting off). All counts halve every Decay
+// so older questions fade out.
+type TopQuestions struct {
+	K     int           `env:"CHATBOT_TOP_QUESTIONS"`
+	Width int           `env:"CHATBOT_TOP_QUESTIONS_WIDTH"`
+	Depth int           `env:"CHATBOT_TOP_QUESTIONS_DEPTH"`
+	Decay time.Duration `env:"CHATBOT_TOP_QUESTIONS_DECAY"`
+}
+
+// PageContext caps the metadata of the page context the widget sends at
+// MaxKeys keys and MaxBytes bytes of JSON.
+type PageContext struct {
+	MaxKeys  int `env:"CHATBOT_CONTEXT_MAX_KEYS"`
+	MaxBytes int `env:"CHATBOT_CONTEXT_MAX_BYTES"`
+}
+
+// Transcripts posts the full transcript of every session to WebhookURL
+// when it closes, signed with Secret if set. MaxAttempts 0 retries as
+// often as event webhook deliveries.
+type Transcripts struct {
+	WebhookURL  string `env:"CHATBOT_TRANSCRIPT_WEBHOOK_URL"`
+	Secret      string `env:"CHATBOT_TRANSCRIPT_WEBHOOK_SECRET"`
+	MaxAttempts int    `env:"CHATBOT_TRANSCRIPT_WEBHOOK_MAX_ATTEMPTS"`
+}
+
+// Server limits the HTTP server and WebSockets. Sizes are in bytes.
+// Streamed responses get WriteTimeout for each write rather than in all;
+// WebSockets have none once upgraded. Behind a load balancer, ProxyHeader
+// names the header with the visitor IP, e.g. X-Forwarded-For.
+type Server struct {
+	ReadTimeout      time.Duration `env:"CHATBOT_READ_TIMEOUT"`
+	WriteTimeout     time.Duration `env:"CHATBOT_WRITE_TIMEOUT"`
+	IdleTimeout      time.Duration `env:"CHATBOT_IDLE_TIMEOUT"`
+	BodyLimit        int           `env:"CHATBOT_BODY_LIMIT"`
+	ChatBodyLimit    int           `env:"CHATBOT_CHAT_BODY_LIMIT"`
+	WSReadLimit      int           `env:"CHATBOT_WS_READ_LIMIT"`
+	HandshakeTimeout time.Duration `env:"CHATBOT_WS_HANDSHAKE_TIMEOUT"`
+	ProxyHeader      string        `env:"CHATBOT_PROXY_HEADER"`
+}
+
+// Probe sends Message to the bot every Interval (0 turns probes off) and
+// expects a reply with RequiredFields within Timeout. The bot is marked
+// down after FailureThreshold failures in a row.
+type Probe struct {
+	Interval         time.Duration `env:"CHATBOT_PROBE_INTERVAL"`
+	Message          string        `env:"CHATBOT_PROBE_MESSAGE"`
+	RequiredFields   []string      `env:"CHATBOT_PROBE_REQUIRED_FIELDS"`
+	FailureThreshold int           `env:"CHATBOT_PROBE_FAILURE_THRESHOLD"`
+	Timeout          time.Duration `env:"CHATBOT_PROBE_TIMEOUT"`
+}
+
+// Idle sessions are sent WarningMessage after WarnAfter and closed after
+// CloseAfter. A closed session can be resumed for Grace.
+type Idle struct {
+	WarnAfter      time.Duration `env:"CHATBOT_IDLE_WARN_AFTER"`
+	CloseAfter     time.Duration `env:"CHATBOT_IDLE_CLOSE_AFTER"`
+	Grace          time.Duration `env:"CHATBOT_SESSION_GRACE"`
+	WarningMessage string        `env:"CHATBOT_IDLE_WARNING_MESSAGE"`
+}
+
+// RateLimit allows each visitor Limit messages per Window and, unless
+// DailyQuota is 0, that many a day.
+type RateLimit struct {
+	Limit      int           `env:"CHATBOT_RATE_LIMIT"`
+	Window     time.Duration `env:"CHATBOT_RATE_LIMIT_WINDOW"`
+	DailyQuota int           `env:"CHATBOT_DAILY_QUOTA"`
+}
+
+// Widget is how the embedded widget looks before any greeting rule or
+// tenant setting applies.
+type Widget struct {
+	Title       string `env:"CHATBOT_WIDGET_TITLE"`
+	Placeholder string `env:"CHATBOT_WIDGET_PLACEHOLDER"`
+	Greeting    string `env:"CHATBOT_GREETING"`
+}
+
+// Degraded is what visitors are told while the bot is down. Canned
+// answers come from CannedAnswersFile, by default canned_answers.json in
+// the data directory.
+type Degraded struct {
+	Banner            string `env:"CHATBOT_DEGRADED_BANNER"`
+	NoAnswer          string `env:"CHATBOT_DEGRADED_NO_ANSWER"`
+	EmailThanks       string `env:"CHATBOT_DEGRADED_EMAIL_THANKS"`
+	CannedAnswersFile string `env:"CHATBOT_CANNED_ANSWERS_FILE"`
+}
+
+// Actions the bot can ask for are defined in File, by default
+// actions.json in the data directory, and each may run for Timeout.
+type Actions struct {
+	File    string        `env:"CHATBOT_ACTIONS_FILE"`
+	Timeout time.Duration `env:"CHATBOT_ACTION_TIMEOUT"`
+}
+
+// Hooks around message processing are defined in File, by default
+// hooks.json in the data directory. AbortReply is sent when one stops a
+// message.
+type Hooks struct {
+	File       string `env:"CHATBOT_HOOKS_FILE"`
+	AbortReply string `env:"CHATBOT_HOOK_ABORT_REPLY"`
+}
+
+// Scripts are the Lua hooks in Dir, by default scripts in the data
+// directory, each limited to Timeout, MaxCallDepth nested calls and a
+// stack of MaxStack entries.
+type Scripts struct {
+	Dir          string        `env:"CHATBOT_SCRIPTS_DIR"`
+	Timeout      time.Duration `env:"CHATBOT_SCRIPT_TIMEOUT"`
+	MaxCallDepth int           `env:"CHATBOT_SCRIPT_MAX_CALL_DEPTH"`
+	MaxStack     int           `env:"CHATBOT_SCRIPT_MAX_STACK"`
+}
+
+// Wasm runs the WebAssembly processors in Dir, by default plugins in the
+// data directory, checked for changes every ReloadInterval. Each call may
+// take Timeout and MemoryPages pages of 64 KiB.
+type Wasm struct {
+	Dir            string        `env:"CHATBOT_WASM_DIR"`
+	ReloadInterval time.Duration `env:"CHATBOT_WASM_RELOAD_INTERVAL"`
+	Timeout        time.Duration `env:"CHATBOT_WASM_TIMEOUT"`
+	MemoryPages    int           `env:"CHATBOT_WASM_MEMORY_PAGES"`
+}
+
+// MaxWasmMemoryPages is the most memory a WebAssembly module can have.
+const MaxWasmMemoryPages = 65536
+
+// Events are posted to the webhooks at URLs, only those of Types if set.
+// Failed deliveries are retried up to MaxAttempts times, waiting Backoff
+// and doubling up to MaxBackoff, and kept for Retention.
+type Events struct {
+	URLs        []string      `env:"CHATBOT_EVENT_WEBHOOK_URLS"`
+	Types       []string      `env:"CHATBOT_EVENT_WEBHOOK_TYPES"`
+	MaxAttempts int           `env:"CHATBOT_EVENT_WEBHOOK_MAX_ATTEMPTS"`
+	Backoff     time.Duration `env:"CHATBOT_EVENT_WEBHOOK_BACKOFF"`
+	MaxBackoff  time.Duration `env:"CHATBOT_EVENT_WEBHOOK_MAX_BACKOFF"`
+	Retention   time.Duration `env:"CHATBOT_EVENT_WEBHOOK_RETENTION"`
+}
+
+// Assignment routes queued conversations to agents with fewer than
+// MaxChats, by Strategy "round_robin", "least_active" or "skills"; empty
+// leaves agents to claim conversations themselves. Conversations needing skills only go to agents with all of
+// them until they have waited SkillRoutingTimeout; 0 waits forever.
+type Assignment struct {
+	Strategy            string        `env:"CHATBOT_ASSIGNMENT"`
+	MaxChats            int           `env:"CHATBOT_AGENT_MAX_CHATS"`
+	SkillRoutingTimeout time.Duration `env:"CHATBOT_SKILL_ROUTING_TIMEOUT"`
+}
+
+// Queue tells waiting visitors their place every UpdateInterval (0 turns
+// updates off) with Message, which may use {position} and {wait}. Waits
+// are estimated from the last HandleTimeWindow conversations, or
+// DefaultHandleTime before there are any. NoAgentsMessage is sent when
+// nobody is available.
+type Queue struct {
+	UpdateInterval    time.Duration `env:"CHATBOT_QUEUE_UPDATE_INTERVAL"`
+	Message           string        `env:"CHATBOT_QUEUE_MESSAGE"`
+	HandleTimeWindow  int           `env:"CHATBOT_QUEUE_HANDLE_TIME_WINDOW"`
+	DefaultHandleTime time.Duration `env:"CHATBOT_QUEUE_DEFAULT_HANDLE_TIME"`
+	NoAgentsMessage   string        `env:"CHATBOT_NO_AGENTS_MESSAGE"`
+}
+
+// SLA targets for escalated conversations, e.g. a first agent reply
+// within 2m and resolution within 30m. Unset targets are not tracked.
+type SLA struct {
+	FirstResponse time.Duration `env:"CHATBOT_SLA_FIRST_RESPONSE"`
+	Resolution    time.Duration `env:"CHATBOT_SLA_RESOLUTION"`
+}
+
+// Ticketing exports escalations no agent picked up within
+// EscalationTimeout to a "zendesk" or "intercom" helpdesk. URLTemplate
+// links to the ticket, with {id} in place of its ID.
+type Ticketing struct {
+	Provider             string        `env:"CHATBOT_TICKETING_PROVIDER"`
+	ZendeskSubdomain     string        `env:"CHATBOT_ZENDESK_SUBDOMAIN"`
+	ZendeskEmail         string        `env:"CHATBOT_ZENDESK_EMAIL"`
+	ZendeskAPIToken      string        `env:"CHATBOT_ZENDESK_API_TOKEN"`
+	IntercomToken        string        `env:"CHATBOT_INTERCOM_TOKEN"`
+	IntercomTicketTypeID string        `env:"CHATBOT_INTERCOM_TICKET_TYPE_ID"`
+	URLTemplate          string        `env:"CHATBOT_TICKET_URL_TEMPLATE"`
+	EscalationTimeout    time.Duration `env:"CHATBOT_ESCALATION_TIMEOUT"`
+}
+
+// Alerts email OperatorEmails about the agent queue: on every escalation
+// if OnEscalation, and once QueueThreshold visitors wait (0 never). With
+// DigestInterval, alerts are batched into one email per interval.
+type Alerts struct {
+	OperatorEmails []string      `env:"CHATBOT_OPERATOR_EMAILS"`
+	OnEscalation   bool          `env:"CHATBOT_ALERT_ON_ESCALATION"`
+	QueueThreshold int           `env:"CHATBOT_ALERT_QUEUE_THRESHOLD"`
+	DigestInterval time.Duration `env:"CHATBOT_ALERT_DIGEST_INTERVAL"`
+}
+
+// SMTP is the outgoing mail server for visitor and operator emails.
+type SMTP struct {
+	Addr     string `env:"CHATBOT_SMTP_ADDR"`
+	Username string `env:"CHATBOT_SMTP_USERNAME"`
+	Password string `env:"CHATBOT_SMTP_PASSWORD"`
+	From     string `env:"CHATBOT_SMTP_FROM"`
+}
+
+// Retention deletes archived sessions older than Sessions (0 keeps them),
+// checking every Interval. With S3Bucket set their transcripts are
+// exported there first, under Prefix.
+type Retention struct {
+	Sessions          time.Duration `env:"CHATBOT_SESSION_RETENTION"`
+	Interval          time.Duration `env:"CHATBOT_RETENTION_INTERVAL"`
+	Prefix            string        `env:"CHATBOT_ARCHIVE_PREFIX"`
+	S3Bucket          string        `env:"CHATBOT_ARCHIVE_S3_BUCKET"`
+	S3Endpoint        string        `env:"CHATBOT_ARCHIVE_S3_ENDPOINT"`
+	S3Region          string        `env:"CHATBOT_ARCHIVE_S3_REGION"`
+	S3AccessKeyID     string        `env:"CHATBOT_ARCHIVE_S3_ACCESS_KEY_ID"`
+	S3SecretAccessKey string        `env:"CHATBOT_ARCHIVE_S3_SECRET_ACCESS_KEY"`
+}
+
+// Booking makes appointments through "calcom" or "calendly".
+type Booking struct {
+	Provider          string `env:"CHATBOT_BOOKING_PROVIDER"`
+	CalcomAPIKey      string `env:"CHATBOT_CALCOM_API_KEY"`
+	CalcomEventTypeID string `env:"CHATBOT_CALCOM_EVENT_TYPE_ID"`
+	CalendlyToken     string `env:"CHATBOT_CALENDLY_TOKEN"`
+	CalendlyEventType string `env:"CHATBOT_CALENDLY_EVENT_TYPE"`
+}
+
+// Shopify searches products through the Storefront API of Domain.
+type Shopify struct {
+	Domain          string `env:"CHATBOT_SHOPIFY_DOMAIN"`
+	StorefrontToken string `env:"CHATBOT_SHOPIFY_STOREFRONT_TOKEN"`
+	APIVersion      string `env:"CHATBOT_SHOPIFY_API_VERSION"`
+}
+
+// Stripe makes payment links through Stripe Checkout.
+type Stripe struct {
+	SecretKey     string `env:"CHATBOT_STRIPE_SECRET_KEY"`
+	WebhookSecret string `env:"CHATBOT_STRIPE_WEBHOOK_SECRET"`
+	Currency      string `env:"CHATBOT_STRIPE_CURRENCY"`
+	SuccessURL    string `env:"CHATBOT_STRIPE_SUCCESS_URL"`
+	CancelURL     string `env:"CHATBOT_STRIPE_CANCEL_URL"`
+}
+
+// GeoIP locates new sessions through "maxmind" or "ipapi". CountryOnly
+// keeps only the country, for privacy.
+type GeoIP struct {
+	Provider          string `env:"CHATBOT_GEOIP_PROVIDER"`
+	MaxMindAccountID  string `env:"CHATBOT_MAXMIND_ACCOUNT_ID"`
+	MaxMindLicenseKey string `env:"CHATBOT_MAXMIND_LICENSE_KEY"`
+	CountryOnly       bool   `env:"CHATBOT_GEOIP_COUNTRY_ONLY"`
+}
+
+// WebPush notifies visitors who left the page, opening ClickURL. Generate
+// the key pair with e.g. `npx web-push generate-vapid-keys`.
+type WebPush struct {
+	VAPIDPublicKey  string `env:"CHATBOT_VAPID_PUBLIC_KEY"`
+	VAPIDPrivateKey string `env:"CHATBOT_VAPID_PRIVATE_KEY"`
+	VAPIDSubject    string `env:"CHATBOT_VAPID_SUBJECT"`
+	ClickURL        string `env:"CHATBOT_PUSH_URL"`
+}
+
+// AgentPush notifies the agent app through FCM and APNs.
+type AgentPush struct {
+	FCMCredentialsFile string `env:"CHATBOT_FCM_CREDENTIALS_FILE"`
+	APNsKeyFile        string `env:"CHATBOT_APNS_KEY_FILE"`
+	APNsKeyID          string `env:"CHATBOT_APNS_KEY_ID"`
+	APNsTeamID         string `env:"CHATBOT_APNS_TEAM_ID"`
+	APNsTopic          string `env:"CHATBOT_APNS_TOPIC"`
+	APNsSandbox        bool   `env:"CHATBOT_APNS_SANDBOX"`
+}
+
+// Default is the configuration used for anything not set.
+func Default() Config {
+	return Config{
+		Provider:       "n8n",
+		WebhookURL:     "https://n8n.tspbrand.id/webhook/web-chatbot",
+		Port:           8080,
+		AllowedOrigins: []string{"http://localhost:4321"}, // Astro default port
+
+		OpenAI: OpenAI{
+			URL:   "https://api.openai.com/v1/chat/completions",
+			Model: "gpt-4o-mini",
+		},
+		Upstream: Upstream{
+			RequestLimit:        256 * 1024,
+			ResponseLimit:       1024 * 1024,
+			ConnectTimeout:      5 * time.Second,
+			ReadTimeout:         30 * time.Second,
+			Timeout:             2 * time.Minute,
+			MaxIdleConns:        100,
+			MaxIdleConnsPerHost: 32,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		Reply:     Reply{Fallback: "error"},
+		Log:       Log{Level: "info", Format: "json"},
+		Store:     Store{BusyTimeout: 5 * time.Second, CheckpointInterval: 5 * time.Minute},
+		Backplane: Backplane{Kind: "redis", Channel: "chatbot:frames"},
+		Cache: Cache{
+			Backend:  "memory",
+			Size:     10000,
+			RedisURL: "redis://localhost:6379/0",
+			GeoIPTTL: 24 * time.Hour,
+		},
+		JWT:          JWT{Leeway: 30 * time.Second},
+		TwoFactor:    TwoFactor{Admin: TwoFactorOptional, Issuer: "Chatbot"},
+		Autocert:     Autocert{TLSPort: 443},
+		Handoff:      Handoff{ReadyTimeout: time.Minute},
+		Shutdown:     Shutdown{Timeout: 30 * time.Second, ReconnectAfter: 2 * time.Second},
+		JobQueue:     JobQueue{Interval: 5 * time.Second, Lease: time.Minute},
+		Reminders:    Reminders{Interval: 30 * time.Second, Retry: 5 * time.Minute, Expiry: 24 * time.Hour, MaxDelay: 90 * 24 * time.Hour},
+		Verify:       Verify{CodeTTL: 10 * time.Minute, MaxAttempts: 5, Subject: "Your verification code"},
+		Share:        Share{TTL: 7 * 24 * time.Hour, MaxTTL: 30 * 24 * time.Hour},
+		Translation:  Translation{Lang: "en", CacheTTL: 30 * 24 * time.Hour},
+		Telemetry:    Telemetry{ServiceName: "web-chatbot-backend", SampleRatio: 1},
+		Metrics:      Metrics{MaxTenants: 50, MaxProviders: 10},
+		TopQuestions: TopQuestions{K: 50, Width: 4096, Depth: 4, Decay: time.Hour},
+		PageContext:  PageContext{MaxKeys: 20, MaxBytes: 4096},
+		Server: Server{
+			ReadTimeout:      15 * time.Second,
+			WriteTimeout:     15 * time.Second,
+			IdleTimeout:      60 * time.Second,
+			BodyLimit:        4 * 1024 * 1024,
+			ChatBodyLimit:    64 * 1024,
+			WSReadLimit:      64 * 1024,
+			HandshakeTimeout: 10 * time.Second,
+		},
+		Probe:     Probe{Interval: time.Minute, Message: "ping", FailureThreshold: 2, Timeout: 10 * time.Second},
+		Idle:      Idle{WarnAfter: 5 * time.Minute, CloseAfter: 10 * time.Minute, Grace: 30 * time.Minute, WarningMessage: "Are you still there?"},
+		RateLimit: RateLimit{Limit: 30, Window: time.Minute},
+		Widget:    Widget{Title: "Chatbot", Placeholder: "Type your message..."},
+		Degraded: Degraded{
+			Banner:      "Our assistant is temporarily unavailable. We'll do our best to help in the meantime.",
+			NoAnswer:    "I can't answer that right now. Leave your email address and we'll follow up as soon as we can.",
+			EmailThanks: "Thanks! We'll get back to you by email.",
+		},
+		Actions:    Actions{Timeout: 15 * time.Second},
+		Hooks:      Hooks{AbortReply: "Sorry, I can't help with that here."},
+		Scripts:    Scripts{Timeout: 100 * time.Millisecond, MaxCallDepth: 64, MaxStack: 64 * 1024},
+		Wasm:       Wasm{ReloadInterval: 5 * time.Second, Timeout: 200 * time.Millisecond, MemoryPages: 256},
+		Events:     Events{MaxAttempts: 10, Backoff: 5 * time.Second, MaxBackoff: time.Hour, Retention: 72 * time.Hour},
+		Assignment: Assignment{MaxChats: 3, SkillRoutingTimeout: 2 * time.Minute},
+		Queue: Queue{
+			UpdateInterval:    time.Minute,
+			Message:           "You are number {position} in the queue. Estimated wait: {wait}.",
+			HandleTimeWindow:  20,
+			DefaultHandleTime: 5 * time.Minute,
+			NoAgentsMessage:   "All our agents are away right now. You're in the queue and we'll be with you as soon as someone is available.",
+		},
+		Ticketing: Ticketing{EscalationTimeout: 5 * time.Minute},
+		Alerts:    Alerts{OnEscalation: true},
+		Retention: Retention{Interval: time.Hour, S3Endpoint: "https://s3.amazonaws.com", S3Region: "us-east-1"},
+		Shopify:   Shopify{APIVersion: "2024-07"},
+		Stripe:    Stripe{Currency: "usd"},
+		WebPush:   WebPush{VAPIDSubject: "mailto:admin@example.com", ClickURL: "http://localhost:4321"},
+
+		DataDir:              "data",
+		HistoryTurns:         10,
+		BatchMaxMessages:     50,
+		DeadLetterMax:        1000,
+		KBMinQuality:         4,
+		PreviewTTL:           24 * time.Hour,
+		SSEKeepalive:         15 * time.Second,
+		TenantReloadInterval: 30 * time.Second,
+		StreamInterval:       5 * time.Second,
+	}
+}
+
+// Load reads the file at path, if any, over the defaults and then applies
+// CHATBOT_PROVIDER, CHATBOT_WEBHOOK_URL, CHATBOT_WEBHOOK_SECRET,
+// CHATBOT_PORT, CHATBOT_ALLOWED_ORIGINS (comma separated) and the
+// variables of the env tags from getenv on top. Files ending in .yaml or
+// .yml are read as YAML, anything else as JSON. The result is validated,
+// and every problem is reported at once.
+func Load(path string, getenv func(string) string) (Config, error) {
+	cfg := Default()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, err
+		}
+		if err := decode(path, data, &cfg); err != nil {
+			return cfg, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	var errs []error
+	if v := getenv("CHATBOT_PROVIDER"); v != "" {
+		cfg.Provider = v
+	}
+	if v := getenv("CHATBOT_WEBHOOK_URL"); v != "" {
+		cfg.WebhookURL = v
+	}
+	if v := getenv("CHATBOT_WEBHOOK_SECRET"); v != "" {
+		cfg.WebhookSecret = v
+	}
+	if v := getenv("CHATBOT_PORT"); v != "" {
+		if port, err := strconv.Atoi(v); err != nil {
+			errs = append(errs, fmt.Errorf("invalid CHATBOT_PORT %q", v))
+		} else {
+			cfg.Port = port
+		}
+	}
+	if v := getenv("CHATBOT_ALLOWED_ORIGINS"); v != "" {
+		cfg.AllowedOrigins = splitList(v)
+	}
+	errs = append(errs, loadEnv(&cfg, getenv)...)
+	errs = append(errs, cfg.Validate())
+	return cfg, errors.Join(errs...)
+}
+
+// decode rejects unknown keys so typos do not go unnoticed.
+func decode(path string, data []byte, cfg *Config) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		return dec.Decode(cfg)
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		return dec.Decode(cfg)
+	}
+}
+
+// Validate reports every problem with the configuration at once.
+func (c Config) Validate() error {
+	var errs []error
+	switch c.Provider {
+	case "n8n", "http", "openai":
+	default:
+		errs = append(errs, fmt.Errorf("provider must be n8n, http or openai, got %q", c.Provider))
+	}
+	if u, err := url.Parse(c.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("webhook_url must be an http(s) URL, got %q", c.WebhookURL))
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port must be between 1 and 65535, got %d", c.Port))
+	}
+	if len(c.AllowedOrigins) == 0 {
+		errs = append(errs, errors.New("allowed_origins must not be empty"))
+	}
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" {
+			errs = append(errs, fmt.Errorf("allowed origin %q must be a scheme and host, e.g. https://example.com", origin))
+		}
+	}
+
+	errs = append(errs, checkNotNegative(c)...)
+	oneOf := func(key, got string, allowed ...string) {
+		for _, a := range allowed {
+			if got == a {
+				return
+			}
+		}
+		errs = append(errs, fmt.Errorf("%s must be one of %s, got %q", key, strings.Join(allowed, ", "), got))
+	}
+	oneOf("CHATBOT_REPLY_FALLBACK", c.Reply.Fallback, "error", "raw")
+	oneOf("CHATBOT_LOG_LEVEL", c.Log.Level, "trace", "debug", "info", "warn", "error")
+	oneOf("CHATBOT_LOG_FORMAT", c.Log.Format, "json", "console")
+	oneOf("CHATBOT_STORE_DRIVER", c.Store.Driver, "", "sqlite", "postgres")
+	oneOf("CHATBOT_BACKPLANE", c.Backplane.Kind, "redis", "postgres")
+	oneOf("CHATBOT_CACHE", c.Cache.Backend, "memory", "redis")
+	oneOf("CHATBOT_ADMIN_2FA", c.TwoFactor.Admin, TwoFactorOff, TwoFactorOptional, TwoFactorRequired)
+	oneOf("CHATBOT_ASSIGNMENT", c.Assignment.Strategy, "", "round_robin", "least_active", "skills")
+	oneOf("CHATBOT_TICKETING_PROVIDER", c.Ticketing.Provider, "", "zendesk", "intercom")
+	oneOf("CHATBOT_BOOKING_PROVIDER", c.Booking.Provider, "", "calcom", "calendly")
+	oneOf("CHATBOT_GEOIP_PROVIDER", c.GeoIP.Provider, "", "maxmind", "ipapi")
+	// These set how often something runs, which cannot be never
+	positive := func(key string, d time.Duration) {
+		if d <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive, got %s", key, d))
+		}
+	}
+	positive("CHATBOT_SSE_KEEPALIVE", c.SSEKeepalive)
+	positive("CHATBOT_TENANT_RELOAD_INTERVAL", c.TenantReloadInterval)
+	positive("CHATBOT_STREAM_INTERVAL", c.StreamInterval)
+	positive("CHATBOT_WASM_RELOAD_INTERVAL", c.Wasm.ReloadInterval)
+	if c.Retention.Sessions > 0 {
+		positive("CHATBOT_RETENTION_INTERVAL", c.Retention.Interval)
+	}
+	if c.DataDir == "" {
+		errs = append(errs, errors.New("CHATBOT_DATA_DIR must not be empty"))
+	}
+	if c.Wasm.MemoryPages > MaxWasmMemoryPages {
+		errs = append(errs, fmt.Errorf("CHATBOT_WASM_MEMORY_PAGES must be at most %d, got %d", MaxWasmMemoryPages, c.Wasm.MemoryPages))
+	}
+	if c.JobQueue.Enabled && c.Store.Driver == "" {
+		errs = append(errs, errors.New("CHATBOT_JOB_QUEUE needs the message store, see CHATBOT_STORE_DRIVER"))
+	}
+	if len(c.Alerts.OperatorEmails) > 0 && (c.SMTP.Addr == "" || c.SMTP.From == "") {
+		errs = append(errs, errors.New("CHATBOT_OPERATOR_EMAILS needs CHATBOT_SMTP_ADDR and CHATBOT_SMTP_FROM"))
+	}
+	if c.JWT.Secret != "" && c.JWT.PublicKeyFile != "" {
+		errs = append(errs, errors.New("set only one of CHATBOT_JWT_SECRET and CHATBOT_JWT_PUBLIC_KEY_FILE"))
+	}
+	if c.Autocert.Enabled && (c.Autocert.TLSPort < 1 || c.Autocert.TLSPort > 65535) {
+		errs = append(errs, fmt.Errorf("CHATBOT_TLS_PORT must be between 1 and 65535, got %d", c.Autocert.TLSPort))
+	}
+	if c.Telemetry.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("CHATBOT_TRACE_SAMPLE_RATIO must be between 0 and 1, got %g", c.Telemetry.SampleRatio))
+	}
+	if c.TopQuestions.K > 0 && (c.TopQuestions.Width < 1 || c.TopQuestions.Depth < 1) {
+		errs = append(errs, errors.New("CHATBOT_TOP_QUESTIONS_WIDTH and CHATBOT_TOP_QUESTIONS_DEPTH must be at least 1"))
+	}
+	if c.Share.MaxTTL < c.Share.TTL {
+		errs = append(errs, fmt.Errorf("CHATBOT_SHARE_MAX_TTL must be at least CHATBOT_SHARE_TTL (%s), got %s", c.Share.TTL, c.Share.MaxTTL))
+	}
+	return errors.Join(errs...)
+}
+
+// CORSOrigins is AllowedOrigins in the form the CORS middleware takes.
+func (c Config) CORSOrigins() string {
+	origins := make([]string, len(c.AllowedOrigins))
+	for i, origin := range c.AllowedOrigins {
+		origins[i] = strings.TrimSuffix(origin, "/")
+	}
+	return strings.Join(origins, ",")
+}
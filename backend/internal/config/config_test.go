@@ -0,0 +1,205 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// env returns a getenv reading from vars.
+func env(vars map[string]string) func(string) string {
+	return func(key string) string { return vars[key] }
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := Load("", env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, Default()) {
+		t.Errorf("got %+v, want the defaults %+v", cfg, Default())
+	}
+}
+
+func TestLoadFileThenEnvironment(t *testing.T) {
+	yamlFile := writeFile(t, "chatbot.yaml", `
+provider: http
+webhook_url: https://bot.example.com/hook
+port: 9000
+allowed_origins: [https://a.example.com, https://b.example.com]
+`)
+	jsonFile := writeFile(t, "chatbot.json", `{"webhook_url": "https://bot.example.com/hook", "port": 9000}`)
+
+	for _, path := range []string{yamlFile, jsonFile} {
+		t.Run(filepath.Ext(path), func(t *testing.T) {
+			cfg, err := Load(path, env(nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.WebhookURL != "https://bot.example.com/hook" || cfg.Port != 9000 {
+				t.Errorf("file not applied: %+v", cfg)
+			}
+		})
+	}
+
+	cfg, err := Load(yamlFile, env(map[string]string{
+		"CHATBOT_PORT":            "9100",
+		"CHATBOT_WEBHOOK_SECRET":  "s3cret",
+		"CHATBOT_ALLOWED_ORIGINS": " https://c.example.com, ,https://d.example.com/ ",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Default()
+	want.Provider = "http"
+	want.WebhookURL = "https://bot.example.com/hook"
+	want.WebhookSecret = "s3cret"
+	want.Port = 9100
+	want.AllowedOrigins = []string{"https://c.example.com", "https://d.example.com/"}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("got %+v, want %+v", cfg, want)
+	}
+	if got := cfg.CORSOrigins(); got != "https://c.example.com,https://d.example.com" {
+		t.Errorf("CORSOrigins() = %q", got)
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	for name, content := range map[string]string{
+		"chatbot.yaml": "webhook_ulr: https://bot.example.com\n",
+		"chatbot.json": `{"webhook_ulr": "https://bot.example.com"}`,
+	} {
+		if _, err := Load(writeFile(t, name, content), env(nil)); err == nil || !strings.Contains(err.Error(), "webhook_ulr") {
+			t.Errorf("%s: err = %v, want one naming webhook_ulr", name, err)
+		}
+	}
+}
+
+func TestLoadInvalidPort(t *testing.T) {
+	if _, err := Load("", env(map[string]string{"CHATBOT_PORT": "eighty"})); err == nil {
+		t.Error("CHATBOT_PORT=eighty accepted")
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	cfg := Config{
+		Provider:       "smtp",
+		WebhookURL:     "ftp://bot.example.com",
+		Port:           70000,
+		AllowedOrigins: []string{"*", "example.com", "https://example.com/path"},
+	}
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("invalid configuration accepted")
+	}
+	for _, want := range []string{"provider", "webhook_url", "port", `"example.com"`, `"https://example.com/path"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %s: %v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), `"*"`) {
+		t.Errorf("wildcard origin reported: %v", err)
+	}
+
+	cfg = Default()
+	cfg.AllowedOrigins = nil
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "allowed_origins") {
+		t.Errorf("empty allowed_origins: err = %v", err)
+	}
+}
+
+func TestLoadEnvironmentSettings(t *testing.T) {
+	cfg, err := Load("", env(map[string]string{
+		"CHATBOT_CACHE_SIZE":          "500",
+		"CHATBOT_UPSTREAM_TIMEOUT":    "45s",
+		"CHATBOT_TRACE_SAMPLE_RATIO":  "0.25",
+		"CHATBOT_JOB_QUEUE":           "true",
+		"CHATBOT_METRICS_TENANTS":     "shop, ,blog",
+		"CHATBOT_STORE_DRIVER":        "sqlite",
+		"CHATBOT_DATA_DIR":            "/var/lib/chatbot",
+		"CHATBOT_RATE_LIMIT_WINDOW":   "30s",
+		"CHATBOT_ALERT_ON_ESCALATION": "false",
+		"CHATBOT_ASSIGNMENT":          "skills",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Default()
+	want.Cache.Size = 500
+	want.Upstream.Timeout = 45 * time.Second
+	want.Telemetry.SampleRatio = 0.25
+	want.JobQueue.Enabled = true
+	want.Metrics.Tenants = []string{"shop", "blog"}
+	want.Store.Driver = "sqlite"
+	want.DataDir = "/var/lib/chatbot"
+	want.RateLimit.Window = 30 * time.Second
+	want.Alerts.OnEscalation = false
+	want.Assignment.Strategy = "skills"
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("got %+v, want %+v", cfg, want)
+	}
+}
+
+func TestLoadEnvironmentOnly(t *testing.T) {
+	path := writeFile(t, "chatbot.yaml", "cache:\n  size: 5\n")
+	if _, err := Load(path, env(nil)); err == nil {
+		t.Error("environment-only setting accepted in the file")
+	}
+}
+
+func TestLoadReportsEveryBadVariable(t *testing.T) {
+	_, err := Load("", env(map[string]string{
+		"CHATBOT_PORT":                "eighty",
+		"CHATBOT_CACHE_SIZE":          "lots",
+		"CHATBOT_JWT_LEEWAY":          "30",
+		"CHATBOT_AUTOCERT":            "sure",
+		"CHATBOT_TRACE_SAMPLE_RATIO":  "2",
+		"CHATBOT_CACHE":               "disk",
+		"CHATBOT_ADMIN_2FA":           "always",
+		"CHATBOT_REMINDER_RETRY":      "-1m",
+		"CHATBOT_JWT_SECRET":          "s",
+		"CHATBOT_JWT_PUBLIC_KEY_FILE": "key.pem",
+		"CHATBOT_TOP_QUESTIONS_WIDTH": "0",
+		"CHATBOT_RATE_LIMIT_WINDOW":   "soon",
+		"CHATBOT_ASSIGNMENT":          "random",
+		"CHATBOT_STREAM_INTERVAL":     "0s",
+		"CHATBOT_WASM_MEMORY_PAGES":   "100000",
+		"CHATBOT_OPERATOR_EMAILS":     "ops@example.com",
+	}))
+	if err == nil {
+		t.Fatal("invalid environment accepted")
+	}
+	for _, want := range []string{
+		"CHATBOT_PORT",
+		"CHATBOT_CACHE_SIZE",
+		"CHATBOT_JWT_LEEWAY",
+		"CHATBOT_AUTOCERT",
+		"CHATBOT_TRACE_SAMPLE_RATIO",
+		"CHATBOT_CACHE ",
+		"CHATBOT_ADMIN_2FA",
+		"CHATBOT_REMINDER_RETRY must not be negative",
+		"only one of CHATBOT_JWT_SECRET",
+		"CHATBOT_TOP_QUESTIONS_WIDTH",
+		"CHATBOT_RATE_LIMIT_WINDOW",
+		"CHATBOT_ASSIGNMENT",
+		"CHATBOT_STREAM_INTERVAL must be positive",
+		"CHATBOT_WASM_MEMORY_PAGES",
+		"CHATBOT_OPERATOR_EMAILS needs CHATBOT_SMTP_ADDR",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %s: %v", want, err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// envFields calls fn with every field of cfg that has an env tag, and the
+// variable it names, looking into the sections too.
+func envFields(cfg reflect.Value, fn func(key string, field reflect.Value)) {
+	t := cfg.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := cfg.Field(i)
+		if key := t.Field(i).Tag.Get("env"); key != "" {
+			fn(key, field)
+		} else if field.Kind() == reflect.Struct {
+			envFields(field, fn)
+		}
+	}
+}
+
+// loadEnv sets the fields with env tags whose variable is set. Values that
+// do not parse leave the default and are reported.
+func loadEnv(cfg *Config, getenv func(string) string) []error {
+	var errs []error
+	envFields(reflect.ValueOf(cfg).Elem(), func(key string, field reflect.Value) {
+		v := getenv(key)
+		if v == "" {
+			return
+		}
+		if err := setField(field, v); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s %q: %w", key, v, err))
+		}
+	})
+	return errs
+}
+
+func setField(field reflect.Value, v string) error {
+	switch {
+	case field.Type() == durationType:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case field.Kind() == reflect.String:
+		field.SetString(v)
+	case field.Kind() == reflect.Int:
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case field.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		field.Set(reflect.ValueOf(splitList(v)))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
+
+// checkNotNegative rejects negative numbers and durations in the settings
+// with env tags; none of them has a meaning for one.
+func checkNotNegative(c Config) []error {
+	var errs []error
+	envFields(reflect.ValueOf(c), func(key string, field reflect.Value) {
+		switch field.Kind() {
+		case reflect.Int, reflect.Int64:
+			if field.Int() < 0 {
+				errs = append(errs, fmt.Errorf("%s must not be negative", key))
+			}
+		case reflect.Float64:
+			if field.Float() < 0 {
+				errs = append(errs, fmt.Errorf("%s must not be negative", key))
+			}
+		}
+	})
+	return errs
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
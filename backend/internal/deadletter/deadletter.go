@@ -0,0 +1,146 @@
+// Package deadletter keeps the visitor messages the bot failed to answer,
+// along with what went wrong, so operators can see what visitors lost and
+// why.
+package deadletter
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/filestore"
+)
+
+var ErrNotFound = errors.New("dead letter not found")
+
+// Letter is one unanswered message.
+type Letter struct {
+	ID        string `json:"id"`
+	SessionID string `json:"session_id,omitempty"`
+	VisitorID string `json:"visitor_id,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	Message   string `json:"message"`
+	// Class is the kind of failure, see provider.Class.
+	Class string `json:"class"`
+	// Status is the HTTP status the bot answered with, if it answered.
+	Status    int       `json:"status,omitempty"`
+	Error     string    `json:"error"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Queue holds the most recent letters in memory and, when a path is set,
+// saves them to a JSON file after every change so they survive restarts.
+type Queue struct {
+	mu      sync.Mutex
+	path    string
+	max     int
+	letters []*Letter // oldest first
+}
+
+// New loads letters from path and keeps at most max of them, dropping the
+// oldest. An empty path keeps them in memory only.
+func New(path string, max int) (*Queue, error) {
+	if max < 1 {
+		max = 1
+	}
+	q := &Queue{path: path, max: max}
+	if path != "" {
+		if err := filestore.Load(path, &q.letters); err != nil {
+			return nil, err
+		}
+	}
+	q.trim()
+	return q, nil
+}
+
+// Add stores a letter from l's session, visitor, request, message, class,
+// status and error.
+func (q *Queue) Add(l Letter) *Letter {
+	stored := l
+	stored.ID = uuid.NewString()
+	stored.CreatedAt = time.Now()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.letters = append(q.letters, &stored)
+	q.trim()
+	q.save()
+	c := stored
+	return &c
+}
+
+// List returns letters, newest first, optionally only those of one class
+// or session.
+func (q *Queue) List(class, sessionID string) []*Letter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := make([]*Letter, 0, len(q.letters))
+	for i := len(q.letters) - 1; i >= 0; i-- {
+		l := q.letters[i]
+		if class != "" && l.Class != class {
+			continue
+		}
+		if sessionID != "" && l.SessionID != sessionID {
+			continue
+		}
+		c := *l
+		out = append(out, &c)
+	}
+	return out
+}
+
+// Counts returns how many letters there are of each class.
+func (q *Queue) Counts() map[string]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	counts := make(map[string]int)
+	for _, l := range q.letters {
+		counts[l.Class]++
+	}
+	return counts
+}
+
+// Get returns a copy of one letter.
+func (q *Queue) Get(id string) (*Letter, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, l := range q.letters {
+		if l.ID == id {
+			c := *l
+			return &c, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Delete removes a letter once it has been dealt with.
+func (q *Queue) Delete(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, l := range q.letters {
+		if l.ID == id {
+			q.letters = append(q.letters[:i], q.letters[i+1:]...)
+			q.save()
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (q *Queue) trim() {
+	if over := len(q.letters) - q.max; over > 0 {
+		q.letters = append([]*Letter(nil), q.letters[over:]...)
+	}
+}
+
+func (q *Queue) save() {
+	if q.path == "" {
+		return
+	}
+	if err := filestore.Save(q.path, q.letters); err != nil {
+		log.Error().Err(err).Msg("Error saving dead letters")
+	}
+}
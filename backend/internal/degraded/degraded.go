@@ -0,0 +1,175 @@
+// Package degraded answers visitors from canned answers while the upstream
+// bot is unavailable, and collects email addresses for follow-up.
+package degraded
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"web-chatbot-backend/internal/filestore"
+)
+
+// Answer is a canned reply served when any of its keywords match.
+type Answer struct {
+	Keywords []string `json:"keywords"`
+	Answer   string   `json:"answer"`
+}
+
+// FollowUp is a request from a visitor to be contacted once the bot is
+// back, with the questions that went unanswered.
+type FollowUp struct {
+	ID        string    `json:"id"`
+	SessionID string    `json:"session_id,omitempty"`
+	VisitorID string    `json:"visitor_id,omitempty"`
+	Email     string    `json:"email"`
+	Questions []string  `json:"questions"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Messages are the texts shown to visitors in degraded mode.
+type Messages struct {
+	// Banner is sent once per conversation as a system message.
+	Banner string
+	// NoAnswer is the reply when no canned answer matches.
+	NoAnswer string
+	// EmailThanks confirms a collected email address.
+	EmailThanks string
+}
+
+// Response is what to send back for one visitor message.
+type Response struct {
+	Reply string
+	// Banner is empty if it was already shown in this conversation.
+	Banner   string
+	FollowUp *FollowUp
+}
+
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+// Mode holds the canned answers and per-conversation degraded state.
+type Mode struct {
+	msgs          Messages
+	answers       []Answer
+	followUpsPath string
+
+	mu        sync.Mutex
+	bannered  map[string]bool
+	questions map[string][]string
+	followUps []*FollowUp
+}
+
+// New loads canned answers from answersPath and previously collected
+// follow-ups from followUpsPath. Either path may be empty.
+func New(msgs Messages, answersPath, followUpsPath string) (*Mode, error) {
+	m := &Mode{
+		msgs:          msgs,
+		followUpsPath: followUpsPath,
+		bannered:      make(map[string]bool),
+		questions:     make(map[string][]string),
+	}
+	if answersPath != "" {
+		if err := filestore.Load(answersPath, &m.answers); err != nil {
+			return nil, err
+		}
+	}
+	if followUpsPath != "" {
+		if err := filestore.Load(followUpsPath, &m.followUps); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Respond answers one message. conversation identifies the conversation
+// for banner and question tracking; it may be empty for one-off requests,
+// in which case the banner is always included.
+func (m *Mode) Respond(conversation, visitorID, message string) (Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var resp Response
+	if conversation == "" || !m.bannered[conversation] {
+		resp.Banner = m.msgs.Banner
+		if conversation != "" {
+			m.bannered[conversation] = true
+		}
+	}
+
+	if email := emailPattern.FindString(message); email != "" {
+		f := &FollowUp{
+			ID:        uuid.NewString(),
+			SessionID: conversation,
+			VisitorID: visitorID,
+			Email:     email,
+			Questions: m.questions[conversation],
+			CreatedAt: time.Now(),
+		}
+		m.followUps = append(m.followUps, f)
+		delete(m.questions, conversation)
+		resp.Reply = m.msgs.EmailThanks
+		resp.FollowUp = f
+		return resp, m.save()
+	}
+
+	if answer := m.match(message); answer != "" {
+		resp.Reply = answer
+		return resp, nil
+	}
+
+	if conversation != "" {
+		m.questions[conversation] = append(m.questions[conversation], message)
+	}
+	resp.Reply = m.msgs.NoAnswer
+	return resp, nil
+}
+
+// Forget drops the degraded state of a conversation.
+func (m *Mode) Forget(conversation string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.bannered, conversation)
+	delete(m.questions, conversation)
+}
+
+// FollowUps returns the collected follow-up requests, newest first.
+func (m *Mode) FollowUps() []FollowUp {
+	m.mu.Lock()
+	out := make([]FollowUp, 0, len(m.followUps))
+	for _, f := range m.followUps {
+		out = append(out, *f)
+	}
+	m.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out
+}
+
+// match returns the canned answer sharing the most keywords with message.
+func (m *Mode) match(message string) string {
+	text := strings.ToLower(message)
+	best, bestHits := "", 0
+	for _, a := range m.answers {
+		hits := 0
+		for _, k := range a.Keywords {
+			if k != "" && strings.Contains(text, strings.ToLower(k)) {
+				hits++
+			}
+		}
+		if hits > bestHits {
+			best, bestHits = a.Answer, hits
+		}
+	}
+	return best
+}
+
+// save must be called with m.mu held.
+func (m *Mode) save() error {
+	if m.followUpsPath == "" {
+		return nil
+	}
+	return filestore.Save(m.followUpsPath, m.followUps)
+}
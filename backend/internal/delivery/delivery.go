@@ -0,0 +1,305 @@
+// Package delivery sends bus events to outbound webhook endpoints with
+// at-least-once semantics: every delivery is persisted before it is sent
+// and retried with backoff until the endpoint answers with a 2xx status.
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/events"
+	"web-chatbot-backend/internal/filestore"
+	"web-chatbot-backend/internal/signing"
+)
+
+// Status of a single delivery.
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusDelivered Status = "delivered"
+	StatusFailed    Status = "failed"
+)
+
+var ErrNotFound = errors.New("delivery not found")
+
+// Delivery is one event bound for one endpoint, along with its receipt.
+type Delivery struct {
+	ID            string       `json:"id"`
+	Endpoint      string       `json:"endpoint"`
+	Event         events.Event `json:"event"`
+	Status        Status       `json:"status"`
+	Attempts      int          `json:"attempts"`
+	LastStatus    int          `json:"last_status_code,omitempty"`
+	LastError     string       `json:"last_error,omitempty"`
+	CreatedAt     time.Time    `json:"created_at"`
+	NextAttemptAt time.Time    `json:"next_attempt_at"`
+	DeliveredAt   *time.Time   `json:"delivered_at,omitempty"`
+}
+
+// Config controls which endpoints receive events and how hard the
+// dispatcher tries.
+type Config struct {
+	Endpoints []string
+	// EventTypes limits delivery to these event types. Empty means all.
+	EventTypes []string
+	// MaxAttempts before a delivery is marked failed.
+	MaxAttempts int
+	// InitialBackoff is doubled after every failed attempt up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Retention is how long delivered receipts are kept.
+	Retention time.Duration
+	// Secret, if set, signs each delivery; see package signing.
+	Secret string
+}
+
+// Dispatcher persists and sends deliveries.
+type Dispatcher struct {
+	cfg    Config
+	path   string
+	client *http.Client
+
+	mu         sync.Mutex
+	deliveries map[string]*Delivery
+}
+
+// NewDispatcher loads pending deliveries from path so that events queued
+// before a restart are still sent. An empty path keeps them in memory.
+func NewDispatcher(cfg Config, path string) (*Dispatcher, error) {
+	d := &Dispatcher{
+		cfg:        cfg,
+		path:       path,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		deliveries: make(map[string]*Delivery),
+	}
+	if path != "" {
+		if err := filestore.Load(path, &d.deliveries); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// Enqueue records a delivery of e to every configured endpoint. Deliveries
+// are keyed by event and endpoint, so enqueueing the same event twice has
+// no effect.
+func (d *Dispatcher) Enqueue(e events.Event) {
+	if !d.wants(e.Type) {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for i, endpoint := range d.cfg.Endpoints {
+		id := fmt.Sprintf("%s-%d", e.ID, i)
+		if _, ok := d.deliveries[id]; ok {
+			continue
+		}
+		d.deliveries[id] = &Delivery{
+			ID:            id,
+			Endpoint:      endpoint,
+			Event:         e,
+			Status:        StatusPending,
+			CreatedAt:     now,
+			NextAttemptAt: now,
+		}
+	}
+	d.save()
+}
+
+// Get returns a copy of one delivery.
+func (d *Dispatcher) Get(id string) (*Delivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	del, ok := d.deliveries[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c := *del
+	return &c, nil
+}
+
+// List returns deliveries, newest first, optionally filtered by status
+// and event ID.
+func (d *Dispatcher) List(status Status, eventID string) []*Delivery {
+	d.mu.Lock()
+	out := make([]*Delivery, 0, len(d.deliveries))
+	for _, del := range d.deliveries {
+		if status != "" && del.Status != status {
+			continue
+		}
+		if eventID != "" && del.Event.ID != eventID {
+			continue
+		}
+		c := *del
+		out = append(out, &c)
+	}
+	d.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].CreatedAt.After(out[j].CreatedAt)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// Redeliver resets a delivery so it is sent again on the next pass,
+// regardless of its current status.
+func (d *Dispatcher) Redeliver(id string) (*Delivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	del, ok := d.deliveries[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	del.Status = StatusPending
+	del.Attempts = 0
+	del.NextAttemptAt = time.Now()
+	d.save()
+	c := *del
+	return &c, nil
+}
+
+// Run sends due deliveries every interval until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.flush(ctx)
+		}
+	}
+}
+
+func (d *Dispatcher) flush(ctx context.Context) {
+	now := time.Now()
+
+	d.mu.Lock()
+	var due []Delivery
+	for id, del := range d.deliveries {
+		if del.Status == StatusDelivered && d.cfg.Retention > 0 && del.DeliveredAt != nil && now.Sub(*del.DeliveredAt) > d.cfg.Retention {
+			delete(d.deliveries, id)
+			continue
+		}
+		if del.Status == StatusPending && !del.NextAttemptAt.After(now) {
+			due = append(due, *del)
+		}
+	}
+	d.mu.Unlock()
+
+	sort.Slice(due, func(i, j int) bool { return due[i].CreatedAt.Before(due[j].CreatedAt) })
+	for _, del := range due {
+		code, err := d.send(ctx, &del)
+		d.record(del.ID, code, err)
+	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, del *Delivery) (int, error) {
+	body, err := json.Marshal(del.Event)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, del.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// Receivers deduplicate on these, since a delivery may arrive more than once
+	req.Header.Set("X-Event-ID", del.Event.ID)
+	req.Header.Set("X-Event-Type", del.Event.Type)
+	req.Header.Set("X-Delivery-ID", del.ID)
+	req.Header.Set("X-Delivery-Attempt", fmt.Sprint(del.Attempts+1))
+	if d.cfg.Secret != "" {
+		signing.Sign(req.Header, d.cfg.Secret, body, time.Now())
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// record stores the outcome of an attempt and schedules the next one.
+func (d *Dispatcher) record(id string, code int, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	del, ok := d.deliveries[id]
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	del.Attempts++
+	del.LastStatus = code
+	if err == nil {
+		del.Status = StatusDelivered
+		del.LastError = ""
+		del.DeliveredAt = &now
+	} else {
+		del.LastError = err.Error()
+		if d.cfg.MaxAttempts > 0 && del.Attempts >= d.cfg.MaxAttempts {
+			del.Status = StatusFailed
+			log.Error().Str("delivery_id", del.ID).Str("endpoint", del.Endpoint).Int("attempts", del.Attempts).Err(err).Msg("Webhook delivery failed for good")
+		} else {
+			del.NextAttemptAt = now.Add(d.backoff(del.Attempts))
+		}
+	}
+	d.save()
+}
+
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	b := d.cfg.InitialBackoff
+	for i := 1; i < attempts; i++ {
+		b *= 2
+		if d.cfg.MaxBackoff > 0 && b >= d.cfg.MaxBackoff {
+			return d.cfg.MaxBackoff
+		}
+	}
+	return b
+}
+
+func (d *Dispatcher) wants(eventType string) bool {
+	if len(d.cfg.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range d.cfg.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// save must be called with d.mu held.
+func (d *Dispatcher) save() {
+	if d.path == "" {
+		return
+	}
+	if err := filestore.Save(d.path, d.deliveries); err != nil {
+		log.Error().Err(err).Msg("Error saving webhook deliveries")
+	}
+}
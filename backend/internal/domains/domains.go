@@ -0,0 +1,120 @@
+// Package domains maps the custom domains tenants serve the widget and
+// API on, such as chat.example.com, to those tenants.
+package domains
+
+import (
+	"errors"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"web-chatbot-backend/internal/filestore"
+)
+
+var (
+	ErrNotFound    = errors.New("domain not found")
+	ErrExists      = errors.New("domain is already mapped")
+	ErrInvalidHost = errors.New("invalid domain name")
+)
+
+// A DNS name with at least two labels, e.g. chat.example.com
+var hostPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9\-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+// Domain is one custom domain.
+type Domain struct {
+	Host      string    `json:"host"`
+	Tenant    string    `json:"tenant"`
+	CreatedAt time.Time `json:"created_at"`
+	CreatedBy string    `json:"created_by,omitempty"`
+}
+
+// Store holds the domains in memory and saves them to a JSON file after
+// every change.
+type Store struct {
+	mu      sync.RWMutex
+	path    string
+	domains map[string]*Domain
+}
+
+// NewStore loads domains from path. An empty path keeps them in memory
+// only.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path, domains: make(map[string]*Domain)}
+	if path != "" {
+		if err := filestore.Load(path, &s.domains); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Normalize lowercases host and drops any port and trailing dot.
+func Normalize(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// Add maps host to tenant.
+func (s *Store) Add(host, tenant, by string) (*Domain, error) {
+	host = Normalize(host)
+	if !hostPattern.MatchString(host) {
+		return nil, ErrInvalidHost
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.domains[host]; ok {
+		return nil, ErrExists
+	}
+	d := &Domain{Host: host, Tenant: tenant, CreatedAt: time.Now(), CreatedBy: by}
+	s.domains[host] = d
+	c := *d
+	return &c, s.save()
+}
+
+// Remove unmaps host.
+func (s *Store) Remove(host string) error {
+	host = Normalize(host)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.domains[host]; !ok {
+		return ErrNotFound
+	}
+	delete(s.domains, host)
+	return s.save()
+}
+
+// Lookup returns the tenant host is mapped to.
+func (s *Store) Lookup(host string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	d, ok := s.domains[Normalize(host)]
+	if !ok {
+		return "", false
+	}
+	return d.Tenant, true
+}
+
+// List returns every domain, sorted by host.
+func (s *Store) List() []Domain {
+	s.mu.RLock()
+	out := make([]Domain, 0, len(s.domains))
+	for _, d := range s.domains {
+		out = append(out, *d)
+	}
+	s.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Host < out[j].Host })
+	return out
+}
+
+// save writes the domains to disk. Callers hold s.mu.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	return filestore.Save(s.path, s.domains)
+}
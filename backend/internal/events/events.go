@@ -0,0 +1,57 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event is a notification about something that happened to a session.
+type Event struct {
+	ID        string         `json:"id"`
+	Type      string         `json:"type"`
+	SessionID string         `json:"session_id,omitempty"`
+	Time      time.Time      `json:"time"`
+	Data      map[string]any `json:"data,omitempty"`
+}
+
+// Handler receives published events.
+type Handler func(Event)
+
+// Bus fans events out to every subscribed handler.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers []Handler
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe registers a handler for all future events.
+func (b *Bus) Subscribe(h Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, h)
+}
+
+// Publish fills in the event ID and time if missing and delivers it to
+// every handler synchronously, in subscription order.
+func (b *Bus) Publish(e Event) {
+	if e.ID == "" {
+		e.ID = uuid.NewString()
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	handlers := make([]Handler, len(b.handlers))
+	copy(handlers, b.handlers)
+	b.mu.RUnlock()
+
+	for _, h := range handlers {
+		h(e)
+	}
+}
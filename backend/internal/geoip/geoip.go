@@ -0,0 +1,150 @@
+// Package geoip looks up the approximate location of a visitor's IP
+// address.
+package geoip
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Location is where an IP address is registered.
+type Location struct {
+	Country     string `json:"country,omitempty"`
+	CountryCode string `json:"country_code,omitempty"`
+	Region      string `json:"region,omitempty"`
+	City        string `json:"city,omitempty"`
+}
+
+// Locator resolves IP addresses to locations.
+type Locator interface {
+	Lookup(ctx context.Context, ip string) (Location, error)
+}
+
+// Config selects and configures a locator.
+type Config struct {
+	// Provider is "maxmind" or "ipapi". Empty disables lookups.
+	Provider string
+
+	MaxMindAccountID  string
+	MaxMindLicenseKey string
+}
+
+// New returns the locator for cfg.Provider, or nil if none is set.
+func New(cfg Config) (Locator, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	var l Locator
+	switch cfg.Provider {
+	case "":
+		return nil, nil
+	case "maxmind":
+		if cfg.MaxMindAccountID == "" || cfg.MaxMindLicenseKey == "" {
+			return nil, fmt.Errorf("maxmind needs an account ID and license key")
+		}
+		l = &MaxMind{BaseURL: "https://geoip.maxmind.com/geoip/v2.1", AccountID: cfg.MaxMindAccountID, LicenseKey: cfg.MaxMindLicenseKey, Client: client}
+	case "ipapi":
+		l = &IPAPI{BaseURL: "http://ip-api.com/json", Client: client}
+	default:
+		return nil, fmt.Errorf("unknown geoip provider %q", cfg.Provider)
+	}
+	return l, nil
+}
+
+// Public reports whether ip is worth looking up, i.e. not a loopback,
+// private or otherwise local address.
+func Public(ip string) bool {
+	addr := net.ParseIP(ip)
+	return addr != nil && !addr.IsLoopback() && !addr.IsPrivate() && !addr.IsUnspecified() &&
+		!addr.IsLinkLocalUnicast() && !addr.IsMulticast()
+}
+
+// CountryOnly drops the region and city from loc.
+func CountryOnly(loc Location) Location {
+	return Location{Country: loc.Country, CountryCode: loc.CountryCode}
+}
+
+// MaxMind uses the GeoIP2 City web service.
+type MaxMind struct {
+	BaseURL    string
+	AccountID  string
+	LicenseKey string
+	Client     *http.Client
+}
+
+func (m *MaxMind) Lookup(ctx context.Context, ip string) (Location, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.BaseURL+"/city/"+url.PathEscape(ip), nil)
+	if err != nil {
+		return Location{}, err
+	}
+	req.SetBasicAuth(m.AccountID, m.LicenseKey)
+
+	type names struct {
+		Names map[string]string `json:"names"`
+	}
+	var result struct {
+		Country struct {
+			ISOCode string            `json:"iso_code"`
+			Names   map[string]string `json:"names"`
+		} `json:"country"`
+		City         names   `json:"city"`
+		Subdivisions []names `json:"subdivisions"`
+	}
+	if err := getJSON(m.Client, req, &result); err != nil {
+		return Location{}, err
+	}
+	loc := Location{
+		Country:     result.Country.Names["en"],
+		CountryCode: result.Country.ISOCode,
+		City:        result.City.Names["en"],
+	}
+	if len(result.Subdivisions) > 0 {
+		loc.Region = result.Subdivisions[0].Names["en"]
+	}
+	return loc, nil
+}
+
+// IPAPI uses the free ip-api.com service.
+type IPAPI struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func (a *IPAPI) Lookup(ctx context.Context, ip string) (Location, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		a.BaseURL+"/"+url.PathEscape(ip)+"?fields=status,message,country,countryCode,regionName,city", nil)
+	if err != nil {
+		return Location{}, err
+	}
+	var result struct {
+		Status      string `json:"status"`
+		Message     string `json:"message"`
+		Country     string `json:"country"`
+		CountryCode string `json:"countryCode"`
+		RegionName  string `json:"regionName"`
+		City        string `json:"city"`
+	}
+	if err := getJSON(a.Client, req, &result); err != nil {
+		return Location{}, err
+	}
+	if result.Status != "success" {
+		return Location{}, fmt.Errorf("ip-api: %s", result.Message)
+	}
+	return Location{Country: result.Country, CountryCode: result.CountryCode, Region: result.RegionName, City: result.City}, nil
+}
+
+func getJSON(client *http.Client, req *http.Request, out interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("geoip lookup responded with status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
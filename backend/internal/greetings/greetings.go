@@ -0,0 +1,263 @@
+// Package greetings picks the greeting a new conversation opens with from
+// rules on who the visitor is and where they came from.
+package greetings
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"web-chatbot-backend/internal/filestore"
+)
+
+var ErrNotFound = errors.New("greeting not found")
+
+// Visit values of a rule.
+const (
+	VisitFirst     = "first"
+	VisitReturning = "returning"
+)
+
+// Rule shows Text to visitors matching all of its conditions; conditions
+// left empty match everyone. A rule with a Tenant is only shown to that
+// tenant's visitors, and is tried before the rules for every tenant. Hours are in the visitor's local time, FromHour
+// inclusive and ToHour exclusive, and wrap past midnight when FromHour is
+// the larger.
+type Rule struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Text string `json:"text"`
+	// Tenant is the tenant the rule is for, or empty for all of them.
+	Tenant string `json:"tenant,omitempty"`
+	// Visit is "first", "returning" or empty for both.
+	Visit string `json:"visit,omitempty"`
+	// ReferrerDomains match the referrer's host or any subdomain of it.
+	ReferrerDomains []string  `json:"referrer_domains,omitempty"`
+	FromHour        *int      `json:"from_hour,omitempty"`
+	ToHour          *int      `json:"to_hour,omitempty"`
+	Disabled        bool      `json:"disabled"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// Validate checks the rule has text and sensible conditions.
+func (r *Rule) Validate() error {
+	if r.Text == "" {
+		return fmt.Errorf("text is required")
+	}
+	if r.Visit != "" && r.Visit != VisitFirst && r.Visit != VisitReturning {
+		return fmt.Errorf("visit must be %q or %q", VisitFirst, VisitReturning)
+	}
+	if (r.FromHour == nil) != (r.ToHour == nil) {
+		return fmt.Errorf("from_hour and to_hour go together")
+	}
+	if r.FromHour != nil && (*r.FromHour < 0 || *r.FromHour > 23 || *r.ToHour < 0 || *r.ToHour > 24) {
+		return fmt.Errorf("hours must be between 0 and 24")
+	}
+	return nil
+}
+
+// Visitor is what rules are matched against. LocalTime is zero when the
+// visitor's time zone is unknown, in which case rules with hours never
+// match.
+type Visitor struct {
+	Tenant    string
+	Returning bool
+	Referrer  string
+	LocalTime time.Time
+}
+
+func (r *Rule) matches(v Visitor) bool {
+	if r.Tenant != "" && r.Tenant != v.Tenant {
+		return false
+	}
+	switch r.Visit {
+	case VisitFirst:
+		if v.Returning {
+			return false
+		}
+	case VisitReturning:
+		if !v.Returning {
+			return false
+		}
+	}
+	if len(r.ReferrerDomains) > 0 && !fromDomain(v.Referrer, r.ReferrerDomains) {
+		return false
+	}
+	if r.FromHour != nil {
+		if v.LocalTime.IsZero() {
+			return false
+		}
+		hour := v.LocalTime.Hour()
+		from, to := *r.FromHour, *r.ToHour
+		if from <= to && (hour < from || hour >= to) {
+			return false
+		}
+		if from > to && hour < from && hour >= to {
+			return false
+		}
+	}
+	return true
+}
+
+func fromDomain(referrer string, domains []string) bool {
+	u, err := url.Parse(referrer)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimPrefix(d, "."))
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// Set holds the greeting rules in evaluation order.
+type Set struct {
+	mu    sync.RWMutex
+	path  string
+	rules []*Rule
+}
+
+// NewSet loads rules from path. An empty path keeps them in memory.
+func NewSet(path string) (*Set, error) {
+	s := &Set{path: path}
+	if path != "" {
+		if err := filestore.Load(path, &s.rules); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range s.rules {
+		if err := r.Validate(); err != nil {
+			return nil, fmt.Errorf("greeting %s: %w", r.ID, err)
+		}
+	}
+	return s, nil
+}
+
+// Pick returns a copy of the first enabled rule of v's tenant matching v,
+// else of the first enabled rule for every tenant, or nil.
+func (s *Set) Pick(v Visitor) *Rule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, own := range []bool{true, false} {
+		for _, r := range s.rules {
+			if (r.Tenant != "") == own && !r.Disabled && r.matches(v) {
+				c := *r
+				return &c
+			}
+		}
+	}
+	return nil
+}
+
+// List returns copies of all rules in evaluation order.
+func (s *Set) List() []Rule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Rule, 0, len(s.rules))
+	for _, r := range s.rules {
+		out = append(out, *r)
+	}
+	return out
+}
+
+// Get returns a copy of the rule with the given ID.
+func (s *Set) Get(id string) (*Rule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, r := range s.rules {
+		if r.ID == id {
+			c := *r
+			return &c, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Put creates a rule at the end of the list, or replaces the rule with the
+// same ID in place.
+func (s *Set) Put(r Rule) (*Rule, error) {
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.ID == "" {
+		r.ID = uuid.NewString()
+	}
+	for i, existing := range s.rules {
+		if existing.ID == r.ID {
+			r.CreatedAt = existing.CreatedAt
+			s.rules[i] = &r
+			c := r
+			return &c, s.save()
+		}
+	}
+	r.CreatedAt = time.Now()
+	s.rules = append(s.rules, &r)
+	c := r
+	return &c, s.save()
+}
+
+// Replace swaps in a whole new list of rules at once. Nothing changes if
+// any rule is invalid.
+func (s *Set) Replace(list []Rule) error {
+	next := make([]*Rule, 0, len(list))
+	for i := range list {
+		r := list[i]
+		if err := r.Validate(); err != nil {
+			return fmt.Errorf("greeting %q: %w", r.Name, err)
+		}
+		next = append(next, &r)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := make(map[string]*Rule, len(s.rules))
+	for _, r := range s.rules {
+		old[r.ID] = r
+	}
+	now := time.Now()
+	for _, r := range next {
+		if existing, ok := old[r.ID]; ok && r.ID != "" {
+			r.CreatedAt = existing.CreatedAt
+			continue
+		}
+		if r.ID == "" {
+			r.ID = uuid.NewString()
+		}
+		r.CreatedAt = now
+	}
+	s.rules = next
+	return s.save()
+}
+
+// Delete removes a rule.
+func (s *Set) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, r := range s.rules {
+		if r.ID == id {
+			s.rules = append(s.rules[:i], s.rules[i+1:]...)
+			return s.save()
+		}
+	}
+	return ErrNotFound
+}
+
+// save must be called with s.mu held.
+func (s *Set) save() error {
+	if s.path == "" {
+		return nil
+	}
+	return filestore.Save(s.path, s.rules)
+}
@@ -0,0 +1,124 @@
+// Package handoff lets a new version of the server take over from the
+// running one without refusing a connection. A supervisor process holds
+// the listening sockets and runs the server as a worker process on them.
+// To upgrade, it starts a new worker on the same sockets and, once that
+// one is ready, has the old one stop accepting, finish what it is doing
+// and hand its state over before it exits.
+//
+// Listeners can also come from systemd socket activation, or be opened
+// with SO_REUSEPORT so that two independently started processes can
+// share a port.
+package handoff
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// envListeners tells a worker how many listeners its supervisor passed it.
+const envListeners = "CHATBOT_HANDOFF_LISTENERS"
+
+// File descriptors a supervisor passes to its workers: messages to the
+// worker, messages from it, then the listeners.
+const (
+	controlFD       = 3
+	statusFD        = 4
+	firstListenerFD = 5
+)
+
+// Types of the messages between supervisor and workers.
+const (
+	// typeReady is sent by a worker once it serves on its listeners.
+	typeReady = "ready"
+	// typeHandoff asks a worker to stop accepting and hand over.
+	typeHandoff = "handoff"
+	// typeState carries the state of the old worker to the new one.
+	typeState = "state"
+	// typeImported tells the old worker the new one has taken the state.
+	typeImported = "imported"
+)
+
+// Message is one line of the protocol between supervisor and workers.
+type Message struct {
+	Type  string          `json:"type"`
+	State json.RawMessage `json:"state,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+var (
+	inheritOnce sync.Once
+	inheritMu   sync.Mutex
+	inherited   []net.Listener
+)
+
+// inheritedListeners returns the listeners passed to this process by a
+// supervisor or by systemd and not yet taken by Listen.
+func inheritedListeners() []net.Listener {
+	inheritOnce.Do(func() {
+		first, n := listenFDs()
+		for i := 0; i < n; i++ {
+			f := os.NewFile(uintptr(first+i), "listener")
+			ln, err := net.FileListener(f)
+			f.Close()
+			if err != nil {
+				log.Warn().Int("fd", first+i).Err(err).Msg("Ignoring inherited file that is not a listener")
+				continue
+			}
+			inherited = append(inherited, ln)
+		}
+	})
+	return inherited
+}
+
+// listenFDs returns the first file descriptor and the number of inherited
+// listeners.
+func listenFDs() (first, n int) {
+	if v := os.Getenv(envListeners); v != "" {
+		n, _ = strconv.Atoi(v)
+		return firstListenerFD, n
+	}
+	// systemd socket activation
+	if os.Getenv("LISTEN_PID") == strconv.Itoa(os.Getpid()) {
+		n, _ = strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		return 3, n
+	}
+	return 0, 0
+}
+
+// Listen returns a TCP listener on addr: the inherited one for its port if
+// there is one, otherwise a new one, opened with SO_REUSEPORT if reusePort
+// is set.
+func Listen(addr string, reusePort bool) (net.Listener, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	inheritMu.Lock()
+	listeners := inheritedListeners()
+	for i, ln := range listeners {
+		if tcp, ok := ln.Addr().(*net.TCPAddr); ok && strconv.Itoa(tcp.Port) == port {
+			inherited = append(listeners[:i:i], listeners[i+1:]...)
+			inheritMu.Unlock()
+			log.Info().Str("addr", addr).Msg("Serving on inherited listener")
+			return ln, nil
+		}
+	}
+	inheritMu.Unlock()
+
+	var lc net.ListenConfig
+	if reusePort {
+		lc.Control = reusePortControl
+	}
+	ln, err := lc.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", addr, err)
+	}
+	return ln, nil
+}
@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package handoff
+
+import (
+	"errors"
+	"syscall"
+)
+
+// reusePortControl fails: SO_REUSEPORT is not available here.
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT is not supported on this platform")
+}
@@ -0,0 +1,237 @@
+package handoff
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Supervisor holds the listening sockets and keeps a worker running on
+// them. SIGHUP replaces the worker with a new one started from the
+// executable as it is now on disk; SIGTERM or SIGINT stops the worker and
+// then the supervisor. A worker that exits on its own is restarted.
+type Supervisor struct {
+	// Addrs are the addresses to listen on.
+	Addrs []string
+	// ReusePort opens the listeners with SO_REUSEPORT.
+	ReusePort bool
+	// ReadyTimeout is how long a new worker has to become ready.
+	ReadyTimeout time.Duration
+
+	files []*os.File
+}
+
+// worker is a running worker process, seen from the supervisor.
+type worker struct {
+	cmd      *exec.Cmd
+	control  *json.Encoder
+	messages chan Message
+	exited   chan error
+}
+
+func (w *worker) messagesC() <-chan Message {
+	if w == nil {
+		return nil
+	}
+	return w.messages
+}
+
+func (w *worker) exitedC() <-chan error {
+	if w == nil {
+		return nil
+	}
+	return w.exited
+}
+
+func (w *worker) send(m Message) {
+	if err := w.control.Encode(m); err != nil {
+		log.Error().Int("pid", w.cmd.Process.Pid).Err(err).Msg("Error writing to worker")
+	}
+}
+
+// Run supervises workers until a termination signal arrives and the
+// current worker has exited.
+func (s *Supervisor) Run() error {
+	for _, addr := range s.Addrs {
+		ln, err := Listen(addr, s.ReusePort)
+		if err != nil {
+			return err
+		}
+		tcp, ok := ln.(*net.TCPListener)
+		if !ok {
+			return fmt.Errorf("listener on %s is not TCP", addr)
+		}
+		f, err := tcp.File()
+		if err != nil {
+			return err
+		}
+		ln.Close()
+		s.files = append(s.files, f)
+	}
+
+	upgrade := make(chan os.Signal, 1)
+	signal.Notify(upgrade, syscall.SIGHUP)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+
+	cur, err := s.start()
+	if err != nil {
+		return err
+	}
+	log.Info().Int("pid", os.Getpid()).Strs("addrs", s.Addrs).Msg("Supervising; send SIGHUP to upgrade")
+	// old is the worker handing over to cur, if any
+	var old *worker
+	for {
+		select {
+		case sig := <-stop:
+			log.Info().Str("signal", sig.String()).Msg("Stopping workers")
+			for _, w := range []*worker{cur, old} {
+				if w != nil {
+					w.cmd.Process.Signal(syscall.SIGTERM)
+				}
+			}
+			for _, w := range []*worker{cur, old} {
+				if w != nil {
+					<-w.exited
+				}
+			}
+			return nil
+
+		case <-upgrade:
+			if old != nil {
+				log.Warn().Msg("Still handing over to the last worker, ignoring upgrade")
+				continue
+			}
+			next, err := s.start()
+			if err != nil {
+				log.Error().Err(err).Msg("New worker failed to start, keeping the running one")
+				continue
+			}
+			cur.send(Message{Type: typeHandoff})
+			old, cur = cur, next
+
+		case m := <-old.messagesC():
+			if m.Type == typeState {
+				cur.send(m)
+			}
+
+		case m := <-cur.messagesC():
+			if m.Type == typeImported && old != nil {
+				old.send(m)
+			}
+
+		case err := <-old.exitedC():
+			log.Info().Int("pid", old.cmd.Process.Pid).AnErr("exit", err).Msg("Old worker exited")
+			old = nil
+
+		case err := <-cur.exitedC():
+			log.Error().Int("pid", cur.cmd.Process.Pid).AnErr("exit", err).Msg("Worker exited, restarting it")
+			time.Sleep(time.Second)
+			if cur, err = s.start(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// start starts a worker on the listeners and waits until it is ready.
+func (s *Supervisor) start() (*worker, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	controlR, controlW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	statusR, statusW, err := os.Pipe()
+	if err != nil {
+		controlR.Close()
+		controlW.Close()
+		return nil, err
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.Env = append(workerEnv(), fmt.Sprintf("%s=%d", envListeners, len(s.files)))
+	cmd.ExtraFiles = append([]*os.File{controlR, statusW}, s.files...)
+	err = cmd.Start()
+	controlR.Close()
+	statusW.Close()
+	if err != nil {
+		controlW.Close()
+		statusR.Close()
+		return nil, err
+	}
+
+	w := &worker{
+		cmd:      cmd,
+		control:  json.NewEncoder(controlW),
+		messages: make(chan Message, 4),
+		exited:   make(chan error, 1),
+	}
+	go func() {
+		defer statusR.Close()
+		dec := json.NewDecoder(statusR)
+		for {
+			var m Message
+			if err := dec.Decode(&m); err != nil {
+				return
+			}
+			w.messages <- m
+		}
+	}()
+	go func() {
+		w.exited <- cmd.Wait()
+		controlW.Close()
+	}()
+
+	timer := time.NewTimer(s.ReadyTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case m := <-w.messages:
+			if m.Type == typeReady {
+				log.Info().Int("pid", cmd.Process.Pid).Msg("Worker ready")
+				return w, nil
+			}
+		case err := <-w.exited:
+			return nil, fmt.Errorf("worker exited before it was ready: %w", errOrExit(err))
+		case <-timer.C:
+			cmd.Process.Kill()
+			<-w.exited
+			return nil, fmt.Errorf("worker not ready after %s", s.ReadyTimeout)
+		}
+	}
+}
+
+func errOrExit(err error) error {
+	if err == nil {
+		return errors.New("exit status 0")
+	}
+	return err
+}
+
+// workerEnv is the environment of this process without what only concerns
+// it: systemd's socket activation and any listeners it inherited itself.
+func workerEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		switch name {
+		case "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", envListeners:
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
@@ -0,0 +1,108 @@
+package handoff
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Worker is a server's link to the supervisor that started it.
+type Worker struct {
+	mu  sync.Mutex
+	out *json.Encoder
+
+	onState  func(state []byte) error
+	replaced chan struct{}
+	imported chan string
+	once     sync.Once
+}
+
+// Connect returns the link to the supervisor that started this process, or
+// nil if it was not started by one. onState is called with the state the
+// worker this one replaces hands over; it must be set up before Ready.
+func Connect(onState func(state []byte) error) *Worker {
+	if os.Getenv(envListeners) == "" {
+		return nil
+	}
+	w := &Worker{
+		out:      json.NewEncoder(os.NewFile(statusFD, "handoff-status")),
+		onState:  onState,
+		replaced: make(chan struct{}),
+		imported: make(chan string, 1),
+	}
+	go w.read(os.NewFile(controlFD, "handoff-control"))
+	return w
+}
+
+func (w *Worker) read(f *os.File) {
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	for {
+		var m Message
+		if err := dec.Decode(&m); err != nil {
+			log.Warn().Err(err).Msg("Lost the connection to the supervisor")
+			return
+		}
+		switch m.Type {
+		case typeHandoff:
+			w.once.Do(func() { close(w.replaced) })
+		case typeState:
+			reply := Message{Type: typeImported}
+			if err := w.onState(m.State); err != nil {
+				log.Error().Err(err).Msg("Error taking over state")
+				reply.Error = err.Error()
+			}
+			if err := w.send(reply); err != nil {
+				log.Error().Err(err).Msg("Error telling the supervisor")
+			}
+		case typeImported:
+			select {
+			case w.imported <- m.Error:
+			default:
+			}
+		}
+	}
+}
+
+func (w *Worker) send(m Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.out.Encode(m)
+}
+
+// Ready tells the supervisor the worker serves on its listeners, so it can
+// retire the worker this one replaces.
+func (w *Worker) Ready() error {
+	return w.send(Message{Type: typeReady})
+}
+
+// Replaced is closed once the supervisor asks the worker to hand over to a
+// new one. It is nil, and never closed, without a supervisor.
+func (w *Worker) Replaced() <-chan struct{} {
+	if w == nil {
+		return nil
+	}
+	return w.replaced
+}
+
+// HandOver sends state to the worker taking over and waits until it has
+// taken it or ctx is done.
+func (w *Worker) HandOver(ctx context.Context, state []byte) error {
+	if err := w.send(Message{Type: typeState, State: state}); err != nil {
+		return err
+	}
+	select {
+	case msg := <-w.imported:
+		if msg != "" {
+			return fmt.Errorf("new worker: %s", msg)
+		}
+		return nil
+	case <-ctx.Done():
+		return errors.New("new worker did not take over the state in time")
+	}
+}
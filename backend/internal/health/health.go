@@ -0,0 +1,209 @@
+// Package health tracks the health of upstream webhooks using periodic
+// synthetic probes.
+package health
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/events"
+)
+
+// ProbeFunc checks one upstream and returns an error if it is unhealthy.
+type ProbeFunc func(ctx context.Context) error
+
+// Status is the last known health of one upstream.
+type Status struct {
+	Name                string    `json:"name"`
+	Healthy             bool      `json:"healthy"`
+	LastCheckedAt       time.Time `json:"last_checked_at,omitempty"`
+	LastError           string    `json:"last_error,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	ChangedAt           time.Time `json:"changed_at,omitempty"`
+}
+
+type target struct {
+	probe  ProbeFunc
+	status Status
+}
+
+// Monitor runs probes and flips upstreams between healthy and unhealthy.
+// Upstreams start out healthy and are marked unhealthy after Threshold
+// consecutive probe failures; a single success marks them healthy again.
+type Monitor struct {
+	Threshold int
+	Timeout   time.Duration
+
+	mu      sync.RWMutex
+	targets map[string]*target
+	bus     *events.Bus
+}
+
+func NewMonitor(bus *events.Bus, threshold int, timeout time.Duration) *Monitor {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &Monitor{
+		Threshold: threshold,
+		Timeout:   timeout,
+		targets:   make(map[string]*target),
+		bus:       bus,
+	}
+}
+
+// Register adds an upstream to be probed.
+func (m *Monitor) Register(name string, probe ProbeFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.targets[name] = &target{probe: probe, status: Status{Name: name, Healthy: true}}
+}
+
+// Remove stops probing an upstream and forgets its status.
+func (m *Monitor) Remove(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.targets, name)
+}
+
+// Healthy reports whether the named upstream is currently healthy.
+// Unknown upstreams are considered healthy.
+func (m *Monitor) Healthy(name string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t, ok := m.targets[name]
+	return !ok || t.status.Healthy
+}
+
+// Ready reports whether every upstream is healthy.
+func (m *Monitor) Ready() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, t := range m.targets {
+		if !t.status.Healthy {
+			return false
+		}
+	}
+	return true
+}
+
+// Statuses returns the status of every upstream, sorted by name.
+func (m *Monitor) Statuses() []Status {
+	m.mu.RLock()
+	out := make([]Status, 0, len(m.targets))
+	for _, t := range m.targets {
+		out = append(out, t.status)
+	}
+	m.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// CheckAll probes every upstream once.
+func (m *Monitor) CheckAll(ctx context.Context) {
+	m.mu.RLock()
+	names := make([]string, 0, len(m.targets))
+	for name := range m.targets {
+		names = append(names, name)
+	}
+	m.mu.RUnlock()
+
+	for _, name := range names {
+		m.check(ctx, name)
+	}
+}
+
+// Run probes every upstream immediately and then every interval until ctx
+// is cancelled.
+func (m *Monitor) Run(ctx context.Context, interval time.Duration) {
+	m.CheckAll(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.CheckAll(ctx)
+		}
+	}
+}
+
+func (m *Monitor) check(ctx context.Context, name string) {
+	m.mu.RLock()
+	t, ok := m.targets[name]
+	m.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, m.Timeout)
+	err := t.probe(probeCtx)
+	cancel()
+
+	m.Report(name, err)
+}
+
+// Report records the outcome of a check against an upstream. It is used by
+// the probe loop and can also be called with failures observed on live
+// traffic.
+func (m *Monitor) Report(name string, err error) {
+	m.record(name, err, false)
+}
+
+// Fail marks an upstream unhealthy right away, whatever the threshold, for
+// failures that will not go away by themselves. The next successful check
+// marks it healthy again. It reports whether the upstream was healthy
+// until now.
+func (m *Monitor) Fail(name string, err error) bool {
+	return m.record(name, err, true)
+}
+
+func (m *Monitor) record(name string, err error, force bool) bool {
+	m.mu.Lock()
+	t, ok := m.targets[name]
+	if !ok {
+		m.mu.Unlock()
+		return false
+	}
+	now := time.Now()
+	st := &t.status
+	st.LastCheckedAt = now
+	wasHealthy := st.Healthy
+	if err != nil {
+		st.LastError = err.Error()
+		st.ConsecutiveFailures++
+		if force || st.ConsecutiveFailures >= m.Threshold {
+			st.Healthy = false
+		}
+	} else {
+		st.LastError = ""
+		st.ConsecutiveFailures = 0
+		st.Healthy = true
+	}
+	flipped := wasHealthy != st.Healthy
+	if flipped {
+		st.ChangedAt = now
+	}
+	snapshot := *st
+	m.mu.Unlock()
+
+	if !flipped {
+		return false
+	}
+	eventType := "upstream_healthy"
+	if !snapshot.Healthy {
+		eventType = "upstream_unhealthy"
+		log.Warn().Str("upstream", name).Str("error", snapshot.LastError).Msg("Upstream is unhealthy")
+	} else {
+		log.Info().Str("upstream", name).Msg("Upstream recovered")
+	}
+	m.bus.Publish(events.Event{
+		Type: eventType,
+		Data: map[string]any{"upstream": name, "error": snapshot.LastError},
+	})
+	return true
+}
@@ -0,0 +1,175 @@
+// Package hooks lets compiled-in plugins and external HTTP hooks inspect,
+// modify or abort message processing at fixed extension points.
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Point is an extension point in the message pipeline.
+type Point string
+
+const (
+	// OnMessageIn runs when a visitor message arrives. Hooks may rewrite
+	// Message or abort.
+	OnMessageIn Point = "on_message_in"
+	// BeforeUpstream runs before each webhook call. Hooks may modify Payload
+	// or abort.
+	BeforeUpstream Point = "before_upstream"
+	// AfterUpstream runs after each webhook call. Hooks may rewrite Reply.
+	AfterUpstream Point = "after_upstream"
+	// OnReplyOut runs before the reply is sent to the visitor. Hooks may
+	// rewrite Reply or abort.
+	OnReplyOut Point = "on_reply_out"
+	// OnSessionClose runs when a session closes. It is informational only.
+	OnSessionClose Point = "on_session_close"
+)
+
+// Points lists every extension point.
+var Points = []Point{OnMessageIn, BeforeUpstream, AfterUpstream, OnReplyOut, OnSessionClose}
+
+// Valid reports whether p is a known extension point.
+func (p Point) Valid() bool {
+	for _, known := range Points {
+		if p == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Context is the state handed to hooks. Hooks modify it in place.
+type Context struct {
+	Point     Point                  `json:"point"`
+	SessionID string                 `json:"session_id,omitempty"`
+	VisitorID string                 `json:"visitor_id,omitempty"`
+	Message   string                 `json:"message,omitempty"`
+	Payload   map[string]interface{} `json:"payload,omitempty"`
+	Reply     string                 `json:"reply,omitempty"`
+}
+
+// AbortError stops processing. Reply, if set, is sent to the visitor
+// instead of a bot answer.
+type AbortError struct {
+	Hook   string
+	Reply  string
+	Reason string
+}
+
+func (e *AbortError) Error() string {
+	return fmt.Sprintf("aborted by hook %s: %s", e.Hook, e.Reason)
+}
+
+// Hook is one plugin attached to an extension point.
+type Hook interface {
+	Name() string
+	Run(ctx context.Context, hc *Context) error
+}
+
+// Func adapts a function to a Hook.
+type Func struct {
+	HookName string
+	Fn       func(ctx context.Context, hc *Context) error
+}
+
+func (f Func) Name() string                               { return f.HookName }
+func (f Func) Run(ctx context.Context, hc *Context) error { return f.Fn(ctx, hc) }
+
+// Runner runs the hooks registered at each point, in registration order.
+type Runner struct {
+	mu    sync.RWMutex
+	hooks map[Point][]Hook
+	// failOpen holds hooks whose errors (other than aborts) are logged and
+	// skipped instead of stopping processing.
+	failOpen map[Hook]bool
+}
+
+type plugin struct {
+	point Point
+	hook  Hook
+}
+
+var (
+	pluginsMu sync.Mutex
+	plugins   []plugin
+)
+
+// RegisterPlugin makes a compiled-in plugin part of every Runner created
+// afterwards. Plugin packages call it from init and are enabled with a
+// blank import in main. Plugins are fail-closed.
+func RegisterPlugin(p Point, h Hook) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	plugins = append(plugins, plugin{point: p, hook: h})
+}
+
+// NewRunner returns a runner with all compiled-in plugins registered.
+func NewRunner() *Runner {
+	r := &Runner{hooks: make(map[Point][]Hook), failOpen: make(map[Hook]bool)}
+	pluginsMu.Lock()
+	for _, pl := range plugins {
+		r.hooks[pl.point] = append(r.hooks[pl.point], pl.hook)
+	}
+	pluginsMu.Unlock()
+	return r
+}
+
+// Register attaches h at p. If failOpen is set, errors from h are logged
+// and processing continues; aborts are always honoured.
+func (r *Runner) Register(p Point, h Hook, failOpen bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks[p] = append(r.hooks[p], h)
+	if failOpen {
+		r.failOpen[h] = true
+	}
+}
+
+// Run passes hc through every hook at its point. It returns the first
+// *AbortError, or the first error from a fail-closed hook.
+func (r *Runner) Run(ctx context.Context, hc *Context) error {
+	r.mu.RLock()
+	hooks := append([]Hook(nil), r.hooks[hc.Point]...)
+	r.mu.RUnlock()
+
+	for _, h := range hooks {
+		err := h.Run(ctx, hc)
+		if err == nil {
+			continue
+		}
+		var abort *AbortError
+		if errors.As(err, &abort) {
+			if abort.Hook == "" {
+				abort.Hook = h.Name()
+			}
+			return abort
+		}
+		r.mu.RLock()
+		open := r.failOpen[h]
+		r.mu.RUnlock()
+		if open {
+			log.Error().Str("hook", h.Name()).Str("point", string(hc.Point)).Err(err).Msg("Hook failed, continuing")
+			continue
+		}
+		return fmt.Errorf("hook %s at %s: %w", h.Name(), hc.Point, err)
+	}
+	return nil
+}
+
+// Registered lists hook names per point.
+func (r *Runner) Registered() map[Point][]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make(map[Point][]string)
+	for p, hooks := range r.hooks {
+		for _, h := range hooks {
+			out[p] = append(out[p], h.Name())
+		}
+	}
+	return out
+}
@@ -0,0 +1,114 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"web-chatbot-backend/internal/filestore"
+)
+
+// HTTPHook posts the hook context to an external URL. The response may
+// return replacement "message", "payload" or "reply" values, or set
+// "abort" to stop processing with an optional "abort_reply".
+type HTTPHook struct {
+	HookName string
+	URL      string
+	Client   *http.Client
+}
+
+type httpHookResponse struct {
+	Message    *string                `json:"message"`
+	Payload    map[string]interface{} `json:"payload"`
+	Reply      *string                `json:"reply"`
+	Abort      bool                   `json:"abort"`
+	AbortReply string                 `json:"abort_reply"`
+	Reason     string                 `json:"reason"`
+}
+
+func (h *HTTPHook) Name() string { return h.HookName }
+
+func (h *HTTPHook) Run(ctx context.Context, hc *Context) error {
+	body, err := json.Marshal(hc)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("hook responded with status %d", resp.StatusCode)
+	}
+
+	var out httpHookResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return fmt.Errorf("invalid hook response: %w", err)
+	}
+	if out.Abort {
+		return &AbortError{Hook: h.HookName, Reply: out.AbortReply, Reason: out.Reason}
+	}
+	if out.Message != nil {
+		hc.Message = *out.Message
+	}
+	if out.Payload != nil {
+		hc.Payload = out.Payload
+	}
+	if out.Reply != nil {
+		hc.Reply = *out.Reply
+	}
+	return nil
+}
+
+// Config describes one external HTTP hook.
+type Config struct {
+	Name     string `json:"name"`
+	Point    Point  `json:"point"`
+	URL      string `json:"url"`
+	Timeout  string `json:"timeout,omitempty"`
+	FailOpen bool   `json:"fail_open"`
+}
+
+// LoadFile registers the HTTP hooks described in a JSON config file. A
+// missing file registers nothing.
+func (r *Runner) LoadFile(path string) error {
+	var configs []Config
+	if err := filestore.Load(path, &configs); err != nil {
+		return err
+	}
+	for _, cfg := range configs {
+		if !cfg.Point.Valid() {
+			return fmt.Errorf("hook %q: unknown point %q", cfg.Name, cfg.Point)
+		}
+		if cfg.URL == "" {
+			return fmt.Errorf("hook %q: missing url", cfg.Name)
+		}
+		timeout := 5 * time.Second
+		if cfg.Timeout != "" {
+			d, err := time.ParseDuration(cfg.Timeout)
+			if err != nil {
+				return fmt.Errorf("hook %q: invalid timeout: %w", cfg.Name, err)
+			}
+			timeout = d
+		}
+		r.Register(cfg.Point, &HTTPHook{
+			HookName: cfg.Name,
+			URL:      cfg.URL,
+			Client:   &http.Client{Timeout: timeout},
+		}, cfg.FailOpen)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+// Package httpclient builds the HTTP client shared by everything that
+// calls the bot and other upstream services, so no call can hang forever
+// on a server that accepts the connection and never answers.
+package httpclient
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Config bounds the time spent on each phase of a request. Zero leaves a
+// phase unbounded, apart from the request's own context.
+type Config struct {
+	// ConnectTimeout bounds dialing, and the TLS handshake.
+	ConnectTimeout time.Duration
+	// ReadTimeout bounds the wait for the response headers once the
+	// request is sent. Streamed bodies can take longer.
+	ReadTimeout time.Duration
+	// Timeout bounds the whole request, body included.
+	Timeout time.Duration
+
+	// Idle keep-alive connections kept for reuse, in total and per host,
+	// and for how long.
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+
+	// TLS configures the client side of TLS connections, see LoadTLS. Nil
+	// uses the system roots and no client certificate.
+	TLS *tls.Config
+}
+
+// New returns a client with its own pooled transport.
+func New(cfg Config) *http.Client {
+	dialer := &net.Dialer{Timeout: cfg.ConnectTimeout, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   cfg.ConnectTimeout,
+		ResponseHeaderTimeout: cfg.ReadTimeout,
+		ExpectContinueTimeout: time.Second,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		TLSClientConfig:       cfg.TLS,
+	}
+	return &http.Client{Transport: transport, Timeout: cfg.Timeout}
+}
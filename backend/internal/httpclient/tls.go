@@ -0,0 +1,59 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLSFiles names the PEM files for TLS to upstreams behind a private CA or
+// a gateway asking for client certificates.
+type TLSFiles struct {
+	// CAFile holds root certificates trusted on top of the system ones.
+	CAFile string
+	// CertFile and KeyFile hold the client certificate, with any
+	// intermediates, and its private key.
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify accepts any server certificate. For development
+	// only.
+	InsecureSkipVerify bool
+}
+
+var errHalfKeyPair = errors.New("a client certificate needs both a certificate and a key file")
+
+// LoadTLS reads the files into a TLS configuration. It returns nil when
+// nothing is set, leaving the defaults.
+func LoadTLS(f TLSFiles) (*tls.Config, error) {
+	if f == (TLSFiles{}) {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: f.InsecureSkipVerify}
+	if f.CAFile != "" {
+		data, err := os.ReadFile(f.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", f.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if (f.CertFile == "") != (f.KeyFile == "") {
+		return nil, errHalfKeyPair
+	}
+	if f.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
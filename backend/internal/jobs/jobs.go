@@ -0,0 +1,116 @@
+// Package jobs runs bulk admin operations in the background and tracks
+// their progress, and works through the persistent job queue of the
+// message store.
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrNotFound = errors.New("job not found")
+
+// Status of a job.
+type Status string
+
+const (
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+)
+
+// maxErrors caps the item errors kept per job.
+const maxErrors = 50
+
+// Job is one bulk operation over a list of items.
+type Job struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	Status     Status     `json:"status"`
+	Total      int        `json:"total"`
+	Done       int        `json:"done"`
+	Failed     int        `json:"failed"`
+	Errors     []string   `json:"errors,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Manager keeps the jobs of this process in memory.
+type Manager struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func NewManager() *Manager {
+	return &Manager{jobs: make(map[string]*Job)}
+}
+
+// Start runs fn for every item in the background and returns the new
+// job. A failing item is recorded and the job moves on to the next one.
+func (m *Manager) Start(jobType string, items []string, fn func(item string) error) *Job {
+	j := &Job{
+		ID:        uuid.NewString(),
+		Type:      jobType,
+		Status:    StatusRunning,
+		Total:     len(items),
+		CreatedAt: time.Now(),
+	}
+	m.mu.Lock()
+	m.jobs[j.ID] = j
+	snapshot := *j
+	m.mu.Unlock()
+
+	go func() {
+		for _, item := range items {
+			err := fn(item)
+			m.mu.Lock()
+			j.Done++
+			if err != nil {
+				j.Failed++
+				if len(j.Errors) < maxErrors {
+					j.Errors = append(j.Errors, fmt.Sprintf("%s: %v", item, err))
+				}
+			}
+			m.mu.Unlock()
+		}
+		now := time.Now()
+		m.mu.Lock()
+		j.Status = StatusCompleted
+		j.FinishedAt = &now
+		m.mu.Unlock()
+	}()
+	return &snapshot
+}
+
+// Get returns a snapshot of a job.
+func (m *Manager) Get(id string) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return j.clone(), nil
+}
+
+// List returns snapshots of all jobs, newest first.
+func (m *Manager) List() []*Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]*Job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		out = append(out, j.clone())
+	}
+	sort.Slice(out, func(i, k int) bool { return out[i].CreatedAt.After(out[k].CreatedAt) })
+	return out
+}
+
+func (j *Job) clone() *Job {
+	c := *j
+	c.Errors = append([]string(nil), j.Errors...)
+	return &c
+}
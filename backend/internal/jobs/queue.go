@@ -0,0 +1,118 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/store"
+)
+
+// Handler runs a queued job. Returning nil completes it, an error from
+// Retry has it run again later and any other error fails it for good.
+type Handler func(ctx context.Context, job store.Job) error
+
+type retryError struct {
+	at  time.Time
+	err error
+}
+
+func (e *retryError) Error() string { return e.err.Error() }
+
+func (e *retryError) Unwrap() error { return e.err }
+
+// Retry returns the error for a job that failed but should be tried again
+// at at.
+func Retry(at time.Time, err error) error {
+	return &retryError{at: at, err: err}
+}
+
+// Runner works through the job queue of a store. Several instances can
+// share one queue: each job is leased to one of them at a time.
+type Runner struct {
+	// Lease is how long a worker has to finish a job before another may
+	// take it over, and Batch how many due jobs are claimed at a time.
+	Lease time.Duration
+	Batch int
+
+	store    *store.Store
+	owner    string
+	handlers map[string]Handler
+}
+
+// NewRunner returns a runner on the queue in st, with a lease of a minute.
+func NewRunner(st *store.Store) *Runner {
+	return &Runner{
+		Lease:    time.Minute,
+		Batch:    20,
+		store:    st,
+		owner:    uuid.NewString(),
+		handlers: make(map[string]Handler),
+	}
+}
+
+// Handle sets the handler of a kind of job. Handlers are set before Run.
+func (r *Runner) Handle(kind string, h Handler) {
+	r.handlers[kind] = h
+}
+
+// Enqueue adds a job, see store.Store.Enqueue.
+func (r *Runner) Enqueue(ctx context.Context, kind, key string, payload any, runAt time.Time) (bool, error) {
+	return r.store.Enqueue(ctx, kind, key, payload, runAt)
+}
+
+// Cancel stops a pending job, see store.Store.Cancel.
+func (r *Runner) Cancel(ctx context.Context, kind, key string) (bool, error) {
+	return r.store.Cancel(ctx, kind, key)
+}
+
+// Run claims and runs due jobs every interval until ctx is cancelled.
+func (r *Runner) Run(ctx context.Context, interval time.Duration) {
+	kinds := make([]string, 0, len(r.handlers))
+	for k := range r.handlers {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.runDue(ctx, kinds)
+		}
+	}
+}
+
+func (r *Runner) runDue(ctx context.Context, kinds []string) {
+	due, err := r.store.Claim(ctx, r.owner, kinds, r.Batch, r.Lease)
+	if err != nil {
+		log.Error().Err(err).Msg("Error claiming jobs")
+	}
+	for _, job := range due {
+		jobCtx, cancel := context.WithTimeout(ctx, r.Lease)
+		err := r.handlers[job.Kind](jobCtx, job)
+		cancel()
+
+		var retry *retryError
+		switch {
+		case err == nil:
+			err = r.store.Complete(ctx, job.ID, r.owner)
+		case errors.As(err, &retry):
+			log.Warn().Str("job_id", job.ID).Str("kind", job.Kind).Int("attempts", job.Attempts).Time("retry_at", retry.at).Err(err).Msg("Job failed, will retry")
+			err = r.store.Retry(ctx, job.ID, r.owner, retry.at, err.Error())
+		default:
+			log.Error().Str("job_id", job.ID).Str("kind", job.Kind).Int("attempts", job.Attempts).Err(err).Msg("Job failed")
+			err = r.store.Fail(ctx, job.ID, r.owner, err.Error())
+		}
+		if err != nil {
+			log.Error().Str("job_id", job.ID).Str("kind", job.Kind).Err(err).Msg("Error recording job outcome")
+		}
+	}
+}
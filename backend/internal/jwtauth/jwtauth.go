@@ -0,0 +1,176 @@
+// Package jwtauth checks the JSON Web Tokens visitors sign in with, signed
+// with a shared secret (HS256) or an RSA key (RS256).
+package jwtauth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformed  = errors.New("malformed token")
+	ErrAlgorithm  = errors.New("unexpected signing algorithm")
+	ErrSignature  = errors.New("invalid token signature")
+	ErrExpired    = errors.New("token has expired")
+	ErrNotYet     = errors.New("token is not valid yet")
+	ErrIssuer     = errors.New("unexpected token issuer")
+	ErrAudience   = errors.New("token is not meant for this server")
+	errNoRSAKey   = errors.New("no RSA public key in PEM data")
+	errNotRSA     = errors.New("public key is not an RSA key")
+	errBadOptions = errors.New("a secret or an RSA key is required")
+)
+
+// Claims are the claims of a verified token.
+type Claims map[string]interface{}
+
+// Subject is the "sub" claim.
+func (c Claims) Subject() string {
+	sub, _ := c["sub"].(string)
+	return sub
+}
+
+// Options configure a Verifier. Exactly one of Secret and Key is set.
+type Options struct {
+	// Secret checks HS256 tokens.
+	Secret []byte
+	// Key checks RS256 tokens.
+	Key *rsa.PublicKey
+	// Issuer and Audience, if set, must match the "iss" and "aud" claims.
+	Issuer   string
+	Audience string
+	// Leeway allows for clock skew when checking "exp" and "nbf".
+	Leeway time.Duration
+}
+
+// Verifier checks tokens against one key.
+type Verifier struct {
+	opts Options
+	alg  string
+}
+
+// New returns a Verifier for opts.
+func New(opts Options) (*Verifier, error) {
+	switch {
+	case len(opts.Secret) > 0 && opts.Key == nil:
+		return &Verifier{opts: opts, alg: "HS256"}, nil
+	case len(opts.Secret) == 0 && opts.Key != nil:
+		return &Verifier{opts: opts, alg: "RS256"}, nil
+	}
+	return nil, errBadOptions
+}
+
+// Algorithm is the signing algorithm accepted, HS256 or RS256.
+func (v *Verifier) Algorithm() string { return v.alg }
+
+// Verify checks the signature and time limits of token and returns its
+// claims.
+func (v *Verifier) Verify(token string, now time.Time) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	// The algorithm is fixed by configuration, never taken from the token
+	if header.Alg != v.alg {
+		return nil, fmt.Errorf("%w %q", ErrAlgorithm, header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	switch v.alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, v.opts.Secret)
+		mac.Write(signed)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return nil, ErrSignature
+		}
+	case "RS256":
+		sum := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(v.opts.Key, crypto.SHA256, sum[:], sig) != nil {
+			return nil, ErrSignature
+		}
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0).Add(v.opts.Leeway)) {
+		return nil, ErrExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(v.opts.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, ErrNotYet
+	}
+	if v.opts.Issuer != "" && claims["iss"] != v.opts.Issuer {
+		return nil, ErrIssuer
+	}
+	if v.opts.Audience != "" && !hasAudience(claims["aud"], v.opts.Audience) {
+		return nil, ErrAudience
+	}
+	return claims, nil
+}
+
+// hasAudience reports whether aud, a string or a list of them, includes
+// want.
+func hasAudience(aud interface{}, want string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == want
+	case []interface{}:
+		for _, a := range aud {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return ErrMalformed
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return ErrMalformed
+	}
+	return nil
+}
+
+// ParseRSAPublicKey reads a PEM encoded RSA public key, either PKIX
+// ("PUBLIC KEY") or PKCS #1 ("RSA PUBLIC KEY").
+func ParseRSAPublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errNoRSAKey
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errNotRSA
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,152 @@
+// Package knowledge keeps question and answer pairs for the bot's
+// knowledge base. Agents' answers that reviewers rate highly are suggested
+// as entries, and an admin approves or rejects each one.
+package knowledge
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"web-chatbot-backend/internal/filestore"
+)
+
+var (
+	ErrNotFound = errors.New("knowledge base entry not found")
+	ErrReviewed = errors.New("knowledge base entry was already reviewed")
+	ErrEmpty    = errors.New("question and answer are required")
+)
+
+// Status is where an entry is in review.
+type Status string
+
+const (
+	StatusPending  Status = "pending"
+	StatusApproved Status = "approved"
+	StatusRejected Status = "rejected"
+)
+
+// Entry is a question and its answer, and where they came from.
+type Entry struct {
+	ID       string `json:"id"`
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+	Status   Status `json:"status"`
+	// The message the answer was taken from, who gave it and how a
+	// reviewer rated it
+	SessionID  string     `json:"session_id,omitempty"`
+	Index      int        `json:"index"`
+	Agent      string     `json:"agent,omitempty"`
+	Quality    int        `json:"quality,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ReviewedBy string     `json:"reviewed_by,omitempty"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty"`
+}
+
+// Store holds entries, saved to a JSON file after every change.
+type Store struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]*Entry
+}
+
+// NewStore loads entries from path.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path, entries: make(map[string]*Entry)}
+	if err := filestore.Load(path, &s.entries); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Suggest queues e for review. A message is suggested once; a later
+// suggestion from it only raises the quality of one still pending. It
+// reports whether the entry is new.
+func (s *Store) Suggest(e Entry) (Entry, bool, error) {
+	e.Question = strings.TrimSpace(e.Question)
+	e.Answer = strings.TrimSpace(e.Answer)
+	if e.Question == "" || e.Answer == "" {
+		return Entry{}, false, ErrEmpty
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.entries {
+		if existing.SessionID != e.SessionID || existing.Index != e.Index {
+			continue
+		}
+		if existing.Status != StatusPending || e.Quality <= existing.Quality {
+			return *existing, false, nil
+		}
+		existing.Quality = e.Quality
+		return *existing, false, s.save()
+	}
+	e.ID = uuid.NewString()
+	e.Status = StatusPending
+	e.CreatedAt = time.Now()
+	e.ReviewedBy, e.ReviewedAt = "", nil
+	s.entries[e.ID] = &e
+	return e, true, s.save()
+}
+
+// Review approves or rejects a pending entry. When approving, a non-empty
+// question or answer replaces the suggested one.
+func (s *Store) Review(id string, approve bool, by, question, answer string) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[id]
+	if !ok {
+		return Entry{}, ErrNotFound
+	}
+	if e.Status != StatusPending {
+		return Entry{}, ErrReviewed
+	}
+	now := time.Now()
+	e.Status = StatusRejected
+	if approve {
+		e.Status = StatusApproved
+		if q := strings.TrimSpace(question); q != "" {
+			e.Question = q
+		}
+		if a := strings.TrimSpace(answer); a != "" {
+			e.Answer = a
+		}
+	}
+	e.ReviewedBy, e.ReviewedAt = by, &now
+	return *e, s.save()
+}
+
+// Remove deletes an entry.
+func (s *Store) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.entries, id)
+	return s.save()
+}
+
+// List returns the entries with status, or all of them if it is empty,
+// oldest first.
+func (s *Store) List(status Status) []Entry {
+	s.mu.Lock()
+	out := make([]Entry, 0)
+	for _, e := range s.entries {
+		if status == "" || e.Status == status {
+			out = append(out, *e)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+// save writes the entries to disk. Callers hold s.mu.
+func (s *Store) save() error {
+	return filestore.Save(s.path, s.entries)
+}
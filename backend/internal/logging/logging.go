@@ -0,0 +1,96 @@
+// Package logging configures the server's structured logger. Level and
+// format can be changed while the server runs.
+package logging
+
+import (
+	"errors"
+	"io"
+	stdlog "log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Formats
+const (
+	JSON    = "json"
+	Console = "console"
+)
+
+var (
+	ErrUnknownLevel  = errors.New(`log level must be one of "trace", "debug", "info", "warn", "error"`)
+	ErrUnknownFormat = errors.New(`log format must be "json" or "console"`)
+)
+
+// output lets the format change without replacing the global logger,
+// which other goroutines read without locking.
+var output = &switchWriter{w: os.Stderr, format: JSON}
+
+type switchWriter struct {
+	mu     sync.RWMutex
+	w      io.Writer
+	format string
+}
+
+func (s *switchWriter) Write(p []byte) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.w.Write(p)
+}
+
+func init() {
+	zerolog.TimeFieldFormat = time.RFC3339Nano
+	log.Logger = zerolog.New(output).With().Timestamp().Logger()
+	// log.Ctx falls back to the global logger for contexts without one
+	zerolog.DefaultContextLogger = &log.Logger
+	// Anything still logged through the standard library ends up here too
+	stdlog.SetFlags(0)
+	stdlog.SetOutput(log.Logger)
+}
+
+// Configure sets the lowest level logged, e.g. "debug" or "warn", and the
+// format, JSON or console. An empty value leaves that setting as it is.
+func Configure(level, format string) error {
+	var lvl zerolog.Level
+	if level != "" {
+		var err error
+		if lvl, err = zerolog.ParseLevel(level); err != nil || lvl == zerolog.NoLevel {
+			return ErrUnknownLevel
+		}
+	}
+	var w io.Writer
+	switch format {
+	case "":
+	case JSON:
+		w = os.Stderr
+	case Console:
+		w = zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339}
+	default:
+		return ErrUnknownFormat
+	}
+
+	if level != "" {
+		zerolog.SetGlobalLevel(lvl)
+	}
+	if w != nil {
+		output.mu.Lock()
+		output.w, output.format = w, format
+		output.mu.Unlock()
+	}
+	return nil
+}
+
+// Level is the lowest level logged.
+func Level() string {
+	return zerolog.GlobalLevel().String()
+}
+
+// Format is the current format.
+func Format() string {
+	output.mu.RLock()
+	defer output.mu.RUnlock()
+	return output.format
+}
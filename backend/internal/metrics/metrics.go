@@ -0,0 +1,188 @@
+// Package metrics exports chat traffic to Prometheus, labelled by tenant
+// and bot provider. Label values are guarded so a server with many
+// tenants cannot create an unbounded number of series.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"web-chatbot-backend/internal/session"
+)
+
+// Other replaces label values past the limit.
+const Other = "other"
+
+// Guard bounds the values of one label. With an allowlist only the listed
+// values are kept; otherwise the first Max distinct values seen are. Every
+// other value is reported as Other.
+type Guard struct {
+	mu    sync.Mutex
+	allow map[string]bool
+	seen  map[string]bool
+	max   int
+}
+
+// NewGuard returns a guard keeping the values in allow or, if allow is
+// empty, the first max values seen. max <= 0 with no allowlist keeps no
+// values at all.
+func NewGuard(allow []string, max int) *Guard {
+	g := &Guard{seen: make(map[string]bool), max: max}
+	if len(allow) > 0 {
+		g.allow = make(map[string]bool, len(allow))
+		for _, v := range allow {
+			g.allow[v] = true
+		}
+	}
+	return g
+}
+
+// Value returns the label value to report for v.
+func (g *Guard) Value(v string) string {
+	if g.allow != nil {
+		if g.allow[v] {
+			return v
+		}
+		return Other
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.seen[v] {
+		return v
+	}
+	if len(g.seen) >= g.max {
+		return Other
+	}
+	g.seen[v] = true
+	return v
+}
+
+// Config sets the label guards.
+type Config struct {
+	Tenants   *Guard
+	Providers *Guard
+}
+
+// Metrics holds the collectors.
+type Metrics struct {
+	cfg      Config
+	registry *prometheus.Registry
+
+	messages    *prometheus.CounterVec
+	botRequests *prometheus.CounterVec
+	botLatency  *prometheus.HistogramVec
+	botErrors   *prometheus.CounterVec
+	phases      *prometheus.HistogramVec
+	routed      *prometheus.CounterVec
+	routeTimes  *prometheus.HistogramVec
+}
+
+func New(cfg Config) *Metrics {
+	m := &Metrics{
+		cfg:      cfg,
+		registry: prometheus.NewRegistry(),
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chatbot_messages_total",
+			Help: "Visitor messages answered, by outcome.",
+		}, []string{"tenant", "provider", "outcome"}),
+		botRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chatbot_bot_requests_total",
+			Help: "Calls to the bot, by HTTP status class.",
+		}, []string{"tenant", "provider", "status"}),
+		botLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "chatbot_bot_request_duration_seconds",
+			Help:    "How long the bot took to answer.",
+			Buckets: []float64{.1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"tenant", "provider"}),
+		botErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chatbot_bot_errors_total",
+			Help: "Failed calls to the bot, by kind of failure.",
+		}, []string{"tenant", "provider", "class"}),
+		phases: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "chatbot_reply_phase_duration_seconds",
+			Help:    "Time spent answering visitor messages, by phase.",
+			Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"tenant", "phase"}),
+		routed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chatbot_routed_messages_total",
+			Help: "Visitor messages sent to each workflow route, by how the route was picked and outcome.",
+		}, []string{"tenant", "route", "by", "outcome"}),
+		routeTimes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "chatbot_route_request_duration_seconds",
+			Help:    "How long the workflow of each route took to answer.",
+			Buckets: []float64{.1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"tenant", "route"}),
+	}
+	m.registry.MustRegister(m.messages, m.botRequests, m.botLatency, m.botErrors, m.phases, m.routed, m.routeTimes,
+		collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return m
+}
+
+// CountMessage records one answered visitor message. failed is set when
+// the visitor got an apology instead of a reply.
+func (m *Metrics) CountMessage(tenant, provider string, failed bool) {
+	outcome := "ok"
+	if failed {
+		outcome = "error"
+	}
+	m.messages.WithLabelValues(m.cfg.Tenants.Value(tenant), m.cfg.Providers.Value(provider), outcome).Inc()
+}
+
+// ObserveBotCall records one call to the bot. status is 0 when no
+// response arrived.
+func (m *Metrics) ObserveBotCall(tenant, provider string, status int, took time.Duration) {
+	tenant, provider = m.cfg.Tenants.Value(tenant), m.cfg.Providers.Value(provider)
+	m.botRequests.WithLabelValues(tenant, provider, statusClass(status)).Inc()
+	m.botLatency.WithLabelValues(tenant, provider).Observe(took.Seconds())
+}
+
+// CountBotError records one failed call to the bot. class is one of the
+// fixed failure classes, see provider.Class.
+func (m *Metrics) CountBotError(tenant, provider, class string) {
+	m.botErrors.WithLabelValues(m.cfg.Tenants.Value(tenant), m.cfg.Providers.Value(provider), class).Inc()
+}
+
+// ObserveTiming records the phases of one reply.
+func (m *Metrics) ObserveTiming(tenant string, t session.Timing) {
+	tenant = m.cfg.Tenants.Value(tenant)
+	for phase, ms := range map[string]int64{
+		"queue":      t.QueueMS,
+		"upstream":   t.UpstreamMS,
+		"processing": t.ProcessingMS,
+		"delivery":   t.DeliveryMS,
+		"total":      t.TotalMS,
+	} {
+		m.phases.WithLabelValues(tenant, phase).Observe(float64(ms) / 1000)
+	}
+}
+
+// ObserveRoute records one bot call on a workflow route. Routes come from
+// the routing file, so their names need no guard.
+func (m *Metrics) ObserveRoute(tenant, route, by string, failed bool, took time.Duration) {
+	tenant = m.cfg.Tenants.Value(tenant)
+	outcome := "ok"
+	if failed {
+		outcome = "error"
+	}
+	m.routed.WithLabelValues(tenant, route, by, outcome).Inc()
+	m.routeTimes.WithLabelValues(tenant, route).Observe(took.Seconds())
+}
+
+// statusClass turns a status into e.g. "2xx", keeping the label bounded.
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "none"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,179 @@
+// Package operators keeps the people who use the admin API and the
+// tokens they sign in with, so admin requests can be told apart by who
+// made them. Only a SHA-256 hash of each token is stored; the token
+// itself is shown once, when it is issued.
+package operators
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"web-chatbot-backend/internal/filestore"
+)
+
+var (
+	ErrNotFound = errors.New("operator not found")
+	ErrExists   = errors.New("operator already exists")
+	ErrNoName   = errors.New("operator name is required")
+	ErrInvalid  = errors.New("invalid operator token")
+)
+
+// Prefix starts every token, so leaked tokens are easy to search for.
+const Prefix = "cbo_"
+
+// Operator is one person using the admin API.
+type Operator struct {
+	Name string `json:"name"`
+	// Hint is the start of the token, to tell tokens apart.
+	Hint      string     `json:"hint"`
+	Hash      string     `json:"hash"`
+	CreatedAt time.Time  `json:"created_at"`
+	CreatedBy string     `json:"created_by,omitempty"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+}
+
+// Store holds the operators by name, saved to a JSON file after every
+// change.
+type Store struct {
+	mu        sync.Mutex
+	path      string
+	operators map[string]*Operator
+	byHash    map[string]*Operator
+}
+
+// NewStore loads operators from path.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path, operators: make(map[string]*Operator), byHash: make(map[string]*Operator)}
+	if err := filestore.Load(path, &s.operators); err != nil {
+		return nil, err
+	}
+	for _, o := range s.operators {
+		s.byHash[o.Hash] = o
+	}
+	return s, nil
+}
+
+// Create adds an operator and returns the token they sign in with, which
+// cannot be recovered later.
+func (s *Store) Create(name, by string) (*Operator, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", ErrNoName
+	}
+	token, err := newToken()
+	if err != nil {
+		return nil, "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.operators[name]; ok {
+		return nil, "", ErrExists
+	}
+	o := &Operator{Name: name, Hint: token[:len(Prefix)+6], Hash: hash(token), CreatedAt: time.Now(), CreatedBy: by}
+	s.operators[name] = o
+	s.byHash[o.Hash] = o
+	if err := s.save(); err != nil {
+		delete(s.operators, name)
+		delete(s.byHash, o.Hash)
+		return nil, "", err
+	}
+	c := *o
+	return &c, token, nil
+}
+
+// Rotate replaces an operator's token, which stops working at once, and
+// returns the new one.
+func (s *Store) Rotate(name string) (*Operator, string, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.operators[name]
+	if !ok {
+		return nil, "", ErrNotFound
+	}
+	now := time.Now()
+	delete(s.byHash, o.Hash)
+	o.Hint, o.Hash, o.RotatedAt = token[:len(Prefix)+6], hash(token), &now
+	s.byHash[o.Hash] = o
+	if err := s.save(); err != nil {
+		return nil, "", err
+	}
+	c := *o
+	return &c, token, nil
+}
+
+// Authenticate returns the operator token belongs to.
+func (s *Store) Authenticate(token string) (*Operator, error) {
+	if !strings.HasPrefix(token, Prefix) {
+		return nil, ErrInvalid
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.byHash[hash(token)]
+	if !ok {
+		return nil, ErrInvalid
+	}
+	c := *o
+	return &c, nil
+}
+
+// Exists reports whether name is an operator.
+func (s *Store) Exists(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.operators[name]
+	return ok
+}
+
+// List returns every operator by name.
+func (s *Store) List() []Operator {
+	s.mu.Lock()
+	out := make([]Operator, 0, len(s.operators))
+	for _, o := range s.operators {
+		out = append(out, *o)
+	}
+	s.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Remove drops an operator, whose token stops working at once.
+func (s *Store) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.operators[name]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.operators, name)
+	delete(s.byHash, o.Hash)
+	return s.save()
+}
+
+// newToken returns a new random token.
+func newToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return Prefix + hex.EncodeToString(raw), nil
+}
+
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// save writes the operators to disk. Callers hold s.mu.
+func (s *Store) save() error {
+	return filestore.Save(s.path, s.operators)
+}
@@ -0,0 +1,63 @@
+package operators
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "operators.json")
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op, token, err := s.Create("alice", "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, Prefix) || op.Hint != token[:len(Prefix)+6] {
+		t.Errorf("token %q, hint %q", token, op.Hint)
+	}
+	if _, _, err := s.Create("alice", "root"); !errors.Is(err, ErrExists) {
+		t.Errorf("second alice: err = %v, want ErrExists", err)
+	}
+	if _, _, err := s.Create(" ", "root"); !errors.Is(err, ErrNoName) {
+		t.Errorf("blank name: err = %v, want ErrNoName", err)
+	}
+
+	reloaded, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := reloaded.Authenticate(token); err != nil || got.Name != "alice" {
+		t.Fatalf("Authenticate = %v, %v", got, err)
+	}
+	for _, bad := range []string{"", "cbo_", token + "0", strings.TrimPrefix(token, Prefix)} {
+		if _, err := reloaded.Authenticate(bad); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Authenticate(%q): err = %v, want ErrInvalid", bad, err)
+		}
+	}
+
+	_, rotated, err := reloaded.Rotate("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reloaded.Authenticate(token); !errors.Is(err, ErrInvalid) {
+		t.Errorf("old token after rotation: err = %v", err)
+	}
+	if _, err := reloaded.Authenticate(rotated); err != nil {
+		t.Errorf("new token: %v", err)
+	}
+
+	if err := reloaded.Remove("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reloaded.Authenticate(rotated); !errors.Is(err, ErrInvalid) {
+		t.Errorf("token of a removed operator: err = %v", err)
+	}
+	if err := reloaded.Remove("alice"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("removing again: err = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,77 @@
+// Package page implements the cursor pagination shared by all list
+// endpoints. Cursors are opaque to clients: they pass back next_cursor
+// from the previous page until has_more is false.
+package page
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 200
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Request selects one page.
+type Request struct {
+	Offset int
+	Limit  int
+}
+
+// Parse reads a cursor and page size from a request. An empty cursor
+// starts at the first page; a limit of zero or less uses DefaultLimit, and
+// limits above MaxLimit are capped.
+func Parse(cursor string, limit int) (Request, error) {
+	r := Request{Limit: limit}
+	if r.Limit <= 0 {
+		r.Limit = DefaultLimit
+	}
+	if r.Limit > MaxLimit {
+		r.Limit = MaxLimit
+	}
+	if cursor == "" {
+		return r, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return r, ErrInvalidCursor
+	}
+	offset, ok := strings.CutPrefix(string(raw), "o:")
+	if !ok {
+		return r, ErrInvalidCursor
+	}
+	r.Offset, err = strconv.Atoi(offset)
+	if err != nil || r.Offset < 0 {
+		return r, ErrInvalidCursor
+	}
+	return r, nil
+}
+
+// Info is returned alongside every page.
+type Info struct {
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+	Total      int    `json:"total"`
+}
+
+// Apply cuts the requested page out of items, which must be in a stable
+// order.
+func Apply[T any](items []T, r Request) ([]T, Info) {
+	info := Info{Total: len(items)}
+	if r.Offset >= len(items) {
+		return []T{}, info
+	}
+	end := r.Offset + r.Limit
+	if end < len(items) {
+		info.HasMore = true
+		info.NextCursor = base64.RawURLEncoding.EncodeToString([]byte("o:" + strconv.Itoa(end)))
+	} else {
+		end = len(items)
+	}
+	return items[r.Offset:end], info
+}
@@ -0,0 +1,100 @@
+// Package pagecontext checks what the widget tells the backend about the
+// page a visitor is chatting from, which is passed on to the bot so the
+// workflow can tailor its answers.
+package pagecontext
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"golang.org/x/text/language"
+)
+
+// Limits on what the widget may send
+const (
+	maxURLLength       = 2048
+	maxUserAgentLength = 512
+)
+
+var (
+	ErrInvalidURL    = errors.New("must be an http or https URL")
+	ErrTooLong       = errors.New("is too long")
+	ErrInvalidLocale = errors.New("must be a BCP 47 language tag such as en-GB")
+	ErrTooManyKeys   = errors.New("metadata has too many keys")
+	ErrInvalidKey    = errors.New("metadata keys must be 1 to 64 letters, digits, '_', '-' or '.'")
+	ErrTooLarge      = errors.New("metadata is too large")
+)
+
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// Context describes the page a message was sent from.
+type Context struct {
+	PageURL   string `json:"page_url,omitempty"`
+	Referrer  string `json:"referrer,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	Locale    string `json:"locale,omitempty"`
+	// Metadata is anything else the site wants the workflow to know, such
+	// as the product being viewed or the cart total.
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+// Limits caps the metadata: MaxKeys top-level keys and MaxBytes of JSON.
+type Limits struct {
+	MaxKeys  int
+	MaxBytes int
+}
+
+// Validate checks every field, and normalizes the locale.
+func (c *Context) Validate(l Limits) error {
+	if err := checkURL(c.PageURL); err != nil {
+		return fmt.Errorf("page_url %w", err)
+	}
+	if err := checkURL(c.Referrer); err != nil {
+		return fmt.Errorf("referrer %w", err)
+	}
+	if len(c.UserAgent) > maxUserAgentLength {
+		return fmt.Errorf("user_agent %w", ErrTooLong)
+	}
+	if c.Locale != "" {
+		tag, err := language.Parse(c.Locale)
+		if err != nil {
+			return fmt.Errorf("locale %w", ErrInvalidLocale)
+		}
+		c.Locale = tag.String()
+	}
+	if len(c.Metadata) > l.MaxKeys {
+		return ErrTooManyKeys
+	}
+	for key := range c.Metadata {
+		if !keyPattern.MatchString(key) {
+			return fmt.Errorf("%w, got %q", ErrInvalidKey, key)
+		}
+	}
+	if len(c.Metadata) > 0 {
+		raw, err := json.Marshal(c.Metadata)
+		if err != nil {
+			return err
+		}
+		if len(raw) > l.MaxBytes {
+			return ErrTooLarge
+		}
+	}
+	return nil
+}
+
+func checkURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	if len(raw) > maxURLLength {
+		return ErrTooLong
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidURL
+	}
+	return nil
+}
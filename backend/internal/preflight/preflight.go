@@ -0,0 +1,95 @@
+// Package preflight checks the configuration before the server takes any
+// traffic and reports every problem at once, rather than one at a time as
+// each feature trips over its own settings.
+package preflight
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Severity says what a failed check means for the tenant.
+type Severity string
+
+const (
+	// Fatal problems keep the server from starting.
+	Fatal Severity = "fatal"
+	// Degraded problems break one feature; the tenant is served without it.
+	Degraded Severity = "degraded"
+)
+
+// Check is one thing to validate for a tenant.
+type Check struct {
+	Tenant   string
+	Name     string
+	Severity Severity
+	Run      func() error
+}
+
+// Problem is a failed check.
+type Problem struct {
+	Tenant   string   `json:"tenant"`
+	Check    string   `json:"check"`
+	Severity Severity `json:"severity"`
+	Error    string   `json:"error"`
+}
+
+// Report is the outcome of a run.
+type Report struct {
+	CheckedAt time.Time `json:"checked_at"`
+	Checks    int       `json:"checks"`
+	Problems  []Problem `json:"problems"`
+}
+
+// Run runs every check, in order.
+func Run(checks []Check) Report {
+	r := Report{CheckedAt: time.Now(), Checks: len(checks), Problems: []Problem{}}
+	for _, c := range checks {
+		if err := c.Run(); err != nil {
+			r.Problems = append(r.Problems, Problem{Tenant: c.Tenant, Check: c.Name, Severity: c.Severity, Error: err.Error()})
+		}
+	}
+	return r
+}
+
+// Fatal reports whether any problem keeps the server from starting.
+func (r Report) Fatal() bool {
+	for _, p := range r.Problems {
+		if p.Severity == Fatal {
+			return true
+		}
+	}
+	return false
+}
+
+// Degraded reports whether tenant has problems that break a feature.
+func (r Report) Degraded(tenant string) bool {
+	for _, p := range r.Problems {
+		if p.Tenant == tenant {
+			return true
+		}
+	}
+	return false
+}
+
+// URL returns a check function that passes if raw is empty or an absolute
+// URL with one of schemes.
+func URL(raw string, schemes ...string) func() error {
+	return func() error {
+		if raw == "" {
+			return nil
+		}
+		u, err := url.Parse(raw)
+		if err != nil {
+			return err
+		}
+		for _, s := range schemes {
+			if u.Scheme == s && u.Host != "" {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not a URL with scheme %s", raw, strings.Join(schemes, " or "))
+	}
+}
@@ -0,0 +1,138 @@
+package provider
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+var (
+	// ErrParse is returned when a response is not in the format the bot
+	// promised, e.g. broken JSON.
+	ErrParse = errors.New("could not parse the bot response")
+	// ErrSchema is returned when a response parses but does not hold a
+	// reply where one is expected.
+	ErrSchema = errors.New("bot response does not match the reply format")
+)
+
+// StatusError is returned when the bot answers with a status other than
+// 2xx.
+type StatusError struct {
+	Status int
+	// Body is the start of the response, for logs.
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("bot responded with status %d", e.Status)
+	}
+	return fmt.Sprintf("bot responded with status %d: %s", e.Status, e.Body)
+}
+
+// statusError returns the error for a non-2xx response with body.
+func statusError(status int, body []byte) error {
+	const maxBody = 200
+	text := strings.TrimSpace(string(body))
+	if len(text) > maxBody {
+		text = text[:maxBody]
+	}
+	return &StatusError{Status: status, Body: text}
+}
+
+// Class is the kind of failure a call to the bot ran into.
+type Class string
+
+const (
+	ClassDNS              Class = "dns"
+	ClassTLS              Class = "tls"
+	ClassConnect          Class = "connect"
+	ClassConnectTimeout   Class = "connect_timeout"
+	ClassTimeout          Class = "timeout"
+	ClassCanceled         Class = "canceled"
+	ClassClientError      Class = "4xx"
+	ClassServerError      Class = "5xx"
+	ClassRead             Class = "read"
+	ClassParse            Class = "parse"
+	ClassSchema           Class = "schema"
+	ClassRequestTooLarge  Class = "request_too_large"
+	ClassResponseTooLarge Class = "response_too_large"
+	ClassOther            Class = "other"
+)
+
+// Classes lists every class, for validating filters.
+var Classes = []Class{
+	ClassDNS, ClassTLS, ClassConnect, ClassConnectTimeout, ClassTimeout, ClassCanceled,
+	ClassClientError, ClassServerError, ClassRead, ClassParse, ClassSchema,
+	ClassRequestTooLarge, ClassResponseTooLarge, ClassOther,
+}
+
+// Classify returns the class of err, which SendMessage returned. It is
+// empty for a nil error.
+func Classify(err error) Class {
+	if err == nil {
+		return ""
+	}
+	var status *StatusError
+	if errors.As(err, &status) {
+		if status.Status >= 500 {
+			return ClassServerError
+		}
+		return ClassClientError
+	}
+	switch {
+	case errors.Is(err, ErrRequestTooLarge):
+		return ClassRequestTooLarge
+	case errors.Is(err, ErrResponseTooLarge):
+		return ClassResponseTooLarge
+	case errors.Is(err, ErrSchema):
+		return ClassSchema
+	case errors.Is(err, ErrParse):
+		return ClassParse
+	case errors.Is(err, ErrUnreadable):
+		return ClassRead
+	case errors.Is(err, context.Canceled):
+		return ClassCanceled
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return ClassDNS
+	}
+	if isTLSError(err) {
+		return ClassTLS
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		if opErr.Timeout() {
+			return ClassConnectTimeout
+		}
+		return ClassConnect
+	}
+	// The transport's handshake timeout has no type of its own
+	if strings.Contains(err.Error(), "TLS handshake timeout") {
+		return ClassConnectTimeout
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return ClassTimeout
+	}
+	return ClassOther
+}
+
+func isTLSError(err error) bool {
+	var (
+		record    tls.RecordHeaderError
+		alert     tls.AlertError
+		verify    *tls.CertificateVerificationError
+		authority x509.UnknownAuthorityError
+		hostname  x509.HostnameError
+		invalid   x509.CertificateInvalidError
+	)
+	return errors.As(err, &record) || errors.As(err, &alert) || errors.As(err, &verify) ||
+		errors.As(err, &authority) || errors.As(err, &hostname) || errors.As(err, &invalid)
+}
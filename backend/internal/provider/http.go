@@ -0,0 +1,88 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"web-chatbot-backend/internal/signing"
+)
+
+// HTTP posts each conversation to any HTTP service that takes the webhook
+// payload. Services that put their answer somewhere other than "reply"
+// name where in ReplyField.
+type HTTP struct {
+	URL string
+	// Header is sent with every call, e.g. for an Authorization header.
+	Header http.Header
+	// ReplyField is the dot-separated path of the reply text in a JSON
+	// response, e.g. "data.answer". Empty means the webhook reply format.
+	ReplyField string
+	// Secret, if set, signs each call; see package signing.
+	Secret string
+	Limits Limits
+	Client *http.Client
+}
+
+// NewHTTP returns the provider for the service at url.
+func NewHTTP(url string, header http.Header, replyField string, limits Limits) *HTTP {
+	return &HTTP{URL: url, Header: header, ReplyField: replyField, Limits: limits}
+}
+
+func (h *HTTP) SendMessage(ctx context.Context, conv Conversation) (Reply, error) {
+	body, err := Encode(conv.Payload, h.Limits.Request)
+	if err != nil {
+		return Reply{}, err
+	}
+	header := h.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	if h.Secret != "" {
+		signing.Sign(header, h.Secret, body, time.Now())
+	}
+	resp, err := post(ctx, h.Client, h.URL, body, header)
+	if err != nil {
+		return Reply{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err = readResponse(resp, h.Limits.Response, conv.OnDelta)
+	if err != nil {
+		return Reply{Status: resp.StatusCode}, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return Reply{Status: resp.StatusCode}, statusError(resp.StatusCode, body)
+	}
+	if h.ReplyField == "" {
+		return Reply{Status: resp.StatusCode, Body: body, ContentType: bodyType(resp)}, nil
+	}
+
+	var obj map[string]any
+	if err := json.Unmarshal(body, &obj); err != nil {
+		return Reply{Status: resp.StatusCode}, fmt.Errorf("%w: response is not a JSON object", ErrParse)
+	}
+	text, ok := lookup(obj, h.ReplyField).(string)
+	if !ok {
+		return Reply{Status: resp.StatusCode}, fmt.Errorf("%w: no text at %q", ErrSchema, h.ReplyField)
+	}
+	obj["reply"] = text
+	body, err = json.Marshal(obj)
+	return Reply{Status: resp.StatusCode, Body: body, ContentType: jsonType}, err
+}
+
+// lookup follows a dot-separated path through nested objects.
+func lookup(obj map[string]any, path string) any {
+	var v any = obj
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
@@ -0,0 +1,73 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"web-chatbot-backend/internal/signing"
+)
+
+// ErrNotRegistered is returned when n8n answers that the webhook is not
+// registered: the workflow is not active, or a test URL is used while the
+// editor is not listening for a test event. It does not go away by itself.
+var ErrNotRegistered = errors.New("n8n webhook is not registered")
+
+// NotRegistered reports whether a response is n8n's answer for a webhook
+// that is not registered.
+func NotRegistered(status int, body []byte) bool {
+	if status != http.StatusNotFound {
+		return false
+	}
+	var resp struct {
+		Message string `json:"message"`
+	}
+	return json.Unmarshal(body, &resp) == nil && strings.Contains(resp.Message, "not registered")
+}
+
+// N8N posts each conversation to an n8n webhook, which answers in the
+// webhook reply format itself.
+type N8N struct {
+	URL string
+	// Secret, if set, signs each call; see package signing.
+	Secret string
+	Limits Limits
+	Client *http.Client
+}
+
+// NewN8N returns the provider for the n8n webhook at url.
+func NewN8N(url, secret string, limits Limits) *N8N {
+	return &N8N{URL: url, Secret: secret, Limits: limits}
+}
+
+func (n *N8N) SendMessage(ctx context.Context, conv Conversation) (Reply, error) {
+	body, err := Encode(conv.Payload, n.Limits.Request)
+	if err != nil {
+		return Reply{}, err
+	}
+	header := make(http.Header)
+	if n.Secret != "" {
+		signing.Sign(header, n.Secret, body, time.Now())
+	}
+	resp, err := post(ctx, n.Client, n.URL, body, header)
+	if err != nil {
+		return Reply{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err = readResponse(resp, n.Limits.Response, conv.OnDelta)
+	if err != nil {
+		return Reply{Status: resp.StatusCode}, err
+	}
+	if NotRegistered(resp.StatusCode, body) {
+		return Reply{Status: resp.StatusCode}, fmt.Errorf("%w: %w", ErrNotRegistered, statusError(resp.StatusCode, body))
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return Reply{Status: resp.StatusCode}, statusError(resp.StatusCode, body)
+	}
+	return Reply{Status: resp.StatusCode, Body: body, ContentType: bodyType(resp)}, nil
+}
@@ -0,0 +1,140 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"web-chatbot-backend/internal/session"
+)
+
+// Used when the published configuration has no prompt
+const defaultSystemPrompt = "You are a friendly assistant chatting with visitors of a website. Keep your answers short."
+
+// OpenAI asks a model through the OpenAI Chat Completions API, or any
+// service compatible with it. The prompt, summary, memory and history of
+// the payload become the chat messages; actions, quick replies and rich
+// content are not available to it.
+type OpenAI struct {
+	// URL is the chat completions endpoint,
+	// e.g. https://api.openai.com/v1/chat/completions.
+	URL    string
+	APIKey string
+	Model  string
+	Limits Limits
+	Client *http.Client
+}
+
+// NewOpenAI returns the provider for model at url.
+func NewOpenAI(url, apiKey, model string, limits Limits) *OpenAI {
+	return &OpenAI{URL: url, APIKey: apiKey, Model: model, Limits: limits}
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+func (o *OpenAI) SendMessage(ctx context.Context, conv Conversation) (Reply, error) {
+	request := map[string]any{"model": o.Model, "messages": chatMessages(conv.Payload)}
+	if conv.OnDelta != nil {
+		request["stream"] = true
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return Reply{}, err
+	}
+	if len(body) > o.Limits.Request {
+		return Reply{}, fmt.Errorf("%w: %d bytes", ErrRequestTooLarge, len(body))
+	}
+
+	header := http.Header{"Authorization": {"Bearer " + o.APIKey}}
+	resp, err := post(ctx, o.Client, o.URL, body, header)
+	if err != nil {
+		return Reply{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err = readResponse(resp, o.Limits.Response, conv.OnDelta)
+	if err != nil {
+		return Reply{Status: resp.StatusCode}, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var failure struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.Unmarshal(body, &failure)
+		return Reply{Status: resp.StatusCode}, &StatusError{Status: resp.StatusCode, Body: failure.Error.Message}
+	}
+	if conv.OnDelta != nil {
+		// Streamed responses are already in the reply format
+		return Reply{Status: resp.StatusCode, Body: body, ContentType: jsonType}, nil
+	}
+
+	var completion struct {
+		Choices []struct {
+			Message chatMessage `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(body, &completion); err != nil {
+		return Reply{Status: resp.StatusCode}, fmt.Errorf("%w: %v", ErrParse, err)
+	}
+	if len(completion.Choices) == 0 {
+		return Reply{Status: resp.StatusCode}, fmt.Errorf("%w: no choices", ErrSchema)
+	}
+	body, err = json.Marshal(map[string]string{"reply": completion.Choices[0].Message.Content})
+	return Reply{Status: resp.StatusCode, Body: body, ContentType: jsonType}, err
+}
+
+// chatMessages turns a webhook payload into the messages of a chat
+// completion request.
+func chatMessages(payload map[string]any) []chatMessage {
+	system, _ := payload["prompt"].(string)
+	if system == "" {
+		system = defaultSystemPrompt
+	}
+	summary, _ := payload["summary"].(string)
+	message, _ := payload["message"].(string)
+
+	if payload["mode"] == "summarize" {
+		turns, _ := payload["messages"].([]session.Message)
+		instructions := "Summarize the conversation below in a few sentences, keeping anything needed to carry it on."
+		if summary != "" {
+			instructions += "\n\nSummary of what came before it: " + summary
+		}
+		var transcript strings.Builder
+		for _, t := range turns {
+			fmt.Fprintf(&transcript, "%s: %s\n", t.Role, t.Text)
+		}
+		return []chatMessage{{Role: "system", Content: instructions}, {Role: "user", Content: transcript.String()}}
+	}
+
+	if summary != "" {
+		system += "\n\nSummary of the conversation so far: " + summary
+	}
+	if memory, ok := payload["memory"]; ok {
+		if raw, err := json.Marshal(memory); err == nil {
+			system += "\n\nWhat is known about this conversation: " + string(raw)
+		}
+	}
+	messages := []chatMessage{{Role: "system", Content: system}}
+
+	history, _ := payload["history"].([]session.Message)
+	for _, turn := range history {
+		switch turn.Role {
+		case session.RoleVisitor:
+			messages = append(messages, chatMessage{Role: "user", Content: turn.Text})
+		case session.RoleBot, session.RoleAgent:
+			messages = append(messages, chatMessage{Role: "assistant", Content: turn.Text})
+		}
+	}
+	// The history usually ends with the message itself already
+	if last := messages[len(messages)-1]; last.Role != "user" || last.Content != message {
+		messages = append(messages, chatMessage{Role: "user", Content: message})
+	}
+	return messages
+}
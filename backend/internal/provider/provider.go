@@ -0,0 +1,136 @@
+// Package provider asks a bot for its reply to a visitor message, whether
+// the bot is an n8n workflow, an OpenAI-compatible chat model or any other
+// HTTP service.
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"web-chatbot-backend/internal/requestid"
+	"web-chatbot-backend/internal/session"
+	"web-chatbot-backend/internal/streaming"
+	"web-chatbot-backend/internal/tracing"
+)
+
+var (
+	ErrRequestTooLarge  = errors.New("bot request exceeds the request limit")
+	ErrResponseTooLarge = errors.New("bot response exceeds the response limit")
+	// ErrUnreadable is returned when the response could not be read.
+	ErrUnreadable = errors.New("could not read the bot response")
+)
+
+// BotProvider answers visitor messages.
+type BotProvider interface {
+	SendMessage(ctx context.Context, conv Conversation) (Reply, error)
+}
+
+// Conversation is what a bot is asked to answer.
+type Conversation struct {
+	// Payload is the JSON body webhooks receive: the "message" and,
+	// depending on the call, "prompt", "history", "summary", "memory",
+	// "mode" and so on.
+	Payload map[string]any
+	// OnDelta, if set, is passed the text of a streamed reply as it
+	// arrives.
+	OnDelta func(string)
+}
+
+// Reply is the bot's answer in the webhook reply format: plain text, or a
+// JSON object with a "reply" and optionally "memory", "actions",
+// "quick_replies" and "rich".
+type Reply struct {
+	Body []byte
+	// ContentType is the media type of Body. Streamed responses are
+	// collected into JSON.
+	ContentType string
+	// Status is the HTTP status the bot answered with, also set when
+	// SendMessage fails after the bot answered.
+	Status int
+}
+
+// Limits bound what is exchanged with a bot, in bytes.
+type Limits struct {
+	Request  int
+	Response int
+}
+
+// Encode marshals payload within limit, dropping the oldest history turns
+// if that is what it takes to fit.
+func Encode(payload map[string]any, limit int) ([]byte, error) {
+	body, err := json.Marshal(payload)
+	for err == nil && len(body) > limit {
+		history, _ := payload["history"].([]session.Message)
+		if len(history) == 0 {
+			return nil, fmt.Errorf("%w: %d bytes", ErrRequestTooLarge, len(body))
+		}
+		payload["history"] = history[1:]
+		body, err = json.Marshal(payload)
+	}
+	return body, err
+}
+
+// ReadLimited reads all of r unless it is longer than limit bytes.
+func ReadLimited(r io.Reader, limit int) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnreadable, err)
+	}
+	if len(body) > limit {
+		return nil, fmt.Errorf("%w of %d bytes", ErrResponseTooLarge, limit)
+	}
+	return body, nil
+}
+
+// bodyType returns the content type of the body readResponse reads.
+func bodyType(resp *http.Response) string {
+	contentType := resp.Header.Get("Content-Type")
+	if streaming.Streamed(contentType) {
+		return jsonType
+	}
+	return contentType
+}
+
+const jsonType = "application/json"
+
+// readResponse reads a response body, passing the text of a streamed one
+// to onDelta as it arrives.
+func readResponse(resp *http.Response, limit int, onDelta func(string)) ([]byte, error) {
+	contentType := resp.Header.Get("Content-Type")
+	if !streaming.Streamed(contentType) {
+		return ReadLimited(resp.Body, limit)
+	}
+	body, err := streaming.Read(resp.Body, contentType, limit, onDelta)
+	switch {
+	case errors.Is(err, streaming.ErrTooLarge):
+		return nil, fmt.Errorf("%w: %v", ErrResponseTooLarge, err)
+	case err != nil:
+		return nil, fmt.Errorf("%w: %v", ErrUnreadable, err)
+	}
+	return body, nil
+}
+
+// post sends body as JSON to url with the given extra headers.
+func post(ctx context.Context, client *http.Client, url string, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	tracing.Inject(ctx, req.Header)
+	if id := requestid.From(ctx); id != "" {
+		req.Header.Set(requestid.Header, id)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
@@ -0,0 +1,95 @@
+// Package queue estimates how long visitors waiting for a human agent
+// still have to wait.
+package queue
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"web-chatbot-backend/internal/events"
+	"web-chatbot-backend/internal/session"
+)
+
+// Estimator learns recent handle times, from an agent claiming a
+// conversation until it leaves the agent's hands, and turns them into wait
+// estimates.
+type Estimator struct {
+	mu      sync.Mutex
+	window  int
+	times   []time.Duration
+	started map[string]time.Time
+	// fallback is used until the first conversation has been handled.
+	fallback time.Duration
+}
+
+// NewEstimator averages over the last window handle times.
+func NewEstimator(window int, fallback time.Duration) *Estimator {
+	if window < 1 {
+		window = 1
+	}
+	return &Estimator{window: window, started: make(map[string]time.Time), fallback: fallback}
+}
+
+// Observe records handle times from session transitions; subscribe it to
+// the event bus.
+func (e *Estimator) Observe(ev events.Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if ev.Type == "session_"+string(session.StatusWithAgent) {
+		e.started[ev.SessionID] = ev.Time
+		return
+	}
+	start, ok := e.started[ev.SessionID]
+	if !ok {
+		return
+	}
+	if ev.Type == "session_deleted" {
+		delete(e.started, ev.SessionID)
+		return
+	}
+	if from, _ := ev.Data["from"].(session.Status); from != session.StatusWithAgent {
+		return
+	}
+	delete(e.started, ev.SessionID)
+	e.times = append(e.times, ev.Time.Sub(start))
+	if len(e.times) > e.window {
+		e.times = e.times[len(e.times)-e.window:]
+	}
+}
+
+// HandleTime is the average recent handle time.
+func (e *Estimator) HandleTime() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.times) == 0 {
+		return e.fallback
+	}
+	var total time.Duration
+	for _, t := range e.times {
+		total += t
+	}
+	return total / time.Duration(len(e.times))
+}
+
+// Wait estimates the wait at the given 1-based queue position when agents
+// are serving conversations in parallel.
+func (e *Estimator) Wait(position, agents int) time.Duration {
+	if agents < 1 {
+		agents = 1
+	}
+	return e.HandleTime() * time.Duration(position) / time.Duration(agents)
+}
+
+// Positions returns the 1-based queue position of every waiting session,
+// first come first served.
+func Positions(waiting []*session.Session) map[string]int {
+	sort.Slice(waiting, func(i, j int) bool {
+		return waiting[i].StatusChangedAt.Before(waiting[j].StatusChangedAt)
+	})
+	out := make(map[string]int, len(waiting))
+	for i, s := range waiting {
+		out[s.ID] = i + 1
+	}
+	return out
+}
@@ -0,0 +1,139 @@
+// Package ratelimit limits how many messages each client may send, per
+// fixed window and per day.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// State is where a client stands against its limits.
+type State struct {
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+	// The daily quota fields are left out when there is no quota.
+	Quota          int        `json:"quota,omitempty"`
+	QuotaRemaining int        `json:"quota_remaining,omitempty"`
+	QuotaReset     *time.Time `json:"quota_reset,omitempty"`
+}
+
+// RetryAfter is how long a client that was refused has to wait.
+func (s State) RetryAfter(now time.Time) time.Duration {
+	if s.Quota > 0 && s.QuotaRemaining == 0 && s.QuotaReset != nil {
+		return s.QuotaReset.Sub(now)
+	}
+	return s.Reset.Sub(now)
+}
+
+type usage struct {
+	windowStart time.Time
+	used        int
+	day         time.Time
+	dayUsed     int
+}
+
+// Limiter allows Limit requests per Window and, if Quota is set, Quota
+// requests per UTC day to each key.
+type Limiter struct {
+	Limit  int
+	Window time.Duration
+	Quota  int
+
+	mu      sync.Mutex
+	clients map[string]*usage
+}
+
+func New(limit int, window time.Duration, quota int) *Limiter {
+	return &Limiter{Limit: limit, Window: window, Quota: quota, clients: make(map[string]*usage)}
+}
+
+// Allow counts a request from key if it is within the limits and reports
+// whether it was allowed, along with the state afterwards.
+func (l *Limiter) Allow(key string) (State, bool) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	u := l.current(key, now)
+	ok := u.used < l.Limit && (l.Quota <= 0 || u.dayUsed < l.Quota)
+	if ok {
+		u.used++
+		u.dayUsed++
+	}
+	return l.state(u), ok
+}
+
+// Peek returns the state of key without counting a request.
+func (l *Limiter) Peek(key string) State {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state(l.current(key, now))
+}
+
+// Prune forgets clients whose window and day are both over.
+func (l *Limiter) Prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	today := startOfDay(now)
+	for key, u := range l.clients {
+		if now.Sub(u.windowStart) >= l.Window && (l.Quota <= 0 || u.day.Before(today)) {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// Run prunes every interval until ctx is cancelled.
+func (l *Limiter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.Prune(now)
+		}
+	}
+}
+
+// current returns the usage of key with expired counters reset.
+func (l *Limiter) current(key string, now time.Time) *usage {
+	u, ok := l.clients[key]
+	if !ok {
+		u = &usage{windowStart: now, day: startOfDay(now)}
+		l.clients[key] = u
+	}
+	if now.Sub(u.windowStart) >= l.Window {
+		u.windowStart = now
+		u.used = 0
+	}
+	if today := startOfDay(now); u.day.Before(today) {
+		u.day = today
+		u.dayUsed = 0
+	}
+	return u
+}
+
+func (l *Limiter) state(u *usage) State {
+	s := State{
+		Limit:     l.Limit,
+		Remaining: max(l.Limit-u.used, 0),
+		Reset:     u.windowStart.Add(l.Window),
+	}
+	if l.Quota > 0 {
+		reset := u.day.AddDate(0, 0, 1)
+		s.Quota = l.Quota
+		s.QuotaRemaining = max(l.Quota-u.dayUsed, 0)
+		s.QuotaReset = &reset
+		// Nothing is left for this window once the day's quota is used up
+		s.Remaining = min(s.Remaining, s.QuotaRemaining)
+	}
+	return s
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,110 @@
+// Package realtime keeps rolling traffic counters and fans live updates out
+// to dashboard subscribers.
+package realtime
+
+import (
+	"sync"
+	"time"
+)
+
+// Rate counts occurrences over a sliding window, in one-second buckets.
+type Rate struct {
+	mu      sync.Mutex
+	counts  []int
+	seconds []int64
+}
+
+// NewRate returns a counter over the last span, which is rounded up to
+// whole seconds.
+func NewRate(span time.Duration) *Rate {
+	n := int((span + time.Second - 1) / time.Second)
+	if n < 1 {
+		n = 1
+	}
+	return &Rate{counts: make([]int, n), seconds: make([]int64, n)}
+}
+
+// Add records n occurrences now.
+func (r *Rate) Add(n int) {
+	now := time.Now().Unix()
+	i := int(now % int64(len(r.counts)))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.seconds[i] != now {
+		r.seconds[i] = now
+		r.counts[i] = 0
+	}
+	r.counts[i] += n
+}
+
+// Count returns the occurrences within the window.
+func (r *Rate) Count() int {
+	now := time.Now().Unix()
+	oldest := now - int64(len(r.counts))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	total := 0
+	for i, sec := range r.seconds {
+		if sec > oldest && sec <= now {
+			total += r.counts[i]
+		}
+	}
+	return total
+}
+
+// Update is one named message on the feed.
+type Update struct {
+	Event string
+	Data  any
+}
+
+// Feed fans updates out to subscribers. Subscribers that fall behind miss
+// updates rather than holding up the publisher.
+type Feed struct {
+	mu   sync.RWMutex
+	subs map[chan Update]struct{}
+}
+
+func NewFeed() *Feed {
+	return &Feed{subs: make(map[chan Update]struct{})}
+}
+
+// Subscribe returns a channel receiving future updates and a function that
+// unsubscribes and closes it.
+func (f *Feed) Subscribe(buffer int) (<-chan Update, func()) {
+	ch := make(chan Update, buffer)
+	f.mu.Lock()
+	f.subs[ch] = struct{}{}
+	f.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			f.mu.Lock()
+			delete(f.subs, ch)
+			f.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish delivers u to every subscriber with room for it.
+func (f *Feed) Publish(u Update) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for ch := range f.subs {
+		select {
+		case ch <- u:
+		default:
+		}
+	}
+}
+
+// Subscribers returns how many subscribers there are.
+func (f *Feed) Subscribers() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.subs)
+}
@@ -0,0 +1,71 @@
+// Package redact masks personal data, such as email addresses, phone
+// numbers and card numbers, in free text.
+package redact
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Placeholders that replace what was masked.
+const (
+	Email = "[email]"
+	Phone = "[phone]"
+	Card  = "[card]"
+)
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	// 13 to 19 digits, optionally grouped with spaces or dashes
+	cardPattern = regexp.MustCompile(`\b\d(?:[ \-]?\d){12,18}\b`)
+	// An optional +, then at least 7 digits with the usual separators
+	phonePattern = regexp.MustCompile(`\+?\(?\d[\d ().\-]{5,}\d`)
+)
+
+// Text returns s with email addresses, card numbers and phone numbers
+// replaced by placeholders. Card numbers are only masked when they pass
+// the Luhn check, so order and tracking numbers stay readable unless they
+// look like phone numbers.
+func Text(s string) string {
+	s = emailPattern.ReplaceAllString(s, Email)
+	s = cardPattern.ReplaceAllStringFunc(s, func(m string) string {
+		if luhn(m) {
+			return Card
+		}
+		return m
+	})
+	return phonePattern.ReplaceAllStringFunc(s, func(m string) string {
+		if digits(m) < 7 {
+			return m
+		}
+		return Phone
+	})
+}
+
+func digits(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			n++
+		}
+	}
+	return n
+}
+
+func luhn(s string) bool {
+	s = strings.NewReplacer(" ", "", "-", "").Replace(s)
+	sum := 0
+	double := false
+	for i := len(s) - 1; i >= 0; i-- {
+		d := int(s[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
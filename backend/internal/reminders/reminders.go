@@ -0,0 +1,263 @@
+// Package reminders keeps the follow-up messages visitors asked for, such
+// as "remind me tomorrow", until they are due and then hands them to a
+// delivery function.
+package reminders
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/filestore"
+)
+
+var (
+	ErrNotFound   = errors.New("reminder not found")
+	ErrNotPending = errors.New("reminder is no longer pending")
+	ErrNoMessage  = errors.New("reminder message is required")
+	ErrNoVisitor  = errors.New("reminder needs a visitor or session")
+	ErrPast       = errors.New("reminder is due in the past")
+)
+
+// Status of a reminder.
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusDelivered Status = "delivered"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+// Reminder is one message to send a visitor later.
+type Reminder struct {
+	ID        string `json:"id"`
+	SessionID string `json:"session_id,omitempty"`
+	VisitorID string `json:"visitor_id,omitempty"`
+	Message   string `json:"message"`
+	// Email is where to send the reminder if the visitor can be reached
+	// no other way.
+	Email     string    `json:"email,omitempty"`
+	DueAt     time.Time `json:"due_at"`
+	CreatedAt time.Time `json:"created_at"`
+	Status    Status    `json:"status"`
+	// Channel is how the reminder reached the visitor, e.g. "chat",
+	// "push" or "email".
+	Channel     string     `json:"channel,omitempty"`
+	Attempts    int        `json:"attempts,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	// NextAttemptAt is when a reminder that could not be delivered yet is
+	// tried again.
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+}
+
+// DeliverFunc sends a due reminder and returns the channel it went out
+// on.
+type DeliverFunc func(ctx context.Context, r Reminder) (channel string, err error)
+
+// Store holds reminders in memory and, when a path is set, saves them to a
+// JSON file after every change so they survive restarts.
+type Store struct {
+	// RetryInterval is how long to wait before trying an undelivered
+	// reminder again, and Expiry how long after it was due to give up.
+	RetryInterval time.Duration
+	Expiry        time.Duration
+
+	mu        sync.Mutex
+	path      string
+	reminders map[string]*Reminder
+}
+
+// NewStore loads reminders from path. An empty path keeps them in memory
+// only.
+func NewStore(path string) (*Store, error) {
+	s := &Store{
+		RetryInterval: 5 * time.Minute,
+		Expiry:        24 * time.Hour,
+		path:          path,
+		reminders:     make(map[string]*Reminder),
+	}
+	if path != "" {
+		if err := filestore.Load(path, &s.reminders); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Schedule stores a new reminder from r's SessionID, VisitorID, Message,
+// Email and DueAt.
+func (s *Store) Schedule(r Reminder) (*Reminder, error) {
+	now := time.Now()
+	switch {
+	case r.Message == "":
+		return nil, ErrNoMessage
+	case r.VisitorID == "" && r.SessionID == "":
+		return nil, ErrNoVisitor
+	case !r.DueAt.After(now):
+		return nil, ErrPast
+	}
+	stored := &Reminder{
+		ID:            uuid.NewString(),
+		SessionID:     r.SessionID,
+		VisitorID:     r.VisitorID,
+		Message:       r.Message,
+		Email:         r.Email,
+		DueAt:         r.DueAt,
+		CreatedAt:     now,
+		Status:        StatusPending,
+		NextAttemptAt: r.DueAt,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reminders[stored.ID] = stored
+	s.save()
+	c := *stored
+	return &c, nil
+}
+
+// Get returns a copy of one reminder.
+func (s *Store) Get(id string) (*Reminder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.reminders[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c := *r
+	return &c, nil
+}
+
+// List returns reminders, soonest first, optionally only those of one
+// visitor or with one status.
+func (s *Store) List(visitorID string, status Status) []*Reminder {
+	s.mu.Lock()
+	out := make([]*Reminder, 0, len(s.reminders))
+	for _, r := range s.reminders {
+		if visitorID != "" && r.VisitorID != visitorID {
+			continue
+		}
+		if status != "" && r.Status != status {
+			continue
+		}
+		c := *r
+		out = append(out, &c)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].DueAt.Equal(out[j].DueAt) {
+			return out[i].DueAt.Before(out[j].DueAt)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// Cancel stops a pending reminder from being sent.
+func (s *Store) Cancel(id string) (*Reminder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.reminders[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if r.Status != StatusPending {
+		return nil, ErrNotPending
+	}
+	r.Status = StatusCancelled
+	s.save()
+	c := *r
+	return &c, nil
+}
+
+// Reassign hands the reminders of one visitor to another.
+func (s *Store) Reassign(fromVisitor, toVisitor string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := false
+	for _, r := range s.reminders {
+		if r.VisitorID == fromVisitor {
+			r.VisitorID = toVisitor
+			changed = true
+		}
+	}
+	if changed {
+		s.save()
+	}
+}
+
+// Run delivers due reminders every interval until ctx is cancelled.
+func (s *Store) Run(ctx context.Context, interval time.Duration, deliver DeliverFunc) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.flush(ctx, deliver)
+		}
+	}
+}
+
+func (s *Store) flush(ctx context.Context, deliver DeliverFunc) {
+	now := time.Now()
+	s.mu.Lock()
+	var due []Reminder
+	for _, r := range s.reminders {
+		if r.Status == StatusPending && !r.NextAttemptAt.After(now) {
+			due = append(due, *r)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, r := range due {
+		channel, err := deliver(ctx, r)
+		s.Record(r.ID, channel, err, time.Now())
+	}
+}
+
+// Record stores the outcome of one delivery attempt. Reminders this store
+// does not know are ignored.
+func (s *Store) Record(id, channel string, err error, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.reminders[id]
+	if !ok || r.Status != StatusPending {
+		// Cancelled while it was being sent
+		return
+	}
+	r.Attempts++
+	switch {
+	case err == nil:
+		r.Status = StatusDelivered
+		r.Channel = channel
+		r.LastError = ""
+		r.DeliveredAt = &now
+	case now.Sub(r.DueAt) >= s.Expiry:
+		r.Status = StatusFailed
+		r.LastError = err.Error()
+	default:
+		r.LastError = err.Error()
+		r.NextAttemptAt = now.Add(s.RetryInterval)
+	}
+	s.save()
+}
+
+// save writes the reminders to disk. Callers hold s.mu.
+func (s *Store) save() {
+	if s.path == "" {
+		return
+	}
+	if err := filestore.Save(s.path, s.reminders); err != nil {
+		log.Error().Err(err).Msg("Error saving reminders")
+	}
+}
@@ -0,0 +1,189 @@
+// Package replyparser pulls the reply text out of a bot response. The
+// response's content type says whether it is plain text or JSON; in JSON
+// the reply is looked for in a configurable list of fields, first match
+// wins.
+package replyparser
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidJSON is returned for a body that is meant to be JSON but
+	// does not parse.
+	ErrInvalidJSON = errors.New("response is not valid JSON")
+	// ErrNoReply is returned for JSON with none of the reply fields, and
+	// no action either, unless the parser falls back to the raw body.
+	ErrNoReply = errors.New("response has none of the reply fields")
+)
+
+// DefaultFields are where the reply is looked for when none are
+// configured: the webhook reply format's "reply", then what n8n's AI
+// Agent and common APIs answer with.
+var DefaultFields = []string{"reply", "output", "message", "text"}
+
+// Fallback is what a parser does with JSON that has none of its fields.
+type Fallback string
+
+const (
+	// FallbackError fails with ErrNoReply.
+	FallbackError Fallback = "error"
+	// FallbackRaw takes the whole body as the reply.
+	FallbackRaw Fallback = "raw"
+)
+
+// Config sets up a Parser.
+type Config struct {
+	// Fields are paths to try in order, dot separated with array indexes
+	// as numbers or in brackets, optionally starting with "$": "output",
+	// "data.reply", "$.choices[0].message.content". Empty means
+	// DefaultFields.
+	Fields   []string
+	Fallback Fallback
+}
+
+// Parser extracts replies. It is safe for concurrent use.
+type Parser struct {
+	fields   []string
+	paths    [][]string
+	fallback Fallback
+}
+
+// Result is one parsed response.
+type Result struct {
+	// Text is the reply. It is empty for a response that only asks for
+	// actions to be run, the reply coming after them.
+	Text string
+	// Empty is set for a response without any content.
+	Empty bool
+	// Field is the path the reply was found at, empty for plain text.
+	Field string
+}
+
+// New returns a parser for cfg.
+func New(cfg Config) (*Parser, error) {
+	fields := cfg.Fields
+	if len(fields) == 0 {
+		fields = DefaultFields
+	}
+	p := &Parser{fields: fields, fallback: cfg.Fallback}
+	switch p.fallback {
+	case "":
+		p.fallback = FallbackError
+	case FallbackError, FallbackRaw:
+	default:
+		return nil, fmt.Errorf("unknown fallback %q, want %q or %q", cfg.Fallback, FallbackError, FallbackRaw)
+	}
+	for _, f := range fields {
+		path, err := parsePath(f)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", f, err)
+		}
+		p.paths = append(p.paths, path)
+	}
+	return p, nil
+}
+
+// Fields returns the paths the parser tries, in order.
+func (p *Parser) Fields() []string {
+	return append([]string(nil), p.fields...)
+}
+
+// parsePath splits a field path into its segments.
+func parsePath(field string) ([]string, error) {
+	field = strings.TrimPrefix(strings.TrimPrefix(field, "$"), ".")
+	field = strings.ReplaceAll(field, "[", ".")
+	field = strings.ReplaceAll(field, "]", "")
+	if field == "" {
+		return nil, errors.New("empty path")
+	}
+	segments := strings.Split(field, ".")
+	for _, s := range segments {
+		if s == "" {
+			return nil, errors.New("empty path segment")
+		}
+	}
+	return segments, nil
+}
+
+// Parse extracts the reply from body, which came with contentType. Text
+// types are the reply as is and JSON types must parse. Without a telling
+// content type, a body that parses as a JSON object or array is taken for
+// JSON and anything else for text.
+func (p *Parser) Parse(contentType string, body []byte) (Result, error) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return Result{Empty: true}, nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	isJSON := mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+	isText := strings.HasPrefix(mediaType, "text/")
+	if !isJSON && (isText || (trimmed[0] != '{' && trimmed[0] != '[')) {
+		return Result{Text: string(body)}, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(trimmed))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		if !isJSON {
+			return Result{Text: string(body)}, nil
+		}
+		return Result{}, ErrInvalidJSON
+	}
+	if s, ok := v.(string); ok {
+		return Result{Text: s}, nil
+	}
+	for i, path := range p.paths {
+		if text, ok := lookup(v, path); ok {
+			return Result{Text: text, Field: p.fields[i]}, nil
+		}
+		// n8n can answer with all the items of the last node
+		if items, ok := v.([]any); ok && len(items) > 0 {
+			if text, ok := lookup(items[0], path); ok {
+				return Result{Text: text, Field: p.fields[i]}, nil
+			}
+		}
+	}
+	if obj, ok := v.(map[string]any); ok && (obj["action"] != nil || obj["actions"] != nil) {
+		return Result{}, nil
+	}
+	if p.fallback == FallbackRaw {
+		return Result{Text: string(body)}, nil
+	}
+	return Result{}, ErrNoReply
+}
+
+// lookup follows path into v and returns the text there, if it is a
+// string, number or boolean.
+func lookup(v any, path []string) (string, bool) {
+	for _, segment := range path {
+		switch node := v.(type) {
+		case map[string]any:
+			v = node[segment]
+		case []any:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", false
+			}
+			v = node[i]
+		default:
+			return "", false
+		}
+	}
+	switch leaf := v.(type) {
+	case string:
+		return leaf, true
+	case json.Number:
+		return leaf.String(), true
+	case bool:
+		return strconv.FormatBool(leaf), true
+	}
+	return "", false
+}
@@ -0,0 +1,173 @@
+package replyparser
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         Config
+		contentType string
+		body        string
+		want        Result
+		err         error
+	}{
+		{
+			name:        "reply before output",
+			contentType: "application/json",
+			body:        `{"text": "c", "output": "b", "reply": "a"}`,
+			want:        Result{Text: "a", Field: "reply"},
+		},
+		{
+			name:        "output before text",
+			contentType: "application/json",
+			body:        `{"text": "c", "output": "b"}`,
+			want:        Result{Text: "b", Field: "output"},
+		},
+		{
+			name:        "text last",
+			contentType: "application/json",
+			body:        `{"text": "c", "other": "d"}`,
+			want:        Result{Text: "c", Field: "text"},
+		},
+		{
+			name:        "fields of the wrong type are skipped",
+			contentType: "application/json",
+			body:        `{"reply": {"nested": true}, "output": "b"}`,
+			want:        Result{Text: "b", Field: "output"},
+		},
+		{
+			name:        "numbers and booleans as text",
+			contentType: "application/json",
+			body:        `{"reply": 12.50}`,
+			want:        Result{Text: "12.50", Field: "reply"},
+		},
+		{
+			name:        "configured fields in their order",
+			cfg:         Config{Fields: []string{"$.choices[0].message.content", "reply"}},
+			contentType: "application/json",
+			body:        `{"reply": "a", "choices": [{"message": {"content": "b"}}]}`,
+			want:        Result{Text: "b", Field: "$.choices[0].message.content"},
+		},
+		{
+			name:        "first item of an n8n array",
+			contentType: "application/json",
+			body:        `[{"output": "a"}, {"output": "b"}]`,
+			want:        Result{Text: "a", Field: "output"},
+		},
+		{
+			name:        "JSON string",
+			contentType: "application/json",
+			body:        `"hello"`,
+			want:        Result{Text: "hello"},
+		},
+		{
+			name:        "plain text",
+			contentType: "text/plain; charset=utf-8",
+			body:        "Hello there",
+			want:        Result{Text: "Hello there"},
+		},
+		{
+			name:        "text type wins over a JSON-looking body",
+			contentType: "text/plain",
+			body:        `{"reply": "a"}`,
+			want:        Result{Text: `{"reply": "a"}`},
+		},
+		{
+			name: "text without a content type",
+			body: "Hello there",
+			want: Result{Text: "Hello there"},
+		},
+		{
+			name: "JSON without a content type",
+			body: `{"reply": "a"}`,
+			want: Result{Text: "a", Field: "reply"},
+		},
+		{
+			name: "broken JSON without a content type is text",
+			body: `{"reply": "a"`,
+			want: Result{Text: `{"reply": "a"`},
+		},
+		{
+			name:        "broken JSON",
+			contentType: "application/json",
+			body:        `{"reply": "a"`,
+			err:         ErrInvalidJSON,
+		},
+		{
+			name:        "trailing data after JSON",
+			contentType: "application/json",
+			body:        `{"reply": "a"} {"reply": "b"}`,
+			err:         ErrInvalidJSON,
+		},
+		{
+			name:        "JSON with a +json type",
+			contentType: "application/vnd.bot+json",
+			body:        `{"reply": "a"`,
+			err:         ErrInvalidJSON,
+		},
+		{
+			name:        "no reply field",
+			contentType: "application/json",
+			body:        `{"other": "a"}`,
+			err:         ErrNoReply,
+		},
+		{
+			name:        "no reply field, raw fallback",
+			cfg:         Config{Fallback: FallbackRaw},
+			contentType: "application/json",
+			body:        `{"other": "a"}`,
+			want:        Result{Text: `{"other": "a"}`},
+		},
+		{
+			name:        "only actions",
+			contentType: "application/json",
+			body:        `{"action": "escalate"}`,
+			want:        Result{},
+		},
+		{
+			name:        "empty body",
+			contentType: "application/json",
+			body:        " \n",
+			want:        Result{Empty: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := p.Parse(tt.contentType, []byte(tt.body))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		ok   bool
+	}{
+		{"defaults", Config{}, true},
+		{"paths", Config{Fields: []string{"data.reply", "$.items[0]"}, Fallback: FallbackRaw}, true},
+		{"unknown fallback", Config{Fallback: "ignore"}, false},
+		{"empty path", Config{Fields: []string{"$"}}, false},
+		{"empty segment", Config{Fields: []string{"data..reply"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.cfg); (err == nil) != tt.ok {
+				t.Errorf("New(%+v) err = %v, want ok = %v", tt.cfg, err, tt.ok)
+			}
+		})
+	}
+}
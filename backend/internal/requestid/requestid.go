@@ -0,0 +1,50 @@
+// Package requestid identifies chat turns across the backend's logs, the
+// frames sent to the visitor and the bot's own logs.
+package requestid
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Header carries the ID on HTTP requests and responses.
+const Header = "X-Request-ID"
+
+// maxLength bounds IDs taken from callers.
+const maxLength = 128
+
+type contextKey struct{}
+
+// New returns a fresh ID.
+func New() string {
+	return uuid.NewString()
+}
+
+// Valid reports whether an ID sent by a caller can be used as is: not
+// empty, not too long, and printable ASCII only so it is safe to log.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// With returns ctx carrying id, and a logger that adds it as request_id
+// to every line logged through log.Ctx(ctx).
+func With(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, contextKey{}, id)
+	return log.With().Str("request_id", id).Logger().WithContext(ctx)
+}
+
+// From returns the ID in ctx, or "" if there is none.
+func From(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
@@ -0,0 +1,106 @@
+// Package rich defines the rich content sent to the widget alongside a
+// text reply, such as product carousels, link buttons and forms.
+package rich
+
+// SchemaVersion is the version of the rich content schema, see schema.json.
+// It is bumped on any change that older widgets cannot render.
+const SchemaVersion = 2
+
+// Element types
+const (
+	TypeCarousel = "carousel"
+	TypeButtons  = "buttons"
+	TypeCard     = "card"
+	TypeList     = "list"
+	TypeForm     = "form"
+	TypeMap      = "map"
+)
+
+// Element is one block of rich content. Which fields are set depends on
+// Type: Cards for a carousel, Card for a card, Items for a list, Form for a
+// form, Map for a map and Buttons for a button row.
+type Element struct {
+	Type    string     `json:"type"`
+	Title   string     `json:"title,omitempty"`
+	Cards   []Card     `json:"cards,omitempty"`
+	Card    *Card      `json:"card,omitempty"`
+	Items   []ListItem `json:"items,omitempty"`
+	Form    *Form      `json:"form,omitempty"`
+	Map     *Map       `json:"map,omitempty"`
+	Buttons []Button   `json:"buttons,omitempty"`
+}
+
+// Card is a single card or one item of a carousel.
+type Card struct {
+	Title    string   `json:"title"`
+	Subtitle string   `json:"subtitle,omitempty"`
+	ImageURL string   `json:"image_url,omitempty"`
+	Price    string   `json:"price,omitempty"`
+	URL      string   `json:"url,omitempty"`
+	Buttons  []Button `json:"buttons,omitempty"`
+}
+
+// ListItem is one row of a list.
+type ListItem struct {
+	Title    string `json:"title"`
+	Subtitle string `json:"subtitle,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+	URL      string `json:"url,omitempty"`
+}
+
+// Form asks the visitor for several values at once.
+type Form struct {
+	ID          string  `json:"id"`
+	Fields      []Field `json:"fields"`
+	SubmitLabel string  `json:"submit_label,omitempty"`
+}
+
+// Field types
+const (
+	FieldText     = "text"
+	FieldEmail    = "email"
+	FieldNumber   = "number"
+	FieldTextarea = "textarea"
+	FieldSelect   = "select"
+)
+
+// Field is one input of a form. Options lists the choices of a select.
+type Field struct {
+	Name        string   `json:"name"`
+	Label       string   `json:"label"`
+	Type        string   `json:"type"`
+	Required    bool     `json:"required,omitempty"`
+	Placeholder string   `json:"placeholder,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// Map shows a place, such as a store or a delivery address. URL links to
+// directions or a maps app and defaults to OpenStreetMap in the widget.
+type Map struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Label     string  `json:"label,omitempty"`
+	Address   string  `json:"address,omitempty"`
+	URL       string  `json:"url,omitempty"`
+}
+
+// Button opens a link.
+type Button struct {
+	Label string `json:"label"`
+	URL   string `json:"url"`
+}
+
+// Carousel returns a carousel element of cards.
+func Carousel(cards ...Card) Element {
+	return Element{Type: TypeCarousel, Cards: cards}
+}
+
+// Buttons returns a row of link buttons.
+func Buttons(buttons ...Button) Element {
+	return Element{Type: TypeButtons, Buttons: buttons}
+}
+
+// MapCard returns a map element showing one place.
+func MapCard(m Map) Element {
+	return Element{Type: TypeMap, Map: &m}
+}
@@ -0,0 +1,8 @@
+package rich
+
+import _ "embed"
+
+// Schema is the JSON Schema of a rich content array at SchemaVersion.
+//
+//go:embed schema.json
+var Schema []byte
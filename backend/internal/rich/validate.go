@@ -0,0 +1,199 @@
+package rich
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"unicode/utf8"
+)
+
+// Limits enforced by Sanitize, mirrored in schema.json.
+const (
+	maxCards   = 10
+	maxButtons = 5
+	maxItems   = 20
+	maxFields  = 10
+	maxOptions = 50
+	maxText    = 500
+)
+
+// Decode parses a JSON array of elements as sent by the bot and sanitizes
+// them. Entries that cannot be decoded or are invalid are dropped and
+// reported in the returned errors; the remaining elements are safe to send
+// to the widget.
+func Decode(data json.RawMessage) ([]Element, []error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, []error{fmt.Errorf("rich content must be an array: %w", err)}
+	}
+	var (
+		out  []Element
+		errs []error
+	)
+	for i, r := range raw {
+		var e Element
+		if err := json.Unmarshal(r, &e); err != nil {
+			errs = append(errs, fmt.Errorf("element %d: %w", i, err))
+			continue
+		}
+		clean, err := Sanitize(e)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("element %d: %w", i, err))
+			continue
+		}
+		out = append(out, clean)
+	}
+	return out, errs
+}
+
+// Sanitize returns e with invalid parts removed: buttons, cards, list
+// items and form fields that are incomplete or link anywhere but http(s)
+// are dropped, lists are cut to their maximum length and over-long text is
+// truncated. It returns an error if nothing valid remains.
+func Sanitize(e Element) (Element, error) {
+	out := Element{Type: e.Type, Title: truncate(e.Title)}
+	switch e.Type {
+	case TypeCarousel:
+		for _, c := range e.Cards {
+			if card, ok := sanitizeCard(c); ok && len(out.Cards) < maxCards {
+				out.Cards = append(out.Cards, card)
+			}
+		}
+		if len(out.Cards) == 0 {
+			return Element{}, fmt.Errorf("carousel has no valid cards")
+		}
+	case TypeCard:
+		if e.Card == nil {
+			return Element{}, fmt.Errorf("card is missing")
+		}
+		card, ok := sanitizeCard(*e.Card)
+		if !ok {
+			return Element{}, fmt.Errorf("card needs a title")
+		}
+		out.Card = &card
+	case TypeButtons:
+		out.Buttons = sanitizeButtons(e.Buttons)
+		if len(out.Buttons) == 0 {
+			return Element{}, fmt.Errorf("button row has no valid buttons")
+		}
+	case TypeList:
+		for _, item := range e.Items {
+			if item.Title == "" || len(out.Items) == maxItems {
+				continue
+			}
+			out.Items = append(out.Items, ListItem{
+				Title:    truncate(item.Title),
+				Subtitle: truncate(item.Subtitle),
+				ImageURL: safeURL(item.ImageURL),
+				URL:      safeURL(item.URL),
+			})
+		}
+		if len(out.Items) == 0 {
+			return Element{}, fmt.Errorf("list has no valid items")
+		}
+		out.Buttons = sanitizeButtons(e.Buttons)
+	case TypeForm:
+		if e.Form == nil || e.Form.ID == "" {
+			return Element{}, fmt.Errorf("form needs an id")
+		}
+		form := &Form{ID: e.Form.ID, SubmitLabel: truncate(e.Form.SubmitLabel)}
+		for _, f := range e.Form.Fields {
+			if field, ok := sanitizeField(f); ok && len(form.Fields) < maxFields {
+				form.Fields = append(form.Fields, field)
+			}
+		}
+		if len(form.Fields) == 0 {
+			return Element{}, fmt.Errorf("form has no valid fields")
+		}
+		out.Form = form
+	case TypeMap:
+		if e.Map == nil {
+			return Element{}, fmt.Errorf("map is missing")
+		}
+		m := *e.Map
+		if m.Latitude < -90 || m.Latitude > 90 || m.Longitude < -180 || m.Longitude > 180 {
+			return Element{}, fmt.Errorf("map coordinates are out of range")
+		}
+		m.Label = truncate(m.Label)
+		m.Address = truncate(m.Address)
+		m.URL = safeURL(m.URL)
+		out.Map = &m
+		out.Buttons = sanitizeButtons(e.Buttons)
+	default:
+		return Element{}, fmt.Errorf("unknown element type %q", e.Type)
+	}
+	return out, nil
+}
+
+func sanitizeCard(c Card) (Card, bool) {
+	if c.Title == "" {
+		return Card{}, false
+	}
+	return Card{
+		Title:    truncate(c.Title),
+		Subtitle: truncate(c.Subtitle),
+		ImageURL: safeURL(c.ImageURL),
+		Price:    truncate(c.Price),
+		URL:      safeURL(c.URL),
+		Buttons:  sanitizeButtons(c.Buttons),
+	}, true
+}
+
+func sanitizeButtons(buttons []Button) []Button {
+	var out []Button
+	for _, b := range buttons {
+		if b.Label == "" || safeURL(b.URL) == "" || len(out) == maxButtons {
+			continue
+		}
+		out = append(out, Button{Label: truncate(b.Label), URL: b.URL})
+	}
+	return out
+}
+
+func sanitizeField(f Field) (Field, bool) {
+	if f.Name == "" {
+		return Field{}, false
+	}
+	if f.Type == "" {
+		f.Type = FieldText
+	}
+	switch f.Type {
+	case FieldText, FieldEmail, FieldNumber, FieldTextarea:
+		f.Options = nil
+	case FieldSelect:
+		if len(f.Options) == 0 {
+			return Field{}, false
+		}
+		if len(f.Options) > maxOptions {
+			f.Options = f.Options[:maxOptions]
+		}
+	default:
+		return Field{}, false
+	}
+	if f.Label == "" {
+		f.Label = f.Name
+	}
+	f.Label = truncate(f.Label)
+	f.Placeholder = truncate(f.Placeholder)
+	return f, true
+}
+
+// safeURL returns u if it is an absolute http(s) URL and "" otherwise, so
+// the widget never renders javascript: or data: links from the bot.
+func safeURL(u string) string {
+	if u == "" {
+		return ""
+	}
+	parsed, err := url.Parse(u)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return ""
+	}
+	return u
+}
+
+func truncate(s string) string {
+	if utf8.RuneCountInString(s) <= maxText {
+		return s
+	}
+	return string([]rune(s)[:maxText])
+}
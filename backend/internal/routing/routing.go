@@ -0,0 +1,286 @@
+// Package routing picks the bot workflow each visitor message goes to, by
+// declarative rules matching keywords, a field of the webhook payload or
+// the intent a classifier finds. Messages no route takes go to the
+// default webhook.
+package routing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/filestore"
+)
+
+// Default names the route of messages no rule takes.
+const Default = "default"
+
+// How a message was routed
+const (
+	ByKeyword = "keyword"
+	ByPattern = "pattern"
+	ByField   = "field"
+	ByIntent  = "intent"
+	ByDefault = "default"
+)
+
+// Route sends the messages it matches to URL. A message matches when it
+// contains any of Keywords (case-insensitive), matches Pattern, has one
+// of Values at Field in the webhook payload, e.g. "user.plan", or is
+// classified as one of Intents.
+type Route struct {
+	Name     string   `json:"name"`
+	URL      string   `json:"url"`
+	Keywords []string `json:"keywords,omitempty"`
+	Pattern  string   `json:"pattern,omitempty"`
+	Field    string   `json:"field,omitempty"`
+	Values   []string `json:"values,omitempty"`
+	Intents  []string `json:"intents,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// Validate checks the route can match something and compiles its pattern.
+func (r *Route) Validate() error {
+	if r.Name == "" || r.Name == Default {
+		return fmt.Errorf("a name other than %q is required", Default)
+	}
+	if u, err := url.Parse(r.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an http(s) URL, got %q", r.URL)
+	}
+	if (r.Field == "") != (len(r.Values) == 0) {
+		return errors.New("field and values go together")
+	}
+	if len(r.Keywords) == 0 && r.Pattern == "" && r.Field == "" && len(r.Intents) == 0 {
+		return errors.New("keywords, pattern, field or intents is required")
+	}
+	if r.Pattern != "" {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+		r.re = re
+	}
+	return nil
+}
+
+// match reports how the route matches a message, if it does without
+// asking the classifier.
+func (r *Route) match(message, lower string, payload map[string]any) string {
+	for _, k := range r.Keywords {
+		if k != "" && strings.Contains(lower, strings.ToLower(k)) {
+			return ByKeyword
+		}
+	}
+	if r.re != nil && r.re.MatchString(message) {
+		return ByPattern
+	}
+	if r.Field != "" {
+		if v, ok := lookup(payload, r.Field); ok && slices.Contains(r.Values, fmt.Sprint(v)) {
+			return ByField
+		}
+	}
+	return ""
+}
+
+// lookup returns the value at a dot-separated path in payload.
+func lookup(payload map[string]any, path string) (any, bool) {
+	var v any = payload
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// Config is the routing file: the routes in evaluation order and, for
+// routes with intents, the classifier.
+type Config struct {
+	// Classifier is posted {"message": ..., "session_id": ...} and
+	// answers {"intent": "..."}.
+	Classifier string `json:"classifier_url,omitempty"`
+	// ClassifierTimeout bounds a classifier call, "3s" if not set.
+	ClassifierTimeout string  `json:"classifier_timeout,omitempty"`
+	Routes            []Route `json:"routes"`
+}
+
+// Decision is where a message goes and why.
+type Decision struct {
+	Route string
+	URL   string
+	By    string
+	// Intent is what the classifier found, if it was asked.
+	Intent string
+}
+
+// Stats counts the messages of one route.
+type Stats struct {
+	Route     string     `json:"route"`
+	Hits      int64      `json:"hits"`
+	Failures  int64      `json:"failures"`
+	AvgMS     int64      `json:"avg_ms"`
+	LastHitAt *time.Time `json:"last_hit_at,omitempty"`
+
+	totalMS int64
+}
+
+// Router holds the routes.
+type Router struct {
+	routes     []Route
+	classifier string
+	client     *http.Client
+
+	mu    sync.Mutex
+	stats map[string]*Stats
+}
+
+// Load reads the routing file at path. It returns nil, and no error, if
+// the file is missing or has no routes.
+func Load(path string, client *http.Client) (*Router, error) {
+	var cfg Config
+	if err := filestore.Load(path, &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Routes) == 0 {
+		return nil, nil
+	}
+	return New(cfg, client)
+}
+
+// New returns a router for cfg, calling the classifier with client.
+func New(cfg Config, client *http.Client) (*Router, error) {
+	r := &Router{classifier: cfg.Classifier, stats: map[string]*Stats{Default: {Route: Default}}}
+	timeout := 3 * time.Second
+	if cfg.ClassifierTimeout != "" {
+		d, err := time.ParseDuration(cfg.ClassifierTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid classifier_timeout: %w", err)
+		}
+		timeout = d
+	}
+	c := *client
+	c.Timeout = timeout
+	r.client = &c
+
+	for _, route := range cfg.Routes {
+		if err := route.Validate(); err != nil {
+			return nil, fmt.Errorf("route %q: %w", route.Name, err)
+		}
+		if _, dup := r.stats[route.Name]; dup {
+			return nil, fmt.Errorf("route %q is defined twice", route.Name)
+		}
+		if len(route.Intents) > 0 && r.classifier == "" {
+			return nil, fmt.Errorf("route %q: intents need classifier_url", route.Name)
+		}
+		r.routes = append(r.routes, route)
+		r.stats[route.Name] = &Stats{Route: route.Name}
+	}
+	return r, nil
+}
+
+// Routes returns the routes in evaluation order.
+func (r *Router) Routes() []Route {
+	return append([]Route(nil), r.routes...)
+}
+
+// Pick returns the first route matching message, whose webhook payload is
+// payload, or the default route with no URL. The classifier is asked at
+// most once, and only when a route with intents is reached; if it fails
+// those routes do not match.
+func (r *Router) Pick(ctx context.Context, message string, payload map[string]any) Decision {
+	lower := strings.ToLower(message)
+	classified := false
+	intent := ""
+	for _, route := range r.routes {
+		if by := route.match(message, lower, payload); by != "" {
+			return Decision{Route: route.Name, URL: route.URL, By: by, Intent: intent}
+		}
+		if len(route.Intents) == 0 {
+			continue
+		}
+		if !classified {
+			classified = true
+			var err error
+			if intent, err = r.classify(ctx, message, payload); err != nil {
+				log.Ctx(ctx).Warn().Err(err).Msg("Error classifying message")
+			}
+		}
+		if intent != "" && slices.Contains(route.Intents, intent) {
+			return Decision{Route: route.Name, URL: route.URL, By: ByIntent, Intent: intent}
+		}
+	}
+	return Decision{Route: Default, By: ByDefault, Intent: intent}
+}
+
+func (r *Router) classify(ctx context.Context, message string, payload map[string]any) (string, error) {
+	body, err := json.Marshal(map[string]any{"message": message, "session_id": payload["session_id"]})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.classifier, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("classifier returned status %d", resp.StatusCode)
+	}
+	var out struct {
+		Intent string `json:"intent"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("invalid classifier response: %w", err)
+	}
+	return out.Intent, nil
+}
+
+// Record counts a message sent on route, and whether the call failed.
+func (r *Router) Record(route string, failed bool, took time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.stats[route]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	s.Hits++
+	if failed {
+		s.Failures++
+	}
+	s.totalMS += took.Milliseconds()
+	s.AvgMS = s.totalMS / s.Hits
+	s.LastHitAt = &now
+}
+
+// Stats returns the counts of every route in evaluation order, the
+// default route last.
+func (r *Router) Stats() []Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]Stats, 0, len(r.routes)+1)
+	for _, route := range r.routes {
+		out = append(out, *r.stats[route.Name])
+	}
+	return append(out, *r.stats[Default])
+}
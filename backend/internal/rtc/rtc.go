@@ -0,0 +1,248 @@
+// Package rtc tracks WebRTC sessions between an agent and a visitor, such
+// as co-browsing and voice calls. The backend only brokers signaling; media flows directly
+// between the browsers once the visitor has consented.
+package rtc
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"web-chatbot-backend/internal/events"
+)
+
+// Call kinds
+const (
+	KindCobrowse = "cobrowse"
+	KindVoice    = "voice"
+)
+
+// State is where a call is in its lifecycle.
+type State string
+
+const (
+	StateRequested State = "requested"
+	StateAccepted  State = "accepted"
+	StateDeclined  State = "declined"
+	StateEnded     State = "ended"
+)
+
+var (
+	ErrNotFound    = errors.New("call not found")
+	ErrUnknownKind = errors.New("unknown call kind")
+	ErrBusy        = errors.New("session already has an open call")
+	ErrState       = errors.New("call is not in a state that allows this")
+)
+
+// Call is one request by an agent to connect to a visitor's browser.
+type Call struct {
+	ID          string     `json:"id"`
+	SessionID   string     `json:"session_id"`
+	Kind        string     `json:"kind"`
+	Agent       string     `json:"agent,omitempty"`
+	State       State      `json:"state"`
+	RequestedAt time.Time  `json:"requested_at"`
+	AnsweredAt  *time.Time `json:"answered_at,omitempty"`
+	EndedAt     *time.Time `json:"ended_at,omitempty"`
+	// EndedBy is "agent", "visitor" or "disconnect".
+	EndedBy string `json:"ended_by,omitempty"`
+}
+
+// Duration is how long the call was connected, or zero if it never was
+// or has not ended yet.
+func (c *Call) Duration() time.Duration {
+	if c.State != StateEnded || c.AnsweredAt == nil || c.EndedAt == nil {
+		return 0
+	}
+	return c.EndedAt.Sub(*c.AnsweredAt)
+}
+
+// Open reports whether the call has not been declined or ended yet.
+func (c *Call) Open() bool {
+	return c.State == StateRequested || c.State == StateAccepted
+}
+
+// Broker keeps the calls of all sessions and publishes their consent and
+// lifecycle events, e.g. cobrowse_requested, cobrowse_consent_granted,
+// cobrowse_consent_declined and cobrowse_ended.
+type Broker struct {
+	mu    sync.Mutex
+	calls map[string]*Call
+	kinds map[string]bool
+	bus   *events.Bus
+}
+
+// NewBroker returns a broker for calls of the given kinds.
+func NewBroker(bus *events.Bus, kinds ...string) *Broker {
+	b := &Broker{calls: make(map[string]*Call), kinds: make(map[string]bool), bus: bus}
+	for _, k := range kinds {
+		b.kinds[k] = true
+	}
+	return b
+}
+
+// Request opens a call on a session. A session has at most one open call.
+func (b *Broker) Request(sessionID, kind, agent string) (*Call, error) {
+	if !b.kinds[kind] {
+		return nil, ErrUnknownKind
+	}
+	b.mu.Lock()
+	for _, c := range b.calls {
+		if c.SessionID == sessionID && c.Open() {
+			b.mu.Unlock()
+			return nil, ErrBusy
+		}
+	}
+	c := &Call{
+		ID:          uuid.NewString(),
+		SessionID:   sessionID,
+		Kind:        kind,
+		Agent:       agent,
+		State:       StateRequested,
+		RequestedAt: time.Now(),
+	}
+	b.calls[c.ID] = c
+	out := *c
+	b.mu.Unlock()
+
+	b.publish(&out, "requested", nil)
+	return &out, nil
+}
+
+// Answer records the visitor's consent decision on a requested call.
+func (b *Broker) Answer(sessionID, callID string, accepted bool) (*Call, error) {
+	b.mu.Lock()
+	c, err := b.get(sessionID, callID)
+	if err != nil {
+		b.mu.Unlock()
+		return nil, err
+	}
+	if c.State != StateRequested {
+		b.mu.Unlock()
+		return nil, ErrState
+	}
+	now := time.Now()
+	c.AnsweredAt = &now
+	c.State = StateDeclined
+	if accepted {
+		c.State = StateAccepted
+	}
+	out := *c
+	b.mu.Unlock()
+
+	suffix := "consent_declined"
+	if accepted {
+		suffix = "consent_granted"
+	}
+	b.publish(&out, suffix, nil)
+	return &out, nil
+}
+
+// End closes an open call. by says who ended it.
+func (b *Broker) End(sessionID, callID, by string) (*Call, error) {
+	b.mu.Lock()
+	c, err := b.get(sessionID, callID)
+	if err != nil {
+		b.mu.Unlock()
+		return nil, err
+	}
+	if !c.Open() {
+		b.mu.Unlock()
+		return nil, ErrState
+	}
+	out := b.end(c, by)
+	b.mu.Unlock()
+
+	b.publishEnded(&out)
+	return &out, nil
+}
+
+// EndAll closes every open call of a session, e.g. when either side
+// disconnects, and returns them.
+func (b *Broker) EndAll(sessionID, by string) []Call {
+	b.mu.Lock()
+	var ended []Call
+	for _, c := range b.calls {
+		if c.SessionID == sessionID && c.Open() {
+			ended = append(ended, b.end(c, by))
+		}
+	}
+	b.mu.Unlock()
+
+	for i := range ended {
+		b.publishEnded(&ended[i])
+	}
+	return ended
+}
+
+// Connected reports whether signaling may be relayed for the call, which
+// is only the case once the visitor has accepted it.
+func (b *Broker) Connected(sessionID, callID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, err := b.get(sessionID, callID)
+	return err == nil && c.State == StateAccepted
+}
+
+// Calls returns the calls of a session, oldest first.
+func (b *Broker) Calls(sessionID string) []Call {
+	b.mu.Lock()
+	var out []Call
+	for _, c := range b.calls {
+		if c.SessionID == sessionID {
+			out = append(out, *c)
+		}
+	}
+	b.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].RequestedAt.Before(out[j].RequestedAt) })
+	return out
+}
+
+// Forget drops the calls of a session.
+func (b *Broker) Forget(sessionID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for id, c := range b.calls {
+		if c.SessionID == sessionID {
+			delete(b.calls, id)
+		}
+	}
+}
+
+func (b *Broker) get(sessionID, callID string) (*Call, error) {
+	c, ok := b.calls[callID]
+	if !ok || c.SessionID != sessionID {
+		return nil, ErrNotFound
+	}
+	return c, nil
+}
+
+func (b *Broker) end(c *Call, by string) Call {
+	now := time.Now()
+	c.State = StateEnded
+	c.EndedAt = &now
+	c.EndedBy = by
+	return *c
+}
+
+func (b *Broker) publishEnded(c *Call) {
+	var extra map[string]any
+	if c.AnsweredAt != nil {
+		extra = map[string]any{"duration_seconds": int(c.Duration().Seconds())}
+	}
+	b.publish(c, "ended", extra)
+}
+
+func (b *Broker) publish(c *Call, suffix string, extra map[string]any) {
+	data := map[string]any{"call_id": c.ID, "kind": c.Kind, "agent": c.Agent}
+	if c.EndedBy != "" {
+		data["ended_by"] = c.EndedBy
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+	b.bus.Publish(events.Event{Type: c.Kind + "_" + suffix, SessionID: c.SessionID, Data: data})
+}
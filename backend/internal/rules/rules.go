@@ -0,0 +1,240 @@
+// Package rules short-circuits visitor messages matching keyword or regex
+// rules with fixed replies, before the bot is ever called.
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"web-chatbot-backend/internal/filestore"
+)
+
+var ErrNotFound = errors.New("rule not found")
+
+// Rule replies with Reply when a message contains any of Keywords
+// (case-insensitive) or matches Pattern. Skills are required of the agent
+// the conversation is routed to; a rule with skills but no reply only
+// labels the conversation and lets the bot answer.
+type Rule struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Keywords  []string   `json:"keywords,omitempty"`
+	Pattern   string     `json:"pattern,omitempty"`
+	Reply     string     `json:"reply"`
+	Skills    []string   `json:"skills,omitempty"`
+	Disabled  bool       `json:"disabled"`
+	Hits      int64      `json:"hits"`
+	LastHitAt *time.Time `json:"last_hit_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+
+	re *regexp.Regexp
+}
+
+// Validate checks the rule can match something and compiles its pattern.
+func (r *Rule) Validate() error {
+	if r.Reply == "" && len(r.Skills) == 0 {
+		return fmt.Errorf("reply or skills is required")
+	}
+	if len(r.Keywords) == 0 && r.Pattern == "" {
+		return fmt.Errorf("keywords or pattern is required")
+	}
+	if r.Pattern != "" {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+		r.re = re
+	}
+	return nil
+}
+
+func (r *Rule) matches(message, lower string) bool {
+	for _, k := range r.Keywords {
+		if k != "" && strings.Contains(lower, strings.ToLower(k)) {
+			return true
+		}
+	}
+	return r.re != nil && r.re.MatchString(message)
+}
+
+// Engine holds the rules in evaluation order.
+type Engine struct {
+	mu    sync.RWMutex
+	path  string
+	rules []*Rule
+}
+
+// NewEngine loads rules from path. An empty path keeps them in memory.
+func NewEngine(path string) (*Engine, error) {
+	e := &Engine{path: path}
+	if path != "" {
+		if err := filestore.Load(path, &e.rules); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range e.rules {
+		if err := r.Validate(); err != nil {
+			return nil, fmt.Errorf("rule %s: %w", r.ID, err)
+		}
+	}
+	return e, nil
+}
+
+// Match returns a copy of the first enabled rule matching message and
+// counts the hit. It returns nil if no rule matches.
+func (e *Engine) Match(message string) *Rule {
+	return e.match(message, true)
+}
+
+// Find is Match without counting the hit, for messages that should not
+// show up in the rule stats.
+func (e *Engine) Find(message string) *Rule {
+	return e.match(message, false)
+}
+
+func (e *Engine) match(message string, count bool) *Rule {
+	lower := strings.ToLower(message)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, r := range e.rules {
+		if r.Disabled || !r.matches(message, lower) {
+			continue
+		}
+		if count {
+			now := time.Now()
+			r.Hits++
+			r.LastHitAt = &now
+			e.save()
+		}
+		c := *r
+		return &c
+	}
+	return nil
+}
+
+// List returns copies of all rules in evaluation order.
+func (e *Engine) List() []Rule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	out := make([]Rule, 0, len(e.rules))
+	for _, r := range e.rules {
+		out = append(out, *r)
+	}
+	return out
+}
+
+// Get returns a copy of the rule with the given ID.
+func (e *Engine) Get(id string) (*Rule, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for _, r := range e.rules {
+		if r.ID == id {
+			c := *r
+			return &c, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Stats returns the rules ordered by hit count, highest first.
+func (e *Engine) Stats() []Rule {
+	out := e.List()
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Hits > out[j].Hits })
+	return out
+}
+
+// Put creates a rule, or replaces the rule with the same ID keeping its
+// hit counters.
+func (e *Engine) Put(r Rule) (*Rule, error) {
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if r.ID == "" {
+		r.ID = uuid.NewString()
+	}
+	for i, existing := range e.rules {
+		if existing.ID == r.ID {
+			r.Hits = existing.Hits
+			r.LastHitAt = existing.LastHitAt
+			r.CreatedAt = existing.CreatedAt
+			e.rules[i] = &r
+			c := r
+			return &c, e.save()
+		}
+	}
+	r.CreatedAt = time.Now()
+	r.Hits = 0
+	r.LastHitAt = nil
+	e.rules = append(e.rules, &r)
+	c := r
+	return &c, e.save()
+}
+
+// Replace swaps in a whole new list of rules at once, keeping the hit
+// counters of rules that stay. Nothing changes if any rule is invalid.
+func (e *Engine) Replace(list []Rule) error {
+	next := make([]*Rule, 0, len(list))
+	for i := range list {
+		r := list[i]
+		if err := r.Validate(); err != nil {
+			return fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+		next = append(next, &r)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	old := make(map[string]*Rule, len(e.rules))
+	for _, r := range e.rules {
+		old[r.ID] = r
+	}
+	now := time.Now()
+	for _, r := range next {
+		if existing, ok := old[r.ID]; ok && r.ID != "" {
+			r.Hits = existing.Hits
+			r.LastHitAt = existing.LastHitAt
+			r.CreatedAt = existing.CreatedAt
+			continue
+		}
+		if r.ID == "" {
+			r.ID = uuid.NewString()
+		}
+		r.Hits = 0
+		r.LastHitAt = nil
+		r.CreatedAt = now
+	}
+	e.rules = next
+	return e.save()
+}
+
+// Delete removes a rule.
+func (e *Engine) Delete(id string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i, r := range e.rules {
+		if r.ID == id {
+			e.rules = append(e.rules[:i], e.rules[i+1:]...)
+			return e.save()
+		}
+	}
+	return ErrNotFound
+}
+
+// save must be called with e.mu held.
+func (e *Engine) save() error {
+	if e.path == "" {
+		return nil
+	}
+	return filestore.Save(e.path, e.rules)
+}
@@ -0,0 +1,100 @@
+package scripting
+
+import (
+	lua "github.com/yuin/gopher-lua"
+
+	"web-chatbot-backend/internal/hooks"
+)
+
+func contextToTable(L *lua.LState, hc *hooks.Context) *lua.LTable {
+	t := L.NewTable()
+	t.RawSetString("point", lua.LString(hc.Point))
+	t.RawSetString("session_id", lua.LString(hc.SessionID))
+	t.RawSetString("visitor_id", lua.LString(hc.VisitorID))
+	t.RawSetString("message", lua.LString(hc.Message))
+	t.RawSetString("reply", lua.LString(hc.Reply))
+	if hc.Payload != nil {
+		t.RawSetString("payload", toLua(L, hc.Payload))
+	}
+	return t
+}
+
+// applyTable copies the editable fields of a returned context table back.
+func applyTable(t *lua.LTable, hc *hooks.Context) {
+	if v, ok := t.RawGetString("message").(lua.LString); ok {
+		hc.Message = string(v)
+	}
+	if v, ok := t.RawGetString("reply").(lua.LString); ok {
+		hc.Reply = string(v)
+	}
+	if v, ok := t.RawGetString("payload").(*lua.LTable); ok && hc.Payload != nil {
+		if m, ok := fromLua(v).(map[string]interface{}); ok {
+			hc.Payload = m
+		}
+	}
+}
+
+func toLua(L *lua.LState, v interface{}) lua.LValue {
+	switch v := v.(type) {
+	case nil:
+		return lua.LNil
+	case string:
+		return lua.LString(v)
+	case bool:
+		return lua.LBool(v)
+	case float64:
+		return lua.LNumber(v)
+	case int:
+		return lua.LNumber(v)
+	case map[string]interface{}:
+		t := L.NewTable()
+		for k, item := range v {
+			t.RawSetString(k, toLua(L, item))
+		}
+		return t
+	case []interface{}:
+		t := L.NewTable()
+		for _, item := range v {
+			t.Append(toLua(L, item))
+		}
+		return t
+	case map[string]string:
+		t := L.NewTable()
+		for k, item := range v {
+			t.RawSetString(k, lua.LString(item))
+		}
+		return t
+	default:
+		return lua.LNil
+	}
+}
+
+// fromLua converts a Lua value to its JSON-compatible Go equivalent. Tables
+// with only consecutive integer keys from 1 become slices.
+func fromLua(v lua.LValue) interface{} {
+	switch v := v.(type) {
+	case lua.LString:
+		return string(v)
+	case lua.LNumber:
+		return float64(v)
+	case lua.LBool:
+		return bool(v)
+	case *lua.LTable:
+		if n := v.Len(); n > 0 {
+			arr := make([]interface{}, 0, n)
+			for i := 1; i <= n; i++ {
+				arr = append(arr, fromLua(v.RawGetInt(i)))
+			}
+			return arr
+		}
+		m := make(map[string]interface{})
+		v.ForEach(func(k, item lua.LValue) {
+			if ks, ok := k.(lua.LString); ok {
+				m[string(ks)] = fromLua(item)
+			}
+		})
+		return m
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,199 @@
+// Package scripting runs sandboxed Lua scripts at the hook points, so small
+// pieces of custom logic can be deployed without rebuilding the binary.
+//
+// A script defines a global function per hook point it handles, e.g.
+//
+//	function on_message_in(ctx)
+//	  ctx.message = string.lower(ctx.message)
+//	  return ctx
+//	end
+//
+// The function receives the hook context as a table and returns it, with
+// any changes. Setting ctx.abort = true (and optionally ctx.abort_reply)
+// stops processing.
+package scripting
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+
+	"web-chatbot-backend/internal/hooks"
+)
+
+// Limits bounds what a single script invocation may use.
+type Limits struct {
+	// Timeout is the wall-clock budget of one invocation.
+	Timeout time.Duration
+	// CallStackSize is the maximum Lua call depth.
+	CallStackSize int
+	// RegistryMaxSize caps the Lua value stack, which bounds the memory a
+	// script can hold live at once.
+	RegistryMaxSize int
+}
+
+// DefaultLimits are used for zero fields in Limits.
+var DefaultLimits = Limits{
+	Timeout:         100 * time.Millisecond,
+	CallStackSize:   64,
+	RegistryMaxSize: 64 * 1024,
+}
+
+// Script is a compiled Lua script.
+type Script struct {
+	Name   string
+	proto  *lua.FunctionProto
+	points []hooks.Point
+	limits Limits
+}
+
+// Compile parses and compiles source and records which hook points it
+// defines functions for.
+func Compile(name, source string, limits Limits) (*Script, error) {
+	chunk, err := parse.Parse(strings.NewReader(source), name)
+	if err != nil {
+		return nil, err
+	}
+	proto, err := lua.Compile(chunk, name)
+	if err != nil {
+		return nil, err
+	}
+	s := &Script{Name: name, proto: proto, limits: withDefaults(limits)}
+
+	// Run the chunk once to find out which hook functions it defines
+	L := s.newState(context.Background())
+	defer L.Close()
+	if err := L.CallByParam(lua.P{Fn: L.NewFunctionFromProto(proto), Protect: true}); err != nil {
+		return nil, err
+	}
+	for _, p := range hooks.Points {
+		if L.GetGlobal(string(p)).Type() == lua.LTFunction {
+			s.points = append(s.points, p)
+		}
+	}
+	return s, nil
+}
+
+// Points lists the hook points the script handles.
+func (s *Script) Points() []hooks.Point {
+	return s.points
+}
+
+// Hook returns the hook that runs the script's function for point p.
+func (s *Script) Hook(p hooks.Point) hooks.Hook {
+	return hooks.Func{
+		HookName: s.Name,
+		Fn: func(ctx context.Context, hc *hooks.Context) error {
+			return s.run(ctx, p, hc)
+		},
+	}
+}
+
+func (s *Script) run(ctx context.Context, p hooks.Point, hc *hooks.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.limits.Timeout)
+	defer cancel()
+
+	L := s.newState(ctx)
+	defer L.Close()
+	if err := L.CallByParam(lua.P{Fn: L.NewFunctionFromProto(s.proto), Protect: true}); err != nil {
+		return fmt.Errorf("script %s: %w", s.Name, err)
+	}
+
+	err := L.CallByParam(lua.P{Fn: L.GetGlobal(string(p)), NRet: 1, Protect: true}, contextToTable(L, hc))
+	if err != nil {
+		return fmt.Errorf("script %s: %w", s.Name, err)
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+
+	t, ok := ret.(*lua.LTable)
+	if !ok {
+		// Scripts that only observe may return nothing
+		return nil
+	}
+	if lua.LVAsBool(t.RawGetString("abort")) {
+		return &hooks.AbortError{
+			Hook:   s.Name,
+			Reply:  lua.LVAsString(t.RawGetString("abort_reply")),
+			Reason: lua.LVAsString(t.RawGetString("reason")),
+		}
+	}
+	applyTable(t, hc)
+	return nil
+}
+
+// newState creates a Lua state with only the safe standard libraries.
+func (s *Script) newState(ctx context.Context) *lua.LState {
+	L := lua.NewState(lua.Options{
+		SkipOpenLibs:    true,
+		CallStackSize:   s.limits.CallStackSize,
+		RegistrySize:    1024,
+		RegistryMaxSize: s.limits.RegistryMaxSize,
+	})
+	for _, lib := range []struct {
+		name string
+		fn   lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.fn))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	// No file system, code loading or GC control
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "require", "module", "collectgarbage", "getfenv", "setfenv"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	L.SetContext(ctx)
+	return L
+}
+
+// LoadDir compiles every *.lua file in dir and registers a hook for each
+// point it handles. A missing directory loads nothing.
+func LoadDir(dir string, limits Limits, runner *hooks.Runner) ([]*Script, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.lua"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	var scripts []*Script
+	for _, path := range paths {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		s, err := Compile(filepath.Base(path), string(source), limits)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range s.Points() {
+			runner.Register(p, s.Hook(p), false)
+		}
+		scripts = append(scripts, s)
+	}
+	return scripts, nil
+}
+
+func withDefaults(l Limits) Limits {
+	if l.Timeout <= 0 {
+		l.Timeout = DefaultLimits.Timeout
+	}
+	if l.CallStackSize <= 0 {
+		l.CallStackSize = DefaultLimits.CallStackSize
+	}
+	if l.RegistryMaxSize <= 0 {
+		l.RegistryMaxSize = DefaultLimits.RegistryMaxSize
+	}
+	return l
+}
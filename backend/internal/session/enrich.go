@@ -0,0 +1,92 @@
+package session
+
+import (
+	"time"
+
+	"web-chatbot-backend/internal/geoip"
+	"web-chatbot-backend/internal/pagecontext"
+	"web-chatbot-backend/internal/useragent"
+)
+
+// SetLocation records where the visitor is connecting from.
+func (m *Manager) SetLocation(id string, loc geoip.Location) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	s.Location = &loc
+	return nil
+}
+
+// SetDevice records the visitor's device and browser.
+func (m *Manager) SetDevice(id string, d useragent.Device) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	s.Device = &d
+	return nil
+}
+
+// Reassign hands every session of one visitor to another, e.g. when two
+// visitor profiles are merged, and returns the IDs of those sessions.
+func (m *Manager) Reassign(fromVisitor, toVisitor string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []string
+	for id, s := range m.sessions {
+		if s.VisitorID == fromVisitor {
+			s.VisitorID = toVisitor
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// SetUser records the claims of the token the visitor signed in with.
+func (m *Manager) SetUser(id string, claims map[string]interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	s.User = copyMemory(claims)
+	return nil
+}
+
+// Verification is an email address the visitor proved they own with a
+// one-time code.
+type Verification struct {
+	Email      string    `json:"email"`
+	VerifiedAt time.Time `json:"verified_at"`
+}
+
+// SetVerified records that the visitor proved they own email.
+func (m *Manager) SetVerified(id, email string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	s.Verified = &Verification{Email: email, VerifiedAt: at}
+	return nil
+}
+
+// SetContext records the page the visitor is writing from. It replaces
+// what was recorded before, so it follows the visitor around the site.
+func (m *Manager) SetContext(id string, c pagecontext.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	s.Context = &c
+	return nil
+}
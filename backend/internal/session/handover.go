@@ -0,0 +1,64 @@
+package session
+
+import "time"
+
+// Handover is a session with everything the manager keeps about it, as
+// handed to the process taking over from this one.
+type Handover struct {
+	Session
+	Messages []Message       `json:"messages,omitempty"`
+	Offers   []HandoverOffer `json:"offers,omitempty"`
+	ReadAt   time.Time       `json:"read_at"`
+}
+
+// HandoverOffer is a quick reply on offer, including what stays on the
+// server.
+type HandoverOffer struct {
+	QuickReply
+	Action string                 `json:"action,omitempty"`
+	Params map[string]interface{} `json:"params,omitempty"`
+}
+
+// Export returns every session for handing over.
+func (m *Manager) Export() []Handover {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]Handover, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		h := Handover{
+			Session:  *s.clone(),
+			Messages: append([]Message(nil), s.messages...),
+			ReadAt:   s.readAt,
+		}
+		for _, o := range s.offers {
+			h.Offers = append(h.Offers, HandoverOffer{QuickReply: o, Action: o.Action, Params: o.Params})
+		}
+		out = append(out, h)
+	}
+	return out
+}
+
+// Import adds sessions handed over by another process, as they were there.
+// Sessions already known are left alone, and no events are published since
+// the other process did for every change. It returns how many were added.
+func (m *Manager) Import(handovers []Handover) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, h := range handovers {
+		if _, ok := m.sessions[h.ID]; ok || h.ID == "" {
+			continue
+		}
+		s := h.Session
+		s.messages = h.Messages
+		s.readAt = h.ReadAt
+		for _, o := range h.Offers {
+			q := o.QuickReply
+			q.Action, q.Params = o.Action, o.Params
+			s.offers = append(s.offers, q)
+		}
+		m.sessions[s.ID] = &s
+		n++
+	}
+	return n
+}
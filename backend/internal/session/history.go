@@ -0,0 +1,203 @@
+package session
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Roles of transcript messages.
+const (
+	RoleVisitor = "visitor"
+	RoleBot     = "bot"
+	RoleAgent   = "agent"
+	RoleSystem  = "system"
+)
+
+// MaxHistory caps the transcript kept per session; older messages are
+// dropped first.
+const MaxHistory = 200
+
+// Message is one entry in a session transcript.
+type Message struct {
+	// ID is unique to the message, see Append.
+	ID string `json:"id,omitempty"`
+	// ClientID is the ID the widget gave a visitor message, so a message
+	// it sends again is recognized.
+	ClientID string    `json:"client_id,omitempty"`
+	Role     string    `json:"role"`
+	Text     string    `json:"text"`
+	Time     time.Time `json:"time"`
+	// Timing is set on bot replies, see SetTiming.
+	Timing *Timing `json:"timing,omitempty"`
+	// Failure is set on visitor messages the bot could not answer, to the
+	// kind of failure, see SetFailure.
+	Failure string `json:"failure,omitempty"`
+	// DeliveredAt and ReadAt are set on messages to the visitor once the
+	// widget reports them, see MarkReceipt.
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	ReadAt      *time.Time `json:"read_at,omitempty"`
+}
+
+// Timing breaks down how long answering a visitor message took, in
+// milliseconds: waiting to be processed, calling the bot, everything else
+// the backend did with the message and the reply, and sending the reply to
+// the visitor.
+type Timing struct {
+	QueueMS      int64 `json:"queue_ms"`
+	UpstreamMS   int64 `json:"upstream_ms"`
+	ProcessingMS int64 `json:"processing_ms"`
+	DeliveryMS   int64 `json:"delivery_ms"`
+	TotalMS      int64 `json:"total_ms"`
+}
+
+// AppendMessage adds a message to the session transcript and returns it.
+func (m *Manager) AppendMessage(id, role, text string) (Message, error) {
+	return m.Append(id, Message{Role: role, Text: text})
+}
+
+// Append adds msg to the session transcript, with a new ID unless it has
+// one, and returns it.
+func (m *Manager) Append(id string, msg Message) (Message, error) {
+	if msg.ID == "" {
+		msg.ID = uuid.NewString()
+	}
+	msg.Time = time.Now()
+	m.mu.Lock()
+	s, ok := m.sessions[id]
+	if !ok {
+		m.mu.Unlock()
+		return Message{}, ErrNotFound
+	}
+	s.messages = append(s.messages, msg)
+	s.MessageCount++
+	if len(s.messages) > MaxHistory {
+		s.messages = append([]Message(nil), s.messages[len(s.messages)-MaxHistory:]...)
+	}
+	snapshot, hook := s.clone(), m.onMessage
+	m.mu.Unlock()
+
+	if hook != nil {
+		hook(snapshot, msg)
+	}
+	return msg, nil
+}
+
+// FindClientMessage returns the visitor message the widget gave clientID,
+// if it is still in the transcript.
+func (m *Manager) FindClientMessage(id, clientID string) (Message, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.sessions[id]
+	if !ok || clientID == "" {
+		return Message{}, false
+	}
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if s.messages[i].ClientID == clientID {
+			return s.messages[i], true
+		}
+	}
+	return Message{}, false
+}
+
+// MessagesAfter returns the messages added after the one with messageID,
+// oldest first. If that message is no longer in the transcript, the whole
+// transcript is returned.
+func (m *Manager) MessagesAfter(id, messageID string) ([]Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	start := 0
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if s.messages[i].ID == messageID {
+			start = i + 1
+			break
+		}
+	}
+	return append([]Message{}, s.messages[start:]...), nil
+}
+
+// SetTiming records how long the latest bot reply took. It is called once
+// the reply was delivered, after it was added to the transcript.
+func (m *Manager) SetTiming(id string, t Timing) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if s.messages[i].Role == RoleBot {
+			if s.messages[i].Timing == nil {
+				s.messages[i].Timing = &t
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// SetFailure records on the latest visitor message of a session why the
+// bot could not answer it.
+func (m *Manager) SetFailure(id, class string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if s.messages[i].Role == RoleVisitor {
+			s.messages[i].Failure = class
+			return nil
+		}
+	}
+	return nil
+}
+
+// OnMessage registers fn to be called with every message added to a
+// transcript, after it was added. Only one function can be registered; it
+// must not block.
+func (m *Manager) OnMessage(fn func(s *Session, msg Message)) {
+	m.mu.Lock()
+	m.onMessage = fn
+	m.mu.Unlock()
+}
+
+// SetChannel records how the visitor is connected to the session.
+func (m *Manager) SetChannel(id, channel string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	s.Channel = channel
+	return nil
+}
+
+// SetTenant records the website the session was started on.
+func (m *Manager) SetTenant(id, tenant string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	s.Tenant = tenant
+	return nil
+}
+
+// History returns a copy of the session transcript, oldest first.
+func (m *Manager) History(id string) ([]Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]Message(nil), s.messages...), nil
+}
@@ -0,0 +1,70 @@
+package session
+
+import (
+	"context"
+	"time"
+
+	"web-chatbot-backend/internal/events"
+)
+
+// IdlePolicy controls how quiet sessions are warned, closed and archived.
+// A zero duration disables the corresponding step.
+type IdlePolicy struct {
+	// WarnAfter is the idle time after which a session_idle_warning event
+	// is published so the visitor can be asked if they are still there.
+	WarnAfter time.Duration
+	// CloseAfter is the idle time after which the session is closed.
+	CloseAfter time.Duration
+	// Grace is how long a closed session can still be reopened before it
+	// is archived.
+	Grace time.Duration
+}
+
+// Sweep applies the idle policy once to every session.
+func (m *Manager) Sweep(p IdlePolicy) {
+	now := time.Now()
+	var warn, closeIDs, archive []string
+
+	m.mu.Lock()
+	for id, s := range m.sessions {
+		switch s.Status {
+		case StatusNew, StatusActive:
+			idle := now.Sub(s.LastActivityAt)
+			if p.CloseAfter > 0 && idle >= p.CloseAfter {
+				closeIDs = append(closeIDs, id)
+			} else if p.WarnAfter > 0 && idle >= p.WarnAfter && !s.warned {
+				s.warned = true
+				warn = append(warn, id)
+			}
+		case StatusClosed:
+			if p.Grace > 0 && s.ClosedAt != nil && now.Sub(*s.ClosedAt) >= p.Grace {
+				archive = append(archive, id)
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	for _, id := range warn {
+		m.bus.Publish(events.Event{Type: "session_idle_warning", SessionID: id})
+	}
+	for _, id := range closeIDs {
+		m.Close(id, "idle")
+	}
+	for _, id := range archive {
+		m.transition(id, StatusClosed, StatusArchived)
+	}
+}
+
+// RunIdleReaper sweeps sessions every interval until ctx is cancelled.
+func (m *Manager) RunIdleReaper(ctx context.Context, p IdlePolicy, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Sweep(p)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package session
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrInvalidPosition is returned for coordinates outside the valid range.
+var ErrInvalidPosition = errors.New("latitude must be within ±90 and longitude within ±180")
+
+// SharedLocation is a position the visitor chose to share from their
+// device, unlike Location which is derived from their IP address.
+type SharedLocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	// Accuracy is the radius of uncertainty in meters, if known.
+	Accuracy float64   `json:"accuracy,omitempty"`
+	SharedAt time.Time `json:"shared_at"`
+}
+
+// ShareLocation records a position shared by the visitor, replacing any
+// earlier one.
+func (m *Manager) ShareLocation(id string, loc SharedLocation) error {
+	if loc.Latitude < -90 || loc.Latitude > 90 || loc.Longitude < -180 || loc.Longitude > 180 {
+		return ErrInvalidPosition
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if loc.SharedAt.IsZero() {
+		loc.SharedAt = time.Now()
+	}
+	s.SharedLocation = &loc
+	return nil
+}
@@ -0,0 +1,63 @@
+package session
+
+import (
+	"errors"
+	"time"
+)
+
+// MaxMemoryKeys caps how many variables a session may hold.
+const MaxMemoryKeys = 50
+
+var ErrMemoryFull = errors.New("session memory is full")
+
+// SetMemory merges vars into the session's memory. A nil value deletes the
+// key. Keys beyond MaxMemoryKeys are rejected with ErrMemoryFull, but the
+// keys that fit are still stored.
+func (m *Manager) SetMemory(id string, vars map[string]interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if s.Memory == nil {
+		s.Memory = make(map[string]interface{})
+	}
+
+	var err error
+	for k, v := range vars {
+		if v == nil {
+			delete(s.Memory, k)
+			continue
+		}
+		if _, exists := s.Memory[k]; !exists && len(s.Memory) >= MaxMemoryKeys {
+			err = ErrMemoryFull
+			continue
+		}
+		s.Memory[k] = v
+	}
+	s.UpdatedAt = time.Now()
+	return err
+}
+
+// Memory returns a copy of the session's variables.
+func (m *Manager) Memory(id string) (map[string]interface{}, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyMemory(s.Memory), nil
+}
+
+func copyMemory(mem map[string]interface{}) map[string]interface{} {
+	if mem == nil {
+		return nil
+	}
+	c := make(map[string]interface{}, len(mem))
+	for k, v := range mem {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,45 @@
+package session
+
+import "time"
+
+// Presence is when the visitor's connection to a session opened and, once
+// it has, closed.
+type Presence struct {
+	ConnectedAt    time.Time  `json:"connected_at"`
+	DisconnectedAt *time.Time `json:"disconnected_at,omitempty"`
+}
+
+// Online reports whether the visitor is connected.
+func (p *Presence) Online() bool {
+	return p != nil && p.DisconnectedAt == nil
+}
+
+// MarkConnected records that the visitor connected to the session at at.
+func (m *Manager) MarkConnected(id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	s.Presence = &Presence{ConnectedAt: at}
+	return nil
+}
+
+// MarkDisconnected records that the connection opened at connectedAt
+// closed at at. It does nothing if the visitor has connected again since.
+func (m *Manager) MarkDisconnected(id string, connectedAt, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if s.Presence == nil || !s.Presence.ConnectedAt.Equal(connectedAt) {
+		return nil
+	}
+	p := *s.Presence
+	p.DisconnectedAt = &at
+	s.Presence = &p
+	return nil
+}
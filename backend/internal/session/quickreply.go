@@ -0,0 +1,61 @@
+package session
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+var ErrUnknownQuickReply = errors.New("quick reply is not on offer")
+
+// QuickReply is a suggested answer offered to the visitor. Selecting it
+// sends Value as a message or, if Action is set, runs that action. Action
+// and Params stay on the server so visitors cannot run arbitrary actions.
+type QuickReply struct {
+	ID     string                 `json:"id"`
+	Label  string                 `json:"label"`
+	Value  string                 `json:"value,omitempty"`
+	Action string                 `json:"-"`
+	Params map[string]interface{} `json:"-"`
+}
+
+// OfferQuickReplies replaces the quick replies on offer in a session and
+// returns them with IDs assigned.
+func (m *Manager) OfferQuickReplies(id string, offers []QuickReply) ([]QuickReply, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	out := make([]QuickReply, len(offers))
+	for i, qr := range offers {
+		if qr.ID == "" {
+			qr.ID = uuid.NewString()
+		}
+		if qr.Value == "" {
+			qr.Value = qr.Label
+		}
+		out[i] = qr
+	}
+	s.offers = out
+	return append([]QuickReply(nil), out...), nil
+}
+
+// TakeQuickReply returns the selected quick reply and withdraws all
+// offers, so each set can only be answered once.
+func (m *Manager) TakeQuickReply(id, quickReplyID string) (QuickReply, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return QuickReply{}, ErrNotFound
+	}
+	for _, qr := range s.offers {
+		if qr.ID == quickReplyID {
+			s.offers = nil
+			return qr, nil
+		}
+	}
+	return QuickReply{}, ErrUnknownQuickReply
+}
@@ -0,0 +1,72 @@
+package session
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrMessageNotFound is returned for a receipt of a message that is not in
+// the transcript.
+var ErrMessageNotFound = errors.New("message not found")
+
+// Receipt is what the widget reports about a message sent to the visitor.
+type Receipt string
+
+const (
+	// ReceiptDelivered means the message reached the widget.
+	ReceiptDelivered Receipt = "delivered"
+	// ReceiptRead means the visitor has seen the message and everything
+	// before it.
+	ReceiptRead Receipt = "read"
+)
+
+// MarkReceipt records a receipt for the message messageID at at. A read
+// receipt also covers the messages before it, and implies delivery. It
+// returns the bot, agent and system messages whose state changed. Visitor
+// messages get no receipts, but may be read up to.
+func (m *Manager) MarkReceipt(id, messageID string, r Receipt, at time.Time) ([]Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	index := -1
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if s.messages[i].ID == messageID {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, ErrMessageNotFound
+	}
+
+	first := index
+	if r == ReceiptRead {
+		first = 0
+		if at.After(s.readAt) {
+			s.readAt = at
+		}
+	}
+	var changed []Message
+	for i := first; i <= index; i++ {
+		msg := &s.messages[i]
+		if msg.Role == RoleVisitor {
+			continue
+		}
+		updated := false
+		if msg.DeliveredAt == nil {
+			msg.DeliveredAt = &at
+			updated = true
+		}
+		if r == ReceiptRead && msg.ReadAt == nil {
+			msg.ReadAt = &at
+			updated = true
+		}
+		if updated {
+			changed = append(changed, *msg)
+		}
+	}
+	return changed, nil
+}
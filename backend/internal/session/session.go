@@ -0,0 +1,360 @@
+package session
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"web-chatbot-backend/internal/events"
+	"web-chatbot-backend/internal/geoip"
+	"web-chatbot-backend/internal/pagecontext"
+	"web-chatbot-backend/internal/useragent"
+)
+
+var (
+	ErrNotFound = errors.New("session not found")
+	ErrExpired  = errors.New("session can no longer be reopened")
+)
+
+// Session is a single conversation between a visitor and the bot.
+type Session struct {
+	ID        string    `json:"id"`
+	VisitorID string    `json:"visitor_id,omitempty"`
+	Status    Status    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// StatusChangedAt is when the session entered its current status.
+	StatusChangedAt time.Time `json:"status_changed_at"`
+	// LastActivityAt is the time of the last visitor message.
+	LastActivityAt time.Time  `json:"last_activity_at"`
+	ClosedAt       *time.Time `json:"closed_at,omitempty"`
+	// Memory holds conversation-scoped variables forwarded with every
+	// webhook call.
+	Memory map[string]interface{} `json:"memory,omitempty"`
+	// Location is looked up from the visitor's IP address when geo-IP
+	// enrichment is enabled.
+	Location *geoip.Location `json:"location,omitempty"`
+	// SharedLocation is the position the visitor last shared, see
+	// ShareLocation.
+	SharedLocation *SharedLocation `json:"shared_location,omitempty"`
+	// Device is parsed from the User-Agent of the connection.
+	Device *useragent.Device `json:"device,omitempty"`
+	// Agent is who claimed the session from the agent queue.
+	Agent string `json:"agent,omitempty"`
+	// Tags label the session for filtering, see Tag.
+	Tags []string `json:"tags,omitempty"`
+	// Skills are what an agent needs to take the session, see
+	// RequireSkills.
+	Skills []string `json:"skills,omitempty"`
+	// Summary covers the earlier turns of a long conversation, see
+	// Summarize.
+	Summary *Summary `json:"summary,omitempty"`
+	// Test marks a conversation an operator started from the admin API to
+	// try the bot out; it is left out of analytics.
+	Test bool `json:"test,omitempty"`
+	// Channel is how the visitor is talking to us, e.g. "websocket" or
+	// "http", see SetChannel.
+	Channel string `json:"channel,omitempty"`
+	// Tenant is the website the session was started on, empty for the
+	// default one; see SetTenant.
+	Tenant string `json:"tenant,omitempty"`
+	// User holds the claims of the token the visitor signed in with, when
+	// visitors must sign in; see SetUser.
+	User map[string]interface{} `json:"user,omitempty"`
+	// Verified is the email address the visitor proved they own, see
+	// SetVerified.
+	Verified *Verification `json:"verified,omitempty"`
+	// Context describes the page the visitor last wrote from, see
+	// SetContext.
+	Context *pagecontext.Context `json:"context,omitempty"`
+	// Presence is when the visitor last connected and disconnected, see
+	// MarkConnected.
+	Presence *Presence `json:"presence,omitempty"`
+	// MessageCount is how many messages the transcript has had, including
+	// any dropped to keep it under MaxHistory.
+	MessageCount int `json:"message_count"`
+
+	// messages is the transcript, see AppendMessage.
+	messages []Message
+	// offers are the quick replies the visitor may currently pick.
+	offers []QuickReply
+	// readAt is when the visitor last read the conversation, see MarkRead.
+	readAt time.Time
+
+	// warned is set once the idle warning has been sent and cleared on the
+	// next visitor message.
+	warned bool
+}
+
+// Manager owns all sessions and enforces the status state machine.
+type Manager struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+	bus      *events.Bus
+
+	// onMessage is called with every transcript message, see OnMessage.
+	onMessage func(s *Session, msg Message)
+}
+
+func NewManager(bus *events.Bus) *Manager {
+	return &Manager{
+		sessions: make(map[string]*Session),
+		bus:      bus,
+	}
+}
+
+// Create starts a new session in the "new" status. visitorID may be empty
+// for anonymous visitors.
+func (m *Manager) Create(visitorID string) *Session {
+	return m.create(visitorID, false)
+}
+
+// CreateTest starts a test conversation, see Session.Test. visitorID may
+// name a visitor to simulate.
+func (m *Manager) CreateTest(visitorID string) *Session {
+	return m.create(visitorID, true)
+}
+
+func (m *Manager) create(visitorID string, test bool) *Session {
+	now := time.Now()
+	s := &Session{
+		ID:        uuid.NewString(),
+		VisitorID: visitorID,
+		Status:    StatusNew,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Test:      test,
+
+		StatusChangedAt: now,
+		LastActivityAt:  now,
+	}
+
+	m.mu.Lock()
+	m.sessions[s.ID] = s
+	m.mu.Unlock()
+
+	data := map[string]any{"to": StatusNew}
+	if test {
+		data["test"] = true
+	}
+	m.bus.Publish(events.Event{
+		Type:      "session_" + string(StatusNew),
+		SessionID: s.ID,
+		Data:      data,
+	})
+	return s.clone()
+}
+
+// Get returns a snapshot of the session with the given ID.
+func (m *Manager) Get(id string) (*Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return s.clone(), nil
+}
+
+// List returns snapshots of the sessions for which keep returns true, or
+// of all sessions if keep is nil.
+func (m *Manager) List(keep func(*Session) bool) []*Session {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var out []*Session
+	for _, s := range m.sessions {
+		if keep == nil || keep(s) {
+			out = append(out, s.clone())
+		}
+	}
+	return out
+}
+
+// Delete removes a session and its transcript for good.
+func (m *Manager) Delete(id string) error {
+	m.mu.Lock()
+	if _, ok := m.sessions[id]; !ok {
+		m.mu.Unlock()
+		return ErrNotFound
+	}
+	delete(m.sessions, id)
+	m.mu.Unlock()
+
+	m.bus.Publish(events.Event{Type: "session_deleted", SessionID: id})
+	return nil
+}
+
+// Touch records visitor activity on a session, resetting its idle timer.
+func (m *Manager) Touch(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	s.LastActivityAt = time.Now()
+	s.warned = false
+	return nil
+}
+
+// Close closes a session, recording why in the session_closed event.
+// Closing a session that is already closed or archived is a no-op.
+func (m *Manager) Close(id, reason string) error {
+	m.mu.Lock()
+	s, ok := m.sessions[id]
+	if !ok {
+		m.mu.Unlock()
+		return ErrNotFound
+	}
+	if s.Status == StatusClosed || s.Status == StatusArchived {
+		m.mu.Unlock()
+		return nil
+	}
+	from := s.Status
+	now := time.Now()
+	s.Status = StatusClosed
+	s.UpdatedAt = now
+	s.StatusChangedAt = now
+	s.ClosedAt = &now
+	m.mu.Unlock()
+
+	m.publishTransition(id, from, StatusClosed, map[string]any{"reason": reason})
+	return nil
+}
+
+// Reopen resumes a closed session if it was closed less than grace ago.
+// Sessions that are still open are returned as they are; archived ones
+// cannot be resumed.
+func (m *Manager) Reopen(id string, grace time.Duration) (*Session, error) {
+	m.mu.Lock()
+	s, ok := m.sessions[id]
+	if !ok {
+		m.mu.Unlock()
+		return nil, ErrNotFound
+	}
+	if s.Status == StatusArchived {
+		m.mu.Unlock()
+		return nil, ErrExpired
+	}
+	if s.Status != StatusClosed {
+		c := s.clone()
+		m.mu.Unlock()
+		return c, nil
+	}
+	if s.ClosedAt == nil || time.Since(*s.ClosedAt) > grace {
+		m.mu.Unlock()
+		return nil, ErrExpired
+	}
+	now := time.Now()
+	s.Status = StatusActive
+	s.UpdatedAt = now
+	s.StatusChangedAt = now
+	s.LastActivityAt = now
+	s.ClosedAt = nil
+	s.warned = false
+	c := s.clone()
+	m.mu.Unlock()
+
+	m.publishTransition(id, StatusClosed, StatusActive, map[string]any{"reason": "reopened"})
+	return c, nil
+}
+
+// Transition moves a session to a new status. Transitions not allowed by the
+// state machine are rejected with ErrInvalidTransition. On success a
+// "session_<status>" event is published.
+func (m *Manager) Transition(id string, to Status) error {
+	_, err := m.transition(id, "", to)
+	return err
+}
+
+// Activate moves a new session to active. Sessions that are already active
+// or further along are left untouched.
+func (m *Manager) Activate(id string) error {
+	_, err := m.transition(id, StatusNew, StatusActive)
+	return err
+}
+
+// Claim hands a session in the agent queue to an agent. It is the move to
+// StatusWithAgent that Transition makes, but also records the agent, so
+// later visitor messages can be pushed to their devices.
+func (m *Manager) Claim(id, agent string) error {
+	m.mu.Lock()
+	s, ok := m.sessions[id]
+	if !ok {
+		m.mu.Unlock()
+		return ErrNotFound
+	}
+	from := s.Status
+	if err := checkTransition(from, StatusWithAgent); err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	now := time.Now()
+	s.Status = StatusWithAgent
+	s.UpdatedAt = now
+	s.StatusChangedAt = now
+	s.Agent = agent
+	m.mu.Unlock()
+
+	m.publishTransition(id, from, StatusWithAgent, map[string]any{"agent": agent})
+	return nil
+}
+
+// transition applies a status change. If only is non-empty the change is
+// applied only when the session is currently in that status, and the
+// returned bool reports whether anything changed.
+func (m *Manager) transition(id string, only, to Status) (bool, error) {
+	m.mu.Lock()
+	s, ok := m.sessions[id]
+	if !ok {
+		m.mu.Unlock()
+		return false, ErrNotFound
+	}
+	from := s.Status
+	if only != "" && from != only {
+		m.mu.Unlock()
+		return false, nil
+	}
+	if err := checkTransition(from, to); err != nil {
+		m.mu.Unlock()
+		return false, err
+	}
+	now := time.Now()
+	s.Status = to
+	s.UpdatedAt = now
+	s.StatusChangedAt = now
+	if to == StatusClosed {
+		s.ClosedAt = &now
+	} else {
+		s.ClosedAt = nil
+	}
+	m.mu.Unlock()
+
+	m.publishTransition(id, from, to, nil)
+	return true, nil
+}
+
+func (m *Manager) publishTransition(id string, from, to Status, extra map[string]any) {
+	data := map[string]any{"from": from, "to": to}
+	for k, v := range extra {
+		data[k] = v
+	}
+	m.bus.Publish(events.Event{
+		Type:      "session_" + string(to),
+		SessionID: id,
+		Data:      data,
+	})
+}
+
+func (s *Session) clone() *Session {
+	c := *s
+	c.Memory = copyMemory(s.Memory)
+	c.User = copyMemory(s.User)
+	c.Tags = append([]string(nil), s.Tags...)
+	c.Skills = append([]string(nil), s.Skills...)
+	c.messages = nil
+	c.offers = nil
+	return &c
+}
@@ -0,0 +1,65 @@
+package session
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"web-chatbot-backend/internal/events"
+)
+
+func TestReopen(t *testing.T) {
+	m := NewManager(events.NewBus())
+
+	open := m.Create("visitor")
+	if s, err := m.Reopen(open.ID, time.Minute); err != nil || s.Status != open.Status {
+		t.Fatalf("Reopen(open) = %v, %v; want it unchanged", s, err)
+	}
+
+	closed := m.Create("visitor")
+	if err := m.Close(closed.ID, "disconnect"); err != nil {
+		t.Fatal(err)
+	}
+	s, err := m.Reopen(closed.ID, time.Minute)
+	if err != nil || s.Status != StatusActive {
+		t.Fatalf("Reopen(closed) = %v, %v; want it active", s, err)
+	}
+
+	stale := m.Create("visitor")
+	if err := m.Close(stale.ID, "disconnect"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Reopen(stale.ID, 0); !errors.Is(err, ErrExpired) {
+		t.Fatalf("Reopen past grace: err = %v, want ErrExpired", err)
+	}
+
+	archived := m.Create("visitor")
+	if err := m.Close(archived.ID, "idle"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := m.transition(archived.ID, StatusClosed, StatusArchived); !ok || err != nil {
+		t.Fatalf("archiving: %v, %v", ok, err)
+	}
+	if _, err := m.Reopen(archived.ID, time.Hour); !errors.Is(err, ErrExpired) {
+		t.Fatalf("Reopen(archived): err = %v, want ErrExpired", err)
+	}
+
+	if _, err := m.Reopen("missing", time.Minute); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Reopen(missing): err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestTransitionCannotReopen(t *testing.T) {
+	m := NewManager(events.NewBus())
+	closed := m.Create("visitor")
+	if err := m.Close(closed.ID, "disconnect"); err != nil {
+		t.Fatal(err)
+	}
+	// Only Reopen, within the grace period, brings a closed session back
+	if err := m.Transition(closed.ID, StatusActive); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("Transition(closed -> active): err = %v, want ErrInvalidTransition", err)
+	}
+	if s, err := m.Get(closed.ID); err != nil || s.Status != StatusClosed {
+		t.Fatalf("after the rejected transition: %v, %v", s, err)
+	}
+}
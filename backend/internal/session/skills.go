@@ -0,0 +1,30 @@
+package session
+
+import "sort"
+
+// RequireSkills adds to the skills an agent needs to take the session,
+// e.g. "billing" or a language.
+func (m *Manager) RequireSkills(id string, skills []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	set := make(map[string]bool, len(s.Skills)+len(skills))
+	for _, k := range s.Skills {
+		set[k] = true
+	}
+	for _, k := range skills {
+		if k != "" {
+			set[k] = true
+		}
+	}
+	out := make([]string, 0, len(set))
+	for k := range set {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	s.Skills = out
+	return nil
+}
@@ -0,0 +1,55 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Status is the lifecycle state of a conversation.
+type Status string
+
+const (
+	StatusNew          Status = "new"
+	StatusActive       Status = "active"
+	StatusWaitingAgent Status = "waiting_agent"
+	StatusWithAgent    Status = "with_agent"
+	StatusClosed       Status = "closed"
+	StatusArchived     Status = "archived"
+)
+
+var ErrInvalidTransition = errors.New("invalid session status transition")
+
+// transitions lists the statuses reachable from each status. Closed sessions
+// go back to active only through Manager.Reopen, which enforces the reopen
+// grace period, so the move is not listed here.
+var transitions = map[Status][]Status{
+	StatusNew:          {StatusActive, StatusClosed},
+	StatusActive:       {StatusWaitingAgent, StatusClosed},
+	StatusWaitingAgent: {StatusWithAgent, StatusActive, StatusClosed},
+	StatusWithAgent:    {StatusActive, StatusClosed},
+	StatusClosed:       {StatusArchived},
+	StatusArchived:     {},
+}
+
+// CanTransition reports whether a session may move from one status to another.
+func CanTransition(from, to Status) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+func checkTransition(from, to Status) error {
+	if !CanTransition(from, to) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, from, to)
+	}
+	return nil
+}
+
+// Valid reports whether s is a known status.
+func (s Status) Valid() bool {
+	_, ok := transitions[s]
+	return ok
+}
@@ -0,0 +1,50 @@
+package session
+
+import "time"
+
+// Summary stands in for the earlier turns of a long conversation in what
+// is forwarded to the bot.
+type Summary struct {
+	Text string `json:"text"`
+	// Through is the time of the last message the summary covers.
+	Through time.Time `json:"through"`
+	// Turns is how many turns the summary covers in total.
+	Turns     int       `json:"turns"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Summarize replaces the session summary with text covering turns more
+// turns, up to and including the message at through.
+func (m *Manager) Summarize(id, text string, through time.Time, turns int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	sum := &Summary{Text: text, Through: through, Turns: turns, UpdatedAt: time.Now()}
+	if s.Summary != nil {
+		sum.Turns += s.Summary.Turns
+	}
+	s.Summary = sum
+	return nil
+}
+
+// Turns returns the visitor, bot and agent messages the session summary
+// does not cover yet, oldest first.
+func (m *Manager) Turns(id string) ([]Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	var out []Message
+	for _, msg := range s.messages {
+		if msg.Role == RoleSystem || (s.Summary != nil && !msg.Time.After(s.Summary.Through)) {
+			continue
+		}
+		out = append(out, msg)
+	}
+	return out, nil
+}
@@ -0,0 +1,42 @@
+package session
+
+import "sort"
+
+// Tag adds and removes tags on a session.
+func (m *Manager) Tag(id string, add, remove []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	set := make(map[string]bool, len(s.Tags)+len(add))
+	for _, t := range s.Tags {
+		set[t] = true
+	}
+	for _, t := range add {
+		if t != "" {
+			set[t] = true
+		}
+	}
+	for _, t := range remove {
+		delete(set, t)
+	}
+	tags := make([]string, 0, len(set))
+	for t := range set {
+		tags = append(tags, t)
+	}
+	sort.Strings(tags)
+	s.Tags = tags
+	return nil
+}
+
+// HasTag reports whether the session carries tag.
+func (s *Session) HasTag(tag string) bool {
+	for _, t := range s.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
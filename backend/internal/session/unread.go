@@ -0,0 +1,35 @@
+package session
+
+import "time"
+
+// MarkRead records that the visitor has seen every message sent up to at.
+func (m *Manager) MarkRead(id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if at.After(s.readAt) {
+		s.readAt = at
+	}
+	return nil
+}
+
+// Unread counts the bot, agent and system messages the visitor has not
+// seen yet.
+func (m *Manager) Unread(id string) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	n := 0
+	for i := len(s.messages) - 1; i >= 0 && s.messages[i].Time.After(s.readAt); i-- {
+		if s.messages[i].Role != RoleVisitor {
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,81 @@
+// Package settings keeps what operators change at runtime through the
+// admin API, such as the webhook URL and which features are on, so it
+// survives restarts.
+package settings
+
+import (
+	"maps"
+	"sync"
+	"time"
+
+	"web-chatbot-backend/internal/filestore"
+)
+
+// Settings overrides the server's configuration.
+type Settings struct {
+	// WebhookURL replaces the configured webhook when set.
+	WebhookURL string `json:"webhook_url,omitempty"`
+	// Features holds the features switched on or off; the rest keep their
+	// default.
+	Features  map[string]bool `json:"features,omitempty"`
+	UpdatedBy string          `json:"updated_by,omitempty"`
+	UpdatedAt *time.Time      `json:"updated_at,omitempty"`
+}
+
+func (s Settings) clone() Settings {
+	s.Features = maps.Clone(s.Features)
+	return s
+}
+
+// Store holds the settings, saved to a JSON file after every change.
+type Store struct {
+	mu       sync.RWMutex
+	path     string
+	settings Settings
+}
+
+// NewStore loads the settings from path.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path}
+	if err := filestore.Load(path, &s.settings); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get returns a copy of the settings.
+func (s *Store) Get() Settings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.settings.clone()
+}
+
+// Enabled reports whether feature is on, or def if it was never switched.
+func (s *Store) Enabled(feature string, def bool) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	on, ok := s.settings.Features[feature]
+	if !ok {
+		return def
+	}
+	return on
+}
+
+// Update changes the settings with fn and saves them, returning them as
+// they were and are now. Nothing changes if fn or saving fails.
+func (s *Store) Update(by string, fn func(*Settings) error) (before, after Settings, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before = s.settings.clone()
+	after = s.settings.clone()
+	if err := fn(&after); err != nil {
+		return before, before, err
+	}
+	now := time.Now()
+	after.UpdatedBy, after.UpdatedAt = by, &now
+	if err := filestore.Save(s.path, after); err != nil {
+		return before, before, err
+	}
+	s.settings = after
+	return before, after.clone(), nil
+}
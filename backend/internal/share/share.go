@@ -0,0 +1,69 @@
+// Package share signs and checks the tokens of transcript share links. A
+// token names one session and when it expires, and is signed with
+// HMAC-SHA256 so it cannot be changed or made up without the secret.
+package share
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalid = errors.New("invalid share link")
+	ErrExpired = errors.New("share link has expired")
+)
+
+// Signer issues and checks share tokens.
+type Signer struct {
+	secret []byte
+}
+
+func NewSigner(secret []byte) *Signer {
+	return &Signer{secret: secret}
+}
+
+// Token returns a token for sessionID that is valid until expires.
+func (s *Signer) Token(sessionID string, expires time.Time) string {
+	payload := sessionID + "." + strconv.FormatInt(expires.Unix(), 10)
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(payload)) + "." + enc.EncodeToString(s.mac(payload))
+}
+
+// Verify checks token and returns the session it is for and when it
+// expires.
+func (s *Signer) Verify(token string, now time.Time) (string, time.Time, error) {
+	enc := base64.RawURLEncoding
+	p, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", time.Time{}, ErrInvalid
+	}
+	payload, err := enc.DecodeString(p)
+	if err != nil {
+		return "", time.Time{}, ErrInvalid
+	}
+	got, err := enc.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, s.mac(string(payload))) {
+		return "", time.Time{}, ErrInvalid
+	}
+	id, exp, ok := strings.Cut(string(payload), ".")
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if !ok || err != nil || id == "" {
+		return "", time.Time{}, ErrInvalid
+	}
+	expires := time.Unix(unix, 0)
+	if !now.Before(expires) {
+		return "", expires, ErrExpired
+	}
+	return id, expires, nil
+}
+
+func (s *Signer) mac(payload string) []byte {
+	m := hmac.New(sha256.New, s.secret)
+	m.Write([]byte(payload))
+	return m.Sum(nil)
+}
@@ -0,0 +1,138 @@
+// Package shopify searches a Shopify store's catalog through the
+// Storefront API.
+package shopify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Product is a search result.
+type Product struct {
+	Title    string
+	Handle   string
+	URL      string
+	ImageURL string
+	Price    float64
+	Currency string
+}
+
+// Client talks to one store.
+type Client struct {
+	// Domain is the store's myshopify.com domain.
+	Domain     string
+	Token      string
+	APIVersion string
+	Client     *http.Client
+}
+
+// New returns a client for the store, or nil if no domain is set.
+func New(domain, token, apiVersion string) (*Client, error) {
+	if domain == "" {
+		return nil, nil
+	}
+	if token == "" {
+		return nil, fmt.Errorf("shopify needs a storefront access token")
+	}
+	if apiVersion == "" {
+		apiVersion = "2024-07"
+	}
+	return &Client{
+		Domain:     strings.TrimSuffix(strings.TrimPrefix(domain, "https://"), "/"),
+		Token:      token,
+		APIVersion: apiVersion,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+const searchQuery = `query($query: String!, $first: Int!) {
+  products(first: $first, query: $query) {
+    edges { node {
+      title handle onlineStoreUrl
+      featuredImage { url }
+      priceRange { minVariantPrice { amount currencyCode } }
+    } }
+  }
+}`
+
+// Search returns up to limit products matching query.
+func (c *Client) Search(ctx context.Context, query string, limit int) ([]Product, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"query":     searchQuery,
+		"variables": map[string]interface{}{"query": query, "first": limit},
+	})
+	if err != nil {
+		return nil, err
+	}
+	endpoint := fmt.Sprintf("https://%s/api/%s/graphql.json", c.Domain, c.APIVersion)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Shopify-Storefront-Access-Token", c.Token)
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("shopify responded with status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var result struct {
+		Data struct {
+			Products struct {
+				Edges []struct {
+					Node struct {
+						Title          string `json:"title"`
+						Handle         string `json:"handle"`
+						OnlineStoreURL string `json:"onlineStoreUrl"`
+						FeaturedImage  *struct {
+							URL string `json:"url"`
+						} `json:"featuredImage"`
+						PriceRange struct {
+							MinVariantPrice struct {
+								Amount       string `json:"amount"`
+								CurrencyCode string `json:"currencyCode"`
+							} `json:"minVariantPrice"`
+						} `json:"priceRange"`
+					} `json:"node"`
+				} `json:"edges"`
+			} `json:"products"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if len(result.Errors) > 0 {
+		return nil, fmt.Errorf("shopify: %s", result.Errors[0].Message)
+	}
+
+	products := make([]Product, 0, len(result.Data.Products.Edges))
+	for _, e := range result.Data.Products.Edges {
+		n := e.Node
+		p := Product{Title: n.Title, Handle: n.Handle, URL: n.OnlineStoreURL, Currency: n.PriceRange.MinVariantPrice.CurrencyCode}
+		if p.URL == "" {
+			p.URL = fmt.Sprintf("https://%s/products/%s", c.Domain, n.Handle)
+		}
+		if n.FeaturedImage != nil {
+			p.ImageURL = n.FeaturedImage.URL
+		}
+		p.Price, _ = strconv.ParseFloat(n.PriceRange.MinVariantPrice.Amount, 64)
+		products = append(products, p)
+	}
+	return products, nil
+}
@@ -0,0 +1,108 @@
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSignFormat(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"message":"hi"}`)
+	h := http.Header{}
+	Sign(h, "secret", body, now)
+
+	if got := h.Get(TimestampHeader); got != "1700000000" {
+		t.Errorf("timestamp = %q, want 1700000000", got)
+	}
+	nonce := h.Get(NonceHeader)
+	if len(nonce) != 32 {
+		t.Errorf("nonce = %q, want 32 hex characters", nonce)
+	}
+	m := hmac.New(sha256.New, []byte("secret"))
+	m.Write([]byte("1700000000." + nonce + "." + string(body)))
+	if want := "v1=" + hex.EncodeToString(m.Sum(nil)); h.Get(SignatureHeader) != want {
+		t.Errorf("signature = %q, want %q", h.Get(SignatureHeader), want)
+	}
+
+	other := http.Header{}
+	Sign(other, "secret", body, now)
+	if other.Get(NonceHeader) == nonce {
+		t.Error("two signatures share a nonce")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"message":"hi"}`)
+	signed := func(secret string, at time.Time) http.Header {
+		h := http.Header{}
+		Sign(h, secret, body, at)
+		return h
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		body   []byte
+		err    error
+	}{
+		{"valid", signed("secret", now), body, nil},
+		{"within tolerance", signed("secret", now.Add(-4*time.Minute)), body, nil},
+		{"slightly ahead", signed("secret", now.Add(time.Minute)), body, nil},
+		{"unsigned", http.Header{}, body, ErrMissing},
+		{"other secret", signed("other", now), body, ErrInvalid},
+		{"changed body", signed("secret", now), []byte(`{"message":"bye"}`), ErrInvalid},
+		{"too old", signed("secret", now.Add(-6*time.Minute)), body, ErrStale},
+		{"too far ahead", signed("secret", now.Add(6*time.Minute)), body, ErrStale},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewVerifier("secret", 5*time.Minute)
+			if err := v.Verify(tt.header, tt.body, now); !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	for _, sig := range []string{"v2=abcd", "v1=not-hex", "abcd"} {
+		h := http.Header{}
+		Sign(h, "secret", nil, now)
+		h.Set(SignatureHeader, sig)
+		if err := NewVerifier("secret", time.Minute).Verify(h, nil, now); err == nil {
+			t.Errorf("signature %q accepted", sig)
+		}
+	}
+}
+
+func TestVerifyRejectsReplays(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte("{}")
+	v := NewVerifier("secret", 5*time.Minute)
+	h := http.Header{}
+	Sign(h, "secret", body, now)
+
+	if err := v.Verify(h, body, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Verify(h, body, now.Add(time.Second)); !errors.Is(err, ErrReplayed) {
+		t.Fatalf("replay: err = %v, want ErrReplayed", err)
+	}
+	// Once the nonce is forgotten the timestamp is too old anyway
+	if err := v.Verify(h, body, now.Add(6*time.Minute)); !errors.Is(err, ErrStale) {
+		t.Fatalf("late replay: err = %v, want ErrStale", err)
+	}
+
+	other := http.Header{}
+	Sign(other, "secret", body, now)
+	if err := v.Verify(other, body, now.Add(time.Second)); err != nil {
+		t.Fatalf("new nonce: %v", err)
+	}
+}
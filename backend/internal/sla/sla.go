@@ -0,0 +1,236 @@
+// Package sla tracks service level targets for conversations handed to
+// human agents: how soon an agent first answers and how soon the
+// conversation is resolved.
+package sla
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"web-chatbot-backend/internal/events"
+)
+
+// Targets
+const (
+	FirstResponse = "first_response"
+	Resolution    = "resolution"
+)
+
+// Policy sets the targets, counted from when the conversation entered
+// the agent queue. A zero duration disables that target.
+type Policy struct {
+	FirstResponse time.Duration
+	Resolution    time.Duration
+}
+
+// Target is the state of one target of a conversation.
+type Target struct {
+	DueAt    time.Time  `json:"due_at"`
+	MetAt    *time.Time `json:"met_at,omitempty"`
+	Breached bool       `json:"breached"`
+	// RemainingSeconds counts down to DueAt while the target is open and
+	// goes negative once it is overdue.
+	RemainingSeconds int `json:"remaining_seconds"`
+}
+
+// Timer tracks the targets of one escalated conversation.
+type Timer struct {
+	SessionID     string    `json:"session_id"`
+	StartedAt     time.Time `json:"started_at"`
+	FirstResponse *Target   `json:"first_response,omitempty"`
+	Resolution    *Target   `json:"resolution,omitempty"`
+}
+
+// Tracker keeps the timers of all escalated conversations and publishes
+// sla_breached events.
+type Tracker struct {
+	// PolicyFor, if set, returns the policy of a conversation in place of
+	// the tracker's own. Set it before the first Start.
+	PolicyFor func(sessionID string) Policy
+
+	mu     sync.Mutex
+	policy Policy
+	timers map[string]*Timer
+	bus    *events.Bus
+}
+
+func NewTracker(policy Policy, bus *events.Bus) *Tracker {
+	return &Tracker{policy: policy, timers: make(map[string]*Timer), bus: bus}
+}
+
+// Start starts the clock for a conversation entering the queue. A
+// conversation that is escalated again keeps its original timer.
+func (t *Tracker) Start(sessionID string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.timers[sessionID]; ok {
+		return
+	}
+	policy := t.policy
+	if t.PolicyFor != nil {
+		policy = t.PolicyFor(sessionID)
+	}
+	if policy.FirstResponse == 0 && policy.Resolution == 0 {
+		return
+	}
+	timer := &Timer{SessionID: sessionID, StartedAt: at}
+	if policy.FirstResponse > 0 {
+		timer.FirstResponse = &Target{DueAt: at.Add(policy.FirstResponse)}
+	}
+	if policy.Resolution > 0 {
+		timer.Resolution = &Target{DueAt: at.Add(policy.Resolution)}
+	}
+	t.timers[sessionID] = timer
+}
+
+// Responded records an agent's reply, meeting the first response target.
+func (t *Tracker) Responded(sessionID string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if timer, ok := t.timers[sessionID]; ok {
+		meet(timer.FirstResponse, at)
+	}
+}
+
+// Resolved records the end of the conversation, meeting both targets.
+func (t *Tracker) Resolved(sessionID string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if timer, ok := t.timers[sessionID]; ok {
+		meet(timer.FirstResponse, at)
+		meet(timer.Resolution, at)
+	}
+}
+
+// Forget drops a conversation's timer.
+func (t *Tracker) Forget(sessionID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.timers, sessionID)
+}
+
+func meet(target *Target, at time.Time) {
+	if target != nil && target.MetAt == nil {
+		target.MetAt = &at
+	}
+}
+
+// Check publishes an sla_breached event for every target that became
+// overdue since the last check.
+func (t *Tracker) Check(now time.Time) {
+	type breach struct {
+		sessionID, target string
+		dueAt             time.Time
+	}
+	var breaches []breach
+
+	t.mu.Lock()
+	for id, timer := range t.timers {
+		for name, target := range map[string]*Target{FirstResponse: timer.FirstResponse, Resolution: timer.Resolution} {
+			if target == nil || target.Breached {
+				continue
+			}
+			if target.MetAt == nil && now.After(target.DueAt) || target.MetAt != nil && target.MetAt.After(target.DueAt) {
+				target.Breached = true
+				breaches = append(breaches, breach{id, name, target.DueAt})
+			}
+		}
+	}
+	t.mu.Unlock()
+
+	for _, b := range breaches {
+		t.bus.Publish(events.Event{
+			Type:      "sla_breached",
+			SessionID: b.sessionID,
+			Data:      map[string]any{"target": b.target, "due_at": b.dueAt},
+		})
+	}
+}
+
+// Get returns a conversation's timer with its countdowns as of now.
+func (t *Tracker) Get(sessionID string, now time.Time) (Timer, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	timer, ok := t.timers[sessionID]
+	if !ok {
+		return Timer{}, false
+	}
+	return snapshot(timer, now), true
+}
+
+// Open returns the timers with a target still open, most urgent first.
+func (t *Tracker) Open(now time.Time) []Timer {
+	t.mu.Lock()
+	var out []Timer
+	for _, timer := range t.timers {
+		if open(timer.FirstResponse) || open(timer.Resolution) {
+			out = append(out, snapshot(timer, now))
+		}
+	}
+	t.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return nextDue(out[i]).Before(nextDue(out[j])) })
+	return out
+}
+
+func open(target *Target) bool {
+	return target != nil && target.MetAt == nil
+}
+
+// nextDue is the due time of the earliest open target.
+func nextDue(timer Timer) time.Time {
+	if open(timer.FirstResponse) {
+		return timer.FirstResponse.DueAt
+	}
+	return timer.Resolution.DueAt
+}
+
+func snapshot(timer *Timer, now time.Time) Timer {
+	out := *timer
+	out.FirstResponse = countdown(timer.FirstResponse, now)
+	out.Resolution = countdown(timer.Resolution, now)
+	return out
+}
+
+func countdown(target *Target, now time.Time) *Target {
+	if target == nil {
+		return nil
+	}
+	c := *target
+	end := now
+	if c.MetAt != nil {
+		end = *c.MetAt
+	}
+	c.RemainingSeconds = int(c.DueAt.Sub(end).Seconds())
+	return &c
+}
+
+// Observe drives the timers from events; subscribe it to the event bus.
+// Agent replies are published as agent_message events.
+func (t *Tracker) Observe(e events.Event) {
+	switch e.Type {
+	case "session_waiting_agent":
+		t.Start(e.SessionID, e.Time)
+	case "agent_message":
+		t.Responded(e.SessionID, e.Time)
+	case "session_closed":
+		t.Resolved(e.SessionID, e.Time)
+	case "session_archived", "session_deleted":
+		t.Forget(e.SessionID)
+	}
+}
+
+// Run checks for breaches every interval until ctx is cancelled.
+func (t *Tracker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			t.Check(now)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// APIKey is an API key other backends call the chat API with. Only a hash
+// of the key itself is kept.
+type APIKey struct {
+	ID                string     `json:"id"`
+	Name              string     `json:"name"`
+	Scopes            []string   `json:"scopes"`
+	Tenant            string     `json:"tenant,omitempty"`
+	Hint              string     `json:"hint"`
+	Hash              string     `json:"hash"`
+	CreatedAt         time.Time  `json:"created_at"`
+	CreatedBy         string     `json:"created_by,omitempty"`
+	LastUsedAt        *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt         *time.Time `json:"revoked_at,omitempty"`
+	RotatedAt         *time.Time `json:"rotated_at,omitempty"`
+	PreviousHash      string     `json:"previous_hash,omitempty"`
+	PreviousExpiresAt *time.Time `json:"previous_expires_at,omitempty"`
+}
+
+const apiKeyColumns = `id, name, scopes, tenant, hint, hash, created_at, created_by, last_used_at, revoked_at, rotated_at, previous_hash, previous_expires_at`
+
+// PutAPIKey creates an API key, or replaces the one with the same ID.
+func (s *Store) PutAPIKey(ctx context.Context, k APIKey) error {
+	scopes, err := json.Marshal(k.Scopes)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, s.rebind(
+		`INSERT INTO api_keys (`+apiKeyColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET name = excluded.name, scopes = excluded.scopes, tenant = excluded.tenant,
+		hint = excluded.hint, hash = excluded.hash, last_used_at = excluded.last_used_at,
+		revoked_at = excluded.revoked_at, rotated_at = excluded.rotated_at,
+		previous_hash = excluded.previous_hash, previous_expires_at = excluded.previous_expires_at`),
+		k.ID, k.Name, string(scopes), k.Tenant, k.Hint, k.Hash, k.CreatedAt.UTC(), k.CreatedBy,
+		utcOrNil(k.LastUsedAt), utcOrNil(k.RevokedAt), utcOrNil(k.RotatedAt), k.PreviousHash, utcOrNil(k.PreviousExpiresAt))
+	return err
+}
+
+// APIKeys returns every API key, newest first.
+func (s *Store) APIKeys(ctx context.Context) ([]APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		var scopes string
+		var lastUsedAt, revokedAt, rotatedAt, previousExpiresAt sql.NullTime
+		if err := rows.Scan(&k.ID, &k.Name, &scopes, &k.Tenant, &k.Hint, &k.Hash, &k.CreatedAt, &k.CreatedBy,
+			&lastUsedAt, &revokedAt, &rotatedAt, &k.PreviousHash, &previousExpiresAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(scopes), &k.Scopes); err != nil {
+			return nil, err
+		}
+		k.LastUsedAt = timeOrNil(lastUsedAt)
+		k.RevokedAt = timeOrNil(revokedAt)
+		k.RotatedAt = timeOrNil(rotatedAt)
+		k.PreviousExpiresAt = timeOrNil(previousExpiresAt)
+		out = append(out, k)
+	}
+	return out, rows.Err()
+}
+
+func timeOrNil(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
@@ -0,0 +1,216 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Statuses of a job.
+const (
+	JobPending   = "pending"
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// ErrLeaseLost is returned for a job whose lease ran out and was taken by
+// another worker.
+var ErrLeaseLost = errors.New("job lease lost")
+
+// Job is one unit of background work. Kind says which handler runs it and
+// Key, unique per kind, keeps the same work from being queued twice.
+type Job struct {
+	ID        string          `json:"id"`
+	Kind      string          `json:"kind"`
+	Key       string          `json:"key"`
+	Payload   json.RawMessage `json:"payload"`
+	Status    string          `json:"status"`
+	RunAt     time.Time       `json:"run_at"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error,omitempty"`
+	// LeaseOwner is the worker running the job until LeaseUntil.
+	LeaseOwner string     `json:"lease_owner,omitempty"`
+	LeaseUntil *time.Time `json:"lease_until,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+const jobColumns = `id, kind, key, payload, status, run_at, attempts, last_error, lease_owner, lease_until, created_at, updated_at`
+
+// Enqueue adds a job to run at runAt with payload encoded as JSON. It
+// reports false, and queues nothing, if a job of the kind with the key was
+// queued before.
+func (s *Store) Enqueue(ctx context.Context, kind, key string, payload any, runAt time.Time) (bool, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now().UTC()
+	res, err := s.db.ExecContext(ctx, s.rebind(
+		`INSERT INTO jobs (id, kind, key, payload, status, run_at, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (kind, key) DO NOTHING`),
+		uuid.NewString(), kind, key, string(body), JobPending, runAt.UTC(), now, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// Claim leases up to limit due jobs of the given kinds to owner until
+// lease from now. A job whose lease runs out before it is completed is
+// claimed again, by this or another worker, so each job runs at least
+// once.
+func (s *Store) Claim(ctx context.Context, owner string, kinds []string, limit int, lease time.Duration) ([]Job, error) {
+	if len(kinds) == 0 {
+		return nil, nil
+	}
+	now := time.Now().UTC()
+	args := []any{JobPending, now, now}
+	for _, k := range kinds {
+		args = append(args, k)
+	}
+	args = append(args, limit)
+	rows, err := s.db.QueryContext(ctx, s.rebind(
+		`SELECT id FROM jobs WHERE status = ? AND run_at <= ? AND (lease_until IS NULL OR lease_until < ?)
+		AND kind IN (?`+strings.Repeat(", ?", len(kinds)-1)+`) ORDER BY run_at LIMIT ?`), args...)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var claimed []Job
+	for _, id := range ids {
+		// Only one worker's update matches, however many saw the job due
+		res, err := s.db.ExecContext(ctx, s.rebind(
+			`UPDATE jobs SET lease_owner = ?, lease_until = ?, attempts = attempts + 1, updated_at = ?
+			WHERE id = ? AND status = ? AND (lease_until IS NULL OR lease_until < ?)`),
+			owner, now.Add(lease), now, id, JobPending, now)
+		if err != nil {
+			return claimed, err
+		}
+		if n, _ := res.RowsAffected(); n != 1 {
+			continue
+		}
+		j, err := s.Job(ctx, id)
+		if err != nil {
+			return claimed, err
+		}
+		claimed = append(claimed, *j)
+	}
+	return claimed, nil
+}
+
+// Complete marks a job owner holds the lease of as done.
+func (s *Store) Complete(ctx context.Context, id, owner string) error {
+	return s.finish(ctx, id, owner, JobDone, "", nil)
+}
+
+// Retry gives up owner's lease on a job and has it run again at at.
+func (s *Store) Retry(ctx context.Context, id, owner string, at time.Time, reason string) error {
+	return s.finish(ctx, id, owner, JobPending, reason, &at)
+}
+
+// Fail marks a job owner holds the lease of as failed for good.
+func (s *Store) Fail(ctx context.Context, id, owner, reason string) error {
+	return s.finish(ctx, id, owner, JobFailed, reason, nil)
+}
+
+func (s *Store) finish(ctx context.Context, id, owner, status, reason string, runAt *time.Time) error {
+	now := time.Now().UTC()
+	query := `UPDATE jobs SET status = ?, last_error = ?, lease_owner = '', lease_until = NULL, updated_at = ?`
+	args := []any{status, reason, now}
+	if runAt != nil {
+		query += `, run_at = ?`
+		args = append(args, runAt.UTC())
+	}
+	query += ` WHERE id = ? AND lease_owner = ? AND status = ?`
+	args = append(args, id, owner, JobPending)
+	res, err := s.db.ExecContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Cancel stops a pending job of the kind with the key from running. It
+// reports whether there was one.
+func (s *Store) Cancel(ctx context.Context, kind, key string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, s.rebind(
+		`UPDATE jobs SET status = ?, updated_at = ? WHERE kind = ? AND key = ? AND status = ?`),
+		JobCancelled, time.Now().UTC(), kind, key, JobPending)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// Job returns one job.
+func (s *Store) Job(ctx context.Context, id string) (*Job, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT `+jobColumns+` FROM jobs WHERE id = ?`), id)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := scanJobs(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &jobs[0], nil
+}
+
+// Jobs returns up to limit jobs, soonest due first, optionally only those
+// of one kind or status.
+func (s *Store) Jobs(ctx context.Context, kind, status string, limit int) ([]Job, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(
+		`SELECT `+jobColumns+` FROM jobs WHERE (? = '' OR kind = ?) AND (? = '' OR status = ?) ORDER BY run_at LIMIT ?`),
+		kind, kind, status, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanJobs(rows)
+}
+
+func scanJobs(rows *sql.Rows) ([]Job, error) {
+	defer rows.Close()
+	out := []Job{}
+	for rows.Next() {
+		var j Job
+		var payload string
+		var leaseUntil sql.NullTime
+		if err := rows.Scan(&j.ID, &j.Kind, &j.Key, &payload, &j.Status, &j.RunAt, &j.Attempts, &j.LastError,
+			&j.LeaseOwner, &leaseUntil, &j.CreatedAt, &j.UpdatedAt); err != nil {
+			return nil, err
+		}
+		j.Payload = json.RawMessage(payload)
+		if leaseUntil.Valid {
+			j.LeaseUntil = &leaseUntil.Time
+		}
+		out = append(out, j)
+	}
+	return out, rows.Err()
+}
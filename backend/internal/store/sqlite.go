@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNotSQLite is returned for operations only the SQLite store supports.
+var ErrNotSQLite = errors.New("only the SQLite store supports this; use the database's own tools for Postgres")
+
+// ErrCheckpointBusy is returned when readers kept a checkpoint from
+// emptying the write-ahead log. The next checkpoint tries again.
+var ErrCheckpointBusy = errors.New("checkpoint blocked by readers")
+
+// sqliteDSN adds the settings every SQLite connection gets to dsn: the
+// write-ahead log, so readers such as backups never block writers, and a
+// busy timeout, so a connection waits for a lock instead of failing at
+// once.
+func sqliteDSN(dsn string, busyTimeout time.Duration) string {
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return fmt.Sprintf("%s%s_pragma=journal_mode(WAL)&_pragma=busy_timeout(%d)", dsn, sep, busyTimeout.Milliseconds())
+}
+
+// Checkpoint copies the write-ahead log back into the database file and
+// empties it, so the log does not grow without bound. It returns the
+// number of pages copied.
+func (s *Store) Checkpoint(ctx context.Context) (int, error) {
+	if s.driver != SQLite {
+		return 0, ErrNotSQLite
+	}
+	var busy, logPages, copied int
+	if err := s.db.QueryRowContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &logPages, &copied); err != nil {
+		return 0, err
+	}
+	if busy != 0 {
+		return copied, ErrCheckpointBusy
+	}
+	return copied, nil
+}
+
+// Backup writes a consistent snapshot of the database to path while it
+// stays in use. The snapshot is written next to path first and renamed
+// into place once complete, so path never holds a partial copy.
+func (s *Store) Backup(ctx context.Context, path string) error {
+	if s.driver != SQLite {
+		return ErrNotSQLite
+	}
+	tmp := path + ".tmp"
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, `VACUUM INTO ?`, tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("writing snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
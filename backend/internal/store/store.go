@@ -0,0 +1,348 @@
+// Package store persists conversation messages, the queue of background
+// jobs, the registry of tenants and API keys in a SQL database: SQLite for
+// development and single-instance setups, Postgres for production.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// Drivers
+const (
+	SQLite   = "sqlite"
+	Postgres = "postgres"
+)
+
+// Channels messages arrive by.
+const (
+	ChannelWebSocket = "websocket"
+	ChannelHTTP      = "http"
+	ChannelSSE       = "sse"
+	ChannelTest      = "test"
+)
+
+// Message is one stored transcript entry. ID increases with every message
+// stored, so it can be used as a cursor. MessageID is the ID the message
+// has in the session transcript.
+type Message struct {
+	ID          int64      `json:"id"`
+	MessageID   string     `json:"message_id,omitempty"`
+	SessionID   string     `json:"session_id"`
+	VisitorID   string     `json:"visitor_id,omitempty"`
+	Channel     string     `json:"channel"`
+	Role        string     `json:"role"`
+	Text        string     `json:"text"`
+	CreatedAt   time.Time  `json:"created_at"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	ReadAt      *time.Time `json:"read_at,omitempty"`
+}
+
+// Store is a message store on one database.
+type Store struct {
+	db     *sql.DB
+	driver string
+}
+
+var schema = map[string][]string{
+	SQLite: {
+		`CREATE TABLE IF NOT EXISTS messages (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			session_id TEXT NOT NULL,
+			visitor_id TEXT NOT NULL,
+			channel TEXT NOT NULL,
+			role TEXT NOT NULL,
+			text TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS messages_session ON messages (session_id, id)`,
+		`CREATE TABLE IF NOT EXISTS jobs (
+			id TEXT PRIMARY KEY,
+			kind TEXT NOT NULL,
+			key TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL,
+			run_at TIMESTAMP NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			lease_owner TEXT NOT NULL DEFAULT '',
+			lease_until TIMESTAMP,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			UNIQUE (kind, key)
+		)`,
+		`CREATE INDEX IF NOT EXISTS jobs_due ON jobs (status, run_at)`,
+		`CREATE TABLE IF NOT EXISTS tenants (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			webhook_url TEXT NOT NULL DEFAULT '',
+			webhook_secret TEXT NOT NULL DEFAULT '',
+			allowed_origins TEXT NOT NULL DEFAULT '[]',
+			rate_limit INTEGER NOT NULL DEFAULT 0,
+			rate_limit_window TEXT NOT NULL DEFAULT '',
+			daily_quota INTEGER NOT NULL DEFAULT 0,
+			theme TEXT NOT NULL DEFAULT '{}',
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS api_keys (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			scopes TEXT NOT NULL DEFAULT '[]',
+			tenant TEXT NOT NULL DEFAULT '',
+			hint TEXT NOT NULL,
+			hash TEXT NOT NULL UNIQUE,
+			created_at TIMESTAMP NOT NULL,
+			created_by TEXT NOT NULL DEFAULT '',
+			last_used_at TIMESTAMP,
+			revoked_at TIMESTAMP,
+			rotated_at TIMESTAMP,
+			previous_hash TEXT NOT NULL DEFAULT '',
+			previous_expires_at TIMESTAMP
+		)`,
+	},
+	Postgres: {
+		`CREATE TABLE IF NOT EXISTS messages (
+			id BIGSERIAL PRIMARY KEY,
+			session_id TEXT NOT NULL,
+			visitor_id TEXT NOT NULL,
+			channel TEXT NOT NULL,
+			role TEXT NOT NULL,
+			text TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS messages_session ON messages (session_id, id)`,
+		`CREATE TABLE IF NOT EXISTS jobs (
+			id TEXT PRIMARY KEY,
+			kind TEXT NOT NULL,
+			key TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL,
+			run_at TIMESTAMPTZ NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			lease_owner TEXT NOT NULL DEFAULT '',
+			lease_until TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL,
+			UNIQUE (kind, key)
+		)`,
+		`CREATE INDEX IF NOT EXISTS jobs_due ON jobs (status, run_at)`,
+		`CREATE TABLE IF NOT EXISTS tenants (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			webhook_url TEXT NOT NULL DEFAULT '',
+			webhook_secret TEXT NOT NULL DEFAULT '',
+			allowed_origins TEXT NOT NULL DEFAULT '[]',
+			rate_limit INTEGER NOT NULL DEFAULT 0,
+			rate_limit_window TEXT NOT NULL DEFAULT '',
+			daily_quota INTEGER NOT NULL DEFAULT 0,
+			theme TEXT NOT NULL DEFAULT '{}',
+			created_at TIMESTAMPTZ NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS api_keys (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			scopes TEXT NOT NULL DEFAULT '[]',
+			tenant TEXT NOT NULL DEFAULT '',
+			hint TEXT NOT NULL,
+			hash TEXT NOT NULL UNIQUE,
+			created_at TIMESTAMPTZ NOT NULL,
+			created_by TEXT NOT NULL DEFAULT '',
+			last_used_at TIMESTAMPTZ,
+			revoked_at TIMESTAMPTZ,
+			rotated_at TIMESTAMPTZ,
+			previous_hash TEXT NOT NULL DEFAULT '',
+			previous_expires_at TIMESTAMPTZ
+		)`,
+	},
+}
+
+// Options tune the connection to the database.
+type Options struct {
+	// BusyTimeout is how long a SQLite connection waits for a lock held
+	// by another process, e.g. a backup, before giving up.
+	BusyTimeout time.Duration
+}
+
+// column is a column added to a table after it was first created, so
+// databases created before get it too.
+type column struct {
+	table, name string
+	// definition per driver
+	definition map[string]string
+}
+
+var columns = []column{
+	{"messages", "message_id", map[string]string{SQLite: "TEXT NOT NULL DEFAULT ''", Postgres: "TEXT NOT NULL DEFAULT ''"}},
+	{"messages", "delivered_at", map[string]string{SQLite: "TIMESTAMP", Postgres: "TIMESTAMPTZ"}},
+	{"messages", "read_at", map[string]string{SQLite: "TIMESTAMP", Postgres: "TIMESTAMPTZ"}},
+	{"tenants", "geoip_country_only", map[string]string{SQLite: "BOOLEAN", Postgres: "BOOLEAN"}},
+	{"tenants", "sla_first_response", map[string]string{SQLite: "TEXT NOT NULL DEFAULT ''", Postgres: "TEXT NOT NULL DEFAULT ''"}},
+	{"tenants", "sla_resolution", map[string]string{SQLite: "TEXT NOT NULL DEFAULT ''", Postgres: "TEXT NOT NULL DEFAULT ''"}},
+	{"tenants", "greeting", map[string]string{SQLite: "TEXT NOT NULL DEFAULT ''", Postgres: "TEXT NOT NULL DEFAULT ''"}},
+}
+
+// indexes need the columns added after the tables were created.
+var indexes = []string{
+	`CREATE INDEX IF NOT EXISTS messages_message ON messages (session_id, message_id)`,
+}
+
+// Open connects to the database and creates the tables if needed. driver
+// is "sqlite", with a file path as dsn, or "postgres", with a connection
+// URL. SQLite databases are switched to write-ahead logging.
+func Open(ctx context.Context, driver, dsn string, opts Options) (*Store, error) {
+	stmts, ok := schema[driver]
+	if !ok {
+		return nil, fmt.Errorf("unknown store driver %q", driver)
+	}
+	sqlDriver := driver
+	switch driver {
+	case Postgres:
+		sqlDriver = "pgx"
+	case SQLite:
+		dsn = sqliteDSN(dsn, opts.BusyTimeout)
+	}
+	db, err := sql.Open(sqlDriver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == SQLite {
+		// SQLite allows one writer at a time
+		db.SetMaxOpenConns(1)
+	}
+	for _, stmt := range stmts {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("creating tables: %w", err)
+		}
+	}
+	st := &Store{db: db, driver: driver}
+	for _, col := range columns {
+		if err := st.addColumn(ctx, col); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("adding column %s.%s: %w", col.table, col.name, err)
+		}
+	}
+	for _, stmt := range indexes {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("creating indexes: %w", err)
+		}
+	}
+	return st, nil
+}
+
+// addColumn adds col to its table unless it is there already.
+func (s *Store) addColumn(ctx context.Context, col column) error {
+	if s.driver == Postgres {
+		_, err := s.db.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s`, col.table, col.name, col.definition[s.driver]))
+		return err
+	}
+	var n int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, col.table, col.name).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, col.table, col.name, col.definition[s.driver]))
+	return err
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Append stores a message.
+func (s *Store) Append(ctx context.Context, m Message) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(
+		`INSERT INTO messages (message_id, session_id, visitor_id, channel, role, text, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`),
+		m.MessageID, m.SessionID, m.VisitorID, m.Channel, m.Role, m.Text, m.CreatedAt.UTC())
+	return err
+}
+
+// SetReceipts records when a message of a session was delivered to and
+// read by the visitor. Times already recorded are kept, and nil times are
+// left alone.
+func (s *Store) SetReceipts(ctx context.Context, sessionID, messageID string, deliveredAt, readAt *time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(
+		`UPDATE messages SET delivered_at = COALESCE(delivered_at, ?), read_at = COALESCE(read_at, ?)
+		WHERE session_id = ? AND message_id = ?`),
+		utcOrNil(deliveredAt), utcOrNil(readAt), sessionID, messageID)
+	return err
+}
+
+func utcOrNil(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+// Messages returns up to limit messages of a visitor's session with IDs
+// after after, oldest first.
+func (s *Store) Messages(ctx context.Context, sessionID, visitorID string, after int64, limit int) ([]Message, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(
+		`SELECT id, message_id, session_id, visitor_id, channel, role, text, created_at, delivered_at, read_at FROM messages
+		WHERE session_id = ? AND visitor_id = ? AND id > ? ORDER BY id LIMIT ?`),
+		sessionID, visitorID, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Message{}
+	for rows.Next() {
+		var m Message
+		var deliveredAt, readAt sql.NullTime
+		if err := rows.Scan(&m.ID, &m.MessageID, &m.SessionID, &m.VisitorID, &m.Channel, &m.Role, &m.Text, &m.CreatedAt, &deliveredAt, &readAt); err != nil {
+			return nil, err
+		}
+		if deliveredAt.Valid {
+			m.DeliveredAt = &deliveredAt.Time
+		}
+		if readAt.Valid {
+			m.ReadAt = &readAt.Time
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// Reassign moves the messages of one visitor to another and returns how
+// many were moved.
+func (s *Store) Reassign(ctx context.Context, fromVisitor, toVisitor string) (int64, error) {
+	res, err := s.db.ExecContext(ctx, s.rebind(`UPDATE messages SET visitor_id = ? WHERE visitor_id = ?`), toVisitor, fromVisitor)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// rebind turns ? placeholders into Postgres' $1, $2, ...
+func (s *Store) rebind(query string) string {
+	if s.driver != Postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Tenant is one website served by the backend, with its own bot workflow
+// and settings. Empty settings fall back to the server's.
+type Tenant struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// WebhookURL is the workflow the tenant's messages go to, signed with
+	// WebhookSecret if set.
+	WebhookURL     string   `json:"webhook_url,omitempty"`
+	WebhookSecret  string   `json:"webhook_secret,omitempty"`
+	AllowedOrigins []string `json:"allowed_origins,omitempty"`
+	// RateLimit messages per RateLimitWindow, e.g. "1m", and DailyQuota
+	// per visitor.
+	RateLimit       int            `json:"rate_limit,omitempty"`
+	RateLimitWindow string         `json:"rate_limit_window,omitempty"`
+	DailyQuota      int            `json:"daily_quota,omitempty"`
+	Theme           map[string]any `json:"theme,omitempty"`
+	// Greeting is shown to the tenant's visitors no greeting rule matches.
+	Greeting string `json:"greeting,omitempty"`
+	// GeoIPCountryOnly keeps only the country of the tenant's visitors'
+	// locations; nil leaves it to the server.
+	GeoIPCountryOnly *bool `json:"geoip_country_only,omitempty"`
+	// SLAFirstResponse and SLAResolution are the service level targets of
+	// the tenant's escalated conversations, e.g. "2m"; "0s" disables one.
+	SLAFirstResponse string    `json:"sla_first_response,omitempty"`
+	SLAResolution    string    `json:"sla_resolution,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+const tenantColumns = `id, name, webhook_url, webhook_secret, allowed_origins, rate_limit, rate_limit_window, daily_quota, theme, geoip_country_only, sla_first_response, sla_resolution, greeting, created_at, updated_at`
+
+// PutTenant creates a tenant, or replaces the one with the same ID keeping
+// its creation time.
+func (s *Store) PutTenant(ctx context.Context, t Tenant) error {
+	origins, err := json.Marshal(t.AllowedOrigins)
+	if err != nil {
+		return err
+	}
+	theme, err := json.Marshal(t.Theme)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	_, err = s.db.ExecContext(ctx, s.rebind(
+		`INSERT INTO tenants (`+tenantColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET name = excluded.name, webhook_url = excluded.webhook_url,
+		webhook_secret = excluded.webhook_secret, allowed_origins = excluded.allowed_origins,
+		rate_limit = excluded.rate_limit, rate_limit_window = excluded.rate_limit_window,
+		daily_quota = excluded.daily_quota, theme = excluded.theme, geoip_country_only = excluded.geoip_country_only,
+		sla_first_response = excluded.sla_first_response, sla_resolution = excluded.sla_resolution,
+		greeting = excluded.greeting, updated_at = excluded.updated_at`),
+		t.ID, t.Name, t.WebhookURL, t.WebhookSecret, string(origins), t.RateLimit, t.RateLimitWindow,
+		t.DailyQuota, string(theme), boolOrNil(t.GeoIPCountryOnly),
+		t.SLAFirstResponse, t.SLAResolution, t.Greeting, now, now)
+	return err
+}
+
+// DeleteTenant removes a tenant and reports whether there was one.
+func (s *Store) DeleteTenant(ctx context.Context, id string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM tenants WHERE id = ?`), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// Tenant returns one tenant, or sql.ErrNoRows.
+func (s *Store) Tenant(ctx context.Context, id string) (*Tenant, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT `+tenantColumns+` FROM tenants WHERE id = ?`), id)
+	if err != nil {
+		return nil, err
+	}
+	tenants, err := scanTenants(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(tenants) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &tenants[0], nil
+}
+
+// Tenants returns every tenant by ID.
+func (s *Store) Tenants(ctx context.Context) ([]Tenant, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+tenantColumns+` FROM tenants ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	return scanTenants(rows)
+}
+
+func scanTenants(rows *sql.Rows) ([]Tenant, error) {
+	defer rows.Close()
+	out := []Tenant{}
+	for rows.Next() {
+		var t Tenant
+		var origins, theme string
+		var countryOnly sql.NullBool
+		if err := rows.Scan(&t.ID, &t.Name, &t.WebhookURL, &t.WebhookSecret, &origins, &t.RateLimit,
+			&t.RateLimitWindow, &t.DailyQuota, &theme, &countryOnly, &t.SLAFirstResponse, &t.SLAResolution, &t.Greeting, &t.CreatedAt, &t.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if countryOnly.Valid {
+			t.GeoIPCountryOnly = &countryOnly.Bool
+		}
+		if err := json.Unmarshal([]byte(origins), &t.AllowedOrigins); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(theme), &t.Theme); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+func boolOrNil(b *bool) any {
+	if b == nil {
+		return nil
+	}
+	return *b
+}
@@ -0,0 +1,140 @@
+// Package streaming reads replies that the bot streams as it writes them,
+// as server-sent events or newline-delimited JSON, so the visitor can see
+// the answer appear before it is complete.
+package streaming
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+)
+
+// ErrTooLarge is returned when a stream runs past its limit.
+var ErrTooLarge = errors.New("streamed response too large")
+
+// Streamed reports whether a response with this Content-Type is streamed.
+func Streamed(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/event-stream", "application/x-ndjson", "application/jsonl":
+		return true
+	}
+	return false
+}
+
+// Read reads a streamed reply from r, calling onDelta (if not nil) with
+// each piece of text as it arrives. Pieces may come as
+//
+//	{"delta": "..."}
+//	{"type": "item", "content": "..."}          (n8n streaming responses)
+//	{"choices": [{"delta": {"content": "..."}}]} (OpenAI-style chunks)
+//
+// or as plain text. Any other JSON object is taken as the final response,
+// e.g. to carry quick replies. Read returns that final response with the
+// full text as its "reply", ready for the usual reply parsing.
+func Read(r io.Reader, contentType string, limit int, onDelta func(string)) ([]byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	sse := mediaType == "text/event-stream"
+
+	var text strings.Builder
+	var final map[string]any
+	handle := func(data string) bool {
+		if sse && strings.TrimSpace(data) == "[DONE]" {
+			return false
+		}
+		delta, obj := parseEvent(data)
+		if obj != nil {
+			final = obj
+		}
+		if delta != "" {
+			text.WriteString(delta)
+			if onDelta != nil {
+				onDelta(delta)
+			}
+		}
+		return true
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), max(limit, 64*1024))
+	read := 0
+	var event []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		read += len(line) + 1
+		if read > limit {
+			return nil, fmt.Errorf("%w: over %d bytes", ErrTooLarge, limit)
+		}
+		if !sse {
+			if strings.TrimSpace(line) != "" && !handle(line) {
+				break
+			}
+			continue
+		}
+		// Server-sent events end with a blank line; only data lines matter
+		if line == "" {
+			if len(event) > 0 && !handle(strings.Join(event, "\n")) {
+				break
+			}
+			event = event[:0]
+			continue
+		}
+		if data, ok := strings.CutPrefix(line, "data:"); ok {
+			event = append(event, strings.TrimPrefix(data, " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, fmt.Errorf("%w: line over %d bytes", ErrTooLarge, limit)
+		}
+		return nil, err
+	}
+	if len(event) > 0 {
+		handle(strings.Join(event, "\n"))
+	}
+
+	if final == nil {
+		final = make(map[string]any)
+	}
+	if _, ok := final["reply"]; !ok || text.Len() > 0 {
+		final["reply"] = text.String()
+	}
+	return json.Marshal(final)
+}
+
+// parseEvent returns the text an event adds, or the object it carries if
+// it is not a piece of text.
+func parseEvent(data string) (string, map[string]any) {
+	trimmed := bytes.TrimSpace([]byte(data))
+	var obj map[string]any
+	if len(trimmed) == 0 || trimmed[0] != '{' || json.Unmarshal(trimmed, &obj) != nil {
+		return data, nil
+	}
+	if delta, ok := obj["delta"].(string); ok {
+		return delta, nil
+	}
+	if t, _ := obj["type"].(string); t != "" {
+		switch t {
+		case "item":
+			content, _ := obj["content"].(string)
+			return content, nil
+		case "begin", "end", "error":
+			return "", nil
+		}
+	}
+	if choices, ok := obj["choices"].([]any); ok {
+		if len(choices) == 0 {
+			return "", nil
+		}
+		choice, _ := choices[0].(map[string]any)
+		delta, _ := choice["delta"].(map[string]any)
+		content, _ := delta["content"].(string)
+		return content, nil
+	}
+	return "", obj
+}
@@ -0,0 +1,134 @@
+package streaming
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStreamed(t *testing.T) {
+	for contentType, want := range map[string]bool{
+		"text/event-stream":                true,
+		"text/event-stream; charset=utf-8": true,
+		"application/x-ndjson":             true,
+		"application/jsonl":                true,
+		"application/json":                 false,
+		"text/plain":                       false,
+		"":                                 false,
+	} {
+		if got := Streamed(contentType); got != want {
+			t.Errorf("Streamed(%q) = %v, want %v", contentType, got, want)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		deltas      []string
+		want        map[string]any
+	}{
+		{
+			name:        "SSE deltas",
+			contentType: "text/event-stream",
+			body:        "data: {\"delta\": \"Hel\"}\n\ndata: {\"delta\": \"lo\"}\n\n",
+			deltas:      []string{"Hel", "lo"},
+			want:        map[string]any{"reply": "Hello"},
+		},
+		{
+			name:        "SSE ignores other fields and stops at [DONE]",
+			contentType: "text/event-stream",
+			body:        ": keepalive\nevent: message\nid: 1\ndata: {\"delta\": \"Hi\"}\n\ndata: [DONE]\n\ndata: {\"delta\": \"late\"}\n\n",
+			deltas:      []string{"Hi"},
+			want:        map[string]any{"reply": "Hi"},
+		},
+		{
+			name:        "SSE multi-line plain text",
+			contentType: "text/event-stream",
+			body:        "data: line one\ndata: line two\n\n",
+			deltas:      []string{"line one\nline two"},
+			want:        map[string]any{"reply": "line one\nline two"},
+		},
+		{
+			name:        "SSE event without a blank line at the end",
+			contentType: "text/event-stream",
+			body:        "data: {\"delta\": \"Hi\"}",
+			deltas:      []string{"Hi"},
+			want:        map[string]any{"reply": "Hi"},
+		},
+		{
+			name:        "OpenAI chunks",
+			contentType: "text/event-stream",
+			body: "data: {\"choices\": [{\"delta\": {\"role\": \"assistant\"}}]}\n\n" +
+				"data: {\"choices\": [{\"delta\": {\"content\": \"Hi \"}}]}\n\n" +
+				"data: {\"choices\": [{\"delta\": {\"content\": \"there\"}}]}\n\n" +
+				"data: {\"choices\": []}\n\n" +
+				"data: [DONE]\n\n",
+			deltas: []string{"Hi ", "there"},
+			want:   map[string]any{"reply": "Hi there"},
+		},
+		{
+			name:        "n8n items",
+			contentType: "application/x-ndjson",
+			body: "{\"type\": \"begin\"}\n" +
+				"{\"type\": \"item\", \"content\": \"Hel\"}\n" +
+				"\n" +
+				"{\"type\": \"item\", \"content\": \"lo\"}\n" +
+				"{\"type\": \"end\"}\n",
+			deltas: []string{"Hel", "lo"},
+			want:   map[string]any{"reply": "Hello"},
+		},
+		{
+			name:        "final object keeps its fields",
+			contentType: "application/jsonl",
+			body: "{\"delta\": \"Pick one\"}\n" +
+				"{\"quick_replies\": [{\"label\": \"Yes\"}]}\n",
+			deltas: []string{"Pick one"},
+			want:   map[string]any{"reply": "Pick one", "quick_replies": []any{map[string]any{"label": "Yes"}}},
+		},
+		{
+			name:        "final reply without deltas",
+			contentType: "application/x-ndjson",
+			body:        "{\"reply\": \"Done\"}\n",
+			want:        map[string]any{"reply": "Done"},
+		},
+		{
+			name:        "empty stream",
+			contentType: "text/event-stream",
+			body:        "",
+			want:        map[string]any{"reply": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deltas []string
+			body, err := Read(strings.NewReader(tt.body), tt.contentType, 1<<20, func(d string) {
+				deltas = append(deltas, d)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]any
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Fatalf("final response %s: %v", body, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("final response = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(deltas, tt.deltas) {
+				t.Errorf("deltas = %q, want %q", deltas, tt.deltas)
+			}
+		})
+	}
+}
+
+func TestReadLimit(t *testing.T) {
+	body := strings.Repeat("{\"delta\": \"0123456789\"}\n", 100)
+	if _, err := Read(strings.NewReader(body), "application/x-ndjson", 256, nil); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("err = %v, want ErrTooLarge", err)
+	}
+}
@@ -0,0 +1,194 @@
+// Package tenants keeps the registry of websites one backend serves, each
+// with its own bot workflow, allowed origins, rate limits and widget
+// theme. Tenants live in the message store database and are cached in
+// memory, reloaded periodically so every instance sees changes.
+package tenants
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/store"
+)
+
+var (
+	ErrNotFound  = errors.New("tenant not found")
+	ErrInvalidID = errors.New("tenant ID must be 1-63 lowercase letters, digits, - or _")
+	ErrReserved  = errors.New("tenant ID is reserved")
+)
+
+var idPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// Validate reports every problem with t at once. reserved lists IDs that
+// cannot be used, such as the default tenant's.
+func Validate(t store.Tenant, reserved ...string) error {
+	var errs []error
+	switch {
+	case !idPattern.MatchString(t.ID):
+		errs = append(errs, ErrInvalidID)
+	case slices.Contains(reserved, t.ID):
+		errs = append(errs, fmt.Errorf("%w: %s", ErrReserved, t.ID))
+	}
+	if strings.TrimSpace(t.Name) == "" {
+		errs = append(errs, errors.New("name is required"))
+	}
+	if t.WebhookURL != "" {
+		if u, err := url.Parse(t.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("webhook_url must be an http(s) URL, got %q", t.WebhookURL))
+		}
+	}
+	for _, origin := range t.AllowedOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" {
+			errs = append(errs, fmt.Errorf("allowed origin %q must be a scheme and host, e.g. https://example.com", origin))
+		}
+	}
+	if t.RateLimit < 0 || t.DailyQuota < 0 {
+		errs = append(errs, errors.New("rate_limit and daily_quota must not be negative"))
+	}
+	if t.RateLimitWindow != "" {
+		if d, err := time.ParseDuration(t.RateLimitWindow); err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("rate_limit_window must be a positive duration, got %q", t.RateLimitWindow))
+		}
+	}
+	for _, target := range []struct{ name, value string }{
+		{"sla_first_response", t.SLAFirstResponse},
+		{"sla_resolution", t.SLAResolution},
+	} {
+		if target.value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(target.value); err != nil || d < 0 {
+			errs = append(errs, fmt.Errorf("%s must be a duration, got %q", target.name, target.value))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Registry caches the tenants of a store.
+type Registry struct {
+	store    *store.Store
+	reserved []string
+
+	// OnReload, if set, is called with every tenant after each reload,
+	// including those Put and Delete make. Set it before Run.
+	OnReload func([]store.Tenant)
+
+	mu      sync.RWMutex
+	tenants map[string]store.Tenant
+	origins map[string]bool
+}
+
+// NewRegistry loads the tenants of st. reserved lists IDs tenants cannot
+// take.
+func NewRegistry(ctx context.Context, st *store.Store, reserved ...string) (*Registry, error) {
+	r := &Registry{store: st, reserved: reserved}
+	return r, r.Reload(ctx)
+}
+
+// Reload reads every tenant from the store again.
+func (r *Registry) Reload(ctx context.Context) error {
+	list, err := r.store.Tenants(ctx)
+	if err != nil {
+		return err
+	}
+	r.set(list)
+	if r.OnReload != nil {
+		r.OnReload(list)
+	}
+	return nil
+}
+
+func (r *Registry) set(list []store.Tenant) {
+	tenants := make(map[string]store.Tenant, len(list))
+	origins := make(map[string]bool)
+	for _, t := range list {
+		tenants[t.ID] = t
+		for _, o := range t.AllowedOrigins {
+			origins[strings.TrimSuffix(o, "/")] = true
+		}
+	}
+	r.mu.Lock()
+	r.tenants, r.origins = tenants, origins
+	r.mu.Unlock()
+}
+
+// Run reloads the tenants every interval until ctx is cancelled, picking
+// up changes made through other instances.
+func (r *Registry) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Reload(ctx); err != nil {
+				log.Error().Err(err).Msg("Error reloading tenants")
+			}
+		}
+	}
+}
+
+// Get returns a tenant.
+func (r *Registry) Get(id string) (store.Tenant, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.tenants[id]
+	return t, ok
+}
+
+// List returns every tenant by ID.
+func (r *Registry) List() []store.Tenant {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]store.Tenant, 0, len(r.tenants))
+	for _, t := range r.tenants {
+		out = append(out, t)
+	}
+	slices.SortFunc(out, func(a, b store.Tenant) int { return strings.Compare(a.ID, b.ID) })
+	return out
+}
+
+// AllowsOrigin reports whether any tenant allows browsers on origin.
+func (r *Registry) AllowsOrigin(origin string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.origins[origin]
+}
+
+// Put validates and saves a tenant, and returns it as stored.
+func (r *Registry) Put(ctx context.Context, t store.Tenant) (store.Tenant, error) {
+	if err := Validate(t, r.reserved...); err != nil {
+		return store.Tenant{}, err
+	}
+	if err := r.store.PutTenant(ctx, t); err != nil {
+		return store.Tenant{}, err
+	}
+	saved, err := r.store.Tenant(ctx, t.ID)
+	if err != nil {
+		return store.Tenant{}, err
+	}
+	return *saved, r.Reload(ctx)
+}
+
+// Delete removes a tenant.
+func (r *Registry) Delete(ctx context.Context, id string) error {
+	ok, err := r.store.DeleteTenant(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotFound
+	}
+	return r.Reload(ctx)
+}
@@ -0,0 +1,50 @@
+package ticketing
+
+import (
+	"context"
+	"net/http"
+)
+
+// Intercom creates tickets through the Intercom Tickets API.
+type Intercom struct {
+	BaseURL      string
+	Token        string
+	TicketTypeID string
+	URLTemplate  string
+	Client       *http.Client
+}
+
+func (i *Intercom) Name() string { return "intercom" }
+
+func (i *Intercom) CreateTicket(ctx context.Context, t Ticket) (Created, error) {
+	body := map[string]interface{}{
+		"ticket_type_id": i.TicketTypeID,
+		"ticket_attributes": map[string]interface{}{
+			"_default_title_":       t.Subject,
+			"_default_description_": t.Transcript,
+		},
+	}
+	if t.Email != "" {
+		body["contacts"] = []map[string]string{{"email": t.Email}}
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+i.Token)
+	header.Set("Intercom-Version", "2.10")
+
+	var resp struct {
+		ID       string `json:"id"`
+		TicketID string `json:"ticket_id"`
+	}
+	if err := postJSON(ctx, i.Client, i.BaseURL+"/tickets", header, body, &resp); err != nil {
+		return Created{}, err
+	}
+	created := Created{ID: resp.TicketID}
+	if created.ID == "" {
+		created.ID = resp.ID
+	}
+	if i.URLTemplate != "" {
+		created.URL = ticketURL(i.URLTemplate, resp.ID)
+	}
+	return created, nil
+}
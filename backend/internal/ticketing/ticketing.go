@@ -0,0 +1,122 @@
+// Package ticketing creates helpdesk tickets for conversations that were
+// escalated but never picked up by an agent.
+package ticketing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Ticket is the conversation handed over to the helpdesk.
+type Ticket struct {
+	SessionID  string
+	Subject    string
+	Transcript string
+	Name       string
+	Email      string
+}
+
+// Created identifies the ticket in the helpdesk.
+type Created struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+// Connector creates tickets in one helpdesk product.
+type Connector interface {
+	Name() string
+	CreateTicket(ctx context.Context, t Ticket) (Created, error)
+}
+
+// Config selects and configures a connector.
+type Config struct {
+	// Provider is "zendesk" or "intercom". Empty disables ticket export.
+	Provider string
+
+	ZendeskSubdomain string
+	ZendeskEmail     string
+	ZendeskAPIToken  string
+
+	IntercomToken        string
+	IntercomTicketTypeID string
+
+	// URLTemplate overrides the link posted back to the visitor; "{id}" is
+	// replaced with the ticket ID.
+	URLTemplate string
+}
+
+// New returns the connector for cfg.Provider, or nil if none is set.
+func New(cfg Config) (Connector, error) {
+	client := &http.Client{Timeout: 15 * time.Second}
+	switch cfg.Provider {
+	case "":
+		return nil, nil
+	case "zendesk":
+		if cfg.ZendeskSubdomain == "" || cfg.ZendeskEmail == "" || cfg.ZendeskAPIToken == "" {
+			return nil, fmt.Errorf("zendesk needs a subdomain, email and API token")
+		}
+		tmpl := cfg.URLTemplate
+		if tmpl == "" {
+			tmpl = "https://" + cfg.ZendeskSubdomain + ".zendesk.com/agent/tickets/{id}"
+		}
+		return &Zendesk{
+			BaseURL:     "https://" + cfg.ZendeskSubdomain + ".zendesk.com",
+			Email:       cfg.ZendeskEmail,
+			APIToken:    cfg.ZendeskAPIToken,
+			URLTemplate: tmpl,
+			Client:      client,
+		}, nil
+	case "intercom":
+		if cfg.IntercomToken == "" || cfg.IntercomTicketTypeID == "" {
+			return nil, fmt.Errorf("intercom needs an access token and ticket type ID")
+		}
+		return &Intercom{
+			BaseURL:      "https://api.intercom.io",
+			Token:        cfg.IntercomToken,
+			TicketTypeID: cfg.IntercomTicketTypeID,
+			URLTemplate:  cfg.URLTemplate,
+			Client:       client,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown ticketing provider %q", cfg.Provider)
+	}
+}
+
+// postJSON sends body to url and decodes a 2xx JSON response into out.
+func postJSON(ctx context.Context, client *http.Client, url string, header http.Header, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("helpdesk responded with status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+func ticketURL(tmpl, id string) string {
+	return strings.ReplaceAll(tmpl, "{id}", id)
+}
@@ -0,0 +1,206 @@
+// Package topk keeps a running estimate of the most frequent questions,
+// in constant memory: a count-min sketch counts every question, and the k
+// with the highest counts are kept by name.
+package topk
+
+import (
+	"hash/maphash"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// maxQuestionLength caps normalized questions, in characters.
+const maxQuestionLength = 120
+
+// Normalize lower-cases s, drops punctuation and collapses whitespace, so
+// the same question asked in different ways is counted once.
+func Normalize(s string) string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	q := []rune(strings.Join(words, " "))
+	if len(q) > maxQuestionLength {
+		q = q[:maxQuestionLength]
+	}
+	return strings.TrimSpace(string(q))
+}
+
+// Config sizes a sketch: Width counters in each of Depth rows, and the K
+// questions kept by name. A count is overestimated by more than e/Width of
+// all questions counted with a probability of at most 1/e^Depth.
+type Config struct {
+	K     int
+	Width int
+	Depth int
+}
+
+// Item is a question and its estimated count.
+type Item struct {
+	Question string `json:"question"`
+	Count    uint64 `json:"count"`
+}
+
+// Sketch estimates the top K questions of one stream. It is safe for
+// concurrent use.
+type Sketch struct {
+	mu       sync.Mutex
+	cfg      Config
+	seeds    []maphash.Seed
+	counters [][]uint64
+	top      map[string]uint64
+	total    uint64
+}
+
+// New returns an empty sketch.
+func New(cfg Config) *Sketch {
+	s := &Sketch{cfg: cfg, top: make(map[string]uint64, cfg.K+1)}
+	for range cfg.Depth {
+		s.seeds = append(s.seeds, maphash.MakeSeed())
+		s.counters = append(s.counters, make([]uint64, cfg.Width))
+	}
+	return s
+}
+
+// Add counts one occurrence of question.
+func (s *Sketch) Add(question string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.total++
+	estimate := ^uint64(0)
+	for i, seed := range s.seeds {
+		row := s.counters[i]
+		j := maphash.String(seed, question) % uint64(len(row))
+		row[j]++
+		estimate = min(estimate, row[j])
+	}
+	if _, ok := s.top[question]; ok || len(s.top) < s.cfg.K {
+		s.top[question] = estimate
+		return
+	}
+	// Replace the least frequent question kept, if this one is now more
+	// frequent
+	least, leastCount := "", ^uint64(0)
+	for q, c := range s.top {
+		if c < leastCount {
+			least, leastCount = q, c
+		}
+	}
+	if estimate > leastCount {
+		delete(s.top, least)
+		s.top[question] = estimate
+	}
+}
+
+// Decay halves every count, so questions asked a while ago give way to
+// what is being asked now.
+func (s *Sketch) Decay() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.total /= 2
+	for _, row := range s.counters {
+		for j := range row {
+			row[j] /= 2
+		}
+	}
+	for q, c := range s.top {
+		if c /= 2; c == 0 {
+			delete(s.top, q)
+		} else {
+			s.top[q] = c
+		}
+	}
+}
+
+// Top returns up to n of the most frequent questions, most frequent first,
+// and the number of questions counted.
+func (s *Sketch) Top(n int) ([]Item, uint64) {
+	s.mu.Lock()
+	items := make([]Item, 0, len(s.top))
+	for q, c := range s.top {
+		items = append(items, Item{Question: q, Count: c})
+	}
+	total := s.total
+	s.mu.Unlock()
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].Question < items[j].Question
+	})
+	if n > 0 && len(items) > n {
+		items = items[:n]
+	}
+	return items, total
+}
+
+// Tracker keeps a sketch per tenant.
+type Tracker struct {
+	cfg Config
+
+	mu       sync.RWMutex
+	sketches map[string]*Sketch
+}
+
+// NewTracker returns a tracker whose sketches are sized by cfg.
+func NewTracker(cfg Config) *Tracker {
+	return &Tracker{cfg: cfg, sketches: make(map[string]*Sketch)}
+}
+
+// Add counts question for tenant. Questions that normalize to nothing are
+// ignored.
+func (t *Tracker) Add(tenant, question string) {
+	if question = Normalize(question); question == "" {
+		return
+	}
+	t.sketch(tenant).Add(question)
+}
+
+func (t *Tracker) sketch(tenant string) *Sketch {
+	t.mu.RLock()
+	s, ok := t.sketches[tenant]
+	t.mu.RUnlock()
+	if ok {
+		return s
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s, ok = t.sketches[tenant]; !ok {
+		s = New(t.cfg)
+		t.sketches[tenant] = s
+	}
+	return s
+}
+
+// Decay halves every tenant's counts.
+func (t *Tracker) Decay() {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, s := range t.sketches {
+		s.Decay()
+	}
+}
+
+// Top returns the top n questions of tenant, see Sketch.Top.
+func (t *Tracker) Top(tenant string, n int) ([]Item, uint64) {
+	t.mu.RLock()
+	s, ok := t.sketches[tenant]
+	t.mu.RUnlock()
+	if !ok {
+		return []Item{}, 0
+	}
+	return s.Top(n)
+}
+
+// Tenants returns the tenants with questions counted, sorted.
+func (t *Tracker) Tenants() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	out := make([]string, 0, len(t.sketches))
+	for tenant := range t.sketches {
+		out = append(out, tenant)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,294 @@
+// Package totp is the second factor operators confirm destructive admin
+// requests with: time-based one-time passwords (RFC 6238) from an
+// authenticator app, and recovery codes for when the app is lost.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"web-chatbot-backend/internal/filestore"
+)
+
+var (
+	ErrNotEnrolled     = errors.New("two-factor authentication is not set up")
+	ErrAlreadyEnrolled = errors.New("two-factor authentication is already set up")
+	ErrNotStarted      = errors.New("two-factor enrollment was not started")
+	ErrInvalidCode     = errors.New("invalid one-time code")
+	ErrLocked          = errors.New("too many invalid one-time codes; try again later")
+)
+
+// Codes have Digits digits and change every Period. Codes from one period
+// before or after are accepted too, for clock drift.
+const (
+	Digits = 6
+	Period = 30 * time.Second
+	// RecoveryCodes are issued when enrollment is confirmed.
+	RecoveryCodes = 10
+	// After MaxFailures invalid codes in a row, an operator's codes are
+	// refused for Lockout, so they cannot be guessed.
+	MaxFailures = 5
+	Lockout     = 5 * time.Minute
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// hotp returns the code for secret in one period (RFC 4226).
+func hotp(secret []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, n%1_000_000)
+}
+
+// Enrollment is one operator's second factor.
+type Enrollment struct {
+	// Secret is the base32 key shared with the authenticator app.
+	Secret      string     `json:"secret"`
+	Confirmed   bool       `json:"confirmed"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ConfirmedAt *time.Time `json:"confirmed_at,omitempty"`
+	// RecoveryHashes are SHA-256 hashes of the unused recovery codes.
+	RecoveryHashes []string `json:"recovery_hashes,omitempty"`
+	// LastCounter is the period of the last code accepted, so a code
+	// cannot be used twice.
+	LastCounter uint64 `json:"last_counter,omitempty"`
+	// Failures counts the invalid codes since the last valid one, and
+	// LockedUntil is when codes are taken again after too many.
+	Failures    int        `json:"failures,omitempty"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+}
+
+// locked reports whether codes are refused at now.
+func (e *Enrollment) locked(now time.Time) bool {
+	return e.LockedUntil != nil && now.Before(*e.LockedUntil)
+}
+
+// fail counts an invalid code, locking the enrollment after too many.
+func (e *Enrollment) fail(now time.Time) {
+	e.Failures++
+	if e.Failures >= MaxFailures {
+		until := now.Add(Lockout)
+		e.Failures, e.LockedUntil = 0, &until
+	}
+}
+
+// Store holds the enrollments by operator name, saved to a JSON file after
+// every change.
+type Store struct {
+	mu       sync.Mutex
+	path     string
+	issuer   string
+	accounts map[string]*Enrollment
+}
+
+// NewStore loads enrollments from path. issuer names the service in
+// authenticator apps.
+func NewStore(path, issuer string) (*Store, error) {
+	s := &Store{path: path, issuer: issuer, accounts: make(map[string]*Enrollment)}
+	if err := filestore.Load(path, &s.accounts); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Enroll starts setting up a second factor for name and returns the
+// secret, along with an otpauth:// URL to show as a QR code. Until it is
+// confirmed, starting again replaces the secret.
+func (s *Store) Enroll(name string, now time.Time) (secret, uri string, err error) {
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	secret = encoding.EncodeToString(raw)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.accounts[name]; ok && e.Confirmed {
+		return "", "", ErrAlreadyEnrolled
+	}
+	s.accounts[name] = &Enrollment{Secret: secret, CreatedAt: now}
+	if err := s.save(); err != nil {
+		return "", "", err
+	}
+	return secret, s.uri(name, secret), nil
+}
+
+func (s *Store) uri(name, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", s.issuer)
+	q.Set("digits", fmt.Sprint(Digits))
+	q.Set("period", fmt.Sprint(int(Period/time.Second)))
+	label := url.PathEscape(s.issuer + ":" + name)
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// Confirm finishes enrollment with a code from the authenticator app and
+// returns the recovery codes, which are shown only this once.
+func (s *Store) Confirm(name, code string, now time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.accounts[name]
+	if !ok {
+		return nil, ErrNotStarted
+	}
+	if e.Confirmed {
+		return nil, ErrAlreadyEnrolled
+	}
+	if e.locked(now) {
+		return nil, ErrLocked
+	}
+	if !e.check(code, now) {
+		e.fail(now)
+		if err := s.save(); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidCode
+	}
+	e.Failures, e.LockedUntil = 0, nil
+	codes, hashes, err := recoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	e.Confirmed, e.ConfirmedAt, e.RecoveryHashes = true, &now, hashes
+	if err := s.save(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify checks a code from the authenticator app or, failing that, an
+// unused recovery code, which is then used up. It reports whether a
+// recovery code was used. After MaxFailures invalid codes in a row it
+// returns ErrLocked, whatever the code, until Lockout has passed.
+func (s *Store) Verify(name, code string, now time.Time) (recovery bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.accounts[name]
+	if !ok || !e.Confirmed {
+		return false, ErrNotEnrolled
+	}
+	if e.locked(now) {
+		return false, ErrLocked
+	}
+	if e.check(code, now) {
+		e.Failures, e.LockedUntil = 0, nil
+		return false, s.save()
+	}
+	h := hash(normalize(code))
+	for i, r := range e.RecoveryHashes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(r)) == 1 {
+			e.RecoveryHashes = append(e.RecoveryHashes[:i], e.RecoveryHashes[i+1:]...)
+			e.Failures, e.LockedUntil = 0, nil
+			return true, s.save()
+		}
+	}
+	e.fail(now)
+	if err := s.save(); err != nil {
+		return false, err
+	}
+	return false, ErrInvalidCode
+}
+
+// check reports whether code is the current one, give or take a period,
+// and was not used before.
+func (e *Enrollment) check(code string, now time.Time) bool {
+	secret, err := encoding.DecodeString(e.Secret)
+	if err != nil || len(code) != Digits {
+		return false
+	}
+	counter := uint64(now.Unix() / int64(Period/time.Second))
+	for _, c := range []uint64{counter - 1, counter, counter + 1} {
+		if c <= e.LastCounter {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(hotp(secret, c)), []byte(code)) == 1 {
+			e.LastCounter = c
+			return true
+		}
+	}
+	return false
+}
+
+// Enrolled reports whether name has a confirmed second factor, and how
+// many recovery codes are left.
+func (s *Store) Enrolled(name string) (bool, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.accounts[name]
+	if !ok || !e.Confirmed {
+		return false, 0
+	}
+	return true, len(e.RecoveryHashes)
+}
+
+// Any reports whether any operator has a confirmed second factor.
+func (s *Store) Any() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.accounts {
+		if e.Confirmed {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove drops the second factor of name.
+func (s *Store) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.accounts[name]; !ok {
+		return ErrNotEnrolled
+	}
+	delete(s.accounts, name)
+	return s.save()
+}
+
+// recoveryCodes returns new recovery codes, like "4f1c-9a2e-07bd", and
+// their hashes.
+func recoveryCodes() (codes, hashes []string, err error) {
+	for range RecoveryCodes {
+		raw := make([]byte, 6)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		h := hex.EncodeToString(raw)
+		code := h[:4] + "-" + h[4:8] + "-" + h[8:]
+		codes = append(codes, code)
+		hashes = append(hashes, hash(normalize(code)))
+	}
+	return codes, hashes, nil
+}
+
+// normalize makes recovery codes match however they are typed.
+func normalize(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+func hash(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// save writes the enrollments to disk. Callers hold s.mu.
+func (s *Store) save() error {
+	return filestore.Save(s.path, s.accounts)
+}
@@ -0,0 +1,95 @@
+package totp
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// enrolled returns a store with alice enrolled at now, and a function
+// giving her current code.
+func enrolled(t *testing.T, now time.Time) (*Store, func(time.Time) string) {
+	t.Helper()
+	s, err := NewStore(filepath.Join(t.TempDir(), "two_factor.json"), "Chatbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _, err := s.Enroll("alice", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := encoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := func(at time.Time) string {
+		return hotp(raw, uint64(at.Unix()/int64(Period/time.Second)))
+	}
+	if _, err := s.Confirm("alice", code(now), now); err != nil {
+		t.Fatal(err)
+	}
+	return s, code
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s, code := enrolled(t, now)
+
+	later := now.Add(Period)
+	if _, err := s.Verify("alice", code(later), later); err != nil {
+		t.Fatalf("valid code: %v", err)
+	}
+	if _, err := s.Verify("alice", code(later), later); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("reused code: err = %v, want ErrInvalidCode", err)
+	}
+	if _, err := s.Verify("bob", "123456", later); !errors.Is(err, ErrNotEnrolled) {
+		t.Fatalf("unknown operator: err = %v, want ErrNotEnrolled", err)
+	}
+}
+
+func TestVerifyLocksAfterTooManyFailures(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s, code := enrolled(t, now)
+
+	at := now.Add(Period)
+	for i := range MaxFailures {
+		if _, err := s.Verify("alice", "000000", at); !errors.Is(err, ErrInvalidCode) {
+			t.Fatalf("guess %d: err = %v, want ErrInvalidCode", i+1, err)
+		}
+	}
+	// Even the right code is refused while locked
+	if _, err := s.Verify("alice", code(at), at); !errors.Is(err, ErrLocked) {
+		t.Fatalf("while locked: err = %v, want ErrLocked", err)
+	}
+
+	// The lock is kept across restarts
+	reloaded, err := NewStore(s.path, "Chatbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reloaded.Verify("alice", code(at), at); !errors.Is(err, ErrLocked) {
+		t.Fatalf("after reload: err = %v, want ErrLocked", err)
+	}
+
+	after := at.Add(Lockout + Period)
+	if _, err := reloaded.Verify("alice", code(after), after); err != nil {
+		t.Fatalf("after the lockout: %v", err)
+	}
+}
+
+func TestVerifySuccessResetsFailures(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s, code := enrolled(t, now)
+
+	at := now
+	for range 3 {
+		for range MaxFailures - 1 {
+			s.Verify("alice", "000000", at)
+		}
+		at = at.Add(Period)
+		if _, err := s.Verify("alice", code(at), at); err != nil {
+			t.Fatalf("valid code after %d failures: %v", MaxFailures-1, err)
+		}
+	}
+}
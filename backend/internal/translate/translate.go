@@ -0,0 +1,147 @@
+// Package translate translates chat messages for operators through an
+// external translation service.
+package translate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Translation is one translated text.
+type Translation struct {
+	Text string `json:"text"`
+	// SourceLang is the language the service detected, e.g. "de".
+	SourceLang string `json:"source_lang,omitempty"`
+}
+
+// Translator translates texts into a target language, given as an ISO
+// 639-1 code such as "en". The result has one translation per text, in
+// order.
+type Translator interface {
+	Translate(ctx context.Context, texts []string, target string) ([]Translation, error)
+}
+
+// Config selects and configures a translator.
+type Config struct {
+	// Provider is "deepl" or "google". Empty disables translation.
+	Provider string
+	APIKey   string
+	// URL overrides the service's endpoint, e.g. DeepL's paid API.
+	URL string
+}
+
+// New returns the translator for cfg.Provider, or nil if none is set.
+func New(cfg Config) (Translator, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	switch cfg.Provider {
+	case "":
+		return nil, nil
+	case "deepl":
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("deepl needs an API key")
+		}
+		endpoint := cfg.URL
+		if endpoint == "" {
+			endpoint = "https://api-free.deepl.com/v2/translate"
+		}
+		return &DeepL{URL: endpoint, APIKey: cfg.APIKey, Client: client}, nil
+	case "google":
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("google needs an API key")
+		}
+		endpoint := cfg.URL
+		if endpoint == "" {
+			endpoint = "https://translation.googleapis.com/language/translate/v2"
+		}
+		return &Google{URL: endpoint, APIKey: cfg.APIKey, Client: client}, nil
+	}
+	return nil, fmt.Errorf("unknown translation provider %q", cfg.Provider)
+}
+
+// DeepL uses the DeepL API.
+type DeepL struct {
+	URL    string
+	APIKey string
+	Client *http.Client
+}
+
+func (d *DeepL) Translate(ctx context.Context, texts []string, target string) ([]Translation, error) {
+	body, _ := json.Marshal(map[string]any{"text": texts, "target_lang": strings.ToUpper(target)})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "DeepL-Auth-Key "+d.APIKey)
+	var result struct {
+		Translations []struct {
+			Text     string `json:"text"`
+			Detected string `json:"detected_source_language"`
+		} `json:"translations"`
+	}
+	if err := postJSON(d.Client, req, &result); err != nil {
+		return nil, err
+	}
+	out := make([]Translation, len(result.Translations))
+	for i, t := range result.Translations {
+		out[i] = Translation{Text: t.Text, SourceLang: strings.ToLower(t.Detected)}
+	}
+	return checkCount(out, texts)
+}
+
+// Google uses the Cloud Translation API (v2).
+type Google struct {
+	URL    string
+	APIKey string
+	Client *http.Client
+}
+
+func (g *Google) Translate(ctx context.Context, texts []string, target string) ([]Translation, error) {
+	body, _ := json.Marshal(map[string]any{"q": texts, "target": target, "format": "text"})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.URL+"?key="+url.QueryEscape(g.APIKey), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Data struct {
+			Translations []struct {
+				Text     string `json:"translatedText"`
+				Detected string `json:"detectedSourceLanguage"`
+			} `json:"translations"`
+		} `json:"data"`
+	}
+	if err := postJSON(g.Client, req, &result); err != nil {
+		return nil, err
+	}
+	out := make([]Translation, len(result.Data.Translations))
+	for i, t := range result.Data.Translations {
+		out[i] = Translation{Text: t.Text, SourceLang: t.Detected}
+	}
+	return checkCount(out, texts)
+}
+
+func checkCount(out []Translation, texts []string) ([]Translation, error) {
+	if len(out) != len(texts) {
+		return nil, fmt.Errorf("translation returned %d texts for %d", len(out), len(texts))
+	}
+	return out, nil
+}
+
+func postJSON(client *http.Client, req *http.Request, out interface{}) error {
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("translation responded with status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
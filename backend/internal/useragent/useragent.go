@@ -0,0 +1,88 @@
+// Package useragent extracts the device type, operating system and browser
+// from a User-Agent header. It recognises the common browsers and
+// platforms only; anything else is reported as "Other".
+package useragent
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Device types
+const (
+	Desktop = "desktop"
+	Mobile  = "mobile"
+	Tablet  = "tablet"
+	Bot     = "bot"
+)
+
+// Device describes the visitor's client.
+type Device struct {
+	Type    string `json:"type"`
+	OS      string `json:"os"`
+	Browser string `json:"browser"`
+	// BrowserVersion is the major version only.
+	BrowserVersion string `json:"browser_version,omitempty"`
+}
+
+type browserRule struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// Order matters: most browsers also claim to be Chrome and Safari.
+var browsers = []browserRule{
+	{"Edge", regexp.MustCompile(`Edg(?:e|A|iOS)?/(\d+)`)},
+	{"Opera", regexp.MustCompile(`(?:OPR|Opera)/(\d+)`)},
+	{"Samsung Internet", regexp.MustCompile(`SamsungBrowser/(\d+)`)},
+	{"Firefox", regexp.MustCompile(`(?:Firefox|FxiOS)/(\d+)`)},
+	{"Chrome", regexp.MustCompile(`(?:Chrome|CriOS)/(\d+)`)},
+	{"Safari", regexp.MustCompile(`Version/(\d+).*Safari/`)},
+}
+
+var botPattern = regexp.MustCompile(`(?i)bot|crawler|spider|headless|curl|wget|python-requests|go-http-client`)
+
+// Parse reads a User-Agent header.
+func Parse(ua string) Device {
+	d := Device{Type: Desktop, OS: "Other", Browser: "Other"}
+	if ua == "" {
+		return d
+	}
+
+	switch {
+	case strings.Contains(ua, "iPad"):
+		d.OS = "iOS"
+		d.Type = Tablet
+	case strings.Contains(ua, "iPhone") || strings.Contains(ua, "iPod"):
+		d.OS = "iOS"
+		d.Type = Mobile
+	case strings.Contains(ua, "Android"):
+		d.OS = "Android"
+		// Android tablets leave "Mobile" out of the User-Agent
+		if strings.Contains(ua, "Mobile") {
+			d.Type = Mobile
+		} else {
+			d.Type = Tablet
+		}
+	case strings.Contains(ua, "Windows"):
+		d.OS = "Windows"
+	case strings.Contains(ua, "Mac OS X") || strings.Contains(ua, "Macintosh"):
+		d.OS = "macOS"
+	case strings.Contains(ua, "CrOS"):
+		d.OS = "ChromeOS"
+	case strings.Contains(ua, "Linux"):
+		d.OS = "Linux"
+	}
+	if botPattern.MatchString(ua) {
+		d.Type = Bot
+	}
+
+	for _, b := range browsers {
+		if m := b.re.FindStringSubmatch(ua); m != nil {
+			d.Browser = b.name
+			d.BrowserVersion = m[1]
+			break
+		}
+	}
+	return d
+}
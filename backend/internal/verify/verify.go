@@ -0,0 +1,123 @@
+// Package verify issues the one-time codes visitors prove they own an
+// email address with. Codes are kept in memory, hashed, and each allows a
+// few attempts before it has to be sent again.
+package verify
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoCode          = errors.New("no verification code was sent")
+	ErrExpired         = errors.New("verification code has expired")
+	ErrWrongCode       = errors.New("wrong verification code")
+	ErrTooManyAttempts = errors.New("too many wrong verification codes")
+	ErrTooSoon         = errors.New("a verification code was sent moments ago")
+)
+
+// CodeLength is the number of digits in a code.
+const CodeLength = 6
+
+type pending struct {
+	email    string
+	hash     string
+	sentAt   time.Time
+	expires  time.Time
+	attempts int
+}
+
+// Codes holds the code last sent for each key, e.g. a session ID.
+type Codes struct {
+	// TTL is how long a code is valid, MaxAttempts how many wrong codes
+	// end it early and ResendAfter how long before another may be sent
+	// for the same key.
+	TTL         time.Duration
+	MaxAttempts int
+	ResendAfter time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*pending
+}
+
+// NewCodes returns codes valid for ten minutes and five attempts.
+func NewCodes() *Codes {
+	return &Codes{
+		TTL:         10 * time.Minute,
+		MaxAttempts: 5,
+		ResendAfter: 30 * time.Second,
+		pending:     make(map[string]*pending),
+	}
+}
+
+// Issue returns a new code for email, replacing any code sent for key
+// before.
+func (c *Codes) Issue(key, email string, now time.Time) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, ok := c.pending[key]; ok && now.Sub(p.sentAt) < c.ResendAfter {
+		return "", ErrTooSoon
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+	code := fmt.Sprintf("%0*d", CodeLength, n.Int64())
+	c.prune(now)
+	c.pending[key] = &pending{email: email, hash: hash(code), sentAt: now, expires: now.Add(c.TTL)}
+	return code, nil
+}
+
+// Pending reports whether a code sent for key is waiting to be entered.
+func (c *Codes) Pending(key string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.pending[key]
+	return ok && now.Before(p.expires)
+}
+
+// Check returns the email address the code for key was sent to if code
+// is right, and forgets the code. Wrong codes count against MaxAttempts.
+func (c *Codes) Check(key, code string, now time.Time) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.pending[key]
+	if !ok {
+		return "", ErrNoCode
+	}
+	if !now.Before(p.expires) {
+		delete(c.pending, key)
+		return "", ErrExpired
+	}
+	if subtle.ConstantTimeCompare([]byte(hash(code)), []byte(p.hash)) != 1 {
+		p.attempts++
+		if p.attempts >= c.MaxAttempts {
+			delete(c.pending, key)
+			return "", ErrTooManyAttempts
+		}
+		return "", ErrWrongCode
+	}
+	delete(c.pending, key)
+	return p.email, nil
+}
+
+// prune forgets expired codes. c.mu must be held.
+func (c *Codes) prune(now time.Time) {
+	for key, p := range c.pending {
+		if !now.Before(p.expires) {
+			delete(c.pending, key)
+		}
+	}
+}
+
+func hash(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
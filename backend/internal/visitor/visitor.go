@@ -0,0 +1,220 @@
+// Package visitor keeps a profile for every visitor across their sessions.
+package visitor
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"web-chatbot-backend/internal/filestore"
+)
+
+var (
+	ErrNotFound    = errors.New("visitor not found")
+	ErrSameVisitor = errors.New("cannot merge a visitor into itself")
+)
+
+// Profile aggregates everything known about one visitor ID.
+type Profile struct {
+	ID         string     `json:"id"`
+	SessionIDs []string   `json:"session_ids"`
+	FirstSeen  time.Time  `json:"first_seen"`
+	LastSeen   time.Time  `json:"last_seen"`
+	Notes      string     `json:"notes"`
+	Banned     bool       `json:"banned"`
+	BanReason  string     `json:"ban_reason,omitempty"`
+	BannedAt   *time.Time `json:"banned_at,omitempty"`
+	// MergedFrom lists the visitor IDs merged into this one, see Merge.
+	MergedFrom []string `json:"merged_from,omitempty"`
+}
+
+// PreviousSessions is the number of sessions before the most recent one.
+func (p *Profile) PreviousSessions() int {
+	if len(p.SessionIDs) == 0 {
+		return 0
+	}
+	return len(p.SessionIDs) - 1
+}
+
+// Update holds the admin-editable fields of a profile. Nil fields are left
+// unchanged.
+type Update struct {
+	Notes     *string `json:"notes"`
+	Banned    *bool   `json:"banned"`
+	BanReason *string `json:"ban_reason"`
+}
+
+// Store holds visitor profiles in memory and, when a path is set, saves
+// them to a JSON file after every change.
+type Store struct {
+	mu       sync.RWMutex
+	path     string
+	profiles map[string]*Profile
+}
+
+// NewStore loads profiles from path. An empty path keeps profiles in memory
+// only.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path, profiles: make(map[string]*Profile)}
+	if path != "" {
+		if err := filestore.Load(path, &s.profiles); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Get returns a copy of the profile for id.
+func (s *Store) Get(id string) (*Profile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.profiles[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return p.clone(), nil
+}
+
+// List returns copies of all profiles, newest first.
+func (s *Store) List() []*Profile {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]*Profile, 0, len(s.profiles))
+	for _, p := range s.profiles {
+		out = append(out, p.clone())
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].FirstSeen.Equal(out[j].FirstSeen) {
+			return out[i].FirstSeen.After(out[j].FirstSeen)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// Touch returns the profile for id, creating it on first sight and
+// refreshing its last-seen time otherwise.
+func (s *Store) Touch(id string) (*Profile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.getOrCreate(id)
+	p.LastSeen = time.Now()
+	return p.clone(), s.save()
+}
+
+// RecordSession adds a session to the visitor's history.
+func (s *Store) RecordSession(id, sessionID string) (*Profile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.getOrCreate(id)
+	for _, existing := range p.SessionIDs {
+		if existing == sessionID {
+			return p.clone(), nil
+		}
+	}
+	p.SessionIDs = append(p.SessionIDs, sessionID)
+	p.LastSeen = time.Now()
+	return p.clone(), s.save()
+}
+
+// Update applies admin edits to an existing profile.
+func (s *Store) Update(id string, u Update) (*Profile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.profiles[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if u.Notes != nil {
+		p.Notes = *u.Notes
+	}
+	if u.BanReason != nil {
+		p.BanReason = *u.BanReason
+	}
+	if u.Banned != nil && *u.Banned != p.Banned {
+		p.Banned = *u.Banned
+		if p.Banned {
+			now := time.Now()
+			p.BannedAt = &now
+		} else {
+			p.BannedAt = nil
+			p.BanReason = ""
+		}
+	}
+	return p.clone(), s.save()
+}
+
+// Merge folds the profile of from, e.g. the anonymous visitor someone was
+// on another device, into the profile of into and removes it. Sessions are
+// kept in the order the visitors were first seen, and a ban on either
+// applies to the merged profile.
+func (s *Store) Merge(from, into string) (*Profile, error) {
+	if from == into {
+		return nil, ErrSameVisitor
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.profiles[from]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	p := s.getOrCreate(into)
+
+	seen := make(map[string]bool)
+	first, second := p.SessionIDs, old.SessionIDs
+	if old.FirstSeen.Before(p.FirstSeen) {
+		first, second = second, first
+	}
+	var ids []string
+	for _, id := range append(append([]string(nil), first...), second...) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	p.SessionIDs = ids
+	if old.FirstSeen.Before(p.FirstSeen) {
+		p.FirstSeen = old.FirstSeen
+	}
+	if old.LastSeen.After(p.LastSeen) {
+		p.LastSeen = old.LastSeen
+	}
+	if old.Notes != "" {
+		if p.Notes != "" {
+			p.Notes += "\n"
+		}
+		p.Notes += old.Notes
+	}
+	if old.Banned && !p.Banned {
+		p.Banned, p.BanReason, p.BannedAt = true, old.BanReason, old.BannedAt
+	}
+	p.MergedFrom = append(append(p.MergedFrom, from), old.MergedFrom...)
+	delete(s.profiles, from)
+	return p.clone(), s.save()
+}
+
+func (s *Store) getOrCreate(id string) *Profile {
+	p, ok := s.profiles[id]
+	if !ok {
+		now := time.Now()
+		p = &Profile{ID: id, FirstSeen: now, LastSeen: now}
+		s.profiles[id] = p
+	}
+	return p
+}
+
+// save must be called with s.mu held.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	return filestore.Save(s.path, s.profiles)
+}
+
+func (p *Profile) clone() *Profile {
+	c := *p
+	c.SessionIDs = append([]string(nil), p.SessionIDs...)
+	c.MergedFrom = append([]string(nil), p.MergedFrom...)
+	return &c
+}
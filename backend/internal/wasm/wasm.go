@@ -0,0 +1,296 @@
+// Package wasm runs WebAssembly processor modules at the hook points.
+//
+// A processor module exports:
+//
+//	alloc(size i32) i32                  allocate size bytes for the input
+//	<point>(ptr i32, len i32) i64        e.g. on_message_in, before_upstream
+//
+// Each point function receives the hook context as JSON and returns the
+// location of its JSON result packed as (ptr << 32 | len), or 0 for no
+// change. The result uses the same shape as external HTTP hooks: optional
+// "message", "payload" and "reply" replacements, or "abort" with an
+// optional "abort_reply".
+//
+// Every call runs in a fresh module instance, so processors cannot keep
+// state between messages or see each other's memory. Modules may import
+// WASI but get no file system, network or environment access.
+package wasm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+
+	"web-chatbot-backend/internal/hooks"
+)
+
+// Limits bounds a single processor call.
+type Limits struct {
+	Timeout time.Duration
+	// MemoryPages caps linear memory in 64 KiB pages.
+	MemoryPages uint32
+}
+
+type module struct {
+	name     string
+	modTime  time.Time
+	compiled wazero.CompiledModule
+	points   map[hooks.Point]bool
+}
+
+// Host loads processor modules from a directory and reloads them when the
+// files change.
+type Host struct {
+	dir     string
+	limits  Limits
+	runtime wazero.Runtime
+
+	mu      sync.RWMutex
+	modules []*module
+}
+
+// NewHost creates a runtime and loads every *.wasm file in dir.
+func NewHost(ctx context.Context, dir string, limits Limits) (*Host, error) {
+	cfg := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(limits.MemoryPages).
+		WithCloseOnContextDone(true)
+	r := wazero.NewRuntimeWithConfig(ctx, cfg)
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+		r.Close(ctx)
+		return nil, err
+	}
+
+	h := &Host{dir: dir, limits: limits, runtime: r}
+	if err := h.Reload(ctx); err != nil {
+		r.Close(ctx)
+		return nil, err
+	}
+	return h, nil
+}
+
+// Register attaches the host to every hook point. The hooks always run the
+// currently loaded modules, so reloads take effect without re-registering.
+func (h *Host) Register(runner *hooks.Runner) {
+	for _, p := range hooks.Points {
+		p := p
+		runner.Register(p, hooks.Func{
+			HookName: "wasm",
+			Fn: func(ctx context.Context, hc *hooks.Context) error {
+				return h.run(ctx, p, hc)
+			},
+		}, false)
+	}
+}
+
+// Modules lists the loaded module names and the points they handle.
+func (h *Host) Modules() map[string][]hooks.Point {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	out := make(map[string][]hooks.Point, len(h.modules))
+	for _, m := range h.modules {
+		points := []hooks.Point{}
+		for _, p := range hooks.Points {
+			if m.points[p] {
+				points = append(points, p)
+			}
+		}
+		out[m.name] = points
+	}
+	return out
+}
+
+// Reload recompiles modules whose files were added or changed and drops
+// modules whose files were removed. On error the current set is kept.
+func (h *Host) Reload(ctx context.Context) error {
+	paths, err := filepath.Glob(filepath.Join(h.dir, "*.wasm"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	h.mu.RLock()
+	current := make(map[string]*module, len(h.modules))
+	for _, m := range h.modules {
+		current[m.name] = m
+	}
+	h.mu.RUnlock()
+
+	next := make([]*module, 0, len(paths))
+	changed := len(paths) != len(current)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		name := filepath.Base(path)
+		if m, ok := current[name]; ok && m.modTime.Equal(info.ModTime()) {
+			next = append(next, m)
+			continue
+		}
+		m, err := h.compile(ctx, path, info.ModTime())
+		if err != nil {
+			return fmt.Errorf("wasm module %s: %w", name, err)
+		}
+		log.Info().Str("module", name).Msg("Loaded wasm module")
+		next = append(next, m)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+
+	h.mu.Lock()
+	old := h.modules
+	h.modules = next
+	h.mu.Unlock()
+
+	// Release compiled code for modules that were replaced or removed
+	keep := make(map[*module]bool, len(next))
+	for _, m := range next {
+		keep[m] = true
+	}
+	for _, m := range old {
+		if !keep[m] {
+			m.compiled.Close(ctx)
+		}
+	}
+	return nil
+}
+
+// Watch polls the module directory every interval and reloads on change.
+func (h *Host) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := h.Reload(ctx); err != nil {
+				log.Error().Err(err).Msg("Error reloading wasm modules")
+			}
+		}
+	}
+}
+
+// Close releases the runtime and all modules.
+func (h *Host) Close(ctx context.Context) error {
+	return h.runtime.Close(ctx)
+}
+
+func (h *Host) compile(ctx context.Context, path string, modTime time.Time) (*module, error) {
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := h.runtime.CompileModule(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	exports := compiled.ExportedFunctions()
+	if _, ok := exports["alloc"]; !ok {
+		compiled.Close(ctx)
+		return nil, fmt.Errorf("missing alloc export")
+	}
+	m := &module{name: filepath.Base(path), modTime: modTime, compiled: compiled, points: make(map[hooks.Point]bool)}
+	for _, p := range hooks.Points {
+		if _, ok := exports[string(p)]; ok {
+			m.points[p] = true
+		}
+	}
+	return m, nil
+}
+
+func (h *Host) run(ctx context.Context, p hooks.Point, hc *hooks.Context) error {
+	h.mu.RLock()
+	modules := append([]*module(nil), h.modules...)
+	h.mu.RUnlock()
+
+	for _, m := range modules {
+		if !m.points[p] {
+			continue
+		}
+		if err := h.call(ctx, m, p, hc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type result struct {
+	Message    *string                `json:"message"`
+	Payload    map[string]interface{} `json:"payload"`
+	Reply      *string                `json:"reply"`
+	Abort      bool                   `json:"abort"`
+	AbortReply string                 `json:"abort_reply"`
+	Reason     string                 `json:"reason"`
+}
+
+// call runs one point function in a fresh instance of m.
+func (h *Host) call(ctx context.Context, m *module, p hooks.Point, hc *hooks.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, h.limits.Timeout)
+	defer cancel()
+
+	input, err := json.Marshal(hc)
+	if err != nil {
+		return err
+	}
+
+	// An empty name lets several instances of the same module coexist.
+	// Reactor modules initialise themselves in _initialize.
+	cfg := wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize")
+	inst, err := h.runtime.InstantiateModule(ctx, m.compiled, cfg)
+	if err != nil {
+		return fmt.Errorf("wasm %s: %w", m.name, err)
+	}
+	defer inst.Close(ctx)
+
+	res, err := inst.ExportedFunction("alloc").Call(ctx, uint64(len(input)))
+	if err != nil {
+		return fmt.Errorf("wasm %s: alloc: %w", m.name, err)
+	}
+	ptr := uint32(res[0])
+	if !inst.Memory().Write(ptr, input) {
+		return fmt.Errorf("wasm %s: input out of bounds", m.name)
+	}
+
+	res, err = inst.ExportedFunction(string(p)).Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		return fmt.Errorf("wasm %s: %s: %w", m.name, p, err)
+	}
+	if res[0] == 0 {
+		return nil
+	}
+	outPtr, outLen := uint32(res[0]>>32), uint32(res[0])
+	output, ok := inst.Memory().Read(outPtr, outLen)
+	if !ok {
+		return fmt.Errorf("wasm %s: output out of bounds", m.name)
+	}
+
+	var out result
+	if err := json.Unmarshal(output, &out); err != nil {
+		return fmt.Errorf("wasm %s: invalid output: %w", m.name, err)
+	}
+	if out.Abort {
+		return &hooks.AbortError{Hook: m.name, Reply: out.AbortReply, Reason: out.Reason}
+	}
+	if out.Message != nil {
+		hc.Message = *out.Message
+	}
+	if out.Payload != nil {
+		hc.Payload = out.Payload
+	}
+	if out.Reply != nil {
+		hc.Reply = *out.Reply
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ErrGone is returned when the push service reports that a subscription
+// no longer exists and should be removed.
+var ErrGone = errors.New("push subscription expired")
+
+// Sender encrypts and delivers notifications (RFC 8291, RFC 8292).
+type Sender struct {
+	key *ecdsa.PrivateKey
+	// PublicKey is the VAPID public key in the base64url form browsers
+	// expect as applicationServerKey.
+	PublicKey string
+	// Subject is a mailto: or https: contact for the push service.
+	Subject string
+	// TTL is how long the push service keeps undelivered notifications.
+	TTL    time.Duration
+	Client *http.Client
+}
+
+// NewSender returns a sender for the VAPID key pair, given as base64url
+// strings as produced by common VAPID key generators.
+func NewSender(publicKey, privateKey, subject string) (*Sender, error) {
+	priv, err := decodeKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("vapid private key: %w", err)
+	}
+	pub, err := decodeKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("vapid public key: %w", err)
+	}
+	if _, err := ecdh.P256().NewPublicKey(pub); err != nil {
+		return nil, fmt.Errorf("vapid public key: %w", err)
+	}
+	// pub is an uncompressed point: 0x04 || X || Y
+	key := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(pub[1:33]),
+			Y:     new(big.Int).SetBytes(pub[33:]),
+		},
+		D: new(big.Int).SetBytes(priv),
+	}
+	return &Sender{
+		key:       key,
+		PublicKey: publicKey,
+		Subject:   subject,
+		TTL:       24 * time.Hour,
+		Client:    &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Notification is the JSON payload the service worker receives.
+type Notification struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	URL   string `json:"url,omitempty"`
+	Tag   string `json:"tag,omitempty"`
+}
+
+// Send delivers n to one subscription.
+func (s *Sender) Send(ctx context.Context, sub Subscription, n Notification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	body, err := encrypt(sub, payload)
+	if err != nil {
+		return err
+	}
+	auth, err := s.vapidHeader(sub.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(s.TTL.Seconds())))
+	req.Header.Set("Authorization", auth)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return ErrGone
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("push service responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// vapidHeader signs a JWT for the push service's origin.
+func (s *Sender) vapidHeader(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": s.Subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`)) + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	r, sig, err := ecdsa.Sign(rand.Reader, s.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	sig.FillBytes(signature[32:])
+	return fmt.Sprintf("vapid t=%s.%s, k=%s", unsigned, enc.EncodeToString(signature), s.PublicKey), nil
+}
+
+// encrypt applies the aes128gcm content encoding to payload for sub.
+func encrypt(sub Subscription, payload []byte) ([]byte, error) {
+	uaPublic, err := decodeKey(sub.Keys.P256dh)
+	if err != nil {
+		return nil, fmt.Errorf("subscription p256dh: %w", err)
+	}
+	authSecret, err := decodeKey(sub.Keys.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("subscription auth: %w", err)
+	}
+	uaKey, err := ecdh.P256().NewPublicKey(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+	asKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asKey.PublicKey().Bytes()
+	shared, err := asKey.ECDH(uaKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keyInfo := "WebPush: info\x00" + string(uaPublic) + string(asPublic)
+	ikm, err := hkdf.Key(sha256.New, shared, authSecret, keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	prk, err := hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// A single record, terminated by the 0x02 padding delimiter
+	ciphertext := gcm.Seal(nil, nonce, append(payload, 2), nil)
+
+	var header bytes.Buffer
+	header.Write(salt)
+	binary.Write(&header, binary.BigEndian, uint32(4096))
+	header.WriteByte(byte(len(asPublic)))
+	header.Write(asPublic)
+	return append(header.Bytes(), ciphertext...), nil
+}
+
+func decodeKey(s string) ([]byte, error) {
+	if b, err := base64.RawURLEncoding.DecodeString(s); err == nil {
+		return b, nil
+	}
+	return base64.URLEncoding.DecodeString(s)
+}
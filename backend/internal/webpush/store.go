@@ -0,0 +1,103 @@
+// Package webpush sends Web Push notifications to visitors' browsers,
+// signed with the server's VAPID key.
+package webpush
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"web-chatbot-backend/internal/filestore"
+)
+
+// Subscription is a browser push subscription as returned by
+// PushManager.subscribe().
+type Subscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Store keeps the subscriptions of each visitor, saved to a JSON file
+// after every change.
+type Store struct {
+	mu   sync.Mutex
+	path string
+	// subs maps visitor IDs to their subscriptions, one per browser.
+	subs map[string][]Subscription
+}
+
+// NewStore loads subscriptions from path.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path, subs: make(map[string][]Subscription)}
+	if err := filestore.Load(path, &s.subs); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Subscribe adds or refreshes a subscription for the visitor.
+func (s *Store) Subscribe(visitorID string, sub Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub.CreatedAt = time.Now()
+	list := s.subs[visitorID]
+	for i := range list {
+		if list[i].Endpoint == sub.Endpoint {
+			list[i] = sub
+			return filestore.Save(s.path, s.subs)
+		}
+	}
+	s.subs[visitorID] = append(list, sub)
+	return filestore.Save(s.path, s.subs)
+}
+
+// Unsubscribe removes the subscription with the given endpoint.
+func (s *Store) Unsubscribe(visitorID, endpoint string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := s.subs[visitorID]
+	for i := range list {
+		if list[i].Endpoint == endpoint {
+			list = append(list[:i], list[i+1:]...)
+			if len(list) == 0 {
+				delete(s.subs, visitorID)
+			} else {
+				s.subs[visitorID] = list
+			}
+			return filestore.Save(s.path, s.subs)
+		}
+	}
+	return nil
+}
+
+// Subscriptions returns the visitor's subscriptions.
+func (s *Store) Subscriptions(visitorID string) []Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Subscription(nil), s.subs[visitorID]...)
+}
+
+// Move hands the subscriptions of one visitor to another, so they are
+// notified on every device after the two are merged.
+func (s *Store) Move(fromVisitor, toVisitor string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	moved, ok := s.subs[fromVisitor]
+	if !ok {
+		return nil
+	}
+	delete(s.subs, fromVisitor)
+	list := s.subs[toVisitor]
+	for _, sub := range moved {
+		known := slices.ContainsFunc(list, func(existing Subscription) bool { return existing.Endpoint == sub.Endpoint })
+		if !known {
+			list = append(list, sub)
+		}
+	}
+	s.subs[toVisitor] = list
+	return filestore.Save(s.path, s.subs)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/jobs"
+	"web-chatbot-backend/internal/reminders"
+	"web-chatbot-backend/internal/store"
+)
+
+// Kinds of queued jobs
+const reminderJob = "reminder"
+
+// Most jobs listed by GET /admin/v1/job-queue
+const maxJobsListed = 1000
+
+// With CHATBOT_JOB_QUEUE=true, reminders are delivered through the job
+// queue of the message store instead of by each instance from its own
+// file, so they survive restarts and instances sharing the database never
+// send one twice.
+//
+// jobQueue is nil unless CHATBOT_JOB_QUEUE is set.
+var jobQueue *jobs.Runner
+
+// startJobQueue queues the pending reminders and starts running jobs.
+func startJobQueue(ctx context.Context) {
+	jobQueue = jobs.NewRunner(messageStore)
+	jobQueue.Lease = serverConfig.JobQueue.Lease
+	jobQueue.Handle(reminderJob, runReminderJob)
+	for _, r := range visitorReminders.List("", reminders.StatusPending) {
+		queueReminder(ctx, r)
+	}
+	go jobQueue.Run(ctx, serverConfig.JobQueue.Interval)
+}
+
+// queueReminder adds the job delivering a reminder, unless it is queued
+// already.
+func queueReminder(ctx context.Context, r *reminders.Reminder) {
+	if jobQueue == nil {
+		return
+	}
+	if _, err := jobQueue.Enqueue(ctx, reminderJob, r.ID, r, r.NextAttemptAt); err != nil {
+		log.Error().Str("reminder_id", r.ID).Err(err).Msg("Error queueing reminder")
+	}
+}
+
+// runReminderJob delivers a reminder, trying again every
+// CHATBOT_REMINDER_RETRY until it is CHATBOT_REMINDER_EXPIRY overdue.
+func runReminderJob(ctx context.Context, job store.Job) error {
+	var r reminders.Reminder
+	if err := json.Unmarshal(job.Payload, &r); err != nil {
+		return fmt.Errorf("invalid reminder: %w", err)
+	}
+	// The instance that stored the reminder has the latest copy
+	if stored, err := visitorReminders.Get(r.ID); err == nil {
+		if stored.Status != reminders.StatusPending {
+			return nil
+		}
+		r = *stored
+	}
+	channel, err := deliverReminder(ctx, r)
+	now := time.Now()
+	visitorReminders.Record(r.ID, channel, err, now)
+	if err != nil && now.Sub(r.DueAt) < serverConfig.Reminders.Expiry {
+		return jobs.Retry(now.Add(serverConfig.Reminders.Retry), err)
+	}
+	return err
+}
+
+// registerJobQueueRoutes lets operators look into the job queue.
+func registerJobQueueRoutes(admin fiber.Router) {
+	admin.Get("/job-queue", func(c *fiber.Ctx) error {
+		if jobQueue == nil {
+			return c.Status(404).JSON(fiber.Map{"error": "Job queue is not enabled"})
+		}
+		list, err := messageStore.Jobs(c.Context(), c.Query("kind"), c.Query("status"), maxJobsListed)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return paginate(c, "jobs", list)
+	})
+}
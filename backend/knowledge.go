@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/annotations"
+	"web-chatbot-backend/internal/events"
+	"web-chatbot-backend/internal/knowledge"
+	"web-chatbot-backend/internal/session"
+)
+
+var knowledgeBase *knowledge.Store
+
+// suggestKnowledge queues an agent's answer for the knowledge base when a
+// reviewer rated it highly enough, with the visitor message it answered
+// as the question.
+func suggestKnowledge(a annotations.Annotation) {
+	if serverConfig.KBMinQuality <= 0 || a.Message.Role != session.RoleAgent || a.Quality == nil || *a.Quality < serverConfig.KBMinQuality || a.Prompt == "" {
+		return
+	}
+	var agent string
+	if sess, err := sessions.Get(a.SessionID); err == nil {
+		agent = sess.Agent
+	}
+	e, created, err := knowledgeBase.Suggest(knowledge.Entry{
+		Question:  a.Prompt,
+		Answer:    a.Message.Text,
+		SessionID: a.SessionID,
+		Index:     a.Index,
+		Agent:     agent,
+		Quality:   *a.Quality,
+	})
+	if err != nil {
+		log.Error().Str("session_id", a.SessionID).Int("index", a.Index).Err(err).Msg("Error suggesting knowledge base entry")
+		return
+	}
+	if !created {
+		return
+	}
+	log.Info().Str("entry_id", e.ID).Str("session_id", e.SessionID).Int("quality", e.Quality).Msg("Knowledge base entry suggested")
+	bus.Publish(events.Event{
+		Type:      "kb_entry_suggested",
+		SessionID: e.SessionID,
+		Data:      map[string]any{"entry_id": e.ID, "question": e.Question, "quality": e.Quality},
+	})
+}
+
+// registerKnowledgeRoutes lets admins review suggested knowledge base
+// entries. Approved entries are published as kb_entry_approved events and
+// exported, for the workflow to answer from.
+func registerKnowledgeRoutes(admin fiber.Router) {
+	// Entries, oldest first, optionally by status, e.g. ?status=pending for
+	// the review queue
+	admin.Get("/kb", func(c *fiber.Ctx) error {
+		status := knowledge.Status(c.Query("status"))
+		switch status {
+		case "", knowledge.StatusPending, knowledge.StatusApproved, knowledge.StatusRejected:
+		default:
+			return c.Status(400).JSON(fiber.Map{"error": "status must be pending, approved or rejected"})
+		}
+		return paginate(c, "entries", knowledgeBase.List(status))
+	})
+
+	// Approved entries as JSON lines, one question and answer per line
+	admin.Get("/kb/export", func(c *fiber.Ctx) error {
+		list := knowledgeBase.List(knowledge.StatusApproved)
+		c.Set("Content-Type", "application/x-ndjson")
+		c.Set("Content-Disposition", `attachment; filename="knowledge.jsonl"`)
+		streamBody(c, func(w *bufio.Writer) {
+			enc := json.NewEncoder(w)
+			for _, e := range list {
+				if err := enc.Encode(fiber.Map{"id": e.ID, "question": e.Question, "answer": e.Answer}); err != nil {
+					return
+				}
+			}
+		})
+		return nil
+	})
+
+	// The admin may reword the question or answer before approving
+	admin.Post("/kb/:id/approve", func(c *fiber.Ctx) error {
+		var body struct {
+			Question string `json:"question"`
+			Answer   string `json:"answer"`
+		}
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&body); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+			}
+		}
+		e, err := knowledgeBase.Review(c.Params("id"), true, changedBy(c), body.Question, body.Answer)
+		if err != nil {
+			return knowledgeReviewError(c, err)
+		}
+		log.Info().Str("entry_id", e.ID).Str("by", e.ReviewedBy).Msg("Knowledge base entry approved")
+		bus.Publish(events.Event{
+			Type:      "kb_entry_approved",
+			SessionID: e.SessionID,
+			Data:      map[string]any{"entry_id": e.ID, "question": e.Question, "answer": e.Answer, "by": e.ReviewedBy},
+		})
+		return c.JSON(e)
+	})
+
+	admin.Post("/kb/:id/reject", func(c *fiber.Ctx) error {
+		e, err := knowledgeBase.Review(c.Params("id"), false, changedBy(c), "", "")
+		if err != nil {
+			return knowledgeReviewError(c, err)
+		}
+		return c.JSON(e)
+	})
+
+	admin.Delete("/kb/:id", func(c *fiber.Ctx) error {
+		if err := knowledgeBase.Remove(c.Params("id")); err != nil {
+			if errors.Is(err, knowledge.ErrNotFound) {
+				return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.SendStatus(204)
+	})
+}
+
+func knowledgeReviewError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, knowledge.ErrNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, knowledge.ErrReviewed):
+		return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+}
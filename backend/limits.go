@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"web-chatbot-backend/internal/ratelimit"
+)
+
+// Message rate limits for visitors, counted per visitor ID or, for
+// anonymous visitors, per IP address. CHATBOT_DAILY_QUOTA=0 means no
+// daily quota.
+var messageLimiter *ratelimit.Limiter
+
+// Sent to visitors who are over their limit
+const rateLimitedMessage = "You are sending messages too quickly. Please wait a moment and try again."
+
+func rateLimitKey(visitorID, ip string) string {
+	if visitorID != "" {
+		return "visitor:" + visitorID
+	}
+	return "ip:" + ip
+}
+
+// setRateLimitHeaders describes state in the X-RateLimit-* headers.
+func setRateLimitHeaders(c *fiber.Ctx, state ratelimit.State) {
+	c.Set("X-RateLimit-Limit", strconv.Itoa(state.Limit))
+	c.Set("X-RateLimit-Remaining", strconv.Itoa(state.Remaining))
+	c.Set("X-RateLimit-Reset", strconv.FormatInt(state.Reset.Unix(), 10))
+}
+
+// retryAfterSeconds rounds the wait for a refused client up to whole
+// seconds.
+func retryAfterSeconds(state ratelimit.State) int {
+	return int((state.RetryAfter(time.Now()) + time.Second - 1) / time.Second)
+}
+
+// registerLimitRoutes lets widgets check their limits before sending.
+func registerLimitRoutes(app *fiber.App) {
+	app.Get("/limits", func(c *fiber.Ctx) error {
+		visitorID := c.Query("visitor_id")
+		if id := c.Query("session_id"); id != "" && visitorID == "" {
+			sess, err := sessions.Get(id)
+			if err != nil {
+				return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+			}
+			visitorID = sess.VisitorID
+		}
+		state := limiterFor(tenantFrom(c.UserContext())).Peek(rateLimitKey(visitorID, c.IP()))
+		setRateLimitHeaders(c, state)
+		return c.JSON(state)
+	})
+}
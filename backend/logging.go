@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/logging"
+	"web-chatbot-backend/internal/requestid"
+)
+
+// setupLogging applies the configured level and format.
+func setupLogging() {
+	if err := logging.Configure(serverConfig.Log.Level, serverConfig.Log.Format); err != nil {
+		log.Fatal().Err(err).Msg("Invalid logging configuration")
+	}
+}
+
+// assignRequestID gives every HTTP request an ID, the caller's X-Request-ID
+// if it sent a usable one, returns it in the X-Request-ID header and keeps
+// it in the request's user context, from where it is logged and forwarded
+// to the bot. WebSocket connections get an ID per message instead.
+func assignRequestID(c *fiber.Ctx) error {
+	id := c.Get(requestid.Header)
+	if !requestid.Valid(id) {
+		id = requestid.New()
+	}
+	c.Set(requestid.Header, id)
+	c.SetUserContext(requestid.With(c.UserContext(), id))
+	return c.Next()
+}
+
+// registerLoggingRoutes lets admins turn up the level, e.g. to debug while
+// chasing a problem, without restarting the server.
+func registerLoggingRoutes(admin fiber.Router) {
+	admin.Get("/logging", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"level": logging.Level(), "format": logging.Format()})
+	})
+
+	admin.Put("/logging", func(c *fiber.Ctx) error {
+		var body struct {
+			Level  string `json:"level"`
+			Format string `json:"format"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		if err := logging.Configure(body.Level, body.Format); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		log.Info().Str("log_level", logging.Level()).Str("log_format", logging.Format()).Str("by", changedBy(c)).Msg("Changed logging")
+		return c.JSON(fiber.Map{"level": logging.Level(), "format": logging.Format()})
+	})
+}
@@ -1,234 +1,1042 @@
 package main
 
 import (
-	"log"
-	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/gofiber/websocket/v2"
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/etag"
+	"github.com/gofiber/websocket/v2"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"web-chatbot-backend/internal/actions"
+	"web-chatbot-backend/internal/agentpush"
+	"web-chatbot-backend/internal/agents"
+	"web-chatbot-backend/internal/alerts"
+	"web-chatbot-backend/internal/annotations"
+	"web-chatbot-backend/internal/apikeys"
+	"web-chatbot-backend/internal/assign"
+	"web-chatbot-backend/internal/booking"
+	"web-chatbot-backend/internal/bookmarks"
+	"web-chatbot-backend/internal/botconfig"
+	"web-chatbot-backend/internal/changelog"
+	"web-chatbot-backend/internal/config"
+	"web-chatbot-backend/internal/deadletter"
+	"web-chatbot-backend/internal/degraded"
+	"web-chatbot-backend/internal/delivery"
+	"web-chatbot-backend/internal/domains"
+	"web-chatbot-backend/internal/events"
+	"web-chatbot-backend/internal/geoip"
+	"web-chatbot-backend/internal/greetings"
+	"web-chatbot-backend/internal/handoff"
+	"web-chatbot-backend/internal/health"
+	"web-chatbot-backend/internal/hooks"
+	"web-chatbot-backend/internal/httpclient"
+	"web-chatbot-backend/internal/jobs"
+	"web-chatbot-backend/internal/knowledge"
+	"web-chatbot-backend/internal/operators"
+	"web-chatbot-backend/internal/pagecontext"
+	"web-chatbot-backend/internal/queue"
+	"web-chatbot-backend/internal/ratelimit"
+	"web-chatbot-backend/internal/reminders"
+	"web-chatbot-backend/internal/replyparser"
+	"web-chatbot-backend/internal/requestid"
+	"web-chatbot-backend/internal/rich"
+	"web-chatbot-backend/internal/rules"
+	"web-chatbot-backend/internal/scripting"
+	"web-chatbot-backend/internal/session"
+	"web-chatbot-backend/internal/settings"
+	"web-chatbot-backend/internal/shopify"
+	"web-chatbot-backend/internal/store"
+	"web-chatbot-backend/internal/stripe"
+	"web-chatbot-backend/internal/ticketing"
+	"web-chatbot-backend/internal/topk"
+	"web-chatbot-backend/internal/totp"
+	"web-chatbot-backend/internal/tracing"
+	"web-chatbot-backend/internal/translate"
+	"web-chatbot-backend/internal/verify"
+	"web-chatbot-backend/internal/visitor"
+	"web-chatbot-backend/internal/wasm"
+	"web-chatbot-backend/internal/webpush"
 )
 
-// WebSocket clients manager
-type Client struct {
-	Conn *websocket.Conn
+// notifySession records a system message in the session transcript and
+// pushes it to the visitor if they are connected.
+func notifySession(id, text string) {
+	msg, err := sessions.AppendMessage(id, session.RoleSystem, text)
+	if err != nil {
+		log.Error().Str("session_id", id).Err(err).Msg("Error recording message")
+	}
+	if err := visitorHub.SendTo(id, fiber.Map{"type": "system", "message": text, "message_id": msg.ID}); err == nil {
+		sendUnread(id)
+	} else if err != errNotConnected {
+		log.Warn().Err(err).Msg("write error")
+	}
+}
+
+// sendUnread updates the widget's unread badge.
+func sendUnread(id string) {
+	n, err := sessions.Unread(id)
+	if err != nil {
+		return
+	}
+	if err := visitorHub.Post(id, fiber.Map{"type": "unread", "count": n}); err != nil && err != errNotConnected {
+		log.Warn().Err(err).Msg("write error")
+	}
+}
+
+// serverConfig is loaded and validated at startup, before anything is set
+// up from it; see internal/config.
+var serverConfig config.Config
+
+// Event bus and session state machine shared by all handlers
+var bus = events.NewBus()
+var sessions = session.NewManager(bus)
+
+var visitors *visitor.Store
+
+// Pinned conversations and bookmarked messages
+var reviewMarks *bookmarks.Store
+
+var bulkJobs = jobs.NewManager()
+
+// dataFile is path if it is set, otherwise name in the data directory.
+func dataFile(path, name string) string {
+	if path != "" {
+		return path
+	}
+	return filepath.Join(serverConfig.DataDir, name)
 }
 
-var clients = make(map[*websocket.Conn]bool)
+// deliveryConfig sets up the outbound event webhooks.
+func deliveryConfig() delivery.Config {
+	ev := serverConfig.Events
+	return delivery.Config{
+		Endpoints:      ev.URLs,
+		EventTypes:     ev.Types,
+		MaxAttempts:    ev.MaxAttempts,
+		InitialBackoff: ev.Backoff,
+		MaxBackoff:     ev.MaxBackoff,
+		Retention:      ev.Retention,
+	}
+}
+
+var deliveries *delivery.Dispatcher
+
+// Degraded mode answers while the upstream is down
+var degradedMode *degraded.Mode
+
+// Actions the bot can ask the backend to run
+var actionRegistry *actions.Registry
+
+// Fixed replies for off-topic or restricted queries
+var autoResponder *rules.Engine
+
+// Plugins and external hooks around message processing
+var pipelineHooks = hooks.NewRunner()
+
+// WebAssembly processors, reloaded when their files change
+var wasmHost *wasm.Host
+
+var locator geoip.Locator
+
+var pushSubscriptions *webpush.Store
+var pushSender *webpush.Sender
+
+// smtpConfig is the outgoing mail server for visitor and operator emails.
+func smtpConfig() actions.SMTPConfig {
+	m := serverConfig.SMTP
+	return actions.SMTPConfig{Addr: m.Addr, Username: m.Username, Password: m.Password, From: m.From}
+}
+
+var operatorAlerts *alerts.Mailer
+
+var agentPush *agentpush.Notifier
+
+// Synthetic upstream probes, and the health of the upstreams they and
+// live traffic report
+var upstreams *health.Monitor
+
+// idlePolicy is when idle sessions are warned and closed.
+func idlePolicy() session.IdlePolicy {
+	idle := serverConfig.Idle
+	return session.IdlePolicy{WarnAfter: idle.WarnAfter, CloseAfter: idle.CloseAfter, Grace: idle.Grace}
+}
+
+// conditional adds an ETag to GET responses and answers a matching
+// If-None-Match with 304 Not Modified, so polling clients skip unchanged
+// data.
+var conditional = etag.New()
+
+// limitBody rejects request bodies over n bytes, for endpoints that need
+// a tighter limit than the server-wide one.
+func limitBody(n int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if len(c.Body()) > n {
+			return c.Status(413).JSON(fiber.Map{"error": "Request body too large"})
+		}
+		return c.Next()
+	}
+}
+
+// resumeOrCreateSession reopens the session the client asked for if it is
+// still within its grace period, otherwise it starts a new one for tenant.
+// Test chats are never resumed by visitors.
+func resumeOrCreateSession(id, visitorID, tenant string) *session.Session {
+	if id != "" && !isTestSession(id) {
+		sess, err := sessions.Reopen(id, serverConfig.Idle.Grace)
+		if err == nil {
+			log.Info().Str("session_id", id).Msg("Resumed session")
+			return sess
+		}
+		log.Warn().Str("session_id", id).Err(err).Msg("Could not resume session")
+	}
+	sess := sessions.Create(visitorID)
+	if tenant != "" && tenant != defaultTenant {
+		if err := sessions.SetTenant(sess.ID, tenant); err != nil {
+			log.Error().Str("session_id", sess.ID).Err(err).Msg("Error recording tenant")
+		}
+		sess.Tenant = tenant
+	}
+	return sess
+}
+
+// Shown to banned visitors instead of connecting them to the bot
+const bannedMessage = "You are not allowed to use this chat."
 
 func handleWebSocket(c *websocket.Conn) {
-	// Register new client
-	clients[c] = true
+	// Oversized frames close the connection
+	c.SetReadLimit(int64(serverConfig.Server.WSReadLimit))
+
+	visitorID := c.Query("visitor_id")
+	var profile *visitor.Profile
+	if visitorID != "" {
+		var err error
+		profile, err = visitors.Touch(visitorID)
+		if err != nil {
+			log.Error().Str("visitor_id", visitorID).Err(err).Msg("Error updating visitor")
+		} else if profile.Banned {
+			log.Warn().Str("visitor_id", visitorID).Msg("Rejected banned visitor")
+			c.WriteJSON(fiber.Map{"error": bannedMessage})
+			c.Close()
+			return
+		}
+	}
+
+	tenant, _ := c.Locals("tenant").(string)
+	sess := resumeOrCreateSession(requestedSession(c.Query("session_id"), c.Query("resume_token")), visitorID, tenant)
+	sessions.SetChannel(sess.ID, store.ChannelWebSocket)
+	signIn(sess.ID, c.Locals("user"))
+	client := newClient(c, sess.ID)
+	ip, _ := c.Locals("ip").(string)
+	enrichSession(sess, ip, c.Headers("User-Agent"))
+
+	if visitorID != "" {
+		if p, err := visitors.RecordSession(visitorID, sess.ID); err != nil {
+			log.Error().Str("visitor_id", visitorID).Err(err).Msg("Error recording session")
+		} else {
+			profile = p
+		}
+	}
+
+	// Register new client, taking over from an earlier connection
+	if old := visitorHub.Register(client); old != nil {
+		old.Replace()
+	}
+	connectedAt := visitorConnected(sess.ID)
 
 	// Cleanup when the connection closes
 	defer func() {
-		delete(clients, c)
-		c.Close()
+		client.Close()
+		visitorDisconnected(sess.ID, connectedAt)
+		if current := visitorHub.Get(sess.ID); current != nil && current != client {
+			// A newer connection, e.g. from another tab or after a
+			// reconnect, took over the session and its calls
+			return
+		}
+		visitorHub.Unregister(client)
+		for _, call := range calls.EndAll(sess.ID, "disconnect") {
+			sendCall(call)
+		}
+		if err := sessions.Close(sess.ID, "disconnect"); err != nil {
+			log.Error().Str("session_id", sess.ID).Err(err).Msg("Error closing session")
+		}
 	}()
 
+	// Tell the client which session it is in so it can resume after a reconnect
+	if err := client.WriteJSON(fiber.Map{"type": "session", "session_id": sess.ID, "status": sess.Status}); err != nil {
+		log.Warn().Err(err).Msg("write error")
+		return
+	}
+
 	for {
 		// Read message from client
 		type Message struct {
-			Message string `json:"message"`
+			// Type is empty for chat messages, "read" when the widget
+			// has shown everything received so far, "delivered" when
+			// the message MessageID reached it, "location" when the
+			// visitor shares their position, or "history" to get the
+			// messages after After, see messageids.go and receipts.go.
+			Type         string `json:"type"`
+			Message      string `json:"message"`
+			QuickReplyID string `json:"quick_reply_id"`
+			ClientID     string `json:"client_id"`
+			After        string `json:"after"`
+			MessageID    string `json:"message_id"`
+
+			Latitude  float64 `json:"latitude"`
+			Longitude float64 `json:"longitude"`
+			Accuracy  float64 `json:"accuracy"`
+
+			// Signaling for calls with an agent, see signalFrame
+			CallID   string          `json:"call_id"`
+			Accepted bool            `json:"accepted"`
+			Signal   json.RawMessage `json:"signal"`
+
+			// Traceparent is the W3C trace context of the message, if the
+			// widget traces its requests
+			Traceparent string `json:"traceparent"`
+
+			// Context describes the page the message was sent from
+			Context *pagecontext.Context `json:"context"`
 		}
 		var msg Message
 		if err := c.ReadJSON(&msg); err != nil {
-			log.Println("read error:", err)
+			log.Debug().Err(err).Msg("read error")
 			break
 		}
+		received := time.Now()
 
-		log.Printf("Received message: %s", msg.Message)
+		if msg.Type == "history" {
+			if err := sendMissed(client, msg.After); err != nil {
+				log.Warn().Err(err).Msg("write error")
+				break
+			}
+			continue
+		}
+		if msg.Type == "delivered" {
+			recordReceipt(sess.ID, msg.MessageID, session.ReceiptDelivered)
+			continue
+		}
 
-		// Forward message to n8n webhook
-		webhookURL := "https://n8n.tspbrand.id/webhook/web-chatbot"
-		payload, _ := json.Marshal(map[string]string{"message": msg.Message})
+		// Sending a message means the visitor has read the conversation
+		if err := sessions.MarkRead(sess.ID, time.Now()); err != nil {
+			log.Error().Str("session_id", sess.ID).Err(err).Msg("Error marking session read")
+		}
+		if msg.Type == "read" {
+			recordReceipt(sess.ID, msg.MessageID, session.ReceiptRead)
+			sendUnread(sess.ID)
+			continue
+		}
+		if strings.HasPrefix(msg.Type, "rtc_") {
+			handleVisitorSignal(client, signalFrame{Type: msg.Type, CallID: msg.CallID, Accepted: msg.Accepted, Signal: msg.Signal})
+			continue
+		}
+		if msg.Type == "location" {
+			loc := session.SharedLocation{Latitude: msg.Latitude, Longitude: msg.Longitude, Accuracy: msg.Accuracy}
+			if err := sessions.ShareLocation(sess.ID, loc); err != nil {
+				client.WriteJSON(fiber.Map{"type": "system", "message": "That location could not be shared."})
+				continue
+			}
+			// The workflow sees the position in shared_location
+			if msg.Message == "" {
+				msg.Message = fmt.Sprintf("Shared location: %.6f, %.6f", msg.Latitude, msg.Longitude)
+			}
+		}
 
-		resp, err := http.Post(webhookURL, "application/json", bytes.NewBuffer(payload))
-		if err != nil {
-			log.Printf("Error contacting webhook: %v", err)
-			c.WriteJSON(fiber.Map{"reply": "Sorry, I couldn't process your message. Please try again later."})
+		if state, ok := limiterFor(tenant).Allow(rateLimitKey(visitorID, ip)); !ok {
+			client.WriteJSON(fiber.Map{"error": rateLimitedMessage, "retry_after": retryAfterSeconds(state)})
 			continue
 		}
 
-		// First try to read as plain text
-		bodyBytes, err := io.ReadAll(resp.Body)
-		resp.Body.Close()
+		// Each message is a trace of its own, joining the widget's if it
+		// sent a traceparent, and gets a request ID of its own
+		ctx := requestid.With(withTenant(context.Background(), tenant), requestid.New())
+		ctx = withTurnClock(ctx, received)
+		ctx, err := acceptPageContext(ctx, msg.Context, c.Headers("User-Agent"))
 		if err != nil {
-			log.Printf("Error reading response body: %v", err)
-			c.WriteJSON(fiber.Map{"reply": "Sorry, I couldn't read the response from the server."})
+			client.WriteJSON(fiber.Map{"error": "Invalid context: " + err.Error()})
 			continue
 		}
+		ctx, fresh := acceptMessage(ctx, client, msg.ClientID)
+		if !fresh {
+			continue
+		}
+		keepPageContext(ctx, sess.ID)
+		log.Ctx(ctx).Debug().Str("text", msg.Message).Msg("Received message")
+		ctx = tracing.Extract(ctx, http.Header{"Traceparent": {msg.Traceparent}})
+		ctx, span := tracing.Start(ctx, "websocket message", trace.SpanKindServer, attribute.String("chatbot.session_id", sess.ID))
+		err = answerVisitor(ctx, client, profile, msg.Message, msg.QuickReplyID)
+		tracing.End(span, err)
+		if err != nil {
+			log.Ctx(ctx).Warn().Err(err).Msg("write error")
+			break
+		}
+	}
+}
 
-		log.Printf("Raw response body: %s", string(bodyBytes))
-
-		// Determine response type and extract reply
-		var reply string
-		
-		// Check if the response starts with common text response patterns
-		responseText := string(bodyBytes)
-		if strings.HasPrefix(responseText, "H") || strings.HasPrefix(responseText, "S") {
-			// Likely a plain text response in Indonesian (Halo, Selamat, etc.)
-			log.Printf("Detected plain text response starting with H/S, treating as plain text")
-			reply = responseText
-		} else if strings.TrimSpace(responseText) == "" {
-			// Empty response
-			log.Printf("Empty response received")
-			reply = "No response received from the server."
-		} else {
-			// Try to parse as JSON
-			var n8nResp map[string]interface{}
-			if err := json.Unmarshal(bodyBytes, &n8nResp); err == nil {
-				// Successfully parsed as JSON
-				log.Printf("Parsed JSON response: %v", n8nResp)
-				
-				// Check for error response
-				if code, ok := n8nResp["code"]; ok {
-					if code == float64(404) {
-						if msg, ok := n8nResp["message"].(string); ok {
-							reply = fmt.Sprintf("Error: %s", msg)
-						} else {
-							reply = "Error: Webhook not found or not registered."
-						}
-					}
-				} else if replyVal, ok := n8nResp["reply"]; ok {
-					// Extract reply from JSON
-					switch v := replyVal.(type) {
-					case string:
-						reply = v
-					case float64, int, int64, float32: // Handle numeric types
-						reply = fmt.Sprintf("%v", v)
-					default:
-						reply = fmt.Sprintf("%v", v)
-					}
-				} else {
-					// If no "reply" field, check if this is an error message
-					reply = responseText
+// answerVisitor runs a visitor message through the bot, or passes it on to
+// the agent handling the conversation, and sends whatever comes back to the
+// visitor's connection. It returns an error only if the reply could not be
+// written.
+func answerVisitor(ctx context.Context, client *Client, profile *visitor.Profile, message, quickReplyID string) error {
+	turnsInFlight.Add(1)
+	defer turnsInFlight.Add(-1)
+	// The bot call is abandoned if the connection goes away meanwhile
+	ctx, cancel := client.bind(ctx)
+	defer cancel()
+	ctx, typing := withTyping(ctx, client)
+	id := client.SessionID
+	if err := sessions.Touch(id); err != nil {
+		log.Ctx(ctx).Error().Str("session_id", id).Err(err).Msg("Error touching session")
+	}
+	if err := sessions.Activate(id); err != nil {
+		log.Ctx(ctx).Error().Str("session_id", id).Err(err).Msg("Error activating session")
+	}
+	notifyAgentOfReply(id, message)
+
+	// Once an agent has the conversation the bot stays out of it
+	if current, err := sessions.Get(id); err == nil && current.Status == session.StatusWithAgent {
+		relayToAgent(ctx, current, profile, message)
+		countMessage(ctx, nil)
+		return nil
+	}
+
+	// Forward message to n8n webhook
+	var out botReply
+	var err error
+	if quickReplyID != "" {
+		out, err = respondQuickReply(ctx, id, profile, message, quickReplyID)
+	} else {
+		out, err = respond(ctx, id, profile, message)
+	}
+	countMessage(ctx, err)
+	// Every frame answering the message carries its request ID
+	requestID := requestid.From(ctx)
+	if err != nil {
+		client.WriteJSON(fiber.Map{"reply": apology(err), "request_id": requestID})
+		typing.stop()
+		return nil
+	}
+	if out.System != "" {
+		client.WriteJSON(fiber.Map{"type": "system", "message": out.System, "request_id": requestID})
+	}
+	for _, result := range out.Actions {
+		client.WriteJSON(fiber.Map{"type": "action_result", "result": result, "request_id": requestID})
+	}
+	log.Ctx(ctx).Debug().Str("reply", out.Reply).Msg("Sending reply")
+
+	// Send response back to client
+	_, span := tracing.Start(ctx, "reply.write", trace.SpanKindInternal, attribute.String("chatbot.session_id", id))
+	frame := out.frame()
+	frame["request_id"] = requestID
+	err = client.WriteJSON(frame)
+	tracing.End(span, err)
+	if err != nil {
+		return err
+	}
+	typing.stop()
+	recordTiming(ctx, id)
+	sendUnread(id)
+	return nil
+}
+
+func main() {
+	var err error
+	serverConfig, err = config.Load(os.Getenv("CHATBOT_CONFIG_FILE"), os.Getenv)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid configuration")
+	}
+	setupLogging()
+	if len(os.Args) > 1 && os.Args[1] == "backup" {
+		runBackupCommand(os.Args[2:])
+		return
+	}
+	chatMetrics = newChatMetrics()
+	supervisor = handoff.Connect(importHandoff)
+	if serverConfig.Handoff.Supervisor && supervisor == nil {
+		runSupervisor(serverConfig)
+		return
+	}
+	webhookURL = serverConfig.WebhookURL
+	webhookSecret = serverConfig.WebhookSecret
+
+	// Load everything before starting any background work. What fails to
+	// load is reported by runPreflight along with every other problem.
+	up := serverConfig.Upstream
+	tlsConfig, err := httpclient.LoadTLS(httpclient.TLSFiles{
+		CAFile:             up.CAFile,
+		CertFile:           up.CertFile,
+		KeyFile:            up.KeyFile,
+		InsecureSkipVerify: up.InsecureSkipVerify,
+	})
+	loaded("upstream_tls", err)
+	if up.InsecureSkipVerify {
+		log.Warn().Msg("CHATBOT_UPSTREAM_INSECURE_SKIP_VERIFY is set; upstream TLS certificates will not be verified")
+	}
+	upstreamClient = httpclient.New(httpclient.Config{
+		ConnectTimeout:      up.ConnectTimeout,
+		ReadTimeout:         up.ReadTimeout,
+		Timeout:             up.Timeout,
+		MaxIdleConns:        up.MaxIdleConns,
+		MaxIdleConnsPerHost: up.MaxIdleConnsPerHost,
+		IdleConnTimeout:     up.IdleConnTimeout,
+		TLS:                 tlsConfig,
+	})
+	botProvider, err = newBotProvider(serverConfig)
+	loaded("bot_provider", err)
+	botProviderName = serverConfig.Provider
+	upstreams = health.NewMonitor(bus, serverConfig.Probe.FailureThreshold, serverConfig.Probe.Timeout)
+	messageLimiter = ratelimit.New(serverConfig.RateLimit.Limit, serverConfig.RateLimit.Window, serverConfig.RateLimit.DailyQuota)
+	runtimeSettings, err = settings.NewStore(filepath.Join(serverConfig.DataDir, "settings.json"))
+	if loaded("runtime_settings", err) {
+		if url := runtimeSettings.Get().WebhookURL; url != "" && botProviderName != "openai" {
+			if loaded("runtime_webhook_url", useWebhookURL(url)) {
+				log.Info().Str("webhook_url", url).Msg("Using the webhook URL set at runtime")
+			}
+		}
+	}
+	loaded("workflow_routes", loadRoutes(serverConfig))
+	replyParser, err = replyparser.New(replyparser.Config{
+		Fields:   serverConfig.Reply.Fields,
+		Fallback: replyparser.Fallback(serverConfig.Reply.Fallback),
+	})
+	loaded("reply_extraction", err)
+	visitorTokens, err = newVisitorTokens()
+	loaded("visitor_tokens", err)
+	adminOperators, err = operators.NewStore(filepath.Join(serverConfig.DataDir, "operators.json"))
+	loaded("operators", err)
+	twoFactor, err = totp.NewStore(filepath.Join(serverConfig.DataDir, "two_factor.json"), serverConfig.TwoFactor.Issuer)
+	loaded("two_factor", err)
+	customDomains, err = domains.NewStore(filepath.Join(serverConfig.DataDir, "domains.json"))
+	loaded("custom_domains", err)
+	shareLinks, err = newShareLinks()
+	loaded("share_links", err)
+	exportAnonymizer, err = newExportAnonymizer()
+	loaded("export_anonymizer", err)
+
+	visitors, err = visitor.NewStore(filepath.Join(serverConfig.DataDir, "visitors.json"))
+	loaded("visitors", err)
+	reviewMarks, err = bookmarks.NewStore(filepath.Join(serverConfig.DataDir, "bookmarks.json"))
+	loaded("bookmarks", err)
+	messageAnnotations, err = annotations.NewStore(filepath.Join(serverConfig.DataDir, "annotations.json"))
+	loaded("annotations", err)
+	knowledgeBase, err = knowledge.NewStore(filepath.Join(serverConfig.DataDir, "knowledge.json"))
+	loaded("knowledge_base", err)
+	agentRoster, err = agents.NewStore(filepath.Join(serverConfig.DataDir, "agents.json"))
+	loaded("agents", err)
+	deliveries, err = delivery.NewDispatcher(deliveryConfig(), filepath.Join(serverConfig.DataDir, "deliveries.json"))
+	loaded("event_webhook_deliveries", err)
+	if serverConfig.Transcripts.WebhookURL != "" {
+		transcriptDeliveries, err = delivery.NewDispatcher(transcriptDeliveryConfig(), filepath.Join(serverConfig.DataDir, "transcript_deliveries.json"))
+		loaded("transcript_deliveries", err)
+	}
+	dm := serverConfig.Degraded
+	degradedMode, err = degraded.New(degraded.Messages{Banner: dm.Banner, NoAnswer: dm.NoAnswer, EmailThanks: dm.EmailThanks},
+		dataFile(dm.CannedAnswersFile, "canned_answers.json"),
+		filepath.Join(serverConfig.DataDir, "followups.json"))
+	loaded("canned_answers", err)
+	autoResponder, err = rules.NewEngine(filepath.Join(serverConfig.DataDir, "rules.json"))
+	loaded("auto_responder_rules", err)
+	greetingRules, err = greetings.NewSet(filepath.Join(serverConfig.DataDir, "greetings.json"))
+	loaded("greetings", err)
+	botConfig, err = botconfig.NewStore(filepath.Join(serverConfig.DataDir, "bot_config.json"))
+	loaded("bot_config", err)
+	configChanges, err = changelog.NewLog(filepath.Join(serverConfig.DataDir, "config_changes.json"))
+	loaded("config_changes", err)
+	actionRegistry = actions.NewRegistry(serverConfig.Actions.Timeout)
+	actionRegistry.Client = upstreamClient
+	loaded("actions_file", actionRegistry.LoadFile(dataFile(serverConfig.Actions.File, "actions.json")))
+	actionRegistry.Register("escalate", actions.HandlerFunc(escalateAction))
+	deadLetters, err = deadletter.New(filepath.Join(serverConfig.DataDir, "dead_letters.json"), serverConfig.DeadLetterMax)
+	loaded("dead_letters", err)
+	visitorReminders, err = reminders.NewStore(filepath.Join(serverConfig.DataDir, "reminders.json"))
+	if loaded("reminders", err) {
+		visitorReminders.RetryInterval = serverConfig.Reminders.Retry
+		visitorReminders.Expiry = serverConfig.Reminders.Expiry
+	}
+	actionRegistry.Register("remind", actions.HandlerFunc(remindAction))
+	verificationCodes = verify.NewCodes()
+	verificationCodes.TTL, verificationCodes.MaxAttempts = serverConfig.Verify.CodeTTL, serverConfig.Verify.MaxAttempts
+	actionRegistry.Register("verify_email", actions.HandlerFunc(verifyEmailAction))
+	scheduler, err := booking.New(booking.Config{
+		Provider:          serverConfig.Booking.Provider,
+		CalcomAPIKey:      serverConfig.Booking.CalcomAPIKey,
+		CalcomEventTypeID: serverConfig.Booking.CalcomEventTypeID,
+		CalendlyToken:     serverConfig.Booking.CalendlyToken,
+		CalendlyEventType: serverConfig.Booking.CalendlyEventType,
+	})
+	if loaded("booking", err) && scheduler != nil {
+		registerBookingActions(actionRegistry, scheduler)
+	}
+	shop, err := shopify.New(serverConfig.Shopify.Domain, serverConfig.Shopify.StorefrontToken, serverConfig.Shopify.APIVersion)
+	if loaded("shopify", err) && shop != nil {
+		registerShopActions(actionRegistry, shop)
+	}
+	if wp := serverConfig.WebPush; wp.VAPIDPrivateKey != "" {
+		pushSender, err = webpush.NewSender(wp.VAPIDPublicKey, wp.VAPIDPrivateKey, wp.VAPIDSubject)
+		loaded("web_push", err)
+		pushSubscriptions, err = webpush.NewStore(filepath.Join(serverConfig.DataDir, "push_subscriptions.json"))
+		loaded("push_subscriptions", err)
+	}
+	loaded("cache", openCache(context.Background()))
+	translator, err = translate.New(translate.Config{
+		Provider: serverConfig.Translation.Provider,
+		APIKey:   serverConfig.Translation.APIKey,
+		URL:      serverConfig.Translation.URL,
+	})
+	loaded("translation", err)
+	locator, err = geoip.New(geoip.Config{
+		Provider:          serverConfig.GeoIP.Provider,
+		MaxMindAccountID:  serverConfig.GeoIP.MaxMindAccountID,
+		MaxMindLicenseKey: serverConfig.GeoIP.MaxMindLicenseKey,
+	})
+	loaded("geoip", err)
+	payments, err := stripe.New(serverConfig.Stripe.SecretKey, serverConfig.Stripe.SuccessURL, serverConfig.Stripe.CancelURL)
+	if loaded("stripe", err) && payments != nil {
+		registerPaymentActions(actionRegistry, payments)
+	}
+	loaded("hooks_file", pipelineHooks.LoadFile(dataFile(serverConfig.Hooks.File, "hooks.json")))
+	scripts, err := scripting.LoadDir(dataFile(serverConfig.Scripts.Dir, "scripts"), scripting.Limits{
+		Timeout:         serverConfig.Scripts.Timeout,
+		CallStackSize:   serverConfig.Scripts.MaxCallDepth,
+		RegistryMaxSize: serverConfig.Scripts.MaxStack,
+	}, pipelineHooks)
+	loaded("scripts", err)
+	for _, script := range scripts {
+		log.Info().Str("script", script.Name).Interface("points", script.Points()).Msg("Loaded script")
+	}
+	wasmHost, err = wasm.NewHost(context.Background(), dataFile(serverConfig.Wasm.Dir, "plugins"), wasm.Limits{
+		Timeout:     serverConfig.Wasm.Timeout,
+		MemoryPages: uint32(serverConfig.Wasm.MemoryPages),
+	})
+	if loaded("wasm_modules", err) {
+		wasmHost.Register(pipelineHooks)
+	}
+	if serverConfig.Assignment.Strategy != "" {
+		assigner, err = assign.New(serverConfig.Assignment.Strategy)
+		loaded("assignment", err)
+	}
+	waitEstimator = queue.NewEstimator(serverConfig.Queue.HandleTimeWindow, serverConfig.Queue.DefaultHandleTime)
+	connector, err := ticketing.New(ticketingConfig())
+	loaded("ticketing", err)
+	transcriptArchive = newTranscriptArchive()
+	topQuestions = topk.NewTracker(topk.Config{
+		K:     serverConfig.TopQuestions.K,
+		Width: serverConfig.TopQuestions.Width,
+		Depth: serverConfig.TopQuestions.Depth,
+	})
+
+	// Keep every message in the database, see messages.go
+	if serverConfig.Store.Driver != "" {
+		if loaded("message_store", openMessageStore(context.Background())) {
+			log.Info().Str("driver", serverConfig.Store.Driver).Msg("Storing messages")
+			loaded("tenants", startTenantRegistry(context.Background()))
+		}
+	}
+	loaded("api_keys", openAPIKeys(context.Background()))
+
+	// Reach WebSocket connections held by other instances
+	bp, err := connectBackplane(context.Background())
+	if loaded("backplane", err) && bp != nil {
+		frameBackplane = bp
+	}
+
+	// Push queued conversations and visitor replies to the agent app
+	senders := make(map[string]agentpush.Sender)
+	ap := serverConfig.AgentPush
+	if ap.FCMCredentialsFile != "" {
+		fcm, err := agentpush.NewFCMSender(ap.FCMCredentialsFile)
+		if loaded("fcm", err) {
+			senders[agentpush.FCM] = fcm
+		}
+	}
+	if ap.APNsKeyFile != "" {
+		apns, err := agentpush.NewAPNsSender(ap.APNsKeyFile, ap.APNsKeyID, ap.APNsTeamID, ap.APNsTopic, ap.APNsSandbox)
+		if loaded("apns", err) {
+			senders[agentpush.APNs] = apns
+		}
+	}
+	if len(senders) > 0 {
+		agentPush, err = agentpush.NewNotifier(filepath.Join(serverConfig.DataDir, "agent_devices.json"), senders)
+		loaded("agent_devices", err)
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Endpoint:    serverConfig.Telemetry.Endpoint,
+		ServiceName: serverConfig.Telemetry.ServiceName,
+		SampleRatio: serverConfig.Telemetry.SampleRatio,
+	})
+	loaded("tracing", err)
+
+	// Check everything loaded above before taking traffic
+	runPreflight()
+
+	// Log every session status change
+	bus.Subscribe(func(e events.Event) {
+		log.Info().Str("session_id", e.SessionID).Str("event", e.Type).Interface("data", e.Data).Msg("Event")
+	})
+
+	// Warn idle visitors and disconnect them once their session is closed
+	bus.Subscribe(func(e events.Event) {
+		switch e.Type {
+		case "session_idle_warning":
+			if err := visitorHub.Post(e.SessionID, fiber.Map{"reply": serverConfig.Idle.WarningMessage, "type": "idle_warning"}); err != nil && err != errNotConnected {
+				log.Warn().Err(err).Msg("write error")
+			}
+		case "session_closed":
+			if e.Data["reason"] == "idle" {
+				visitorHub.Post(e.SessionID, fiber.Map{"type": "session_closed", "session_id": e.SessionID})
+				if client := visitorHub.Get(e.SessionID); client != nil {
+					client.Close()
 				}
-			} else {
-				// Not valid JSON, treat as plain text
-				log.Printf("Response is not JSON, treating as plain text: %v", err)
-				reply = responseText
 			}
+		case "session_" + string(session.StatusWithAgent), "session_" + string(session.StatusActive):
+			// Let the widget know whether a bot or a human is answering
+			visitorHub.Post(e.SessionID, fiber.Map{"type": "session", "session_id": e.SessionID, "status": e.Data["to"]})
 		}
+	})
+	go sessions.RunIdleReaper(context.Background(), idlePolicy(), 30*time.Second)
 
-		log.Printf("Sending reply: %s", reply)
+	if serverConfig.Retention.Sessions > 0 {
+		go runRetention(context.Background())
+	}
 
-		// Send response back to client
-		if err := c.WriteJSON(fiber.Map{"reply": reply}); err != nil {
-			log.Println("write error:", err)
-			break
+	// Let hooks observe closing sessions
+	bus.Subscribe(func(e events.Event) {
+		if e.Type != "session_closed" {
+			return
+		}
+		sess, err := sessions.Get(e.SessionID)
+		if err != nil {
+			return
 		}
+		hc := &hooks.Context{Point: hooks.OnSessionClose, SessionID: sess.ID, VisitorID: sess.VisitorID}
+		go func() {
+			if err := pipelineHooks.Run(context.Background(), hc); err != nil {
+				log.Error().Str("session_id", sess.ID).Err(err).Msg("on_session_close hooks failed")
+			}
+		}()
+	})
+	go wasmHost.Watch(context.Background(), serverConfig.Wasm.ReloadInterval)
+
+	// Drop degraded-mode state once a session can no longer be resumed
+	bus.Subscribe(func(e events.Event) {
+		if e.Type == "session_archived" || e.Type == "session_deleted" {
+			degradedMode.Forget(e.SessionID)
+		}
+		if e.Type == "session_deleted" {
+			calls.Forget(e.SessionID)
+		}
+	})
+	bus.Subscribe(recordVoiceCall)
+
+	// Route queued conversations to agents automatically
+	if assigner != nil {
+		go runAssignment(context.Background(), 30*time.Second)
 	}
-}
 
-func main() {
-	app := fiber.New()
+	// Keep waiting visitors informed of their place in the agent queue
+	bus.Subscribe(waitEstimator.Observe)
+	if serverConfig.Queue.UpdateInterval > 0 {
+		go runQueueUpdates(context.Background(), serverConfig.Queue.UpdateInterval)
+	}
+
+	// Hand unclaimed escalations to the helpdesk
+	if connector != nil {
+		go runEscalationExporter(context.Background(), connector, serverConfig.Ticketing.EscalationTimeout, 30*time.Second)
+	}
+
+	// Email operators about the agent queue
+	if len(serverConfig.Alerts.OperatorEmails) > 0 {
+		operatorAlerts = &alerts.Mailer{
+			Send: func(subject, body string) error {
+				return actions.SendMail(smtpConfig(), serverConfig.Alerts.OperatorEmails, subject, body)
+			},
+			Digest: serverConfig.Alerts.DigestInterval,
+		}
+		bus.Subscribe(alertOperators)
+		bus.Subscribe(alertNotRegistered)
+		go operatorAlerts.Run(context.Background())
+	}
+
+	// Track first response and resolution targets of escalations
+	startSLATimers()
+
+	// Forget visitors whose rate limits have reset
+	go messageLimiter.Run(context.Background(), 10*time.Minute)
+
+	if serverConfig.Store.Driver == store.SQLite && serverConfig.Store.CheckpointInterval > 0 {
+		go runStoreCheckpoints(context.Background(), serverConfig.Store.CheckpointInterval)
+	}
+
+	if frameBackplane != nil {
+		go frameBackplane.Run(context.Background(), deliverFromBackplane)
+		log.Info().Str("backplane", serverConfig.Backplane.Kind).Str("instance", frameBackplane.ID()).Msg("Joined backplane")
+	}
+
+	// Feed live stats to dashboards on the admin stream
+	go runDashboardStream(context.Background(), serverConfig.StreamInterval)
+
+	if serverConfig.TopQuestions.K > 0 && serverConfig.TopQuestions.Decay > 0 {
+		go runTopQuestionsDecay(context.Background(), serverConfig.TopQuestions.Decay)
+	}
+
+	if agentPush != nil {
+		bus.Subscribe(notifyAgentsOfQueue)
+	}
+
+	// Forward events to the outbound event webhooks
+	bus.Subscribe(deliveries.Enqueue)
+	go deliveries.Run(context.Background(), time.Second)
+
+	// Post transcripts of closed sessions to the transcript webhook
+	if transcriptDeliveries != nil {
+		bus.Subscribe(sendTranscript)
+		go transcriptDeliveries.Run(context.Background(), time.Second)
+	}
+
+	// Send reminders as they fall due
+	if serverConfig.JobQueue.Enabled {
+		startJobQueue(context.Background())
+	} else {
+		go visitorReminders.Run(context.Background(), serverConfig.Reminders.Interval, deliverReminder)
+	}
+
+	registerBotProbe()
+	if serverConfig.Probe.Interval > 0 {
+		go upstreams.Run(context.Background(), serverConfig.Probe.Interval)
+	}
+
+	defer shutdownTracing(context.Background())
+
+	app := fiber.New(fiber.Config{
+		// Behind a load balancer, take the visitor IP from e.g. X-Forwarded-For
+		ProxyHeader:  serverConfig.Server.ProxyHeader,
+		ReadTimeout:  serverConfig.Server.ReadTimeout,
+		WriteTimeout: serverConfig.Server.WriteTimeout,
+		IdleTimeout:  serverConfig.Server.IdleTimeout,
+		BodyLimit:    serverConfig.Server.BodyLimit,
+		// Route params and query values are kept in stores and maps, so
+		// they must not alias Fiber's reused request buffers
+		Immutable: true,
+	})
 
 	// Enable CORS
 	app.Use(cors.New(cors.Config{
-		AllowOrigins: "http://localhost:4321", // Astro default port
-		AllowHeaders: "Origin, Content-Type, Accept",
+		AllowOrigins:     serverConfig.CORSOrigins(),
+		AllowOriginsFunc: tenantOrigin,
+		AllowHeaders:     "Origin, Content-Type, Accept, Authorization, If-None-Match, Traceparent, Tracestate, X-Request-ID",
+		ExposeHeaders:    "ETag, X-Request-ID",
 	}))
+	app.Use(assignRequestID, traceRequests, resolveTenant)
 
-	app.Post("/chat", func(c *fiber.Ctx) error {
-		var body map[string]string
+	app.Post("/chat", limitBody(serverConfig.Server.ChatBodyLimit), requireCaller(apikeys.ScopeChat), func(c *fiber.Ctx) error {
+		c.SetUserContext(withTurnClock(c.UserContext(), time.Now()))
+		var body struct {
+			Message      string `json:"message"`
+			VisitorID    string `json:"visitor_id"`
+			SessionID    string `json:"session_id"`
+			QuickReplyID string `json:"quick_reply_id"`
+			// Context describes the page the message was sent from
+			Context *pagecontext.Context `json:"context"`
+		}
 		if err := c.BodyParser(&body); err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
 		}
+		ctx, err := acceptPageContext(c.UserContext(), body.Context, c.Get("User-Agent"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid context: " + err.Error()})
+		}
+		c.SetUserContext(ctx)
 
-		log.Printf("Received HTTP message: %s", body["message"])
+		state, ok := limiterFor(tenantFrom(c.UserContext())).Allow(rateLimitKey(body.VisitorID, c.IP()))
+		setRateLimitHeaders(c, state)
+		if !ok {
+			retryAfter := retryAfterSeconds(state)
+			c.Set("Retry-After", strconv.Itoa(retryAfter))
+			return c.Status(429).JSON(fiber.Map{"error": rateLimitedMessage, "retry_after": retryAfter})
+		}
 
-		// Forward message to webhook n8n
-		webhookURL := "https://n8n.tspbrand.id/webhook/web-chatbot"
-		payload, _ := json.Marshal(map[string]string{"message": body["message"]})
+		log.Ctx(c.UserContext()).Debug().Str("text", body.Message).Msg("Received HTTP message")
 
-		resp, err := http.Post(webhookURL, "application/json", bytes.NewBuffer(payload))
-		if err != nil {
-			log.Printf("Error contacting webhook: %v", err)
-			return c.Status(500).JSON(fiber.Map{"reply": "Sorry, I couldn't process your message. Please try again later."})
+		var profile *visitor.Profile
+		if visitorID := body.VisitorID; visitorID != "" {
+			var err error
+			profile, err = visitors.Touch(visitorID)
+			if err != nil {
+				log.Ctx(c.UserContext()).Error().Str("visitor_id", visitorID).Err(err).Msg("Error updating visitor")
+			} else if profile.Banned {
+				return c.Status(403).JSON(fiber.Map{"error": bannedMessage})
+			}
 		}
-		defer resp.Body.Close()
 
-		// First try to read as plain text
-		bodyBytes, err := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			log.Printf("Error reading response body: %v", err)
-			return c.Status(500).JSON(fiber.Map{"reply": "Sorry, I couldn't read the response from the server."})
-		}
-
-		log.Printf("Raw HTTP response body: %s", string(bodyBytes))
-
-		// Determine response type and extract reply
-		var reply string
-		
-		// Check if the response starts with common text response patterns
-		responseText := string(bodyBytes)
-		if strings.HasPrefix(responseText, "H") || strings.HasPrefix(responseText, "S") {
-			// Likely a plain text response in Indonesian (Halo, Selamat, etc.)
-			log.Printf("Detected plain text response starting with H/S, treating as plain text")
-			reply = responseText
-		} else if strings.TrimSpace(responseText) == "" {
-			// Empty response
-			log.Printf("Empty response received")
-			reply = "No response received from the server."
-		} else {
-			// Try to parse as JSON
-			var n8nResp map[string]interface{}
-			if err := json.Unmarshal(bodyBytes, &n8nResp); err == nil {
-				// Successfully parsed as JSON
-				log.Printf("Parsed HTTP JSON response: %v", n8nResp)
-				
-				// Check for error response
-				if code, ok := n8nResp["code"]; ok {
-					if code == float64(404) {
-						if msg, ok := n8nResp["message"].(string); ok {
-							reply = fmt.Sprintf("Error: %s", msg)
-						} else {
-							reply = "Error: Webhook not found or not registered."
-						}
-					}
-				} else if replyVal, ok := n8nResp["reply"]; ok {
-					// Extract reply from JSON
-					switch v := replyVal.(type) {
-					case string:
-						reply = v
-					case float64, int, int64, float32: // Handle numeric types
-						reply = fmt.Sprintf("%v", v)
-					default:
-						reply = fmt.Sprintf("%v", v)
-					}
-				} else {
-					// If no "reply" field, check if this is an error message
-					reply = responseText
+		// Messages with a session_id continue that conversation, e.g. when
+		// the widget falls back from a dropped WebSocket; without one they
+		// are answered on their own
+		conversation := ""
+		if id := body.SessionID; id != "" {
+			if existing, err := sessions.Get(id); err == nil && !ownsSession(existing, body.VisitorID, c.Locals("user")) {
+				return c.Status(403).JSON(fiber.Map{"error": "Session belongs to another visitor"})
+			}
+			sess := resumeOrCreateSession(id, body.VisitorID, tenantFrom(c.UserContext()))
+			conversation = sess.ID
+			signIn(sess.ID, c.Locals("user"))
+			keepPageContext(c.UserContext(), sess.ID)
+			if sess.ID != id && profile != nil {
+				if _, err := visitors.RecordSession(profile.ID, sess.ID); err != nil {
+					log.Ctx(c.UserContext()).Error().Str("visitor_id", profile.ID).Err(err).Msg("Error recording session")
 				}
-			} else {
-				// Not valid JSON, treat as plain text
-				log.Printf("HTTP response is not JSON, treating as plain text: %v", err)
-				reply = responseText
 			}
+			// Visitors on an event stream get the reply there
+			if stream := streamClient(sess.ID); stream != nil {
+				go answerOnStream(c.UserContext(), stream, profile, body.Message, body.QuickReplyID)
+				return c.Status(202).JSON(fiber.Map{"session_id": sess.ID, "status": "accepted"})
+			}
+			sessions.SetChannel(sess.ID, store.ChannelHTTP)
+			if err := sessions.Touch(sess.ID); err != nil {
+				log.Ctx(c.UserContext()).Error().Str("session_id", sess.ID).Err(err).Msg("Error touching session")
+			}
+			if err := sessions.Activate(sess.ID); err != nil {
+				log.Ctx(c.UserContext()).Error().Str("session_id", sess.ID).Err(err).Msg("Error activating session")
+			}
+			notifyAgentOfReply(sess.ID, body.Message)
+
+			// Agent replies arrive over the WebSocket, never in this response
+			if current, err := sessions.Get(sess.ID); err == nil && current.Status == session.StatusWithAgent {
+				relayToAgent(c.UserContext(), current, profile, body.Message)
+				countMessage(c.UserContext(), nil)
+				return c.Status(202).JSON(fiber.Map{"session_id": sess.ID, "status": current.Status})
+			}
+		}
+
+		// Forward message to webhook n8n
+		out, err := respond(c.UserContext(), conversation, profile, body.Message)
+		countMessage(c.UserContext(), err)
+		if err != nil {
+			resp := fiber.Map{"reply": apology(err)}
+			if conversation != "" {
+				resp["session_id"] = conversation
+			}
+			return c.Status(500).JSON(resp)
 		}
 
-		log.Printf("Sending HTTP reply: %s", reply)
+		log.Ctx(c.UserContext()).Debug().Str("reply", out.Reply).Msg("Sending HTTP reply")
 
-		return c.JSON(fiber.Map{"reply": reply})
+		resp := out.frame()
+		if conversation != "" {
+			resp["session_id"] = conversation
+		}
+		if out.System != "" {
+			resp["system"] = out.System
+		}
+		if len(out.Actions) > 0 {
+			resp["actions"] = out.Actions
+		}
+		recordTiming(c.UserContext(), conversation)
+		return c.JSON(resp)
 	})
 
-		// WebSocket setup
+	registerLimitRoutes(app)
+	registerMetricsRoutes(app)
+	registerBatchRoutes(app)
+	registerReminderRoutes(app)
+	registerMergeRoutes(app)
+	registerInjectRoutes(app)
+	registerShareRoutes(app)
+	registerPreviewRoutes(app)
+	registerMessageRoutes(app)
+	registerAdminRoutes(app)
+	if pushSender != nil {
+		registerPushRoutes(app)
+	}
+
+	if serverConfig.Stripe.WebhookSecret != "" {
+		app.Post("/webhooks/stripe", handleStripeWebhook)
+	}
+
+	// Readiness reflects the health of the default bot's webhook, and
+	// turns false on shutdown so load balancers stop sending traffic here.
+	// Tenants preflight found problems with, or whose own webhook is
+	// unhealthy, are listed but keep the instance ready.
+	app.Get("/readyz", func(c *fiber.Ctx) error {
+		if draining.Load() {
+			return c.Status(503).JSON(fiber.Map{"status": "shutting_down"})
+		}
+		if !upstreams.Healthy(defaultTenant) {
+			return c.Status(503).JSON(fiber.Map{"status": "unavailable", "upstreams": upstreams.Statuses()})
+		}
+		resp := fiber.Map{"status": "ready", "upstreams": upstreams.Statuses()}
+		degraded := degradedTenants()
+		for _, s := range upstreams.Statuses() {
+			if !s.Healthy && !slices.Contains(degraded, s.Name) {
+				degraded = append(degraded, s.Name)
+			}
+		}
+		if len(degraded) > 0 {
+			resp["degraded_tenants"] = degraded
+		}
+		return c.JSON(resp)
+	})
+
+	// Widget config, greeting and recent history in one round trip
+	app.Get("/bootstrap", conditional, handleBootstrap)
+
+	// JSON Schema of the rich content sent with replies
+	app.Get("/rich/schema", conditional, func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, "application/schema+json")
+		return c.Send(rich.Schema)
+	})
+
+	// Where a visitor's session stands. The rest of the session, such as
+	// its memory, location and sign-in claims, is for agents only.
+	app.Get("/sessions/:id", requireCaller(apikeys.ScopeMessages), conditional, func(c *fiber.Ctx) error {
+		visitorID := c.Query("visitor_id")
+		if visitorID == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "visitor_id is required"})
+		}
+		sess, err := sessions.Get(c.Params("id"))
+		if err != nil || sess.VisitorID != visitorID {
+			return c.Status(404).JSON(fiber.Map{"error": session.ErrNotFound.Error()})
+		}
+		return c.JSON(fiber.Map{
+			"id":                sess.ID,
+			"status":            sess.Status,
+			"created_at":        sess.CreatedAt,
+			"status_changed_at": sess.StatusChangedAt,
+		})
+	})
+
+	// WebSocket setup
 	app.Use("/ws", func(c *fiber.Ctx) error {
 		// IsWebSocketUpgrade returns true if the client requested upgrade to the WebSocket protocol
 		if websocket.IsWebSocketUpgrade(c) {
 			c.Locals("allowed", true)
+			c.Locals("ip", c.IP())
 			return c.Next()
 		}
 		return fiber.ErrUpgradeRequired
 	})
 
-	app.Get("/ws/chat", websocket.New(handleWebSocket))
+	app.Get("/ws/chat", rejectWhileDraining, requireVisitorToken, websocket.New(handleWebSocket, websocket.Config{HandshakeTimeout: serverConfig.Server.HandshakeTimeout}))
+
+	// The same chat over server-sent events, for networks that block
+	// WebSockets
+	app.Get("/sse/chat", rejectWhileDraining, requireVisitorToken, handleEventStream)
 
-	log.Fatal(app.Listen(":8080"))
+	if serverConfig.Autocert.Enabled {
+		go serveTLS(app)
+	}
+	listenAndDrain(app, fmt.Sprintf(":%d", serverConfig.Port))
 }
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/apikeys"
+	"web-chatbot-backend/internal/events"
+	"web-chatbot-backend/internal/jwtauth"
+	"web-chatbot-backend/internal/session"
+	"web-chatbot-backend/internal/visitor"
+)
+
+// mergeVisitors folds the anonymous visitor from into the identified
+// visitor into: its profile, sessions, stored messages, reminders and push
+// subscriptions all move over. What the bot remembered about from is
+// copied into into's open sessions, without overwriting anything it
+// already knows, so the next message sent upstream carries both.
+func mergeVisitors(from, into string) (*visitor.Profile, error) {
+	profile, err := visitors.Merge(from, into)
+	if err != nil {
+		return nil, err
+	}
+	moved := sessions.Reassign(from, into)
+	if messageStore != nil {
+		if _, err := messageStore.Reassign(context.Background(), from, into); err != nil {
+			log.Error().Str("from_visitor", from).Str("visitor_id", into).Err(err).Msg("Error moving messages to the merged visitor")
+		}
+	}
+	visitorReminders.Reassign(from, into)
+	if pushSubscriptions != nil {
+		if err := pushSubscriptions.Move(from, into); err != nil {
+			log.Error().Str("from_visitor", from).Str("visitor_id", into).Err(err).Msg("Error moving push subscriptions to the merged visitor")
+		}
+	}
+	carryOverMemory(moved, into)
+
+	bus.Publish(events.Event{
+		Type: "visitors_merged",
+		Data: map[string]any{"visitor_id": into, "merged_from": from, "sessions": moved},
+	})
+	log.Info().Str("from_visitor", from).Str("visitor_id", into).Int("sessions", len(moved)).Msg("Merged visitors")
+	return profile, nil
+}
+
+// carryOverMemory copies the memory of the most recently updated of the
+// moved sessions into every open session of visitorID.
+func carryOverMemory(moved []string, visitorID string) {
+	var latest *session.Session
+	for _, id := range moved {
+		s, err := sessions.Get(id)
+		if err == nil && len(s.Memory) > 0 && (latest == nil || s.UpdatedAt.After(latest.UpdatedAt)) {
+			latest = s
+		}
+	}
+	if latest == nil {
+		return
+	}
+	open := sessions.List(func(s *session.Session) bool {
+		return s.VisitorID == visitorID && s.ID != latest.ID &&
+			s.Status != session.StatusClosed && s.Status != session.StatusArchived
+	})
+	for _, s := range open {
+		vars := make(map[string]interface{})
+		for k, v := range latest.Memory {
+			if _, ok := s.Memory[k]; !ok {
+				vars[k] = v
+			}
+		}
+		if len(vars) == 0 {
+			continue
+		}
+		if err := sessions.SetMemory(s.ID, vars); err != nil {
+			log.Error().Str("session_id", s.ID).Err(err).Msg("Error carrying memory over")
+		}
+	}
+}
+
+func mergeError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, visitor.ErrNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, visitor.ErrSameVisitor):
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+}
+
+// registerMergeRoutes serves POST /visitors/merge, which the site calls
+// once a visitor signs in to attach what they did anonymously, e.g. on
+// another device, to their profile. A visitor signed in with a token can
+// only merge into the visitor ID of its subject.
+func registerMergeRoutes(app *fiber.App) {
+	app.Post("/visitors/merge", requireCaller(apikeys.ScopeVisitors), func(c *fiber.Ctx) error {
+		var body struct {
+			VisitorID          string `json:"visitor_id"`
+			AnonymousVisitorID string `json:"anonymous_visitor_id"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		if body.VisitorID == "" || body.AnonymousVisitorID == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "visitor_id and anonymous_visitor_id are required"})
+		}
+		if claims, _ := c.Locals("user").(jwtauth.Claims); claims != nil && claims.Subject() != body.VisitorID {
+			return c.Status(403).JSON(fiber.Map{"error": "visitor_id does not match the signed-in user"})
+		}
+		profile, err := mergeVisitors(body.AnonymousVisitorID, body.VisitorID)
+		if err != nil {
+			return mergeError(c, err)
+		}
+		return c.JSON(profile)
+	})
+}
+
+// registerMergeAdminRoutes lets operators merge two visitors they know to
+// be the same person.
+func registerMergeAdminRoutes(admin fiber.Router) {
+	admin.Post("/visitors/:id/merge", func(c *fiber.Ctx) error {
+		var body struct {
+			From string `json:"from"`
+		}
+		if err := c.BodyParser(&body); err != nil || body.From == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "from is required"})
+		}
+		profile, err := mergeVisitors(body.From, c.Params("id"))
+		if err != nil {
+			return mergeError(c, err)
+		}
+		return c.JSON(profile)
+	})
+}
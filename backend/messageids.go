@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/session"
+)
+
+// Every transcript message gets an ID from the server. A WebSocket message
+// may carry a "client_id" of the widget's choosing; the server answers it
+// with {"type":"ack","client_id":...,"message_id":...} as soon as it is
+// accepted, and replies name the message they answer in "reply_to". A
+// message sent again with the same client_id is acknowledged again but not
+// answered twice. After a reconnect the widget sends {"type":"history",
+// "after":<last message_id it has>} to get what it missed.
+
+// inbound identifies the visitor message being answered.
+type inbound struct {
+	ID       string
+	ClientID string
+}
+
+type inboundKey struct{}
+
+// withInbound returns a copy of ctx for answering the visitor message id.
+func withInbound(ctx context.Context, id, clientID string) context.Context {
+	return context.WithValue(ctx, inboundKey{}, inbound{ID: id, ClientID: clientID})
+}
+
+// inboundFrom returns the visitor message ctx is answering, if it is known.
+func inboundFrom(ctx context.Context) inbound {
+	in, _ := ctx.Value(inboundKey{}).(inbound)
+	return in
+}
+
+// acceptMessage gives a visitor message on client its ID and acknowledges
+// it if the widget gave it a client ID. It reports false if the message was
+// already received, in which case it must not be answered again.
+func acceptMessage(ctx context.Context, client *Client, clientID string) (context.Context, bool) {
+	if clientID == "" {
+		return withInbound(ctx, uuid.NewString(), ""), true
+	}
+	if prev, ok := sessions.FindClientMessage(client.SessionID, clientID); ok {
+		log.Ctx(ctx).Info().Str("client_id", clientID).Str("message_id", prev.ID).Msg("Ignoring message received before")
+		client.WriteJSON(ackFrame(clientID, prev.ID))
+		return ctx, false
+	}
+	id := uuid.NewString()
+	client.WriteJSON(ackFrame(clientID, id))
+	return withInbound(ctx, id, clientID), true
+}
+
+func ackFrame(clientID, messageID string) fiber.Map {
+	return fiber.Map{"type": "ack", "client_id": clientID, "message_id": messageID}
+}
+
+// recordVisitorMessage adds the visitor message ctx is answering to the
+// transcript, and returns ctx knowing its ID.
+func recordVisitorMessage(ctx context.Context, conversation, text string) context.Context {
+	in := inboundFrom(ctx)
+	msg, err := sessions.Append(conversation, session.Message{ID: in.ID, ClientID: in.ClientID, Role: session.RoleVisitor, Text: text})
+	if err != nil {
+		log.Ctx(ctx).Error().Str("session_id", conversation).Err(err).Msg("Error recording message")
+		return ctx
+	}
+	return withInbound(ctx, msg.ID, msg.ClientID)
+}
+
+// sendMissed answers a history request with the messages added after the
+// one the widget last had.
+func sendMissed(client *Client, after string) error {
+	missed, err := sessions.MessagesAfter(client.SessionID, after)
+	if err != nil {
+		missed = []session.Message{}
+	}
+	return client.WriteJSON(fiber.Map{"type": "history", "after": after, "messages": missed})
+}
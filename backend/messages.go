@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/apikeys"
+	"web-chatbot-backend/internal/session"
+	"web-chatbot-backend/internal/store"
+)
+
+var (
+	messageStore *store.Store
+	// Messages and receipts waiting to be written, in order, so a slow
+	// database never holds up a conversation
+	storeQueue = make(chan storeWrite, 1024)
+)
+
+// storeWrite is a new message for the message store, or with receipt set,
+// the receipts of a message stored before.
+type storeWrite struct {
+	message store.Message
+	receipt bool
+}
+
+// apply writes w to the message store.
+func (w storeWrite) apply(ctx context.Context) error {
+	m := w.message
+	if w.receipt {
+		return messageStore.SetReceipts(ctx, m.SessionID, m.MessageID, m.DeliveredAt, m.ReadAt)
+	}
+	return messageStore.Append(ctx, m)
+}
+
+// queueStoreWrite queues w for the message store, dropping it if the
+// queue is full.
+func queueStoreWrite(w storeWrite) {
+	select {
+	case storeQueue <- w:
+	default:
+		log.Warn().Str("session_id", w.message.SessionID).Msg("Message store is falling behind, dropped a message")
+	}
+}
+
+// Most messages returned per page by GET /sessions/:id/messages
+const maxMessagesPage = 200
+
+// openMessageStore connects to the configured message store and starts
+// writing transcript messages to it.
+func openMessageStore(ctx context.Context) error {
+	st, err := dialMessageStore(ctx)
+	if err != nil {
+		return err
+	}
+	messageStore = st
+
+	sessions.OnMessage(func(s *session.Session, msg session.Message) {
+		queueStoreWrite(storeWrite{message: store.Message{
+			MessageID: msg.ID,
+			SessionID: s.ID,
+			VisitorID: s.VisitorID,
+			Channel:   s.Channel,
+			Role:      msg.Role,
+			Text:      msg.Text,
+			CreatedAt: msg.Time,
+		}})
+	})
+	go persistMessages(ctx)
+	return nil
+}
+
+// dialMessageStore connects to the configured message store.
+func dialMessageStore(ctx context.Context) (*store.Store, error) {
+	dsn := serverConfig.Store.DSN
+	if dsn == "" && serverConfig.Store.Driver == store.SQLite {
+		if err := os.MkdirAll(serverConfig.DataDir, 0o755); err != nil {
+			return nil, err
+		}
+		dsn = filepath.Join(serverConfig.DataDir, "messages.db")
+	}
+	return store.Open(ctx, serverConfig.Store.Driver, dsn, store.Options{BusyTimeout: serverConfig.Store.BusyTimeout})
+}
+
+// persistMessages writes queued messages to the store until ctx is
+// cancelled.
+func persistMessages(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case w := <-storeQueue:
+			wctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			if err := w.apply(wctx); err != nil {
+				log.Error().Str("session_id", w.message.SessionID).Err(err).Msg("Error storing message")
+			}
+			cancel()
+		}
+	}
+}
+
+// flushMessages writes the messages still queued, e.g. on shutdown.
+func flushMessages(ctx context.Context) {
+	for {
+		select {
+		case w := <-storeQueue:
+			if err := w.apply(ctx); err != nil {
+				log.Error().Str("session_id", w.message.SessionID).Err(err).Msg("Error storing message")
+			}
+		default:
+			return
+		}
+	}
+}
+
+// registerMessageRoutes serves a visitor the stored history of their
+// sessions, also after a restart. Without a message store the transcript
+// kept in memory is served instead.
+func registerMessageRoutes(app *fiber.App) {
+	app.Get("/sessions/:id/messages", requireCaller(apikeys.ScopeMessages), func(c *fiber.Ctx) error {
+		id, visitorID := c.Params("id"), c.Query("visitor_id")
+		if visitorID == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "visitor_id is required"})
+		}
+		limit := c.QueryInt("limit", maxMessagesPage)
+		if limit <= 0 || limit > maxMessagesPage {
+			limit = maxMessagesPage
+		}
+
+		if messageStore == nil {
+			sess, err := sessions.Get(id)
+			if err != nil || sess.VisitorID != visitorID {
+				return c.Status(404).JSON(fiber.Map{"error": session.ErrNotFound.Error()})
+			}
+			history, _ := sessions.History(id)
+			if len(history) > limit {
+				history = history[len(history)-limit:]
+			}
+			return c.JSON(fiber.Map{"messages": history})
+		}
+
+		list, err := messageStore.Messages(c.Context(), id, visitorID, int64(c.QueryInt("after")), limit)
+		if err != nil {
+			log.Error().Str("session_id", id).Err(err).Msg("Error reading messages")
+			return c.Status(500).JSON(fiber.Map{"error": "Could not read messages"})
+		}
+		resp := fiber.Map{"messages": list}
+		if len(list) == limit {
+			resp["next_after"] = list[len(list)-1].ID
+		}
+		return c.JSON(resp)
+	})
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+
+	"web-chatbot-backend/internal/metrics"
+)
+
+// chatMetrics are served to Prometheus, see config.Metrics.
+var chatMetrics *metrics.Metrics
+
+// newChatMetrics labels series with the configured tenants and providers.
+func newChatMetrics() *metrics.Metrics {
+	return metrics.New(metrics.Config{
+		Tenants:   metrics.NewGuard(serverConfig.Metrics.Tenants, serverConfig.Metrics.MaxTenants),
+		Providers: metrics.NewGuard(serverConfig.Metrics.Providers, serverConfig.Metrics.MaxProviders),
+	})
+}
+
+// registerMetricsRoutes serves GET /metrics for Prometheus to scrape.
+func registerMetricsRoutes(app *fiber.App) {
+	handler := adaptor.HTTPHandler(chatMetrics.Handler())
+	app.Get("/metrics", func(c *fiber.Ctx) error {
+		if serverConfig.Metrics.Token != "" && c.Get("Authorization") != "Bearer "+serverConfig.Metrics.Token {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+		}
+		return handler(c)
+	})
+}
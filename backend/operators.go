@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/operators"
+	"web-chatbot-backend/internal/totp"
+)
+
+// adminOperators sign in to the admin API with their own token instead
+// of the shared CHATBOT_ADMIN_TOKEN, so destructive requests and second
+// factors are tied to who made them.
+var adminOperators *operators.Store
+
+// signedInOperator returns the operator whose token made an admin
+// request, or "" for the shared admin token.
+func signedInOperator(c *fiber.Ctx) string {
+	name, _ := c.Locals("operator").(string)
+	return name
+}
+
+// registerOperatorRoutes lets operators be added, given a new token and
+// removed. Adding one or issuing a token gives someone access to the
+// admin API, so once any operator has a second factor it takes one of
+// them with a code, see approvalProblem.
+func registerOperatorRoutes(admin fiber.Router) {
+	admin.Get("/operators", func(c *fiber.Ctx) error {
+		return paginate(c, "operators", adminOperators.List())
+	})
+
+	// The token itself is only ever returned here and by rotate
+	admin.Post("/operators", requireApproval, func(c *fiber.Ctx) error {
+		var body struct {
+			Name string `json:"name"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		op, token, err := adminOperators.Create(body.Name, changedBy(c))
+		switch {
+		case errors.Is(err, operators.ErrNoName):
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		case errors.Is(err, operators.ErrExists):
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		case err != nil:
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		log.Info().Str("operator", op.Name).Str("by", changedBy(c)).Msg("Added operator")
+		return c.Status(201).JSON(fiber.Map{"operator": op, "token": token})
+	})
+
+	admin.Post("/operators/:name/rotate", requireApproval, func(c *fiber.Ctx) error {
+		op, token, err := adminOperators.Rotate(c.Params("name"))
+		switch {
+		case errors.Is(err, operators.ErrNotFound):
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		case err != nil:
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		log.Info().Str("operator", op.Name).Str("by", changedBy(c)).Msg("Rotated operator token")
+		return c.JSON(fiber.Map{"operator": op, "token": token})
+	})
+
+	admin.Delete("/operators/:name", requireSecondFactor, func(c *fiber.Ctx) error {
+		name := c.Params("name")
+		if err := adminOperators.Remove(name); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		// Their second factor goes with them, so the name can be given to
+		// someone else
+		if err := twoFactor.Remove(name); err != nil && !errors.Is(err, totp.ErrNotEnrolled) {
+			log.Error().Str("operator", name).Err(err).Msg("Error removing second factor")
+		}
+		log.Info().Str("operator", name).Str("by", changedBy(c)).Msg("Removed operator")
+		return c.SendStatus(204)
+	})
+}
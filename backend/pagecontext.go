@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/pagecontext"
+)
+
+// The widget may send a "context" object with each message, describing the
+// page the visitor is on. It is passed to the bot as "context", and its
+// metadata is capped by config.PageContext.
+type pageContextKey struct{}
+
+// pageContextFrom returns the page context a message was sent with, if
+// any.
+func pageContextFrom(ctx context.Context) *pagecontext.Context {
+	pc, _ := ctx.Value(pageContextKey{}).(*pagecontext.Context)
+	return pc
+}
+
+// acceptPageContext checks the page context sent with a message and keeps
+// it in ctx for the bot. The user agent defaults to the request's.
+func acceptPageContext(ctx context.Context, pc *pagecontext.Context, userAgent string) (context.Context, error) {
+	if pc == nil {
+		return ctx, nil
+	}
+	if pc.UserAgent == "" {
+		pc.UserAgent = userAgent
+	}
+	if err := pc.Validate(pagecontext.Limits{MaxKeys: serverConfig.PageContext.MaxKeys, MaxBytes: serverConfig.PageContext.MaxBytes}); err != nil {
+		return ctx, err
+	}
+	return context.WithValue(ctx, pageContextKey{}, pc), nil
+}
+
+// keepPageContext records the page context in ctx on the session, so
+// later messages without one carry it too.
+func keepPageContext(ctx context.Context, conversation string) {
+	pc := pageContextFrom(ctx)
+	if pc == nil || conversation == "" {
+		return
+	}
+	if err := sessions.SetContext(conversation, *pc); err != nil {
+		log.Ctx(ctx).Error().Str("session_id", conversation).Err(err).Msg("Error recording page context")
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/actions"
+	"web-chatbot-backend/internal/events"
+	"web-chatbot-backend/internal/rich"
+	"web-chatbot-backend/internal/stripe"
+)
+
+// registerPaymentActions adds the create_payment_link action.
+//
+// Workflows call {"action": "create_payment_link", "params": {"name": "Gift card", "amount": 25}}
+// (or {"price": "price_..."} for an existing Stripe price) and the visitor
+// gets a pay button. Once Stripe reports the payment, a confirmation is
+// posted into the chat.
+func registerPaymentActions(registry *actions.Registry, payments *stripe.Client) {
+	registry.Register("create_payment_link", actions.HandlerFunc(func(ctx context.Context, call actions.Call) (map[string]interface{}, error) {
+		item := stripe.Item{Quantity: intParam(call.Params, "quantity", 1)}
+		item.Price, _ = call.Params["price"].(string)
+		item.Name, _ = call.Params["name"].(string)
+		item.Amount, _ = call.Params["amount"].(float64)
+		item.Currency, _ = call.Params["currency"].(string)
+		if item.Currency == "" {
+			item.Currency = serverConfig.Stripe.Currency
+		}
+
+		link, err := payments.CreateLink(ctx, item, call.SessionID)
+		if err != nil {
+			return map[string]interface{}{"message": "Sorry, I couldn't create a payment link right now."}, err
+		}
+		label := "Pay now"
+		if item.Price == "" {
+			label = fmt.Sprintf("Pay %.2f %s", item.Amount, strings.ToUpper(item.Currency))
+		}
+		return map[string]interface{}{
+			"id":      link.ID,
+			"url":     link.URL,
+			"message": "You can complete your payment here:",
+			"rich":    []rich.Element{rich.Buttons(rich.Button{Label: label, URL: link.URL})},
+		}, nil
+	}))
+}
+
+// handleStripeWebhook confirms completed checkouts in the chat they were
+// created from.
+func handleStripeWebhook(c *fiber.Ctx) error {
+	event, err := stripe.ParseWebhook(c.Body(), c.Get("Stripe-Signature"), serverConfig.Stripe.WebhookSecret)
+	if errors.Is(err, stripe.ErrInvalidSignature) {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	} else if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid event"})
+	}
+	if event.Type != "checkout.session.completed" {
+		return c.SendStatus(204)
+	}
+
+	var checkout stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Object, &checkout); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid checkout session"})
+	}
+	if checkout.ClientReferenceID == "" || checkout.PaymentStatus != "paid" {
+		return c.SendStatus(204)
+	}
+
+	log.Info().Str("payment_id", checkout.ID).Str("session_id", checkout.ClientReferenceID).Msg("Payment completed")
+	bus.Publish(events.Event{
+		Type:      "payment_completed",
+		SessionID: checkout.ClientReferenceID,
+		Data: map[string]any{
+			"checkout_id": checkout.ID,
+			"amount":      checkout.AmountTotal,
+			"currency":    checkout.Currency,
+		},
+	})
+	if _, err := sessions.Get(checkout.ClientReferenceID); err == nil {
+		notifySession(checkout.ClientReferenceID, fmt.Sprintf("Payment received: %.2f %s. Thank you!",
+			float64(checkout.AmountTotal)/100, strings.ToUpper(checkout.Currency)))
+	}
+	return c.SendStatus(204)
+}
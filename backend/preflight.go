@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/preflight"
+)
+
+// preflightReport is what the checks found at startup.
+var preflightReport preflight.Report
+
+// loadChecks are what main loaded at startup, stores and key files among
+// them. Each is a fatal check that already ran, so that what failed to
+// load is reported along with every other problem rather than stopping
+// the server on its own.
+var loadChecks []preflight.Check
+
+// loaded records whether what loaded, and reports whether it did.
+func loaded(what string, err error) bool {
+	loadChecks = append(loadChecks, preflight.Check{
+		Tenant:   defaultTenant,
+		Name:     what,
+		Severity: preflight.Fatal,
+		Run:      func() error { return err },
+	})
+	return err == nil
+}
+
+// preflightChecks lists what is checked before the server starts, once
+// everything is loaded: first whether it loaded at all. Only the config
+// file stops the server as soon as it is read.
+func preflightChecks() []preflight.Check {
+	fatal := func(name string, run func() error) preflight.Check {
+		return preflight.Check{Tenant: defaultTenant, Name: name, Severity: preflight.Fatal, Run: run}
+	}
+	degraded := func(name string, run func() error) preflight.Check {
+		return preflight.Check{Tenant: defaultTenant, Name: name, Severity: preflight.Degraded, Run: run}
+	}
+	checks := append(slices.Clone(loadChecks),
+		fatal("webhook_url", preflight.URL(webhookURL, "http", "https")),
+		degraded("actions", checkActions),
+		degraded("ticket_url_template", checkTicketURLTemplate),
+		degraded("otlp_endpoint", preflight.URL(serverConfig.Telemetry.Endpoint, "http", "https")),
+		degraded("translation_url", preflight.URL(serverConfig.Translation.URL, "http", "https")),
+		degraded("config_slack_webhook_url", preflight.URL(serverConfig.ConfigSlackWebhookURL, "https")),
+		degraded("stripe_success_url", preflight.URL(serverConfig.Stripe.SuccessURL, "http", "https")),
+		degraded("stripe_cancel_url", preflight.URL(serverConfig.Stripe.CancelURL, "http", "https")),
+		degraded("push_url", preflight.URL(serverConfig.WebPush.ClickURL, "http", "https")),
+		degraded("transcript_webhook_url", preflight.URL(serverConfig.Transcripts.WebhookURL, "http", "https")),
+	)
+	if botProviderName == "openai" {
+		checks = append(checks, fatal("openai_url", preflight.URL(serverConfig.OpenAI.URL, "http", "https")))
+	}
+	for _, endpoint := range deliveryConfig().Endpoints {
+		checks = append(checks, degraded("event_webhook_url", preflight.URL(endpoint, "http", "https")))
+	}
+	return checks
+}
+
+// checkActions renders the templates of every configured action.
+func checkActions() error {
+	problems := actionRegistry.Check()
+	names := make([]string, 0, len(problems))
+	for name := range problems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs []error
+	for _, name := range names {
+		errs = append(errs, fmt.Errorf("action %q: %w", name, problems[name]))
+	}
+	return errors.Join(errs...)
+}
+
+// checkTicketURLTemplate checks the helpdesk link template has a place
+// for the ticket ID.
+func checkTicketURLTemplate() error {
+	tmpl := ticketingConfig().URLTemplate
+	if tmpl == "" {
+		return nil
+	}
+	if !strings.Contains(tmpl, "{id}") {
+		return fmt.Errorf("%q has no {id}", tmpl)
+	}
+	return preflight.URL(strings.ReplaceAll(tmpl, "{id}", "1"), "http", "https")()
+}
+
+// runPreflight checks the configuration, logs every problem and stops the
+// server if any is fatal. Tenants with other problems are served without
+// the broken features and show up as degraded in /readyz.
+func runPreflight() {
+	preflightReport = preflight.Run(preflightChecks())
+	for _, p := range preflightReport.Problems {
+		event := log.Warn()
+		if p.Severity == preflight.Fatal || serverConfig.PreflightStrict {
+			event = log.Error()
+		}
+		event.Str("tenant", p.Tenant).Str("check", p.Check).Str("severity", string(p.Severity)).Str("error", p.Error).Msg("Preflight check failed")
+	}
+	switch {
+	case len(preflightReport.Problems) == 0:
+		log.Info().Int("checks", preflightReport.Checks).Msg("Preflight checks passed")
+	case preflightReport.Fatal() || serverConfig.PreflightStrict:
+		log.Fatal().Int("problems", len(preflightReport.Problems)).Msg("Preflight checks failed, not starting")
+	default:
+		log.Warn().Int("problems", len(preflightReport.Problems)).Msg("Preflight checks found problems; starting degraded")
+	}
+}
+
+// degradedTenants lists the tenants preflight found problems with.
+func degradedTenants() []string {
+	var out []string
+	if preflightReport.Degraded(defaultTenant) {
+		out = append(out, defaultTenant)
+	}
+	return out
+}
+
+// registerPreflightRoutes shows what the checks found at startup.
+func registerPreflightRoutes(admin fiber.Router) {
+	admin.Get("/preflight", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"report": preflightReport, "degraded_tenants": degradedTenants()})
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/session"
+)
+
+// visitorConnected records that a visitor's connection to a session
+// opened, and returns when, for visitorDisconnected.
+func visitorConnected(sessionID string) time.Time {
+	at := time.Now()
+	if err := sessions.MarkConnected(sessionID, at); err != nil {
+		log.Error().Str("session_id", sessionID).Err(err).Msg("Error recording connection")
+	}
+	return at
+}
+
+// visitorDisconnected records that the connection opened at connectedAt
+// closed.
+func visitorDisconnected(sessionID string, connectedAt time.Time) {
+	if err := sessions.MarkDisconnected(sessionID, connectedAt, time.Now()); err != nil {
+		log.Error().Str("session_id", sessionID).Err(err).Msg("Error recording disconnection")
+	}
+}
+
+// onlineVisitor is a connected visitor as the support team sees them.
+type onlineVisitor struct {
+	SessionID    string         `json:"session_id"`
+	VisitorID    string         `json:"visitor_id,omitempty"`
+	Channel      string         `json:"channel,omitempty"`
+	Status       session.Status `json:"status"`
+	PageURL      string         `json:"page_url,omitempty"`
+	ConnectedAt  time.Time      `json:"connected_at"`
+	MessageCount int            `json:"message_count"`
+	LastActivity time.Time      `json:"last_activity_at"`
+}
+
+// registerPresenceRoutes lists the visitors connected right now.
+func registerPresenceRoutes(admin fiber.Router) {
+	// Newest connections first. The page is the one the visitor last wrote
+	// from.
+	admin.Get("/presence", func(c *fiber.Ctx) error {
+		list := sessions.List(func(s *session.Session) bool { return s.Presence.Online() && !s.Test })
+		online := make([]onlineVisitor, 0, len(list))
+		for _, s := range list {
+			v := onlineVisitor{
+				SessionID:    s.ID,
+				VisitorID:    s.VisitorID,
+				Channel:      s.Channel,
+				Status:       s.Status,
+				ConnectedAt:  s.Presence.ConnectedAt,
+				MessageCount: s.MessageCount,
+				LastActivity: s.LastActivityAt,
+			}
+			if s.Context != nil {
+				v.PageURL = s.Context.PageURL
+			}
+			online = append(online, v)
+		}
+		sort.Slice(online, func(i, j int) bool {
+			if !online[i].ConnectedAt.Equal(online[j].ConnectedAt) {
+				return online[i].ConnectedAt.After(online[j].ConnectedAt)
+			}
+			return online[i].SessionID < online[j].SessionID
+		})
+		return paginate(c, "visitors", online)
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	_ "embed"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+//go:embed preview.html
+var previewPage []byte
+
+// previewSubject is what a preview link for tenant is signed for. Share
+// links sign session IDs, so neither can be used as the other.
+func previewSubject(tenant string) string {
+	return "preview:" + tenant
+}
+
+// requirePreviewToken lets through requests with a valid, unexpired preview
+// link token in ?token= for the tenant in the path.
+func requirePreviewToken(c *fiber.Ctx) error {
+	if !knownTenant(c.Params("tenant")) {
+		return c.Status(404).JSON(fiber.Map{"error": "Unknown tenant"})
+	}
+	subject, _, err := shareLinks.Verify(c.Query("token"), time.Now())
+	if err != nil || subject != previewSubject(c.Params("tenant")) {
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid or expired preview link"})
+	}
+	return c.Next()
+}
+
+// registerPreviewRoutes serves the sandbox page, which shows the widget
+// with the draft configuration so admins can try changes before they are
+// published. Messages go through the draft test pipeline: no session is
+// created and the workflow gets "test" and "draft" set.
+func registerPreviewRoutes(app *fiber.App) {
+	preview := app.Group("/preview/:tenant", requirePreviewToken)
+
+	preview.Get("/", func(c *fiber.Ctx) error {
+		c.Set("Cache-Control", "no-store")
+		c.Set("X-Robots-Tag", "noindex")
+		c.Type("html")
+		return c.Send(previewPage)
+	})
+
+	preview.Get("/config", func(c *fiber.Ctx) error {
+		draft := currentDraft()
+		greeting, err := draftGreeting(draft, greetingVisitor(c.Params("tenant"), "", c.Query("referrer"), c.Query("tz")))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		cfg := defaultWidget()
+		cfg.Greeting = greeting
+		return c.JSON(fiber.Map{"config": cfg, "draft": botConfig.Draft() != nil})
+	})
+
+	preview.Post("/messages", limitBody(serverConfig.Server.ChatBodyLimit), func(c *fiber.Ctx) error {
+		var body struct {
+			Message string `json:"message"`
+		}
+		if err := c.BodyParser(&body); err != nil || body.Message == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "message is required"})
+		}
+		resp, err := draftReply(c.UserContext(), currentDraft(), nil, body.Message)
+		if err != nil {
+			return c.Status(502).JSON(fiber.Map{"reply": apology(err), "error": err.Error()})
+		}
+		return c.JSON(resp)
+	})
+}
+
+// registerPreviewAdminRoutes hands out links to the sandbox page that work
+// without the admin token until they expire.
+func registerPreviewAdminRoutes(admin fiber.Router) {
+	admin.Post("/preview-links", func(c *fiber.Ctx) error {
+		expires := time.Now().Add(serverConfig.PreviewTTL)
+		token := shareLinks.Token(previewSubject(defaultTenant), expires)
+		return c.Status(201).JSON(fiber.Map{
+			"url":        c.BaseURL() + "/preview/" + defaultTenant + "?token=" + token,
+			"expires_at": expires.UTC().Format(time.RFC3339),
+		})
+	})
+}
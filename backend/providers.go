@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"web-chatbot-backend/internal/config"
+	"web-chatbot-backend/internal/health"
+	"web-chatbot-backend/internal/provider"
+)
+
+var (
+	errNoOpenAIKey     = errors.New("CHATBOT_OPENAI_API_KEY is required for the openai provider")
+	errBadHeaderFormat = errors.New(`CHATBOT_HTTP_HEADERS entries must look like "Name: value"`)
+)
+
+// botProvider answers visitor messages, see newBotProvider, and
+// botProviderName is the kind of provider it is, e.g. "openai".
+var (
+	botProvider     provider.BotProvider
+	botProviderName string
+)
+
+// newBotProvider returns the provider cfg asks for.
+func newBotProvider(cfg config.Config) (provider.BotProvider, error) {
+	limits := provider.Limits{Request: cfg.Upstream.RequestLimit, Response: cfg.Upstream.ResponseLimit}
+	switch cfg.Provider {
+	case "openai":
+		if cfg.OpenAI.APIKey == "" {
+			return nil, errNoOpenAIKey
+		}
+		p := provider.NewOpenAI(cfg.OpenAI.URL, cfg.OpenAI.APIKey, cfg.OpenAI.Model, limits)
+		p.Client = upstreamClient
+		return p, nil
+	case "http":
+		header := make(http.Header)
+		for _, h := range cfg.HTTP.Headers {
+			name, value, ok := strings.Cut(h, ":")
+			if !ok || strings.TrimSpace(name) == "" {
+				return nil, fmt.Errorf("%w, got %q", errBadHeaderFormat, h)
+			}
+			header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+		p := provider.NewHTTP(cfg.WebhookURL, header, cfg.HTTP.ReplyField, limits)
+		p.Secret = cfg.WebhookSecret
+		p.Client = upstreamClient
+		return p, nil
+	default:
+		p := provider.NewN8N(cfg.WebhookURL, cfg.WebhookSecret, limits)
+		p.Client = upstreamClient
+		return p, nil
+	}
+}
+
+// probeBot returns a health probe that asks the provider bot returns a
+// canary message and checks it gets a usable reply, for providers whose
+// contract probeWebhook does not know.
+func probeBot(message string, bot func() provider.BotProvider) health.ProbeFunc {
+	return func(ctx context.Context) error {
+		p := bot()
+		if p == nil {
+			return errors.New("no bot provider")
+		}
+		payload := map[string]interface{}{"message": message, "probe": true}
+		resp, err := p.SendMessage(ctx, provider.Conversation{Payload: payload})
+		if err != nil {
+			return err
+		}
+		parsed, err := parseReply(resp)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(parsed.Text) == "" {
+			return errors.New("no usable reply in response")
+		}
+		return nil
+	}
+}
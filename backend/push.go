@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/webpush"
+)
+
+// registerPushRoutes lets the widget subscribe visitors to Web Push
+// notifications.
+func registerPushRoutes(app *fiber.App) {
+	app.Get("/push/config", conditional, func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"public_key": pushSender.PublicKey})
+	})
+
+	app.Post("/push/subscriptions", func(c *fiber.Ctx) error {
+		var body struct {
+			VisitorID    string               `json:"visitor_id"`
+			Subscription webpush.Subscription `json:"subscription"`
+		}
+		if err := c.BodyParser(&body); err != nil || body.VisitorID == "" || body.Subscription.Endpoint == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "visitor_id and subscription are required"})
+		}
+		if err := pushSubscriptions.Subscribe(body.VisitorID, body.Subscription); err != nil {
+			log.Error().Str("visitor_id", body.VisitorID).Err(err).Msg("Error saving push subscription")
+			return c.Status(500).JSON(fiber.Map{"error": "Could not save subscription"})
+		}
+		return c.SendStatus(201)
+	})
+
+	app.Delete("/push/subscriptions", func(c *fiber.Ctx) error {
+		var body struct {
+			VisitorID string `json:"visitor_id"`
+			Endpoint  string `json:"endpoint"`
+		}
+		if err := c.BodyParser(&body); err != nil || body.VisitorID == "" || body.Endpoint == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "visitor_id and endpoint are required"})
+		}
+		if err := pushSubscriptions.Unsubscribe(body.VisitorID, body.Endpoint); err != nil {
+			log.Error().Str("visitor_id", body.VisitorID).Err(err).Msg("Error removing push subscription")
+			return c.Status(500).JSON(fiber.Map{"error": "Could not remove subscription"})
+		}
+		return c.SendStatus(204)
+	})
+}
+
+// pushToVisitor notifies every browser the visitor subscribed from, and
+// drops subscriptions the push service says are gone. It reports whether
+// any notification was delivered.
+func pushToVisitor(visitorID string, n webpush.Notification) bool {
+	if pushSender == nil || visitorID == "" {
+		return false
+	}
+	delivered := false
+	for _, sub := range pushSubscriptions.Subscriptions(visitorID) {
+		err := pushSender.Send(context.Background(), sub, n)
+		switch {
+		case errors.Is(err, webpush.ErrGone):
+			log.Warn().Str("visitor_id", visitorID).Msg("Removing expired push subscription")
+			if err := pushSubscriptions.Unsubscribe(visitorID, sub.Endpoint); err != nil {
+				log.Error().Str("visitor_id", visitorID).Err(err).Msg("Error removing push subscription")
+			}
+		case err != nil:
+			log.Error().Str("visitor_id", visitorID).Err(err).Msg("Error sending push")
+		default:
+			delivered = true
+		}
+	}
+	return delivered
+}
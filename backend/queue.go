@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"web-chatbot-backend/internal/events"
+	"web-chatbot-backend/internal/queue"
+	"web-chatbot-backend/internal/session"
+)
+
+// Queue updates for visitors waiting for an agent. The message may use
+// {position} and {wait}; set CHATBOT_QUEUE_UPDATE_INTERVAL=0 to turn them
+// off. Waits are estimated from how long agents took recently.
+var waitEstimator *queue.Estimator
+
+// Last update sent to each waiting session, so unchanged ones are skipped
+var (
+	queueNotices   = make(map[string]string)
+	queueNoticesMu sync.Mutex
+)
+
+// sendQueueUpdates tells every waiting visitor where they are in the queue
+// and how long they should expect to wait: a queue frame for the widget
+// every time, and a system message when the numbers have changed.
+func sendQueueUpdates() {
+	waiting := sessions.List(func(s *session.Session) bool { return s.Status == session.StatusWaitingAgent })
+	positions := queue.Positions(waiting)
+	agents := servingAgents()
+
+	queueNoticesMu.Lock()
+	for id := range queueNotices {
+		if _, ok := positions[id]; !ok {
+			delete(queueNotices, id)
+		}
+	}
+	queueNoticesMu.Unlock()
+
+	for id, position := range positions {
+		wait := waitEstimator.Wait(position, agents)
+		text := strings.NewReplacer("{position}", strconv.Itoa(position), "{wait}", formatWait(wait)).Replace(serverConfig.Queue.Message)
+
+		visitorHub.Post(id, fiber.Map{"type": "queue", "position": position, "estimated_wait_seconds": int(wait.Seconds())})
+
+		// Only changes are worth a message in the transcript
+		queueNoticesMu.Lock()
+		unchanged := queueNotices[id] == text
+		queueNotices[id] = text
+		queueNoticesMu.Unlock()
+		if !unchanged {
+			notifySession(id, text)
+		}
+	}
+}
+
+// servingAgents counts the agents serving the queue in parallel: those
+// online or, without a roster, those currently handling a conversation.
+func servingAgents() int {
+	if online := len(agentRoster.Online()); online > 0 {
+		return online
+	}
+	agents := make(map[string]bool)
+	for _, s := range sessions.List(func(s *session.Session) bool { return s.Status == session.StatusWithAgent }) {
+		agents[s.Agent] = true
+	}
+	return len(agents)
+}
+
+// formatWait rounds a wait estimate for visitors.
+func formatWait(d time.Duration) string {
+	minutes := int((d + 30*time.Second) / time.Minute)
+	switch {
+	case minutes < 1:
+		return "less than a minute"
+	case minutes == 1:
+		return "about 1 minute"
+	default:
+		return fmt.Sprintf("about %d minutes", minutes)
+	}
+}
+
+// runQueueUpdates sends queue updates every interval, and right away
+// whenever the queue changes, until ctx is cancelled.
+func runQueueUpdates(ctx context.Context, interval time.Duration) {
+	changed := make(chan struct{}, 1)
+	bus.Subscribe(func(e events.Event) {
+		if strings.HasPrefix(e.Type, "session_") {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	})
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-changed:
+		}
+		sendQueueUpdates()
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/events"
+	"web-chatbot-backend/internal/session"
+	"web-chatbot-backend/internal/store"
+)
+
+// The widget reports {"type":"delivered","message_id":...} when a message
+// reaches it and {"type":"read","message_id":...} once the visitor has seen
+// everything up to that message. The times show up on the messages of the
+// transcript and the stored history, and agents get message_delivered and
+// message_read events.
+
+// recordReceipt records a receipt the widget sent for messageID.
+func recordReceipt(sessionID, messageID string, r session.Receipt) {
+	if messageID == "" {
+		return
+	}
+	changed, err := sessions.MarkReceipt(sessionID, messageID, r, time.Now())
+	if err != nil {
+		log.Debug().Str("session_id", sessionID).Str("message_id", messageID).Err(err).Msg("Ignoring receipt")
+		return
+	}
+	if len(changed) == 0 {
+		return
+	}
+	ids := make([]string, len(changed))
+	for i, m := range changed {
+		ids[i] = m.ID
+		if messageStore != nil {
+			queueStoreWrite(storeWrite{
+				message: store.Message{SessionID: sessionID, MessageID: m.ID, DeliveredAt: m.DeliveredAt, ReadAt: m.ReadAt},
+				receipt: true,
+			})
+		}
+	}
+	bus.Publish(events.Event{
+		Type:      "message_" + string(r),
+		SessionID: sessionID,
+		Data:      map[string]any{"message_ids": ids},
+	})
+}
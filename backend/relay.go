@@ -0,0 +1,796 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"web-chatbot-backend/internal/actions"
+	"web-chatbot-backend/internal/alerts"
+	"web-chatbot-backend/internal/events"
+	"web-chatbot-backend/internal/health"
+	"web-chatbot-backend/internal/hooks"
+	"web-chatbot-backend/internal/provider"
+	"web-chatbot-backend/internal/replyparser"
+	"web-chatbot-backend/internal/requestid"
+	"web-chatbot-backend/internal/rich"
+	"web-chatbot-backend/internal/rules"
+	"web-chatbot-backend/internal/session"
+	"web-chatbot-backend/internal/signing"
+	"web-chatbot-backend/internal/tracing"
+	"web-chatbot-backend/internal/visitor"
+)
+
+// webhookURL is where messages are forwarded and webhookSecret, if set,
+// signs each call; see config.Config.
+var webhookURL, webhookSecret string
+
+const noResponseReply = "No response received from the server."
+
+// replyParser pulls the reply out of bot responses, see config.Reply.
+var replyParser *replyparser.Parser
+
+// upstreamClient makes the calls to the bot, its health probes and the
+// HTTP actions, see config.Upstream.
+var upstreamClient *http.Client
+
+// relayError is returned when the webhook call fails. Reply is the apology
+// shown to the visitor in place of a bot answer, and Class the kind of
+// failure, if the bot call itself failed.
+type relayError struct {
+	Reply string
+	Class provider.Class
+	Err   error
+}
+
+func (e *relayError) Error() string { return e.Err.Error() }
+
+func (e *relayError) Unwrap() error { return e.Err }
+
+// defaultApology is shown when nothing more specific can be said.
+const defaultApology = "Sorry, I couldn't process your message. Please try again later."
+
+// upstreamApologies are shown in place of a reply, by kind of failure.
+// The others get defaultApology.
+var upstreamApologies = map[provider.Class]string{
+	provider.ClassRequestTooLarge:  "Sorry, your message is too long for me. Please send a shorter one.",
+	provider.ClassResponseTooLarge: "Sorry, my answer was too long to show. Please try asking in a different way.",
+	provider.ClassRead:             "Sorry, I couldn't read the response from the server.",
+	provider.ClassParse:            "Sorry, I couldn't read the response from the server.",
+	provider.ClassSchema:           "Sorry, I couldn't read the response from the server.",
+	provider.ClassTimeout:          "Sorry, I'm taking too long to answer. Please try again in a moment.",
+	provider.ClassConnectTimeout:   "Sorry, I can't be reached right now. Please try again in a moment.",
+	provider.ClassConnect:          "Sorry, I can't be reached right now. Please try again in a moment.",
+	provider.ClassDNS:              "Sorry, I can't be reached right now. Please try again in a moment.",
+	provider.ClassTLS:              "Sorry, I can't be reached right now. Please try again in a moment.",
+}
+
+// apology returns the message to show the visitor when a relay fails.
+func apology(err error) string {
+	var rerr *relayError
+	if errors.As(err, &rerr) {
+		return rerr.Reply
+	}
+	return defaultApology
+}
+
+// botReply is the outcome of handling one visitor message. System is an
+// optional system message to show before the reply, Actions holds the
+// results of any actions the bot asked us to run, QuickReplies are
+// suggested answers offered to the visitor and Rich is content such as
+// product cards shown with the reply.
+type botReply struct {
+	Reply        string
+	System       string
+	Actions      []actions.Result
+	QuickReplies []session.QuickReply
+	Rich         []rich.Element
+	// MessageID is the reply's ID in the transcript, and ReplyTo the ID of
+	// the visitor message it answers; both are empty for one-off requests.
+	MessageID string
+	ReplyTo   string
+}
+
+// frame renders the reply as a JSON response or WebSocket frame.
+func (r botReply) frame() fiber.Map {
+	m := fiber.Map{"reply": r.Reply}
+	if r.MessageID != "" {
+		m["message_id"] = r.MessageID
+	}
+	if r.ReplyTo != "" {
+		m["reply_to"] = r.ReplyTo
+	}
+	if len(r.QuickReplies) > 0 {
+		m["quick_replies"] = r.QuickReplies
+	}
+	if len(r.Rich) > 0 {
+		m["rich"] = r.Rich
+		m["rich_version"] = rich.SchemaVersion
+	}
+	return m
+}
+
+// maxActionRounds bounds how many times the bot may chain actions for a
+// single visitor message.
+const maxActionRounds = 3
+
+// respond runs one visitor message through the pipeline: the
+// on_message_in hooks, the auto-responder rules or the bot itself, and the
+// on_reply_out hooks. While the upstream is unhealthy, or once a failed
+// call tips it over, the message is answered in degraded mode instead.
+func respond(ctx context.Context, conversation string, profile *visitor.Profile, message string) (botReply, error) {
+	clockFrom(ctx).start()
+	defer clockFrom(ctx).answer()
+	ctx, span := tracing.Start(ctx, "chat.respond", trace.SpanKindInternal, attribute.String("chatbot.session_id", conversation))
+	if conversation != "" {
+		// Verification codes are answered here and never reach the bot
+		if out, ok := takeVerificationCode(ctx, conversation, message); ok {
+			tracing.End(span, nil)
+			return finishReply(ctx, conversation, out), nil
+		}
+		ctx = recordVisitorMessage(ctx, conversation, message)
+	}
+	countQuestion(ctx, conversation, message)
+	out, err := runPipeline(ctx, conversation, profile, message)
+	if err == nil {
+		out = finishReply(ctx, conversation, out)
+	}
+	tracing.End(span, err)
+	return out, err
+}
+
+// respondQuickReply handles the visitor picking a quick reply. Plain quick
+// replies are sent on as messages; action quick replies run their action
+// directly and answer with its outcome.
+func respondQuickReply(ctx context.Context, conversation string, profile *visitor.Profile, label, quickReplyID string) (botReply, error) {
+	clockFrom(ctx).start()
+	defer clockFrom(ctx).answer()
+	qr, err := sessions.TakeQuickReply(conversation, quickReplyID)
+	if err != nil {
+		log.Ctx(ctx).Warn().Str("quick_reply_id", quickReplyID).Str("session_id", conversation).Err(err).Msg("Ignoring quick reply")
+		return respond(ctx, conversation, profile, label)
+	}
+	if qr.Action == "" {
+		return respond(ctx, conversation, profile, qr.Value)
+	}
+
+	ctx = recordVisitorMessage(ctx, conversation, qr.Label)
+	results, offers, elements := runActions(ctx, conversation, profile,
+		[]actions.Directive{{Action: qr.Action, Params: qr.Params}})
+	out := botReply{Reply: summarizeActions(results), Actions: results, QuickReplies: offers, Rich: elements}
+	return finishReply(ctx, conversation, out), nil
+}
+
+// finishReply records the reply in the transcript and puts its quick
+// replies on offer. Quick replies that run actions need a session to be
+// tracked in, so they are dropped for one-off requests.
+func finishReply(ctx context.Context, conversation string, out botReply) botReply {
+	if conversation == "" {
+		var plain []session.QuickReply
+		for _, qr := range out.QuickReplies {
+			if qr.Action == "" {
+				plain = append(plain, qr)
+			}
+		}
+		out.QuickReplies = plain
+		return out
+	}
+
+	if out.System != "" {
+		sessions.AppendMessage(conversation, session.RoleSystem, out.System)
+	}
+	if msg, err := sessions.AppendMessage(conversation, session.RoleBot, out.Reply); err == nil {
+		out.MessageID, out.ReplyTo = msg.ID, inboundFrom(ctx).ID
+	}
+	if serverConfig.MaxTurns > 0 {
+		go summarizeIfLong(conversation)
+	}
+	if len(out.QuickReplies) > 0 {
+		offered, err := sessions.OfferQuickReplies(conversation, out.QuickReplies)
+		if err != nil {
+			log.Ctx(ctx).Error().Str("session_id", conversation).Err(err).Msg("Error offering quick replies")
+		}
+		out.QuickReplies = offered
+	}
+	return out
+}
+
+// runPipeline produces the reply to one message, see respond.
+func runPipeline(ctx context.Context, conversation string, profile *visitor.Profile, message string) (botReply, error) {
+	hc := &hooks.Context{Point: hooks.OnMessageIn, SessionID: conversation, VisitorID: profileID(profile), Message: message}
+	if err := pipelineHooks.Run(ctx, hc); err != nil {
+		return abortedReply(ctx, err)
+	}
+	message = hc.Message
+
+	var out botReply
+	var err error
+	// Test chats stay out of the rule stats
+	match := autoResponder.Match
+	if isTestSession(conversation) {
+		match = autoResponder.Find
+	}
+	var rule *rules.Rule
+	if featureEnabled(featureAutoResponder) {
+		rule = match(message)
+	}
+	if rule != nil && len(rule.Skills) > 0 && conversation != "" {
+		if err := sessions.RequireSkills(conversation, rule.Skills); err != nil {
+			log.Ctx(ctx).Error().Str("session_id", conversation).Err(err).Msg("Error setting skills")
+		}
+	}
+	if rule != nil && rule.Reply != "" {
+		// Fixed replies never reach the bot
+		log.Ctx(ctx).Info().Str("rule_id", rule.ID).Msg("Auto-responder rule matched")
+		bus.Publish(events.Event{
+			Type:      "auto_response",
+			SessionID: conversation,
+			Data:      map[string]any{"rule_id": rule.ID, "rule_name": rule.Name},
+		})
+		out = botReply{Reply: rule.Reply}
+	} else if !upstreams.Healthy(upstreamName(tenantFrom(ctx))) {
+		out, err = respondDegraded(ctx, conversation, profile, message)
+	} else {
+		out, err = respondUpstream(ctx, conversation, profile, message)
+	}
+	if err != nil {
+		return out, err
+	}
+
+	hc = &hooks.Context{Point: hooks.OnReplyOut, SessionID: conversation, VisitorID: profileID(profile), Message: message, Reply: out.Reply}
+	if err := pipelineHooks.Run(ctx, hc); err != nil {
+		return abortedReply(ctx, err)
+	}
+	out.Reply = hc.Reply
+	return out, nil
+}
+
+// abortedReply turns a hook failure into the reply for the visitor.
+func abortedReply(ctx context.Context, err error) (botReply, error) {
+	var abort *hooks.AbortError
+	if errors.As(err, &abort) {
+		log.Ctx(ctx).Warn().Err(err).Msg("Message processing stopped")
+		if abort.Reply == "" {
+			return botReply{Reply: serverConfig.Hooks.AbortReply}, nil
+		}
+		return botReply{Reply: abort.Reply}, nil
+	}
+	log.Ctx(ctx).Error().Err(err).Msg("Hook error")
+	return botReply{}, &relayError{Reply: defaultApology, Err: err}
+}
+
+// respondUpstream asks the webhook for a reply, running any actions it
+// requests and reporting their results back to it.
+func respondUpstream(ctx context.Context, conversation string, profile *visitor.Profile, message string) (botReply, error) {
+	var out botReply
+	var results []actions.Result
+	for round := 0; ; round++ {
+		var sess *session.Session
+		if conversation != "" {
+			sess, _ = sessions.Get(conversation)
+		}
+		payload := webhookPayload(message, profile, sess)
+		if pc := pageContextFrom(ctx); pc != nil {
+			// One-off messages have no session to keep it on
+			payload["context"] = pc
+		}
+		if id := requestid.From(ctx); id != "" {
+			payload["request_id"] = id
+		}
+		if results != nil {
+			// Report the previous round's action results back to the workflow
+			payload["action_results"] = results
+		}
+
+		hc := &hooks.Context{Point: hooks.BeforeUpstream, SessionID: conversation, VisitorID: profileID(profile), Message: message, Payload: payload}
+		if err := pipelineHooks.Run(ctx, hc); err != nil {
+			return abortedReply(ctx, err)
+		}
+
+		startTyping(ctx)
+		var reply upstreamReply
+		var err error
+		if onDelta := replyChunks(conversation); onDelta != nil {
+			reply, err = askBotStreaming(ctx, hc.Payload, onDelta)
+		} else {
+			reply, err = askBotCached(ctx, hc.Payload)
+		}
+		if err != nil {
+			upstream := upstreamName(tenantFrom(ctx))
+			if errors.Is(err, provider.ErrNotRegistered) {
+				webhookNotRegistered(upstream, err)
+			} else {
+				upstreams.Report(upstream, err)
+			}
+			if round == 0 && !upstreams.Healthy(upstream) {
+				return respondDegraded(ctx, conversation, profile, message)
+			}
+			recordFailure(ctx, conversation, profile, message, err)
+			return botReply{}, err
+		}
+
+		// Remember any variables the workflow asked us to keep
+		if conversation != "" && len(reply.Memory) > 0 {
+			if err := sessions.SetMemory(conversation, reply.Memory); err != nil {
+				log.Ctx(ctx).Error().Str("session_id", conversation).Err(err).Msg("Error updating memory")
+			}
+		}
+
+		hc = &hooks.Context{Point: hooks.AfterUpstream, SessionID: conversation, VisitorID: profileID(profile), Message: message, Reply: reply.Text}
+		if err := pipelineHooks.Run(ctx, hc); err != nil {
+			return abortedReply(ctx, err)
+		}
+		if hc.Reply != "" {
+			out.Reply = hc.Reply
+		}
+		if len(reply.QuickReplies) > 0 {
+			out.QuickReplies = reply.QuickReplies
+		}
+		out.Rich = append(out.Rich, reply.Rich...)
+		if len(reply.Actions) == 0 || round == maxActionRounds {
+			break
+		}
+		var offers []session.QuickReply
+		var elements []rich.Element
+		results, offers, elements = runActions(ctx, conversation, profile, reply.Actions)
+		out.Actions = append(out.Actions, results...)
+		out.Rich = append(out.Rich, elements...)
+		if len(offers) > 0 {
+			out.QuickReplies = offers
+		}
+	}
+
+	if out.Reply == "" {
+		out.Reply = summarizeActions(out.Actions)
+	}
+	return out, nil
+}
+
+// runActions executes the directives from one bot reply in order. Actions
+// can offer quick replies by returning them under "quick_replies" in their
+// output; these are collected and removed from the results. Rich content
+// returned under "rich" is collected as well but stays in the results so
+// the workflow can see what was shown.
+func runActions(ctx context.Context, conversation string, profile *visitor.Profile, directives []actions.Directive) ([]actions.Result, []session.QuickReply, []rich.Element) {
+	visitorID := profileID(profile)
+	results := make([]actions.Result, 0, len(directives))
+	var offers []session.QuickReply
+	var elements []rich.Element
+	for _, d := range directives {
+		result := actionRegistry.Execute(ctx, actions.Call{Directive: d, SessionID: conversation, VisitorID: visitorID})
+		if qrs, ok := result.Output["quick_replies"].([]session.QuickReply); ok {
+			offers = append(offers, qrs...)
+			delete(result.Output, "quick_replies")
+		}
+		if els, ok := result.Output["rich"].([]rich.Element); ok {
+			elements = append(elements, els...)
+		}
+		log.Ctx(ctx).Info().Str("action", d.Action).Bool("ok", result.OK).Str("error", result.Error).Msg("Executed action")
+		bus.Publish(events.Event{
+			Type:      "action_executed",
+			SessionID: conversation,
+			Data:      map[string]any{"action": d.Action, "ok": result.OK, "error": result.Error},
+		})
+		results = append(results, result)
+	}
+	return results, offers, elements
+}
+
+// summarizeActions describes action results when the bot sent no text,
+// using the "message" an action returned if there is one.
+func summarizeActions(results []actions.Result) string {
+	var lines []string
+	for _, r := range results {
+		if msg, ok := r.Output["message"].(string); ok && msg != "" {
+			lines = append(lines, msg)
+		} else if r.OK {
+			lines = append(lines, fmt.Sprintf("Done: %s.", r.Action))
+		} else {
+			lines = append(lines, fmt.Sprintf("Sorry, %s failed.", r.Action))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func respondDegraded(ctx context.Context, conversation string, profile *visitor.Profile, message string) (botReply, error) {
+	visitorID := profileID(profile)
+	resp, err := degradedMode.Respond(conversation, visitorID, message)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Error saving follow-up request")
+	}
+	if resp.FollowUp != nil {
+		bus.Publish(events.Event{
+			Type:      "followup_requested",
+			SessionID: conversation,
+			Data:      map[string]any{"followup_id": resp.FollowUp.ID, "email": resp.FollowUp.Email, "visitor_id": visitorID},
+		})
+	}
+	log.Ctx(ctx).Warn().Str("reply", resp.Reply).Msg("Answered in degraded mode")
+	return botReply{Reply: resp.Reply, System: resp.Banner}, nil
+}
+
+func profileID(profile *visitor.Profile) string {
+	if profile == nil {
+		return ""
+	}
+	return profile.ID
+}
+
+// upstreamReply is what the webhook answered: the reply text, any session
+// variables it wants set, any actions it wants run, any quick replies to
+// offer and any rich content to show.
+type upstreamReply struct {
+	Text         string
+	Memory       map[string]interface{}
+	Actions      []actions.Directive
+	QuickReplies []session.QuickReply
+	Rich         []rich.Element
+}
+
+// webhookPayload builds the JSON body forwarded to n8n for one message.
+// sess is nil for one-off requests.
+func webhookPayload(message string, profile *visitor.Profile, sess *session.Session) map[string]interface{} {
+	payload := map[string]interface{}{"message": message}
+	if prompt := botConfig.Release().Prompt; prompt != "" {
+		payload["prompt"] = prompt
+	}
+	if sess != nil {
+		payload["session_id"] = sess.ID
+		if sess.Test {
+			// Workflows can skip side effects for operators trying them out
+			payload["test"] = true
+		}
+		if len(sess.Memory) > 0 {
+			payload["memory"] = sess.Memory
+		}
+		if sess.Location != nil {
+			payload["location"] = sess.Location
+		}
+		if sess.SharedLocation != nil {
+			payload["shared_location"] = sess.SharedLocation
+		}
+		if sess.Device != nil {
+			payload["device"] = sess.Device
+		}
+		if len(sess.User) > 0 {
+			payload["user"] = sess.User
+		}
+		if sess.Verified != nil {
+			payload["verified"] = sess.Verified
+		}
+		if sess.Context != nil {
+			payload["context"] = sess.Context
+		}
+		if limit := historyLimit(); limit > 0 {
+			summary, turns := conversationHistory(sess.ID, limit)
+			payload["history"] = turns
+			if summary != nil {
+				payload["summary"] = summary.Text
+			}
+		}
+	}
+	if profile != nil {
+		// Let the bot know whether it is talking to a returning visitor
+		v := map[string]interface{}{
+			"id":                profile.ID,
+			"previous_sessions": profile.PreviousSessions(),
+			"returning":         profile.PreviousSessions() > 0,
+		}
+		if len(profile.MergedFrom) > 0 {
+			v["merged_from"] = profile.MergedFrom
+		}
+		payload["visitor"] = v
+	}
+	return payload
+}
+
+// askBot sends payload to the bot and returns the reply extracted from
+// its response.
+func askBot(ctx context.Context, payload map[string]interface{}) (upstreamReply, error) {
+	return askBotStreaming(ctx, payload, nil)
+}
+
+// askBotStreaming is askBot that passes the text of a streamed response to
+// onDelta as it arrives.
+func askBotStreaming(ctx context.Context, payload map[string]interface{}, onDelta func(string)) (upstreamReply, error) {
+	ctx, span := tracing.Start(ctx, "bot.request", trace.SpanKindClient,
+		attribute.String("chatbot.provider", botProviderName), attribute.Bool("chatbot.streaming", onDelta != nil))
+	started := time.Now()
+	bot, route := routeMessage(ctx, payload)
+	if route.Route != "" {
+		span.SetAttributes(attribute.String("chatbot.route", route.Route))
+	}
+	resp, err := bot.SendMessage(ctx, provider.Conversation{Payload: payload, OnDelta: onDelta})
+	var parsed replyparser.Result
+	if err == nil {
+		parsed, err = parseReply(resp)
+	}
+	tracing.End(span, err)
+	took := time.Since(started)
+	clockFrom(ctx).addUpstream(took)
+	chatMetrics.ObserveBotCall(tenantFrom(ctx), botProviderName, resp.Status, took)
+	recordRoute(ctx, route, err != nil, took)
+	called := log.Ctx(ctx).Info().Str("provider", botProviderName).
+		Int64("latency_ms", took.Milliseconds()).Int("webhook_status", resp.Status)
+	if sessionID, ok := payload["session_id"].(string); ok {
+		called = called.Str("session_id", sessionID)
+	}
+	if route.Route != "" {
+		called = called.Str("route", route.Route).Str("routed_by", route.By)
+	}
+	called.Msg("Called the bot")
+	if err != nil {
+		return upstreamReply{}, upstreamFailure(ctx, err)
+	}
+	bodyBytes := resp.Body
+
+	log.Ctx(ctx).Debug().Str("body", string(bodyBytes)).Str("content_type", resp.ContentType).
+		Str("reply_field", parsed.Field).Msg("Raw response body")
+
+	text := parsed.Text
+	if parsed.Empty {
+		text = noResponseReply
+	}
+	return upstreamReply{
+		Text:    truncateReply(text),
+		Memory:  extractMemory(bodyBytes),
+		Actions: extractActions(bodyBytes),
+
+		QuickReplies: extractQuickReplies(bodyBytes),
+		Rich:         extractRich(bodyBytes),
+	}, nil
+}
+
+// upstreamFailure classifies a failed bot call, counts it and returns the
+// error carrying the apology for the visitor.
+func upstreamFailure(ctx context.Context, err error) error {
+	class := provider.Classify(err)
+	chatMetrics.CountBotError(tenantFrom(ctx), botProviderName, string(class))
+	event := log.Ctx(ctx).Error()
+	switch class {
+	case provider.ClassRequestTooLarge, provider.ClassResponseTooLarge, provider.ClassCanceled:
+		event = log.Ctx(ctx).Warn()
+	}
+	event.Str("error_class", string(class)).Err(err).Msg("Bot call failed")
+	reply, ok := upstreamApologies[class]
+	if !ok {
+		reply = defaultApology
+	}
+	return &relayError{Reply: reply, Class: class, Err: err}
+}
+
+// parseReply extracts the reply from a bot response, failing the call as
+// a parse error for broken JSON and as a schema error for JSON without a
+// reply.
+func parseReply(resp provider.Reply) (replyparser.Result, error) {
+	parsed, err := replyParser.Parse(resp.ContentType, resp.Body)
+	switch {
+	case errors.Is(err, replyparser.ErrInvalidJSON):
+		return parsed, fmt.Errorf("%w: %w", provider.ErrParse, err)
+	case errors.Is(err, replyparser.ErrNoReply):
+		return parsed, fmt.Errorf("%w: %w", provider.ErrSchema, err)
+	}
+	return parsed, err
+}
+
+// replyChunks returns the function passing streamed reply text on to the
+// visitor of a conversation as chunk frames, or nil if they are not
+// connected here. Like the final reply, the chunks stop at maxReplyLength.
+func replyChunks(conversation string) func(string) {
+	if conversation == "" || visitorHub.Get(conversation) == nil {
+		return nil
+	}
+	sent := 0
+	return func(delta string) {
+		if serverConfig.Upstream.MaxReplyLength > 0 {
+			if sent >= serverConfig.Upstream.MaxReplyLength {
+				return
+			}
+			if runes := []rune(delta); sent+len(runes) > serverConfig.Upstream.MaxReplyLength {
+				delta = string(runes[:serverConfig.Upstream.MaxReplyLength-sent])
+			}
+			sent += utf8.RuneCountInString(delta)
+		}
+		if err := visitorHub.Post(conversation, fiber.Map{"type": "chunk", "delta": delta}); err != nil {
+			log.Error().Str("session_id", conversation).Err(err).Msg("Error streaming reply")
+		}
+	}
+}
+
+// truncateReply cuts replies longer than maxReplyLength characters short.
+func truncateReply(reply string) string {
+	if serverConfig.Upstream.MaxReplyLength <= 0 || utf8.RuneCountInString(reply) <= serverConfig.Upstream.MaxReplyLength {
+		return reply
+	}
+	runes := []rune(reply)
+	return string(runes[:serverConfig.Upstream.MaxReplyLength]) + "…"
+}
+
+// extractRich returns the "rich" array of a JSON response, checked against
+// the rich content schema. Invalid elements are logged and left out so
+// the widget only ever receives well-formed content.
+func extractRich(bodyBytes []byte) []rich.Element {
+	var resp struct {
+		Rich json.RawMessage `json:"rich"`
+	}
+	if err := json.Unmarshal(bodyBytes, &resp); err != nil || len(resp.Rich) == 0 || string(resp.Rich) == "null" {
+		return nil
+	}
+	elements, errs := rich.Decode(resp.Rich)
+	for _, err := range errs {
+		log.Warn().Err(err).Msg("Dropping invalid rich content from webhook")
+	}
+	return elements
+}
+
+// extractQuickReplies returns the "quick_replies" array of a JSON response.
+// Each entry has a "label" and an optional "value" sent when picked.
+func extractQuickReplies(bodyBytes []byte) []session.QuickReply {
+	var resp struct {
+		QuickReplies []struct {
+			Label string `json:"label"`
+			Value string `json:"value"`
+		} `json:"quick_replies"`
+	}
+	if err := json.Unmarshal(bodyBytes, &resp); err != nil {
+		return nil
+	}
+	var out []session.QuickReply
+	for _, qr := range resp.QuickReplies {
+		if qr.Label != "" {
+			out = append(out, session.QuickReply{Label: qr.Label, Value: qr.Value})
+		}
+	}
+	return out
+}
+
+// extractActions returns the action directives of a JSON response, given
+// either as a single top-level {"action": ..., "params": ...} or as an
+// "actions" array.
+func extractActions(bodyBytes []byte) []actions.Directive {
+	var resp struct {
+		actions.Directive
+		Actions []actions.Directive `json:"actions"`
+	}
+	if err := json.Unmarshal(bodyBytes, &resp); err != nil {
+		return nil
+	}
+	var out []actions.Directive
+	if resp.Action != "" {
+		out = append(out, resp.Directive)
+	}
+	for _, d := range resp.Actions {
+		if d.Action != "" {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// extractMemory returns the "memory" object of a JSON response, used by
+// workflows to set conversation variables. A null value deletes a variable.
+func extractMemory(bodyBytes []byte) map[string]interface{} {
+	var resp struct {
+		Memory map[string]interface{} `json:"memory"`
+	}
+	if err := json.Unmarshal(bodyBytes, &resp); err != nil {
+		return nil
+	}
+	return resp.Memory
+}
+
+// webhookNotRegistered takes an upstream out of service when n8n says its
+// webhook is not registered, which no retry fixes, so visitors are
+// answered in degraded mode until a probe gets a reply again. Without
+// probes nothing would bring the upstream back, so then it counts as any
+// other failure.
+func webhookNotRegistered(upstream string, err error) {
+	if serverConfig.Probe.Interval <= 0 {
+		upstreams.Report(upstream, err)
+		return
+	}
+	if upstreams.Fail(upstream, err) {
+		log.Error().Str("upstream", upstream).Str("webhook_url", upstreamWebhookURL(upstream)).Msg("The n8n webhook is not registered; answering in degraded mode until it is")
+	}
+}
+
+// alertNotRegistered emails the operators how to fix the webhook once the
+// upstream goes unhealthy because n8n does not know it, whether a visitor
+// message or a probe found out.
+func alertNotRegistered(e events.Event) {
+	if e.Type != "upstream_unhealthy" {
+		return
+	}
+	if msg, _ := e.Data["error"].(string); !strings.Contains(msg, provider.ErrNotRegistered.Error()) {
+		return
+	}
+	upstream, _ := e.Data["upstream"].(string)
+	subject := "The n8n webhook is not registered"
+	if upstream != defaultTenant {
+		subject += " for tenant " + upstream
+	}
+	operatorAlerts.Notify(alerts.Alert{
+		Subject: subject,
+		Body:    notRegisteredHint(upstreamWebhookURL(upstream)),
+	})
+}
+
+// notRegisteredHint explains how to get the webhook at url registered.
+func notRegisteredHint(url string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "n8n answers 404 for %s: the webhook is not registered. Visitors are answered in degraded mode meanwhile.\n\n", url)
+	if strings.Contains(url, "/webhook-test/") {
+		b.WriteString("This is a test URL, which n8n only listens on for one call after \"Execute workflow\" is clicked in the editor. " +
+			"Use the production URL, with /webhook/ in place of /webhook-test/, and activate the workflow.\n")
+	} else {
+		b.WriteString("Check that the workflow is active, and that the path and HTTP method of its Webhook node match this URL.\n")
+	}
+	b.WriteString("\nThe bot answers again as soon as a health probe gets a reply.")
+	return b.String()
+}
+
+// probeWebhook returns a health probe that sends a canary message to url,
+// signed with secret if set, and checks the response still honours the reply contract: a 2xx status,
+// a reply that can be extracted, and any required JSON fields present.
+func probeWebhook(url, secret, message string, requiredFields []string) health.ProbeFunc {
+	return func(ctx context.Context) error {
+		body, _ := json.Marshal(map[string]interface{}{"message": message, "probe": true})
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Chatbot-Probe", "1")
+		if secret != "" {
+			signing.Sign(req.Header, secret, body, time.Now())
+		}
+
+		resp, err := upstreamClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		bodyBytes, err := provider.ReadLimited(resp.Body, serverConfig.Upstream.ResponseLimit)
+		if err != nil {
+			return err
+		}
+		if provider.NotRegistered(resp.StatusCode, bodyBytes) {
+			return fmt.Errorf("%w (status %d)", provider.ErrNotRegistered, resp.StatusCode)
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+		}
+
+		if len(requiredFields) > 0 {
+			var fields map[string]interface{}
+			if err := json.Unmarshal(bodyBytes, &fields); err != nil {
+				return fmt.Errorf("response is not a JSON object: %w", err)
+			}
+			for _, f := range requiredFields {
+				if _, ok := fields[f]; !ok {
+					return fmt.Errorf("response is missing field %q", f)
+				}
+			}
+		}
+
+		parsed, err := parseReply(provider.Reply{Body: bodyBytes, ContentType: resp.Header.Get("Content-Type")})
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(parsed.Text) == "" {
+			return errors.New("no usable reply in response")
+		}
+		return nil
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"web-chatbot-backend/internal/rich"
+	"web-chatbot-backend/internal/session"
+)
+
+func TestExtractQuickReplies(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []session.QuickReply
+	}{
+		{
+			name: "labels and values",
+			body: `{"reply": "Which one?", "quick_replies": [{"label": "Yes", "value": "yes"}, {"label": "No"}]}`,
+			want: []session.QuickReply{{Label: "Yes", Value: "yes"}, {Label: "No"}},
+		},
+		{
+			name: "entries without a label are dropped",
+			body: `{"quick_replies": [{"value": "x"}, {"label": "Ok"}]}`,
+			want: []session.QuickReply{{Label: "Ok"}},
+		},
+		{name: "none", body: `{"reply": "Hi"}`},
+		{name: "wrong type", body: `{"quick_replies": "Yes"}`},
+		{name: "plain text", body: `Hi`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractQuickReplies([]byte(tt.body)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractRich(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []rich.Element
+	}{
+		{
+			name: "valid elements",
+			body: `{"reply": "Here", "rich": [{"type": "buttons", "buttons": [{"label": "Shop", "url": "https://example.com"}]}]}`,
+			want: []rich.Element{{Type: rich.TypeButtons, Buttons: []rich.Button{{Label: "Shop", URL: "https://example.com"}}}},
+		},
+		{
+			name: "invalid elements are dropped",
+			body: `{"rich": [{"type": "buttons", "buttons": [{"label": "Run", "url": "javascript:alert(1)"}]}, {"type": "card", "card": {"title": "Tea"}}]}`,
+			want: []rich.Element{{Type: rich.TypeCard, Card: &rich.Card{Title: "Tea"}}},
+		},
+		{name: "none", body: `{"reply": "Hi"}`},
+		{name: "null", body: `{"rich": null}`},
+		{name: "not an array", body: `{"rich": {"type": "card"}}`},
+		{name: "plain text", body: `Hi`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractRich([]byte(tt.body)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
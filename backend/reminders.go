@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/actions"
+	"web-chatbot-backend/internal/apikeys"
+	"web-chatbot-backend/internal/events"
+	"web-chatbot-backend/internal/reminders"
+	"web-chatbot-backend/internal/session"
+	"web-chatbot-backend/internal/webpush"
+)
+
+var visitorReminders *reminders.Store
+
+var (
+	errVisitorAway    = errors.New("visitor is not connected and has no push subscription or email address")
+	errNoReminderTime = errors.New(`"at" (RFC 3339) or "in" (e.g. "24h") is required`)
+	errReminderTooFar = errors.New("reminder is too far ahead")
+)
+
+// reminderDueAt reads when a reminder is due from an RFC 3339 time or a
+// delay such as "24h".
+func reminderDueAt(at, in string) (time.Time, error) {
+	var due time.Time
+	switch {
+	case at != "":
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			return due, fmt.Errorf("invalid at %q", at)
+		}
+		due = t
+	case in != "":
+		d, err := time.ParseDuration(in)
+		if err != nil {
+			return due, fmt.Errorf("invalid in %q", in)
+		}
+		due = time.Now().Add(d)
+	default:
+		return due, errNoReminderTime
+	}
+	if time.Until(due) > serverConfig.Reminders.MaxDelay {
+		return due, errReminderTooFar
+	}
+	return due, nil
+}
+
+// deliverReminder sends a due reminder to the visitor's chat if they are
+// connected, otherwise as a push notification or, failing that, by email.
+func deliverReminder(_ context.Context, r reminders.Reminder) (string, error) {
+	frame := fiber.Map{"type": "reminder", "reminder_id": r.ID, "message": r.Message}
+	for _, id := range reminderSessions(r) {
+		// Only a confirmed delivery counts; a session held by no instance
+		// falls through to push and email
+		err := visitorHub.SendTo(id, frame)
+		if err == nil {
+			sessions.AppendMessage(id, session.RoleBot, r.Message)
+			publishReminder(r, id, "chat")
+			return "chat", nil
+		}
+		if err != errNotConnected {
+			log.Warn().Str("session_id", id).Err(err).Msg("Error sending reminder to chat")
+		}
+	}
+	if pushToVisitor(r.VisitorID, webpush.Notification{Title: "Reminder", Body: r.Message, URL: serverConfig.WebPush.ClickURL, Tag: r.ID}) {
+		publishReminder(r, r.SessionID, "push")
+		return "push", nil
+	}
+	if r.Email != "" && smtpConfig().Addr != "" {
+		if err := actions.SendMail(smtpConfig(), []string{r.Email}, "Reminder", r.Message); err != nil {
+			return "", err
+		}
+		publishReminder(r, r.SessionID, "email")
+		return "email", nil
+	}
+	return "", errVisitorAway
+}
+
+// reminderSessions lists the sessions a reminder may be shown in: the one
+// it was set in, then any other open session of the same visitor.
+func reminderSessions(r reminders.Reminder) []string {
+	var ids []string
+	if r.SessionID != "" {
+		ids = append(ids, r.SessionID)
+	}
+	if r.VisitorID == "" {
+		return ids
+	}
+	open := sessions.List(func(s *session.Session) bool {
+		return s.VisitorID == r.VisitorID && s.ID != r.SessionID &&
+			s.Status != session.StatusClosed && s.Status != session.StatusArchived
+	})
+	for _, s := range open {
+		ids = append(ids, s.ID)
+	}
+	return ids
+}
+
+func publishReminder(r reminders.Reminder, sessionID, channel string) {
+	bus.Publish(events.Event{
+		Type:      "reminder_delivered",
+		SessionID: sessionID,
+		Data:      map[string]any{"reminder_id": r.ID, "visitor_id": r.VisitorID, "channel": channel},
+	})
+}
+
+// remindAction lets the bot schedule a reminder:
+//
+//	{"action": "remind", "params": {"message": "...", "in": "24h"}}
+//
+// or with "at" as an RFC 3339 time. The "email" param or session variable
+// is used if the visitor cannot be reached otherwise.
+func remindAction(ctx context.Context, call actions.Call) (map[string]interface{}, error) {
+	message, _ := call.Params["message"].(string)
+	at, _ := call.Params["at"].(string)
+	in, _ := call.Params["in"].(string)
+	due, err := reminderDueAt(at, in)
+	if err != nil {
+		return nil, err
+	}
+	memory, _ := sessions.Memory(call.SessionID)
+	r, err := visitorReminders.Schedule(reminders.Reminder{
+		SessionID: call.SessionID,
+		VisitorID: call.VisitorID,
+		Message:   message,
+		Email:     stringParam(call.Params, memory, "email"),
+		DueAt:     due,
+	})
+	if err != nil {
+		return nil, err
+	}
+	queueReminder(ctx, r)
+	when := r.DueAt.In(sessionLocation(call.SessionID)).Format("Monday 2 January 15:04 MST")
+	return map[string]interface{}{
+		"reminder_id": r.ID,
+		"due_at":      r.DueAt.Format(time.RFC3339),
+		"message":     fmt.Sprintf("OK, I'll remind you on %s.", when),
+	}, nil
+}
+
+// registerReminderRoutes lets the widget schedule, list and cancel the
+// visitor's reminders.
+func registerReminderRoutes(app *fiber.App) {
+	app.Post("/reminders", requireCaller(apikeys.ScopeReminders), func(c *fiber.Ctx) error {
+		var body struct {
+			SessionID string `json:"session_id"`
+			VisitorID string `json:"visitor_id"`
+			Message   string `json:"message"`
+			At        string `json:"at"`
+			In        string `json:"in"`
+			Email     string `json:"email"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		if body.SessionID != "" {
+			sess, err := sessions.Get(body.SessionID)
+			if err != nil {
+				return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+			}
+			if !ownsSession(sess, body.VisitorID, c.Locals("user")) {
+				return c.Status(403).JSON(fiber.Map{"error": "Session belongs to another visitor"})
+			}
+		}
+		due, err := reminderDueAt(body.At, body.In)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		r, err := visitorReminders.Schedule(reminders.Reminder{
+			SessionID: body.SessionID,
+			VisitorID: body.VisitorID,
+			Message:   body.Message,
+			Email:     body.Email,
+			DueAt:     due,
+		})
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		queueReminder(c.Context(), r)
+		return c.Status(201).JSON(r)
+	})
+
+	app.Get("/reminders", requireCaller(apikeys.ScopeReminders), func(c *fiber.Ctx) error {
+		visitorID := c.Query("visitor_id")
+		if visitorID == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "visitor_id is required"})
+		}
+		return c.JSON(visitorReminders.List(visitorID, reminders.StatusPending))
+	})
+
+	app.Delete("/reminders/:id", requireCaller(apikeys.ScopeReminders), func(c *fiber.Ctx) error {
+		r, err := visitorReminders.Get(c.Params("id"))
+		if err != nil || r.VisitorID != c.Query("visitor_id") {
+			return c.Status(404).JSON(fiber.Map{"error": reminders.ErrNotFound.Error()})
+		}
+		return cancelReminder(c, r.ID)
+	})
+}
+
+// registerReminderAdminRoutes shows every reminder, and lets operators
+// cancel one.
+func registerReminderAdminRoutes(admin fiber.Router) {
+	admin.Get("/reminders", func(c *fiber.Ctx) error {
+		list := visitorReminders.List(c.Query("visitor_id"), reminders.Status(c.Query("status")))
+		return paginate(c, "reminders", list)
+	})
+
+	admin.Delete("/reminders/:id", func(c *fiber.Ctx) error {
+		return cancelReminder(c, c.Params("id"))
+	})
+}
+
+func cancelReminder(c *fiber.Ctx, id string) error {
+	r, err := visitorReminders.Cancel(id)
+	switch {
+	case errors.Is(err, reminders.ErrNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, reminders.ErrNotPending):
+		return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+	case err != nil:
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if jobQueue != nil {
+		if _, err := jobQueue.Cancel(c.Context(), reminderJob, r.ID); err != nil {
+			log.Error().Str("reminder_id", r.ID).Err(err).Msg("Error cancelling reminder job")
+		}
+	}
+	return c.JSON(r)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/archive"
+	"web-chatbot-backend/internal/session"
+)
+
+// Session retention: archived sessions older than
+// CHATBOT_SESSION_RETENTION are deleted. When an archive bucket is configured their transcripts are
+// exported first, and nothing is deleted if the export fails.
+var transcriptArchive archive.Store
+
+func newTranscriptArchive() archive.Store {
+	r := serverConfig.Retention
+	if r.S3Bucket == "" {
+		return nil
+	}
+	return &archive.S3{
+		Endpoint:        r.S3Endpoint,
+		Region:          r.S3Region,
+		Bucket:          r.S3Bucket,
+		AccessKeyID:     r.S3AccessKeyID,
+		SecretAccessKey: r.S3SecretAccessKey,
+		Client:          &http.Client{Timeout: time.Minute},
+	}
+}
+
+// enforceRetention exports and deletes the sessions past retention.
+func enforceRetention(ctx context.Context) {
+	cutoff := time.Now().Add(-serverConfig.Retention.Sessions)
+	expired := sessions.List(func(s *session.Session) bool {
+		return s.Status == session.StatusArchived && s.StatusChangedAt.Before(cutoff)
+	})
+	if len(expired) == 0 {
+		return
+	}
+
+	if transcriptArchive != nil {
+		records := make([]archive.Record, 0, len(expired))
+		for _, s := range expired {
+			history, _ := sessions.History(s.ID)
+			records = append(records, archive.Record{Session: s, Messages: history})
+		}
+		key, err := archive.Export(ctx, transcriptArchive, serverConfig.Retention.Prefix, records, time.Now())
+		if err != nil {
+			log.Error().Int("sessions", len(expired)).Err(err).Msg("Retention export failed, keeping the sessions")
+			return
+		}
+		log.Info().Int("sessions", len(expired)).Str("key", key).Msg("Retention exported sessions")
+	}
+
+	for _, s := range expired {
+		sessions.Delete(s.ID)
+	}
+}
+
+// runRetention enforces retention every serverConfig.Retention.Interval until ctx is
+// cancelled.
+func runRetention(ctx context.Context) {
+	ticker := time.NewTicker(serverConfig.Retention.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			enforceRetention(ctx)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"web-chatbot-backend/internal/config"
+	"web-chatbot-backend/internal/provider"
+	"web-chatbot-backend/internal/routing"
+)
+
+var errRoutingProvider = errors.New("routing to workflows needs the n8n or http provider")
+
+// botRouter is nil unless there is a routing file, and routeProviders has
+// the provider of each of its routes.
+var (
+	botRouter      *routing.Router
+	routeProviders map[string]provider.BotProvider
+)
+
+// With a routing file, visitor messages go to the workflow of the first
+// route they match instead of all to the webhook, e.g. separate sales,
+// support and billing workflows. See package routing for the format.
+//
+// loadRoutes reads the routing file and sets up a provider like the
+// default one for each route.
+func loadRoutes(cfg config.Config) error {
+	router, err := routing.Load(dataFile(cfg.RoutesFile, "routes.json"), upstreamClient)
+	if err != nil || router == nil {
+		return err
+	}
+	if cfg.Provider == "openai" {
+		return errRoutingProvider
+	}
+	providers := make(map[string]provider.BotProvider)
+	for _, route := range router.Routes() {
+		routeCfg := cfg
+		routeCfg.WebhookURL = route.URL
+		p, err := newBotProvider(routeCfg)
+		if err != nil {
+			return err
+		}
+		providers[route.Name] = p
+	}
+	botRouter, routeProviders = router, providers
+	return nil
+}
+
+// routeMessage returns the provider to send payload to. Tenants with a
+// webhook of their own get it, unrouted. Only payloads with a visitor
+// message are routed; the rest, such as summaries, go to the default
+// webhook with no decision.
+func routeMessage(ctx context.Context, payload map[string]interface{}) (provider.BotProvider, routing.Decision) {
+	if p, ok := tenantProvider(tenantFrom(ctx)); ok {
+		return p, routing.Decision{}
+	}
+	message, _ := payload["message"].(string)
+	if botRouter == nil || message == "" || !featureEnabled(featureWorkflowRouting) {
+		return defaultBot(), routing.Decision{}
+	}
+	d := botRouter.Pick(ctx, message, payload)
+	if p, ok := routeProviders[d.Route]; ok {
+		return p, d
+	}
+	return defaultBot(), d
+}
+
+// recordRoute counts a bot call made on the route of d.
+func recordRoute(ctx context.Context, d routing.Decision, failed bool, took time.Duration) {
+	if d.Route == "" {
+		return
+	}
+	botRouter.Record(d.Route, failed, took)
+	chatMetrics.ObserveRoute(tenantFrom(ctx), d.Route, d.By, failed, took)
+}
+
+// registerRoutingRoutes shows the workflow routes with their counts and
+// lets operators check where a message would go.
+func registerRoutingRoutes(admin fiber.Router) {
+	admin.Get("/routes", func(c *fiber.Ctx) error {
+		if botRouter == nil {
+			return c.Status(404).JSON(fiber.Map{"error": "Workflow routing is not configured"})
+		}
+		return c.JSON(fiber.Map{"routes": botRouter.Routes(), "stats": botRouter.Stats()})
+	})
+
+	// Dry run: the classifier is asked, but nothing is sent or counted
+	admin.Post("/routes/test", func(c *fiber.Ctx) error {
+		if botRouter == nil {
+			return c.Status(404).JSON(fiber.Map{"error": "Workflow routing is not configured"})
+		}
+		var body struct {
+			Message string                 `json:"message"`
+			Payload map[string]interface{} `json:"payload"`
+		}
+		if err := c.BodyParser(&body); err != nil || body.Message == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "message is required"})
+		}
+		if body.Payload == nil {
+			body.Payload = make(map[string]interface{})
+		}
+		body.Payload["message"] = body.Message
+		d := botRouter.Pick(c.Context(), body.Message, body.Payload)
+		return c.JSON(fiber.Map{"route": d.Route, "url": d.URL, "by": d.By, "intent": d.Intent})
+	})
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/events"
+	"web-chatbot-backend/internal/rtc"
+	"web-chatbot-backend/internal/session"
+)
+
+// The agent console opens a socket per conversation. Visitor messages,
+// suggested replies and agent replies travel over it, as does the WebRTC
+// signaling between agent and visitor: call requests, consent answers and
+// the offer/answer/candidate exchange.
+var calls = rtc.NewBroker(bus, rtc.KindCobrowse, rtc.KindVoice)
+
+// signalFrame is a frame on the agent console socket or a signaling frame
+// from the visitor:
+//
+//	message      agent replies with Message
+//	rtc_request  agent asks to start a call of Kind
+//	rtc_consent  visitor accepts or declines CallID
+//	rtc_signal   SDP offer/answer or ICE candidate for CallID, relayed as is
+//	rtc_end      either side hangs up CallID
+type signalFrame struct {
+	Type     string          `json:"type"`
+	Kind     string          `json:"kind"`
+	CallID   string          `json:"call_id"`
+	Accepted bool            `json:"accepted"`
+	Signal   json.RawMessage `json:"signal"`
+	Message  string          `json:"message"`
+}
+
+// handleAgentSocket serves the agent console socket for one session.
+func handleAgentSocket(c *websocket.Conn) {
+	sessionID := c.Params("id")
+	agent := c.Query("agent")
+	if _, err := sessions.Get(sessionID); err != nil {
+		c.WriteJSON(fiber.Map{"error": err.Error()})
+		c.Close()
+		return
+	}
+	client := newClient(c, sessionID)
+	c.SetReadLimit(int64(serverConfig.Server.WSReadLimit))
+
+	if old := agentHub.Register(client); old != nil {
+		old.Close()
+	}
+
+	defer func() {
+		agentHub.Unregister(client)
+		for _, call := range calls.EndAll(sessionID, "disconnect") {
+			sendCall(call)
+		}
+		client.Close()
+	}()
+
+	for {
+		var f signalFrame
+		if err := c.ReadJSON(&f); err != nil {
+			break
+		}
+		switch f.Type {
+		case "rtc_request":
+			// Voice calls are for conversations handed to a human
+			if f.Kind == rtc.KindVoice {
+				if sess, err := sessions.Get(sessionID); err != nil || sess.Status != session.StatusWithAgent {
+					client.WriteJSON(fiber.Map{"type": "rtc_error", "error": "voice calls need an escalated conversation"})
+					continue
+				}
+			}
+			call, err := calls.Request(sessionID, f.Kind, agent)
+			if err != nil {
+				client.WriteJSON(fiber.Map{"type": "rtc_error", "error": err.Error()})
+				continue
+			}
+			sendCall(*call)
+		case "rtc_signal", "rtc_end":
+			relaySignal(sessionID, "agent", f)
+		case "message":
+			// Replies typed in, or suggestions picked, in the console
+			sess, err := sessions.Get(sessionID)
+			if err != nil || f.Message == "" {
+				continue
+			}
+			if err := deliverAgentMessage(sess, agent, f.Message); err != nil {
+				client.WriteJSON(fiber.Map{"type": "error", "error": err.Error()})
+			}
+		}
+	}
+}
+
+// handleVisitorSignal handles a signaling frame from the visitor's chat
+// socket.
+func handleVisitorSignal(client *Client, f signalFrame) {
+	switch f.Type {
+	case "rtc_consent":
+		call, err := calls.Answer(client.SessionID, f.CallID, f.Accepted)
+		if err != nil {
+			client.WriteJSON(fiber.Map{"type": "rtc_error", "call_id": f.CallID, "error": err.Error()})
+			return
+		}
+		sendCall(*call)
+	case "rtc_signal", "rtc_end":
+		relaySignal(client.SessionID, "visitor", f)
+	}
+}
+
+// relaySignal forwards signaling from one side to the other, or ends the
+// call. Signaling is only relayed once the visitor has consented.
+func relaySignal(sessionID, from string, f signalFrame) {
+	sender, peer := visitorHub, agentHub
+	if from == "agent" {
+		sender, peer = peer, sender
+	}
+
+	if f.Type == "rtc_end" {
+		call, err := calls.End(sessionID, f.CallID, from)
+		if err != nil {
+			sender.Post(sessionID, fiber.Map{"type": "rtc_error", "call_id": f.CallID, "error": err.Error()})
+			return
+		}
+		sendCall(*call)
+		return
+	}
+
+	if !calls.Connected(sessionID, f.CallID) {
+		sender.Post(sessionID, fiber.Map{"type": "rtc_error", "call_id": f.CallID, "error": "call is not connected"})
+		return
+	}
+	if err := peer.Post(sessionID, fiber.Map{"type": "rtc_signal", "call_id": f.CallID, "signal": f.Signal}); err != nil && err != errNotConnected {
+		log.Warn().Err(err).Msg("write error")
+	}
+}
+
+// sendCall tells both sides about the current state of a call. The visitor
+// is asked for consent when the call is requested.
+func sendCall(call rtc.Call) {
+	frame := fiber.Map{"type": "rtc_call", "call": call}
+	for _, hub := range []*Hub{visitorHub, agentHub} {
+		if err := hub.Post(call.SessionID, frame); err != nil && err != errNotConnected {
+			log.Warn().Err(err).Msg("write error")
+		}
+	}
+}
+
+// recordVoiceCall notes the start and end of voice calls in the
+// transcript, so the conversation history shows when the chat moved to a
+// call and for how long.
+func recordVoiceCall(e events.Event) {
+	var text string
+	switch e.Type {
+	case rtc.KindVoice + "_consent_granted":
+		text = "Voice call started"
+	case rtc.KindVoice + "_ended":
+		seconds, ok := e.Data["duration_seconds"].(int)
+		if !ok {
+			return
+		}
+		text = fmt.Sprintf("Voice call ended after %s", time.Duration(seconds)*time.Second)
+	default:
+		return
+	}
+	if _, err := sessions.AppendMessage(e.SessionID, session.RoleSystem, text); err != nil {
+		log.Error().Str("session_id", e.SessionID).Err(err).Msg("Error recording voice call")
+	}
+}
+
+// registerCallRoutes adds the agent console socket and the call log.
+func registerCallRoutes(admin fiber.Router) {
+	admin.Get("/sessions/:id/calls", func(c *fiber.Ctx) error {
+		if _, err := sessions.Get(c.Params("id")); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"calls": calls.Calls(c.Params("id"))})
+	})
+
+	admin.Get("/sessions/:id/ws", rejectWhileDraining, func(c *fiber.Ctx) error {
+		if !websocket.IsWebSocketUpgrade(c) {
+			return fiber.ErrUpgradeRequired
+		}
+		return c.Next()
+	}, websocket.New(handleAgentSocket, websocket.Config{HandshakeTimeout: serverConfig.Server.HandshakeTimeout}))
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/changelog"
+	"web-chatbot-backend/internal/preflight"
+	"web-chatbot-backend/internal/provider"
+	"web-chatbot-backend/internal/settings"
+)
+
+// Operators can change the webhook URL and switch features off at
+// runtime, without a redeploy. The changes are kept in settings.json in
+// the data directory, so each instance has its own.
+var runtimeSettings *settings.Store
+
+// Features that can be switched at runtime, all on by default
+const (
+	featureAutoResponder     = "auto_responder"
+	featureGreetings         = "greetings"
+	featureResponseCache     = "response_cache"
+	featureWorkflowRouting   = "workflow_routing"
+	featureInject            = "inject"
+	featureEmailVerification = "email_verification"
+)
+
+var features = map[string]string{
+	featureAutoResponder:     "Fixed replies from auto-responder rules",
+	featureGreetings:         "Greetings picked by greeting rules",
+	featureResponseCache:     "Caching bot replies, see CHATBOT_RESPONSE_CACHE_TTL",
+	featureWorkflowRouting:   "Routing messages to several workflows",
+	featureInject:            "Messages posted with POST /sessions/:id/inject",
+	featureEmailVerification: "The verify_email action",
+}
+
+var errNoWebhook = errors.New("the openai provider has no webhook URL")
+
+// featureEnabled reports whether a feature is switched on.
+func featureEnabled(name string) bool {
+	return runtimeSettings == nil || runtimeSettings.Enabled(name, true)
+}
+
+// botProviderMu guards botProvider and webhookURL, which change when
+// operators set a new webhook URL.
+var botProviderMu sync.RWMutex
+
+// defaultBot returns the provider of the default webhook.
+func defaultBot() provider.BotProvider {
+	botProviderMu.RLock()
+	defer botProviderMu.RUnlock()
+	return botProvider
+}
+
+// currentWebhookURL returns the default webhook URL in use.
+func currentWebhookURL() string {
+	botProviderMu.RLock()
+	defer botProviderMu.RUnlock()
+	return webhookURL
+}
+
+// useWebhookURL sends the default tenant's messages to url from now on.
+// An empty url goes back to the configured one.
+func useWebhookURL(url string) error {
+	if botProviderName == "openai" {
+		return errNoWebhook
+	}
+	if url == "" {
+		url = serverConfig.WebhookURL
+	}
+	cfg := serverConfig
+	cfg.WebhookURL = url
+	p, err := newBotProvider(cfg)
+	if err != nil {
+		return err
+	}
+	botProviderMu.Lock()
+	botProvider, webhookURL = p, url
+	botProviderMu.Unlock()
+	registerBotProbe()
+	return nil
+}
+
+// registerBotProbe probes the default bot so a broken workflow shows up
+// in /readyz.
+func registerBotProbe() {
+	if botProviderName == "n8n" {
+		upstreams.Register(defaultTenant, probeWebhook(currentWebhookURL(), webhookSecret, serverConfig.Probe.Message, serverConfig.Probe.RequiredFields))
+	} else {
+		upstreams.Register(defaultTenant, probeBot(serverConfig.Probe.Message, defaultBot))
+	}
+}
+
+// settingsView is the settings as shown to operators.
+func settingsView(s settings.Settings) fiber.Map {
+	names := make([]string, 0, len(features))
+	for name := range features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]fiber.Map, len(names))
+	for i, name := range names {
+		list[i] = fiber.Map{"name": name, "description": features[name], "enabled": featureEnabled(name)}
+	}
+	return fiber.Map{
+		"webhook_url":            currentWebhookURL(),
+		"webhook_url_overridden": s.WebhookURL != "",
+		"features":               list,
+		"updated_by":             s.UpdatedBy,
+		"updated_at":             s.UpdatedAt,
+	}
+}
+
+// registerSettingsRoutes lets operators change the webhook URL and switch
+// features at runtime.
+func registerSettingsRoutes(admin fiber.Router) {
+	admin.Get("/settings", func(c *fiber.Ctx) error {
+		return c.JSON(settingsView(runtimeSettings.Get()))
+	})
+
+	// Changes only what the body names, e.g. {"features": {"greetings":
+	// false}}. A webhook_url of "" goes back to CHATBOT_WEBHOOK_URL.
+	admin.Patch("/settings", func(c *fiber.Ctx) error {
+		var body struct {
+			WebhookURL *string         `json:"webhook_url"`
+			Features   map[string]bool `json:"features"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		for name := range body.Features {
+			if _, ok := features[name]; !ok {
+				return c.Status(400).JSON(fiber.Map{"error": "Unknown feature " + name})
+			}
+		}
+		if body.WebhookURL != nil {
+			if botProviderName == "openai" {
+				return c.Status(400).JSON(fiber.Map{"error": errNoWebhook.Error()})
+			}
+			if err := preflight.URL(*body.WebhookURL, "http", "https")(); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+
+		before, after, err := runtimeSettings.Update(changedBy(c), func(s *settings.Settings) error {
+			if body.WebhookURL != nil {
+				s.WebhookURL = *body.WebhookURL
+			}
+			for name, on := range body.Features {
+				if s.Features == nil {
+					s.Features = make(map[string]bool)
+				}
+				s.Features[name] = on
+			}
+			return nil
+		})
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if after.WebhookURL != before.WebhookURL {
+			if err := useWebhookURL(after.WebhookURL); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			log.Info().Str("webhook_url", currentWebhookURL()).Str("by", changedBy(c)).Msg("Changed the webhook URL")
+		}
+		view := settingsView(after)
+		before.UpdatedBy, before.UpdatedAt = "", nil
+		after.UpdatedBy, after.UpdatedAt = "", nil
+		recordChange(c, "settings", changelog.ActionUpdate, "", before, after)
+		return c.JSON(view)
+	})
+}
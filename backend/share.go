@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/apikeys"
+	"web-chatbot-backend/internal/redact"
+	"web-chatbot-backend/internal/session"
+	"web-chatbot-backend/internal/share"
+)
+
+var shareLinks *share.Signer
+
+var errShareTooLong = errors.New("expires_in is longer than the maximum")
+
+// newShareLinks returns the signer for share links. Without
+// CHATBOT_SHARE_SECRET a random secret is used, so links stop working
+// when the server restarts.
+func newShareLinks() (*share.Signer, error) {
+	if serverConfig.Share.Secret != "" {
+		return share.NewSigner([]byte(serverConfig.Share.Secret)), nil
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	log.Warn().Msg("CHATBOT_SHARE_SECRET is not set; share links will not survive a restart")
+	return share.NewSigner(secret), nil
+}
+
+// shareTranscript responds with a link to the transcript of sess that
+// works without credentials until it expires.
+func shareTranscript(c *fiber.Ctx, sess *session.Session, expiresIn string) error {
+	ttl := serverConfig.Share.TTL
+	if expiresIn != "" {
+		d, err := time.ParseDuration(expiresIn)
+		if err != nil || d <= 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid expires_in"})
+		}
+		ttl = d
+	}
+	if ttl > serverConfig.Share.MaxTTL {
+		return c.Status(400).JSON(fiber.Map{"error": errShareTooLong.Error(), "max": serverConfig.Share.MaxTTL.String()})
+	}
+	expires := time.Now().Add(ttl)
+	token := shareLinks.Token(sess.ID, expires)
+	return c.Status(201).JSON(fiber.Map{
+		"url":        c.BaseURL() + "/share/" + token,
+		"token":      token,
+		"expires_at": expires.UTC().Format(time.RFC3339),
+	})
+}
+
+// redactedHistory returns the transcript with personal data masked in
+// every message.
+func redactedHistory(id string) ([]session.Message, error) {
+	history, err := sessions.History(id)
+	if err != nil {
+		return nil, err
+	}
+	for i := range history {
+		history[i].Text = redact.Text(history[i].Text)
+	}
+	return history, nil
+}
+
+// registerShareRoutes lets a visitor share the transcript of their session,
+// and serves shared transcripts read-only. Email addresses, phone numbers
+// and card numbers are masked in shared transcripts.
+func registerShareRoutes(app *fiber.App) {
+	app.Post("/sessions/:id/share", requireCaller(apikeys.ScopeMessages), func(c *fiber.Ctx) error {
+		var body struct {
+			VisitorID string `json:"visitor_id"`
+			ExpiresIn string `json:"expires_in"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		sess, err := sessions.Get(c.Params("id"))
+		if err != nil || body.VisitorID == "" || sess.VisitorID != body.VisitorID {
+			return c.Status(404).JSON(fiber.Map{"error": session.ErrNotFound.Error()})
+		}
+		return shareTranscript(c, sess, body.ExpiresIn)
+	})
+
+	app.Get("/share/:token", func(c *fiber.Ctx) error {
+		id, expires, err := shareLinks.Verify(c.Params("token"), time.Now())
+		if errors.Is(err, share.ErrExpired) {
+			return c.Status(410).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		history, err := redactedHistory(id)
+		if err != nil {
+			// Deleted since the link was made
+			return c.Status(404).JSON(fiber.Map{"error": share.ErrInvalid.Error()})
+		}
+		c.Set("Cache-Control", "private, no-store")
+		c.Set("X-Robots-Tag", "noindex")
+		return c.JSON(fiber.Map{"messages": history, "expires_at": expires.UTC().Format(time.RFC3339)})
+	})
+}
+
+// registerShareAdminRoutes lets agents share the transcript of any session.
+func registerShareAdminRoutes(admin fiber.Router) {
+	admin.Post("/sessions/:id/share", func(c *fiber.Ctx) error {
+		var body struct {
+			ExpiresIn string `json:"expires_in"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		sess, err := sessions.Get(c.Params("id"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return shareTranscript(c, sess, body.ExpiresIn)
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"web-chatbot-backend/internal/actions"
+	"web-chatbot-backend/internal/rich"
+	"web-chatbot-backend/internal/shopify"
+)
+
+// registerShopActions adds the search_products action.
+//
+// Workflows call {"action": "search_products", "params": {"query": "red shoes", "limit": 5}}
+// and the matches are shown to the visitor as a carousel of product cards.
+func registerShopActions(registry *actions.Registry, store *shopify.Client) {
+	registry.Register("search_products", actions.HandlerFunc(func(ctx context.Context, call actions.Call) (map[string]interface{}, error) {
+		query, _ := call.Params["query"].(string)
+		if query == "" {
+			return nil, fmt.Errorf("missing query")
+		}
+		limit := intParam(call.Params, "limit", 5)
+
+		products, err := store.Search(ctx, query, limit)
+		if err != nil {
+			return map[string]interface{}{"message": "Sorry, I couldn't search the store right now."}, err
+		}
+		if len(products) == 0 {
+			return map[string]interface{}{"products": []string{}, "message": fmt.Sprintf("I couldn't find any products matching %q.", query)}, nil
+		}
+
+		titles := make([]string, 0, len(products))
+		cards := make([]rich.Card, 0, len(products))
+		for _, p := range products {
+			titles = append(titles, p.Title)
+			cards = append(cards, rich.Card{
+				Title:    p.Title,
+				ImageURL: p.ImageURL,
+				Price:    fmt.Sprintf("%.2f %s", p.Price, p.Currency),
+				URL:      p.URL,
+				Buttons:  []rich.Button{{Label: "View product", URL: p.URL}},
+			})
+		}
+		return map[string]interface{}{
+			"products": titles,
+			"message":  "Here's what I found:",
+			"rich":     []rich.Element{rich.Carousel(cards...)},
+		}, nil
+	}))
+}
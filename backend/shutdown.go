@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/handoff"
+	"web-chatbot-backend/internal/store"
+)
+
+var (
+	// draining is set once shutdown has begun
+	draining atomic.Bool
+	// turnsInFlight counts visitor messages still being answered on
+	// WebSocket and event stream connections
+	turnsInFlight atomic.Int64
+)
+
+// rejectWhileDraining turns away new WebSocket and event stream
+// connections once shutdown has begun, so clients retry elsewhere.
+func rejectWhileDraining(c *fiber.Ctx) error {
+	if draining.Load() {
+		c.Set("Retry-After", "1")
+		return c.Status(503).JSON(fiber.Map{"error": "Server is shutting down"})
+	}
+	return c.Next()
+}
+
+// listenAndDrain serves app on addr until a termination signal arrives,
+// or the supervisor asks to hand over to a new worker, then shuts down
+// gracefully: listeners close so no new connections are accepted, messages
+// being answered get until the deadline to finish, sessions are handed
+// over, connected visitors and agents are told to reconnect, open requests
+// are waited for and queued messages are stored. The deadline and when
+// visitors are told to reconnect are in config.Shutdown.
+func listenAndDrain(app *fiber.App, addr string) {
+	ln, err := handoff.Listen(addr, serverConfig.Handoff.ReusePort)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error listening")
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	if supervisor != nil {
+		// The supervisor passes these on as SIGTERM
+		signal.Ignore(syscall.SIGHUP, os.Interrupt)
+	}
+	failed := make(chan error, 1)
+	go func() {
+		if err := app.Listener(ln); err != nil && !draining.Load() {
+			failed <- err
+		}
+	}()
+	if supervisor != nil {
+		if err := supervisor.Ready(); err != nil {
+			log.Error().Err(err).Msg("Error telling the supervisor")
+		}
+	}
+	handingOver := false
+	select {
+	case err := <-failed:
+		log.Fatal().Err(err).Msg("Server stopped")
+	case sig := <-stop:
+		log.Info().Str("signal", sig.String()).Dur("timeout", serverConfig.Shutdown.Timeout).Msg("Shutting down")
+	case <-supervisor.Replaced():
+		handingOver = true
+		log.Info().Dur("timeout", serverConfig.Shutdown.Timeout).Msg("Handing over to a new worker")
+	}
+	signal.Stop(stop)
+
+	draining.Store(true)
+	ctx, cancel := context.WithTimeout(context.Background(), serverConfig.Shutdown.Timeout)
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() { stopped <- app.ShutdownWithContext(ctx) }()
+
+	if !waitForTurns(ctx) {
+		log.Warn().Int64("turns", turnsInFlight.Load()).Msg("Shutdown deadline passed with messages still being answered")
+	}
+	reconnect := fiber.Map{"type": "reconnect", "retry_after": serverConfig.Shutdown.ReconnectAfter.Seconds()}
+	frame := func(*Client) interface{} { return reconnect }
+	if handingOver {
+		// The new worker is already serving, so visitors can come back
+		// at once and resume their session there
+		if tokens := handOver(); tokens != nil {
+			frame = func(c *Client) interface{} {
+				return fiber.Map{"type": "reconnect", "retry_after": 0, "resume_token": tokens[c.SessionID]}
+			}
+		}
+	}
+	visitors := visitorHub.CloseEach(frame, websocket.CloseServiceRestart, "server restarting")
+	agents := agentHub.CloseAll(reconnect, websocket.CloseServiceRestart, "server restarting")
+	log.Info().Int("visitors", visitors).Int("agents", agents).Msg("Disconnected clients")
+
+	if err := <-stopped; err != nil {
+		log.Warn().Err(err).Msg("Requests still open at the shutdown deadline")
+	}
+	if messageStore != nil {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		flushMessages(flushCtx)
+		cancel()
+		if serverConfig.Store.Driver == store.SQLite {
+			checkpointMessageStore(context.Background())
+		}
+	}
+	log.Info().Msg("Server stopped")
+}
+
+// waitForTurns waits until no message is being answered, and reports
+// false if ctx ran out first.
+func waitForTurns(ctx context.Context) bool {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for turnsInFlight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
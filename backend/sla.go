@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"web-chatbot-backend/internal/alerts"
+	"web-chatbot-backend/internal/events"
+	"web-chatbot-backend/internal/sla"
+)
+
+// Service level targets of escalated conversations, see config.SLA
+var slaTimers *sla.Tracker
+
+// alertSLABreach emails the operators about a breached target.
+func alertSLABreach(e events.Event) {
+	if e.Type != "sla_breached" {
+		return
+	}
+	target := "First response"
+	if e.Data["target"] == sla.Resolution {
+		target = "Resolution"
+	}
+	operatorAlerts.Notify(alerts.Alert{
+		Subject: fmt.Sprintf("SLA breached: %s", target),
+		Body:    escalationSummary(e.SessionID),
+	})
+}
+
+// registerSLARoutes exposes the countdowns to the agent console.
+func registerSLARoutes(admin fiber.Router) {
+	admin.Get("/sla", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"timers": slaTimers.Open(time.Now())})
+	})
+
+	admin.Get("/sessions/:id/sla", func(c *fiber.Ctx) error {
+		timer, ok := slaTimers.Get(c.Params("id"), time.Now())
+		if !ok {
+			return c.Status(404).JSON(fiber.Map{"error": "no SLA timer for this session"})
+		}
+		return c.JSON(timer)
+	})
+}
+
+// slaPolicy is the policy of a conversation: its tenant's targets, each
+// falling back to the server's.
+func slaPolicy(sessionID string) sla.Policy {
+	policy := sla.Policy{FirstResponse: serverConfig.SLA.FirstResponse, Resolution: serverConfig.SLA.Resolution}
+	sess, err := sessions.Get(sessionID)
+	if err != nil {
+		return policy
+	}
+	t, ok := registeredTenant(sess.Tenant)
+	if !ok {
+		return policy
+	}
+	if d, err := time.ParseDuration(t.SLAFirstResponse); err == nil {
+		policy.FirstResponse = d
+	}
+	if d, err := time.ParseDuration(t.SLAResolution); err == nil {
+		policy.Resolution = d
+	}
+	return policy
+}
+
+// startSLATimers tracks escalations if any target is set, by the server
+// or, with a tenant registry, possibly by a tenant.
+func startSLATimers() {
+	policy := sla.Policy{FirstResponse: serverConfig.SLA.FirstResponse, Resolution: serverConfig.SLA.Resolution}
+	slaTimers = sla.NewTracker(policy, bus)
+	slaTimers.PolicyFor = slaPolicy
+	if policy.FirstResponse == 0 && policy.Resolution == 0 && tenantRegistry == nil {
+		return
+	}
+	bus.Subscribe(slaTimers.Observe)
+	go slaTimers.Run(context.Background(), 5*time.Second)
+	if operatorAlerts != nil {
+		bus.Subscribe(alertSLABreach)
+	}
+}
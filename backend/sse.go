@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/store"
+	"web-chatbot-backend/internal/visitor"
+)
+
+// streamBody makes write the response body, streamed while write runs.
+// fasthttp sets the CHATBOT_WRITE_TIMEOUT deadline on the connection once,
+// before the body is written, which would cut event streams and long
+// exports short. Instead every write to the client gets the full timeout
+// of its own, so a stream lasts as long as it needs and a client that
+// stops reading is still dropped.
+func streamBody(c *fiber.Ctx, write func(w *bufio.Writer)) {
+	conn := c.Context().Conn()
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		bw := bufio.NewWriterSize(deadlineWriter{conn: conn, w: w, timeout: serverConfig.Server.WriteTimeout}, 16*1024)
+		write(bw)
+		bw.Flush()
+	})
+}
+
+// deadlineWriter passes every write on to the client at once, within
+// timeout from when it starts.
+type deadlineWriter struct {
+	conn    net.Conn
+	w       *bufio.Writer
+	timeout time.Duration
+}
+
+func (d deadlineWriter) Write(p []byte) (int, error) {
+	deadline := time.Time{}
+	if d.timeout > 0 {
+		deadline = time.Now().Add(d.timeout)
+	}
+	if err := d.conn.SetWriteDeadline(deadline); err != nil {
+		return 0, err
+	}
+	n, err := d.w.Write(p)
+	if err == nil {
+		err = d.w.Flush()
+	}
+	return n, err
+}
+
+// handleEventStream serves GET /sse/chat, the chat for visitors whose
+// network blocks WebSockets. It carries the same frames as /ws/chat, each
+// as the data of a server-sent event; the visitor sends their messages to
+// POST /chat with the session ID from the first frame.
+func handleEventStream(c *fiber.Ctx) error {
+	visitorID := c.Query("visitor_id")
+	if visitorID != "" {
+		profile, err := visitors.Touch(visitorID)
+		if err != nil {
+			log.Error().Str("visitor_id", visitorID).Err(err).Msg("Error updating visitor")
+		} else if profile.Banned {
+			log.Warn().Str("visitor_id", visitorID).Msg("Rejected banned visitor")
+			return c.Status(403).JSON(fiber.Map{"error": bannedMessage})
+		}
+	}
+
+	sess := resumeOrCreateSession(requestedSession(c.Query("session_id"), c.Query("resume_token")), visitorID, tenantFrom(c.UserContext()))
+	sessions.SetChannel(sess.ID, store.ChannelSSE)
+	signIn(sess.ID, c.Locals("user"))
+	enrichSession(sess, c.IP(), c.Get("User-Agent"))
+	if visitorID != "" {
+		if _, err := visitors.RecordSession(visitorID, sess.ID); err != nil {
+			log.Error().Str("visitor_id", visitorID).Err(err).Msg("Error recording session")
+		}
+	}
+
+	client := newStreamClient(sess.ID)
+	if old := visitorHub.Register(client); old != nil {
+		old.Replace()
+	}
+	connectedAt := visitorConnected(sess.ID)
+	// Tell the client which session it is in so it can post messages to it
+	// and resume after a reconnect
+	client.WriteJSON(fiber.Map{"type": "session", "session_id": sess.ID, "status": sess.Status})
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+	streamBody(c, func(w *bufio.Writer) {
+		defer func() {
+			client.Close()
+			visitorDisconnected(sess.ID, connectedAt)
+			if visitorHub.Get(sess.ID) != client {
+				// A newer connection took over the session
+				return
+			}
+			visitorHub.Unregister(client)
+			for _, call := range calls.EndAll(sess.ID, "disconnect") {
+				sendCall(call)
+			}
+			if err := sessions.Close(sess.ID, "disconnect"); err != nil {
+				log.Error().Str("session_id", sess.ID).Err(err).Msg("Error closing session")
+			}
+		}()
+
+		ticker := time.NewTicker(serverConfig.SSEKeepalive)
+		defer ticker.Stop()
+		for {
+			var err error
+			select {
+			case <-client.done:
+				// Send what was queued before the close, such as the
+				// reconnect hint on shutdown
+				for {
+					select {
+					case frame := <-client.frames:
+						fmt.Fprintf(w, "data: %s\n\n", frame)
+					default:
+						w.Flush()
+						return
+					}
+				}
+			case frame := <-client.frames:
+				_, err = fmt.Fprintf(w, "data: %s\n\n", frame)
+			case <-ticker.C:
+				_, err = w.WriteString(": keepalive\n\n")
+			}
+			if err == nil {
+				err = w.Flush()
+			}
+			if err != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// streamClient returns the event stream of a session, or nil if it is not
+// connected over one here.
+func streamClient(sessionID string) *Client {
+	if client := visitorHub.Get(sessionID); client != nil && client.Conn == nil {
+		return client
+	}
+	return nil
+}
+
+// answerOnStream answers a message posted to POST /chat on the session's
+// event stream rather than in the response.
+func answerOnStream(ctx context.Context, client *Client, profile *visitor.Profile, message, quickReplyID string) {
+	if err := sessions.MarkRead(client.SessionID, time.Now()); err != nil {
+		log.Ctx(ctx).Error().Str("session_id", client.SessionID).Err(err).Msg("Error marking session read")
+	}
+	if err := answerVisitor(ctx, client, profile, message, quickReplyID); err != nil {
+		log.Ctx(ctx).Error().Str("session_id", client.SessionID).Err(err).Msg("Error answering on the event stream")
+	}
+}
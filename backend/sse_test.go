@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"web-chatbot-backend/internal/config"
+)
+
+// An event stream outlives the server's write timeout as long as it keeps
+// writing, e.g. keepalives.
+func TestEventStreamOutlivesWriteTimeout(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	// Not restored: the stream may still be running when the test ends
+	serverConfig = config.Default()
+	serverConfig.Server.WriteTimeout, serverConfig.SSEKeepalive = timeout, 50*time.Millisecond
+
+	app := fiber.New(fiber.Config{WriteTimeout: timeout, DisableStartupMessage: true})
+	app.Get("/sse/chat", handleEventStream)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	defer app.Shutdown()
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/sse/chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+	}()
+	deadline := time.After(5 * timeout)
+	var sawSession bool
+	keepalivesAfterTimeout := 0
+	start := time.Now()
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatalf("stream closed after %v", time.Since(start))
+			}
+			if strings.Contains(line, `"type":"session"`) {
+				sawSession = true
+			}
+			if line == ": keepalive" && time.Since(start) > 2*timeout {
+				keepalivesAfterTimeout++
+			}
+		case <-deadline:
+			if !sawSession {
+				t.Error("no session frame")
+			}
+			if keepalivesAfterTimeout == 0 {
+				t.Error("no keepalives once the write timeout had passed")
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/session"
+)
+
+// Webhook payloads carry the latest CHATBOT_HISTORY_TURNS turns of the
+// conversation as history. With CHATBOT_MAX_TURNS set instead, the history
+// may grow to that many turns before the older ones are folded into a
+// summary written by the bot.
+//
+// historyLimit is how many turns payloads carry; 0 means none.
+func historyLimit() int {
+	if serverConfig.MaxTurns > 0 {
+		return serverConfig.MaxTurns
+	}
+	return serverConfig.HistoryTurns
+}
+
+// Sessions being summarized right now
+var summarizing sync.Map
+
+// conversationHistory returns the summary and the turns after it to
+// forward with a message, at most limit of them.
+func conversationHistory(id string, limit int) (*session.Summary, []session.Message) {
+	sess, err := sessions.Get(id)
+	if err != nil {
+		return nil, nil
+	}
+	turns, _ := sessions.Turns(id)
+	if len(turns) > limit {
+		turns = turns[len(turns)-limit:]
+	}
+	return sess.Summary, turns
+}
+
+// summarizeIfLong asks the bot to fold the older turns of a conversation
+// into its summary once there are more than maxTurns of them. The most
+// recent half are kept as they are, so this happens every maxTurns/2 turns
+// rather than on every message.
+func summarizeIfLong(id string) {
+	if _, busy := summarizing.LoadOrStore(id, true); busy {
+		return
+	}
+	defer summarizing.Delete(id)
+
+	sess, err := sessions.Get(id)
+	if err != nil {
+		return
+	}
+	turns, _ := sessions.Turns(id)
+	maxTurns := serverConfig.MaxTurns
+	if len(turns) <= maxTurns {
+		return
+	}
+	fold := turns[:len(turns)-max(maxTurns/2, 1)]
+
+	payload := map[string]interface{}{"mode": "summarize", "session_id": id, "messages": fold}
+	if sess.Summary != nil {
+		payload["summary"] = sess.Summary.Text
+	}
+	reply, err := askBot(context.Background(), payload)
+	if err != nil || reply.Text == "" {
+		// The history stays bounded by maxTurns either way
+		log.Error().Str("session_id", id).Err(err).Msg("Summarizing session failed")
+		return
+	}
+	if err := sessions.Summarize(id, reply.Text, fold[len(fold)-1].Time, len(fold)); err != nil {
+		log.Error().Str("session_id", id).Err(err).Msg("Error saving summary")
+		return
+	}
+	log.Info().Int("turns", len(fold)).Str("session_id", id).Msg("Summarized turns")
+}
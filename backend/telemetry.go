@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"web-chatbot-backend/internal/tracing"
+)
+
+// traceRequests starts a server span for every HTTP request, continuing
+// the caller's trace if it sent a traceparent header, and keeps it in the
+// request's user context for the handlers. WebSocket and event stream
+// connections last too long to be one span; their messages are traced
+// one by one instead.
+func traceRequests(c *fiber.Ctx) error {
+	if websocket.IsWebSocketUpgrade(c) || c.Get("Accept") == "text/event-stream" {
+		return c.Next()
+	}
+	h := http.Header{}
+	for _, name := range []string{"Traceparent", "Tracestate", "Baggage"} {
+		if v := c.Get(name); v != "" {
+			h.Set(name, v)
+		}
+	}
+	ctx := tracing.Extract(c.UserContext(), h)
+	ctx, span := tracing.Start(ctx, c.Method(), trace.SpanKindServer,
+		attribute.String("http.request.method", c.Method()), attribute.String("url.path", c.Path()))
+	c.SetUserContext(ctx)
+
+	err := c.Next()
+	status := c.Response().StatusCode()
+	if err != nil {
+		if e, ok := err.(*fiber.Error); ok {
+			status = e.Code
+		}
+	}
+	span.SetName(c.Method() + " " + c.Route().Path)
+	span.SetAttributes(attribute.String("http.route", c.Route().Path), attribute.Int("http.response.status_code", status))
+	spanErr := err
+	if spanErr == nil && status >= 500 {
+		spanErr = fiber.NewError(status, http.StatusText(status))
+	}
+	tracing.End(span, spanErr)
+	return err
+}
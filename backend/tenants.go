@@ -0,0 +1,326 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/changelog"
+	"web-chatbot-backend/internal/provider"
+	"web-chatbot-backend/internal/ratelimit"
+	"web-chatbot-backend/internal/store"
+	"web-chatbot-backend/internal/tenants"
+)
+
+// Tenants other than the default one are kept in the message store, so
+// one backend can serve many websites, each with its own workflow. Every
+// instance reloads them every CHATBOT_TENANT_RELOAD_INTERVAL.
+//
+// tenantRegistry is nil without a message store.
+var tenantRegistry *tenants.Registry
+
+// registeredTenant returns the settings of a tenant from the registry.
+// The default tenant has none.
+func registeredTenant(id string) (store.Tenant, bool) {
+	if tenantRegistry == nil || id == defaultTenant {
+		return store.Tenant{}, false
+	}
+	return tenantRegistry.Get(id)
+}
+
+// useTenant keeps tenant as the one a request is for, in the "tenant"
+// local and the request's user context.
+func useTenant(c *fiber.Ctx, tenant string) {
+	c.Locals("tenant", tenant)
+	c.SetUserContext(withTenant(c.UserContext(), tenant))
+}
+
+// tenantBot is the provider of a tenant's webhook, kept until the webhook
+// changes.
+type tenantBot struct {
+	url, secret string
+	provider    provider.BotProvider
+}
+
+var (
+	tenantBotsMu sync.Mutex
+	tenantBots   = make(map[string]tenantBot)
+)
+
+// tenantProvider returns the provider for the webhook of a tenant, if it
+// has one of its own.
+func tenantProvider(tenant string) (provider.BotProvider, bool) {
+	t, ok := registeredTenant(tenant)
+	if !ok || t.WebhookURL == "" {
+		return nil, false
+	}
+	tenantBotsMu.Lock()
+	defer tenantBotsMu.Unlock()
+	if b, ok := tenantBots[tenant]; ok && b.url == t.WebhookURL && b.secret == t.WebhookSecret {
+		return b.provider, true
+	}
+	// Tenant providers start from the server's own configuration
+	cfg := serverConfig
+	cfg.WebhookURL = t.WebhookURL
+	if t.WebhookSecret != "" {
+		cfg.WebhookSecret = t.WebhookSecret
+	}
+	p, err := newBotProvider(cfg)
+	if err != nil {
+		log.Error().Str("tenant", tenant).Err(err).Msg("Error setting up the tenant's bot provider")
+		return nil, false
+	}
+	tenantBots[tenant] = tenantBot{url: t.WebhookURL, secret: t.WebhookSecret, provider: p}
+	return p, true
+}
+
+// tenantLimiter is the rate limiter of a tenant with limits of its own,
+// kept until they change.
+type tenantLimiter struct {
+	limit, quota int
+	window       time.Duration
+	limiter      *ratelimit.Limiter
+}
+
+var (
+	tenantLimitersMu sync.Mutex
+	tenantLimiters   = make(map[string]tenantLimiter)
+)
+
+// limiterFor returns the message rate limiter of a tenant: its own if it
+// sets any limit, the server's otherwise. Limits it leaves out are the
+// server's.
+func limiterFor(tenant string) *ratelimit.Limiter {
+	t, ok := registeredTenant(tenant)
+	if !ok || (t.RateLimit == 0 && t.RateLimitWindow == "" && t.DailyQuota == 0) {
+		return messageLimiter
+	}
+	limit, window, quota := messageLimiter.Limit, messageLimiter.Window, messageLimiter.Quota
+	if t.RateLimit > 0 {
+		limit = t.RateLimit
+	}
+	if d, err := time.ParseDuration(t.RateLimitWindow); err == nil && d > 0 {
+		window = d
+	}
+	if t.DailyQuota > 0 {
+		quota = t.DailyQuota
+	}
+
+	tenantLimitersMu.Lock()
+	defer tenantLimitersMu.Unlock()
+	if l, ok := tenantLimiters[tenant]; ok && l.limit == limit && l.window == window && l.quota == quota {
+		return l.limiter
+	}
+	l := tenantLimiter{limit: limit, window: window, quota: quota, limiter: ratelimit.New(limit, window, quota)}
+	tenantLimiters[tenant] = l
+	return l.limiter
+}
+
+// pruneTenantLimiters forgets visitors whose tenant rate limits have
+// reset, every interval until ctx is cancelled.
+func pruneTenantLimiters(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			tenantLimitersMu.Lock()
+			for _, l := range tenantLimiters {
+				l.limiter.Prune(now)
+			}
+			tenantLimitersMu.Unlock()
+		}
+	}
+}
+
+// upstreamName is the name the health of a tenant's bot is tracked
+// under: the tenant's own for one with a webhook of its own, the default
+// bot's otherwise.
+func upstreamName(tenant string) string {
+	if t, ok := registeredTenant(tenant); ok && t.WebhookURL != "" {
+		return tenant
+	}
+	return defaultTenant
+}
+
+// upstreamWebhookURL is the webhook an upstream is named after.
+func upstreamWebhookURL(name string) string {
+	if t, ok := registeredTenant(name); ok && t.WebhookURL != "" {
+		return t.WebhookURL
+	}
+	return currentWebhookURL()
+}
+
+// tenantProbes has the webhook each tenant's probe was registered for,
+// so reloads only replace the probes of tenants whose webhook changed.
+type tenantProbe struct{ url, secret string }
+
+var (
+	tenantProbesMu sync.Mutex
+	tenantProbes   = make(map[string]tenantProbe)
+)
+
+// probeTenants keeps a health probe on the webhook of every tenant with
+// one of its own, and drops those of tenants that no longer have one. It
+// runs after every reload of the registry.
+func probeTenants(list []store.Tenant) {
+	tenantProbesMu.Lock()
+	defer tenantProbesMu.Unlock()
+	seen := make(map[string]bool, len(list))
+	for _, t := range list {
+		if t.WebhookURL == "" {
+			continue
+		}
+		seen[t.ID] = true
+		p := tenantProbe{url: t.WebhookURL, secret: t.WebhookSecret}
+		if old, ok := tenantProbes[t.ID]; ok && old == p {
+			continue
+		}
+		tenantProbes[t.ID] = p
+		if botProviderName == "n8n" {
+			secret := cmp.Or(t.WebhookSecret, webhookSecret)
+			upstreams.Register(t.ID, probeWebhook(t.WebhookURL, secret, serverConfig.Probe.Message, serverConfig.Probe.RequiredFields))
+		} else {
+			id := t.ID
+			upstreams.Register(t.ID, probeBot(serverConfig.Probe.Message, func() provider.BotProvider {
+				p, _ := tenantProvider(id)
+				return p
+			}))
+		}
+	}
+	for id := range tenantProbes {
+		if !seen[id] {
+			delete(tenantProbes, id)
+			upstreams.Remove(id)
+		}
+	}
+}
+
+// tenantOrigin reports whether a tenant allows browsers on origin, for
+// origins the server does not allow itself.
+func tenantOrigin(origin string) bool {
+	return tenantRegistry != nil && tenantRegistry.AllowsOrigin(origin)
+}
+
+// startTenantRegistry loads the tenants from the message store and keeps
+// them fresh.
+func startTenantRegistry(ctx context.Context) error {
+	registry, err := tenants.NewRegistry(ctx, messageStore, defaultTenant)
+	if err != nil {
+		return err
+	}
+	tenantRegistry = registry
+	probeTenants(registry.List())
+	registry.OnReload = probeTenants
+	go registry.Run(ctx, serverConfig.TenantReloadInterval)
+	go pruneTenantLimiters(ctx, 10*time.Minute)
+	log.Info().Int("tenants", len(registry.List())).Msg("Loaded tenants")
+	return nil
+}
+
+// publicTenant is a tenant as shown to operators, without its secret.
+func publicTenant(t store.Tenant) fiber.Map {
+	return fiber.Map{
+		"id":                 t.ID,
+		"name":               t.Name,
+		"webhook_url":        t.WebhookURL,
+		"webhook_secret_set": t.WebhookSecret != "",
+		"allowed_origins":    t.AllowedOrigins,
+		"rate_limit":         t.RateLimit,
+		"rate_limit_window":  t.RateLimitWindow,
+		"daily_quota":        t.DailyQuota,
+		"theme":              t.Theme,
+		"greeting":           t.Greeting,
+		"geoip_country_only": t.GeoIPCountryOnly,
+		"sla_first_response": t.SLAFirstResponse,
+		"sla_resolution":     t.SLAResolution,
+		"created_at":         t.CreatedAt,
+		"updated_at":         t.UpdatedAt,
+	}
+}
+
+// registerTenantRoutes lets operators manage the tenants.
+func registerTenantRoutes(admin fiber.Router) {
+	noRegistry := func(c *fiber.Ctx) error {
+		return c.Status(404).JSON(fiber.Map{"error": "The tenant registry needs the message store, see CHATBOT_STORE_DRIVER"})
+	}
+
+	admin.Get("/tenants", func(c *fiber.Ctx) error {
+		if tenantRegistry == nil {
+			return noRegistry(c)
+		}
+		list := tenantRegistry.List()
+		out := make([]fiber.Map, len(list))
+		for i, t := range list {
+			out[i] = publicTenant(t)
+		}
+		return paginate(c, "tenants", out)
+	})
+
+	admin.Get("/tenants/:id", func(c *fiber.Ctx) error {
+		if tenantRegistry == nil {
+			return noRegistry(c)
+		}
+		t, ok := tenantRegistry.Get(c.Params("id"))
+		if !ok {
+			return c.Status(404).JSON(fiber.Map{"error": tenants.ErrNotFound.Error()})
+		}
+		return c.JSON(publicTenant(t))
+	})
+
+	// Replaces the whole tenant; a missing webhook_secret keeps the
+	// current one
+	admin.Put("/tenants/:id", func(c *fiber.Ctx) error {
+		if tenantRegistry == nil {
+			return noRegistry(c)
+		}
+		var t store.Tenant
+		if err := c.BodyParser(&t); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		t.ID = c.Params("id")
+		existing, exists := tenantRegistry.Get(t.ID)
+		if t.WebhookSecret == "" {
+			t.WebhookSecret = existing.WebhookSecret
+		}
+		if err := tenants.Validate(t, defaultTenant); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		saved, err := tenantRegistry.Put(c.Context(), t)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		log.Info().Str("tenant", t.ID).Str("by", changedBy(c)).Msg("Saved tenant")
+		// History shows tenants as operators see them, without the secret
+		status, action, before := 200, changelog.ActionUpdate, any(publicTenant(existing))
+		if !exists {
+			status, action, before = 201, changelog.ActionCreate, nil
+		}
+		recordChange(c, "tenant", action, t.ID, before, publicTenant(saved))
+		return c.Status(status).JSON(publicTenant(saved))
+	})
+
+	admin.Delete("/tenants/:id", requireSecondFactor, func(c *fiber.Ctx) error {
+		if tenantRegistry == nil {
+			return noRegistry(c)
+		}
+		before, _ := tenantRegistry.Get(c.Params("id"))
+		err := tenantRegistry.Delete(c.Context(), c.Params("id"))
+		switch {
+		case errors.Is(err, tenants.ErrNotFound):
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		case err != nil:
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		log.Info().Str("tenant", c.Params("id")).Str("by", changedBy(c)).Msg("Deleted tenant")
+		recordChange(c, "tenant", changelog.ActionDelete, c.Params("id"), publicTenant(before), nil)
+		return c.SendStatus(204)
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/session"
+	"web-chatbot-backend/internal/store"
+	"web-chatbot-backend/internal/visitor"
+)
+
+// isTestSession reports whether id is a test chat, see Session.Test.
+func isTestSession(id string) bool {
+	if id == "" {
+		return false
+	}
+	sess, err := sessions.Get(id)
+	return err == nil && sess.Test
+}
+
+// registerTestChatRoutes lets operators chat with the bot as a simulated
+// visitor. Test chats go through the same pipeline as real ones but are
+// flagged as test in the webhook payload and left out of analytics and
+// the dashboard stats.
+func registerTestChatRoutes(admin fiber.Router) {
+	admin.Post("/test-chats", func(c *fiber.Ctx) error {
+		var body struct {
+			// VisitorID simulates a known visitor; their profile is read but
+			// not updated
+			VisitorID string `json:"visitor_id"`
+		}
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&body); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+			}
+		}
+		if body.VisitorID != "" {
+			if _, err := visitors.Get(body.VisitorID); err != nil {
+				return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+		sess := sessions.CreateTest(body.VisitorID)
+		sessions.SetChannel(sess.ID, store.ChannelTest)
+		sess.Channel = store.ChannelTest
+		resp := fiber.Map{"session": sess}
+		if serverConfig.Widget.Greeting != "" {
+			resp["greeting"] = serverConfig.Widget.Greeting
+		}
+		return c.Status(201).JSON(resp)
+	})
+
+	admin.Post("/test-chats/:id/messages", func(c *fiber.Ctx) error {
+		var body struct {
+			Message      string `json:"message"`
+			QuickReplyID string `json:"quick_reply_id"`
+		}
+		if err := c.BodyParser(&body); err != nil || (body.Message == "" && body.QuickReplyID == "") {
+			return c.Status(400).JSON(fiber.Map{"error": "message is required"})
+		}
+		sess, err := sessions.Get(c.Params("id"))
+		if err != nil || !sess.Test {
+			return c.Status(404).JSON(fiber.Map{"error": "test chat not found"})
+		}
+		if sess.Status == session.StatusClosed || sess.Status == session.StatusArchived {
+			return c.Status(409).JSON(fiber.Map{"error": "test chat is " + string(sess.Status)})
+		}
+		var profile *visitor.Profile
+		if sess.VisitorID != "" {
+			profile, _ = visitors.Get(sess.VisitorID)
+		}
+		if err := sessions.Touch(sess.ID); err != nil {
+			log.Error().Str("session_id", sess.ID).Err(err).Msg("Error touching session")
+		}
+		if err := sessions.Activate(sess.ID); err != nil {
+			log.Error().Str("session_id", sess.ID).Err(err).Msg("Error activating session")
+		}
+
+		var out botReply
+		if body.QuickReplyID != "" {
+			out, err = respondQuickReply(c.UserContext(), sess.ID, profile, body.Message, body.QuickReplyID)
+		} else {
+			out, err = respond(c.UserContext(), sess.ID, profile, body.Message)
+		}
+		if err != nil {
+			return c.Status(502).JSON(fiber.Map{"reply": apology(err), "error": err.Error()})
+		}
+		resp := out.frame()
+		if out.System != "" {
+			resp["system"] = out.System
+		}
+		if len(out.Actions) > 0 {
+			resp["actions"] = out.Actions
+		}
+		return c.JSON(resp)
+	})
+}
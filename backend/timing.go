@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/session"
+)
+
+// turnClock times the phases of answering one visitor message, see
+// session.Timing.
+type turnClock struct {
+	mu       sync.Mutex
+	received time.Time
+	started  time.Time
+	answered time.Time
+	upstream time.Duration
+}
+
+type turnClockKey struct{}
+
+// withTurnClock returns ctx timing a message received at received.
+func withTurnClock(ctx context.Context, received time.Time) context.Context {
+	return context.WithValue(ctx, turnClockKey{}, &turnClock{received: received})
+}
+
+// clockFrom returns the clock in ctx. Its methods do nothing on nil, for
+// replies nobody times, such as test chats.
+func clockFrom(ctx context.Context) *turnClock {
+	clock, _ := ctx.Value(turnClockKey{}).(*turnClock)
+	return clock
+}
+
+// start marks the pipeline picking the message up; later calls, such as
+// a quick reply passed on as a message, are ignored.
+func (t *turnClock) start() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if t.started.IsZero() {
+		t.started = time.Now()
+	}
+	t.mu.Unlock()
+}
+
+// addUpstream adds the duration of one call to the bot.
+func (t *turnClock) addUpstream(d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.upstream += d
+	t.mu.Unlock()
+}
+
+// answer marks the reply being ready to send.
+func (t *turnClock) answer() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.answered = time.Now()
+	t.mu.Unlock()
+}
+
+// timing returns the phases, with delivery lasting until now. It reports
+// false if no reply was made.
+func (t *turnClock) timing() (session.Timing, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.answered.IsZero() {
+		return session.Timing{}, false
+	}
+	now := time.Now()
+	processing := t.answered.Sub(t.started) - t.upstream
+	if processing < 0 {
+		processing = 0
+	}
+	return session.Timing{
+		QueueMS:      t.started.Sub(t.received).Milliseconds(),
+		UpstreamMS:   t.upstream.Milliseconds(),
+		ProcessingMS: processing.Milliseconds(),
+		DeliveryMS:   now.Sub(t.answered).Milliseconds(),
+		TotalMS:      now.Sub(t.received).Milliseconds(),
+	}, true
+}
+
+// recordTiming stores the timing of the reply just delivered in the
+// conversation with the reply, and in the metrics.
+func recordTiming(ctx context.Context, conversation string) {
+	clock := clockFrom(ctx)
+	if clock == nil {
+		return
+	}
+	timing, ok := clock.timing()
+	if !ok {
+		return
+	}
+	chatMetrics.ObserveTiming(tenantFrom(ctx), timing)
+	if conversation == "" {
+		return
+	}
+	if err := sessions.SetTiming(conversation, timing); err != nil {
+		log.Ctx(ctx).Error().Str("session_id", conversation).Err(err).Msg("Error recording reply timing")
+	}
+}
+
+// phaseStats summarizes one phase over many replies, in milliseconds.
+type phaseStats struct {
+	Avg int64 `json:"avg"`
+	P50 int64 `json:"p50"`
+	P95 int64 `json:"p95"`
+	Max int64 `json:"max"`
+}
+
+func summarizePhase(values []int64) phaseStats {
+	if len(values) == 0 {
+		return phaseStats{}
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	var sum int64
+	for _, v := range values {
+		sum += v
+	}
+	return phaseStats{
+		Avg: sum / int64(len(values)),
+		P50: values[len(values)*50/100],
+		P95: values[len(values)*95/100],
+		Max: values[len(values)-1],
+	}
+}
+
+// registerLatencyRoutes shows where the time answering visitors goes.
+func registerLatencyRoutes(admin fiber.Router) {
+	// Phases of the replies in the transcripts in memory, optionally
+	// only those since a time
+	admin.Get("/analytics/latency", func(c *fiber.Ctx) error {
+		var since time.Time
+		if v := c.Query("since"); v != "" {
+			var err error
+			if since, err = time.Parse(time.RFC3339, v); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "since must be an RFC 3339 time"})
+			}
+		}
+		var queue, upstream, processing, delivery, total []int64
+		for _, s := range sessions.List(func(s *session.Session) bool { return !s.Test }) {
+			history, _ := sessions.History(s.ID)
+			for _, m := range history {
+				if m.Timing == nil || m.Time.Before(since) {
+					continue
+				}
+				queue = append(queue, m.Timing.QueueMS)
+				upstream = append(upstream, m.Timing.UpstreamMS)
+				processing = append(processing, m.Timing.ProcessingMS)
+				delivery = append(delivery, m.Timing.DeliveryMS)
+				total = append(total, m.Timing.TotalMS)
+			}
+		}
+		return c.JSON(fiber.Map{
+			"replies": len(total),
+			"phases": fiber.Map{
+				"queue":      summarizePhase(queue),
+				"upstream":   summarizePhase(upstream),
+				"processing": summarizePhase(processing),
+				"delivery":   summarizePhase(delivery),
+				"total":      summarizePhase(total),
+			},
+		})
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"web-chatbot-backend/internal/redact"
+	"web-chatbot-backend/internal/topk"
+)
+
+// Visitors' questions are counted per tenant to show what people are
+// asking right now, see config.TopQuestions.
+var topQuestions *topk.Tracker
+
+// countQuestion counts a visitor message. Personal data is masked first,
+// so it is never kept; test chats are not counted.
+func countQuestion(ctx context.Context, conversation, message string) {
+	if serverConfig.TopQuestions.K <= 0 || isTestSession(conversation) {
+		return
+	}
+	topQuestions.Add(tenantFrom(ctx), redact.Text(message))
+}
+
+// runTopQuestionsDecay halves the question counts every interval until ctx
+// is cancelled.
+func runTopQuestionsDecay(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			topQuestions.Decay()
+		}
+	}
+}
+
+// registerTopQuestionRoutes shows the most frequent questions of each
+// tenant, or of the one in ?tenant=. Counts are estimates, kept in memory
+// by each instance.
+func registerTopQuestionRoutes(admin fiber.Router) {
+	admin.Get("/analytics/top-questions", func(c *fiber.Ctx) error {
+		limit := c.QueryInt("limit", 10)
+		if limit < 1 || limit > serverConfig.TopQuestions.K {
+			limit = serverConfig.TopQuestions.K
+		}
+		tenants := topQuestions.Tenants()
+		if t := c.Query("tenant"); t != "" {
+			tenants = []string{t}
+		}
+		out := make(fiber.Map, len(tenants))
+		for _, t := range tenants {
+			questions, total := topQuestions.Top(t, limit)
+			out[t] = fiber.Map{"questions": questions, "counted": total}
+		}
+		return c.JSON(fiber.Map{"tenants": out})
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"web-chatbot-backend/internal/delivery"
+	"web-chatbot-backend/internal/events"
+	"web-chatbot-backend/internal/session"
+)
+
+// When CHATBOT_TRANSCRIPT_WEBHOOK_URL is set, the full transcript of every
+// session is posted there when the session closes, signed with
+// CHATBOT_TRANSCRIPT_WEBHOOK_SECRET if set. Failed posts are retried like
+// event webhook deliveries.
+var transcriptDeliveries *delivery.Dispatcher
+
+// transcriptDeliveryConfig is the event webhook configuration, sending to
+// the transcript webhook only.
+func transcriptDeliveryConfig() delivery.Config {
+	cfg := deliveryConfig()
+	cfg.Endpoints = []string{serverConfig.Transcripts.WebhookURL}
+	cfg.EventTypes = nil
+	if serverConfig.Transcripts.MaxAttempts > 0 {
+		cfg.MaxAttempts = serverConfig.Transcripts.MaxAttempts
+	}
+	cfg.Secret = serverConfig.Transcripts.Secret
+	return cfg
+}
+
+// transcriptMetrics sums up a conversation for the transcript webhook.
+type transcriptMetrics struct {
+	// Messages counts the messages by role.
+	Messages        map[string]int `json:"messages"`
+	DurationSeconds float64        `json:"duration_seconds"`
+	// FirstResponseMS is how long the first visitor message waited for an
+	// answer from the bot or an agent.
+	FirstResponseMS *int64 `json:"first_response_ms,omitempty"`
+	// AvgReplyMS is the average time the bot took to answer, see
+	// session.Timing.
+	AvgReplyMS int64 `json:"avg_reply_ms,omitempty"`
+	// Failures counts the visitor messages the bot could not answer.
+	Failures int `json:"failures"`
+}
+
+func measureTranscript(sess *session.Session, history []session.Message) transcriptMetrics {
+	m := transcriptMetrics{Messages: make(map[string]int)}
+	end := sess.UpdatedAt
+	if sess.ClosedAt != nil {
+		end = *sess.ClosedAt
+	}
+	m.DurationSeconds = end.Sub(sess.CreatedAt).Seconds()
+
+	var firstVisitor *session.Message
+	var replies, replyMS int64
+	for i := range history {
+		msg := &history[i]
+		m.Messages[msg.Role]++
+		if msg.Failure != "" {
+			m.Failures++
+		}
+		if msg.Timing != nil {
+			replies++
+			replyMS += msg.Timing.TotalMS
+		}
+		switch {
+		case msg.Role == session.RoleVisitor && firstVisitor == nil:
+			firstVisitor = msg
+		case (msg.Role == session.RoleBot || msg.Role == session.RoleAgent) && firstVisitor != nil && m.FirstResponseMS == nil:
+			ms := msg.Time.Sub(firstVisitor.Time).Milliseconds()
+			m.FirstResponseMS = &ms
+		}
+	}
+	if replies > 0 {
+		m.AvgReplyMS = replyMS / replies
+	}
+	return m
+}
+
+// sendTranscript queues the transcript of a session that just closed for
+// the transcript webhook. Test chats are not sent.
+func sendTranscript(e events.Event) {
+	if e.Type != "session_closed" {
+		return
+	}
+	sess, err := sessions.Get(e.SessionID)
+	if err != nil || sess.Test {
+		return
+	}
+	history, _ := sessions.History(sess.ID)
+	transcriptDeliveries.Enqueue(events.Event{
+		ID:        uuid.NewString(),
+		Type:      "session_transcript",
+		SessionID: sess.ID,
+		Time:      e.Time,
+		Data: map[string]any{
+			"reason":   e.Data["reason"],
+			"session":  sess,
+			"messages": history,
+			"summary":  sess.Summary,
+			"metrics":  measureTranscript(sess, history),
+		},
+	})
+}
+
+// registerTranscriptWebhookRoutes shows the transcript webhook deliveries
+// and lets operators send one again.
+func registerTranscriptWebhookRoutes(admin fiber.Router) {
+	admin.Get("/transcript-deliveries", func(c *fiber.Ctx) error {
+		if transcriptDeliveries == nil {
+			return c.Status(404).JSON(fiber.Map{"error": "Transcript webhook is not configured"})
+		}
+		list := transcriptDeliveries.List(delivery.Status(c.Query("status")), "")
+		return paginate(c, "deliveries", list)
+	})
+
+	admin.Post("/transcript-deliveries/:id/redeliver", func(c *fiber.Ctx) error {
+		if transcriptDeliveries == nil {
+			return c.Status(404).JSON(fiber.Map{"error": "Transcript webhook is not configured"})
+		}
+		del, err := transcriptDeliveries.Redeliver(c.Params("id"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(202).JSON(del)
+	})
+}
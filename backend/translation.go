@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/session"
+	"web-chatbot-backend/internal/translate"
+)
+
+// translator translates messages for operators, see config.Translation.
+var translator translate.Translator
+
+// translatedMessage is a transcript message with its translation.
+type translatedMessage struct {
+	session.Message
+	Translation string `json:"translation"`
+	SourceLang  string `json:"source_lang,omitempty"`
+}
+
+// translateTexts translates texts into lang. Texts translated before come
+// from the cache; the rest are sent to the translator in one request.
+func translateTexts(ctx context.Context, texts []string, lang string) ([]translate.Translation, error) {
+	out := make([]translate.Translation, len(texts))
+	keys := make([]string, len(texts))
+	var missing []int
+	for i, text := range texts {
+		sum := sha256.Sum256([]byte(text))
+		keys[i] = lang + ":" + hex.EncodeToString(sum[:])
+		if !translationCache.GetJSON(ctx, keys[i], &out[i]) {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) == 0 {
+		return out, nil
+	}
+
+	batch := make([]string, len(missing))
+	for j, i := range missing {
+		batch[j] = texts[i]
+	}
+	translated, err := translator.Translate(ctx, batch, lang)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range missing {
+		out[i] = translated[j]
+		if err := translationCache.SetJSON(ctx, keys[i], out[i], serverConfig.Translation.CacheTTL); err != nil {
+			log.Error().Err(err).Msg("Error caching translation")
+		}
+	}
+	return out, nil
+}
+
+// translateHistory returns messages with their translations into lang.
+func translateHistory(ctx context.Context, messages []session.Message, lang string) ([]translatedMessage, error) {
+	texts := make([]string, len(messages))
+	for i, m := range messages {
+		texts[i] = m.Text
+	}
+	translations, err := translateTexts(ctx, texts, lang)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]translatedMessage, len(messages))
+	for i, m := range messages {
+		out[i] = translatedMessage{Message: m, Translation: translations[i].Text, SourceLang: translations[i].SourceLang}
+	}
+	return out, nil
+}
+
+// registerTranslationRoutes lets operators read a transcript, or a single
+// message of it, in their own language.
+func registerTranslationRoutes(admin fiber.Router) {
+	admin.Get("/sessions/:id/transcript/translation", func(c *fiber.Ctx) error {
+		history, err := sessions.History(c.Params("id"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		list, err := translateHistory(c.UserContext(), history, c.Query("lang", serverConfig.Translation.Lang))
+		if err != nil {
+			log.Error().Str("session_id", c.Params("id")).Err(err).Msg("Error translating session")
+			return c.Status(502).JSON(fiber.Map{"error": "Could not translate the transcript"})
+		}
+		return paginate(c, "messages", list)
+	})
+
+	// A message is named by its position in the transcript
+	admin.Get("/sessions/:id/messages/:index/translation", func(c *fiber.Ctx) error {
+		history, err := sessions.History(c.Params("id"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		index, err := c.ParamsInt("index")
+		if err != nil || index < 0 || index >= len(history) {
+			return c.Status(400).JSON(fiber.Map{"error": "index is outside the transcript"})
+		}
+		list, err := translateHistory(c.UserContext(), history[index:index+1], c.Query("lang", serverConfig.Translation.Lang))
+		if err != nil {
+			log.Error().Int("index", index).Str("session_id", c.Params("id")).Err(err).Msg("Error translating message")
+			return c.Status(502).JSON(fiber.Map{"error": "Could not translate the message"})
+		}
+		return c.JSON(list[0])
+	})
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/config"
+	"web-chatbot-backend/internal/operators"
+	"web-chatbot-backend/internal/totp"
+)
+
+var twoFactor *totp.Store
+
+// operatorRequired answers second factor requests made with the shared
+// admin token, which belongs to no operator.
+const operatorRequired = "Sign in with an operator token for this"
+
+// Destructive admin requests, such as deleting data or rotating keys,
+// need a one-time code in an X-Admin-OTP header from the operator whose
+// token made them. CHATBOT_ADMIN_2FA sets how strictly:
+//
+//   - "off": never
+//   - "optional": only from operators who set up two-factor
+//     authentication. Once any operator has, the shared admin token can
+//     no longer make them, as it says nothing about who is calling.
+//   - "required": always, so operators without it and the shared admin
+//     token cannot make them
+//
+// secondFactorProblem checks the one-time code on a destructive request
+// and returns the status and error to answer with, or 0 if it may go on.
+func secondFactorProblem(c *fiber.Ctx) (int, string) {
+	if serverConfig.TwoFactor.Admin == config.TwoFactorOff {
+		return 0, ""
+	}
+	required := serverConfig.TwoFactor.Admin == config.TwoFactorRequired
+	operator := signedInOperator(c)
+	if operator == "" {
+		if required || twoFactor.Any() {
+			return 403, "This operation needs an operator token, not the shared admin token"
+		}
+		return 0, ""
+	}
+	if enrolled, _ := twoFactor.Enrolled(operator); !enrolled {
+		if required {
+			return 403, "This operation needs two-factor authentication; set it up first"
+		}
+		return 0, ""
+	}
+	return oneTimeCodeProblem(c, operator)
+}
+
+// approvalProblem guards what lets someone into the admin API: adding
+// operators, issuing their tokens and setting up second factors. Once any
+// operator has a second factor, only an operator with one can do it, with
+// a code, so the shared admin token cannot be used to make up a new
+// operator and pass as them.
+func approvalProblem(c *fiber.Ctx) (int, string) {
+	if serverConfig.TwoFactor.Admin == config.TwoFactorOff || !twoFactor.Any() {
+		return 0, ""
+	}
+	operator := signedInOperator(c)
+	if operator == "" {
+		return 403, "This operation needs an operator token, not the shared admin token"
+	}
+	if enrolled, _ := twoFactor.Enrolled(operator); !enrolled {
+		return 403, "This operation needs an operator with two-factor authentication"
+	}
+	return oneTimeCodeProblem(c, operator)
+}
+
+// oneTimeCodeProblem checks the X-Admin-OTP code of an enrolled operator.
+func oneTimeCodeProblem(c *fiber.Ctx, operator string) (int, string) {
+	code := c.Get("X-Admin-OTP")
+	if code == "" {
+		return 401, "X-Admin-OTP is required for this operation"
+	}
+	recovery, err := twoFactor.Verify(operator, code, time.Now())
+	if errors.Is(err, totp.ErrLocked) {
+		log.Warn().Str("operator", operator).Str("path", c.Path()).Msg("One-time codes locked after too many invalid ones")
+		return 429, err.Error()
+	}
+	if err != nil {
+		log.Warn().Str("operator", operator).Str("path", c.Path()).Err(err).Msg("Rejected one-time code")
+		return 401, "Invalid one-time code"
+	}
+	if recovery {
+		log.Warn().Str("operator", operator).Msg("Recovery code used")
+	}
+	return 0, ""
+}
+
+// requireSecondFactor guards a destructive admin route, see
+// secondFactorProblem.
+func requireSecondFactor(c *fiber.Ctx) error {
+	if status, msg := secondFactorProblem(c); status != 0 {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	return c.Next()
+}
+
+// requireApproval guards a route that lets someone into the admin API,
+// see approvalProblem.
+func requireApproval(c *fiber.Ctx) error {
+	if status, msg := approvalProblem(c); status != 0 {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	return c.Next()
+}
+
+// registerTwoFactorRoutes lets operators set up and remove their second
+// factor. The operator is the one whose token made the request.
+func registerTwoFactorRoutes(admin fiber.Router) {
+	admin.Get("/2fa", func(c *fiber.Ctx) error {
+		name := signedInOperator(c)
+		if name == "" {
+			return c.Status(400).JSON(fiber.Map{"error": operatorRequired})
+		}
+		enrolled, left := twoFactor.Enrolled(name)
+		return c.JSON(fiber.Map{"operator": name, "enrolled": enrolled, "recovery_codes_left": left, "enforcement": serverConfig.TwoFactor.Admin})
+	})
+
+	// Returns the secret to add to an authenticator app; the operator
+	// POSTs a code from the app to /2fa/confirm to finish. Only the first
+	// operator sets it up on their own. After that, an operator who has it
+	// starts the enrollment of another, named in "operator", and confirms
+	// with their own code, see approvalProblem.
+	admin.Post("/2fa/enroll", requireApproval, func(c *fiber.Ctx) error {
+		var body struct {
+			Operator string `json:"operator"`
+		}
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&body); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+			}
+		}
+		name := body.Operator
+		if name == "" {
+			name = signedInOperator(c)
+		}
+		if name == "" {
+			return c.Status(400).JSON(fiber.Map{"error": operatorRequired})
+		}
+		if !adminOperators.Exists(name) {
+			return c.Status(404).JSON(fiber.Map{"error": operators.ErrNotFound.Error()})
+		}
+		secret, uri, err := twoFactor.Enroll(name, time.Now())
+		switch {
+		case errors.Is(err, totp.ErrAlreadyEnrolled):
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		case err != nil:
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		log.Info().Str("operator", name).Str("by", changedBy(c)).Msg("Two-factor enrollment started")
+		return c.Status(201).JSON(fiber.Map{"secret": secret, "otpauth_url": uri})
+	})
+
+	// The recovery codes are only ever returned here
+	admin.Post("/2fa/confirm", func(c *fiber.Ctx) error {
+		name := signedInOperator(c)
+		if name == "" {
+			return c.Status(400).JSON(fiber.Map{"error": operatorRequired})
+		}
+		var body struct {
+			Code string `json:"code"`
+		}
+		if err := c.BodyParser(&body); err != nil || body.Code == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "code is required"})
+		}
+		codes, err := twoFactor.Confirm(name, body.Code, time.Now())
+		switch {
+		case errors.Is(err, totp.ErrNotStarted):
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		case errors.Is(err, totp.ErrAlreadyEnrolled):
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		case errors.Is(err, totp.ErrInvalidCode):
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		case errors.Is(err, totp.ErrLocked):
+			return c.Status(429).JSON(fiber.Map{"error": err.Error()})
+		case err != nil:
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		log.Info().Str("operator", name).Msg("Two-factor authentication set up")
+		return c.JSON(fiber.Map{"recovery_codes": codes})
+	})
+
+	// Removes an operator's second factor, e.g. one who lost both the app
+	// and the recovery codes. The caller confirms with their own code.
+	admin.Delete("/2fa/:operator", requireSecondFactor, func(c *fiber.Ctx) error {
+		name := c.Params("operator")
+		err := twoFactor.Remove(name)
+		switch {
+		case errors.Is(err, totp.ErrNotEnrolled):
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		case err != nil:
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		log.Info().Str("operator", name).Str("by", changedBy(c)).Msg("Two-factor authentication removed")
+		return c.SendStatus(204)
+	})
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"web-chatbot-backend/internal/config"
+	"web-chatbot-backend/internal/operators"
+	"web-chatbot-backend/internal/totp"
+)
+
+// authenticator plays an operator's authenticator app, handing out each
+// code once like the server accepts them.
+type authenticator struct {
+	secret   []byte
+	last     uint64
+	recovery []any
+}
+
+func (a *authenticator) code() string {
+	counter := uint64(time.Now().Unix()/int64(totp.Period/time.Second)) - 1
+	if counter <= a.last {
+		counter = a.last + 1
+	}
+	a.last = counter
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, a.secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff%1_000_000)
+}
+
+func newTwoFactorApp(t *testing.T, mode string) *fiber.App {
+	t.Helper()
+	var err error
+	serverConfig = config.Default()
+	serverConfig.TwoFactor.Admin = mode
+	serverConfig.AdminToken = "root"
+	dir := t.TempDir()
+	if adminOperators, err = operators.NewStore(filepath.Join(dir, "operators.json")); err != nil {
+		t.Fatal(err)
+	}
+	if twoFactor, err = totp.NewStore(filepath.Join(dir, "two_factor.json"), "Chatbot"); err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	admin := app.Group("/admin/v1", requireAdmin)
+	registerOperatorRoutes(admin)
+	registerTwoFactorRoutes(admin)
+	admin.Delete("/things/:id", requireSecondFactor, func(c *fiber.Ctx) error {
+		return c.SendStatus(204)
+	})
+	return app
+}
+
+// adminCall makes an admin request and returns the status and decoded body.
+func adminCall(t *testing.T, app *fiber.App, method, path, token string, headers map[string]string, body string) (int, map[string]any) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out map[string]any
+	json.NewDecoder(resp.Body).Decode(&out)
+	return resp.StatusCode, out
+}
+
+// addOperator has the caller with token by add name, and returns the
+// new operator's token.
+func addOperator(t *testing.T, app *fiber.App, name string, headers map[string]string, by string) string {
+	t.Helper()
+	status, out := adminCall(t, app, "POST", "/admin/v1/operators", by, headers, `{"name": "`+name+`"}`)
+	if status != 201 {
+		t.Fatalf("adding %s: %d %v", name, status, out)
+	}
+	return out["token"].(string)
+}
+
+// enroll sets up a second factor for name, started by by.
+func enroll(t *testing.T, app *fiber.App, name, token, by string, headers map[string]string) *authenticator {
+	t.Helper()
+	status, out := adminCall(t, app, "POST", "/admin/v1/2fa/enroll", by, headers, `{"operator": "`+name+`"}`)
+	if status != 201 {
+		t.Fatalf("enrolling %s: %d %v", name, status, out)
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(out["secret"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app2fa := &authenticator{secret: secret}
+	status, out = adminCall(t, app, "POST", "/admin/v1/2fa/confirm", token, nil, `{"code": "`+app2fa.code()+`"}`)
+	if status != 200 {
+		t.Fatalf("confirming %s: %d %v", name, status, out)
+	}
+	app2fa.recovery = out["recovery_codes"].([]any)
+	return app2fa
+}
+
+func TestSecondFactorOptional(t *testing.T) {
+	app := newTwoFactorApp(t, config.TwoFactorOptional)
+
+	// Before anyone has a second factor, the shared token does it all
+	if status, _ := adminCall(t, app, "DELETE", "/admin/v1/things/1", "root", nil, ""); status != 204 {
+		t.Fatalf("shared token before any enrollment: %d", status)
+	}
+	alice := addOperator(t, app, "alice", nil, "root")
+	aliceApp := enroll(t, app, "alice", alice, alice, nil)
+
+	// Naming an enrolled operator, or none, no longer gets past with the
+	// shared token
+	for _, headers := range []map[string]string{nil, {"X-Admin-User": "alice"}, {"X-Admin-User": "nobody"}} {
+		if status, _ := adminCall(t, app, "DELETE", "/admin/v1/things/1", "root", headers, ""); status != 403 {
+			t.Errorf("shared token with %v: %d, want 403", headers, status)
+		}
+	}
+	// Nor can it make up an operator, or start a second factor for one
+	if status, _ := adminCall(t, app, "POST", "/admin/v1/operators", "root", nil, `{"name": "mallory"}`); status != 403 {
+		t.Errorf("shared token adding an operator: %d, want 403", status)
+	}
+	if status, _ := adminCall(t, app, "POST", "/admin/v1/2fa/enroll", "root", nil, `{"operator": "alice"}`); status != 403 {
+		t.Errorf("shared token starting an enrollment: %d, want 403", status)
+	}
+
+	if status, _ := adminCall(t, app, "DELETE", "/admin/v1/things/1", alice, nil, ""); status != 401 {
+		t.Errorf("alice without a code: %d, want 401", status)
+	}
+	if status, _ := adminCall(t, app, "DELETE", "/admin/v1/things/1", alice, map[string]string{"X-Admin-OTP": aliceApp.code()}, ""); status != 204 {
+		t.Errorf("alice with a code: %d, want 204", status)
+	}
+
+	// Alice lets bob in. Without a second factor of his own, bob can make
+	// destructive requests but not let anyone else in.
+	bob := addOperator(t, app, "bob", map[string]string{"X-Admin-OTP": aliceApp.code()}, alice)
+	if status, _ := adminCall(t, app, "DELETE", "/admin/v1/things/1", bob, nil, ""); status != 204 {
+		t.Errorf("bob without a second factor: %d, want 204", status)
+	}
+	if status, _ := adminCall(t, app, "POST", "/admin/v1/2fa/enroll", bob, nil, ""); status != 403 {
+		t.Errorf("bob enrolling himself: %d, want 403", status)
+	}
+	if status, _ := adminCall(t, app, "POST", "/admin/v1/operators", bob, nil, `{"name": "mallory"}`); status != 403 {
+		t.Errorf("bob adding an operator: %d, want 403", status)
+	}
+}
+
+func TestSecondFactorRequired(t *testing.T) {
+	app := newTwoFactorApp(t, config.TwoFactorRequired)
+
+	if status, _ := adminCall(t, app, "DELETE", "/admin/v1/things/1", "root", nil, ""); status != 403 {
+		t.Errorf("shared token: %d, want 403", status)
+	}
+	alice := addOperator(t, app, "alice", nil, "root")
+	if status, _ := adminCall(t, app, "DELETE", "/admin/v1/things/1", alice, nil, ""); status != 403 {
+		t.Errorf("alice before enrolling: %d, want 403", status)
+	}
+	aliceApp := enroll(t, app, "alice", alice, alice, nil)
+	// A recovery code works once, in place of one from the app
+	recovery := map[string]string{"X-Admin-OTP": aliceApp.recovery[0].(string)}
+	if status, _ := adminCall(t, app, "DELETE", "/admin/v1/things/1", alice, recovery, ""); status != 204 {
+		t.Errorf("alice with a recovery code: %d, want 204", status)
+	}
+	if status, _ := adminCall(t, app, "DELETE", "/admin/v1/things/1", alice, recovery, ""); status != 401 {
+		t.Errorf("alice with a used recovery code: %d, want 401", status)
+	}
+
+	// New operators need alice's code, and so does their enrollment
+	bob := addOperator(t, app, "bob", map[string]string{"X-Admin-OTP": aliceApp.code()}, alice)
+	bobApp := enroll(t, app, "bob", bob, alice, map[string]string{"X-Admin-OTP": aliceApp.code()})
+	if status, _ := adminCall(t, app, "DELETE", "/admin/v1/things/1", bob, map[string]string{"X-Admin-OTP": bobApp.code()}, ""); status != 204 {
+		t.Errorf("bob with a code: %d, want 204", status)
+	}
+}
+
+func TestSecondFactorLocksOutGuessing(t *testing.T) {
+	app := newTwoFactorApp(t, config.TwoFactorRequired)
+	alice := addOperator(t, app, "alice", nil, "root")
+	aliceApp := enroll(t, app, "alice", alice, alice, nil)
+
+	for range totp.MaxFailures {
+		if status, _ := adminCall(t, app, "DELETE", "/admin/v1/things/1", alice, map[string]string{"X-Admin-OTP": "000000"}, ""); status != 401 {
+			t.Fatalf("wrong code: %d, want 401", status)
+		}
+	}
+	if status, _ := adminCall(t, app, "DELETE", "/admin/v1/things/1", alice, map[string]string{"X-Admin-OTP": aliceApp.code()}, ""); status != 429 {
+		t.Errorf("right code after too many wrong ones: %d, want 429", status)
+	}
+}
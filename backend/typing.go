@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// typingIndicator tells a connected client the bot is typing while its
+// webhook is called, so the widget can show it. Replies from rules, the
+// cache or degraded mode come straight away and never set it.
+type typingIndicator struct {
+	client *Client
+
+	mu     sync.Mutex
+	typing bool
+}
+
+type typingKey struct{}
+
+// withTyping returns a copy of ctx in which the bot's typing state is sent
+// to client.
+func withTyping(ctx context.Context, client *Client) (context.Context, *typingIndicator) {
+	t := &typingIndicator{client: client}
+	return context.WithValue(ctx, typingKey{}, t), t
+}
+
+// startTyping sends {"type":"typing","state":true} to the client the
+// message in ctx came from, if any, unless it was already sent.
+func startTyping(ctx context.Context) {
+	if t, ok := ctx.Value(typingKey{}).(*typingIndicator); ok {
+		t.set(true)
+	}
+}
+
+// stop clears the typing state, if it was set.
+func (t *typingIndicator) stop() {
+	t.set(false)
+}
+
+func (t *typingIndicator) set(typing bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.typing == typing {
+		return
+	}
+	t.typing = typing
+	if err := t.client.WriteJSON(fiber.Map{"type": "typing", "state": typing}); err != nil {
+		log.Debug().Str("session_id", t.client.SessionID).Err(err).Msg("Error sending typing state")
+	}
+}
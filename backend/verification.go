@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/actions"
+	"web-chatbot-backend/internal/events"
+	"web-chatbot-backend/internal/session"
+	"web-chatbot-backend/internal/verify"
+)
+
+// Workflows verify a visitor's email address with the verify_email
+// action: the visitor is emailed a one-time code and types it into the
+// chat. verificationCodes keeps the codes, see config.Verify.
+var verificationCodes *verify.Codes
+
+// Replies to codes typed into the chat
+const (
+	verifiedReply       = "Thanks, your email address is verified."
+	wrongCodeReply      = "That code is not right. Please check the email and try again."
+	codeExpiredReply    = "That code is no longer valid. Ask me to send a new one."
+	verificationCodeLog = "[verification code]"
+)
+
+var (
+	errNoMailer        = errors.New("email is not configured")
+	errInvalidEmail    = errors.New("a valid email address is required")
+	errNoSession       = errors.New("verification needs a session")
+	errVerificationOff = errors.New("email verification is switched off")
+)
+
+// codePattern matches a message that is only a verification code.
+var codePattern = regexp.MustCompile(fmt.Sprintf(`^\s*(\d{%d})\s*$`, verify.CodeLength))
+
+// verifyEmailAction lets the bot start verifying the visitor's email
+// address, given in the "email" param or session variable:
+//
+//	{"action": "verify_email", "params": {"email": "ada@example.com"}}
+//
+// The visitor then types the code into the chat. Once it is right, the
+// session's "verified" is sent with every webhook call.
+func verifyEmailAction(_ context.Context, call actions.Call) (map[string]interface{}, error) {
+	if call.SessionID == "" {
+		return nil, errNoSession
+	}
+	if !featureEnabled(featureEmailVerification) {
+		return nil, errVerificationOff
+	}
+	if smtpConfig().Addr == "" || smtpConfig().From == "" {
+		return nil, errNoMailer
+	}
+	memory, _ := sessions.Memory(call.SessionID)
+	addr, err := mail.ParseAddress(stringParam(call.Params, memory, "email"))
+	if err != nil {
+		return nil, errInvalidEmail
+	}
+	code, err := verificationCodes.Issue(call.SessionID, addr.Address, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	body := fmt.Sprintf("Your verification code is %s. It is valid for %s.\n\nIf you did not ask for it, you can ignore this email.",
+		code, serverConfig.Verify.CodeTTL)
+	if err := actions.SendMail(smtpConfig(), []string{addr.Address}, serverConfig.Verify.Subject, body); err != nil {
+		return nil, err
+	}
+	bus.Publish(events.Event{
+		Type:      "verification_requested",
+		SessionID: call.SessionID,
+		Data:      map[string]any{"visitor_id": call.VisitorID},
+	})
+	return map[string]interface{}{
+		"status":  "code_sent",
+		"message": fmt.Sprintf("I've sent a %d-digit code to %s. Please type it here.", verify.CodeLength, maskEmail(addr.Address)),
+	}, nil
+}
+
+// takeVerificationCode answers a message that is the code the visitor was
+// sent, without passing it to the bot. It reports false for any other
+// message.
+func takeVerificationCode(ctx context.Context, conversation, message string) (botReply, bool) {
+	now := time.Now()
+	m := codePattern.FindStringSubmatch(message)
+	if m == nil || !verificationCodes.Pending(conversation, now) {
+		return botReply{}, false
+	}
+	sessions.AppendMessage(conversation, session.RoleVisitor, verificationCodeLog)
+	email, err := verificationCodes.Check(conversation, m[1], now)
+	switch {
+	case errors.Is(err, verify.ErrWrongCode):
+		return botReply{Reply: wrongCodeReply}, true
+	case err != nil:
+		log.Ctx(ctx).Info().Str("session_id", conversation).Err(err).Msg("Verification failed")
+		return botReply{Reply: codeExpiredReply}, true
+	}
+	if err := sessions.SetVerified(conversation, email, now); err != nil {
+		log.Ctx(ctx).Error().Str("session_id", conversation).Err(err).Msg("Error recording verification")
+		return botReply{Reply: codeExpiredReply}, true
+	}
+	bus.Publish(events.Event{
+		Type:      "visitor_verified",
+		SessionID: conversation,
+		Data:      map[string]any{"email": email},
+	})
+	return botReply{Reply: verifiedReply}, true
+}
+
+// maskEmail hides most of the local part of an address, e.g.
+// "a***@example.com".
+func maskEmail(addr string) string {
+	local, domain, ok := strings.Cut(addr, "@")
+	if !ok || local == "" {
+		return addr
+	}
+	return local[:1] + "***@" + domain
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"web-chatbot-backend/internal/changelog"
+	"web-chatbot-backend/internal/greetings"
+	"web-chatbot-backend/internal/session"
+)
+
+// widgetConfig is what the embedded widget needs to render itself.
+type widgetConfig struct {
+	Title       string `json:"title"`
+	Placeholder string `json:"placeholder"`
+	// Greeting is shown as the first bot message of a new conversation.
+	Greeting    string `json:"greeting,omitempty"`
+	PushEnabled bool   `json:"push_enabled"`
+}
+
+// defaultWidget is the widget as configured, before greeting rules.
+func defaultWidget() widgetConfig {
+	w := serverConfig.Widget
+	return widgetConfig{Title: w.Title, Placeholder: w.Placeholder, Greeting: w.Greeting}
+}
+
+// Greeting rules, tried before falling back to the tenant's greeting and
+// then CHATBOT_GREETING
+var greetingRules *greetings.Set
+
+// pickGreeting chooses the greeting for the visitor opening the widget from
+// the greeting rules. The widget passes the page's referrer and the
+// visitor's IANA time zone as referrer and tz.
+func pickGreeting(c *fiber.Ctx) string {
+	v := greetingVisitor(tenantFrom(c.UserContext()), c.Query("visitor_id"), c.Query("referrer"), c.Query("tz"))
+	if !featureEnabled(featureGreetings) {
+		return defaultGreeting(v.Tenant)
+	}
+	if rule := greetingRules.Pick(v); rule != nil {
+		return rule.Text
+	}
+	return defaultGreeting(v.Tenant)
+}
+
+// defaultGreeting is the greeting of tenant's visitors no rule matches:
+// the tenant's own, else CHATBOT_GREETING.
+func defaultGreeting(tenant string) string {
+	if t, ok := registeredTenant(tenant); ok && t.Greeting != "" {
+		return t.Greeting
+	}
+	return serverConfig.Widget.Greeting
+}
+
+// greetingVisitor describes the visitor of tenant for picking a greeting.
+func greetingVisitor(tenant, visitorID, referrer, tz string) greetings.Visitor {
+	v := greetings.Visitor{Tenant: tenant, Referrer: referrer}
+	if visitorID != "" {
+		if profile, err := visitors.Get(visitorID); err == nil {
+			v.Returning = len(profile.SessionIDs) > 0
+		}
+	}
+	if tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			log.Warn().Str("tz", tz).Err(err).Msg("Ignoring unknown time zone")
+		} else {
+			v.LocalTime = time.Now().In(loc)
+		}
+	}
+	return v
+}
+
+// bootstrapHistory is how many recent messages the bootstrap response
+// carries.
+const bootstrapHistory = 20
+
+// handleBootstrap returns everything the widget needs on open in one
+// response: its config, the greeting and, when it is resuming a session of
+// the same visitor, that session's recent history and unread count.
+func handleBootstrap(c *fiber.Ctx) error {
+	cfg := defaultWidget()
+	cfg.PushEnabled = pushSender != nil
+	cfg.Greeting = pickGreeting(c)
+	tenant, _ := c.Locals("tenant").(string)
+	resp := fiber.Map{"config": cfg, "tenant": tenant}
+	if t, ok := registeredTenant(tenant); ok {
+		resp["name"] = t.Name
+		if len(t.Theme) > 0 {
+			resp["theme"] = t.Theme
+		}
+	}
+	if cfg.Greeting != "" {
+		resp["greeting"] = cfg.Greeting
+	}
+
+	visitorID := c.Query("visitor_id")
+	sess, err := sessions.Get(c.Query("session_id"))
+	// Only hand a transcript to the visitor it belongs to
+	if err != nil || visitorID == "" || sess.VisitorID != visitorID || sess.Status == session.StatusArchived {
+		return c.JSON(resp)
+	}
+	history, _ := sessions.History(sess.ID)
+	if len(history) > bootstrapHistory {
+		history = history[len(history)-bootstrapHistory:]
+	}
+	unread, _ := sessions.Unread(sess.ID)
+	resp["session"] = fiber.Map{"id": sess.ID, "status": sess.Status}
+	resp["unread"] = unread
+	resp["history"] = history
+	return c.JSON(resp)
+}
+
+// registerGreetingRoutes manages the greeting rules. Rules are tried in the
+// order they were created.
+func registerGreetingRoutes(admin fiber.Router) {
+	admin.Get("/greetings", conditional, func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"greetings": greetingRules.List()})
+	})
+
+	admin.Post("/greetings", func(c *fiber.Ctx) error {
+		var rule greetings.Rule
+		if err := c.BodyParser(&rule); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		if rule.Tenant != "" && !knownTenant(rule.Tenant) {
+			return c.Status(400).JSON(fiber.Map{"error": "Unknown tenant"})
+		}
+		rule.ID = ""
+		saved, err := greetingRules.Put(rule)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		recordChange(c, "greeting", changelog.ActionCreate, saved.ID, nil, saved)
+		return c.Status(201).JSON(saved)
+	})
+
+	admin.Put("/greetings/:id", func(c *fiber.Ctx) error {
+		var rule greetings.Rule
+		if err := c.BodyParser(&rule); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		if rule.Tenant != "" && !knownTenant(rule.Tenant) {
+			return c.Status(400).JSON(fiber.Map{"error": "Unknown tenant"})
+		}
+		rule.ID = c.Params("id")
+		before, _ := greetingRules.Get(rule.ID)
+		saved, err := greetingRules.Put(rule)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		action := changelog.ActionUpdate
+		if before == nil {
+			action = changelog.ActionCreate
+		}
+		recordChange(c, "greeting", action, saved.ID, before, saved)
+		return c.JSON(saved)
+	})
+
+	admin.Delete("/greetings/:id", func(c *fiber.Ctx) error {
+		before, _ := greetingRules.Get(c.Params("id"))
+		if err := greetingRules.Delete(c.Params("id")); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		recordChange(c, "greeting", changelog.ActionDelete, c.Params("id"), before, nil)
+		return c.SendStatus(204)
+	})
+}
@@ -1,25 +1,240 @@
 import { useState, useEffect, useRef } from 'react';
+import { Peer } from './rtc';
+
+interface QuickReply {
+  id: string;
+  label: string;
+}
+
+interface LinkButton {
+  label: string;
+  url: string;
+}
+
+interface Card {
+  title: string;
+  subtitle?: string;
+  image_url?: string;
+  price?: string;
+  url?: string;
+  buttons?: LinkButton[];
+}
+
+interface ListItem {
+  title: string;
+  subtitle?: string;
+  image_url?: string;
+  url?: string;
+}
+
+interface FormField {
+  name: string;
+  label: string;
+  type: 'text' | 'email' | 'number' | 'textarea' | 'select';
+  required?: boolean;
+  placeholder?: string;
+  options?: string[];
+}
+
+interface MapPlace {
+  latitude: number;
+  longitude: number;
+  label?: string;
+  address?: string;
+  url?: string;
+}
+
+interface RichForm {
+  id: string;
+  fields: FormField[];
+  submit_label?: string;
+}
+
+// Rich content sent alongside a reply, see GET /rich/schema
+interface RichElement {
+  type: 'carousel' | 'card' | 'list' | 'form' | 'map' | 'buttons';
+  title?: string;
+  cards?: Card[];
+  card?: Card;
+  items?: ListItem[];
+  form?: RichForm;
+  map?: MapPlace;
+  buttons?: LinkButton[];
+}
+
+const renderMap = (place: MapPlace) => {
+  const d = 0.005;
+  const bbox = [place.longitude - d, place.latitude - d, place.longitude + d, place.latitude + d].join(',');
+  const link = place.url || `https://www.openstreetmap.org/?mlat=${place.latitude}&mlon=${place.longitude}#map=16/${place.latitude}/${place.longitude}`;
+  return (
+    <div className="flex-none w-64 bg-white border rounded-lg overflow-hidden text-left">
+      <iframe
+        title={place.label || 'Map'}
+        className="w-full h-40"
+        src={`https://www.openstreetmap.org/export/embed.html?bbox=${bbox}&marker=${place.latitude},${place.longitude}`}
+      />
+      <div className="p-2">
+        {place.label && <div className="font-semibold text-sm">{place.label}</div>}
+        {place.address && <div className="text-xs text-gray-600">{place.address}</div>}
+        <a href={link} target="_blank" rel="noopener noreferrer" className="text-sm text-blue-600">Open in maps</a>
+      </div>
+    </div>
+  );
+};
+
+const renderCard = (card: Card, key: number) => (
+  <div key={key} className="flex-none w-48 bg-white border rounded-lg overflow-hidden text-left">
+    {card.image_url && (
+      <img src={card.image_url} alt={card.title} className="w-full h-32 object-cover" />
+    )}
+    <div className="p-2">
+      <div className="font-semibold text-sm">
+        {card.url ? <a href={card.url} target="_blank" rel="noopener noreferrer">{card.title}</a> : card.title}
+      </div>
+      {card.subtitle && <div className="text-xs text-gray-600">{card.subtitle}</div>}
+      {card.price && <div className="text-sm text-gray-800 mt-1">{card.price}</div>}
+      {card.buttons?.map((button, k) => (
+        <a key={k} href={button.url} target="_blank" rel="noopener noreferrer"
+          className="block mt-2 text-center text-sm text-blue-600 border border-blue-500 rounded hover:bg-blue-50">
+          {button.label}
+        </a>
+      ))}
+    </div>
+  </div>
+);
+
+// A call an agent started with the visitor: co-browsing or voice
+interface Call {
+  id: string;
+  kind: 'cobrowse' | 'voice';
+  agent?: string;
+  state: 'requested' | 'accepted' | 'declined' | 'ended';
+}
+
+interface WidgetConfig {
+  title: string;
+  placeholder: string;
+  push_enabled: boolean;
+}
+
+interface HistoryMessage {
+  id?: string;
+  role: 'visitor' | 'bot' | 'agent' | 'system';
+  text: string;
+  time: string;
+}
 
 interface Message {
   text: string;
   isBot: boolean;
   timestamp: Date;
+  quickReplies?: QuickReply[];
+  rich?: RichElement[];
+  // Set while the bot is still streaming the reply
+  streaming?: boolean;
 }
 
-export default function Chat() {
+// Stable visitor ID so the backend can recognise returning visitors
+const getVisitorId = () => {
+  let id = localStorage.getItem('chatbot_visitor_id');
+  if (!id) {
+    id = crypto.randomUUID();
+    localStorage.setItem('chatbot_visitor_id', id);
+  }
+  return id;
+};
+
+// Describes the page the visitor is on, sent with each message so the
+// workflow can tailor its answers
+const pageContext = (metadata?: Record<string, unknown>) => ({
+  page_url: window.location.href,
+  referrer: document.referrer || undefined,
+  locale: navigator.language,
+  metadata,
+});
+
+const urlBase64ToUint8Array = (base64: string) => {
+  const padded = (base64 + '='.repeat((4 - (base64.length % 4)) % 4)).replace(/-/g, '+').replace(/_/g, '/');
+  return Uint8Array.from(atob(padded), c => c.charCodeAt(0));
+};
+
+const pushSupported = () =>
+  typeof window !== 'undefined' && 'serviceWorker' in navigator && 'PushManager' in window;
+
+interface ChatProps {
+  // Anything else the workflow should know, e.g. the product being viewed
+  metadata?: Record<string, unknown>;
+}
+
+export default function Chat({ metadata }: ChatProps = {}) {
   const [messages, setMessages] = useState<Message[]>([]);
   const [input, setInput] = useState('');
   const [isConnected, setIsConnected] = useState(false);
   const [isLoading, setIsLoading] = useState(false);
+  // Set by the server while it waits for the bot
+  const [isTyping, setIsTyping] = useState(false);
+  const [pushEnabled, setPushEnabled] = useState(false);
+  const [unread, setUnread] = useState(0);
   const ws = useRef<WebSocket | null>(null);
+  const [config, setConfig] = useState<WidgetConfig>({ title: 'Chatbot', placeholder: 'Type your message...', push_enabled: false });
+  const sessionId = useRef<string | null>(null);
+  // While an agent has the conversation there is no bot reply to wait for
+  const withAgent = useRef(false);
+  const peer = useRef<Peer | null>(null);
+  const remoteAudio = useRef<HTMLAudioElement>(null);
+  const [call, setCall] = useState<Call | null>(null);
+  const [queue, setQueue] = useState<{ position: number; estimated_wait_seconds: number } | null>(null);
+  const closedIdle = useRef(false);
+  // IDs of the messages shown, and the latest, to ask for what was missed
+  // while disconnected
+  const seenIds = useRef(new Set<string>());
+  const lastMessageId = useRef<string | null>(null);
   const messagesEndRef = useRef<HTMLDivElement>(null);
 
+  // Load config, greeting and recent history in one request. This runs
+  // before the WebSocket effect, so the stored session is resumed there too.
+  useEffect(() => {
+    sessionId.current = localStorage.getItem('chatbot_session_id');
+    // Referrer and time zone pick among the configured greetings
+    const params = new URLSearchParams({
+      visitor_id: getVisitorId(),
+      referrer: document.referrer,
+      tz: Intl.DateTimeFormat().resolvedOptions().timeZone,
+    });
+    if (sessionId.current) {
+      params.set('session_id', sessionId.current);
+    }
+    fetch(`http://localhost:8080/bootstrap?${params}`)
+      .then(response => response.json())
+      .then(data => {
+        setConfig(data.config);
+        setUnread(data.unread || 0);
+        (data.history || []).forEach((m: HistoryMessage) => seen(m.id));
+        const history: Message[] = (data.history || []).map((m: HistoryMessage) => ({
+          text: m.text,
+          isBot: m.role !== 'visitor',
+          timestamp: new Date(m.time),
+        }));
+        if (history.length === 0 && data.greeting) {
+          history.push({ text: data.greeting, isBot: true, timestamp: new Date() });
+        }
+        // Messages may already have arrived over the WebSocket
+        setMessages(prev => [...history, ...prev]);
+      })
+      .catch(error => console.error('Error loading chat:', error));
+  }, []);
+
   // Connect to WebSocket
   useEffect(() => {
     // Initialize WebSocket connection
     const connectWebSocket = () => {
       console.log('Attempting to connect to WebSocket...');
-      ws.current = new WebSocket('ws://localhost:8080/ws/chat');
+      // Resume the previous session if we have one
+      const params = new URLSearchParams({ visitor_id: getVisitorId() });
+      if (sessionId.current) {
+        params.set('session_id', sessionId.current);
+      }
+      ws.current = new WebSocket(`ws://localhost:8080/ws/chat?${params}`);
       
       ws.current.onopen = () => {
         console.log('Connected to chat server');
@@ -30,6 +245,12 @@ export default function Chat() {
         console.log('Disconnected from chat server:', event.code, event.reason);
         setIsConnected(false);
         
+        // Sessions closed for inactivity, or taken over by another tab,
+        // resume when the tab is next shown
+        if (closedIdle.current) {
+          return;
+        }
+
         // Attempt to reconnect after 3 seconds
         setTimeout(() => {
           if (document.visibilityState === 'visible') {
@@ -47,8 +268,53 @@ export default function Chat() {
         console.log('Received message:', event.data);
         try {
           const data = JSON.parse(event.data);
-          if (data.reply) {
-            addMessage(data.reply, true);
+          if (seen(data.message_id) && data.message_id && data.type !== 'ack') {
+            sendReceipt('delivered', data.message_id);
+          }
+          if (data.type === 'session') {
+            // Back in the same session: fetch replies sent while away
+            if (sessionId.current === data.session_id && lastMessageId.current) {
+              ws.current?.send(JSON.stringify({ type: 'history', after: lastMessageId.current }));
+            }
+            sessionId.current = data.session_id;
+            localStorage.setItem('chatbot_session_id', data.session_id);
+            closedIdle.current = false;
+            withAgent.current = data.status === 'with_agent';
+            if (withAgent.current) setIsLoading(false);
+          } else if (data.type === 'session_closed' || data.type === 'replaced') {
+            closedIdle.current = true;
+          } else if (data.type === 'system') {
+            addMessage(data.message, true);
+          } else if (data.type === 'unread') {
+            setUnread(data.count);
+            if (data.count > 0 && document.visibilityState === 'visible') {
+              markRead();
+            }
+          } else if (data.type === 'rtc_call') {
+            handleCall(data.call);
+          } else if (data.type === 'rtc_signal') {
+            peer.current?.receive(data.signal).catch(error => console.error('Error handling signal:', error));
+          } else if (data.type === 'queue') {
+            setQueue(data);
+          } else if (data.type === 'agent') {
+            setQueue(null);
+            addMessage(data.agent ? `${data.agent}: ${data.message}` : data.message, true);
+          } else if (data.type === 'reminder') {
+            addMessage(data.message, true);
+          } else if (data.type === 'notification') {
+            addMessage(data.from ? `${data.from}: ${data.message}` : data.message, true);
+          } else if (data.type === 'history') {
+            addMissed(data.messages);
+          } else if (data.type === 'ack') {
+            // The message reached the server
+          } else if (data.type === 'typing') {
+            setIsTyping(data.state);
+          } else if (data.type === 'chunk') {
+            appendChunk(data.delta);
+            setIsTyping(false);
+            setIsLoading(false);
+          } else if (data.reply) {
+            finishReply(data.reply, data.quick_replies, data.rich);
             setIsLoading(false);
           } else if (data.error) {
             addMessage(`Error: ${data.error}`, true);
@@ -68,6 +334,8 @@ export default function Chat() {
     const handleVisibilityChange = () => {
       if (document.visibilityState === 'visible' && (!ws.current || ws.current.readyState === WebSocket.CLOSED)) {
         connectWebSocket();
+      } else if (document.visibilityState === 'visible') {
+        markRead();
       }
     };
     
@@ -87,8 +355,162 @@ export default function Chat() {
     messagesEndRef.current?.scrollIntoView({ behavior: 'smooth' });
   }, [messages]);
 
-  const addMessage = (text: string, isBot: boolean) => {
-    setMessages(prev => [...prev, { text, isBot, timestamp: new Date() }]);
+  // Tell the server the visitor has seen everything so far
+  const markRead = () => {
+    if (ws.current?.readyState === WebSocket.OPEN) {
+      ws.current.send(JSON.stringify({ type: 'read', message_id: lastMessageId.current }));
+    }
+  };
+
+  // Tell the server a message reached the widget
+  const sendReceipt = (type: 'delivered', messageId: string) => {
+    if (ws.current?.readyState === WebSocket.OPEN) {
+      ws.current.send(JSON.stringify({ type, message_id: messageId }));
+    }
+  };
+
+  // Show the unread count in the tab title while the page is in the background
+  useEffect(() => {
+    const title = document.title.replace(/^\(\d+\) /, '');
+    document.title = unread > 0 ? `(${unread}) ${title}` : title;
+  }, [unread]);
+
+  // Note a message as shown, reporting whether it was new
+  const seen = (id?: string) => {
+    if (!id) return true;
+    if (seenIds.current.has(id)) return false;
+    seenIds.current.add(id);
+    lastMessageId.current = id;
+    return true;
+  };
+
+  // Show the messages that arrived while the connection was down
+  const addMissed = (missed: HistoryMessage[]) => {
+    const fresh = missed.filter(m => seen(m.id) && m.role !== 'visitor');
+    if (fresh.length === 0) return;
+    fresh.forEach(m => m.id && sendReceipt('delivered', m.id));
+    setMessages(prev => [...prev, ...fresh.map(m => ({ text: m.text, isBot: true, timestamp: new Date(m.time) }))]);
+    setIsLoading(false);
+  };
+
+  const addMessage = (text: string, isBot: boolean, quickReplies?: QuickReply[], rich?: RichElement[]) => {
+    setMessages(prev => [...prev, { text, isBot, timestamp: new Date(), quickReplies, rich }]);
+  };
+
+  // Grow the reply the bot is streaming, starting it on the first chunk
+  const appendChunk = (delta: string) => {
+    setMessages(prev => {
+      const last = prev[prev.length - 1];
+      if (last?.streaming) {
+        return [...prev.slice(0, -1), { ...last, text: last.text + delta }];
+      }
+      return [...prev, { text: delta, isBot: true, timestamp: new Date(), streaming: true }];
+    });
+  };
+
+  // The complete reply replaces whatever was streamed of it
+  const finishReply = (text: string, quickReplies?: QuickReply[], rich?: RichElement[]) => {
+    setMessages(prev => {
+      const done = { text, isBot: true, timestamp: new Date(), quickReplies, rich };
+      if (prev[prev.length - 1]?.streaming) {
+        return [...prev.slice(0, -1), done];
+      }
+      return [...prev, done];
+    });
+  };
+
+  // Ask for push notifications so agent replies reach the visitor after
+  // they leave the page
+  const enablePush = async () => {
+    try {
+      const config = await fetch('http://localhost:8080/push/config');
+      if (!config.ok) return;
+      const { public_key } = await config.json();
+      const registration = await navigator.serviceWorker.register('/sw.js');
+      const subscription = await registration.pushManager.subscribe({
+        userVisibleOnly: true,
+        applicationServerKey: urlBase64ToUint8Array(public_key),
+      });
+      await fetch('http://localhost:8080/push/subscriptions', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ visitor_id: getVisitorId(), subscription }),
+      });
+      setPushEnabled(true);
+    } catch (error) {
+      console.error('Error enabling push notifications:', error);
+    }
+  };
+
+  const sendQuickReply = (reply: QuickReply) => {
+    if (isLoading || ws.current?.readyState !== WebSocket.OPEN) return;
+    addMessage(reply.label, false);
+    setIsLoading(true);
+    ws.current.send(JSON.stringify({ message: reply.label, quick_reply_id: reply.id, client_id: crypto.randomUUID(), context: pageContext(metadata) }));
+  };
+
+  // Forms are answered with one message listing the values, one per line
+  const submitForm = (e: React.FormEvent<HTMLFormElement>, form: RichForm) => {
+    e.preventDefault();
+    if (isLoading || ws.current?.readyState !== WebSocket.OPEN) return;
+    const data = new FormData(e.currentTarget);
+    const text = form.fields.map(field => `${field.label}: ${data.get(field.name) ?? ''}`).join('\n');
+    addMessage(text, false);
+    setIsLoading(true);
+    ws.current.send(JSON.stringify({ message: text, client_id: crypto.randomUUID(), context: pageContext(metadata) }));
+  };
+
+  // Agents ask before viewing the visitor's screen or starting a voice
+  // call; nothing is shared unless the visitor agrees here and again in the
+  // browser's own prompt
+  const handleCall = async (next: Call) => {
+    setCall(next.state === 'requested' || next.state === 'accepted' ? next : null);
+    const voice = next.kind === 'voice';
+    if (next.state === 'requested') {
+      const who = next.agent || 'An agent';
+      const accepted = window.confirm(voice
+        ? `${who} would like to continue this conversation in a voice call. Start the call?`
+        : `${who} would like to see your screen to help you. Share your screen?`);
+      ws.current?.send(JSON.stringify({ type: 'rtc_consent', call_id: next.id, accepted }));
+    } else if (next.state === 'accepted' && !peer.current) {
+      const send = (signal: object) => ws.current?.send(JSON.stringify({ type: 'rtc_signal', call_id: next.id, signal }));
+      peer.current = new Peer(send, stream => {
+        if (remoteAudio.current) remoteAudio.current.srcObject = stream;
+      });
+      try {
+        const stream = voice
+          ? await navigator.mediaDevices.getUserMedia({ audio: true })
+          : await navigator.mediaDevices.getDisplayMedia({ video: true });
+        stream.getTracks()[0].onended = () => endCall(next.id);
+        await peer.current.offer(stream);
+      } catch (error) {
+        console.error(voice ? 'Error starting voice call:' : 'Error sharing screen:', error);
+        endCall(next.id);
+      }
+    } else if (next.state === 'ended' || next.state === 'declined') {
+      peer.current?.close();
+      peer.current = null;
+      if (remoteAudio.current) remoteAudio.current.srcObject = null;
+    }
+  };
+
+  const endCall = (id: string) => {
+    ws.current?.send(JSON.stringify({ type: 'rtc_end', call_id: id }));
+  };
+
+  // The browser asks the visitor for permission before handing over the
+  // position, so nothing is sent without their consent
+  const shareLocation = () => {
+    if (isLoading || ws.current?.readyState !== WebSocket.OPEN || !navigator.geolocation) return;
+    navigator.geolocation.getCurrentPosition(
+      position => {
+        const { latitude, longitude, accuracy } = position.coords;
+        addMessage('📍 Shared my location', false);
+        setIsLoading(true);
+        ws.current?.send(JSON.stringify({ type: 'location', latitude, longitude, accuracy }));
+      },
+      error => console.error('Error getting location:', error),
+    );
   };
 
   const handleSubmit = (e: React.FormEvent) => {
@@ -98,14 +520,14 @@ export default function Chat() {
     // Add user message
     const userMessage = input.trim();
     addMessage(userMessage, false);
-    setIsLoading(true);
+    setIsLoading(!withAgent.current);
     setInput(''); // Clear input immediately for better UX
     
     // Send message via WebSocket if connected
     if (isConnected && ws.current?.readyState === WebSocket.OPEN) {
       console.log('Sending message via WebSocket:', userMessage);
       try {
-        ws.current.send(JSON.stringify({ message: userMessage }));
+        ws.current.send(JSON.stringify({ message: userMessage, client_id: crypto.randomUUID(), context: pageContext(metadata) }));
       } catch (error) {
         console.error('Error sending WebSocket message:', error);
         // If WebSocket send fails, fall back to HTTP
@@ -123,7 +545,12 @@ export default function Chat() {
     fetch('http://localhost:8080/chat', {
       method: 'POST',
       headers: { 'Content-Type': 'application/json' },
-      body: JSON.stringify({ message: message }),
+      body: JSON.stringify({
+        message: message,
+        visitor_id: getVisitorId(),
+        session_id: sessionId.current ?? undefined,
+        context: pageContext(metadata),
+      }),
     })
       .then(response => {
         if (!response.ok) {
@@ -133,8 +560,20 @@ export default function Chat() {
       })
       .then(data => {
         console.log('Received HTTP response:', data);
+        if (data.session_id) {
+          sessionId.current = data.session_id;
+          localStorage.setItem('chatbot_session_id', data.session_id);
+        }
+        if (data.status === 'with_agent') {
+          // The agent answers over the WebSocket once it reconnects
+          setIsLoading(false);
+          return;
+        }
+        if (data.system) {
+          addMessage(data.system, true);
+        }
         if (data.reply) {
-          addMessage(data.reply, true);
+          addMessage(data.reply, true, undefined, data.rich);
         } else {
           addMessage('Received empty response from server.', true);
         }
@@ -150,10 +589,38 @@ export default function Chat() {
   return (
     <div className="flex flex-col h-[80vh] max-w-2xl mx-auto border rounded-lg overflow-hidden bg-white shadow-lg">
       <div className="p-4 bg-blue-600 text-white font-bold">
-        Chatbot
+        {config.title}
+        {unread > 0 && (
+          <span className="ml-2 px-2 py-0.5 text-xs rounded-full bg-red-500">{unread} new</span>
+        )}
         <span className={`ml-2 inline-block w-3 h-3 rounded-full ${isConnected ? 'bg-green-400' : 'bg-red-500'}`}></span>
+        {call?.state === 'accepted' && (
+          <button
+            type="button"
+            onClick={() => endCall(call.id)}
+            className="float-right ml-2 text-sm font-normal underline"
+          >
+            {call.kind === 'voice' ? 'Hang up' : 'Stop sharing'}
+          </button>
+        )}
+        <audio ref={remoteAudio} autoPlay />
+        {config.push_enabled && pushSupported() && !pushEnabled && (
+          <button
+            type="button"
+            onClick={enablePush}
+            className="float-right text-sm font-normal underline"
+          >
+            Notify me of replies
+          </button>
+        )}
       </div>
       
+      {queue && (
+        <div className="px-4 py-2 text-sm bg-yellow-50 border-b border-yellow-200 text-yellow-800">
+          Waiting for an agent: you are number {queue.position} in the queue
+          {queue.estimated_wait_seconds >= 60 && ` (about ${Math.round(queue.estimated_wait_seconds / 60)} min)`}
+        </div>
+      )}
       <div className="flex-1 p-4 overflow-y-auto bg-gray-50">
         {messages.length === 0 ? (
           <div className="text-center text-gray-500 mt-10">
@@ -169,13 +636,85 @@ export default function Chat() {
               >
                 {msg.text}
               </div>
+              {msg.rich?.map((element, i) => (
+                <div key={i} className="flex gap-3 mt-2 overflow-x-auto pb-1">
+                  {element.cards?.map(renderCard)}
+                  {element.card && renderCard(element.card, 0)}
+                  {element.map && renderMap(element.map)}
+                  {element.items && (
+                    <div className="flex-1 bg-white border rounded-lg text-left divide-y">
+                      {element.title && <div className="p-2 font-semibold text-sm">{element.title}</div>}
+                      {element.items.map((item, j) => (
+                        <div key={j} className="flex items-center gap-2 p-2">
+                          {item.image_url && <img src={item.image_url} alt={item.title} className="w-10 h-10 object-cover rounded" />}
+                          <div>
+                            <div className="text-sm">
+                              {item.url ? <a href={item.url} target="_blank" rel="noopener noreferrer" className="text-blue-600">{item.title}</a> : item.title}
+                            </div>
+                            {item.subtitle && <div className="text-xs text-gray-600">{item.subtitle}</div>}
+                          </div>
+                        </div>
+                      ))}
+                    </div>
+                  )}
+                  {element.form && (
+                    <form
+                      className="flex-1 bg-white border rounded-lg p-2 text-left space-y-2"
+                      onSubmit={e => submitForm(e, element.form!)}
+                    >
+                      {element.title && <div className="font-semibold text-sm">{element.title}</div>}
+                      {element.form.fields.map(field => (
+                        <label key={field.name} className="block text-sm">
+                          {field.label}
+                          {field.type === 'select' ? (
+                            <select name={field.name} required={field.required} className="block w-full p-1 border rounded">
+                              {field.options?.map(option => <option key={option}>{option}</option>)}
+                            </select>
+                          ) : field.type === 'textarea' ? (
+                            <textarea name={field.name} required={field.required} placeholder={field.placeholder}
+                              className="block w-full p-1 border rounded" />
+                          ) : (
+                            <input name={field.name} type={field.type} required={field.required} placeholder={field.placeholder}
+                              className="block w-full p-1 border rounded" />
+                          )}
+                        </label>
+                      ))}
+                      <button type="submit" disabled={isLoading}
+                        className="px-3 py-1 text-sm bg-blue-500 text-white rounded-lg hover:bg-blue-600 disabled:opacity-50">
+                        {element.form.submit_label || 'Send'}
+                      </button>
+                    </form>
+                  )}
+                  {element.buttons?.map((button, j) => (
+                    <a key={j} href={button.url} target="_blank" rel="noopener noreferrer"
+                      className="flex-none px-3 py-1 text-sm bg-blue-500 text-white rounded-lg hover:bg-blue-600">
+                      {button.label}
+                    </a>
+                  ))}
+                </div>
+              ))}
+              {msg.quickReplies && index === messages.length - 1 && (
+                <div className="flex flex-wrap gap-2 mt-2">
+                  {msg.quickReplies.map(reply => (
+                    <button
+                      key={reply.id}
+                      type="button"
+                      onClick={() => sendQuickReply(reply)}
+                      className="px-3 py-1 text-sm border border-blue-500 text-blue-600 rounded-full hover:bg-blue-50 disabled:opacity-50"
+                      disabled={isLoading}
+                    >
+                      {reply.label}
+                    </button>
+                  ))}
+                </div>
+              )}
               <div className="text-xs text-gray-500 mt-1">
                 {msg.timestamp.toLocaleTimeString()}
               </div>
             </div>
           ))
         )}
-        {isLoading && (
+        {isTyping && (
           <div className="text-left mb-4">
             <div className="inline-block p-3 rounded-lg bg-gray-200">
               <div className="flex space-x-2">
@@ -195,10 +734,19 @@ export default function Chat() {
             type="text"
             value={input}
             onChange={(e) => setInput(e.target.value)}
-            placeholder="Type your message..."
+            placeholder={config.placeholder}
             className="flex-1 p-2 border rounded-lg focus:outline-none focus:ring-2 focus:ring-blue-500"
             disabled={!isConnected || isLoading}
           />
+          <button
+            type="button"
+            onClick={shareLocation}
+            title="Share my location"
+            className="px-3 border rounded-lg hover:bg-gray-100 disabled:opacity-50"
+            disabled={!isConnected || isLoading}
+          >
+            📍
+          </button>
           <button 
             type="submit" 
             className="bg-blue-500 text-white px-4 py-2 rounded-lg hover:bg-blue-600 focus:outline-none focus:ring-2 focus:ring-blue-500 disabled:opacity-50"
@@ -0,0 +1,43 @@
+// Minimal WebRTC peer for calls brokered by the backend. Signals are
+// passed to send and arrive through receive; the backend relays them to
+// the agent once the visitor has consented.
+export class Peer {
+  private pc: RTCPeerConnection;
+  private stream?: MediaStream;
+
+  // onStream receives the agent's media, e.g. their voice in a call
+  constructor(private send: (signal: object) => void, onStream?: (stream: MediaStream) => void) {
+    this.pc = new RTCPeerConnection({ iceServers: [{ urls: 'stun:stun.l.google.com:19302' }] });
+    this.pc.onicecandidate = event => {
+      if (event.candidate) send({ candidate: event.candidate.toJSON() });
+    };
+    if (onStream) {
+      this.pc.ontrack = event => onStream(event.streams[0]);
+    }
+  }
+
+  // Start sending stream to the agent
+  async offer(stream: MediaStream) {
+    this.stream = stream;
+    stream.getTracks().forEach(track => this.pc.addTrack(track, stream));
+    await this.pc.setLocalDescription(await this.pc.createOffer());
+    this.send({ description: this.pc.localDescription });
+  }
+
+  async receive(signal: { description?: RTCSessionDescriptionInit; candidate?: RTCIceCandidateInit }) {
+    if (signal.description) {
+      await this.pc.setRemoteDescription(signal.description);
+      if (signal.description.type === 'offer') {
+        await this.pc.setLocalDescription(await this.pc.createAnswer());
+        this.send({ description: this.pc.localDescription });
+      }
+    } else if (signal.candidate) {
+      await this.pc.addIceCandidate(signal.candidate);
+    }
+  }
+
+  close() {
+    this.stream?.getTracks().forEach(track => track.stop());
+    this.pc.close();
+  }
+}